@@ -3,6 +3,13 @@ use std::process::Command;
 fn main() {
     println!("cargo:rerun-if-changed=static/css/input.css");
     println!("cargo:rerun-if-changed=templates/");
+    println!("cargo:rerun-if-changed=.git/HEAD");
+
+    // Build info for the footer and /api/version
+    let commit = output("git", &["rev-parse", "--short=12", "HEAD"]);
+    let date = output("date", &["-u", "+%Y-%m-%dT%H:%M:%SZ"]);
+    println!("cargo:rustc-env=MKUBE_GIT_COMMIT={}", commit);
+    println!("cargo:rustc-env=MKUBE_BUILD_DATE={}", date);
 
     let status = Command::new("npx")
         .args([
@@ -25,3 +32,15 @@ fn main() {
         }
     }
 }
+
+// Trimmed stdout of a command, or "unknown" when it fails.
+fn output(cmd: &str, args: &[&str]) -> String {
+    Command::new(cmd)
+        .args(args)
+        .output()
+        .ok()
+        .filter(|o| o.status.success())
+        .map(|o| String::from_utf8_lossy(&o.stdout).trim().to_string())
+        .filter(|s| !s.is_empty())
+        .unwrap_or_else(|| "unknown".to_string())
+}
@@ -0,0 +1,172 @@
+//! Client for the mkube console's own API under /api/console/v1alpha1:
+//! the cluster summary, alerts, the activity feed, app bundles and
+//! scheduling dry runs, with typed results.
+//!
+//! ```no_run
+//! # async fn example() -> Result<(), mkube_console_client::Error> {
+//! let console = mkube_console_client::Client::new("http://console.lan:8080")?;
+//! let summary = console.summary().await?;
+//! println!("{}/{} nodes ready", summary.healthy_nodes, summary.node_count);
+//! # Ok(())
+//! # }
+//! ```
+//!
+//! Every call is a future: drop it, or wrap it in a timeout, to cancel the
+//! request. The client itself gives up after its timeout (30s unless set
+//! with [`Client::with_timeout`]).
+
+mod types;
+
+pub use types::*;
+
+use reqwest::header::{HeaderMap, HeaderName, HeaderValue};
+use reqwest::{Method, RequestBuilder, StatusCode};
+use serde::de::DeserializeOwned;
+use std::time::Duration;
+
+/// The console API version this client speaks.
+pub const API_VERSION: &str = "v1alpha1";
+
+#[derive(Debug, thiserror::Error)]
+pub enum Error {
+    #[error("invalid console URL {0:?}")]
+    InvalidUrl(String),
+    #[error("invalid header: {0}")]
+    InvalidHeader(String),
+    #[error("request failed: {0}")]
+    Http(#[from] reqwest::Error),
+    /// The console answered with an error status.
+    #[error("{status}: {message}")]
+    Status { status: StatusCode, message: String },
+}
+
+#[derive(Debug, Clone)]
+pub struct Client {
+    base: String,
+    http: reqwest::Client,
+    headers: HeaderMap,
+    timeout: Duration,
+}
+
+impl Client {
+    /// A client for the console at `base_url`, e.g. http://console.lan:8080.
+    pub fn new(base_url: &str) -> Result<Self, Error> {
+        let base = base_url.trim_end_matches('/');
+        if !base.starts_with("http://") && !base.starts_with("https://") {
+            return Err(Error::InvalidUrl(base_url.to_string()));
+        }
+        Ok(Self {
+            base: base.to_string(),
+            http: reqwest::Client::new(),
+            headers: HeaderMap::new(),
+            timeout: Duration::from_secs(30),
+        })
+    }
+
+    /// Sends a bearer token with every request, for an authenticating
+    /// proxy in front of the console.
+    pub fn with_bearer_token(self, token: &str) -> Result<Self, Error> {
+        self.with_header("authorization", &format!("Bearer {}", token))
+    }
+
+    /// Sends a header with every request, e.g. the user header a trusted
+    /// proxy would set.
+    pub fn with_header(mut self, name: &str, value: &str) -> Result<Self, Error> {
+        let name = HeaderName::from_bytes(name.as_bytes()).map_err(|e| Error::InvalidHeader(e.to_string()))?;
+        let value = HeaderValue::from_str(value).map_err(|e| Error::InvalidHeader(e.to_string()))?;
+        self.headers.insert(name, value);
+        Ok(self)
+    }
+
+    pub fn with_timeout(mut self, timeout: Duration) -> Self {
+        self.timeout = timeout;
+        self
+    }
+
+    /// Node and pod counts with each node's health.
+    pub async fn summary(&self) -> Result<ClusterSummary, Error> {
+        self.send(self.request(Method::GET, "summary")).await
+    }
+
+    /// Firing and recently resolved alerts.
+    pub async fn alerts(&self) -> Result<Alerts, Error> {
+        self.send(self.request(Method::GET, "alerts")).await
+    }
+
+    /// A page of the activity feed: who created, deleted or changed what,
+    /// newest first. The console caps `limit` at 500.
+    pub async fn activity(&self, offset: usize, limit: usize) -> Result<ActivityPage, Error> {
+        let req = self
+            .request(Method::GET, "activity")
+            .query(&[("offset", offset), ("limit", limit)]);
+        self.send(req).await
+    }
+
+    /// Applied bundles, as "namespace/bundle", with the pods each owns.
+    pub async fn bundles(&self) -> Result<std::collections::BTreeMap<String, Vec<String>>, Error> {
+        self.send(self.request(Method::GET, "bundles")).await
+    }
+
+    /// Applies a bundle; with `prune`, pods it no longer has are deleted.
+    /// A bundle colliding with pods it doesn't own fails with 409 Conflict.
+    pub async fn apply_bundle(&self, bundle: &AppBundle, prune: bool) -> Result<ApplyResult, Error> {
+        let req = self
+            .request(Method::POST, "bundles/apply")
+            .query(&[("prune", prune)])
+            .json(bundle);
+        self.send(req).await
+    }
+
+    /// Dry run of [`Client::apply_bundle`]: the plan, and where the
+    /// scheduler would place each new pod, without changing anything.
+    pub async fn plan_bundle(&self, bundle: &AppBundle, prune: bool) -> Result<ApplyResult, Error> {
+        let req = self
+            .request(Method::POST, "bundles/apply")
+            .query(&[("prune", prune), ("dryRun", true)])
+            .json(bundle);
+        self.send(req).await
+    }
+
+    /// Recent placement decisions, optionally for one namespace or pod.
+    pub async fn decisions(&self, namespace: Option<&str>, pod: Option<&str>) -> Result<Vec<PlacementDecision>, Error> {
+        let mut query = Vec::new();
+        if let Some(ns) = namespace {
+            query.push(("namespace", ns));
+        }
+        if let Some(p) = pod {
+            query.push(("pod", p));
+        }
+        let req = self
+            .http
+            .get(format!("{}/api/v1/schedule/decisions", self.base))
+            .headers(self.headers.clone())
+            .timeout(self.timeout)
+            .query(&query);
+        self.send(req).await
+    }
+
+    fn request(&self, method: Method, path: &str) -> RequestBuilder {
+        self.http
+            .request(method, format!("{}/api/console/{}/{}", self.base, API_VERSION, path))
+            .headers(self.headers.clone())
+            .timeout(self.timeout)
+    }
+
+    async fn send<T: DeserializeOwned>(&self, req: RequestBuilder) -> Result<T, Error> {
+        let resp = req.send().await?;
+        let status = resp.status();
+        if status.is_success() {
+            return Ok(resp.json().await?);
+        }
+        // Errors come as plain text or a Kubernetes-style Status
+        let body = resp.text().await.unwrap_or_default();
+        let message = serde_json::from_str::<serde_json::Value>(&body)
+            .ok()
+            .and_then(|v| v.get("message")?.as_str().map(String::from))
+            .unwrap_or(body);
+        Err(Error::Status {
+            status,
+            message: message.trim().to_string(),
+        })
+    }
+}
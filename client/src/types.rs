@@ -0,0 +1,135 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+
+/// Node and pod counts with each node's health.
+#[derive(Debug, Clone, Default, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ClusterSummary {
+    pub node_count: usize,
+    pub healthy_nodes: usize,
+    pub pod_count: usize,
+    pub running_pods: usize,
+    #[serde(default)]
+    pub nodes: Vec<NodeSummary>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct NodeSummary {
+    pub name: String,
+    pub healthy: bool,
+    pub pod_count: usize,
+    pub last_ping: Option<DateTime<Utc>>,
+}
+
+/// Firing alerts and the most recently resolved ones.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct Alerts {
+    pub firing: Vec<Alert>,
+    pub resolved: Vec<Alert>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Alert {
+    pub key: String,
+    /// critical, warning or info.
+    pub severity: String,
+    pub summary: String,
+    pub description: String,
+    pub started_at: DateTime<Utc>,
+    #[serde(default)]
+    pub resolved_at: Option<DateTime<Utc>>,
+    #[serde(default)]
+    pub labels: BTreeMap<String, String>,
+}
+
+/// A page of the activity (audit) feed, newest first.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ActivityPage {
+    pub items: Vec<ActivityEntry>,
+    /// Entries the console holds in all.
+    pub total: usize,
+    pub offset: usize,
+    pub limit: usize,
+}
+
+/// One change to the cluster and who made it.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ActivityEntry {
+    pub id: u64,
+    pub at: DateTime<Utc>,
+    /// create, delete, scale, node-up, node-down, ...
+    pub action: String,
+    pub kind: String,
+    #[serde(default)]
+    pub namespace: String,
+    pub name: String,
+    /// "system" for changes the console observed.
+    pub user: String,
+    #[serde(default)]
+    pub message: String,
+}
+
+/// A named group of pods applied together. Pods are Kubernetes-style pod
+/// manifests.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct AppBundle {
+    pub name: String,
+    pub namespace: String,
+    #[serde(default)]
+    pub pods: Vec<serde_json::Value>,
+}
+
+/// What applying a bundle will do, or did, by pod name.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct BundlePlan {
+    pub bundle: String,
+    pub namespace: String,
+    pub add: Vec<String>,
+    /// Deleted and recreated because their definition changed.
+    pub change: Vec<String>,
+    pub delete: Vec<String>,
+    pub unchanged: Vec<String>,
+    /// Existing pods the bundle doesn't own; apply refuses while there are
+    /// any.
+    pub conflicts: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ApplyResult {
+    pub plan: BundlePlan,
+    pub applied: bool,
+    pub errors: Vec<String>,
+    /// On a dry run, where each new pod would be placed and why.
+    #[serde(default)]
+    pub placements: Vec<PlacementDecision>,
+}
+
+/// Where the scheduler put a pod, or would, and how every node scored.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PlacementDecision {
+    pub at: DateTime<Utc>,
+    pub namespace: String,
+    pub pod: String,
+    /// least-pods or weighted, or node-name when the pod named its node.
+    pub strategy: String,
+    /// None when no node could take the pod.
+    pub node: Option<String>,
+    pub reason: String,
+    pub candidates: Vec<Candidate>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Candidate {
+    pub node: String,
+    /// Lowest wins; None when ruled out.
+    pub score: Option<f64>,
+    pub excluded: Option<String>,
+    pub avoided: bool,
+    pub unpreferred: bool,
+}
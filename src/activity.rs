@@ -0,0 +1,198 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::{HashMap, VecDeque};
+use std::io::Write;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::sync::broadcast;
+use tracing::warn;
+
+use crate::crypto::Sealer;
+
+const MAX_ENTRIES: usize = 1000;
+const MAX_RECENT: usize = 10;
+
+/// One change to the cluster: a create, delete or scale of a workload, or a
+/// node going up or down.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ActivityEntry {
+    pub id: u64,
+    pub at: DateTime<Utc>,
+    /// create, delete, scale, node-up or node-down.
+    pub action: String,
+    /// Resource kind, as in favorites: pod, node or app.
+    pub kind: String,
+    #[serde(default)]
+    pub namespace: String,
+    pub name: String,
+    /// Who made the change; "system" for changes observed by the console.
+    pub user: String,
+    #[serde(default)]
+    pub message: String,
+}
+
+/// Global cluster activity feed, newest first, kept in memory and
+/// optionally appended to a JSON-lines file under the data dir.
+pub struct ActivityLog {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<ActivityState>,
+    /// Every entry as it is recorded, for caches and other listeners.
+    changes: broadcast::Sender<ActivityEntry>,
+}
+
+struct ActivityState {
+    next_id: u64,
+    entries: VecDeque<ActivityEntry>,
+}
+
+impl ActivityLog {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Self {
+        let mut entries = VecDeque::new();
+        if let Some(ref p) = path {
+            if let Ok(data) = std::fs::read_to_string(p) {
+                for line in data.lines() {
+                    let parsed = sealer
+                        .open(line)
+                        .and_then(|l| {
+                            serde_json::from_str::<ActivityEntry>(&l).map_err(|e| e.to_string())
+                        });
+                    match parsed {
+                        Ok(e) => entries.push_front(e),
+                        Err(e) => warn!("skipping bad activity line in {}: {}", p.display(), e),
+                    }
+                }
+                entries.truncate(MAX_ENTRIES);
+            }
+        }
+        let next_id = entries.iter().map(|e| e.id).max().unwrap_or(0) + 1;
+
+        let (changes, _) = broadcast::channel(64);
+
+        Self {
+            path,
+            sealer,
+            state: Mutex::new(ActivityState { next_id, entries }),
+            changes,
+        }
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<ActivityEntry> {
+        self.changes.subscribe()
+    }
+
+    pub fn record(
+        &self,
+        action: &str,
+        kind: &str,
+        namespace: &str,
+        name: &str,
+        user: &str,
+        message: &str,
+    ) {
+        let mut state = self.state.lock().unwrap();
+        let entry = ActivityEntry {
+            id: state.next_id,
+            at: Utc::now(),
+            action: action.to_string(),
+            kind: kind.to_string(),
+            namespace: namespace.to_string(),
+            name: name.to_string(),
+            user: user.to_string(),
+            message: message.to_string(),
+        };
+        state.next_id += 1;
+
+        if let Some(ref p) = self.path {
+            if let Err(e) = append_line(p, &entry, &self.sealer) {
+                warn!("writing activity log {}: {}", p.display(), e);
+            }
+        }
+
+        let _ = self.changes.send(entry.clone());
+        state.entries.push_front(entry);
+        state.entries.truncate(MAX_ENTRIES);
+    }
+
+    /// A page of the feed, newest first, and the total number of entries.
+    pub fn page(&self, offset: usize, limit: usize) -> (Vec<ActivityEntry>, usize) {
+        let state = self.state.lock().unwrap();
+        let items = state.entries.iter().skip(offset).take(limit).cloned().collect();
+        (items, state.entries.len())
+    }
+
+    /// Entries about one resource, newest first.
+    pub fn for_object(&self, kind: &str, namespace: &str, name: &str) -> Vec<ActivityEntry> {
+        let state = self.state.lock().unwrap();
+        state
+            .entries
+            .iter()
+            .filter(|e| e.kind == kind && e.namespace == namespace && e.name == name)
+            .cloned()
+            .collect()
+    }
+}
+
+/// A resource a user opened, for the dashboard's "Recently viewed" list.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct RecentView {
+    pub kind: String,
+    pub namespace: String,
+    pub name: String,
+    pub viewed_at: DateTime<Utc>,
+}
+
+/// Per-user recently viewed resources. In memory only; it is a convenience
+/// list, not history worth persisting.
+pub struct RecentViews {
+    state: Mutex<HashMap<String, VecDeque<RecentView>>>,
+}
+
+impl RecentViews {
+    pub fn new() -> Self {
+        Self {
+            state: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Moves the resource to the front of the user's list.
+    pub fn touch(&self, user: &str, kind: &str, namespace: &str, name: &str) {
+        let mut state = self.state.lock().unwrap();
+        let list = state.entry(user.to_string()).or_default();
+        list.retain(|v| !(v.kind == kind && v.namespace == namespace && v.name == name));
+        list.push_front(RecentView {
+            kind: kind.to_string(),
+            namespace: namespace.to_string(),
+            name: name.to_string(),
+            viewed_at: Utc::now(),
+        });
+        list.truncate(MAX_RECENT);
+    }
+
+    pub fn list(&self, user: &str) -> Vec<RecentView> {
+        self.state
+            .lock()
+            .unwrap()
+            .get(user)
+            .map(|l| l.iter().cloned().collect())
+            .unwrap_or_default()
+    }
+}
+
+fn append_line(
+    path: &PathBuf,
+    entry: &ActivityEntry,
+    sealer: &Sealer,
+) -> Result<(), Box<dyn std::error::Error>> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    let mut f = std::fs::OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(path)?;
+    writeln!(f, "{}", sealer.seal(&serde_json::to_string(entry)?))?;
+    Ok(())
+}
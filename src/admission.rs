@@ -0,0 +1,174 @@
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use crate::config::{Config, EnvInjectionConfig, ResourceDefaults};
+use crate::helpers::{parse_cpu_millis, parse_memory_bytes};
+use crate::models::k8s::{EnvVar, Pod};
+use crate::secrets::SecretResolver;
+
+/// Annotation listing what admission filled in, e.g. "app: requests.cpu".
+pub const DEFAULTED_ANNOTATION: &str = "console.mkube.io/defaulted-resources";
+
+/// Annotation listing injected variables per container, e.g. "app: TZ".
+pub const INJECTED_ENV_ANNOTATION: &str = "console.mkube.io/injected-env";
+
+/// Admission for every pod the console creates, through the API or a
+/// workload controller: resource defaults, then env injection, then secret
+/// references. Controllers admit each pod as they create it, so templates
+/// keep their references and pods pick up the current policies and
+/// secrets.
+pub struct Admission {
+    config: Arc<Config>,
+    secrets: Arc<SecretResolver>,
+}
+
+impl Admission {
+    pub fn new(config: Arc<Config>, secrets: Arc<SecretResolver>) -> Self {
+        Self { config, secrets }
+    }
+
+    pub async fn admit_pod(&self, pod: &mut Pod) -> Result<(), String> {
+        apply_resource_defaults(pod, &self.config.resource_defaults);
+        inject_env(pod, &self.config.env_injection);
+        self.secrets
+            .resolve_pod(pod)
+            .await
+            .map(|_| ())
+            .map_err(|errors| format!("unresolved secret references: {}", errors.join("; ")))
+    }
+}
+
+/// Fills in missing container requests and limits from the namespace's
+/// defaults (or the "*" entry). As in Kubernetes, a container with a limit
+/// but no request gets a request equal to its limit, and a default limit is
+/// skipped where it would fall below the container's own request. Returns
+/// what was changed, which is also recorded on the pod as an annotation.
+pub fn apply_resource_defaults(
+    pod: &mut Pod,
+    defaults: &HashMap<String, ResourceDefaults>,
+) -> Vec<String> {
+    let Some(d) = defaults
+        .get(&pod.metadata.namespace)
+        .or_else(|| defaults.get("*"))
+    else {
+        return Vec::new();
+    };
+
+    let mut changes = Vec::new();
+    for c in &mut pod.spec.containers {
+        let r = &mut c.resources;
+        for (res, limit) in &d.limits {
+            if r.limits.contains_key(res) {
+                continue;
+            }
+            let below_request = r
+                .requests
+                .get(res)
+                .is_some_and(|req| exceeds(res, req, limit));
+            if !below_request {
+                r.limits.insert(res.clone(), limit.clone());
+                changes.push(format!("{}: limits.{}", c.name, res));
+            }
+        }
+        for (res, request) in &d.requests {
+            if r.requests.contains_key(res) {
+                continue;
+            }
+            let value = match r.limits.get(res) {
+                Some(limit) if exceeds(res, request, limit) => limit.clone(),
+                _ => request.clone(),
+            };
+            r.requests.insert(res.clone(), value);
+            changes.push(format!("{}: requests.{}", c.name, res));
+        }
+    }
+
+    if !changes.is_empty() {
+        pod.metadata
+            .annotations
+            .get_or_insert_with(HashMap::new)
+            .insert(DEFAULTED_ANNOTATION.to_string(), changes.join(", "));
+    }
+    changes
+}
+
+/// Adds the cluster-wide environment variables to every container that
+/// doesn't already set them, unless the pod's namespace opts out. Returns
+/// what was injected, also recorded on the pod as an annotation.
+pub fn inject_env(pod: &mut Pod, cfg: &EnvInjectionConfig) -> Vec<String> {
+    if cfg.vars.is_empty() || cfg.exclude_namespaces.contains(&pod.metadata.namespace) {
+        return Vec::new();
+    }
+
+    let mut changes = Vec::new();
+    for c in &mut pod.spec.containers {
+        for (name, value) in &cfg.vars {
+            if c.env.iter().any(|e| &e.name == name) {
+                continue;
+            }
+            c.env.push(EnvVar {
+                name: name.clone(),
+                value: value.clone(),
+                value_from: None,
+            });
+            changes.push(format!("{}: {}", c.name, name));
+        }
+    }
+
+    if !changes.is_empty() {
+        pod.metadata
+            .annotations
+            .get_or_insert_with(HashMap::new)
+            .insert(INJECTED_ENV_ANNOTATION.to_string(), changes.join(", "));
+    }
+    changes
+}
+
+/// Pods the policies would change, and how, had they been submitted now.
+/// Pods admitted earlier already carry what the current policies filled in,
+/// so against the live cluster this shows what a new policy adds.
+pub fn dry_run(
+    pods: &[Pod],
+    defaults: &HashMap<String, ResourceDefaults>,
+    env: &EnvInjectionConfig,
+) -> Vec<(String, Vec<String>)> {
+    pods.iter()
+        .filter_map(|p| {
+            let mut pod = p.clone();
+            let mut changes = apply_resource_defaults(&mut pod, defaults);
+            changes.extend(inject_env(&mut pod, env).into_iter().map(|c| format!("env {}", c)));
+            if changes.is_empty() {
+                return None;
+            }
+            Some((format!("{}/{}", p.metadata.namespace, p.metadata.name), changes))
+        })
+        .collect()
+}
+
+/// Container/variable pairs a pod's injection annotation names.
+pub fn injected_env(pod: &Pod) -> Vec<(String, String)> {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(INJECTED_ENV_ANNOTATION))
+        .map(|v| {
+            v.split(", ")
+                .filter_map(|e| e.split_once(": "))
+                .map(|(c, n)| (c.to_string(), n.to_string()))
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+// Whether quantity `a` is larger than `b`; unparseable quantities never are.
+fn exceeds(res: &str, a: &str, b: &str) -> bool {
+    let parse = |q: &str| match res {
+        "cpu" => parse_cpu_millis(q),
+        "memory" | "ephemeral-storage" => parse_memory_bytes(q),
+        _ => q.trim().parse().ok(),
+    };
+    match (parse(a), parse(b)) {
+        (Some(a), Some(b)) => a > b,
+        _ => false,
+    }
+}
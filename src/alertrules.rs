@@ -0,0 +1,77 @@
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::config::AlertRuleConfig;
+use crate::controllers::alert_rules;
+use crate::crypto::{self, Sealer};
+
+/// Alert rules created through the API rather than written in the config,
+/// e.g. by an infrastructure-as-code tool. The alert rule engine evaluates
+/// them next to the config's; a config rule of the same name wins.
+/// Persisted under the data dir when there is one.
+pub struct AlertRuleStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    rules: Mutex<BTreeMap<String, AlertRuleConfig>>,
+}
+
+impl AlertRuleStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let rules = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            rules: Mutex::new(rules),
+        })
+    }
+
+    pub fn list(&self) -> Vec<AlertRuleConfig> {
+        self.rules.lock().unwrap().values().cloned().collect()
+    }
+
+    pub fn get(&self, name: &str) -> Option<AlertRuleConfig> {
+        self.rules.lock().unwrap().get(name).cloned()
+    }
+
+    /// Creates or replaces a rule once it compiles. Returns whether it was
+    /// created.
+    pub fn put(&self, rule: AlertRuleConfig) -> Result<bool, String> {
+        alert_rules::compile(std::slice::from_ref(&rule))?;
+        let mut rules = self.rules.lock().unwrap();
+        let created = rules.insert(rule.name.clone(), rule).is_none();
+        self.save(&rules);
+        Ok(created)
+    }
+
+    /// Replaces every rule at once, if they all compile.
+    pub fn replace(&self, rules: Vec<AlertRuleConfig>) -> Result<(), String> {
+        alert_rules::compile(&rules)?;
+        let rules = rules.into_iter().map(|r| (r.name.clone(), r)).collect();
+        let mut current = self.rules.lock().unwrap();
+        *current = rules;
+        self.save(&current);
+        Ok(())
+    }
+
+    pub fn remove(&self, name: &str) -> Option<AlertRuleConfig> {
+        let mut rules = self.rules.lock().unwrap();
+        let removed = rules.remove(name);
+        if removed.is_some() {
+            self.save(&rules);
+        }
+        removed
+    }
+
+    fn save(&self, rules: &BTreeMap<String, AlertRuleConfig>) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(rules)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing alert rules {}: {}", p.display(), e);
+            }
+        }
+    }
+}
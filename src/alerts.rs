@@ -0,0 +1,110 @@
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::collections::{BTreeMap, VecDeque};
+use std::sync::Mutex;
+use tokio::sync::broadcast;
+use tracing::{info, warn};
+
+const MAX_RESOLVED: usize = 100;
+
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Alert {
+    pub key: String,
+    pub severity: String,
+    pub summary: String,
+    pub description: String,
+    pub started_at: DateTime<Utc>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub resolved_at: Option<DateTime<Utc>>,
+    /// Labels from the alert rule that raised it, for routing and grouping.
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    pub labels: BTreeMap<String, String>,
+}
+
+/// Console-side alert state. Alerts are keyed so repeated raises of the same
+/// condition update a single firing alert instead of piling up.
+pub struct AlertManager {
+    state: Mutex<AlertState>,
+    /// Newly firing alerts, for notifiers.
+    fired: broadcast::Sender<Alert>,
+}
+
+#[derive(Default)]
+struct AlertState {
+    firing: BTreeMap<String, Alert>,
+    resolved: VecDeque<Alert>,
+}
+
+impl AlertManager {
+    pub fn new() -> Self {
+        let (fired, _) = broadcast::channel(64);
+        Self {
+            state: Mutex::new(AlertState::default()),
+            fired,
+        }
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<Alert> {
+        self.fired.subscribe()
+    }
+
+    /// Raises (or refreshes) an alert. Returns true if it was not already firing.
+    pub fn raise(&self, key: &str, severity: &str, summary: &str, description: &str) -> bool {
+        self.raise_labeled(key, severity, summary, description, &BTreeMap::new())
+    }
+
+    pub fn raise_labeled(
+        &self,
+        key: &str,
+        severity: &str,
+        summary: &str,
+        description: &str,
+        labels: &BTreeMap<String, String>,
+    ) -> bool {
+        let mut state = self.state.lock().unwrap();
+        if let Some(a) = state.firing.get_mut(key) {
+            a.severity = severity.to_string();
+            a.summary = summary.to_string();
+            a.description = description.to_string();
+            a.labels = labels.clone();
+            return false;
+        }
+        warn!("alert firing: {} ({})", summary, key);
+        let alert = Alert {
+            key: key.to_string(),
+            severity: severity.to_string(),
+            summary: summary.to_string(),
+            description: description.to_string(),
+            started_at: Utc::now(),
+            resolved_at: None,
+            labels: labels.clone(),
+        };
+        state.firing.insert(key.to_string(), alert.clone());
+        // No receivers just means no notifiers are configured
+        let _ = self.fired.send(alert);
+        true
+    }
+
+    pub fn resolve(&self, key: &str) {
+        let mut state = self.state.lock().unwrap();
+        if let Some(mut a) = state.firing.remove(key) {
+            info!("alert resolved: {} ({})", a.summary, key);
+            a.resolved_at = Some(Utc::now());
+            state.resolved.push_front(a);
+            state.resolved.truncate(MAX_RESOLVED);
+        }
+    }
+
+    pub fn is_firing(&self, key: &str) -> bool {
+        self.state.lock().unwrap().firing.contains_key(key)
+    }
+
+    pub fn firing(&self) -> Vec<Alert> {
+        self.state.lock().unwrap().firing.values().cloned().collect()
+    }
+
+    pub fn recently_resolved(&self) -> Vec<Alert> {
+        self.state.lock().unwrap().resolved.iter().cloned().collect()
+    }
+}
@@ -0,0 +1,120 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::VecDeque;
+use std::io::Write;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::crypto::Sealer;
+use crate::models::k8s::ContainerStateTerminated;
+
+const MAX_ENTRIES: usize = 200;
+
+/// Evidence captured about a workload at the time something went wrong.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ArchiveEntry {
+    pub id: u64,
+    pub kind: String,
+    pub captured_at: DateTime<Utc>,
+    pub namespace: String,
+    pub pod: String,
+    pub container: String,
+    pub node: String,
+    pub restart_count: i32,
+    #[serde(default)]
+    pub termination: Option<ContainerStateTerminated>,
+    #[serde(default)]
+    pub logs: String,
+}
+
+/// History archive of captured entries, kept in memory and optionally
+/// appended to a JSON-lines file under the console data directory.
+pub struct HistoryArchive {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<ArchiveState>,
+}
+
+struct ArchiveState {
+    next_id: u64,
+    entries: VecDeque<ArchiveEntry>,
+}
+
+impl HistoryArchive {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Self {
+        let mut entries = VecDeque::new();
+        if let Some(ref p) = path {
+            if let Ok(data) = std::fs::read_to_string(p) {
+                for line in data.lines() {
+                    let parsed = sealer
+                        .open(line)
+                        .and_then(|l| {
+                            serde_json::from_str::<ArchiveEntry>(&l).map_err(|e| e.to_string())
+                        });
+                    match parsed {
+                        Ok(e) => entries.push_front(e),
+                        Err(e) => warn!("skipping bad archive line in {}: {}", p.display(), e),
+                    }
+                }
+                entries.truncate(MAX_ENTRIES);
+            }
+        }
+        let next_id = entries.iter().map(|e| e.id).max().unwrap_or(0) + 1;
+
+        Self {
+            path,
+            sealer,
+            state: Mutex::new(ArchiveState { next_id, entries }),
+        }
+    }
+
+    /// Stores an entry, assigning its id. Returns the stored entry's id.
+    pub fn record(&self, mut entry: ArchiveEntry) -> u64 {
+        let mut state = self.state.lock().unwrap();
+        entry.id = state.next_id;
+        state.next_id += 1;
+
+        if let Some(ref p) = self.path {
+            if let Err(e) = append_line(p, &entry, &self.sealer) {
+                warn!("writing archive {}: {}", p.display(), e);
+            }
+        }
+
+        let id = entry.id;
+        state.entries.push_front(entry);
+        state.entries.truncate(MAX_ENTRIES);
+        id
+    }
+
+    pub fn list(&self) -> Vec<ArchiveEntry> {
+        self.state.lock().unwrap().entries.iter().cloned().collect()
+    }
+
+    pub fn get(&self, id: u64) -> Option<ArchiveEntry> {
+        self.state
+            .lock()
+            .unwrap()
+            .entries
+            .iter()
+            .find(|e| e.id == id)
+            .cloned()
+    }
+}
+
+fn append_line(
+    path: &PathBuf,
+    entry: &ArchiveEntry,
+    sealer: &Sealer,
+) -> Result<(), Box<dyn std::error::Error>> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    let mut f = std::fs::OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(path)?;
+    writeln!(f, "{}", sealer.seal(&serde_json::to_string(entry)?))?;
+    Ok(())
+}
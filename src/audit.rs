@@ -0,0 +1,271 @@
+use reqwest::Client;
+use std::io::Write;
+use std::sync::Arc;
+use tokio::io::AsyncWriteExt;
+use tokio::net::{TcpStream, UdpSocket, UnixDatagram};
+use tokio::sync::{broadcast, mpsc};
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::{ActivityEntry, ActivityLog};
+use crate::config::{AuditSinkConfig, FileAuditSink, HttpAuditSink, SyslogAuditSink};
+
+/// Records an HTTP sink holds while its endpoint is down; older ones are
+/// dropped past this.
+const MAX_BUFFERED: usize = 10_000;
+
+/// Ships activity records to one of config.audit_sinks as they are
+/// recorded. Every sink has its own subscription to the activity log, so a
+/// slow or unreachable one only holds up itself.
+pub struct AuditSink {
+    cfg: AuditSinkConfig,
+    cluster: String,
+}
+
+impl AuditSink {
+    pub fn new(cfg: AuditSinkConfig, cluster: String) -> Self {
+        Self { cfg, cluster }
+    }
+
+    fn describe(&self) -> String {
+        match self.cfg {
+            AuditSinkConfig::File(ref f) => format!("file {}", f.path),
+            AuditSinkConfig::Syslog(ref s) => format!("syslog {}", s.address),
+            AuditSinkConfig::Http(ref h) => format!("http {}", h.url),
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, activity: Arc<ActivityLog>, shutdown: tokio::sync::watch::Receiver<()>) {
+        let records = activity.subscribe();
+        info!("audit sink {} started", self.describe());
+        match self.cfg {
+            AuditSinkConfig::File(ref f) => self.run_file(f, records, shutdown).await,
+            AuditSinkConfig::Syslog(ref s) => self.run_syslog(s, records, shutdown).await,
+            AuditSinkConfig::Http(ref h) => self.run_http(h, records, shutdown).await,
+        }
+        info!("audit sink {} shutting down", self.describe());
+    }
+
+    async fn run_file(
+        &self,
+        cfg: &FileAuditSink,
+        mut records: broadcast::Receiver<ActivityEntry>,
+        mut shutdown: tokio::sync::watch::Receiver<()>,
+    ) {
+        let name = self.describe();
+        loop {
+            let entry = tokio::select! {
+                r = next(&mut records, &name) => match r {
+                    Some(e) => e,
+                    None => return,
+                },
+                _ = shutdown.changed() => return,
+            };
+            let result = std::fs::OpenOptions::new()
+                .create(true)
+                .append(true)
+                .open(&cfg.path)
+                .and_then(|mut f| writeln!(f, "{}", serde_json::to_string(&entry).unwrap_or_default()));
+            if let Err(e) = result {
+                warn!("audit sink file {}: {}", cfg.path, e);
+            }
+        }
+    }
+
+    async fn run_syslog(
+        &self,
+        cfg: &SyslogAuditSink,
+        mut records: broadcast::Receiver<ActivityEntry>,
+        mut shutdown: tokio::sync::watch::Receiver<()>,
+    ) {
+        let name = self.describe();
+        let mut conn: Option<SyslogConn> = None;
+        loop {
+            let entry = tokio::select! {
+                r = next(&mut records, &name) => match r {
+                    Some(e) => e,
+                    None => return,
+                },
+                _ = shutdown.changed() => return,
+            };
+            let message = syslog_message(cfg.facility, &self.cluster, &entry);
+            // One reconnect per record; a sink that stays down drops records
+            // rather than falling behind
+            for _ in 0..2 {
+                if conn.is_none() {
+                    match SyslogConn::connect(&cfg.address).await {
+                        Ok(c) => conn = Some(c),
+                        Err(e) => {
+                            warn!("audit sink syslog {}: {}", cfg.address, e);
+                            break;
+                        }
+                    }
+                }
+                let Some(ref mut c) = conn else { break };
+                match c.send(&message).await {
+                    Ok(()) => break,
+                    Err(e) => {
+                        warn!("audit sink syslog {}: {}", cfg.address, e);
+                        conn = None;
+                    }
+                }
+            }
+        }
+    }
+
+    async fn run_http(
+        &self,
+        cfg: &HttpAuditSink,
+        mut records: broadcast::Receiver<ActivityEntry>,
+        mut shutdown: tokio::sync::watch::Receiver<()>,
+    ) {
+        // Records are queued here as they arrive so a slow endpoint doesn't
+        // make this sink lag behind the activity log
+        let (queue, mut queued) = mpsc::channel::<ActivityEntry>(MAX_BUFFERED);
+        let name = self.describe();
+        let mut receiver_shutdown = shutdown.clone();
+        tokio::spawn(async move {
+            loop {
+                let entry = tokio::select! {
+                    r = next(&mut records, &name) => match r {
+                        Some(e) => e,
+                        None => return,
+                    },
+                    _ = receiver_shutdown.changed() => return,
+                };
+                if queue.try_send(entry).is_err() {
+                    warn!("audit sink {}: {} records queued, dropping", name, MAX_BUFFERED);
+                }
+            }
+        });
+
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        let mut interval = time::interval(Duration::from_secs(cfg.flush_secs));
+        let mut batch = Vec::new();
+        loop {
+            tokio::select! {
+                entry = queued.recv() => {
+                    let Some(entry) = entry else { return };
+                    batch.push(entry);
+                    if batch.len() >= cfg.batch_size {
+                        self.post(&http, cfg, std::mem::take(&mut batch)).await;
+                    }
+                }
+                _ = interval.tick() => {
+                    if !batch.is_empty() {
+                        self.post(&http, cfg, std::mem::take(&mut batch)).await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    // Best effort; the runtime may stop before this is sent
+                    while let Ok(entry) = queued.try_recv() {
+                        batch.push(entry);
+                    }
+                    if !batch.is_empty() {
+                        self.post(&http, cfg, batch).await;
+                    }
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn post(&self, http: &Client, cfg: &HttpAuditSink, batch: Vec<ActivityEntry>) {
+        let mut backoff = Duration::from_secs(1);
+        for attempt in 1..=cfg.max_attempts {
+            let mut req = http.post(&cfg.url).json(&batch);
+            if let Some(ref token) = cfg.bearer_token {
+                req = req.bearer_auth(token);
+            }
+            let error = match req.send().await {
+                Ok(r) if r.status().is_success() => return,
+                Ok(r) => r.status().to_string(),
+                Err(e) => e.to_string(),
+            };
+            if attempt == cfg.max_attempts {
+                warn!(
+                    "audit sink http {}: dropping {} records after {} attempts: {}",
+                    cfg.url,
+                    batch.len(),
+                    attempt,
+                    error
+                );
+                return;
+            }
+            warn!(
+                "audit sink http {}: {}; retrying in {}s",
+                cfg.url,
+                error,
+                backoff.as_secs()
+            );
+            time::sleep(backoff).await;
+            backoff = (backoff * 2).min(Duration::from_secs(60));
+        }
+    }
+}
+
+// The next record, or None once the activity log is gone. Records missed
+// by falling behind are logged; they are still in the activity log itself.
+async fn next(records: &mut broadcast::Receiver<ActivityEntry>, sink: &str) -> Option<ActivityEntry> {
+    loop {
+        match records.recv().await {
+            Ok(e) => return Some(e),
+            Err(broadcast::error::RecvError::Lagged(n)) => warn!("audit sink {}: missed {} records", sink, n),
+            Err(broadcast::error::RecvError::Closed) => return None,
+        }
+    }
+}
+
+// An RFC 5424 message with the record as JSON, at severity notice. The
+// cluster name stands in for the hostname so records from several
+// consoles can be told apart.
+fn syslog_message(facility: u8, cluster: &str, entry: &ActivityEntry) -> String {
+    let pri = u16::from(facility) * 8 + 5;
+    let host: String = cluster
+        .chars()
+        .map(|c| if c.is_ascii_graphic() { c } else { '-' })
+        .collect();
+    format!(
+        "<{}>1 {} {} mkube-console - audit - {}",
+        pri,
+        entry.at.to_rfc3339_opts(chrono::SecondsFormat::Millis, true),
+        host,
+        serde_json::to_string(entry).unwrap_or_default()
+    )
+}
+
+enum SyslogConn {
+    Udp(UdpSocket),
+    Tcp(TcpStream),
+    Unix(UnixDatagram, String),
+}
+
+impl SyslogConn {
+    async fn connect(address: &str) -> std::io::Result<Self> {
+        if let Some(addr) = address.strip_prefix("udp://") {
+            let socket = UdpSocket::bind("0.0.0.0:0").await?;
+            socket.connect(addr).await?;
+            Ok(Self::Udp(socket))
+        } else if let Some(addr) = address.strip_prefix("tcp://") {
+            Ok(Self::Tcp(TcpStream::connect(addr).await?))
+        } else {
+            let path = address.trim_start_matches("unix://").to_string();
+            Ok(Self::Unix(UnixDatagram::unbound()?, path))
+        }
+    }
+
+    async fn send(&mut self, message: &str) -> std::io::Result<()> {
+        match self {
+            Self::Udp(socket) => socket.send(message.as_bytes()).await.map(|_| ()),
+            // Octet counting framing (RFC 6587)
+            Self::Tcp(stream) => {
+                let framed = format!("{} {}", message.len(), message);
+                stream.write_all(framed.as_bytes()).await
+            }
+            Self::Unix(socket, path) => socket.send_to(message.as_bytes(), path.as_str()).await.map(|_| ()),
+        }
+    }
+}
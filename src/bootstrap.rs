@@ -0,0 +1,208 @@
+use chrono::{DateTime, Duration, Utc};
+use serde::{Deserialize, Serialize};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::claims::{new_token, token_hash};
+use crate::crypto::{self, Sealer};
+
+/// Longest a bootstrap token may live.
+pub const MAX_TTL_SECS: i64 = 7 * 24 * 3600;
+pub const DEFAULT_TTL_SECS: i64 = 3600;
+
+// Bootstrap tokens let nodes the console can't reach by a known address
+// (behind NAT, on dynamic IPs) register themselves. An admin mints a token
+// and hands it to the node's agent, which POSTs its name and a reachable
+// address to /api/console/v1alpha1/nodes/register with the token as a
+// bearer. The console answers with the token it will call the node with
+// and an agent token the node uses to report a new address later. The
+// address must still be reachable from the console: a tunnel, a port
+// forward or a VPN address for nodes behind NAT.
+
+/// A token new nodes present to register themselves. Only its hash is
+/// kept; the token itself is shown once, when it is minted.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct BootstrapToken {
+    pub id: String,
+    #[serde(default)]
+    pub description: String,
+    pub created_by: String,
+    pub created_at: DateTime<Utc>,
+    pub expires_at: DateTime<Utc>,
+    /// Registrations left; the token is dropped when it reaches zero.
+    pub uses_left: u32,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub hash: String,
+    /// Uses held by registrations in flight (BootstrapTokens::reserve).
+    #[serde(skip)]
+    pub reserved: u32,
+}
+
+/// Outstanding bootstrap tokens, persisted under the data dir. Expired and
+/// used-up tokens are dropped whenever the store is touched.
+pub struct BootstrapTokens {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    tokens: Mutex<Vec<BootstrapToken>>,
+}
+
+impl BootstrapTokens {
+    /// Fails when the store can't be opened rather than start empty and
+    /// overwrite it.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let tokens = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            tokens: Mutex::new(tokens),
+        })
+    }
+
+    /// Live tokens, without their hashes.
+    pub fn list(&self) -> Vec<BootstrapToken> {
+        let mut tokens = self.tokens.lock().unwrap();
+        if prune(&mut tokens) {
+            self.save(&tokens);
+        }
+        tokens.iter().cloned().map(without_hash).collect()
+    }
+
+    /// Mints a token valid for `ttl_secs` and `uses` registrations, under
+    /// `id` if the caller chose one. Returns its record and the token, which
+    /// is not stored and can't be shown again.
+    pub fn mint(
+        &self,
+        id: Option<&str>,
+        description: &str,
+        ttl_secs: i64,
+        uses: u32,
+        user: &str,
+    ) -> Result<(BootstrapToken, String), String> {
+        if self.path.is_none() {
+            return Err("bootstrap tokens need data_dir, so registered nodes survive a restart".to_string());
+        }
+        if !(1..=MAX_TTL_SECS).contains(&ttl_secs) {
+            return Err(format!("ttlSecs must be between 1 and {}", MAX_TTL_SECS));
+        }
+        if uses == 0 {
+            return Err("uses must be at least 1".to_string());
+        }
+        let id = match id {
+            Some(id) if valid_id(id) => id.to_string(),
+            Some(id) => {
+                return Err(format!(
+                    "token id {:?} must be 1-63 lowercase letters, digits and dashes",
+                    id
+                ));
+            }
+            None => new_token()[..8].to_string(),
+        };
+        let token = new_token();
+        let now = Utc::now();
+        let record = BootstrapToken {
+            id,
+            description: description.to_string(),
+            created_by: user.to_string(),
+            created_at: now,
+            expires_at: now + Duration::seconds(ttl_secs),
+            uses_left: uses,
+            hash: token_hash(&token),
+            reserved: 0,
+        };
+        let mut tokens = self.tokens.lock().unwrap();
+        prune(&mut tokens);
+        if tokens.iter().any(|t| t.id == record.id) {
+            return Err(format!("bootstrap token {} already exists", record.id));
+        }
+        tokens.push(record.clone());
+        self.save(&tokens);
+        Ok((without_hash(record), token))
+    }
+
+    /// A live token's record, without its hash.
+    pub fn get(&self, id: &str) -> Option<BootstrapToken> {
+        self.list().into_iter().find(|t| t.id == id)
+    }
+
+    pub fn revoke(&self, id: &str) -> bool {
+        let mut tokens = self.tokens.lock().unwrap();
+        let before = tokens.len();
+        tokens.retain(|t| t.id != id);
+        let removed = tokens.len() != before;
+        if removed {
+            self.save(&tokens);
+        }
+        removed
+    }
+
+    /// Holds one registration of `token` if it is live and has one that
+    /// isn't already held, and returns the token's id. The caller then
+    /// either redeems the use or releases it, so concurrent registrations
+    /// can't share the last one.
+    pub fn reserve(&self, token: &str) -> Option<String> {
+        let hash = token_hash(token);
+        let now = Utc::now();
+        let mut tokens = self.tokens.lock().unwrap();
+        let t = tokens
+            .iter_mut()
+            .find(|t| t.hash == hash && t.expires_at > now && t.uses_left > t.reserved)?;
+        t.reserved += 1;
+        Some(t.id.clone())
+    }
+
+    /// Uses up the registration reserved on token `id`.
+    pub fn redeem(&self, id: &str) {
+        let mut tokens = self.tokens.lock().unwrap();
+        if let Some(t) = tokens.iter_mut().find(|t| t.id == id) {
+            t.reserved = t.reserved.saturating_sub(1);
+            t.uses_left = t.uses_left.saturating_sub(1);
+        }
+        prune(&mut tokens);
+        self.save(&tokens);
+    }
+
+    /// Gives back the registration reserved on token `id`, e.g. when the
+    /// registration failed and may be retried.
+    pub fn release(&self, id: &str) {
+        let mut tokens = self.tokens.lock().unwrap();
+        if let Some(t) = tokens.iter_mut().find(|t| t.id == id) {
+            t.reserved = t.reserved.saturating_sub(1);
+        }
+    }
+
+    fn save(&self, tokens: &[BootstrapToken]) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(tokens)
+                .map_err(|e| e.to_string())
+                .and_then(|data| {
+                    std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string())
+                });
+            if let Err(e) = result {
+                warn!("writing bootstrap tokens {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+// Drops expired and used-up tokens. Returns whether any were dropped.
+fn prune(tokens: &mut Vec<BootstrapToken>) -> bool {
+    let now = Utc::now();
+    let before = tokens.len();
+    tokens.retain(|t| t.expires_at > now && t.uses_left > 0);
+    tokens.len() != before
+}
+
+fn valid_id(id: &str) -> bool {
+    !id.is_empty()
+        && id.len() <= 63
+        && id
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-')
+}
+
+fn without_hash(mut t: BootstrapToken) -> BootstrapToken {
+    t.hash.clear();
+    t
+}
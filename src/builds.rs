@@ -0,0 +1,142 @@
+use std::collections::HashMap;
+
+use crate::clients::CAPABILITY_LABEL_PREFIX;
+use crate::models::k8s::{Container, EnvVar, Job, Node};
+
+// Image builds for the Build page. A build is a job running BuildKit's
+// daemonless buildctl on a node with the "build" capability: it fetches
+// the context from Git or a tarball URL, builds the Dockerfile natively
+// for that node's architecture and pushes the result, to the console's
+// registry unless the image names another. The job controller runs it
+// and keeps its pod for the logs; the build deployer (controllers/
+// builds.rs) rolls a deployment onto the image once it succeeds.
+
+/// Label marking a job as a build.
+pub const BUILD_LABEL: &str = "mkube.io/build";
+pub const CONTEXT_ANNOTATION: &str = "mkube.io/build-context";
+pub const IMAGE_ANNOTATION: &str = "mkube.io/build-image";
+/// Deployment ("namespace/name") to roll onto the image once built.
+pub const DEPLOY_ANNOTATION: &str = "mkube.io/build-deploy";
+
+/// Capability (config nodes[].capabilities) of nodes that can build.
+pub const BUILD_CAPABILITY: &str = "build";
+
+/// What the Build page submits.
+#[derive(Debug, Clone, Default)]
+pub struct BuildRequest {
+    pub namespace: String,
+    pub name: String,
+    /// Git URL (ref after #, e.g. https://host/repo.git#main) or tarball
+    /// URL.
+    pub context: String,
+    /// Dockerfile path within the context.
+    pub dockerfile: String,
+    pub image: String,
+    pub node: String,
+    /// Deployment in the same namespace to update once built.
+    pub deploy: Option<String>,
+}
+
+/// The job that runs a build. `registry_url` is the console's registry,
+/// which images without a registry host are pushed to.
+pub fn build_job(req: &BuildRequest, builder_image: &str, registry_url: &str) -> Result<Job, String> {
+    let context = req.context.trim();
+    if !["https://", "http://", "git://"].iter().any(|p| context.starts_with(p)) {
+        return Err("the context must be an http(s):// or git:// URL".to_string());
+    }
+    if req.image.trim().is_empty() {
+        return Err("an image to push is required".to_string());
+    }
+    if req.node.is_empty() {
+        return Err("choose a node to build on".to_string());
+    }
+    let image = target_image(req.image.trim(), registry_url);
+    let dockerfile = match req.dockerfile.trim() {
+        "" => "Dockerfile",
+        path => path,
+    };
+    let mut output = format!("type=image,name={},push=true", image);
+    if registry_url.starts_with("http://") && image.starts_with(registry_host(registry_url)) {
+        output.push_str(",registry.insecure=true");
+    }
+
+    let mut job = Job::default();
+    job.metadata.name = req.name.trim().to_string();
+    job.metadata.labels = Some(HashMap::from([(BUILD_LABEL.to_string(), "true".to_string())]));
+    let mut annotations = HashMap::from([
+        (CONTEXT_ANNOTATION.to_string(), context.to_string()),
+        (IMAGE_ANNOTATION.to_string(), image.clone()),
+    ]);
+    if let Some(ref d) = req.deploy {
+        annotations.insert(DEPLOY_ANNOTATION.to_string(), format!("{}/{}", req.namespace, d));
+    }
+    job.metadata.annotations = Some(annotations);
+    // A failed build is worth reading, not retrying
+    job.spec.backoff_limit = 0;
+    job.spec.template.spec.node_name = req.node.clone();
+    job.spec.template.spec.containers = vec![Container {
+        name: "build".to_string(),
+        image: builder_image.to_string(),
+        command: vec!["buildctl-daemonless.sh".to_string()],
+        args: vec![
+            "build".to_string(),
+            "--progress=plain".to_string(),
+            "--frontend=dockerfile.v0".to_string(),
+            format!("--opt=context={}", context),
+            format!("--opt=filename={}", dockerfile),
+            format!("--output={}", output),
+        ],
+        // Rootless BuildKit without a privileged container
+        env: vec![EnvVar {
+            name: "BUILDKITD_FLAGS".to_string(),
+            value: "--oci-worker-no-process-sandbox".to_string(),
+            value_from: None,
+        }],
+        ..Default::default()
+    }];
+    Ok(job)
+}
+
+/// Whether a job is a build.
+pub fn is_build(job: &Job) -> bool {
+    job.metadata
+        .labels
+        .as_ref()
+        .is_some_and(|l| l.get(BUILD_LABEL).is_some_and(|v| v == "true"))
+}
+
+/// A build's annotation, or "" when it has none.
+pub fn annotation<'a>(job: &'a Job, key: &str) -> &'a str {
+    job.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(key))
+        .map(String::as_str)
+        .unwrap_or_default()
+}
+
+/// Whether a node can build images.
+pub fn is_builder(node: &Node) -> bool {
+    let label = format!("{}{}", CAPABILITY_LABEL_PREFIX, BUILD_CAPABILITY);
+    node.metadata
+        .labels
+        .as_ref()
+        .is_some_and(|l| l.get(&label).is_some_and(|v| v == "true"))
+}
+
+// An image without a registry host goes to the console's registry.
+fn target_image(image: &str, registry_url: &str) -> String {
+    let first = image.split('/').next().unwrap_or_default();
+    let has_host = image.contains('/') && (first.contains('.') || first.contains(':') || first == "localhost");
+    if has_host || registry_url.is_empty() {
+        return image.to_string();
+    }
+    format!("{}/{}", registry_host(registry_url), image)
+}
+
+fn registry_host(registry_url: &str) -> &str {
+    registry_url
+        .trim_start_matches("http://")
+        .trim_start_matches("https://")
+        .trim_end_matches('/')
+}
@@ -0,0 +1,162 @@
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap, HashSet};
+
+use crate::models::k8s::Pod;
+
+/// Label naming the bundle that owns a pod. Pods are only pruned or
+/// replaced by the bundle they carry this label for.
+pub const BUNDLE_LABEL: &str = "console.mkube.io/bundle";
+
+/// Annotation holding a hash of the pod as it appeared in the bundle, so a
+/// re-apply can tell changed pods from ones that only picked up admission
+/// defaults or a node assignment.
+pub const SPEC_HASH_ANNOTATION: &str = "console.mkube.io/bundle-hash";
+
+/// A named set of pods applied to one namespace together.
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct AppBundle {
+    pub name: String,
+    pub namespace: String,
+    #[serde(default)]
+    pub pods: Vec<Pod>,
+}
+
+/// What applying a bundle will do (or did), by pod name.
+#[derive(Debug, Clone, Serialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct BundlePlan {
+    pub bundle: String,
+    pub namespace: String,
+    pub add: Vec<String>,
+    /// Pods whose bundle definition changed; they are deleted and recreated.
+    pub change: Vec<String>,
+    /// Pods the previous apply created that the bundle no longer has.
+    pub delete: Vec<String>,
+    pub unchanged: Vec<String>,
+    /// Pods the bundle wants that already exist without being owned by it.
+    /// Apply refuses while there are any.
+    pub conflicts: Vec<String>,
+}
+
+impl BundlePlan {
+    pub fn is_noop(&self) -> bool {
+        self.add.is_empty() && self.change.is_empty() && self.delete.is_empty()
+    }
+}
+
+/// Checks the bundle and returns its pods ready to create: namespaced,
+/// labelled with the bundle and stamped with their hash.
+pub fn prepare(bundle: &AppBundle) -> Result<Vec<Pod>, String> {
+    if bundle.name.is_empty() || bundle.namespace.is_empty() {
+        return Err("bundle needs a name and a namespace".to_string());
+    }
+    let mut seen = HashSet::new();
+    let mut pods = Vec::new();
+    for p in &bundle.pods {
+        if p.metadata.name.is_empty() {
+            return Err("every pod in the bundle needs a name".to_string());
+        }
+        if !seen.insert(p.metadata.name.as_str()) {
+            return Err(format!("pod {:?} appears twice in the bundle", p.metadata.name));
+        }
+        let mut pod = p.clone();
+        pod.metadata.namespace = bundle.namespace.clone();
+        pod.status = Default::default();
+        let hash = spec_hash(&pod);
+        pod.metadata
+            .labels
+            .get_or_insert_with(HashMap::new)
+            .insert(BUNDLE_LABEL.to_string(), bundle.name.clone());
+        pod.metadata
+            .annotations
+            .get_or_insert_with(HashMap::new)
+            .insert(SPEC_HASH_ANNOTATION.to_string(), hash);
+        pods.push(pod);
+    }
+    Ok(pods)
+}
+
+/// Diffs prepared pods against the pods currently in the bundle's
+/// namespace. Without `prune`, pods dropped from the bundle are left alone.
+pub fn plan(bundle: &AppBundle, desired: &[Pod], existing: &[Pod], prune: bool) -> BundlePlan {
+    let mut plan = BundlePlan {
+        bundle: bundle.name.clone(),
+        namespace: bundle.namespace.clone(),
+        ..Default::default()
+    };
+    let current: HashMap<&str, &Pod> = existing
+        .iter()
+        .filter(|p| p.metadata.namespace == bundle.namespace)
+        .map(|p| (p.metadata.name.as_str(), p))
+        .collect();
+
+    for pod in desired {
+        let name = pod.metadata.name.clone();
+        match current.get(name.as_str()) {
+            None => plan.add.push(name),
+            Some(p) if owner(p) != Some(bundle.name.as_str()) => plan.conflicts.push(name),
+            Some(p) if annotation(p, SPEC_HASH_ANNOTATION) == annotation(pod, SPEC_HASH_ANNOTATION) => {
+                plan.unchanged.push(name)
+            }
+            Some(_) => plan.change.push(name),
+        }
+    }
+
+    if prune {
+        let wanted: HashSet<&str> = desired.iter().map(|p| p.metadata.name.as_str()).collect();
+        plan.delete = current
+            .values()
+            .filter(|p| owner(p) == Some(bundle.name.as_str()))
+            .map(|p| p.metadata.name.as_str())
+            .filter(|n| !wanted.contains(n))
+            .map(String::from)
+            .collect();
+    }
+
+    for list in [&mut plan.add, &mut plan.change, &mut plan.delete, &mut plan.unchanged, &mut plan.conflicts] {
+        list.sort();
+    }
+    plan
+}
+
+/// Bundle that owns a pod, if any.
+pub fn owner(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .labels
+        .as_ref()
+        .and_then(|l| l.get(BUNDLE_LABEL))
+        .map(|s| s.as_str())
+}
+
+/// Each pod's name and the hash it was applied with: what a bundle's ETag
+/// is computed over, so a re-apply of the same bundle keeps it.
+pub fn fingerprint<'a>(pods: impl IntoIterator<Item = &'a Pod>) -> BTreeMap<String, String> {
+    pods.into_iter()
+        .map(|p| {
+            let hash = annotation(p, SPEC_HASH_ANNOTATION).unwrap_or_default();
+            (p.metadata.name.clone(), hash.to_string())
+        })
+        .collect()
+}
+
+fn annotation<'a>(pod: &'a Pod, key: &str) -> Option<&'a str> {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(key))
+        .map(|s| s.as_str())
+}
+
+// Hashes the pod as written in the bundle (labels, annotations and spec),
+// before any ownership metadata is added. Going through serde_json::Value
+// sorts map keys, so equal pods hash the same whatever HashMap order.
+fn spec_hash(pod: &Pod) -> String {
+    let doc = serde_json::json!({
+        "labels": pod.metadata.labels,
+        "annotations": pod.metadata.annotations,
+        "spec": pod.spec,
+    });
+    let digest = ring::digest::digest(&ring::digest::SHA256, doc.to_string().as_bytes());
+    digest.as_ref()[..8].iter().map(|b| format!("{:02x}", b)).collect()
+}
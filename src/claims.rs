@@ -0,0 +1,556 @@
+use base64::Engine;
+use base64::engine::general_purpose::URL_SAFE_NO_PAD;
+use chrono::{DateTime, Utc};
+use reqwest::{Client, StatusCode};
+use ring::rand::{SecureRandom, SystemRandom};
+use serde::{Deserialize, Serialize};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use tracing::{info, warn};
+
+use crate::clients::NodeClient;
+use crate::clients::aggregator::Aggregator;
+use crate::clients::tunnel::TunnelHub;
+use crate::config::{NodeAuth, NodeDef};
+use crate::crypto::{self, Sealer};
+use crate::desired::DesiredNode;
+
+// Claiming registers a freshly flashed node without editing config.yaml.
+// An unclaimed node shows a claim code on its display or status page,
+// usually as a QR code of
+//
+//     mkube-claim://<host>:<port>?code=<code>[&tls=1]
+//
+// and serves GET /claim with its name. The console then POSTs the code, a
+// bearer token it generated and its own URL to /claim; the node checks
+// the code, keeps the token for authenticating the console from then on,
+// and stops accepting claims. The console saves the node with its token
+// and starts polling it.
+//
+// Nodes the console can't find by address (behind NAT, on dynamic IPs)
+// register themselves instead, with a bootstrap token an admin minted
+// (see bootstrap.rs). They get the same kind of record here, plus an
+// agent token for re-registering when their address changes. Nodes that
+// can't be reached at all register in push mode and dial in over a tunnel
+// (see clients/tunnel.rs).
+
+/// Scheme of the payload in a node's claim QR code.
+pub const CLAIM_SCHEME: &str = "mkube-claim://";
+
+/// A node registered by claiming rather than listed in the config.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ClaimedNode {
+    pub name: String,
+    pub address: String,
+    /// Bearer token provisioned on the node; never sent back to clients.
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub token: String,
+    /// Hash of the token a self-registered node re-registers with.
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub agent_token_hash: String,
+    /// Push mode: the node dials in and is reached over its tunnel rather
+    /// than at `address`.
+    #[serde(default)]
+    pub tunnel: bool,
+    pub claimed_by: String,
+    pub claimed_at: DateTime<Utc>,
+}
+
+impl ClaimedNode {
+    pub fn node_def(&self) -> NodeDef {
+        NodeDef {
+            name: self.name.clone(),
+            address: self.address.clone(),
+            auth: Some(NodeAuth {
+                bearer_token: Some(self.token.clone()),
+                ..Default::default()
+            }),
+            ..Default::default()
+        }
+    }
+
+    /// Copy without tokens, for listing.
+    pub fn redacted(&self) -> Self {
+        Self {
+            token: String::new(),
+            agent_token_hash: String::new(),
+            ..self.clone()
+        }
+    }
+}
+
+/// What an unclaimed node says about itself at GET /claim.
+#[derive(Debug, Deserialize)]
+struct ClaimInfo {
+    name: String,
+    /// Address the node wants to be reached at, if not the one it was
+    /// found at (e.g. a static IP it moves to once claimed).
+    #[serde(default)]
+    address: Option<String>,
+    #[serde(default)]
+    claimed: bool,
+}
+
+#[derive(Serialize)]
+struct ClaimBody<'a> {
+    code: &'a str,
+    token: &'a str,
+    console: &'a str,
+}
+
+/// Claimed and self-registered nodes, persisted (sealed, as they hold
+/// tokens) under the data dir.
+pub struct ClaimStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    tunnels: Arc<TunnelHub>,
+    http: Client,
+    nodes: Mutex<Vec<ClaimedNode>>,
+}
+
+impl ClaimStore {
+    /// Fails when the store can't be opened, e.g. after its key was
+    /// dropped, rather than forget every claimed node.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>, tunnels: Arc<TunnelHub>) -> Result<Self, String> {
+        let nodes = crypto::load_sealed(path.as_deref(), &sealer)?;
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        Ok(Self {
+            path,
+            sealer,
+            tunnels,
+            http,
+            nodes: Mutex::new(nodes),
+        })
+    }
+
+    pub fn list(&self) -> Vec<ClaimedNode> {
+        self.nodes.lock().unwrap().clone()
+    }
+
+    /// A client for `node`, through the tunnel gateway in push mode.
+    pub fn client(&self, node: &ClaimedNode) -> NodeClient {
+        if !node.tunnel {
+            return NodeClient::new(&node.node_def());
+        }
+        let mut def = node.node_def();
+        def.address = self.tunnels.address(&node.name);
+        NodeClient::tunneled(&def, self.tunnels.clone())
+    }
+
+    /// Claims the node at `address` with the code it shows, and adds it to
+    /// the aggregator. `console` is the URL the node should know this
+    /// console by.
+    pub async fn claim(
+        &self,
+        aggregator: &Aggregator,
+        address: &str,
+        code: &str,
+        console: &str,
+        user: &str,
+    ) -> Result<ClaimedNode, String> {
+        if self.path.is_none() {
+            return Err("claiming nodes needs data_dir, so their tokens survive a restart".to_string());
+        }
+        let address = normalize_address(address)?;
+        let code = code.trim();
+        if code.is_empty() {
+            return Err("enter the claim code the node shows".to_string());
+        }
+
+        let info: ClaimInfo = self
+            .http
+            .get(format!("{}/claim", address))
+            .send()
+            .await
+            .and_then(|r| r.error_for_status())
+            .map_err(|e| format!("reaching {}: {}", address, e))?
+            .json()
+            .await
+            .map_err(|e| format!("{} is not an mkube node waiting to be claimed: {}", address, e))?;
+        if info.claimed {
+            return Err(format!("node {} has already been claimed", info.name));
+        }
+        let taken = aggregator.snapshot_clients().await.iter().any(|c| c.name == info.name)
+            || self.nodes.lock().unwrap().iter().any(|n| n.name == info.name);
+        if info.name.is_empty() || taken {
+            return Err(format!("a node named {:?} is already registered", info.name));
+        }
+
+        let token = new_token();
+        let resp = self
+            .http
+            .post(format!("{}/claim", address))
+            .json(&ClaimBody {
+                code,
+                token: &token,
+                console,
+            })
+            .send()
+            .await
+            .map_err(|e| format!("claiming {}: {}", info.name, e))?;
+        match resp.status() {
+            s if s.is_success() => {}
+            StatusCode::FORBIDDEN | StatusCode::UNAUTHORIZED => {
+                return Err("the claim code is wrong or has expired".to_string());
+            }
+            StatusCode::CONFLICT => return Err(format!("node {} has already been claimed", info.name)),
+            s => return Err(format!("claiming {}: node returned {}", info.name, s)),
+        }
+
+        let node = ClaimedNode {
+            name: info.name,
+            address: match info.address {
+                Some(a) => normalize_address(&a)?,
+                None => address,
+            },
+            token,
+            agent_token_hash: String::new(),
+            tunnel: false,
+            claimed_by: user.to_string(),
+            claimed_at: Utc::now(),
+        };
+        {
+            let mut nodes = self.nodes.lock().unwrap();
+            nodes.push(node.clone());
+            self.save(&nodes);
+        }
+        aggregator.add_client(self.client(&node)).await;
+        info!("claimed node {} at {} for {}", node.name, node.address, user);
+        Ok(node)
+    }
+
+    /// Registers a node that announced itself, at `address` or in push
+    /// mode when `tunnel` is set. Returns the record, whose token the node
+    /// must accept from the console, and the agent token the node
+    /// re-registers and connects with.
+    pub async fn register(
+        &self,
+        aggregator: &Aggregator,
+        name: &str,
+        address: &str,
+        tunnel: bool,
+        registered_by: &str,
+    ) -> Result<(ClaimedNode, String), String> {
+        if self.path.is_none() {
+            return Err("registering nodes needs data_dir, so their tokens survive a restart".to_string());
+        }
+        let address = if tunnel { String::new() } else { normalize_address(address)? };
+        if name.is_empty() || name.contains('/') {
+            return Err(format!("invalid node name {:?}", name));
+        }
+        let taken = aggregator.snapshot_clients().await.iter().any(|c| c.name == name)
+            || self.nodes.lock().unwrap().iter().any(|n| n.name == name);
+        if taken {
+            return Err(format!("a node named {:?} is already registered", name));
+        }
+
+        let agent_token = new_token();
+        let node = ClaimedNode {
+            name: name.to_string(),
+            address,
+            token: new_token(),
+            agent_token_hash: token_hash(&agent_token),
+            tunnel,
+            claimed_by: registered_by.to_string(),
+            claimed_at: Utc::now(),
+        };
+        {
+            let mut nodes = self.nodes.lock().unwrap();
+            nodes.push(node.clone());
+            self.save(&nodes);
+        }
+        aggregator.add_client(self.client(&node)).await;
+        if tunnel {
+            info!("node {} registered itself in push mode", node.name);
+        } else {
+            info!("node {} registered itself at {}", node.name, node.address);
+        }
+        Ok((node, agent_token))
+    }
+
+    /// Name of the self-registered node holding `agent_token`.
+    pub fn agent_node(&self, agent_token: &str) -> Option<String> {
+        let hash = token_hash(agent_token);
+        self.nodes
+            .lock()
+            .unwrap()
+            .iter()
+            .find(|n| !n.agent_token_hash.is_empty() && n.agent_token_hash == hash)
+            .map(|n| n.name.clone())
+    }
+
+    /// Moves a registered node to a new address, or to push mode. Returns
+    /// the record and whether anything changed.
+    pub async fn update_address(
+        &self,
+        aggregator: &Aggregator,
+        name: &str,
+        address: &str,
+        tunnel: bool,
+    ) -> Result<(ClaimedNode, bool), String> {
+        let address = if tunnel { String::new() } else { normalize_address(address)? };
+        let node = {
+            let mut nodes = self.nodes.lock().unwrap();
+            let Some(n) = nodes.iter_mut().find(|n| n.name == name) else {
+                return Err(format!("node {} is not registered", name));
+            };
+            if n.address == address && n.tunnel == tunnel {
+                return Ok((n.clone(), false));
+            }
+            n.address = address;
+            n.tunnel = tunnel;
+            let node = n.clone();
+            self.save(&nodes);
+            node
+        };
+        aggregator.replace_client(self.client(&node)).await;
+        if tunnel {
+            info!("node {} switched to push mode", node.name);
+        } else {
+            info!("node {} moved to {}", node.name, node.address);
+        }
+        Ok((node, true))
+    }
+
+    /// Adds or updates a node declared by name, address and the bearer
+    /// token it was provisioned with, as infrastructure-as-code tools do
+    /// instead of claiming. Returns the record and whether it was created.
+    pub async fn put(
+        &self,
+        aggregator: &Aggregator,
+        name: &str,
+        address: &str,
+        token: &str,
+        user: &str,
+    ) -> Result<(ClaimedNode, bool), String> {
+        if self.path.is_none() {
+            return Err("managing nodes needs data_dir, so their tokens survive a restart".to_string());
+        }
+        let address = normalize_address(address)?;
+        if name.is_empty() || name.contains('/') {
+            return Err(format!("invalid node name {:?}", name));
+        }
+        if token.is_empty() {
+            return Err("the node's bearer token is required".to_string());
+        }
+        let known = self.nodes.lock().unwrap().iter().any(|n| n.name == name);
+        if !known && aggregator.snapshot_clients().await.iter().any(|c| c.name == name) {
+            return Err(format!("node {} is in the config file", name));
+        }
+
+        let (node, created) = {
+            let mut nodes = self.nodes.lock().unwrap();
+            let created = match nodes.iter_mut().find(|n| n.name == name) {
+                Some(n) => {
+                    if n.address == address && n.token == token && !n.tunnel {
+                        return Ok((n.clone(), false));
+                    }
+                    n.address = address;
+                    n.token = token.to_string();
+                    n.tunnel = false;
+                    false
+                }
+                None => {
+                    nodes.push(ClaimedNode {
+                        name: name.to_string(),
+                        address,
+                        token: token.to_string(),
+                        agent_token_hash: String::new(),
+                        tunnel: false,
+                        claimed_by: user.to_string(),
+                        claimed_at: Utc::now(),
+                    });
+                    true
+                }
+            };
+            self.save(&nodes);
+            let node = nodes.iter().find(|n| n.name == name).cloned().expect("just stored");
+            (node, created)
+        };
+        aggregator.replace_client(self.client(&node)).await;
+        info!("node {} set to {} by {}", node.name, node.address, user);
+        Ok((node, created))
+    }
+
+    /// Forgets a claimed, registered or declared node and stops polling it.
+    pub async fn remove(&self, aggregator: &Aggregator, name: &str) -> Option<ClaimedNode> {
+        let node = {
+            let mut nodes = self.nodes.lock().unwrap();
+            let at = nodes.iter().position(|n| n.name == name)?;
+            let node = nodes.remove(at);
+            self.save(&nodes);
+            node
+        };
+        aggregator.remove_client(name).await;
+        info!("node {} removed", name);
+        Some(node)
+    }
+
+    /// Declares several nodes at once for a console configuration apply:
+    /// drops `delete`, adds or updates `set` and writes the store a single
+    /// time. When that write fails the store is left as it was. Returns
+    /// the nodes from before, for `restore`.
+    pub async fn declare(
+        &self,
+        aggregator: &Aggregator,
+        set: &[DesiredNode],
+        delete: &[String],
+        user: &str,
+    ) -> Result<Vec<ClaimedNode>, String> {
+        if self.path.is_none() {
+            return Err("managing nodes needs data_dir, so their tokens survive a restart".to_string());
+        }
+        let mut staged = Vec::with_capacity(set.len());
+        for n in set {
+            let address = normalize_address(&n.address).map_err(|e| format!("node {}: {}", n.name, e))?;
+            if n.name.is_empty() || n.name.contains('/') {
+                return Err(format!("invalid node name {:?}", n.name));
+            }
+            if n.token.is_empty() {
+                return Err(format!("node {}: the node's bearer token is required", n.name));
+            }
+            staged.push((n, address));
+        }
+        let configured = aggregator.snapshot_clients().await;
+
+        let (previous, changed) = {
+            let mut nodes = self.nodes.lock().unwrap();
+            let previous = nodes.clone();
+            let mut next: Vec<ClaimedNode> = nodes.iter().filter(|n| !delete.contains(&n.name)).cloned().collect();
+            let mut changed = Vec::new();
+            for (n, address) in staged {
+                match next.iter_mut().find(|c| c.name == n.name) {
+                    Some(c) => {
+                        if c.address == address && c.token == n.token && !c.tunnel {
+                            continue;
+                        }
+                        c.address = address;
+                        c.token = n.token.clone();
+                        c.tunnel = false;
+                        changed.push(c.clone());
+                    }
+                    None => {
+                        if configured.iter().any(|c| c.name == n.name) {
+                            return Err(format!("node {} is in the config file", n.name));
+                        }
+                        let node = ClaimedNode {
+                            name: n.name.clone(),
+                            address,
+                            token: n.token.clone(),
+                            agent_token_hash: String::new(),
+                            tunnel: false,
+                            claimed_by: user.to_string(),
+                            claimed_at: Utc::now(),
+                        };
+                        changed.push(node.clone());
+                        next.push(node);
+                    }
+                }
+            }
+            self.write(&next)?;
+            *nodes = next;
+            (previous, changed)
+        };
+
+        for name in delete {
+            if previous.iter().any(|n| &n.name == name) {
+                aggregator.remove_client(name).await;
+                info!("node {} removed", name);
+            }
+        }
+        for node in &changed {
+            aggregator.replace_client(self.client(node)).await;
+            info!("node {} set to {} by {}", node.name, node.address, user);
+        }
+        Ok(previous)
+    }
+
+    /// Puts back the nodes `declare` returned, when the rest of an apply
+    /// could not go through, and polls exactly those again.
+    pub async fn restore(&self, aggregator: &Aggregator, previous: Vec<ClaimedNode>) {
+        let current = {
+            let mut nodes = self.nodes.lock().unwrap();
+            let current = std::mem::replace(&mut *nodes, previous.clone());
+            self.save(&nodes);
+            current
+        };
+        for n in current.iter().filter(|n| !previous.iter().any(|p| p.name == n.name)) {
+            aggregator.remove_client(&n.name).await;
+        }
+        for n in &previous {
+            aggregator.replace_client(self.client(n)).await;
+        }
+        info!("claimed nodes restored after a failed apply");
+    }
+
+    fn save(&self, nodes: &[ClaimedNode]) {
+        if let Err(e) = self.write(nodes) {
+            warn!("{}", e);
+        }
+    }
+
+    fn write(&self, nodes: &[ClaimedNode]) -> Result<(), String> {
+        let Some(ref p) = self.path else {
+            return Ok(());
+        };
+        serde_json::to_vec_pretty(nodes)
+            .map_err(|e| e.to_string())
+            .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()))
+            .map_err(|e| format!("writing claimed nodes {}: {}", p.display(), e))
+    }
+}
+
+/// Address and code from a scanned QR payload.
+pub fn parse_payload(payload: &str) -> Result<(String, String), String> {
+    let rest = payload
+        .trim()
+        .strip_prefix(CLAIM_SCHEME)
+        .ok_or_else(|| format!("not a claim code; expected {}<host>:<port>?code=...", CLAIM_SCHEME))?;
+    let (host, query) = rest.split_once('?').unwrap_or((rest, ""));
+    let mut code = String::new();
+    let mut tls = false;
+    for (k, v) in query.split('&').filter_map(|kv| kv.split_once('=')) {
+        match k {
+            "code" => code = v.to_string(),
+            "tls" => tls = v == "1" || v == "true",
+            _ => {}
+        }
+    }
+    let scheme = if tls { "https" } else { "http" };
+    Ok((format!("{}://{}", scheme, host.trim_end_matches('/')), code))
+}
+
+/// The node URL an address stands for: http:// unless it says https://.
+pub fn normalize_address(address: &str) -> Result<String, String> {
+    let a = address.trim().trim_end_matches('/');
+    if a.is_empty() {
+        return Err("enter the node's address".to_string());
+    }
+    if a.starts_with("http://") || a.starts_with("https://") {
+        Ok(a.to_string())
+    } else if a.contains("://") {
+        Err(format!("unsupported address {:?}; use http:// or https://", a))
+    } else {
+        Ok(format!("http://{}", a))
+    }
+}
+
+/// A random 256-bit token, base64url encoded.
+pub fn new_token() -> String {
+    let mut token = [0u8; 32];
+    SystemRandom::new()
+        .fill(&mut token)
+        .expect("system random source failed");
+    URL_SAFE_NO_PAD.encode(token)
+}
+
+/// How tokens presented to the console are stored: hex SHA-256.
+pub fn token_hash(token: &str) -> String {
+    let digest = ring::digest::digest(&ring::digest::SHA256, token.as_bytes());
+    digest.as_ref().iter().map(|b| format!("{:02x}", b)).collect()
+}
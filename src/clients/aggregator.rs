@@ -1,19 +1,110 @@
-use std::collections::HashMap;
-use std::sync::Arc;
-use tokio::sync::RwLock;
+use base64::Engine;
+use base64::engine::general_purpose::URL_SAFE_NO_PAD;
+use chrono::{DateTime, FixedOffset, Utc};
+use futures_util::stream::{self, FuturesUnordered, Stream, StreamExt};
+use std::collections::{BTreeMap, HashMap};
+use std::pin::Pin;
+use std::sync::{Arc, Mutex};
+use tokio::sync::{RwLock, broadcast};
 use tokio::time::{self, Duration};
 use tracing::{info, warn};
 
 use crate::models::k8s::{
-    BareMetalHost, ConfigMap, ConsistencyReport, Deployment, Event, ISCSICdrom, Network, Node,
-    PersistentVolumeClaim, Pod,
+    BareMetalHost, ConfigMap, ConsistencyReport, Deployment, Device, EndpointAddress, EndpointPort, EndpointSubset,
+    Endpoints, Event, ISCSICdrom, Namespace, Network, Node, ObjectMeta, ObjectReference, PersistentVolumeClaim, Pod,
+    PodStatus, Secret, Service, ServicePort, TypeMeta,
 };
+use crate::config::{NamespacePlacement, ScheduleConfig, SchedulingStrategy};
+use crate::localvolumes::LocalVolumeStore;
+use crate::namespaces::{self, NamespaceStore};
 use crate::models::views::{ClusterSummary, NodeSummary};
+use crate::pools::{self, PoolStore};
+use crate::resources;
+use crate::schedule::Schedule;
+use crate::selector::LabelSelector;
+use crate::volumes;
 
-use super::NodeClient;
+use super::decisions::{Candidate, DecisionLog, PlacementDecision};
+use super::events::{ClusterEvent, EventLog, PodSet, VersionedEvent, diff_pods};
+use super::recorder::EventRecorder;
+use super::registry::{RegistryClient, normalize_arch};
+use super::replica::{ReplicaCache, ReplicaNodeHealth, ReplicaSnapshot};
+use super::{LogOptions, NodeClient, ProbeResult};
 
 pub struct Aggregator {
     clients: RwLock<HashMap<String, Arc<NodeClient>>>,
+    /// Set on follower consoles, which serve pods and nodes from an upstream
+    /// console's replication stream instead of polling nodes.
+    replica: Option<Arc<ReplicaCache>>,
+    /// Source of image platform data for architecture-aware scheduling.
+    registry: Option<RegistryClient>,
+    strategy: SchedulingStrategy,
+    /// Where each namespace's pods go, from scheduler.namespaces.
+    namespace_placement: HashMap<String, NamespacePlacement>,
+    /// Cordoned node pools, which take no new pods.
+    pools: Option<Arc<PoolStore>>,
+    /// Node-local volumes, which hold pods mounting them to their node.
+    local_volumes: Option<Arc<LocalVolumeStore>>,
+    /// Namespaces created through the API ahead of their pods.
+    namespaces: Option<Arc<NamespaceStore>>,
+    /// Jitter and staggering for the health checker and pod polling.
+    schedule: Arc<Schedule>,
+    events: EventLog,
+    decisions: DecisionLog,
+    /// Kubernetes events for the above, served next to the nodes' own.
+    recorder: EventRecorder,
+    /// What each node last reported, served in its place while it is
+    /// unreachable so the UI doesn't go blank during an outage.
+    last_known: Mutex<HashMap<String, LastKnown>>,
+}
+
+#[derive(Default)]
+struct LastKnown {
+    pods: Option<Vec<Pod>>,
+    /// When `pods` was listed.
+    pods_at: Option<DateTime<Utc>>,
+    node: Option<Node>,
+    node_at: Option<DateTime<Utc>>,
+    /// Set while the node's entry is being served instead of the node.
+    stale: bool,
+}
+
+const PROBE_COUNT: u32 = 3;
+
+/// How often run_event_source polls nodes for pod changes.
+const POD_EVENT_INTERVAL: Duration = Duration::from_secs(10);
+
+/// How old a node's cached pods and node object may be for scheduling
+/// before create_pod asks the node itself. The event source refreshes the
+/// pods every POD_EVENT_INTERVAL, so only a node it missed is asked.
+const PLACEMENT_PODS_MAX_AGE: i64 = 30;
+const PLACEMENT_NODE_MAX_AGE: i64 = 60;
+
+/// Node-to-node reachability. Row "console" is measured by the console
+/// itself; other rows come from each node's probe endpoint and are None
+/// where the source node doesn't support probing (or is unreachable).
+#[derive(Debug, Clone, serde::Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ConnectivityMatrix {
+    pub nodes: Vec<String>,
+    pub rows: Vec<ConnectivityRow>,
+}
+
+#[derive(Debug, Clone, serde::Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ConnectivityRow {
+    pub source: String,
+    /// One entry per target in `nodes` order; None on the diagonal.
+    pub results: Vec<Option<ProbeResult>>,
+}
+
+/// A single line of a merged multi-pod log stream.
+#[derive(Debug, Clone)]
+pub struct MergedLogLine {
+    pub timestamp: Option<DateTime<FixedOffset>>,
+    pub node: String,
+    pub pod: String,
+    pub line: String,
 }
 
 impl Aggregator {
@@ -24,10 +115,127 @@ impl Aggregator {
         }
         Self {
             clients: RwLock::new(m),
+            replica: None,
+            registry: None,
+            strategy: SchedulingStrategy::default(),
+            namespace_placement: HashMap::new(),
+            pools: None,
+            local_volumes: None,
+            namespaces: None,
+            schedule: Arc::new(Schedule::new(ScheduleConfig::default())),
+            events: EventLog::new(),
+            decisions: DecisionLog::new(),
+            recorder: EventRecorder::new(),
+            last_known: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub fn with_registry(mut self, base_url: String) -> Self {
+        self.registry = Some(RegistryClient::new(base_url));
+        self
+    }
+
+    pub fn with_strategy(mut self, strategy: SchedulingStrategy) -> Self {
+        self.strategy = strategy;
+        self
+    }
+
+    pub fn with_namespace_placement(mut self, placement: HashMap<String, NamespacePlacement>) -> Self {
+        self.namespace_placement = placement;
+        self
+    }
+
+    pub fn with_pools(mut self, pools: Arc<PoolStore>) -> Self {
+        self.pools = Some(pools);
+        self
+    }
+
+    pub fn with_local_volumes(mut self, volumes: Arc<LocalVolumeStore>) -> Self {
+        self.local_volumes = Some(volumes);
+        self
+    }
+
+    pub fn with_namespaces(mut self, namespaces: Arc<NamespaceStore>) -> Self {
+        self.namespaces = Some(namespaces);
+        self
+    }
+
+    pub fn with_schedule(mut self, schedule: Arc<Schedule>) -> Self {
+        self.schedule = schedule;
+        self
+    }
+
+    pub fn follower(clients: Vec<NodeClient>, replica: Arc<ReplicaCache>) -> Self {
+        let mut agg = Self::new(clients);
+        agg.replica = Some(replica);
+        agg
+    }
+
+    pub fn replica(&self) -> Option<Arc<ReplicaCache>> {
+        self.replica.clone()
+    }
+
+    /// Pod and node health changes, as run_event_source and the health
+    /// checker see them. A lagging receiver should resync from the lists.
+    pub fn subscribe(&self) -> broadcast::Receiver<VersionedEvent> {
+        self.events.subscribe()
+    }
+
+    /// Version of the cluster state as of the latest event, for list
+    /// responses.
+    pub fn resource_version(&self) -> u64 {
+        self.events.resource_version()
+    }
+
+    /// The pod's resourceVersion: the version of the cluster state it last
+    /// changed in. The API serves this rather than the node's own.
+    pub fn pod_version(&self, ns: &str, name: &str) -> u64 {
+        self.events.pod_version(&format!("{}/{}", ns, name))
+    }
+
+    /// The node's resourceVersion: the version its health last changed at.
+    pub fn node_version(&self, name: &str) -> u64 {
+        self.events.node_version(name)
+    }
+
+    /// Events after `resource_version` and a receiver for later ones, for
+    /// resuming a watch; None if that version has expired.
+    pub fn events_since(
+        &self,
+        resource_version: u64,
+    ) -> Option<(Vec<VersionedEvent>, broadcast::Receiver<VersionedEvent>)> {
+        self.events.since(resource_version)
+    }
+
+    /// Current pods, nodes and node health, as sent to follower consoles.
+    pub async fn replication_snapshot(&self) -> ReplicaSnapshot {
+        let pods = self.list_all_pods().await.unwrap_or_default();
+        let nodes = self.list_all_nodes().await.unwrap_or_default();
+        let node_health = match self.replica {
+            Some(ref r) => r.snapshot().node_health,
+            None => self
+                .snapshot()
+                .await
+                .iter()
+                .map(|c| ReplicaNodeHealth {
+                    name: c.name.clone(),
+                    healthy: c.is_healthy(),
+                    last_ping: c.last_ping(),
+                })
+                .collect(),
+        };
+        ReplicaSnapshot {
+            taken_at: None,
+            pods,
+            nodes,
+            node_health,
         }
     }
 
     pub async fn list_all_pods(&self) -> Result<Vec<Pod>, Box<dyn std::error::Error + Send + Sync>> {
+        if let Some(ref r) = self.replica {
+            return Ok(r.snapshot().pods);
+        }
         let clients = self.snapshot().await;
 
         let mut all_pods = Vec::new();
@@ -37,31 +245,127 @@ impl Aggregator {
             let c = client.clone();
             handles.push(tokio::spawn(async move {
                 match c.list_pods().await {
-                    Ok(list) => Some((c.name.clone(), list)),
+                    Ok(list) => (c.name.clone(), Some(list)),
                     Err(e) => {
                         warn!("error listing pods from {}: {}", c.name, e);
-                        None
+                        (c.name.clone(), None)
                     }
                 }
             }));
         }
 
         for handle in handles {
-            if let Ok(Some((node_name, list))) = handle.await {
-                for mut pod in list.items {
-                    let annotations = pod.metadata.annotations.get_or_insert_with(HashMap::new);
-                    annotations.insert("mkube.io/node".to_string(), node_name.clone());
-                    all_pods.push(pod);
+            let Ok((node_name, list)) = handle.await else {
+                continue;
+            };
+            let Some(list) = list else {
+                all_pods.extend(self.last_known_pods(&node_name));
+                continue;
+            };
+            let mut pods = list.items;
+            annotate_node(&mut pods, &node_name);
+            self.remember_pods(&node_name, pods.clone());
+            all_pods.extend(pods);
+        }
+
+        Ok(all_pods)
+    }
+
+    /// A page of at most `limit` pods in a stable order, by node name and
+    /// then namespace/name, starting after `from`. Only the nodes the page
+    /// reaches are asked, so paging through a large cluster never lists it
+    /// all at once. Returns whether more pods may follow.
+    pub async fn list_pods_page(
+        &self,
+        namespace: Option<&str>,
+        limit: usize,
+        from: Option<&PodCursor>,
+    ) -> Result<(Vec<Pod>, bool), Box<dyn std::error::Error + Send + Sync>> {
+        let mut by_node: BTreeMap<String, Option<Arc<NodeClient>>> = BTreeMap::new();
+        let mut replica_pods = Vec::new();
+        if let Some(ref r) = self.replica {
+            replica_pods = r.snapshot().pods;
+            for p in &replica_pods {
+                by_node.insert(pod_node(p).to_string(), None);
+            }
+        } else {
+            for c in self.snapshot().await {
+                by_node.insert(c.name.clone(), Some(c));
+            }
+        }
+
+        let mut page = Vec::new();
+        let start = from.map(|c| c.node.as_str()).unwrap_or_default();
+        for (node_name, client) in by_node.range(start.to_string()..) {
+            let mut pods = match client {
+                Some(c) => match c.list_pods().await {
+                    Ok(list) => {
+                        let mut pods = list.items;
+                        annotate_node(&mut pods, node_name);
+                        self.remember_pods(node_name, pods.clone());
+                        pods
+                    }
+                    Err(e) => {
+                        warn!("error listing pods from {}: {}", node_name, e);
+                        self.last_known_pods(node_name)
+                    }
+                },
+                None => replica_pods
+                    .iter()
+                    .filter(|p| pod_node(p) == node_name.as_str())
+                    .cloned()
+                    .collect(),
+            };
+            pods.retain(|p| namespace.is_none_or(|ns| p.metadata.namespace == ns));
+            pods.sort_by(|a, b| pod_key(a).cmp(&pod_key(b)));
+            if let Some(c) = from.filter(|c| c.node == *node_name) {
+                pods.retain(|p| pod_key(p) > c.after);
+            }
+            for pod in pods {
+                if page.len() == limit {
+                    return Ok((page, true));
                 }
+                page.push(pod);
             }
         }
+        Ok((page, false))
+    }
 
-        Ok(all_pods)
+    /// Like list_all_pods, but yields each node's pods as soon as that node
+    /// answers instead of collecting them all, for streaming large lists.
+    pub async fn stream_all_pods(self: Arc<Self>) -> Pin<Box<dyn Stream<Item = Vec<Pod>> + Send>> {
+        if let Some(ref r) = self.replica {
+            return Box::pin(stream::iter([r.snapshot().pods]));
+        }
+        let lists: FuturesUnordered<_> = self
+            .snapshot()
+            .await
+            .into_iter()
+            .map(|c| async move {
+                let list = c.list_pods().await.map_err(|e| e.to_string());
+                (c.name.clone(), list)
+            })
+            .collect();
+        Box::pin(lists.map(move |(node_name, list)| match list {
+            Ok(list) => {
+                let mut pods = list.items;
+                annotate_node(&mut pods, &node_name);
+                self.remember_pods(&node_name, pods.clone());
+                pods
+            }
+            Err(e) => {
+                warn!("error listing pods from {}: {}", node_name, e);
+                self.last_known_pods(&node_name)
+            }
+        }))
     }
 
     pub async fn list_all_nodes(
         &self,
     ) -> Result<Vec<Node>, Box<dyn std::error::Error + Send + Sync>> {
+        if let Some(ref r) = self.replica {
+            return Ok(r.snapshot().nodes);
+        }
         let clients = self.snapshot().await;
 
         let mut nodes = Vec::new();
@@ -71,18 +375,23 @@ impl Aggregator {
             let c = client.clone();
             handles.push(tokio::spawn(async move {
                 match c.get_node().await {
-                    Ok(node) => Some(node),
+                    Ok(node) => (c.name.clone(), Some(node)),
                     Err(e) => {
                         warn!("error getting node from {}: {}", c.name, e);
-                        None
+                        (c.name.clone(), None)
                     }
                 }
             }));
         }
 
         for handle in handles {
-            if let Ok(Some(node)) = handle.await {
-                nodes.push(node);
+            match handle.await {
+                Ok((name, Some(node))) => {
+                    self.remember_node(&name, node.clone());
+                    nodes.push(node);
+                }
+                Ok((name, None)) => nodes.extend(self.last_known_node(&name)),
+                Err(_) => {}
             }
         }
 
@@ -94,6 +403,22 @@ impl Aggregator {
         ns: &str,
         name: &str,
     ) -> Result<(Pod, String), Box<dyn std::error::Error + Send + Sync>> {
+        if let Some(ref r) = self.replica {
+            let pod = r
+                .snapshot()
+                .pods
+                .into_iter()
+                .find(|p| p.metadata.namespace == ns && p.metadata.name == name)
+                .ok_or_else(|| format!("pod {}/{} not found in replica", ns, name))?;
+            let node = pod
+                .metadata
+                .annotations
+                .as_ref()
+                .and_then(|a| a.get("mkube.io/node"))
+                .cloned()
+                .unwrap_or_default();
+            return Ok((pod, node));
+        }
         let clients = self.snapshot().await;
 
         for client in &clients {
@@ -103,43 +428,412 @@ impl Aggregator {
                 return Ok((pod, client.name.clone()));
             }
         }
+        // A pod on an unreachable node is still worth showing.
+        for client in clients.iter().filter(|c| !c.is_healthy()) {
+            let found = self
+                .last_known_pods(&client.name)
+                .into_iter()
+                .find(|p| p.metadata.namespace == ns && p.metadata.name == name);
+            if let Some(pod) = found {
+                return Ok((pod, client.name.clone()));
+            }
+        }
         Err(format!("pod {}/{} not found on any node", ns, name).into())
     }
 
     pub async fn create_pod(
         &self,
         pod: &Pod,
+    ) -> Result<Pod, Box<dyn std::error::Error + Send + Sync>> {
+        self.create_pod_avoiding(pod, &[]).await
+    }
+
+    /// Like create_pod, but places the pod on a node outside `avoid` when
+    /// any other can run it, e.g. away from nodes a job's pods failed on.
+    pub async fn create_pod_avoiding(
+        &self,
+        pod: &Pod,
+        avoid: &[String],
     ) -> Result<Pod, Box<dyn std::error::Error + Send + Sync>> {
         let clients_map = self.clients.read().await;
 
         // Route by nodeName if specified
         if !pod.spec.node_name.is_empty() {
             if let Some(c) = clients_map.get(&pod.spec.node_name) {
-                return c.create_pod(pod).await;
+                let (chosen, candidates) = self.check_named(c, pod).await;
+                self.record_decision(self.decision(pod, "node-name", chosen.clone(), candidates));
+                if let Err(reason) = chosen {
+                    return Err(reason.into());
+                }
+                let created = c.create_pod(pod).await?;
+                self.count_created(&c.name, &created);
+                return Ok(created);
             }
             return Err(format!("node {:?} not found", pod.spec.node_name).into());
         }
 
-        // Least-pods scheduling
-        let mut target: Option<Arc<NodeClient>> = None;
-        let mut min_pods = usize::MAX;
+        let (target, candidates) = self.pick_node(&clients_map, pod, avoid).await;
+        let chosen = match target {
+            Ok(ref c) => Ok(c.name.clone()),
+            Err(ref e) => Err(e.clone()),
+        };
+        self.record_decision(self.decision(pod, self.strategy_name(), chosen, candidates));
+        let target = target?;
+        let created = target.create_pod(pod).await?;
+        self.count_created(&target.name, &created);
+        Ok(created)
+    }
+
+    // Whether the node a pod names can run it. Pool membership and local
+    // volumes still apply; maintenance, pool cordons and namespace pins
+    // don't.
+    async fn check_named(&self, c: &NodeClient, pod: &Pod) -> (Result<String, String>, Vec<Candidate>) {
+        let existing = self.placement_pods(c).await.unwrap_or_default();
+        let node = self.placement_node(c).await;
+        let rejection = match self
+            .pool_rejection(c, pod, true)
+            .or_else(|| self.local_volume_rejection(c, pod))
+        {
+            Some(reason) => Some(reason),
+            None => self.node_rejection(node.as_ref(), pod, &existing).await,
+        };
+        let chosen = match rejection {
+            Some(ref reason) => Err(format!("cannot run on node {:?}: {}", c.name, reason)),
+            None => Ok(c.name.clone()),
+        };
+        let candidate = Candidate {
+            node: c.name.clone(),
+            score: None,
+            excluded: rejection,
+            avoided: false,
+            unpreferred: false,
+        };
+        (chosen, vec![candidate])
+    }
+
+    fn strategy_name(&self) -> &'static str {
+        match self.strategy {
+            SchedulingStrategy::LeastPods => "least-pods",
+            SchedulingStrategy::Weighted => "weighted",
+        }
+    }
+
+    // Adds a pod just created to its node's cached pods, so pods created
+    // in quick succession don't all see the same counts.
+    fn count_created(&self, node: &str, pod: &Pod) {
+        let mut last_known = self.last_known.lock().unwrap();
+        if let Some(pods) = last_known.get_mut(node).and_then(|k| k.pods.as_mut()) {
+            let mut pod = pod.clone();
+            annotate_node(std::slice::from_mut(&mut pod), node);
+            pods.push(pod);
+        }
+    }
 
-        for c in clients_map.values() {
-            if !c.is_healthy() {
-                continue;
+    /// The node create_pod would place `pod` on if it had no nodeName, or
+    /// why none can take it.
+    pub async fn placement(&self, pod: &Pod, avoid: &[String]) -> Result<String, String> {
+        let clients_map = self.clients.read().await;
+        self.pick_node(&clients_map, pod, avoid).await.0.map(|c| c.name.clone())
+    }
+
+    /// Placement decisions, newest first, optionally for one namespace or
+    /// pod.
+    pub fn decisions(&self, namespace: Option<&str>, pod: Option<&str>) -> Vec<PlacementDecision> {
+        self.decisions.list(namespace, pod)
+    }
+
+    /// Where create_pod would put `pod` and why, without creating it or
+    /// logging the decision; for dry runs.
+    pub async fn plan_placement(&self, pod: &Pod, avoid: &[String]) -> PlacementDecision {
+        let clients_map = self.clients.read().await;
+        if !pod.spec.node_name.is_empty() {
+            let (chosen, candidates) = match clients_map.get(&pod.spec.node_name) {
+                Some(c) => self.check_named(c, pod).await,
+                None => (Err(format!("node {:?} not found", pod.spec.node_name)), Vec::new()),
+            };
+            return self.decision(pod, "node-name", chosen, candidates);
+        }
+        let (target, candidates) = self.pick_node(&clients_map, pod, avoid).await;
+        self.decision(pod, self.strategy_name(), target.map(|c| c.name.clone()), candidates)
+    }
+
+    fn record_decision(&self, d: PlacementDecision) {
+        self.recorder.record_decision(&d);
+        self.decisions.record(d);
+    }
+
+    // Publishes a cluster event to subscribers and records it as a
+    // Kubernetes event.
+    fn publish(&self, event: ClusterEvent) {
+        self.recorder.record_cluster_event(&event);
+        self.events.publish(event);
+    }
+
+    fn decision(
+        &self,
+        pod: &Pod,
+        strategy: &str,
+        chosen: Result<String, String>,
+        candidates: Vec<Candidate>,
+    ) -> PlacementDecision {
+        let (node, reason) = match chosen {
+            Ok(node) => {
+                let reason = if strategy == "node-name" {
+                    "named by the pod's nodeName".to_string()
+                } else {
+                    match candidates.iter().find(|c| c.node == node) {
+                        Some(c) if c.avoided => {
+                            "no other node can run it, though asked to avoid this one".to_string()
+                        }
+                        Some(c) if c.unpreferred => {
+                            format!("no node namespace {} prefers can run it", pod.metadata.namespace)
+                        }
+                        Some(Candidate { score: Some(score), .. }) if strategy == "least-pods" => {
+                            format!("fewest pods ({})", score)
+                        }
+                        Some(Candidate { score: Some(score), .. }) => {
+                            format!("fewest pods per unit of performance score ({:.2})", score)
+                        }
+                        _ => String::new(),
+                    }
+                };
+                (Some(node), reason)
             }
-            if let Ok(list) = c.list_pods().await {
-                if list.items.len() < min_pods {
-                    min_pods = list.items.len();
-                    target = Some(c.clone());
+            Err(e) => (None, e),
+        };
+        PlacementDecision {
+            at: Utc::now(),
+            namespace: pod.metadata.namespace.clone(),
+            pod: pod.metadata.name.clone(),
+            strategy: strategy.to_string(),
+            node,
+            reason,
+            candidates,
+        }
+    }
+
+    // Picks among the nodes that can run the pod's images and have its
+    // extended resources (GPUs etc.) free: the one with the fewest pods, or
+    // with the weighted strategy the one with the fewest pods per unit of
+    // performance score. Avoided nodes are only a fallback, then nodes
+    // outside the namespace's soft pin. Counts come from the pod lists the
+    // event source keeps while they are recent.
+    async fn pick_node(
+        &self,
+        clients_map: &HashMap<String, Arc<NodeClient>>,
+        pod: &Pod,
+        avoid: &[String],
+    ) -> (Result<Arc<NodeClient>, String>, Vec<Candidate>) {
+        // Lowest (avoided, unpreferred, load) wins.
+        let mut best: Option<((bool, bool, f64), Arc<NodeClient>)> = None;
+        let mut rejected = Vec::new();
+        let mut candidates = Vec::new();
+
+        for c in clients_map.values().filter(|c| !c.is_healthy()) {
+            candidates.push(Candidate {
+                node: c.name.clone(),
+                score: None,
+                excluded: Some("offline".to_string()),
+                avoided: avoid.contains(&c.name),
+                unpreferred: !self.namespace_prefers(c, pod),
+            });
+        }
+
+        // Every node at once; most answer from the cache.
+        let loads = futures_util::future::join_all(
+            clients_map
+                .values()
+                .filter(|c| c.is_healthy())
+                .map(|c| async move { (c, self.node_load(c, pod).await) }),
+        )
+        .await;
+
+        for (c, load) in loads {
+            let mut candidate = Candidate {
+                node: c.name.clone(),
+                score: None,
+                excluded: None,
+                avoided: avoid.contains(&c.name),
+                unpreferred: !self.namespace_prefers(c, pod),
+            };
+            let load = match load {
+                Some(Ok(load)) => load,
+                Some(Err(reason)) => {
+                    rejected.push(format!("{}: {}", c.name, reason));
+                    candidate.excluded = Some(reason);
+                    candidates.push(candidate);
+                    continue;
+                }
+                None => {
+                    candidate.excluded = Some("couldn't list its pods".to_string());
+                    candidates.push(candidate);
+                    continue;
+                }
+            };
+            let rank = (candidate.avoided, candidate.unpreferred, load);
+            candidate.score = Some(load);
+            candidates.push(candidate);
+            if best.as_ref().is_none_or(|(r, _)| rank < *r) {
+                best = Some((rank, c.clone()));
+            }
+        }
+
+        candidates.sort_by(|a, b| a.node.cmp(&b.node));
+        let result = match best {
+            Some((_, c)) => Ok(c),
+            None if !rejected.is_empty() => {
+                rejected.sort();
+                Err(format!("no node can run this pod ({})", rejected.join("; ")))
+            }
+            None => Err("no healthy nodes available".to_string()),
+        };
+        (result, candidates)
+    }
+
+    // Whether a node is one the pod's namespace is pinned to, or the
+    // namespace isn't pinned.
+    fn namespace_prefers(&self, c: &NodeClient, pod: &Pod) -> bool {
+        let Some(p) = self.namespace_placement.get(&pod.metadata.namespace) else {
+            return true;
+        };
+        p.nodes.contains(&c.name) || (p.pool.is_some() && p.pool == c.pool)
+    }
+
+    // Why a hard namespace pin rules a node out for `pod`.
+    fn namespace_rejection(&self, c: &NodeClient, pod: &Pod) -> Option<String> {
+        let p = self.namespace_placement.get(&pod.metadata.namespace)?;
+        if !p.hard || self.namespace_prefers(c, pod) {
+            return None;
+        }
+        let mut to: Vec<String> = p.nodes.clone();
+        if let Some(ref pool) = p.pool {
+            to.push(format!("pool {}", pool));
+        }
+        Some(format!("namespace {} is pinned to {}", pod.metadata.namespace, to.join(", ")))
+    }
+
+    // How loaded a healthy node is for placing `pod` (lower is better),
+    // why it can't take the pod, or None if its pods couldn't be listed.
+    async fn node_load(&self, c: &NodeClient, pod: &Pod) -> Option<Result<f64, String>> {
+        if c.in_maintenance() {
+            return Some(Err("in maintenance".to_string()));
+        }
+        if let Some(reason) = self
+            .pool_rejection(c, pod, false)
+            .or_else(|| self.local_volume_rejection(c, pod))
+            .or_else(|| self.namespace_rejection(c, pod))
+        {
+            return Some(Err(reason));
+        }
+        let existing = self.placement_pods(c).await?;
+        let node = self.placement_node(c).await;
+        if let Some(reason) = self.node_rejection(node.as_ref(), pod, &existing).await {
+            return Some(Err(reason));
+        }
+        Some(Ok(match self.strategy {
+            SchedulingStrategy::LeastPods => existing.len() as f64,
+            SchedulingStrategy::Weighted => (existing.len() + 1) as f64 / node_score(c, node.as_ref()),
+        }))
+    }
+
+    // Why a node's pool rules it out for `pod`: the pod asks for another
+    // pool, or the node's pool is cordoned. A pod naming its node
+    // (`named`) gets past a cordon, as it does past maintenance.
+    fn pool_rejection(&self, c: &NodeClient, pod: &Pod, named: bool) -> Option<String> {
+        if let Some(wanted) = pools::pod_pool(pod) {
+            match c.pool.as_deref() {
+                Some(pool) if pool == wanted => {}
+                Some(pool) => return Some(format!("in pool {}, the pod asks for {}", pool, wanted)),
+                None => return Some(format!("in no pool, the pod asks for {}", wanted)),
+            }
+        }
+        let pool = c.pool.as_deref()?;
+        if !named && self.pools.as_ref().is_some_and(|p| p.is_cordoned(pool)) {
+            return Some(format!("pool {} is cordoned", pool));
+        }
+        None
+    }
+
+    fn local_volume_rejection(&self, c: &NodeClient, pod: &Pod) -> Option<String> {
+        self.local_volumes.as_ref()?.rejection(&c.name, pod)
+    }
+
+    // A node's pods for scheduling: the cached list while it is recent,
+    // else a live one.
+    async fn placement_pods(&self, c: &NodeClient) -> Option<Vec<Pod>> {
+        if let Some(pods) = self.recent_pods(&c.name, PLACEMENT_PODS_MAX_AGE) {
+            return Some(pods);
+        }
+        let mut pods = c.list_pods().await.ok()?.items;
+        annotate_node(&mut pods, &c.name);
+        self.remember_pods(&c.name, pods.clone());
+        Some(pods)
+    }
+
+    async fn placement_node(&self, c: &NodeClient) -> Option<Node> {
+        if let Some(node) = self.recent_node(&c.name, PLACEMENT_NODE_MAX_AGE) {
+            return Some(node);
+        }
+        let node = c.get_node().await.ok()?;
+        self.remember_node(&c.name, node.clone());
+        Some(node)
+    }
+
+    /// Why a pod can't be placed on a node already running `existing`, or
+    /// None if it can.
+    async fn node_rejection(&self, node: Option<&Node>, pod: &Pod, existing: &[Pod]) -> Option<String> {
+        let wanted = resources::pod_extended(pod);
+        if !wanted.is_empty() {
+            let capacity = node.map(resources::node_extended).unwrap_or_default();
+            let used = resources::allocated(existing);
+            for (res, n) in &wanted {
+                let have = capacity.get(res).copied().unwrap_or(0);
+                let free = have - used.get(res).copied().unwrap_or(0);
+                if *n > free {
+                    return Some(format!("insufficient {} ({} requested, {} of {} free)", res, n, free.max(0), have));
                 }
             }
         }
 
-        match target {
-            Some(c) => c.create_pod(pod).await,
-            None => Err("no healthy nodes available".into()),
+        if let Some(reason) = volumes::data_rejection(pod, node) {
+            return Some(reason);
+        }
+
+        let arch = &node?.status.node_info.architecture;
+        self.arch_mismatch(pod, arch).await
+    }
+
+    /// Whether the console's registry has an image; None for images outside
+    /// it, or without a registry configured.
+    pub async fn image_in_registry(&self, image: &str) -> Option<bool> {
+        self.registry.as_ref()?.image_exists(image).await
+    }
+
+    /// Checks every container image of the pod against a node architecture
+    /// using the registry's manifest platforms. Images outside the registry,
+    /// or nodes that don't report an architecture, are not restricted.
+    pub async fn arch_mismatch(&self, pod: &Pod, node_arch: &str) -> Option<String> {
+        let registry = self.registry.as_ref()?;
+        if node_arch.is_empty() {
+            return None;
+        }
+        let node_arch = normalize_arch(node_arch);
+
+        for c in &pod.spec.containers {
+            let archs = match registry.image_architectures(&c.image).await {
+                Some(a) if !a.is_empty() => a,
+                _ => continue,
+            };
+            if !archs.contains(&node_arch) {
+                return Some(format!(
+                    "image {} has no {} variant (available: {})",
+                    c.image,
+                    node_arch,
+                    archs.join(", ")
+                ));
+            }
         }
+        None
     }
 
     pub async fn delete_pod(
@@ -156,10 +850,32 @@ impl Aggregator {
         c.delete_pod(ns, name).await
     }
 
+    /// Writes `status` to the pod on its node, keeping the node's spec and
+    /// metadata. Returns the pod as the node has it afterwards, at its new
+    /// resourceVersion.
+    pub async fn update_pod_status(
+        &self,
+        ns: &str,
+        name: &str,
+        status: PodStatus,
+    ) -> Result<Pod, Box<dyn std::error::Error + Send + Sync>> {
+        let (mut pod, node_name) = self.get_pod(ns, name).await?;
+        pod.status = status;
+
+        let clients_map = self.clients.read().await;
+        let c = clients_map
+            .get(&node_name)
+            .ok_or_else(|| format!("node {:?} not found", node_name))?;
+        let mut updated = c.update_pod_status(&pod).await?;
+        updated.metadata.resource_version = self.events.touch_pod(&format!("{}/{}", ns, name)).to_string();
+        Ok(updated)
+    }
+
     pub async fn get_pod_log(
         &self,
         ns: &str,
         name: &str,
+        opts: &LogOptions,
     ) -> Result<String, Box<dyn std::error::Error + Send + Sync>> {
         let (_, node_name) = self.get_pod(ns, name).await?;
 
@@ -167,18 +883,236 @@ impl Aggregator {
         let c = clients_map
             .get(&node_name)
             .ok_or_else(|| format!("node {:?} not found", node_name))?;
-        c.get_pod_log(ns, name).await
+        c.get_pod_log(ns, name, opts).await
     }
 
-    pub async fn get_node(
+    /// Starts an exec, attach or port-forward stream on the node running
+    /// the pod; see NodeClient::upgrade.
+    pub async fn stream_pod(
         &self,
+        ns: &str,
         name: &str,
-    ) -> Result<Node, Box<dyn std::error::Error + Send + Sync>> {
+        method: reqwest::Method,
+        path_and_query: &str,
+        headers: reqwest::header::HeaderMap,
+    ) -> Result<reqwest::Response, Box<dyn std::error::Error + Send + Sync>> {
+        let (_, node_name) = self.get_pod(ns, name).await?;
+
         let clients_map = self.clients.read().await;
         let c = clients_map
+            .get(&node_name)
+            .ok_or_else(|| format!("node {:?} not found", node_name))?;
+        c.upgrade(method, path_and_query, headers).await
+    }
+
+    /// Fetches logs from every pod matching the selector (optionally scoped to
+    /// a namespace) and interleaves them by timestamp. Lines without a
+    /// parseable timestamp inherit the previous line's so they stay in place.
+    pub async fn get_merged_logs(
+        &self,
+        namespace: Option<&str>,
+        selector: &LabelSelector,
+        opts: &LogOptions,
+    ) -> Result<Vec<MergedLogLine>, Box<dyn std::error::Error + Send + Sync>> {
+        let pods = self.list_all_pods().await?;
+        let clients_map = self.clients.read().await;
+
+        let mut opts = opts.clone();
+        opts.timestamps = true;
+
+        let mut handles = Vec::new();
+        for pod in pods {
+            if namespace.map(|ns| ns != pod.metadata.namespace).unwrap_or(false) {
+                continue;
+            }
+            if !selector.matches(pod.metadata.labels.as_ref()) {
+                continue;
+            }
+            let node_name = pod
+                .metadata
+                .annotations
+                .as_ref()
+                .and_then(|a| a.get("mkube.io/node"))
+                .cloned()
+                .unwrap_or_default();
+            let c = match clients_map.get(&node_name) {
+                Some(c) => c.clone(),
+                None => continue,
+            };
+            let opts = opts.clone();
+            handles.push(tokio::spawn(async move {
+                let ns = pod.metadata.namespace;
+                let name = pod.metadata.name;
+                match c.get_pod_log(&ns, &name, &opts).await {
+                    Ok(text) => Some((c.name.clone(), name, text)),
+                    Err(e) => {
+                        warn!("error fetching logs for {}/{} from {}: {}", ns, name, c.name, e);
+                        None
+                    }
+                }
+            }));
+        }
+        drop(clients_map);
+
+        let mut lines = Vec::new();
+        for handle in handles {
+            if let Ok(Some((node, pod, text))) = handle.await {
+                let mut last_ts = None;
+                for raw in text.lines() {
+                    let (ts, line) = split_log_timestamp(raw);
+                    if ts.is_some() {
+                        last_ts = ts;
+                    }
+                    lines.push(MergedLogLine {
+                        timestamp: ts.or(last_ts),
+                        node: node.clone(),
+                        pod: pod.clone(),
+                        line: line.to_string(),
+                    });
+                }
+            }
+        }
+
+        // Stable sort keeps per-pod ordering for equal or missing timestamps
+        lines.sort_by_key(|l| l.timestamp);
+        Ok(lines)
+    }
+
+    pub async fn get_node(
+        &self,
+        name: &str,
+    ) -> Result<Node, Box<dyn std::error::Error + Send + Sync>> {
+        if let Some(ref r) = self.replica {
+            return r
+                .snapshot()
+                .nodes
+                .into_iter()
+                .find(|n| n.metadata.name == name)
+                .ok_or_else(|| format!("node {:?} not found in replica", name).into());
+        }
+        let c = self
+            .clients
+            .read()
+            .await
             .get(name)
+            .cloned()
             .ok_or_else(|| format!("node {:?} not found", name))?;
-        c.get_node().await
+        match c.get_node().await {
+            Ok(node) => {
+                self.remember_node(name, node.clone());
+                Ok(node)
+            }
+            Err(e) => self.last_known_node(name).ok_or(e),
+        }
+    }
+
+    /// Every namespace: "default", those created through the API and those
+    /// pods run in, sorted by name.
+    pub async fn list_namespaces(&self) -> Result<Vec<Namespace>, Box<dyn std::error::Error + Send + Sync>> {
+        let mut all: BTreeMap<String, Namespace> = BTreeMap::new();
+        all.insert(
+            namespaces::DEFAULT_NAMESPACE.to_string(),
+            namespaces::implicit(namespaces::DEFAULT_NAMESPACE),
+        );
+        if let Some(ref store) = self.namespaces {
+            all.extend(store.list().into_iter().map(|ns| (ns.metadata.name.clone(), ns)));
+        }
+        for pod in self.list_all_pods().await? {
+            let name = pod.metadata.namespace;
+            if !name.is_empty() && !all.contains_key(&name) {
+                all.insert(name.clone(), namespaces::implicit(&name));
+            }
+        }
+        Ok(all.into_values().collect())
+    }
+
+    pub async fn get_namespace(&self, name: &str) -> Result<Namespace, Box<dyn std::error::Error + Send + Sync>> {
+        if let Some(ns) = self.namespaces.as_ref().and_then(|s| s.get(name)) {
+            return Ok(ns);
+        }
+        if name == namespaces::DEFAULT_NAMESPACE
+            || self.list_all_pods().await?.iter().any(|p| p.metadata.namespace == name)
+        {
+            return Ok(namespaces::implicit(name));
+        }
+        Err(format!("namespace {:?} not found", name).into())
+    }
+
+    /// Unreachable nodes whose last-known state is being served in their
+    /// place, with when each last answered, oldest first.
+    pub async fn stale_nodes(&self) -> Vec<(String, DateTime<Utc>)> {
+        let down: Vec<String> = self
+            .snapshot()
+            .await
+            .iter()
+            .filter(|c| !c.is_healthy())
+            .map(|c| c.name.clone())
+            .collect();
+        let last_known = self.last_known.lock().unwrap();
+        let mut stale: Vec<_> = down
+            .into_iter()
+            .filter_map(|name| {
+                let k = last_known.get(&name).filter(|k| k.stale)?;
+                Some((name, k.pods_at.max(k.node_at)?))
+            })
+            .collect();
+        stale.sort_by_key(|(_, at)| *at);
+        stale
+    }
+
+    // Records the pods a node just listed, annotated with the node.
+    fn remember_pods(&self, node: &str, pods: Vec<Pod>) {
+        let mut last_known = self.last_known.lock().unwrap();
+        let k = last_known.entry(node.to_string()).or_default();
+        k.pods = Some(pods);
+        k.pods_at = Some(Utc::now());
+        k.stale = false;
+    }
+
+    fn remember_node(&self, node: &str, n: Node) {
+        let mut last_known = self.last_known.lock().unwrap();
+        let k = last_known.entry(node.to_string()).or_default();
+        k.node = Some(n);
+        k.node_at = Some(Utc::now());
+        k.stale = false;
+    }
+
+    // Cached pods of a node listed within `max_age` seconds.
+    fn recent_pods(&self, node: &str, max_age: i64) -> Option<Vec<Pod>> {
+        let last_known = self.last_known.lock().unwrap();
+        let k = last_known.get(node)?;
+        if (Utc::now() - k.pods_at?).num_seconds() > max_age {
+            return None;
+        }
+        k.pods.clone()
+    }
+
+    fn recent_node(&self, node: &str, max_age: i64) -> Option<Node> {
+        let last_known = self.last_known.lock().unwrap();
+        let k = last_known.get(node)?;
+        if (Utc::now() - k.node_at?).num_seconds() > max_age {
+            return None;
+        }
+        k.node.clone()
+    }
+
+    fn last_known_pods(&self, node: &str) -> Vec<Pod> {
+        let mut last_known = self.last_known.lock().unwrap();
+        match last_known.get_mut(node) {
+            Some(k) if k.pods.is_some() => {
+                k.stale = true;
+                k.pods.clone().unwrap_or_default()
+            }
+            _ => Vec::new(),
+        }
+    }
+
+    fn last_known_node(&self, node: &str) -> Option<Node> {
+        let mut last_known = self.last_known.lock().unwrap();
+        let k = last_known.get_mut(node)?;
+        let n = k.node.clone()?;
+        k.stale = true;
+        Some(n)
     }
 
     // --- Delegating methods (single-node, use first healthy client) ---
@@ -311,6 +1245,201 @@ impl Aggregator {
         Err(format!("configmap {}/{} not found", ns, name).into())
     }
 
+    /// Creates a configmap on every healthy node, since pods read
+    /// configmaps from the node they run on. Returns the created configmap
+    /// and the nodes it couldn't be created on, failing only when it
+    /// couldn't be created anywhere.
+    pub async fn create_configmap(
+        &self,
+        cm: &ConfigMap,
+    ) -> Result<(ConfigMap, Vec<String>), Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.create_configmap(cm).await }).await;
+        any_succeeded(results)
+    }
+
+    /// Deletes a configmap from every healthy node. Returns the nodes it
+    /// couldn't be deleted from, failing only when no node had it.
+    pub async fn delete_configmap(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Vec<String>, Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.delete_configmap(ns, name).await }).await;
+        any_succeeded(results)
+            .map(|(_, failed)| failed)
+            .map_err(|_| format!("configmap {}/{} not found", ns, name).into())
+    }
+
+    pub async fn list_secrets(
+        &self,
+        ns: &str,
+    ) -> Result<Vec<Secret>, Box<dyn std::error::Error + Send + Sync>> {
+        match self.first_client().await {
+            Some(c) => Ok(c.list_secrets(ns).await?.items),
+            None => Ok(Vec::new()),
+        }
+    }
+
+    pub async fn get_secret(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Secret, Box<dyn std::error::Error + Send + Sync>> {
+        let clients = self.snapshot().await;
+        for c in &clients {
+            if let Ok(s) = c.get_secret(ns, name).await {
+                return Ok(s);
+            }
+        }
+        Err(format!("secret {}/{} not found", ns, name).into())
+    }
+
+    /// Creates a secret on every healthy node, like create_configmap.
+    pub async fn create_secret(
+        &self,
+        secret: &Secret,
+    ) -> Result<(Secret, Vec<String>), Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.create_secret(secret).await }).await;
+        any_succeeded(results)
+    }
+
+    /// Deletes a secret from every healthy node, like delete_configmap.
+    pub async fn delete_secret(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Vec<String>, Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.delete_secret(ns, name).await }).await;
+        any_succeeded(results)
+            .map(|(_, failed)| failed)
+            .map_err(|_| format!("secret {}/{} not found", ns, name).into())
+    }
+
+    /// Services, merged from every healthy node: they're created on each
+    /// node like configmaps, and one a node missed still shows.
+    pub async fn list_services(
+        &self,
+        ns: &str,
+    ) -> Result<Vec<Service>, Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.list_services(ns).await }).await;
+        let mut merged: BTreeMap<String, Service> = BTreeMap::new();
+        for (node, result) in results {
+            match result {
+                Ok(list) => {
+                    for svc in list.items {
+                        merged.entry(svc.metadata.name.clone()).or_insert(svc);
+                    }
+                }
+                Err(e) => warn!("error listing services from {}: {}", node, e),
+            }
+        }
+        Ok(merged.into_values().collect())
+    }
+
+    pub async fn get_service(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Service, Box<dyn std::error::Error + Send + Sync>> {
+        self.list_services(ns)
+            .await?
+            .into_iter()
+            .find(|svc| svc.metadata.name == name)
+            .ok_or_else(|| format!("service {}/{} not found", ns, name).into())
+    }
+
+    /// Creates a service on every healthy node, like create_configmap.
+    pub async fn create_service(
+        &self,
+        svc: &Service,
+    ) -> Result<(Service, Vec<String>), Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.create_service(svc).await }).await;
+        any_succeeded(results)
+    }
+
+    /// Deletes a service from every healthy node, like delete_configmap.
+    pub async fn delete_service(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Vec<String>, Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.delete_service(ns, name).await }).await;
+        any_succeeded(results)
+            .map(|(_, failed)| failed)
+            .map_err(|_| format!("service {}/{} not found", ns, name).into())
+    }
+
+    /// One Endpoints per service in the namespace. Each node only reports
+    /// the pods running on it, so the nodes' endpoints are merged; services
+    /// no node reports endpoints for get them from their selector and the
+    /// pods the console sees.
+    pub async fn list_endpoints(
+        &self,
+        ns: &str,
+    ) -> Result<Vec<Endpoints>, Box<dyn std::error::Error + Send + Sync>> {
+        let results = self.on_healthy(|c| async move { c.list_endpoints(ns).await }).await;
+        let mut merged: BTreeMap<String, Endpoints> = BTreeMap::new();
+        for (node, result) in results {
+            let list = match result {
+                Ok(list) => list,
+                Err(e) => {
+                    warn!("error listing endpoints from {}: {}", node, e);
+                    continue;
+                }
+            };
+            for ep in list.items {
+                let into = merged.entry(ep.metadata.name.clone()).or_insert_with(|| Endpoints {
+                    type_meta: ep.type_meta.clone(),
+                    metadata: ep.metadata.clone(),
+                    subsets: Vec::new(),
+                });
+                merge_endpoints(into, ep.subsets, &node);
+            }
+        }
+
+        let services = self.list_services(ns).await?;
+        if services.iter().any(|svc| !merged.contains_key(&svc.metadata.name)) {
+            let pods: Vec<Pod> = self
+                .list_all_pods()
+                .await
+                .unwrap_or_default()
+                .into_iter()
+                .filter(|p| p.metadata.namespace == ns)
+                .collect();
+            for svc in &services {
+                if !merged.contains_key(&svc.metadata.name) {
+                    merged.insert(svc.metadata.name.clone(), endpoints_from_pods(svc, &pods));
+                }
+            }
+        }
+        Ok(merged.into_values().collect())
+    }
+
+    pub async fn get_endpoints(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Endpoints, Box<dyn std::error::Error + Send + Sync>> {
+        self.list_endpoints(ns)
+            .await?
+            .into_iter()
+            .find(|ep| ep.metadata.name == name)
+            .ok_or_else(|| format!("endpoints {}/{} not found", ns, name).into())
+    }
+
+    // Runs `f` against every healthy node at once, for objects each node
+    // keeps its own copy of.
+    async fn on_healthy<T, F, Fut>(&self, f: F) -> Vec<(String, Result<T, Box<dyn std::error::Error + Send + Sync>>)>
+    where
+        F: Fn(Arc<NodeClient>) -> Fut,
+        Fut: std::future::Future<Output = Result<T, Box<dyn std::error::Error + Send + Sync>>>,
+    {
+        let clients: Vec<_> = self.snapshot().await.into_iter().filter(|c| c.is_healthy()).collect();
+        let names: Vec<String> = clients.iter().map(|c| c.name.clone()).collect();
+        let results = futures_util::future::join_all(clients.into_iter().map(f)).await;
+        names.into_iter().zip(results).collect()
+    }
+
     pub async fn get_consistency(
         &self,
     ) -> Result<ConsistencyReport, Box<dyn std::error::Error + Send + Sync>> {
@@ -320,16 +1449,122 @@ impl Aggregator {
         }
     }
 
+    /// Probes every node pair, all sources in parallel.
+    pub async fn connectivity_matrix(&self) -> ConnectivityMatrix {
+        let mut clients = self.snapshot().await;
+        clients.sort_by(|a, b| a.name.cmp(&b.name));
+        let nodes: Vec<String> = clients.iter().map(|c| c.name.clone()).collect();
+        let targets: Vec<(String, String)> = clients
+            .iter()
+            .map(|c| (c.name.clone(), c.host().to_string()))
+            .collect();
+
+        let console = clients.clone();
+        let console_row = tokio::spawn(async move {
+            let mut results = Vec::new();
+            for c in &console {
+                results.push(Some(c.measure_rtt(PROBE_COUNT).await));
+            }
+            ConnectivityRow {
+                source: "console".to_string(),
+                results,
+            }
+        });
+
+        let mut handles = Vec::new();
+        for c in clients {
+            let targets = targets.clone();
+            handles.push(tokio::spawn(async move {
+                let mut results = Vec::new();
+                let mut supported = c.is_healthy();
+                for (name, host) in &targets {
+                    if *name == c.name || !supported {
+                        results.push(None);
+                        continue;
+                    }
+                    match c.probe(host, PROBE_COUNT).await {
+                        Ok(r) => results.push(Some(r)),
+                        Err(e) => {
+                            // Most likely an mkube without the probe endpoint;
+                            // don't keep asking for every peer
+                            warn!("probe from {} to {}: {}", c.name, name, e);
+                            supported = false;
+                            results.push(None);
+                        }
+                    }
+                }
+                ConnectivityRow {
+                    source: c.name.clone(),
+                    results,
+                }
+            }));
+        }
+
+        let mut rows = Vec::new();
+        if let Ok(row) = console_row.await {
+            rows.push(row);
+        }
+        for handle in handles {
+            if let Ok(row) = handle.await {
+                rows.push(row);
+            }
+        }
+
+        ConnectivityMatrix { nodes, rows }
+    }
+
+    /// Device inventory of every healthy node, as (node, device) pairs.
+    /// Nodes whose mkube doesn't expose a device endpoint are skipped.
+    pub async fn list_devices(&self) -> Vec<(String, Device)> {
+        let mut handles = Vec::new();
+        for c in self.snapshot().await {
+            if !c.is_healthy() {
+                continue;
+            }
+            handles.push(tokio::spawn(async move {
+                match c.list_devices().await {
+                    Ok(list) => list.items.into_iter().map(|d| (c.name.clone(), d)).collect(),
+                    Err(e) => {
+                        warn!("error listing devices from {}: {}", c.name, e);
+                        Vec::new()
+                    }
+                }
+            }));
+        }
+
+        let mut devices = Vec::new();
+        for handle in handles {
+            if let Ok(items) = handle.await {
+                devices.extend(items);
+            }
+        }
+        devices.sort_by(|a, b| (&a.0, &a.1.kind, &a.1.path).cmp(&(&b.0, &b.1.kind, &b.1.path)));
+        devices
+    }
+
+    /// The nodes' events and the console's own (pods appearing and
+    /// changing phase, nodes going down, scheduling), oldest first.
     pub async fn list_events(
         &self,
     ) -> Result<Vec<Event>, Box<dyn std::error::Error + Send + Sync>> {
-        match self.first_client().await {
-            Some(c) => Ok(c.list_events().await?.items),
-            None => Ok(Vec::new()),
-        }
+        let mut events = match self.first_client().await {
+            Some(c) => c.list_events().await?.items,
+            None => Vec::new(),
+        };
+        events.extend(self.recorder.list());
+        events.sort_by_key(event_time);
+        Ok(events)
+    }
+
+    /// Just the console's own events, oldest first.
+    pub fn recorded_events(&self) -> Vec<Event> {
+        self.recorder.list()
     }
 
     pub async fn get_cluster_summary(&self) -> ClusterSummary {
+        if let Some(ref r) = self.replica {
+            return replica_summary(&r.snapshot());
+        }
         let clients = self.snapshot().await;
 
         let mut summary = ClusterSummary {
@@ -366,16 +1601,21 @@ impl Aggregator {
     }
 
     pub async fn run_health_checker(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
-        // Initial check
-        self.ping_all().await;
+        let interval = self.schedule.health_interval();
+        self.schedule.register("health-check", "Pings every node", interval, true);
 
-        let mut interval = time::interval(Duration::from_secs(15));
-        interval.tick().await; // skip first immediate tick
+        // Initial check; it only learns the state, so a console restart
+        // doesn't report unreachable nodes as having just gone down
+        self.ping_all(false, false).await;
 
+        let mut next = self.schedule.jittered(interval);
         loop {
             tokio::select! {
-                _ = interval.tick() => {
-                    self.ping_all().await;
+                _ = time::sleep(next) => {
+                    let started = Utc::now();
+                    self.ping_all(true, true).await;
+                    next = self.schedule.jittered(interval);
+                    self.schedule.ran("health-check", started, next);
                 }
                 _ = shutdown.changed() => {
                     info!("health checker shutting down");
@@ -385,12 +1625,75 @@ impl Aggregator {
         }
     }
 
-    async fn ping_all(&self) {
+    // Pings every node, concurrently; with `stagger` each ping starts at
+    // its own point in the schedule's spread.
+    async fn ping_all(&self, notify: bool, stagger: bool) {
         let clients = self.snapshot().await;
-        for c in &clients {
+        let offsets = if stagger {
+            self.schedule.offsets(clients.len())
+        } else {
+            vec![Duration::ZERO; clients.len()]
+        };
+        let pings = clients.iter().zip(offsets).map(|(c, offset)| async move {
+            time::sleep(offset).await;
+            let was = c.is_healthy();
             if let Err(e) = c.ping().await {
                 warn!("health check failed for {}: {}", c.name, e);
             }
+            let healthy = c.is_healthy();
+            if notify && healthy != was {
+                self.publish(ClusterEvent::NodeHealthChanged {
+                    node: c.name.clone(),
+                    healthy,
+                });
+            }
+        });
+        futures_util::future::join_all(pings).await;
+    }
+
+    /// Polls every node's pods and publishes what changed until shutdown.
+    /// A node's pods are diffed only against its own last answer, so an
+    /// unreachable node doesn't look like all its pods were removed, and
+    /// the first answer from a node only seeds its state.
+    pub async fn run_event_source(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut pods: HashMap<String, PodSet> = HashMap::new();
+        self.schedule
+            .register("pod-poll", "Lists every node's pods for cluster events", POD_EVENT_INTERVAL, true);
+        let mut next = Duration::ZERO;
+
+        loop {
+            tokio::select! {
+                _ = time::sleep(next) => {
+                    let started = Utc::now();
+                    let clients = self.snapshot().await;
+                    pods.retain(|node, _| clients.iter().any(|c| c.name == *node));
+                    // One node at a time, staggered over the schedule's spread.
+                    let round = time::Instant::now();
+                    for (c, offset) in clients.iter().zip(self.schedule.offsets(clients.len())) {
+                        time::sleep_until(round + offset).await;
+                        let Ok(list) = c.list_pods().await else { continue };
+                        let mut cached = list.items.clone();
+                        annotate_node(&mut cached, &c.name);
+                        self.remember_pods(&c.name, cached);
+                        let Some(before) = pods.get(&c.name) else {
+                            let (seeded, _) = diff_pods(&c.name, &PodSet::new(), list.items);
+                            pods.insert(c.name.clone(), seeded);
+                            continue;
+                        };
+                        let (after, events) = diff_pods(&c.name, before, list.items);
+                        for e in events {
+                            self.publish(e);
+                        }
+                        pods.insert(c.name.clone(), after);
+                    }
+                    next = self.schedule.jittered(POD_EVENT_INTERVAL);
+                    self.schedule.ran("pod-poll", started, next);
+                }
+                _ = shutdown.changed() => {
+                    info!("cluster event source shutting down");
+                    return;
+                }
+            }
         }
     }
 
@@ -401,4 +1704,273 @@ impl Aggregator {
     pub async fn snapshot_clients(&self) -> Vec<Arc<NodeClient>> {
         self.snapshot().await
     }
+
+    /// Adds a node at runtime, e.g. one just claimed. Returns false if a
+    /// node of that name is already known.
+    pub async fn add_client(&self, client: NodeClient) -> bool {
+        let mut clients = self.clients.write().await;
+        if clients.contains_key(&client.name) {
+            return false;
+        }
+        clients.insert(client.name.clone(), Arc::new(client));
+        true
+    }
+
+    /// Adds a node at runtime or swaps out the client of one already
+    /// known, e.g. when a self-registered node's address changes.
+    pub async fn replace_client(&self, client: NodeClient) {
+        self.clients
+            .write()
+            .await
+            .insert(client.name.clone(), Arc::new(client));
+    }
+
+    /// Stops polling a node added at runtime.
+    pub async fn remove_client(&self, name: &str) {
+        self.clients.write().await.remove(name);
+    }
+}
+
+// When an event last happened; events without a parseable time sort first.
+fn event_time(e: &Event) -> Option<DateTime<FixedOffset>> {
+    e.last_timestamp
+        .as_deref()
+        .or(e.first_timestamp.as_deref())
+        .or(e.metadata.creation_timestamp.as_deref())
+        .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+}
+
+/// Where a paged pod list continues: after pod `after` ("namespace/name")
+/// of node `node`. Keyed by the last pod rather than a count, so pods
+/// coming and going between pages don't shift the rest.
+#[derive(Debug, Clone, serde::Serialize, serde::Deserialize)]
+pub struct PodCursor {
+    pub node: String,
+    pub after: String,
+    /// resourceVersion of the list's first page.
+    pub resource_version: u64,
+}
+
+impl PodCursor {
+    /// The cursor continuing after `pod`.
+    pub fn after(pod: &Pod, resource_version: u64) -> Self {
+        Self {
+            node: pod_node(pod).to_string(),
+            after: pod_key(pod),
+            resource_version,
+        }
+    }
+
+    /// The opaque continue token.
+    pub fn encode(&self) -> String {
+        URL_SAFE_NO_PAD.encode(serde_json::to_vec(self).unwrap_or_default())
+    }
+
+    pub fn decode(token: &str) -> Option<Self> {
+        let data = URL_SAFE_NO_PAD.decode(token).ok()?;
+        serde_json::from_slice(&data).ok()
+    }
+}
+
+fn pod_node(pod: &Pod) -> &str {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get("mkube.io/node"))
+        .map(String::as_str)
+        .unwrap_or_default()
+}
+
+fn pod_key(pod: &Pod) -> String {
+    format!("{}/{}", pod.metadata.namespace, pod.metadata.name)
+}
+
+fn annotate_node(pods: &mut [Pod], node: &str) {
+    for pod in pods {
+        let annotations = pod.metadata.annotations.get_or_insert_with(HashMap::new);
+        annotations.insert("mkube.io/node".to_string(), node.to_string());
+    }
+}
+
+/// Performance score used by weighted scheduling: the node's configured
+/// weight, else one derived from its reported hardware and load.
+// The first success of a fanned-out change and the nodes it failed on;
+// an error only when it failed everywhere.
+fn any_succeeded<T>(
+    results: Vec<(String, Result<T, Box<dyn std::error::Error + Send + Sync>>)>,
+) -> Result<(T, Vec<String>), Box<dyn std::error::Error + Send + Sync>> {
+    let mut done = None;
+    let mut failed = Vec::new();
+    for (node, result) in results {
+        match result {
+            Ok(v) => {
+                done.get_or_insert(v);
+            }
+            Err(e) => failed.push(format!("{}: {}", node, e)),
+        }
+    }
+    match done {
+        Some(v) => Ok((v, failed)),
+        None if failed.is_empty() => Err("no node is reachable".into()),
+        None => Err(failed.join("; ").into()),
+    }
+}
+
+// Adds one node's subsets to a merged Endpoints: addresses join the subset
+// with the same ports, and are marked with the node that reported them.
+fn merge_endpoints(into: &mut Endpoints, subsets: Vec<EndpointSubset>, node: &str) {
+    for mut subset in subsets {
+        for a in subset.addresses.iter_mut().chain(subset.not_ready_addresses.iter_mut()) {
+            a.node_name.get_or_insert_with(|| node.to_string());
+        }
+        let Some(existing) = into.subsets.iter_mut().find(|s| s.ports == subset.ports) else {
+            into.subsets.push(subset);
+            continue;
+        };
+        for a in subset.addresses {
+            if !existing.addresses.iter().any(|e| e.ip == a.ip) {
+                existing.addresses.push(a);
+            }
+        }
+        for a in subset.not_ready_addresses {
+            if !existing.not_ready_addresses.iter().any(|e| e.ip == a.ip) {
+                existing.not_ready_addresses.push(a);
+            }
+        }
+    }
+}
+
+// Endpoints for a service from the pods its selector matches, for nodes
+// that don't keep endpoints themselves. Pods count as ready when running
+// with every container ready.
+fn endpoints_from_pods(svc: &Service, pods: &[Pod]) -> Endpoints {
+    let mut ep = Endpoints {
+        type_meta: TypeMeta {
+            api_version: "v1".to_string(),
+            kind: "Endpoints".to_string(),
+        },
+        metadata: ObjectMeta {
+            name: svc.metadata.name.clone(),
+            namespace: svc.metadata.namespace.clone(),
+            ..Default::default()
+        },
+        subsets: Vec::new(),
+    };
+    // A service without a selector has its endpoints managed by hand
+    if svc.spec.selector.is_empty() {
+        return ep;
+    }
+
+    let backing: Vec<&Pod> = pods
+        .iter()
+        .filter(|p| !p.status.pod_ip.is_empty())
+        .filter(|p| {
+            let labels = p.metadata.labels.as_ref();
+            svc.spec
+                .selector
+                .iter()
+                .all(|(k, v)| labels.and_then(|l| l.get(k)) == Some(v))
+        })
+        .collect();
+    let Some(first) = backing.first() else {
+        return ep;
+    };
+
+    let mut subset = EndpointSubset {
+        ports: svc.spec.ports.iter().map(|sp| endpoint_port(sp, first)).collect(),
+        ..Default::default()
+    };
+    for p in backing {
+        let address = EndpointAddress {
+            ip: p.status.pod_ip.clone(),
+            node_name: Some(p.spec.node_name.clone()).filter(|n| !n.is_empty()),
+            target_ref: Some(ObjectReference {
+                kind: "Pod".to_string(),
+                namespace: p.metadata.namespace.clone(),
+                name: p.metadata.name.clone(),
+            }),
+        };
+        let ready = p.status.phase == "Running" && p.status.container_statuses.iter().all(|cs| cs.ready);
+        if ready {
+            subset.addresses.push(address);
+        } else {
+            subset.not_ready_addresses.push(address);
+        }
+    }
+    ep.subsets.push(subset);
+    ep
+}
+
+// The pod port a service port sends to: its targetPort as a number or a
+// container port name, else the service port itself.
+fn endpoint_port(sp: &ServicePort, pod: &Pod) -> EndpointPort {
+    let port = match sp.target_port {
+        Some(serde_json::Value::Number(ref n)) => n.as_u64().and_then(|n| u16::try_from(n).ok()),
+        Some(serde_json::Value::String(ref name)) => pod
+            .spec
+            .containers
+            .iter()
+            .flat_map(|c| c.ports.iter())
+            .find(|cp| &cp.name == name)
+            .map(|cp| cp.container_port),
+        _ => None,
+    };
+    EndpointPort {
+        name: sp.name.clone(),
+        port: port.unwrap_or(sp.port),
+        protocol: sp.protocol.clone(),
+    }
+}
+
+pub fn node_score(c: &NodeClient, node: Option<&Node>) -> f64 {
+    c.weight
+        .or_else(|| node.map(resources::performance_score))
+        .filter(|w| *w > 0.0)
+        .unwrap_or(1.0)
+}
+
+fn replica_summary(snap: &ReplicaSnapshot) -> ClusterSummary {
+    let mut summary = ClusterSummary {
+        node_count: snap.node_health.len(),
+        pod_count: snap.pods.len(),
+        ..Default::default()
+    };
+    for h in &snap.node_health {
+        let pod_count = snap
+            .pods
+            .iter()
+            .filter(|p| {
+                p.metadata
+                    .annotations
+                    .as_ref()
+                    .and_then(|a| a.get("mkube.io/node"))
+                    == Some(&h.name)
+            })
+            .count();
+        if h.healthy {
+            summary.healthy_nodes += 1;
+        }
+        summary.nodes.push(NodeSummary {
+            name: h.name.clone(),
+            healthy: h.healthy,
+            pod_count,
+            last_ping: h.last_ping,
+        });
+    }
+    summary.running_pods = snap
+        .pods
+        .iter()
+        .filter(|p| p.status.phase == "Running")
+        .count();
+    summary
+}
+
+// Split a `timestamps=true` log line into its RFC 3339 prefix and message.
+fn split_log_timestamp(raw: &str) -> (Option<DateTime<FixedOffset>>, &str) {
+    if let Some((head, rest)) = raw.split_once(' ') {
+        if let Ok(ts) = DateTime::parse_from_rfc3339(head) {
+            return (Some(ts), rest);
+        }
+    }
+    (None, raw)
 }
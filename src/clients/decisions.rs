@@ -0,0 +1,72 @@
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::collections::VecDeque;
+use std::sync::Mutex;
+
+/// Placement decisions kept, newest first.
+const DECISIONS_LEN: usize = 500;
+
+/// Where create_pod put a pod and why: every node it considered, each
+/// one's score or the reason it was ruled out.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PlacementDecision {
+    pub at: DateTime<Utc>,
+    pub namespace: String,
+    pub pod: String,
+    /// least-pods or weighted, or node-name when the pod named its node.
+    pub strategy: String,
+    /// The chosen node; None when no node could take the pod.
+    pub node: Option<String>,
+    /// Why that node, or why none.
+    pub reason: String,
+    pub candidates: Vec<Candidate>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Candidate {
+    pub node: String,
+    /// Load as the strategy measures it: pods, or pods per unit of
+    /// performance score. Lowest wins. None when ruled out.
+    pub score: Option<f64>,
+    /// Why the node couldn't take the pod.
+    pub excluded: Option<String>,
+    /// The caller asked to avoid the node, e.g. a job's earlier pods failed
+    /// there; it is only picked if no other node can take the pod.
+    pub avoided: bool,
+    /// Outside the nodes the pod's namespace is softly pinned to
+    /// (scheduler.namespaces); only picked if none of those can take it.
+    pub unpreferred: bool,
+}
+
+pub struct DecisionLog {
+    entries: Mutex<VecDeque<PlacementDecision>>,
+}
+
+impl DecisionLog {
+    pub fn new() -> Self {
+        Self {
+            entries: Mutex::new(VecDeque::new()),
+        }
+    }
+
+    pub fn record(&self, decision: PlacementDecision) {
+        let mut entries = self.entries.lock().unwrap();
+        entries.push_front(decision);
+        entries.truncate(DECISIONS_LEN);
+    }
+
+    /// Decisions, newest first, optionally only those in `namespace` or for
+    /// one pod.
+    pub fn list(&self, namespace: Option<&str>, pod: Option<&str>) -> Vec<PlacementDecision> {
+        self.entries
+            .lock()
+            .unwrap()
+            .iter()
+            .filter(|d| namespace.is_none_or(|ns| d.namespace == ns))
+            .filter(|d| pod.is_none_or(|p| d.pod == p))
+            .cloned()
+            .collect()
+    }
+}
@@ -0,0 +1,231 @@
+use chrono::Utc;
+use serde::Serialize;
+use std::collections::{HashMap, VecDeque};
+use std::sync::Mutex;
+use tokio::sync::broadcast;
+
+use crate::models::k8s::Pod;
+
+/// Events kept for watches resuming from a resourceVersion.
+const HISTORY_LEN: usize = 1000;
+
+/// A change the aggregator observed while polling nodes. Subsystems that
+/// react to pod and node changes subscribe to these (Aggregator::subscribe)
+/// instead of each polling and diffing on their own; config.event_hooks
+/// forwards them to webhooks.
+#[derive(Debug, Clone, Serialize)]
+#[serde(tag = "type", rename_all = "camelCase")]
+pub enum ClusterEvent {
+    PodAdded {
+        node: String,
+        pod: Pod,
+    },
+    /// Carries the pod as last seen.
+    PodRemoved {
+        node: String,
+        pod: Pod,
+    },
+    PodPhaseChanged {
+        node: String,
+        from: String,
+        pod: Pod,
+    },
+    NodeHealthChanged {
+        node: String,
+        healthy: bool,
+    },
+}
+
+/// Event type names, as used in config.event_hooks filters.
+pub const EVENT_TYPES: &[&str] = &["podAdded", "podRemoved", "podPhaseChanged", "nodeHealthChanged"];
+
+impl ClusterEvent {
+    pub fn event_type(&self) -> &'static str {
+        match self {
+            ClusterEvent::PodAdded { .. } => "podAdded",
+            ClusterEvent::PodRemoved { .. } => "podRemoved",
+            ClusterEvent::PodPhaseChanged { .. } => "podPhaseChanged",
+            ClusterEvent::NodeHealthChanged { .. } => "nodeHealthChanged",
+        }
+    }
+
+    /// Kubernetes watch event type and object, for pod events.
+    pub fn watch_event(&self) -> Option<(&'static str, &Pod)> {
+        match self {
+            ClusterEvent::PodAdded { pod, .. } => Some(("ADDED", pod)),
+            ClusterEvent::PodPhaseChanged { pod, .. } => Some(("MODIFIED", pod)),
+            ClusterEvent::PodRemoved { pod, .. } => Some(("DELETED", pod)),
+            ClusterEvent::NodeHealthChanged { .. } => None,
+        }
+    }
+}
+
+/// An event and the resourceVersion of the cluster state it produced.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct VersionedEvent {
+    pub resource_version: u64,
+    #[serde(flatten)]
+    pub event: ClusterEvent,
+}
+
+/// Broadcasts events and numbers them with a monotonically increasing
+/// resourceVersion, keeping the latest for watches that resume. Versions
+/// start at the console's start time in microseconds, so they keep
+/// increasing across restarts and a version from before a restart reads as
+/// too old to resume rather than as a future one. A pod's own
+/// resourceVersion is the version it last changed at, and a node's the
+/// version its health last flipped at, so the versions the API serves on
+/// objects and on watch events are the same numbers.
+pub struct EventLog {
+    tx: broadcast::Sender<VersionedEvent>,
+    state: Mutex<LogState>,
+}
+
+struct LogState {
+    version: u64,
+    /// What pods unchanged since the console started are at.
+    start: u64,
+    /// Oldest version the history has every later event for.
+    oldest: u64,
+    history: VecDeque<VersionedEvent>,
+    /// Version each pod last changed at, by "namespace/name".
+    pods: HashMap<String, u64>,
+    /// Version each node's health last changed at, by name.
+    nodes: HashMap<String, u64>,
+}
+
+impl EventLog {
+    pub fn new() -> Self {
+        let (tx, _) = broadcast::channel(256);
+        let start = Utc::now().timestamp_micros().max(0) as u64;
+        Self {
+            tx,
+            state: Mutex::new(LogState {
+                version: start,
+                start,
+                oldest: start,
+                history: VecDeque::new(),
+                pods: HashMap::new(),
+                nodes: HashMap::new(),
+            }),
+        }
+    }
+
+    pub fn publish(&self, event: ClusterEvent) {
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        if let Some((kind, pod)) = event.watch_event() {
+            let key = format!("{}/{}", pod.metadata.namespace, pod.metadata.name);
+            if kind == "DELETED" {
+                state.pods.remove(&key);
+            } else {
+                let version = state.version;
+                state.pods.insert(key, version);
+            }
+        }
+        if let ClusterEvent::NodeHealthChanged { ref node, .. } = event {
+            let version = state.version;
+            state.nodes.insert(node.clone(), version);
+        }
+        let versioned = VersionedEvent {
+            resource_version: state.version,
+            event,
+        };
+        state.history.push_back(versioned.clone());
+        while state.history.len() > HISTORY_LEN {
+            if let Some(e) = state.history.pop_front() {
+                state.oldest = e.resource_version;
+            }
+        }
+        // Sent under the lock, so `since` can't miss or repeat an event.
+        let _ = self.tx.send(versioned);
+    }
+
+    pub fn subscribe(&self) -> broadcast::Receiver<VersionedEvent> {
+        self.tx.subscribe()
+    }
+
+    pub fn resource_version(&self) -> u64 {
+        self.state.lock().unwrap().version
+    }
+
+    /// The resourceVersion of pod `key` ("namespace/name").
+    pub fn pod_version(&self, key: &str) -> u64 {
+        let state = self.state.lock().unwrap();
+        state.pods.get(key).copied().unwrap_or(state.start)
+    }
+
+    /// The resourceVersion of node `name`.
+    pub fn node_version(&self, name: &str) -> u64 {
+        let state = self.state.lock().unwrap();
+        state.nodes.get(name).copied().unwrap_or(state.start)
+    }
+
+    /// Gives pod `key` a new version for a change that isn't an event (a
+    /// status write), so writers still holding the old one conflict.
+    pub fn touch_pod(&self, key: &str) -> u64 {
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        let version = state.version;
+        state.pods.insert(key.to_string(), version);
+        version
+    }
+
+    /// Events after `version` and a receiver for the ones that follow, or
+    /// None if the history no longer reaches back that far (or `version`
+    /// was never issued).
+    pub fn since(&self, version: u64) -> Option<(Vec<VersionedEvent>, broadcast::Receiver<VersionedEvent>)> {
+        let state = self.state.lock().unwrap();
+        if version < state.oldest || version > state.version {
+            return None;
+        }
+        let missed = state
+            .history
+            .iter()
+            .filter(|e| e.resource_version > version)
+            .cloned()
+            .collect();
+        Some((missed, self.tx.subscribe()))
+    }
+}
+
+/// Pods on a node as last polled, keyed by "namespace/name".
+pub type PodSet = HashMap<String, Pod>;
+
+/// Events turning `before` into the node's current `pods`, and the pods to
+/// diff the next poll against. Only added and removed pods and phase
+/// changes are events; other status churn isn't.
+pub fn diff_pods(node: &str, before: &PodSet, pods: Vec<Pod>) -> (PodSet, Vec<ClusterEvent>) {
+    let mut after = PodSet::new();
+    let mut events = Vec::new();
+    for mut pod in pods {
+        let key = format!("{}/{}", pod.metadata.namespace, pod.metadata.name);
+        pod.metadata
+            .annotations
+            .get_or_insert_with(HashMap::new)
+            .insert("mkube.io/node".to_string(), node.to_string());
+        match before.get(&key) {
+            None => events.push(ClusterEvent::PodAdded {
+                node: node.to_string(),
+                pod: pod.clone(),
+            }),
+            Some(was) if was.status.phase != pod.status.phase => events.push(ClusterEvent::PodPhaseChanged {
+                node: node.to_string(),
+                from: was.status.phase.clone(),
+                pod: pod.clone(),
+            }),
+            _ => {}
+        }
+        after.insert(key, pod);
+    }
+    for (key, pod) in before {
+        if !after.contains_key(key) {
+            events.push(ClusterEvent::PodRemoved {
+                node: node.to_string(),
+                pod: pod.clone(),
+            });
+        }
+    }
+    (after, events)
+}
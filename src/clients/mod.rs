@@ -1,74 +1,354 @@
 pub mod aggregator;
-
+pub mod decisions;
+pub mod events;
+pub mod recorder;
+pub mod registry;
+pub mod replica;
+pub mod tunnel;
+
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD;
 use chrono::{DateTime, Utc};
 use reqwest::Client;
+use reqwest::header::{AUTHORIZATION, HeaderMap, HeaderValue};
 use serde::de::DeserializeOwned;
-use std::sync::Mutex;
+use serde::{Deserialize, Serialize};
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
 use std::time::Duration;
 
+use self::tunnel::TunnelHub;
+
+use crate::config::{NodeDef, NodeLocation};
+use crate::dns::DnsResult;
+use crate::helpers::url_encode;
 use crate::models::k8s::{
     BMHList, BareMetalHost, ConfigMap, ConfigMapList, ConsistencyReport, Deployment,
-    DeploymentList, EventList, ISCSICdrom, ISCSICdromList, Network, NetworkList, Node,
-    PVCList, PersistentVolumeClaim, Pod, PodList,
+    DeploymentList, DeviceList, EndpointsList, EventList, ISCSICdrom, ISCSICdromList, Network,
+    NetworkList, NetworkStats, Node, PVCList, PersistentVolumeClaim, Pod, PodList, Secret,
+    SecretList, Service, ServiceList,
 };
+use crate::volumes;
 
 pub struct NodeClient {
     pub name: String,
     pub address: String,
+    /// Console-side labels from the node's config, merged into its own.
+    pub labels: HashMap<String, String>,
+    pub capabilities: Vec<String>,
+    /// Configured scheduling weight, if any.
+    pub weight: Option<f64>,
+    /// Configured pool, if any; see pools.rs.
+    pub pool: Option<String>,
+    /// Configured data paths; see volumes.rs.
+    pub data_paths: Vec<String>,
+    /// Per-node override of the node-down grace period.
+    pub failover_grace_secs: Option<i64>,
+    pub location: Option<NodeLocation>,
     http: Client,
+    /// Push mode: requests go over the node's tunnel, and liveness comes
+    /// from its heartbeats.
+    tunnel: Option<Arc<TunnelHub>>,
     state: Mutex<ClientState>,
 }
 
+/// Label carrying the node's configured pool.
+pub const POOL_LABEL: &str = "mkube.io/pool";
+
+/// Prefix of the labels generated from capability hints.
+pub const CAPABILITY_LABEL_PREFIX: &str = "capability.mkube.io/";
+
+const DEFAULT_TIMEOUT_SECS: u64 = 10;
+
 struct ClientState {
+    maintenance: bool,
     healthy: bool,
     last_ping: Option<DateTime<Utc>>,
+    history: VecDeque<HealthSample>,
+}
+
+/// Samples kept per node; one hour at the 15s health check interval.
+const HEALTH_HISTORY_LEN: usize = 240;
+
+/// Result of a single health check, kept for the liveness timeline.
+#[derive(Debug, Clone, Serialize)]
+pub struct HealthSample {
+    pub at: DateTime<Utc>,
+    pub healthy: bool,
+}
+
+/// Outcome of probing one host: ICMP-style latency and loss.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ProbeResult {
+    pub sent: u32,
+    pub received: u32,
+    /// Average round trip of the replies, in milliseconds.
+    #[serde(default)]
+    pub avg_ms: f64,
+}
+
+impl ProbeResult {
+    pub fn loss_percent(&self) -> u32 {
+        if self.sent == 0 {
+            return 100;
+        }
+        (self.sent - self.received.min(self.sent)) * 100 / self.sent
+    }
+}
+
+/// Query options for the pod log endpoint, mirroring the K8s PodLogOptions
+/// fields that mkube nodes understand.
+#[derive(Debug, Clone, Default)]
+pub struct LogOptions {
+    pub container: Option<String>,
+    pub previous: bool,
+    pub timestamps: bool,
+    pub tail_lines: Option<i64>,
+}
+
+impl LogOptions {
+    fn query_string(&self) -> String {
+        let mut params = Vec::new();
+        if let Some(ref c) = self.container {
+            params.push(format!("container={}", url_encode(c)));
+        }
+        if self.previous {
+            params.push("previous=true".to_string());
+        }
+        if self.timestamps {
+            params.push("timestamps=true".to_string());
+        }
+        if let Some(n) = self.tail_lines {
+            params.push(format!("tailLines={}", n));
+        }
+        if params.is_empty() {
+            String::new()
+        } else {
+            format!("?{}", params.join("&"))
+        }
+    }
 }
 
 impl NodeClient {
-    pub fn new(name: String, address: String) -> Self {
+    pub fn new(def: &NodeDef) -> Self {
+        Self::with_headers(def, HeaderMap::new())
+    }
+
+    /// A push-mode client; `def.address` must be the hub's gateway address
+    /// for the node.
+    pub fn tunneled(def: &NodeDef, hub: Arc<TunnelHub>) -> Self {
+        let mut client = Self::with_headers(def, hub.gateway_headers());
+        client.tunnel = Some(hub);
+        client
+    }
+
+    fn with_headers(def: &NodeDef, mut headers: HeaderMap) -> Self {
+        if let Some(ref auth) = def.auth {
+            let value = match (&auth.bearer_token, &auth.username) {
+                (Some(token), _) => Some(format!("Bearer {}", token)),
+                (None, Some(user)) => {
+                    let pass = auth.password.as_deref().unwrap_or("");
+                    Some(format!("Basic {}", STANDARD.encode(format!("{}:{}", user, pass))))
+                }
+                (None, None) => None,
+            };
+            if let Some(mut v) = value.and_then(|v| HeaderValue::from_str(&v).ok()) {
+                v.set_sensitive(true);
+                headers.insert(AUTHORIZATION, v);
+            }
+        }
         let http = Client::builder()
-            .timeout(Duration::from_secs(10))
+            .timeout(Duration::from_secs(def.timeout_secs.unwrap_or(DEFAULT_TIMEOUT_SECS)))
+            .default_headers(headers)
             .build()
             .expect("failed to create HTTP client");
 
         Self {
-            name,
-            address,
+            name: def.name.clone(),
+            address: def.address.clone(),
+            labels: def.labels.clone(),
+            capabilities: def.capabilities.clone(),
+            weight: def.weight,
+            pool: def.pool.clone(),
+            data_paths: def.data_paths.clone(),
+            failover_grace_secs: def.failover_grace_secs,
+            location: def.location.clone(),
             http,
+            tunnel: None,
             state: Mutex::new(ClientState {
+                maintenance: def.maintenance,
                 healthy: true,
                 last_ping: None,
+                history: VecDeque::new(),
             }),
         }
     }
 
+    /// How the console reaches the node: "direct" or "tunnel".
+    pub fn transport(&self) -> &'static str {
+        if self.tunnel.is_some() { "tunnel" } else { "direct" }
+    }
+
     pub async fn ping(&self) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
-        let resp = self
+        if let Some(ref hub) = self.tunnel {
+            let healthy = hub.is_alive(&self.name);
+            self.record_health_seen(healthy, hub.last_seen(&self.name));
+            if !healthy {
+                return Err(format!("node {} has not sent a tunnel heartbeat recently", self.name).into());
+            }
+            return Ok(());
+        }
+
+        let result = self
             .http
             .get(format!("{}/healthz", self.address))
             .send()
-            .await?;
+            .await;
 
-        if resp.status().is_success() {
-            let mut state = self.state.lock().unwrap();
-            state.healthy = true;
-            state.last_ping = Some(Utc::now());
-            Ok(())
-        } else {
-            let mut state = self.state.lock().unwrap();
-            state.healthy = false;
-            Err(format!("node {} health check returned {}", self.name, resp.status()).into())
+        let healthy = matches!(&result, Ok(resp) if resp.status().is_success());
+        self.record_health(healthy);
+
+        let resp = result?;
+        if !resp.status().is_success() {
+            return Err(format!("node {} health check returned {}", self.name, resp.status()).into());
+        }
+        Ok(())
+    }
+
+    fn record_health(&self, healthy: bool) {
+        self.record_health_seen(healthy, healthy.then(Utc::now));
+    }
+
+    // Records a health check; `seen` is when the node was last heard from,
+    // which for push-mode nodes is their last heartbeat.
+    fn record_health_seen(&self, healthy: bool, seen: Option<DateTime<Utc>>) {
+        let now = Utc::now();
+        let mut state = self.state.lock().unwrap();
+        state.healthy = healthy;
+        if seen.is_some() {
+            state.last_ping = seen;
         }
+        state.history.push_back(HealthSample { at: now, healthy });
+        while state.history.len() > HEALTH_HISTORY_LEN {
+            state.history.pop_front();
+        }
+    }
+
+    /// Whether the node is excluded from scheduling new pods.
+    pub fn in_maintenance(&self) -> bool {
+        self.state.lock().unwrap().maintenance
+    }
+
+    pub fn set_maintenance(&self, on: bool) {
+        self.state.lock().unwrap().maintenance = on;
     }
 
     pub fn is_healthy(&self) -> bool {
         self.state.lock().unwrap().healthy
     }
 
+    pub fn health_history(&self) -> Vec<HealthSample> {
+        self.state.lock().unwrap().history.iter().cloned().collect()
+    }
+
+    /// Number of healthy/unhealthy transitions within the trailing window.
+    pub fn flap_score(&self, window: chrono::Duration) -> usize {
+        let cutoff = Utc::now() - window;
+        let state = self.state.lock().unwrap();
+        let recent: Vec<bool> = state
+            .history
+            .iter()
+            .filter(|s| s.at >= cutoff)
+            .map(|s| s.healthy)
+            .collect();
+        recent.windows(2).filter(|w| w[0] != w[1]).count()
+    }
+
+    /// When the current run of failed health checks began, if the node is
+    /// currently unhealthy.
+    pub fn unhealthy_since(&self) -> Option<DateTime<Utc>> {
+        let state = self.state.lock().unwrap();
+        if state.healthy {
+            return None;
+        }
+        state
+            .history
+            .iter()
+            .rev()
+            .take_while(|s| !s.healthy)
+            .last()
+            .map(|s| s.at)
+    }
+
     pub fn last_ping(&self) -> Option<DateTime<Utc>> {
         self.state.lock().unwrap().last_ping
     }
 
+    /// Host part of the node's address, for peers to probe.
+    pub fn host(&self) -> &str {
+        let rest = self.address.split_once("://").map(|(_, r)| r).unwrap_or(&self.address);
+        let authority = rest.split('/').next().unwrap_or(rest);
+        authority
+            .rsplit_once(':')
+            .map(|(h, _)| h)
+            .unwrap_or(authority)
+            .trim_start_matches('[')
+            .trim_end_matches(']')
+    }
+
+    /// Asks the node to probe another host from its side of the network.
+    pub async fn probe(
+        &self,
+        host: &str,
+        count: u32,
+    ) -> Result<ProbeResult, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json(&format!("/api/v1/diagnostics/probe?host={}&count={}", host, count))
+            .await
+    }
+
+    /// Asks the node to resolve a name with its own resolver configuration.
+    pub async fn resolve(
+        &self,
+        name: &str,
+        qtype: &str,
+    ) -> Result<DnsResult, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json(&format!(
+            "/api/v1/diagnostics/dns?name={}&type={}",
+            url_encode(name),
+            qtype
+        ))
+        .await
+    }
+
+    /// Console-side check: round trips of the node's health endpoint.
+    pub async fn measure_rtt(&self, count: u32) -> ProbeResult {
+        let mut result = ProbeResult {
+            sent: count,
+            ..Default::default()
+        };
+        let mut total = 0.0;
+        for _ in 0..count {
+            let start = std::time::Instant::now();
+            let ok = self
+                .http
+                .get(format!("{}/healthz", self.address))
+                .timeout(Duration::from_secs(2))
+                .send()
+                .await
+                .map(|r| r.status().is_success())
+                .unwrap_or(false);
+            if ok {
+                result.received += 1;
+                total += start.elapsed().as_secs_f64() * 1000.0;
+            }
+        }
+        if result.received > 0 {
+            result.avg_ms = total / result.received as f64;
+        }
+        result
+    }
+
     pub async fn list_pods(&self) -> Result<PodList, Box<dyn std::error::Error + Send + Sync>> {
         self.get_json("/api/v1/pods").await
     }
@@ -93,6 +373,21 @@ impl NodeClient {
         .await
     }
 
+    /// Replaces the pod's status, leaving its spec alone.
+    pub async fn update_pod_status(
+        &self,
+        pod: &Pod,
+    ) -> Result<Pod, Box<dyn std::error::Error + Send + Sync>> {
+        self.put_json(
+            &format!(
+                "/api/v1/namespaces/{}/pods/{}/status",
+                pod.metadata.namespace, pod.metadata.name
+            ),
+            pod,
+        )
+        .await
+    }
+
     pub async fn delete_pod(
         &self,
         ns: &str,
@@ -118,12 +413,16 @@ impl NodeClient {
         &self,
         ns: &str,
         name: &str,
+        opts: &LogOptions,
     ) -> Result<String, Box<dyn std::error::Error + Send + Sync>> {
         let resp = self
             .http
             .get(format!(
-                "{}/api/v1/namespaces/{}/pods/{}/log",
-                self.address, ns, name
+                "{}/api/v1/namespaces/{}/pods/{}/log{}",
+                self.address,
+                url_encode(ns),
+                url_encode(name),
+                opts.query_string()
             ))
             .send()
             .await?;
@@ -135,8 +434,54 @@ impl NodeClient {
         Ok(resp.text().await?)
     }
 
+    /// Sends a request that asks to switch protocols (WebSocket or SPDY,
+    /// as kubectl exec, attach and port-forward do) and returns the node's
+    /// response unread: on 101 Switching Protocols the caller takes the
+    /// connection with `upgrade()`. Only direct nodes; the push-mode tunnel
+    /// carries plain requests and responses.
+    pub async fn upgrade(
+        &self,
+        method: reqwest::Method,
+        path_and_query: &str,
+        headers: HeaderMap,
+    ) -> Result<reqwest::Response, Box<dyn std::error::Error + Send + Sync>> {
+        if self.tunnel.is_some() {
+            return Err(format!(
+                "node {} is connected over a push-mode tunnel, which can't carry pod streams",
+                self.name
+            )
+            .into());
+        }
+        let resp = self
+            .http
+            .request(method, format!("{}{}", self.address, path_and_query))
+            .headers(headers)
+            .send()
+            .await?;
+        Ok(resp)
+    }
+
     pub async fn get_node(&self) -> Result<Node, Box<dyn std::error::Error + Send + Sync>> {
-        self.get_json(&format!("/api/v1/nodes/{}", self.name)).await
+        let mut node: Node = self.get_json(&format!("/api/v1/nodes/{}", self.name)).await?;
+        if !self.labels.is_empty() || !self.capabilities.is_empty() || self.pool.is_some() {
+            let labels = node.metadata.labels.get_or_insert_with(HashMap::new);
+            for hint in &self.capabilities {
+                labels.insert(format!("{}{}", CAPABILITY_LABEL_PREFIX, hint), "true".to_string());
+            }
+            if let Some(ref pool) = self.pool {
+                labels.insert(POOL_LABEL.to_string(), pool.clone());
+            }
+            labels.extend(self.labels.clone());
+        }
+        if !self.data_paths.is_empty() {
+            let annotations = node.metadata.annotations.get_or_insert_with(HashMap::new);
+            let mut paths: Vec<String> = volumes::node_data_paths(annotations);
+            paths.extend(self.data_paths.iter().cloned());
+            paths.sort();
+            paths.dedup();
+            annotations.insert(volumes::DATA_PATHS_ANNOTATION.to_string(), paths.join(","));
+        }
+        Ok(node)
     }
 
     pub async fn watch_pods(
@@ -166,7 +511,10 @@ impl NodeClient {
             .http
             .get(format!(
                 "{}/api/v1/namespaces/{}/pods/{}/log?container={}",
-                self.address, ns, pod_name, container_name
+                self.address,
+                url_encode(ns),
+                url_encode(pod_name),
+                url_encode(container_name)
             ))
             .send()
             .await?;
@@ -230,6 +578,20 @@ impl NodeClient {
         .await
     }
 
+    pub async fn create_pvc(
+        &self,
+        pvc: &PersistentVolumeClaim,
+    ) -> Result<PersistentVolumeClaim, Box<dyn std::error::Error + Send + Sync>> {
+        self.post_json(
+            &format!(
+                "/api/v1/namespaces/{}/persistentvolumeclaims",
+                pvc.metadata.namespace
+            ),
+            pvc,
+        )
+        .await
+    }
+
     // --- BareMetalHosts ---
 
     pub async fn list_bmhs(
@@ -285,6 +647,140 @@ impl NodeClient {
             .await
     }
 
+    pub async fn create_configmap(
+        &self,
+        cm: &ConfigMap,
+    ) -> Result<ConfigMap, Box<dyn std::error::Error + Send + Sync>> {
+        self.post_json(
+            &format!("/api/v1/namespaces/{}/configmaps", cm.metadata.namespace),
+            cm,
+        )
+        .await
+    }
+
+    pub async fn delete_configmap(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+        let resp = self
+            .http
+            .delete(format!(
+                "{}/api/v1/namespaces/{}/configmaps/{}",
+                self.address, ns, name
+            ))
+            .send()
+            .await?;
+
+        if resp.status().as_u16() >= 400 {
+            let body = resp.text().await.unwrap_or_default();
+            return Err(format!("delete configmap failed: {}", body).into());
+        }
+        Ok(())
+    }
+
+    // --- Secrets ---
+
+    pub async fn list_secrets(
+        &self,
+        ns: &str,
+    ) -> Result<SecretList, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json(&format!("/api/v1/namespaces/{}/secrets", ns))
+            .await
+    }
+
+    pub async fn get_secret(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<Secret, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json(&format!("/api/v1/namespaces/{}/secrets/{}", ns, name))
+            .await
+    }
+
+    pub async fn create_secret(
+        &self,
+        secret: &Secret,
+    ) -> Result<Secret, Box<dyn std::error::Error + Send + Sync>> {
+        self.post_json(
+            &format!("/api/v1/namespaces/{}/secrets", secret.metadata.namespace),
+            secret,
+        )
+        .await
+    }
+
+    pub async fn delete_secret(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+        let resp = self
+            .http
+            .delete(format!(
+                "{}/api/v1/namespaces/{}/secrets/{}",
+                self.address, ns, name
+            ))
+            .send()
+            .await?;
+
+        if resp.status().as_u16() >= 400 {
+            let body = resp.text().await.unwrap_or_default();
+            return Err(format!("delete secret failed: {}", body).into());
+        }
+        Ok(())
+    }
+
+    // --- Services ---
+
+    pub async fn list_services(
+        &self,
+        ns: &str,
+    ) -> Result<ServiceList, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json(&format!("/api/v1/namespaces/{}/services", ns))
+            .await
+    }
+
+    pub async fn create_service(
+        &self,
+        svc: &Service,
+    ) -> Result<Service, Box<dyn std::error::Error + Send + Sync>> {
+        self.post_json(
+            &format!("/api/v1/namespaces/{}/services", svc.metadata.namespace),
+            svc,
+        )
+        .await
+    }
+
+    pub async fn delete_service(
+        &self,
+        ns: &str,
+        name: &str,
+    ) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+        let resp = self
+            .http
+            .delete(format!(
+                "{}/api/v1/namespaces/{}/services/{}",
+                self.address, ns, name
+            ))
+            .send()
+            .await?;
+
+        if resp.status().as_u16() >= 400 {
+            let body = resp.text().await.unwrap_or_default();
+            return Err(format!("delete service failed: {}", body).into());
+        }
+        Ok(())
+    }
+
+    /// The node's endpoints, which only list the pods running on it.
+    pub async fn list_endpoints(
+        &self,
+        ns: &str,
+    ) -> Result<EndpointsList, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json(&format!("/api/v1/namespaces/{}/endpoints", ns))
+            .await
+    }
+
     // --- Consistency ---
 
     pub async fn get_consistency(
@@ -293,6 +789,22 @@ impl NodeClient {
         self.get_json("/api/v1/consistency").await
     }
 
+    // --- Devices ---
+
+    pub async fn list_devices(
+        &self,
+    ) -> Result<DeviceList, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json("/api/v1/devices").await
+    }
+
+    // --- Network Stats ---
+
+    pub async fn get_network_stats(
+        &self,
+    ) -> Result<NetworkStats, Box<dyn std::error::Error + Send + Sync>> {
+        self.get_json("/api/v1/stats/network").await
+    }
+
     // --- Events ---
 
     pub async fn list_events(
@@ -339,4 +851,25 @@ impl NodeClient {
         }
         Ok(resp.json().await?)
     }
+
+    async fn put_json<T: DeserializeOwned>(
+        &self,
+        path: &str,
+        body: &impl serde::Serialize,
+    ) -> Result<T, Box<dyn std::error::Error + Send + Sync>> {
+        let resp = self
+            .http
+            .put(format!("{}{}", self.address, path))
+            .header("Content-Type", "application/json")
+            .header("Accept", "application/json")
+            .json(body)
+            .send()
+            .await?;
+
+        if resp.status().as_u16() >= 400 {
+            let body = resp.text().await.unwrap_or_default();
+            return Err(format!("PUT {} returned error: {}", path, body).into());
+        }
+        Ok(resp.json().await?)
+    }
 }
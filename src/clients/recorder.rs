@@ -0,0 +1,150 @@
+use chrono::Utc;
+use std::collections::VecDeque;
+use std::sync::Mutex;
+
+use super::decisions::PlacementDecision;
+use super::events::ClusterEvent;
+use crate::models::k8s::{Event, InvolvedObject, ObjectMeta, TypeMeta};
+
+/// Events kept, oldest dropped first.
+const EVENTS_LEN: usize = 1000;
+
+/// Kubernetes-style events for what the console itself sees happen: nodes
+/// going up and down, pods appearing, changing phase and going away, and
+/// where the scheduler put a pod or why it couldn't. An event repeating
+/// one already kept bumps that one's count instead, as in Kubernetes.
+pub struct EventRecorder {
+    state: Mutex<RecorderState>,
+}
+
+struct RecorderState {
+    next_id: u64,
+    events: VecDeque<Event>,
+}
+
+impl EventRecorder {
+    pub fn new() -> Self {
+        Self {
+            state: Mutex::new(RecorderState {
+                next_id: 1,
+                events: VecDeque::new(),
+            }),
+        }
+    }
+
+    /// Records `type_field` (Normal or Warning) event `reason` about an
+    /// object.
+    pub fn record(&self, kind: &str, namespace: &str, name: &str, type_field: &str, reason: &str, message: &str) {
+        let now = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        let mut state = self.state.lock().unwrap();
+        let repeat = state.events.iter_mut().rev().find(|e| {
+            e.involved_object.kind == kind
+                && e.involved_object.namespace == namespace
+                && e.involved_object.name == name
+                && e.reason == reason
+                && e.message == message
+        });
+        if let Some(e) = repeat {
+            e.count += 1;
+            e.last_timestamp = now;
+            return;
+        }
+
+        let id = state.next_id;
+        state.next_id += 1;
+        state.events.push_back(Event {
+            type_meta: TypeMeta {
+                api_version: "v1".to_string(),
+                kind: "Event".to_string(),
+            },
+            metadata: ObjectMeta {
+                name: format!("{}.{:x}", name, id),
+                // Events about nodes live in default, as in Kubernetes
+                namespace: if namespace.is_empty() { "default" } else { namespace }.to_string(),
+                creation_timestamp: now.clone(),
+                ..Default::default()
+            },
+            involved_object: InvolvedObject {
+                kind: kind.to_string(),
+                namespace: namespace.to_string(),
+                name: name.to_string(),
+            },
+            reason: reason.to_string(),
+            message: message.to_string(),
+            type_field: type_field.to_string(),
+            count: 1,
+            first_timestamp: now.clone(),
+            last_timestamp: now,
+        });
+        while state.events.len() > EVENTS_LEN {
+            state.events.pop_front();
+        }
+    }
+
+    pub fn record_cluster_event(&self, event: &ClusterEvent) {
+        match event {
+            ClusterEvent::PodAdded { node, pod } => self.record(
+                "Pod",
+                &pod.metadata.namespace,
+                &pod.metadata.name,
+                "Normal",
+                "Created",
+                &format!("Pod appeared on node {}", node),
+            ),
+            ClusterEvent::PodRemoved { node, pod } => self.record(
+                "Pod",
+                &pod.metadata.namespace,
+                &pod.metadata.name,
+                "Normal",
+                "Removed",
+                &format!("Pod removed from node {}", node),
+            ),
+            ClusterEvent::PodPhaseChanged { node, from, pod } => {
+                let (type_field, reason) = match pod.status.phase.as_str() {
+                    "Running" => ("Normal", "Started"),
+                    "Succeeded" => ("Normal", "Completed"),
+                    "Failed" => ("Warning", "Failed"),
+                    _ => ("Normal", "PhaseChanged"),
+                };
+                self.record(
+                    "Pod",
+                    &pod.metadata.namespace,
+                    &pod.metadata.name,
+                    type_field,
+                    reason,
+                    &format!("Phase {} -> {} on node {}", from, pod.status.phase, node),
+                );
+            }
+            ClusterEvent::NodeHealthChanged { node, healthy: true } => {
+                self.record("Node", "", node, "Normal", "NodeReady", "Node is reachable again")
+            }
+            ClusterEvent::NodeHealthChanged { node, healthy: false } => self.record(
+                "Node",
+                "",
+                node,
+                "Warning",
+                "NodeNotReady",
+                "Node stopped answering health checks",
+            ),
+        }
+    }
+
+    pub fn record_decision(&self, d: &PlacementDecision) {
+        match d.node {
+            Some(ref node) => self.record(
+                "Pod",
+                &d.namespace,
+                &d.pod,
+                "Normal",
+                "Scheduled",
+                &format!("Successfully assigned {}/{} to {}", d.namespace, d.pod, node),
+            ),
+            None => self.record("Pod", &d.namespace, &d.pod, "Warning", "FailedScheduling", &d.reason),
+        }
+    }
+
+    /// Events, oldest first.
+    pub fn list(&self) -> Vec<Event> {
+        self.state.lock().unwrap().events.iter().cloned().collect()
+    }
+}
@@ -0,0 +1,179 @@
+use reqwest::Client;
+use serde::Deserialize;
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+/// Tags can be re-pushed, so cached platform lists are refreshed after this.
+const PLATFORM_CACHE_TTL: Duration = Duration::from_secs(300);
+
+const MANIFEST_ACCEPT: &str = "application/vnd.oci.image.index.v1+json, \
+    application/vnd.docker.distribution.manifest.list.v2+json, \
+    application/vnd.oci.image.manifest.v1+json, \
+    application/vnd.docker.distribution.manifest.v2+json";
+
+/// Reads image platform data from the console's OCI registry, for
+/// architecture-aware scheduling.
+pub struct RegistryClient {
+    base_url: String,
+    /// host[:port] that image references must start with to be looked up here.
+    host: String,
+    http: Client,
+    cache: Mutex<HashMap<String, (Instant, Vec<String>)>>,
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct Manifest {
+    #[serde(default)]
+    manifests: Vec<IndexEntry>,
+    #[serde(default)]
+    config: Option<Descriptor>,
+}
+
+#[derive(Deserialize)]
+struct IndexEntry {
+    #[serde(default)]
+    platform: Option<Platform>,
+}
+
+#[derive(Deserialize)]
+struct Platform {
+    #[serde(default)]
+    architecture: String,
+    #[serde(default)]
+    os: String,
+}
+
+#[derive(Deserialize)]
+struct Descriptor {
+    digest: String,
+}
+
+#[derive(Deserialize)]
+struct ImageConfig {
+    #[serde(default)]
+    architecture: String,
+}
+
+impl RegistryClient {
+    pub fn new(base_url: String) -> Self {
+        let host = base_url
+            .trim_start_matches("http://")
+            .trim_start_matches("https://")
+            .trim_end_matches('/')
+            .to_string();
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+
+        Self {
+            base_url: base_url.trim_end_matches('/').to_string(),
+            host,
+            http,
+            cache: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Architectures an image can run on, normalized to Go/OCI names
+    /// (amd64, arm64, riscv64). None when the image isn't in this registry
+    /// or can't be inspected; callers should then not restrict placement.
+    pub async fn image_architectures(&self, image: &str) -> Option<Vec<String>> {
+        let (repo, reference) = self.split_image(image)?;
+
+        if let Some((at, archs)) = self.cache.lock().unwrap().get(image) {
+            if at.elapsed() < PLATFORM_CACHE_TTL {
+                return Some(archs.clone());
+            }
+        }
+
+        let manifest: Manifest = self
+            .http
+            .get(format!("{}/v2/{}/manifests/{}", self.base_url, repo, reference))
+            .header("Accept", MANIFEST_ACCEPT)
+            .send()
+            .await
+            .ok()?
+            .error_for_status()
+            .ok()?
+            .json()
+            .await
+            .ok()?;
+
+        let mut archs: Vec<String> = if !manifest.manifests.is_empty() {
+            manifest
+                .manifests
+                .iter()
+                .filter_map(|m| m.platform.as_ref())
+                // Attestation manifests are listed as unknown/unknown
+                .filter(|p| p.os != "unknown" && !p.architecture.is_empty())
+                .map(|p| normalize_arch(&p.architecture))
+                .collect()
+        } else {
+            // Single-platform image: the architecture is in the config blob
+            let digest = manifest.config?.digest;
+            let config: ImageConfig = self
+                .http
+                .get(format!("{}/v2/{}/blobs/{}", self.base_url, repo, digest))
+                .send()
+                .await
+                .ok()?
+                .error_for_status()
+                .ok()?
+                .json()
+                .await
+                .ok()?;
+            vec![normalize_arch(&config.architecture)]
+        };
+        archs.sort();
+        archs.dedup();
+
+        self.cache
+            .lock()
+            .unwrap()
+            .insert(image.to_string(), (Instant::now(), archs.clone()));
+        Some(archs)
+    }
+
+    /// Whether the registry has an image's manifest. None when the image
+    /// isn't in this registry or the registry can't be asked.
+    pub async fn image_exists(&self, image: &str) -> Option<bool> {
+        let (repo, reference) = self.split_image(image)?;
+        let resp = self
+            .http
+            .head(format!("{}/v2/{}/manifests/{}", self.base_url, repo, reference))
+            .header("Accept", MANIFEST_ACCEPT)
+            .send()
+            .await
+            .ok()?;
+        match resp.status().as_u16() {
+            404 => Some(false),
+            s if s < 300 => Some(true),
+            _ => None,
+        }
+    }
+
+    // "host:5000/team/app:v1" -> ("team/app", "v1"); digests are kept as-is.
+    fn split_image<'a>(&self, image: &'a str) -> Option<(&'a str, &'a str)> {
+        let rest = image.strip_prefix(&self.host)?.strip_prefix('/')?;
+        if let Some((repo, digest)) = rest.split_once('@') {
+            return Some((repo, digest));
+        }
+        match rest.rsplit_once(':') {
+            Some((repo, tag)) if !tag.contains('/') => Some((repo, tag)),
+            _ => Some((rest, "latest")),
+        }
+    }
+}
+
+/// Maps kernel-style names (uname -m) to the names used in image manifests.
+pub fn normalize_arch(arch: &str) -> String {
+    match arch {
+        "x86_64" => "amd64",
+        "aarch64" => "arm64",
+        "armv7l" | "armhf" => "arm",
+        other => other,
+    }
+    .to_string()
+}
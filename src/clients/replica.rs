@@ -0,0 +1,157 @@
+use chrono::{DateTime, Utc};
+use reqwest::Client;
+use reqwest::header::{AUTHORIZATION, HeaderMap, HeaderName, HeaderValue};
+use serde::{Deserialize, Serialize};
+use std::sync::Mutex;
+use std::time::Duration;
+use tracing::{info, warn};
+
+use crate::config::FollowConfig;
+use crate::models::k8s::{Node, Pod};
+
+/// Cluster state replicated from an upstream console. The upstream sends a
+/// full snapshot only when something changed, so an idle cluster costs a
+/// keepalive every few seconds over the WAN.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ReplicaSnapshot {
+    pub taken_at: Option<DateTime<Utc>>,
+    pub pods: Vec<Pod>,
+    pub nodes: Vec<Node>,
+    pub node_health: Vec<ReplicaNodeHealth>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ReplicaNodeHealth {
+    pub name: String,
+    pub healthy: bool,
+    pub last_ping: Option<DateTime<Utc>>,
+}
+
+/// Follower side of console replication: keeps the latest snapshot from the
+/// upstream console's replication stream.
+pub struct ReplicaCache {
+    pub upstream: String,
+    http: Client,
+    state: Mutex<ReplicaState>,
+}
+
+#[derive(Default)]
+struct ReplicaState {
+    connected: bool,
+    snapshot: ReplicaSnapshot,
+}
+
+impl ReplicaCache {
+    /// Fails on a header or token that can't be sent.
+    pub fn new(cfg: &FollowConfig) -> Result<Self, String> {
+        let mut headers = HeaderMap::new();
+        for (name, value) in &cfg.headers {
+            let header = HeaderName::from_bytes(name.as_bytes()).map_err(|e| format!("header {:?}: {}", name, e))?;
+            let mut value = HeaderValue::from_str(value).map_err(|e| format!("header {:?}: {}", name, e))?;
+            value.set_sensitive(true);
+            headers.insert(header, value);
+        }
+        if let Some(ref token) = cfg.bearer_token {
+            let mut value =
+                HeaderValue::from_str(&format!("Bearer {}", token)).map_err(|e| format!("bearer_token: {}", e))?;
+            value.set_sensitive(true);
+            headers.insert(AUTHORIZATION, value);
+        }
+        // No overall timeout: the stream is long-lived.
+        let http = Client::builder()
+            .connect_timeout(Duration::from_secs(10))
+            .default_headers(headers)
+            .build()
+            .expect("failed to create HTTP client");
+
+        Ok(Self {
+            upstream: cfg.upstream_url.trim_end_matches('/').to_string(),
+            http,
+            state: Mutex::new(ReplicaState::default()),
+        })
+    }
+
+    pub fn is_connected(&self) -> bool {
+        self.state.lock().unwrap().connected
+    }
+
+    pub fn snapshot(&self) -> ReplicaSnapshot {
+        self.state.lock().unwrap().snapshot.clone()
+    }
+
+    pub async fn run(&self, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        loop {
+            tokio::select! {
+                result = self.follow() => {
+                    self.state.lock().unwrap().connected = false;
+                    if let Err(e) = result {
+                        warn!("replication stream from {}: {}", self.upstream, e);
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("replica follower shutting down");
+                    return;
+                }
+            }
+            // Back off before reconnecting
+            tokio::select! {
+                _ = tokio::time::sleep(Duration::from_secs(5)) => {}
+                _ = shutdown.changed() => return,
+            }
+        }
+    }
+
+    // Reads the upstream SSE stream until it ends or errors.
+    async fn follow(&self) -> Result<(), Box<dyn std::error::Error + Send + Sync>> {
+        let mut resp = self
+            .http
+            .get(format!("{}/api/v1/replication/stream", self.upstream))
+            .header("Accept", "text/event-stream")
+            .send()
+            .await?;
+
+        if resp.status().as_u16() >= 400 {
+            let body = resp.text().await.unwrap_or_default();
+            return Err(format!("replication stream failed: {}", body).into());
+        }
+
+        info!("following console at {}", self.upstream);
+        self.state.lock().unwrap().connected = true;
+
+        // Raw bytes until a frame is whole: a chunk can end inside a
+        // multi-byte character.
+        let mut buf: Vec<u8> = Vec::new();
+        while let Some(chunk) = resp.chunk().await? {
+            buf.extend_from_slice(&chunk);
+            while let Some(end) = buf.windows(2).position(|w| w == b"\n\n") {
+                let frame: Vec<u8> = buf.drain(..end + 2).collect();
+                self.apply_frame(&String::from_utf8_lossy(&frame));
+            }
+        }
+        Err("upstream closed the stream".into())
+    }
+
+    fn apply_frame(&self, frame: &str) {
+        let mut event = "";
+        let mut data = String::new();
+        for line in frame.lines() {
+            if let Some(v) = line.strip_prefix("event:") {
+                event = v.trim();
+            } else if let Some(v) = line.strip_prefix("data:") {
+                if !data.is_empty() {
+                    data.push('\n');
+                }
+                data.push_str(v.strip_prefix(' ').unwrap_or(v));
+            }
+        }
+        if event != "snapshot" {
+            return;
+        }
+        match serde_json::from_str::<ReplicaSnapshot>(&data) {
+            Ok(snap) => self.state.lock().unwrap().snapshot = snap,
+            Err(e) => warn!("bad replication snapshot from {}: {}", self.upstream, e),
+        }
+    }
+}
@@ -0,0 +1,446 @@
+use axum::{
+    Router,
+    body::Body,
+    extract::ws::{Message, WebSocket},
+    extract::{Path, Request, State},
+    http::{HeaderMap, HeaderName, HeaderValue, StatusCode, header},
+    response::{IntoResponse, Response},
+    routing::any,
+};
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD;
+use chrono::{DateTime, Utc};
+use futures_util::{SinkExt, StreamExt};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::net::SocketAddr;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use tokio::net::TcpListener;
+use tokio::sync::mpsc;
+use tracing::{info, warn};
+
+use crate::claims::{new_token, token_hash};
+
+// Push-mode nodes can't be reached by the console (NAT, CGNAT), so they
+// dial in instead: the agent opens a WebSocket to
+// /api/console/v1alpha1/nodes/connect with its agent token and keeps it
+// open. The console's NodeClient for such a node talks plain HTTP to a
+// loopback gateway at http://127.0.0.1:<port>/<node>, which forwards each
+// request over the node's socket as JSON frames:
+//
+//     console -> agent  {"type":"request","id":1,"method":"GET","path":"/api/v1/pods","headers":[...],"body":"<base64>"}
+//                       {"type":"cancel","id":1}
+//     agent -> console  {"type":"head","id":1,"status":200,"headers":[...]}
+//                       {"type":"data","id":1,"body":"<base64>"}
+//                       {"type":"end","id":1}
+//                       {"type":"error","id":1,"message":"..."}
+//                       {"type":"heartbeat"}
+//
+// Responses stream, so watches work; a cancel tells the agent the console
+// stopped reading. A response whose reader falls RESPONSE_BUFFER frames
+// behind is cancelled rather than stall the socket, which carries every
+// other request and the heartbeats. With no socket open the gateway
+// answers 502. Other local processes can reach the gateway too, so it
+// only serves requests carrying GATEWAY_HEADER with this process's secret.
+//
+// Liveness comes from the socket rather than HTTP pings: the agent sends a
+// heartbeat every 15s, and any frame or pong from it counts as a sign of
+// life. A node silent for HEARTBEAT_TIMEOUT_SECS is down, and its socket
+// is dropped so the agent reconnects.
+
+/// How long the gateway waits for a node to start answering.
+const HEAD_TIMEOUT: Duration = Duration::from_secs(60);
+
+/// Largest request body forwarded to a node.
+const MAX_REQUEST_BODY: usize = 16 << 20;
+
+/// Frames of a response buffered for a slow reader before it is cancelled.
+const RESPONSE_BUFFER: usize = 64;
+
+/// Header carrying the gateway secret; never forwarded to nodes.
+const GATEWAY_HEADER: &str = "x-mkube-gateway-secret";
+
+/// Interval of WebSocket pings, which keep NAT mappings alive.
+const PING_INTERVAL: Duration = Duration::from_secs(15);
+
+/// Seconds of silence after which a push-mode node counts as down; three
+/// missed heartbeats.
+const HEARTBEAT_TIMEOUT_SECS: i64 = 45;
+
+#[derive(Debug, Serialize, Deserialize)]
+#[serde(tag = "type", rename_all = "lowercase")]
+enum Frame {
+    Request {
+        id: u64,
+        method: String,
+        path: String,
+        #[serde(default)]
+        headers: Vec<(String, String)>,
+        #[serde(default, skip_serializing_if = "String::is_empty")]
+        body: String,
+    },
+    Head {
+        id: u64,
+        status: u16,
+        #[serde(default)]
+        headers: Vec<(String, String)>,
+    },
+    Data {
+        id: u64,
+        body: String,
+    },
+    End {
+        id: u64,
+    },
+    Error {
+        id: u64,
+        message: String,
+    },
+    Cancel {
+        id: u64,
+    },
+    Heartbeat,
+}
+
+impl Frame {
+    fn id(&self) -> u64 {
+        match *self {
+            Frame::Request { id, .. }
+            | Frame::Head { id, .. }
+            | Frame::Data { id, .. }
+            | Frame::End { id }
+            | Frame::Error { id, .. }
+            | Frame::Cancel { id } => id,
+            Frame::Heartbeat => 0,
+        }
+    }
+}
+
+/// One node's open socket.
+struct Session {
+    connected_at: DateTime<Utc>,
+    last_seen: Mutex<DateTime<Utc>>,
+    remote: String,
+    out: mpsc::Sender<Frame>,
+    pending: Mutex<HashMap<u64, mpsc::Sender<Frame>>>,
+    next_id: AtomicU64,
+}
+
+/// A connected push-mode node, for listing.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct TunnelStatus {
+    pub node: String,
+    pub remote: String,
+    pub connected_at: DateTime<Utc>,
+    pub last_seen: DateTime<Utc>,
+    /// Requests in flight over the socket.
+    pub in_flight: usize,
+}
+
+/// Sockets of push-mode nodes and the loopback gateway that routes node
+/// API calls over them.
+pub struct TunnelHub {
+    gateway: SocketAddr,
+    /// Random per process; see GATEWAY_HEADER.
+    secret: String,
+    listener: Mutex<Option<TcpListener>>,
+    sessions: Mutex<HashMap<String, Arc<Session>>>,
+}
+
+impl TunnelHub {
+    /// Binds the gateway on an ephemeral loopback port.
+    pub async fn bind() -> std::io::Result<Self> {
+        let listener = TcpListener::bind("127.0.0.1:0").await?;
+        Ok(Self {
+            gateway: listener.local_addr()?,
+            secret: new_token(),
+            listener: Mutex::new(Some(listener)),
+            sessions: Mutex::new(HashMap::new()),
+        })
+    }
+
+    /// Address a NodeClient reaches `node` at.
+    pub fn address(&self, node: &str) -> String {
+        format!("http://{}/{}", self.gateway, node)
+    }
+
+    /// Headers a NodeClient sends so the gateway serves it.
+    pub fn gateway_headers(&self) -> HeaderMap {
+        let mut headers = HeaderMap::new();
+        if let Ok(mut v) = HeaderValue::from_str(&self.secret) {
+            v.set_sensitive(true);
+            headers.insert(GATEWAY_HEADER, v);
+        }
+        headers
+    }
+
+    /// When `node` was last heard from over its current socket.
+    pub fn last_seen(&self, node: &str) -> Option<DateTime<Utc>> {
+        self.session(node).map(|s| *s.last_seen.lock().unwrap())
+    }
+
+    /// Whether `node` is connected and has been heard from recently.
+    pub fn is_alive(&self, node: &str) -> bool {
+        self.session(node).is_some_and(|s| s.alive())
+    }
+
+    pub fn list(&self) -> Vec<TunnelStatus> {
+        let mut out: Vec<TunnelStatus> = self
+            .sessions
+            .lock()
+            .unwrap()
+            .iter()
+            .map(|(node, s)| TunnelStatus {
+                node: node.clone(),
+                remote: s.remote.clone(),
+                connected_at: s.connected_at,
+                last_seen: *s.last_seen.lock().unwrap(),
+                in_flight: s.pending.lock().unwrap().len(),
+            })
+            .collect();
+        out.sort_by(|a, b| a.node.cmp(&b.node));
+        out
+    }
+
+    /// Serves the gateway until shutdown.
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let Some(listener) = self.listener.lock().unwrap().take() else {
+            return;
+        };
+        let router = Router::new()
+            .route("/{node}/{*path}", any(forward))
+            .with_state(self.clone());
+        info!("node tunnel gateway listening on {}", self.gateway);
+        let served = axum::serve(listener, router)
+            .with_graceful_shutdown(async move {
+                let _ = shutdown.changed().await;
+            })
+            .await;
+        if let Err(e) = served {
+            warn!("node tunnel gateway: {}", e);
+        }
+    }
+
+    /// Carries requests for `node` over `socket` until either side closes
+    /// it. A node reconnecting replaces its previous socket.
+    pub async fn attach(&self, node: String, remote: String, socket: WebSocket) {
+        let (mut sink, mut stream) = socket.split();
+        let (out, mut outgoing) = mpsc::channel::<Frame>(64);
+        let now = Utc::now();
+        let session = Arc::new(Session {
+            connected_at: now,
+            last_seen: Mutex::new(now),
+            remote: remote.clone(),
+            out,
+            pending: Mutex::new(HashMap::new()),
+            next_id: AtomicU64::new(1),
+        });
+        self.sessions.lock().unwrap().insert(node.clone(), session.clone());
+        info!("node {} connected from {}", node, remote);
+
+        let mut ping = tokio::time::interval(PING_INTERVAL);
+        loop {
+            tokio::select! {
+                frame = outgoing.recv() => {
+                    let Some(frame) = frame else { break };
+                    let Ok(text) = serde_json::to_string(&frame) else { continue };
+                    if sink.send(Message::Text(text.into())).await.is_err() {
+                        break;
+                    }
+                }
+                msg = stream.next() => {
+                    if let Some(Ok(_)) = msg {
+                        *session.last_seen.lock().unwrap() = Utc::now();
+                    }
+                    match msg {
+                        Some(Ok(Message::Text(text))) => match serde_json::from_str::<Frame>(text.as_str()) {
+                            Ok(Frame::Heartbeat) => {}
+                            Ok(frame) => session.deliver(frame),
+                            Err(e) => warn!("node {} sent a bad tunnel frame: {}", node, e),
+                        },
+                        Some(Ok(Message::Close(_))) | Some(Err(_)) | None => break,
+                        Some(Ok(_)) => {}
+                    }
+                }
+                _ = ping.tick() => {
+                    if !session.alive() {
+                        warn!("node {} missed its heartbeats; dropping its tunnel", node);
+                        break;
+                    }
+                    if sink.send(Message::Ping(Default::default())).await.is_err() {
+                        break;
+                    }
+                }
+            }
+        }
+
+        // Dropping the pending senders ends every response in flight.
+        session.pending.lock().unwrap().clear();
+        let mut sessions = self.sessions.lock().unwrap();
+        if sessions.get(&node).is_some_and(|s| Arc::ptr_eq(s, &session)) {
+            sessions.remove(&node);
+        }
+        info!("node {} disconnected", node);
+    }
+
+    fn session(&self, node: &str) -> Option<Arc<Session>> {
+        self.sessions.lock().unwrap().get(node).cloned()
+    }
+}
+
+impl Session {
+    fn alive(&self) -> bool {
+        Utc::now() - *self.last_seen.lock().unwrap() < chrono::Duration::seconds(HEARTBEAT_TIMEOUT_SECS)
+    }
+
+    // Hands a frame to the response it belongs to without waiting, as this
+    // runs in the socket's read loop. A reader that went away or fell too
+    // far behind loses its response: dropping its sender ends the stream
+    // with an error, and the node is told to stop sending.
+    fn deliver(&self, frame: Frame) {
+        let id = frame.id();
+        let done = matches!(frame, Frame::End { .. } | Frame::Error { .. });
+        let tx = {
+            let mut pending = self.pending.lock().unwrap();
+            if done { pending.remove(&id) } else { pending.get(&id).cloned() }
+        };
+        let Some(tx) = tx else { return };
+        if let Err(e) = tx.try_send(frame) {
+            if matches!(e, mpsc::error::TrySendError::Full(_)) {
+                warn!("tunnel request {} stalled; cancelling it", id);
+            }
+            self.pending.lock().unwrap().remove(&id);
+            let _ = self.out.try_send(Frame::Cancel { id });
+        }
+    }
+}
+
+/// A response being read off a socket; tells the node to stop if dropped
+/// before the end.
+struct Inflight {
+    id: u64,
+    session: Arc<Session>,
+    frames: mpsc::Receiver<Frame>,
+    done: bool,
+}
+
+impl Drop for Inflight {
+    fn drop(&mut self) {
+        if !self.done && self.session.pending.lock().unwrap().remove(&self.id).is_some() {
+            let _ = self.session.out.try_send(Frame::Cancel { id: self.id });
+        }
+    }
+}
+
+async fn forward(
+    State(hub): State<Arc<TunnelHub>>,
+    Path((node, path)): Path<(String, String)>,
+    req: Request,
+) -> Response {
+    let presented = req.headers().get(GATEWAY_HEADER).and_then(|v| v.to_str().ok());
+    if presented.is_none_or(|p| token_hash(p) != token_hash(&hub.secret)) {
+        return (StatusCode::FORBIDDEN, "the gateway is for the console's own node clients").into_response();
+    }
+    let Some(session) = hub.session(&node) else {
+        return (StatusCode::BAD_GATEWAY, format!("node {} is not connected", node)).into_response();
+    };
+
+    let method = req.method().to_string();
+    let path = match req.uri().query() {
+        Some(q) => format!("/{}?{}", path, q),
+        None => format!("/{}", path),
+    };
+    let headers = req
+        .headers()
+        .iter()
+        .filter(|(k, _)| **k != header::HOST && k.as_str() != GATEWAY_HEADER && !is_hop_by_hop(k))
+        .filter_map(|(k, v)| Some((k.to_string(), v.to_str().ok()?.to_string())))
+        .collect();
+    let body = match axum::body::to_bytes(req.into_body(), MAX_REQUEST_BODY).await {
+        Ok(b) => b,
+        Err(e) => return (StatusCode::PAYLOAD_TOO_LARGE, e.to_string()).into_response(),
+    };
+
+    let id = session.next_id.fetch_add(1, Ordering::Relaxed);
+    let (tx, frames) = mpsc::channel(RESPONSE_BUFFER);
+    session.pending.lock().unwrap().insert(id, tx);
+    let mut inflight = Inflight {
+        id,
+        session: session.clone(),
+        frames,
+        done: false,
+    };
+    let request = Frame::Request {
+        id,
+        method,
+        path,
+        headers,
+        body: if body.is_empty() { String::new() } else { STANDARD.encode(&body) },
+    };
+    if session.out.send(request).await.is_err() {
+        return (StatusCode::BAD_GATEWAY, format!("node {} disconnected", node)).into_response();
+    }
+
+    let head = match tokio::time::timeout(HEAD_TIMEOUT, inflight.frames.recv()).await {
+        Ok(Some(Frame::Head { status, headers, .. })) => (status, headers),
+        Ok(Some(Frame::Error { message, .. })) => {
+            inflight.done = true;
+            return (StatusCode::BAD_GATEWAY, message).into_response();
+        }
+        Ok(Some(Frame::End { .. })) => {
+            inflight.done = true;
+            return StatusCode::NO_CONTENT.into_response();
+        }
+        Ok(_) => return (StatusCode::BAD_GATEWAY, format!("node {} disconnected", node)).into_response(),
+        Err(_) => {
+            return (StatusCode::GATEWAY_TIMEOUT, format!("node {} did not answer", node)).into_response();
+        }
+    };
+
+    let body = futures_util::stream::unfold(inflight, |mut inflight| async move {
+        if inflight.done {
+            return None;
+        }
+        // Without its sender the response was cancelled or the node left
+        let Some(frame) = inflight.frames.recv().await else {
+            inflight.done = true;
+            let cut = std::io::Error::other("the node's tunnel stopped mid-response");
+            return Some((Err(cut), inflight));
+        };
+        match frame {
+            Frame::Data { body, .. } => {
+                let chunk = STANDARD.decode(body).map_err(std::io::Error::other);
+                Some((chunk, inflight))
+            }
+            Frame::Error { message, .. } => {
+                inflight.done = true;
+                Some((Err(std::io::Error::other(message)), inflight))
+            }
+            _ => {
+                inflight.done = true;
+                None
+            }
+        }
+    });
+
+    let mut resp = Response::new(Body::from_stream(body));
+    *resp.status_mut() = StatusCode::from_u16(head.0).unwrap_or(StatusCode::BAD_GATEWAY);
+    for (k, v) in head.1 {
+        let (Ok(k), Ok(v)) = (HeaderName::try_from(k), HeaderValue::try_from(v)) else {
+            continue;
+        };
+        if !is_hop_by_hop(&k) {
+            resp.headers_mut().append(k, v);
+        }
+    }
+    resp
+}
+
+// Headers that describe the connection rather than the message, and so
+// don't survive the trip over the socket.
+fn is_hop_by_hop(name: &HeaderName) -> bool {
+    *name == header::CONNECTION || *name == header::CONTENT_LENGTH || *name == header::TRANSFER_ENCODING
+}
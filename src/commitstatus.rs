@@ -0,0 +1,112 @@
+use reqwest::Client;
+use serde::Serialize;
+use std::time::Duration;
+use tracing::warn;
+
+use crate::config::{CommitStatusConfig, ForgeProvider};
+
+/// Longest description GitHub accepts.
+const MAX_DESCRIPTION: usize = 140;
+
+#[derive(Serialize)]
+struct StatusBody<'a> {
+    state: &'a str,
+    description: String,
+    context: &'a str,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    target_url: Option<&'a str>,
+}
+
+/// State a commit status is set to.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CommitState {
+    Success,
+    /// A dry run found changes that are not applied yet.
+    Pending,
+    Failure,
+}
+
+impl CommitState {
+    fn as_str(self) -> &'static str {
+        match self {
+            CommitState::Success => "success",
+            CommitState::Pending => "pending",
+            CommitState::Failure => "failure",
+        }
+    }
+}
+
+/// Reports the outcome of GitOps applies to a forge's commit status API,
+/// so the repo shows whether the console applied each commit. GitHub and
+/// Gitea share the API, so only the root and the token scheme differ.
+/// Delivery runs in the background and failures are only logged.
+pub struct CommitStatusReporter {
+    http: Client,
+    api_url: String,
+    auth: String,
+    context: String,
+    target_url: Option<String>,
+}
+
+impl CommitStatusReporter {
+    pub fn new(cfg: &CommitStatusConfig, cluster: &str) -> Self {
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        let (default_url, scheme) = match cfg.provider {
+            ForgeProvider::Github => ("https://api.github.com", "Bearer"),
+            ForgeProvider::Gitea => ("", "token"),
+        };
+        Self {
+            http,
+            api_url: cfg
+                .api_url
+                .as_deref()
+                .unwrap_or(default_url)
+                .trim_end_matches('/')
+                .to_string(),
+            auth: format!("{} {}", scheme, cfg.token),
+            context: cfg
+                .context
+                .clone()
+                .unwrap_or_else(|| format!("mkube-console/{}", cluster)),
+            target_url: cfg.target_url.clone(),
+        }
+    }
+
+    /// Sets `commit`'s status in `repo` ("owner/name") to `state`.
+    pub fn report(&self, repo: &str, commit: &str, state: CommitState, description: &str) {
+        let body = StatusBody {
+            state: state.as_str(),
+            description: description.chars().take(MAX_DESCRIPTION).collect(),
+            context: &self.context,
+            target_url: self.target_url.as_deref(),
+        };
+        let req = self
+            .http
+            .post(format!("{}/repos/{}/statuses/{}", self.api_url, repo, commit))
+            .header("Authorization", &self.auth)
+            .header("Accept", "application/json")
+            .header("User-Agent", "mkube-console")
+            .json(&body);
+        let what = format!("{}@{}", repo, commit);
+        tokio::spawn(async move {
+            match req.send().await {
+                Ok(r) if !r.status().is_success() => warn!("commit status for {}: {}", what, r.status()),
+                Err(e) => warn!("commit status for {}: {}", what, e),
+                Ok(_) => {}
+            }
+        });
+    }
+}
+
+/// Whether `repo` looks like "owner/name" and `commit` like a SHA, so
+/// neither can reach outside the statuses endpoint.
+pub fn valid_target(repo: &str, commit: &str) -> bool {
+    let part = |s: &str| {
+        !s.is_empty() && s != "." && s != ".." && s.chars().all(|c| c.is_ascii_alphanumeric() || "-_.".contains(c))
+    };
+    let repo_ok = matches!(repo.split_once('/'), Some((owner, name)) if part(owner) && part(name));
+    repo_ok && (7..=64).contains(&commit.len()) && commit.chars().all(|c| c.is_ascii_hexdigit())
+}
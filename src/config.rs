@@ -1,6 +1,12 @@
-use serde::Deserialize;
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
 use std::path::Path;
 
+use crate::clients::events::EVENT_TYPES;
+use crate::controllers::alert_rules;
+use crate::custom::FIELD_TYPES;
+use crate::ipam::Subnet;
+
 #[derive(Debug, Clone, Deserialize)]
 pub struct Config {
     #[serde(default = "default_cluster_name")]
@@ -19,12 +25,223 @@ pub struct Config {
     pub logs_url: Option<String>,
     #[serde(default)]
     pub networks: Vec<NetworkDef>,
+    #[serde(default)]
+    pub data_dir: Option<String>,
+    #[serde(default)]
+    pub restart_capture: RestartCaptureConfig,
+    /// Restarting opted-in pods when their configmaps or secrets change.
+    #[serde(default)]
+    pub config_rollout: ConfigRolloutConfig,
+    #[serde(default)]
+    pub node_health: NodeHealthConfig,
+    /// Timing of background work that touches every node.
+    #[serde(default)]
+    pub schedule: ScheduleConfig,
+    /// Bounds on what the Kubernetes-style API sends back.
+    #[serde(default)]
+    pub api: ApiConfig,
+    /// Active/standby high availability; omit to run a single console.
+    #[serde(default)]
+    pub ha: Option<HaConfig>,
+    /// The console's own pod, when it runs inside the cluster it manages.
+    /// Read from the POD_NAMESPACE and POD_NAME environment variables when
+    /// omitted.
+    #[serde(default)]
+    pub self_pod: Option<SelfPodConfig>,
+    /// Where to look for new console releases; omit to disable updates.
+    #[serde(default)]
+    pub update: Option<UpdateConfig>,
+    /// Run as a read replica of another console instead of polling nodes.
+    #[serde(default)]
+    pub follow: Option<FollowConfig>,
+    /// Publish the console through Tailscale or an SSH reverse tunnel.
+    #[serde(default)]
+    pub tunnel: Option<TunnelConfig>,
+    /// Web Push notifications for firing alerts.
+    #[serde(default)]
+    pub push: Option<PushConfig>,
+    /// Per-namespace resource quotas shown on the namespace overview.
+    #[serde(default)]
+    pub namespace_quotas: HashMap<String, NamespaceQuota>,
+    /// Saturation alerting on node network interfaces.
+    #[serde(default)]
+    pub bandwidth: BandwidthConfig,
+    /// Threshold rules over node and pod state, raised as alerts. Re-read
+    /// whenever the config file changes, without a restart.
+    #[serde(default)]
+    pub alert_rules: Vec<AlertRuleConfig>,
+    /// Address ranges set aside outside mkube, flagged on the IPAM page.
+    #[serde(default)]
+    pub ipam: IpamConfig,
+    /// How pods without a nodeName are placed.
+    #[serde(default)]
+    pub scheduler: SchedulerConfig,
+    /// Requests and limits injected into containers that don't set them,
+    /// keyed by namespace; "*" covers namespaces without their own entry.
+    #[serde(default)]
+    pub resource_defaults: HashMap<String, ResourceDefaults>,
+    /// Environment variables (proxy settings, registry mirrors, TZ, ...)
+    /// injected into every pod at admission.
+    #[serde(default)]
+    pub env_injection: EnvInjectionConfig,
+    /// Encryption of console-side state files at rest.
+    #[serde(default)]
+    pub encryption: Option<EncryptionConfig>,
+    /// Backends for secret://<provider>/<path>#<key> references in pod
+    /// specs, resolved at deploy time.
+    #[serde(default)]
+    pub secret_providers: SecretProvidersConfig,
+    /// Sign-in page of the authenticating proxy in front of the console,
+    /// linked from access-denied pages.
+    #[serde(default)]
+    pub login_url: Option<String>,
+    /// Role-based access by proxy-authenticated user; omit to let everyone
+    /// do everything.
+    #[serde(default)]
+    pub access: Option<AccessConfig>,
+    /// Reverse proxies in front of the console, as addresses or CIDRs. A
+    /// request from one counts as coming from the client it names in
+    /// X-Forwarded-For, e.g. for the sign-in throttle.
+    #[serde(default)]
+    pub trusted_proxies: Vec<String>,
+    /// Where to send reports of panics in request handlers, besides the log.
+    #[serde(default)]
+    pub error_reporting: Option<ErrorReportingConfig>,
+    /// Anonymized usage reports; off unless an endpoint is configured.
+    #[serde(default)]
+    pub telemetry: Option<TelemetryConfig>,
+    /// Defaults for the /ui/kiosk wall display.
+    #[serde(default)]
+    pub kiosk: KioskConfig,
+    /// Floor plan for the /ui/map physical layout view.
+    #[serde(default)]
+    pub cluster_map: ClusterMapConfig,
+    /// Webhooks receiving pod and node health events as the console sees
+    /// them.
+    #[serde(default)]
+    pub event_hooks: Vec<EventHookConfig>,
+    /// Resource types the console stores itself and serves under
+    /// /apis/<group>/<version>/, for small controllers that need somewhere
+    /// to keep state.
+    #[serde(default)]
+    pub custom_resources: Vec<CustomResourceDef>,
+    /// Where activity records (who created, deleted or changed what) are
+    /// shipped as they happen, besides the console's own activity log.
+    #[serde(default)]
+    pub audit_sinks: Vec<AuditSinkConfig>,
+    /// A line per HTTP request, for log pipelines and fail2ban-style tools;
+    /// kept apart from the application log.
+    #[serde(default)]
+    pub access_log: Option<AccessLogConfig>,
+    /// Start in read-only mode, refusing every change; admins can switch
+    /// it off at /ui/read-only.
+    #[serde(default)]
+    pub read_only: bool,
+    /// Signed, expiring links to a read-only view for people without an
+    /// account.
+    #[serde(default)]
+    pub share_links: ShareLinksConfig,
+    /// CI systems allowed to roll out new images through
+    /// POST /api/hooks/deploy.
+    #[serde(default)]
+    pub deploy_hooks: Vec<DeployHookConfig>,
+    /// Forge that GitOps applies report back to, so each commit shows
+    /// whether the console applied it.
+    #[serde(default)]
+    pub commit_status: Option<CommitStatusConfig>,
+    /// Image builds on nodes with the "build" capability, from the Build
+    /// page.
+    #[serde(default)]
+    pub builds: BuildsConfig,
 }
 
-#[derive(Debug, Clone, Deserialize)]
+#[derive(Debug, Clone, Default, Deserialize)]
 pub struct NodeDef {
     pub name: String,
     pub address: String,
+    /// MAC address for Wake-on-LAN.
+    #[serde(default)]
+    pub mac: Option<String>,
+    /// Where magic packets are sent; defaults to 255.255.255.255:9.
+    #[serde(default)]
+    pub wol_broadcast: Option<String>,
+    /// Smart-plug or PDU URL that powers the node on when POSTed to.
+    #[serde(default)]
+    pub power_on_url: Option<String>,
+    /// Request timeout for this node's API; slow boards such as a Pi Zero
+    /// may need more than the default 10s.
+    #[serde(default)]
+    pub timeout_secs: Option<u64>,
+    /// How long this node may be unreachable before it is declared down,
+    /// overriding node_health.failover_grace_secs.
+    #[serde(default)]
+    pub failover_grace_secs: Option<i64>,
+    /// Credentials sent with every request to the node.
+    #[serde(default)]
+    pub auth: Option<NodeAuth>,
+    /// Labels merged over the ones the node reports about itself.
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+    /// Relative scheduling weight; a node with weight 2 should take twice
+    /// the pods of a node with weight 1.
+    #[serde(default)]
+    pub weight: Option<f64>,
+    /// Start in maintenance: the scheduler places no new pods here unless a
+    /// pod names the node explicitly.
+    #[serde(default)]
+    pub maintenance: bool,
+    /// Free-form hints such as "ssd", "poe" or "low-memory". Each becomes a
+    /// capability.mkube.io/<hint>=true label.
+    #[serde(default)]
+    pub capabilities: Vec<String>,
+    /// Pool the node belongs to, e.g. stable or testing. Becomes the
+    /// mkube.io/pool label; pods annotated mkube.io/pool only run in that
+    /// pool.
+    #[serde(default)]
+    pub pool: Option<String>,
+    /// Directories holding data that lives only on this node, e.g.
+    /// /srv/postgres. Added to the node's mkube.io/data-paths annotation;
+    /// pods annotated mkube.io/data-local only run where their hostPath
+    /// volumes are listed.
+    #[serde(default)]
+    pub data_paths: Vec<String>,
+    /// Where the box physically is, for the cluster map.
+    #[serde(default)]
+    pub location: Option<NodeLocation>,
+}
+
+/// Physical location of a node. Every field is optional; the map groups
+/// nodes by room and rack and places them on the floor plan by x/y.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct NodeLocation {
+    #[serde(default)]
+    pub room: String,
+    #[serde(default)]
+    pub rack: String,
+    /// Shelf, slot or other position within the rack, e.g. "U12" or "top".
+    #[serde(default)]
+    pub shelf: String,
+    /// Position on cluster_map.floor_plan_url, in percent from the left
+    /// and top edges.
+    #[serde(default)]
+    pub x: Option<f64>,
+    #[serde(default)]
+    pub y: Option<f64>,
+    /// Photo or diagram of the box itself.
+    #[serde(default)]
+    pub photo_url: Option<String>,
+}
+
+/// Either a bearer token or a username and password for basic auth. Secrets
+/// may be given sealed (enc:v1:...) with `mkube-console keys seal`.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct NodeAuth {
+    #[serde(default)]
+    pub bearer_token: Option<String>,
+    #[serde(default)]
+    pub username: Option<String>,
+    #[serde(default)]
+    pub password: Option<String>,
 }
 
 #[derive(Debug, Clone, Deserialize)]
@@ -57,6 +274,855 @@ pub struct NetworkDef {
     pub dns_endpoint: Option<String>,
 }
 
+#[derive(Debug, Clone, Deserialize)]
+pub struct RestartCaptureConfig {
+    #[serde(default = "default_true")]
+    pub enabled: bool,
+    #[serde(default = "default_max_restarts")]
+    pub max_restarts: usize,
+    #[serde(default = "default_window_minutes")]
+    pub window_minutes: i64,
+    #[serde(default = "default_capture_log_lines")]
+    pub log_lines: i64,
+}
+
+impl Default for RestartCaptureConfig {
+    fn default() -> Self {
+        Self {
+            enabled: true,
+            max_restarts: default_max_restarts(),
+            window_minutes: default_window_minutes(),
+            log_lines: default_capture_log_lines(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct NodeHealthConfig {
+    /// How long a node must stay unreachable before it is declared down.
+    #[serde(default = "default_failover_grace_secs")]
+    pub failover_grace_secs: i64,
+    /// Grace period used instead when the node is flapping.
+    #[serde(default = "default_flap_holdoff_secs")]
+    pub flap_holdoff_secs: i64,
+    #[serde(default = "default_window_minutes")]
+    pub flap_window_minutes: i64,
+    /// Health transitions within the window at which a node counts as flapping.
+    #[serde(default = "default_flap_threshold")]
+    pub flap_threshold: usize,
+}
+
+impl Default for NodeHealthConfig {
+    fn default() -> Self {
+        Self {
+            failover_grace_secs: default_failover_grace_secs(),
+            flap_holdoff_secs: default_flap_holdoff_secs(),
+            flap_window_minutes: default_window_minutes(),
+            flap_threshold: default_flap_threshold(),
+        }
+    }
+}
+
+/// Health checks and pod polls are spread over part of their interval
+/// and jittered, so the nodes don't see every request at the same moment.
+#[derive(Debug, Clone, Deserialize, Serialize)]
+pub struct ScheduleConfig {
+    #[serde(default = "default_health_interval_secs")]
+    pub health_interval_secs: u64,
+    /// Seconds over which one round of per-node requests is staggered;
+    /// 0 sends them all at once.
+    #[serde(default = "default_spread_secs")]
+    pub spread_secs: u64,
+    /// How much each interval varies at random, in percent either way.
+    #[serde(default = "default_jitter_percent")]
+    pub jitter_percent: u64,
+}
+
+impl Default for ScheduleConfig {
+    fn default() -> Self {
+        Self {
+            health_interval_secs: default_health_interval_secs(),
+            spread_secs: default_spread_secs(),
+            jitter_percent: default_jitter_percent(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct ApiConfig {
+    /// Largest JSON pod list served, in bytes before compression. Longer
+    /// lists are cut short with a Warning header; clients that need every
+    /// pod use ?fields= or stream with Accept: application/x-ndjson. 0
+    /// turns the cap off.
+    #[serde(default = "default_max_list_bytes")]
+    pub max_list_bytes: usize,
+    /// Strip managedFields and the annotations in scrub_annotations from
+    /// API responses and the UI. A request can pass ?scrub=false to see
+    /// everything, or ?scrub=true when this is off.
+    #[serde(default = "default_true")]
+    pub scrub: bool,
+    /// Annotation keys dropped when scrubbing; a trailing * matches every
+    /// key starting with what comes before it.
+    #[serde(default = "default_scrub_annotations")]
+    pub scrub_annotations: Vec<String>,
+}
+
+impl Default for ApiConfig {
+    fn default() -> Self {
+        Self {
+            max_list_bytes: default_max_list_bytes(),
+            scrub: true,
+            scrub_annotations: default_scrub_annotations(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct BandwidthConfig {
+    /// Link utilisation, in percent of link speed, at which an interface
+    /// counts as saturated.
+    #[serde(default = "default_saturation_percent")]
+    pub saturation_percent: f64,
+    /// Link speed assumed for interfaces that don't report one; without it
+    /// those interfaces are never alerted on.
+    #[serde(default)]
+    pub link_mbps: Option<u64>,
+}
+
+impl Default for BandwidthConfig {
+    fn default() -> Self {
+        Self {
+            saturation_percent: default_saturation_percent(),
+            link_mbps: None,
+        }
+    }
+}
+
+/// An alert raised while `expr` holds for every evaluation over `for_secs`.
+/// Expressions compare one metric with a number, e.g. "node.pods > 40" or
+/// "pod.restarts >= 5"; see controllers::alert_rules for the metrics.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct AlertRuleConfig {
+    // Defaulted so the managed alert rule API can take the name from the
+    // path; compile() still rejects an empty one.
+    #[serde(default)]
+    pub name: String,
+    pub expr: String,
+    /// How long the condition must hold before the alert fires; 0 fires on
+    /// the first evaluation that matches.
+    #[serde(default)]
+    pub for_secs: u64,
+    #[serde(default = "default_rule_severity")]
+    pub severity: String,
+    /// Alert summary; {subject} and {value} are filled in. Defaults to the
+    /// rule name and subject.
+    #[serde(default)]
+    pub summary: Option<String>,
+    #[serde(default)]
+    pub labels: BTreeMap<String, String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ClusterMapConfig {
+    /// Image of the room or rack layout; nodes with an x/y location are
+    /// drawn over it.
+    #[serde(default)]
+    pub floor_plan_url: Option<String>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct KioskConfig {
+    /// Seconds each panel stays on screen; ?rotate= overrides it.
+    #[serde(default = "default_kiosk_rotate_secs")]
+    pub rotate_secs: u64,
+    /// Panels in display order; ?panels=alerts,nodes overrides it.
+    #[serde(default = "default_kiosk_panels")]
+    pub panels: Vec<KioskPanel>,
+}
+
+impl Default for KioskConfig {
+    fn default() -> Self {
+        Self {
+            rotate_secs: default_kiosk_rotate_secs(),
+            panels: default_kiosk_panels(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum KioskPanel {
+    /// Node and pod counts.
+    Summary,
+    /// Firing alerts.
+    Alerts,
+    /// One tile per node, coloured by health and CPU load.
+    Nodes,
+}
+
+impl std::str::FromStr for KioskPanel {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "summary" => Ok(Self::Summary),
+            "alerts" => Ok(Self::Alerts),
+            "nodes" => Ok(Self::Nodes),
+            _ => Err(format!("unknown kiosk panel {:?}", s)),
+        }
+    }
+}
+
+/// Share links are served under /share/, which the authenticating proxy
+/// must let through; the signature in each link is its credential.
+#[derive(Debug, Clone, Deserialize)]
+pub struct ShareLinksConfig {
+    /// Longest a link may stay valid.
+    #[serde(default = "default_share_max_hours")]
+    pub max_hours: i64,
+    /// Signing key; defaults to share.key in the data dir, generated on
+    /// first use. Replacing it revokes every link handed out.
+    #[serde(default)]
+    pub key_file: Option<String>,
+    /// Address links start with, e.g. https://console.example.com; taken
+    /// from the request's Host header when omitted.
+    #[serde(default)]
+    pub base_url: Option<String>,
+}
+
+impl Default for ShareLinksConfig {
+    fn default() -> Self {
+        Self {
+            max_hours: default_share_max_hours(),
+            key_file: None,
+            base_url: None,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct SchedulerConfig {
+    #[serde(default)]
+    pub strategy: SchedulingStrategy,
+    /// Nodes or a pool each namespace's pods go to, e.g. a media namespace
+    /// to the node with the USB drive. Pods naming their node aren't moved.
+    #[serde(default)]
+    pub namespaces: HashMap<String, NamespacePlacement>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct NamespacePlacement {
+    #[serde(default)]
+    pub nodes: Vec<String>,
+    /// A pool (see NodeDef.pool) whose nodes count as listed.
+    #[serde(default)]
+    pub pool: Option<String>,
+    /// Never place the namespace's pods elsewhere. Otherwise other nodes
+    /// take them only when none of these can.
+    #[serde(default)]
+    pub hard: bool,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum SchedulingStrategy {
+    /// The node running the fewest pods.
+    #[default]
+    LeastPods,
+    /// Pods in proportion to each node's performance score: its configured
+    /// weight, or one derived from CPU count, clock and recent load.
+    Weighted,
+}
+
+/// Users come from the Remote-User / X-Forwarded-User header set by the
+/// authenticating proxy in front of the console.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct AccessConfig {
+    /// Reject requests that arrive without a user.
+    #[serde(default)]
+    pub require_user: bool,
+    /// Role of users not listed in `users`; viewer unless set.
+    #[serde(default)]
+    pub default_role: Role,
+    #[serde(default)]
+    pub users: HashMap<String, Role>,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum Role {
+    /// Read-only access.
+    #[default]
+    Viewer,
+    /// Can create, change and delete workloads.
+    Editor,
+    /// Also sees console administration such as encryption status.
+    Admin,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct TelemetryConfig {
+    /// Receives each report as a JSON POST.
+    pub endpoint: String,
+    #[serde(default = "default_telemetry_hours")]
+    pub interval_hours: u64,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ErrorReportingConfig {
+    /// Receives each report as a JSON POST.
+    #[serde(default)]
+    pub webhook_url: Option<String>,
+    /// DSN of a Sentry-compatible service (Sentry, GlitchTip, ...), e.g.
+    /// https://<key>@sentry.example.com/<project>
+    #[serde(default)]
+    pub sentry_dsn: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EventHookConfig {
+    /// Receives each event as a JSON POST.
+    pub url: String,
+    /// Event types to send (podAdded, podRemoved, podPhaseChanged,
+    /// nodeHealthChanged); all of them when empty.
+    #[serde(default)]
+    pub events: Vec<String>,
+}
+
+/// A CI system (GitHub Actions, Drone, ...) that deploys what it builds.
+#[derive(Debug, Clone, Deserialize)]
+pub struct BuildsConfig {
+    /// Image running builds; it needs BuildKit's buildctl-daemonless.sh
+    /// and must exist for the build nodes' architectures.
+    #[serde(default = "default_builder_image")]
+    pub builder_image: String,
+}
+
+impl Default for BuildsConfig {
+    fn default() -> Self {
+        Self {
+            builder_image: default_builder_image(),
+        }
+    }
+}
+
+/// Commit status API of a forge. Applies that name a repo and commit
+/// (?repo=owner/name&commit=<sha>) set that commit's status.
+#[derive(Debug, Clone, Deserialize)]
+pub struct CommitStatusConfig {
+    pub provider: ForgeProvider,
+    /// API root; https://api.github.com for GitHub, e.g.
+    /// https://gitea.example.com/api/v1 for Gitea.
+    #[serde(default)]
+    pub api_url: Option<String>,
+    /// Token allowed to write commit statuses; may be sealed.
+    pub token: String,
+    /// Name the status is listed under; defaults to
+    /// mkube-console/<cluster_name>.
+    #[serde(default)]
+    pub context: Option<String>,
+    /// Link the status points to, e.g. the console.
+    #[serde(default)]
+    pub target_url: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum ForgeProvider {
+    Github,
+    /// Gitea and Forgejo.
+    Gitea,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct DeployHookConfig {
+    /// Shown in the activity feed, e.g. "github-actions".
+    pub name: String,
+    /// Bearer token the CI sends; may be sealed.
+    pub token: String,
+    /// Namespaces it may deploy to; all of them when empty.
+    #[serde(default)]
+    pub namespaces: Vec<String>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct CustomResourceDef {
+    /// API group, e.g. "lab.example.com".
+    pub group: String,
+    #[serde(default = "default_custom_version")]
+    pub version: String,
+    pub kind: String,
+    /// Lowercase plural used in URLs, e.g. "widgets".
+    pub plural: String,
+    #[serde(default = "default_true")]
+    pub namespaced: bool,
+    /// Types of spec fields (string, integer, number, boolean, object or
+    /// array). Fields not listed are stored as given.
+    #[serde(default)]
+    pub schema: HashMap<String, String>,
+    /// Spec fields every object must set.
+    #[serde(default)]
+    pub required: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct IpamConfig {
+    #[serde(default)]
+    pub reserved: Vec<ReservedRange>,
+}
+
+/// An inclusive address range, e.g. printers or a DHCP pool on another box.
+#[derive(Debug, Clone, Deserialize)]
+pub struct ReservedRange {
+    pub name: String,
+    pub start: String,
+    pub end: String,
+}
+
+/// Default requests and limits per resource name, e.g. cpu: "100m".
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ResourceDefaults {
+    #[serde(default)]
+    pub requests: HashMap<String, String>,
+    #[serde(default)]
+    pub limits: HashMap<String, String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct SecretProvidersConfig {
+    #[serde(default)]
+    pub vault: Option<VaultConfig>,
+    #[serde(default)]
+    pub sops: Option<SopsConfig>,
+    #[serde(default)]
+    pub env: Option<EnvSecretsConfig>,
+}
+
+/// HashiCorp Vault KV version 2.
+#[derive(Debug, Clone, Deserialize)]
+pub struct VaultConfig {
+    pub address: String,
+    #[serde(default = "default_vault_mount")]
+    pub mount: String,
+    /// File holding the token, e.g. written by a Vault agent. Takes
+    /// precedence over token_env.
+    #[serde(default)]
+    pub token_file: Option<String>,
+    #[serde(default = "default_vault_token_env")]
+    pub token_env: String,
+}
+
+/// SOPS-encrypted files, decrypted with the sops binary on demand.
+#[derive(Debug, Clone, Deserialize)]
+pub struct SopsConfig {
+    #[serde(default = "default_sops_binary")]
+    pub binary: String,
+    /// Short name used in references to file path.
+    #[serde(default)]
+    pub files: HashMap<String, String>,
+}
+
+/// The console's own environment. Only variables with the prefix can be
+/// referenced, so references can't read arbitrary console settings.
+#[derive(Debug, Clone, Deserialize)]
+pub struct EnvSecretsConfig {
+    #[serde(default = "default_env_secrets_prefix")]
+    pub prefix: String,
+}
+
+/// Where the data key comes from: a file (e.g. written by a KMS agent), an
+/// environment variable, or inline. Keys are 32 random bytes, base64
+/// encoded; `mkube-console keys generate` makes one.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct EncryptionConfig {
+    #[serde(default)]
+    pub key_file: Option<String>,
+    #[serde(default)]
+    pub key_env: Option<String>,
+    #[serde(default)]
+    pub key: Option<String>,
+    /// Retired keys, still accepted for decryption until `keys rotate` has
+    /// rewritten everything with the current key.
+    #[serde(default)]
+    pub previous_key_files: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct EnvInjectionConfig {
+    /// Name to value. A container that sets the variable itself keeps its
+    /// own value.
+    #[serde(default)]
+    pub vars: BTreeMap<String, String>,
+    /// Namespaces that opt out of injection.
+    #[serde(default)]
+    pub exclude_namespaces: Vec<String>,
+}
+
+/// Quota in Kubernetes quantity notation, e.g. cpu: "4", memory: "8Gi".
+#[derive(Debug, Clone, Deserialize)]
+pub struct NamespaceQuota {
+    #[serde(default)]
+    pub cpu: Option<String>,
+    #[serde(default)]
+    pub memory: Option<String>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct HaConfig {
+    /// Lease file on storage shared by both console instances.
+    pub lease_path: String,
+    /// Unique name for this instance; defaults to the hostname.
+    #[serde(default = "default_identity")]
+    pub identity: String,
+    /// URL other instances redirect mutations to while this one leads.
+    #[serde(default)]
+    pub advertise_url: String,
+    #[serde(default = "default_lease_secs")]
+    pub lease_secs: u64,
+    #[serde(default = "default_renew_secs")]
+    pub renew_secs: u64,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct SelfPodConfig {
+    pub namespace: String,
+    pub name: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct UpdateConfig {
+    /// URL serving the latest release as JSON: version, notes, image and
+    /// per-platform binaries with their sha256 and signature.
+    pub release_url: String,
+    /// Base64 Ed25519 public key release binaries must be signed with;
+    /// when omitted, updates are only checked for and never installed.
+    #[serde(default)]
+    pub public_key: Option<String>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct FollowConfig {
+    /// Base URL of the console to replicate from, e.g. http://console.site-a:8080
+    pub upstream_url: String,
+    /// Sent as a bearer token, for an upstream whose authenticating proxy
+    /// accepts one. May be given sealed (enc:v1:...).
+    #[serde(default)]
+    pub bearer_token: Option<String>,
+    /// Extra headers sent upstream, e.g. what the upstream's proxy signs
+    /// service clients in with when it sets access.require_user. Values may
+    /// be given sealed.
+    #[serde(default)]
+    pub headers: HashMap<String, String>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct PushConfig {
+    /// PKCS#8 VAPID private key, generated on first start if missing.
+    /// Defaults to vapid.pk8 in the data dir.
+    #[serde(default)]
+    pub vapid_key_file: Option<String>,
+    /// Contact URI sent to push services, e.g. mailto:admin@example.com
+    pub subject: String,
+}
+
+/// An audit sink, selected with `kind: file`, `kind: syslog` or `kind: http`.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(tag = "kind", rename_all = "lowercase")]
+pub enum AuditSinkConfig {
+    File(FileAuditSink),
+    Syslog(SyslogAuditSink),
+    Http(HttpAuditSink),
+}
+
+/// JSON lines appended to a local file, e.g. for a log shipper to pick up.
+#[derive(Debug, Clone, Deserialize)]
+pub struct FileAuditSink {
+    pub path: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct SyslogAuditSink {
+    /// udp://host:port, tcp://host:port or unix:///path.
+    #[serde(default = "default_syslog_address")]
+    pub address: String,
+    /// Syslog facility number; 13 is "log audit".
+    #[serde(default = "default_syslog_facility")]
+    pub facility: u8,
+}
+
+/// Batches of records POSTed as a JSON array, retried with backoff.
+#[derive(Debug, Clone, Deserialize)]
+pub struct HttpAuditSink {
+    pub url: String,
+    /// May be given sealed (enc:v1:...).
+    #[serde(default)]
+    pub bearer_token: Option<String>,
+    #[serde(default = "default_audit_batch_size")]
+    pub batch_size: usize,
+    /// Longest a record waits for its batch to fill.
+    #[serde(default = "default_audit_flush_secs")]
+    pub flush_secs: u64,
+    /// Attempts at a batch before it is dropped.
+    #[serde(default = "default_audit_max_attempts")]
+    pub max_attempts: u32,
+}
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct AccessLogConfig {
+    /// File the log is appended to; stdout when unset.
+    #[serde(default)]
+    pub path: Option<String>,
+    #[serde(default)]
+    pub format: AccessLogFormat,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum AccessLogFormat {
+    /// Apache/nginx Combined Log Format.
+    #[default]
+    Combined,
+    /// A JSON object per line.
+    Json,
+}
+
+/// Remote access tunnel, selected with `kind: tailscale` or `kind: ssh`.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(tag = "kind", rename_all = "lowercase")]
+pub enum TunnelConfig {
+    Tailscale(TailscaleConfig),
+    Ssh(SshTunnelConfig),
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct TailscaleConfig {
+    #[serde(default = "default_tailscale_binary")]
+    pub binary: String,
+    /// tailscaled socket, for a non-default tailscaled instance.
+    #[serde(default)]
+    pub socket: Option<String>,
+    /// Funnel would publish the console to the internet; it is refused,
+    /// see Config::load.
+    #[serde(default)]
+    pub funnel: bool,
+    #[serde(default = "default_https_port")]
+    pub https_port: u16,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct SshTunnelConfig {
+    pub host: String,
+    pub user: String,
+    #[serde(default = "default_ssh_port")]
+    pub port: u16,
+    pub remote_port: u16,
+    /// Address to bind on the remote side. Only loopback addresses are
+    /// allowed, see Config::load.
+    #[serde(default = "default_remote_bind")]
+    pub remote_bind: String,
+    #[serde(default)]
+    pub identity_file: Option<String>,
+    #[serde(default)]
+    pub known_hosts_file: Option<String>,
+    #[serde(default = "default_ssh_binary")]
+    pub binary: String,
+}
+
+fn default_vault_mount() -> String {
+    "secret".to_string()
+}
+
+fn default_vault_token_env() -> String {
+    "VAULT_TOKEN".to_string()
+}
+
+fn default_sops_binary() -> String {
+    "sops".to_string()
+}
+
+fn default_env_secrets_prefix() -> String {
+    "MKUBE_SECRET_".to_string()
+}
+
+fn default_syslog_address() -> String {
+    "unix:///dev/log".to_string()
+}
+
+fn default_syslog_facility() -> u8 {
+    13
+}
+
+fn default_audit_batch_size() -> usize {
+    100
+}
+
+fn default_audit_flush_secs() -> u64 {
+    5
+}
+
+fn default_audit_max_attempts() -> u32 {
+    5
+}
+
+fn default_tailscale_binary() -> String {
+    "tailscale".to_string()
+}
+
+fn default_https_port() -> u16 {
+    443
+}
+
+fn default_ssh_port() -> u16 {
+    22
+}
+
+fn default_remote_bind() -> String {
+    "localhost".to_string()
+}
+
+fn is_loopback(bind: &str) -> bool {
+    let host = bind.trim_start_matches('[').trim_end_matches(']');
+    host == "localhost" || host.parse::<std::net::IpAddr>().is_ok_and(|ip| ip.is_loopback())
+}
+
+fn default_ssh_binary() -> String {
+    "ssh".to_string()
+}
+
+fn default_identity() -> String {
+    std::env::var("HOSTNAME")
+        .ok()
+        .or_else(|| std::fs::read_to_string("/etc/hostname").ok())
+        .map(|h| h.trim().to_string())
+        .filter(|h| !h.is_empty())
+        .unwrap_or_else(|| format!("console-{}", std::process::id()))
+}
+
+fn default_telemetry_hours() -> u64 {
+    24
+}
+
+fn default_lease_secs() -> u64 {
+    15
+}
+
+fn default_renew_secs() -> u64 {
+    5
+}
+
+fn default_failover_grace_secs() -> i64 {
+    60
+}
+
+fn default_flap_holdoff_secs() -> i64 {
+    300
+}
+
+fn default_flap_threshold() -> usize {
+    4
+}
+
+fn default_health_interval_secs() -> u64 {
+    15
+}
+
+fn default_spread_secs() -> u64 {
+    5
+}
+
+fn default_jitter_percent() -> u64 {
+    10
+}
+
+fn default_max_list_bytes() -> usize {
+    64 << 20
+}
+
+fn default_scrub_annotations() -> Vec<String> {
+    vec!["kubectl.kubernetes.io/last-applied-configuration".to_string()]
+}
+
+fn default_saturation_percent() -> f64 {
+    80.0
+}
+
+fn default_rule_severity() -> String {
+    "warning".to_string()
+}
+
+fn default_true() -> bool {
+    true
+}
+
+fn default_max_restarts() -> usize {
+    3
+}
+
+fn default_window_minutes() -> i64 {
+    10
+}
+
+/// Pods annotated mkube.io/restart-on-change are restarted when a
+/// configmap or secret they use changes, a batch at a time.
+#[derive(Debug, Clone, Deserialize)]
+pub struct ConfigRolloutConfig {
+    /// How often configmaps and secrets are checked for changes.
+    #[serde(default = "default_rollout_interval_secs")]
+    pub interval_secs: u64,
+    /// Pods restarted at once. Never more than one pod of a deployment.
+    #[serde(default = "default_rollout_batch_size")]
+    pub batch_size: usize,
+    /// Pause between batches, so restarted pods come up before the next go.
+    #[serde(default = "default_rollout_batch_delay_secs")]
+    pub batch_delay_secs: u64,
+}
+
+impl Default for ConfigRolloutConfig {
+    fn default() -> Self {
+        Self {
+            interval_secs: default_rollout_interval_secs(),
+            batch_size: default_rollout_batch_size(),
+            batch_delay_secs: default_rollout_batch_delay_secs(),
+        }
+    }
+}
+
+fn default_rollout_interval_secs() -> u64 {
+    30
+}
+
+fn default_rollout_batch_size() -> usize {
+    1
+}
+
+fn default_rollout_batch_delay_secs() -> u64 {
+    30
+}
+
+fn default_capture_log_lines() -> i64 {
+    500
+}
+
+fn default_kiosk_rotate_secs() -> u64 {
+    20
+}
+
+fn default_kiosk_panels() -> Vec<KioskPanel> {
+    vec![KioskPanel::Summary, KioskPanel::Alerts, KioskPanel::Nodes]
+}
+
+fn default_share_max_hours() -> i64 {
+    7 * 24
+}
+
+fn default_builder_image() -> String {
+    "moby/buildkit:rootless".to_string()
+}
+
+fn default_custom_version() -> String {
+    "v1".to_string()
+}
+
 fn default_cluster_name() -> String {
     "mkube".to_string()
 }
@@ -78,12 +1144,160 @@ impl Config {
                 cfg.nodes.push(NodeDef {
                     name: cfg.cluster_name.clone(),
                     address: mkube.base_url.clone(),
+                    ..Default::default()
                 });
             }
         }
 
-        if cfg.nodes.is_empty() {
-            return Err("at least one node or mkube.base_url must be configured".into());
+        if cfg.nodes.is_empty() && cfg.follow.is_none() {
+            return Err("at least one node, mkube.base_url or follow must be configured".into());
+        }
+
+        for (ns, p) in &cfg.scheduler.namespaces {
+            if p.nodes.is_empty() && p.pool.is_none() {
+                return Err(format!("scheduler.namespaces.{}: needs nodes or a pool", ns).into());
+            }
+        }
+
+        for n in &cfg.nodes {
+            if n.weight.is_some_and(|w| w <= 0.0) {
+                return Err(format!("node {}: weight must be positive", n.name).into());
+            }
+            if n.pool.as_deref().is_some_and(|p| p.trim().is_empty()) {
+                return Err(format!("node {}: pool must not be empty", n.name).into());
+            }
+            if let Some(ref l) = n.location {
+                if [l.x, l.y].iter().flatten().any(|v| !(0.0..=100.0).contains(v)) {
+                    return Err(format!("node {}: location x and y are percentages (0-100)", n.name).into());
+                }
+            }
+            if let Some(ref a) = n.auth {
+                if a.bearer_token.is_some() && a.username.is_some() {
+                    return Err(format!("node {}: auth takes a bearer_token or a username, not both", n.name).into());
+                }
+            }
+        }
+
+        for p in &cfg.trusted_proxies {
+            if p.trim().parse::<std::net::IpAddr>().is_err() && Subnet::parse("", p).is_none() {
+                return Err(format!("trusted_proxies: {:?} is not an address or CIDR", p).into());
+            }
+        }
+
+        let sched = &cfg.schedule;
+        if sched.health_interval_secs == 0 || sched.spread_secs >= sched.health_interval_secs {
+            return Err("schedule: spread_secs must be less than a non-zero health_interval_secs".into());
+        }
+        if sched.jitter_percent > 50 {
+            return Err("schedule: jitter_percent can be at most 50".into());
+        }
+        if cfg.config_rollout.interval_secs == 0 || cfg.config_rollout.batch_size == 0 {
+            return Err("config_rollout: interval_secs and batch_size must be positive".into());
+        }
+
+        // Users are whoever Remote-User says, which only an authenticating
+        // proxy in front of the console can vouch for. A tunnel straight to
+        // the listener has none, so it may publish to the tailnet or the
+        // jump host's loopback but not to the internet.
+        match cfg.tunnel {
+            Some(TunnelConfig::Tailscale(ref ts)) if ts.funnel => {
+                return Err("tunnel: funnel would publish the console to the internet, where anyone can claim \
+                            to be any user; use serve, or publish an authenticating proxy in front of the console"
+                    .into());
+            }
+            Some(TunnelConfig::Ssh(ref ssh)) if !is_loopback(&ssh.remote_bind) => {
+                return Err(format!(
+                    "tunnel: remote_bind {:?} would publish the console beyond the jump host, where anyone can \
+                     claim to be any user; bind localhost, or publish an authenticating proxy instead",
+                    ssh.remote_bind
+                )
+                .into());
+            }
+            _ => {}
+        }
+
+        for h in &cfg.deploy_hooks {
+            if h.name.is_empty() || h.token.is_empty() {
+                return Err("deploy hook: name and token are required".into());
+            }
+        }
+
+        if let Some(ref c) = cfg.commit_status {
+            if c.token.is_empty() {
+                return Err("commit_status: token is required".into());
+            }
+            if c.provider == ForgeProvider::Gitea && c.api_url.is_none() {
+                return Err("commit_status: api_url is required for gitea".into());
+            }
+        }
+
+        for h in &cfg.event_hooks {
+            if let Some(e) = h.events.iter().find(|e| !EVENT_TYPES.contains(&e.as_str())) {
+                return Err(format!(
+                    "event hook {}: unknown event type {:?}; use one of {}",
+                    h.url,
+                    e,
+                    EVENT_TYPES.join(", ")
+                )
+                .into());
+            }
+        }
+
+        alert_rules::compile(&cfg.alert_rules)?;
+
+        for sink in &cfg.audit_sinks {
+            match sink {
+                AuditSinkConfig::File(f) if f.path.is_empty() => {
+                    return Err("audit sink: file needs a path".into());
+                }
+                AuditSinkConfig::Syslog(s) => {
+                    if s.facility > 23 {
+                        return Err(format!("audit sink: syslog facility {} is not 0-23", s.facility).into());
+                    }
+                    if !["udp://", "tcp://", "unix://"].iter().any(|p| s.address.starts_with(p)) {
+                        return Err(format!(
+                            "audit sink: syslog address {:?} needs udp://, tcp:// or unix://",
+                            s.address
+                        )
+                        .into());
+                    }
+                }
+                AuditSinkConfig::Http(h) if h.batch_size == 0 || h.flush_secs == 0 || h.max_attempts == 0 => {
+                    return Err(format!(
+                        "audit sink {}: batch_size, flush_secs and max_attempts must be positive",
+                        h.url
+                    )
+                    .into());
+                }
+                _ => {}
+            }
+        }
+
+        let mut seen = std::collections::HashSet::new();
+        for r in &cfg.custom_resources {
+            let id = format!("{}/{}/{}", r.group, r.version, r.plural);
+            if r.group.is_empty() || r.kind.is_empty() || r.plural.is_empty() {
+                return Err(format!("custom resource {}: group, kind and plural are required", id).into());
+            }
+            if r.group == "batch" || r.group == "coordination.k8s.io" {
+                return Err(format!("custom resource {}: group {} is served by the console", id, r.group).into());
+            }
+            if r.plural != r.plural.to_lowercase() || r.plural == "namespaces" || r.plural.contains('/') {
+                return Err(format!("custom resource {}: plural must be a lowercase name other than namespaces", id).into());
+            }
+            if !seen.insert(id.clone()) {
+                return Err(format!("custom resource {}: defined twice", id).into());
+            }
+            if let Some((field, t)) = r.schema.iter().find(|(_, t)| !FIELD_TYPES.contains(&t.as_str())) {
+                return Err(format!(
+                    "custom resource {}: field {} has unknown type {:?}; use one of {}",
+                    id,
+                    field,
+                    t,
+                    FIELD_TYPES.join(", ")
+                )
+                .into());
+            }
         }
 
         Ok(cfg)
@@ -103,4 +1317,20 @@ impl Config {
     pub fn logs_url(&self) -> String {
         self.logs_url.clone().unwrap_or_default()
     }
+
+    /// Whether `ip` is one of trusted_proxies.
+    pub fn is_trusted_proxy(&self, ip: &std::net::IpAddr) -> bool {
+        self.trusted_proxies.iter().any(|p| match Subnet::parse("", p) {
+            Some(net) => net.contains(ip),
+            None => p.trim().parse::<std::net::IpAddr>().is_ok_and(|a| a == *ip),
+        })
+    }
+
+    /// Directory for console-side state files, if persistence is enabled.
+    pub fn data_path(&self, file: &str) -> Option<std::path::PathBuf> {
+        self.data_dir
+            .as_ref()
+            .filter(|d| !d.is_empty())
+            .map(|d| Path::new(d).join(file))
+    }
 }
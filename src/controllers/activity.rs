@@ -0,0 +1,130 @@
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use tokio::sync::broadcast;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::clients::aggregator::Aggregator;
+use crate::clients::events::ClusterEvent;
+use crate::leader::LeaderElector;
+
+/// Feeds the activity log with changes the console observes rather than
+/// makes itself: apps being created, deleted or scaled, and nodes going up
+/// or down (from the aggregator's health events). Pod creates and deletes
+/// through the API are recorded by the handlers, with the requesting user.
+pub struct ActivityWatcher {
+    aggregator: Arc<Aggregator>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+    state: Mutex<WatchState>,
+}
+
+#[derive(Default)]
+struct WatchState {
+    seeded: bool,
+    /// "namespace/name" -> desired replicas
+    replicas: HashMap<String, i32>,
+}
+
+impl ActivityWatcher {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            activity,
+            leader,
+            state: Mutex::new(WatchState::default()),
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(15));
+        let mut events = self.aggregator.subscribe();
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    if self.leader.is_leader() {
+                        self.check().await;
+                    }
+                }
+                msg = events.recv() => match msg.map(|e| e.event) {
+                    Ok(ClusterEvent::NodeHealthChanged { node, healthy }) if self.leader.is_leader() => {
+                        let (action, message) = if healthy {
+                            ("node-up", "node is reachable again")
+                        } else {
+                            ("node-down", "node stopped responding")
+                        };
+                        self.activity.record(action, "node", "", &node, "system", message);
+                    }
+                    Err(broadcast::error::RecvError::Closed) => return,
+                    _ => {}
+                },
+                _ = shutdown.changed() => {
+                    info!("activity watcher shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn check(&self) {
+        let deployments = match self.aggregator.list_deployments().await {
+            Ok(d) => d,
+            Err(e) => {
+                warn!("activity watcher: listing deployments: {}", e);
+                return;
+            }
+        };
+        let replicas: HashMap<String, i32> = deployments
+            .iter()
+            .map(|d| {
+                (
+                    format!("{}/{}", d.metadata.namespace, d.metadata.name),
+                    d.spec.replicas,
+                )
+            })
+            .collect();
+        let mut state = self.state.lock().unwrap();
+
+        // The first pass only learns the current state, so a console restart
+        // doesn't report every existing app as created.
+        if state.seeded {
+            for (key, &want) in &replicas {
+                let (ns, name) = key.split_once('/').unwrap_or(("", key));
+                match state.replicas.get(key) {
+                    None => self.activity.record(
+                        "create",
+                        "app",
+                        ns,
+                        name,
+                        "system",
+                        &format!("created with {} replicas", want),
+                    ),
+                    Some(&had) if had != want => self.activity.record(
+                        "scale",
+                        "app",
+                        ns,
+                        name,
+                        "system",
+                        &format!("scaled from {} to {} replicas", had, want),
+                    ),
+                    _ => {}
+                }
+            }
+            for key in state.replicas.keys() {
+                if !replicas.contains_key(key) {
+                    let (ns, name) = key.split_once('/').unwrap_or(("", key));
+                    self.activity.record("delete", "app", ns, name, "system", "deleted");
+                }
+            }
+        }
+
+        state.replicas = replicas;
+        state.seeded = true;
+    }
+}
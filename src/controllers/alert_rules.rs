@@ -0,0 +1,454 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use std::time::SystemTime;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::alerts::AlertManager;
+use crate::alertrules::AlertRuleStore;
+use crate::clients::aggregator::Aggregator;
+use crate::config::AlertRuleConfig;
+use crate::leader::LeaderElector;
+use crate::metrics::MetricsHistory;
+use crate::models::k8s::Pod;
+
+const KEY_PREFIX: &str = "rule/";
+
+/// Metrics a rule can compare, with what each one is measured over. Node
+/// and pod metrics are evaluated per node or pod, and each one over the
+/// threshold is its own alert.
+pub const METRICS: &[(&str, &str)] = &[
+    ("node.unhealthy", "1 while the node is unreachable, else 0"),
+    ("node.pods", "pods placed on the node"),
+    ("node.bandwidth_percent", "busiest interface, in percent of link speed"),
+    ("pod.restarts", "container restarts, summed over the pod's containers"),
+    ("pod.not_ready", "containers not ready"),
+    ("cluster.nodes_unhealthy", "unreachable nodes"),
+    ("cluster.pods_pending", "pods in phase Pending"),
+    ("cluster.pods_failed", "pods in phase Failed"),
+];
+
+const OPS: &[&str] = &[">=", "<=", "==", "!=", ">", "<"];
+
+/// A rule from the config or the API, parsed and checked.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct AlertRule {
+    pub name: String,
+    pub expr: String,
+    pub metric: String,
+    pub op: String,
+    pub threshold: f64,
+    pub for_secs: u64,
+    pub severity: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub summary: Option<String>,
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    pub labels: BTreeMap<String, String>,
+}
+
+impl AlertRule {
+    fn matches(&self, value: f64) -> bool {
+        match self.op.as_str() {
+            ">=" => value >= self.threshold,
+            "<=" => value <= self.threshold,
+            "==" => value == self.threshold,
+            "!=" => value != self.threshold,
+            ">" => value > self.threshold,
+            _ => value < self.threshold,
+        }
+    }
+}
+
+/// Parses and checks the config's alert_rules, naming the first rule that
+/// is wrong and why.
+pub fn compile(rules: &[AlertRuleConfig]) -> Result<Vec<AlertRule>, String> {
+    let mut seen = HashSet::new();
+    let mut compiled = Vec::new();
+    for r in rules {
+        if r.name.is_empty() || r.name.contains('/') {
+            return Err(format!(
+                "alert rule {:?}: name must be non-empty and contain no /",
+                r.name
+            ));
+        }
+        if !seen.insert(r.name.as_str()) {
+            return Err(format!("alert rule {}: defined twice", r.name));
+        }
+        if !["info", "warning", "critical"].contains(&r.severity.as_str()) {
+            return Err(format!(
+                "alert rule {}: severity {:?} must be info, warning or critical",
+                r.name, r.severity
+            ));
+        }
+        let Some((metric, op, threshold)) = parse_expr(&r.expr) else {
+            return Err(format!(
+                "alert rule {}: expr {:?} must be <metric> <op> <number>, with op one of {}",
+                r.name,
+                r.expr,
+                OPS.join(" ")
+            ));
+        };
+        if !METRICS.iter().any(|(m, _)| *m == metric) {
+            let names: Vec<&str> = METRICS.iter().map(|(m, _)| *m).collect();
+            return Err(format!(
+                "alert rule {}: unknown metric {:?}; use one of {}",
+                r.name,
+                metric,
+                names.join(", ")
+            ));
+        }
+        compiled.push(AlertRule {
+            name: r.name.clone(),
+            expr: r.expr.clone(),
+            metric: metric.to_string(),
+            op: op.to_string(),
+            threshold,
+            for_secs: r.for_secs,
+            severity: r.severity.clone(),
+            summary: r.summary.clone(),
+            labels: r.labels.clone(),
+        });
+    }
+    Ok(compiled)
+}
+
+fn parse_expr(expr: &str) -> Option<(&str, &str, f64)> {
+    // Two-character operators come first so ">=" isn't read as ">"
+    let (at, op) = OPS.iter().find_map(|op| expr.find(op).map(|at| (at, *op)))?;
+    let metric = expr[..at].trim();
+    let threshold: f64 = expr[at + op.len()..].trim().parse().ok()?;
+    if metric.is_empty() || !threshold.is_finite() {
+        return None;
+    }
+    Some((metric, op, threshold))
+}
+
+/// The compiled rules and where they came from, for the API.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct RuleSet {
+    pub source: String,
+    pub loaded_at: DateTime<Utc>,
+    /// Why the config file's current rules were rejected; the rules above
+    /// are the last ones that compiled.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub reload_error: Option<String>,
+    pub rules: Vec<AlertRule>,
+    /// Rules created through the API (alertrules.rs), less any a config
+    /// rule of the same name overrides.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub managed: Vec<AlertRule>,
+}
+
+// Only the part of the config file the engine re-reads.
+#[derive(Deserialize)]
+struct RulesSection {
+    #[serde(default)]
+    alert_rules: Vec<AlertRuleConfig>,
+}
+
+/// Evaluates the config's alert rules every 15 seconds and raises an alert
+/// for every node, pod or cluster whose metric has matched for the rule's
+/// for_secs. The config file is checked for changes on the same tick and
+/// its rules swapped in when they compile; when they don't, the previous
+/// rules stay in force and the error is logged and shown by the API. Rules
+/// created through the API are evaluated alongside. Only the leader alerts.
+pub struct AlertRuleEngine {
+    aggregator: Arc<Aggregator>,
+    store: Arc<AlertRuleStore>,
+    metrics: Arc<MetricsHistory>,
+    alerts: Arc<AlertManager>,
+    leader: Arc<LeaderElector>,
+    config_path: PathBuf,
+    link_mbps: Option<u64>,
+    state: Mutex<EngineState>,
+}
+
+struct EngineState {
+    rules: RuleSet,
+    modified: Option<SystemTime>,
+    /// When each rule/subject pair started matching.
+    pending: HashMap<String, DateTime<Utc>>,
+}
+
+impl AlertRuleEngine {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        store: Arc<AlertRuleStore>,
+        metrics: Arc<MetricsHistory>,
+        alerts: Arc<AlertManager>,
+        leader: Arc<LeaderElector>,
+        config_path: PathBuf,
+        rules: &[AlertRuleConfig],
+        link_mbps: Option<u64>,
+    ) -> Self {
+        let modified = std::fs::metadata(&config_path).and_then(|m| m.modified()).ok();
+        let rules = RuleSet {
+            source: config_path.display().to_string(),
+            loaded_at: Utc::now(),
+            reload_error: None,
+            // Config::load already rejected rules that don't compile
+            rules: compile(rules).unwrap_or_default(),
+            managed: Vec::new(),
+        };
+        Self {
+            aggregator,
+            store,
+            metrics,
+            alerts,
+            leader,
+            config_path,
+            link_mbps,
+            state: Mutex::new(EngineState {
+                rules,
+                modified,
+                pending: HashMap::new(),
+            }),
+        }
+    }
+
+    pub fn rules(&self) -> RuleSet {
+        let mut set = self.state.lock().unwrap().rules.clone();
+        set.managed = self.managed(&set.rules);
+        set
+    }
+
+    /// Whether the config defines a rule named `name`, which the API can't
+    /// replace.
+    pub fn in_config(&self, name: &str) -> bool {
+        self.state.lock().unwrap().rules.rules.iter().any(|r| r.name == name)
+    }
+
+    // The store's rules that compile and aren't overridden by the config.
+    fn managed(&self, config: &[AlertRule]) -> Vec<AlertRule> {
+        self.store
+            .list()
+            .into_iter()
+            .filter(|r| !config.iter().any(|c| c.name == r.name))
+            .filter_map(|r| compile(std::slice::from_ref(&r)).ok())
+            .flatten()
+            .collect()
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(15));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    self.reload();
+                    if self.leader.is_leader() {
+                        self.evaluate().await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("alert rules shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    fn reload(&self) {
+        let modified = std::fs::metadata(&self.config_path).and_then(|m| m.modified()).ok();
+        let mut state = self.state.lock().unwrap();
+        if modified.is_none() || modified == state.modified {
+            return;
+        }
+        state.modified = modified;
+
+        let result = std::fs::read_to_string(&self.config_path)
+            .map_err(|e| format!("reading config {}: {}", self.config_path.display(), e))
+            .and_then(|data| serde_yaml::from_str::<RulesSection>(&data).map_err(|e| format!("parsing config: {}", e)))
+            .and_then(|section| compile(&section.alert_rules));
+        match result {
+            Ok(rules) => {
+                info!("alert rules reloaded: {} rules", rules.len());
+                state.rules.rules = rules;
+                state.rules.loaded_at = Utc::now();
+                state.rules.reload_error = None;
+            }
+            Err(e) => {
+                warn!("alert rules not reloaded, keeping the previous ones: {}", e);
+                state.rules.reload_error = Some(e);
+            }
+        }
+    }
+
+    async fn evaluate(&self) {
+        let mut rules = self.state.lock().unwrap().rules.rules.clone();
+        rules.extend(self.managed(&rules));
+        let values = values(&self.snapshot().await);
+        let now = Utc::now();
+
+        let mut matching = HashSet::new();
+        let mut firing = HashSet::new();
+        let mut pending = self.state.lock().unwrap().pending.clone();
+        for rule in &rules {
+            let Some(subjects) = values.get(rule.metric.as_str()) else {
+                continue;
+            };
+            for (subject, value) in subjects {
+                if !rule.matches(*value) {
+                    continue;
+                }
+                let key = format!("{}{}/{}", KEY_PREFIX, rule.name, subject);
+                let since = *pending.entry(key.clone()).or_insert(now);
+                matching.insert(key.clone());
+                if (now - since).num_seconds() < rule.for_secs as i64 {
+                    continue;
+                }
+                let summary = match rule.summary {
+                    Some(ref s) => s
+                        .replace("{subject}", subject)
+                        .replace("{value}", &format!("{}", value)),
+                    None => format!("{} on {}", rule.name, subject),
+                };
+                self.alerts.raise_labeled(
+                    &key,
+                    &rule.severity,
+                    &summary,
+                    &format!("{} (value {}) since {}", rule.expr, value, since.format("%H:%M:%S")),
+                    &rule.labels,
+                );
+                firing.insert(key);
+            }
+        }
+        pending.retain(|key, _| matching.contains(key));
+        self.state.lock().unwrap().pending = pending;
+
+        for a in self.alerts.firing() {
+            if a.key.starts_with(KEY_PREFIX) && !firing.contains(&a.key) {
+                self.alerts.resolve(&a.key);
+            }
+        }
+    }
+
+    /// The live cluster, as rules see it.
+    pub async fn snapshot(&self) -> Snapshot {
+        let mut bandwidth: HashMap<String, f64> = HashMap::new();
+        for (node, iface) in self.metrics.all_interface_rates() {
+            if let Some(util) = iface.utilisation(self.link_mbps) {
+                let busiest = bandwidth.entry(node).or_default();
+                *busiest = busiest.max(util);
+            }
+        }
+        let nodes = self
+            .aggregator
+            .snapshot_clients()
+            .await
+            .iter()
+            .map(|c| NodeSample {
+                name: c.name.clone(),
+                healthy: c.is_healthy(),
+                bandwidth_percent: bandwidth.get(&c.name).copied(),
+            })
+            .collect();
+        Snapshot {
+            nodes,
+            pods: self.aggregator.list_all_pods().await.unwrap_or_default(),
+        }
+    }
+}
+
+/// Node and pod state that rules are evaluated against: the live cluster,
+/// or one supplied to the rule sandbox.
+#[derive(Debug, Clone, Default, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Snapshot {
+    #[serde(default)]
+    pub nodes: Vec<NodeSample>,
+    #[serde(default)]
+    pub pods: Vec<Pod>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct NodeSample {
+    pub name: String,
+    #[serde(default = "default_healthy")]
+    pub healthy: bool,
+    #[serde(default)]
+    pub bandwidth_percent: Option<f64>,
+}
+
+fn default_healthy() -> bool {
+    true
+}
+
+/// A subject a rule matches, with the metric's value.
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleMatch {
+    pub subject: String,
+    pub value: f64,
+}
+
+/// Where a rule matches in the snapshot right now. A dry run can't tell
+/// how long a condition has held, so for_secs is not applied: these are
+/// the subjects that would start pending.
+pub fn dry_run(rule: &AlertRule, snapshot: &Snapshot) -> Vec<RuleMatch> {
+    values(snapshot)
+        .remove(rule.metric.as_str())
+        .unwrap_or_default()
+        .into_iter()
+        .filter(|(_, value)| rule.matches(*value))
+        .map(|(subject, value)| RuleMatch { subject, value })
+        .collect()
+}
+
+// Every metric's value, by subject: node name, namespace/pod, or the
+// cluster name "cluster".
+fn values(snapshot: &Snapshot) -> HashMap<&'static str, Vec<(String, f64)>> {
+    let mut values: HashMap<&'static str, Vec<(String, f64)>> = HashMap::new();
+    let pods = &snapshot.pods;
+
+    let mut per_node: HashMap<&str, usize> = HashMap::new();
+    for p in pods {
+        *per_node.entry(p.spec.node_name.as_str()).or_default() += 1;
+    }
+    for n in &snapshot.nodes {
+        let unhealthy = if n.healthy { 0.0 } else { 1.0 };
+        values
+            .entry("node.unhealthy")
+            .or_default()
+            .push((n.name.clone(), unhealthy));
+        let count = per_node.get(n.name.as_str()).copied().unwrap_or_default();
+        values
+            .entry("node.pods")
+            .or_default()
+            .push((n.name.clone(), count as f64));
+        if let Some(util) = n.bandwidth_percent {
+            values
+                .entry("node.bandwidth_percent")
+                .or_default()
+                .push((n.name.clone(), util));
+        }
+    }
+
+    for p in pods {
+        let subject = format!("{}/{}", p.metadata.namespace, p.metadata.name);
+        let statuses = &p.status.container_statuses;
+        let restarts: i32 = statuses.iter().map(|cs| cs.restart_count).sum();
+        let not_ready = statuses.iter().filter(|cs| !cs.ready).count();
+        values
+            .entry("pod.restarts")
+            .or_default()
+            .push((subject.clone(), restarts as f64));
+        values
+            .entry("pod.not_ready")
+            .or_default()
+            .push((subject, not_ready as f64));
+    }
+
+    let count_phase = |phase: &str| pods.iter().filter(|p| p.status.phase == phase).count() as f64;
+    let cluster = "cluster".to_string();
+    let unhealthy = snapshot.nodes.iter().filter(|n| !n.healthy).count() as f64;
+    values.insert("cluster.nodes_unhealthy", vec![(cluster.clone(), unhealthy)]);
+    values.insert("cluster.pods_pending", vec![(cluster.clone(), count_phase("Pending"))]);
+    values.insert("cluster.pods_failed", vec![(cluster, count_phase("Failed"))]);
+    values
+}
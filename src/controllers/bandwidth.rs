@@ -0,0 +1,112 @@
+use std::collections::HashSet;
+use std::sync::Arc;
+use tokio::time::{self, Duration};
+use tracing::{debug, info};
+
+use crate::alerts::AlertManager;
+use crate::clients::aggregator::Aggregator;
+use crate::config::BandwidthConfig;
+use crate::helpers::human_rate;
+use crate::leader::LeaderElector;
+use crate::metrics::MetricsHistory;
+
+const KEY_PREFIX: &str = "bandwidth-saturated/";
+
+/// Polls node network counters into the metrics history and raises an alert
+/// for every interface running above the saturation threshold. Collection
+/// runs on standbys too so their charts are warm on failover; only the
+/// leader alerts.
+pub struct BandwidthCollector {
+    aggregator: Arc<Aggregator>,
+    metrics: Arc<MetricsHistory>,
+    alerts: Arc<AlertManager>,
+    cfg: BandwidthConfig,
+    leader: Arc<LeaderElector>,
+}
+
+impl BandwidthCollector {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        metrics: Arc<MetricsHistory>,
+        alerts: Arc<AlertManager>,
+        cfg: BandwidthConfig,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            metrics,
+            alerts,
+            cfg,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(15));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    self.collect().await;
+                    if self.leader.is_leader() {
+                        self.check();
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("bandwidth collector shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn collect(&self) {
+        for c in self.aggregator.snapshot_clients().await {
+            if !c.is_healthy() {
+                self.metrics.clear_rates(&c.name);
+                continue;
+            }
+            match c.get_network_stats().await {
+                Ok(stats) => self.metrics.record_network(&c.name, stats),
+                Err(e) => {
+                    // Older mkube nodes don't expose counters at all
+                    debug!("network stats from {}: {}", c.name, e);
+                    self.metrics.clear_rates(&c.name);
+                }
+            }
+        }
+    }
+
+    fn check(&self) {
+        let mut saturated = HashSet::new();
+        for (node, iface) in self.metrics.all_interface_rates() {
+            let mbps = iface.speed_mbps.or(self.cfg.link_mbps);
+            let Some(util) = iface.utilisation(self.cfg.link_mbps) else {
+                continue;
+            };
+            if util < self.cfg.saturation_percent {
+                continue;
+            }
+            let key = format!("{}{}/{}", KEY_PREFIX, node, iface.name);
+            self.alerts.raise(
+                &key,
+                "warning",
+                &format!("Node {} interface {} is saturated", node, iface.name),
+                &format!(
+                    "{:.0}% of {} Mbps link (rx {}, tx {})",
+                    util,
+                    mbps.unwrap_or_default(),
+                    human_rate(iface.rx_rate),
+                    human_rate(iface.tx_rate)
+                ),
+            );
+            saturated.insert(key);
+        }
+
+        for a in self.alerts.firing() {
+            if a.key.starts_with(KEY_PREFIX) && !saturated.contains(&a.key) {
+                self.alerts.resolve(&a.key);
+            }
+        }
+    }
+}
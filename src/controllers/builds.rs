@@ -0,0 +1,126 @@
+use chrono::{DateTime, Utc};
+use std::collections::HashSet;
+use std::sync::{Arc, Mutex};
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::builds::{self, DEPLOY_ANNOTATION, IMAGE_ANNOTATION};
+use crate::deployhooks;
+use crate::deployments::DeploymentStore;
+use crate::jobs::JobStore;
+use crate::leader::LeaderElector;
+use crate::models::k8s::Job;
+
+/// Rolls deployments onto the images their builds push (see builds.rs):
+/// once a build naming a deployment completes, the deployment's containers
+/// running that image repository get the new image. Builds that completed
+/// before the console started are left alone, so a restart doesn't undo a
+/// rollback made since.
+pub struct BuildDeployer {
+    jobs: Arc<JobStore>,
+    deployments: Arc<DeploymentStore>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+    started: DateTime<Utc>,
+    /// "namespace/job" of builds already handled.
+    deployed: Mutex<HashSet<String>>,
+}
+
+impl BuildDeployer {
+    pub fn new(
+        jobs: Arc<JobStore>,
+        deployments: Arc<DeploymentStore>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            jobs,
+            deployments,
+            activity,
+            leader,
+            started: Utc::now(),
+            deployed: Mutex::new(HashSet::new()),
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(10));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    if self.leader.is_leader() {
+                        self.check();
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("build deployer shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    fn check(&self) {
+        let (jobs, _) = self.jobs.list(None);
+        for job in jobs.iter().filter(|j| self.due(j)) {
+            let key = format!("{}/{}", job.metadata.namespace, job.metadata.name);
+            if self.deployed.lock().unwrap().insert(key) {
+                self.deploy(job);
+            }
+        }
+    }
+
+    // Whether a build completed since the console started and names a
+    // deployment.
+    fn due(&self, job: &Job) -> bool {
+        let complete = job
+            .status
+            .conditions
+            .iter()
+            .any(|c| c.type_ == "Complete" && c.status == "True");
+        let recent = job
+            .status
+            .completion_time
+            .as_deref()
+            .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+            .is_some_and(|t| t >= self.started);
+        builds::is_build(job) && complete && recent && !builds::annotation(job, DEPLOY_ANNOTATION).is_empty()
+    }
+
+    fn deploy(&self, job: &Job) {
+        let image = builds::annotation(job, IMAGE_ANNOTATION);
+        let by = format!("build {}", job.metadata.name);
+        let Some((namespace, name)) = builds::annotation(job, DEPLOY_ANNOTATION).split_once('/') else {
+            return;
+        };
+        let Some(mut d) = self.deployments.get(namespace, name) else {
+            warn!(
+                "build {}: deployment {}/{} not found",
+                job.metadata.name, namespace, name
+            );
+            return;
+        };
+        let changed = match deployhooks::set_image(&mut d.spec.template.spec, image, None) {
+            Ok(changed) => changed,
+            Err(e) => {
+                warn!("build {}: deployment {}/{}: {}", job.metadata.name, namespace, name, e);
+                return;
+            }
+        };
+        if changed.is_empty() {
+            return;
+        }
+        d.metadata.resource_version.clear();
+        match self.deployments.update(namespace, name, d) {
+            Ok(_) => self
+                .activity
+                .record("deploy", "deployment", namespace, name, &by, image),
+            Err(e) => warn!(
+                "build {}: updating deployment {}/{}: {:?}",
+                job.metadata.name, namespace, name, e
+            ),
+        }
+    }
+}
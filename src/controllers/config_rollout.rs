@@ -0,0 +1,313 @@
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::collections::hash_map::DefaultHasher;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::hash::{Hash, Hasher};
+use std::sync::{Arc, Mutex};
+use tokio::time::{self, Duration, Instant};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::clients::aggregator::Aggregator;
+use crate::config::ConfigRolloutConfig;
+use crate::leader::LeaderElector;
+use crate::models::k8s::Pod;
+use crate::restarts::{self, RestartMode};
+use crate::secrets::{self, SecretRef, SecretResolver};
+use crate::stuck;
+
+/// Pod annotation opting the pod in to restarts when a configmap or secret
+/// it uses changes.
+pub const RESTART_ON_CHANGE_ANNOTATION: &str = "mkube.io/restart-on-change";
+
+/// Something a pod takes configuration from.
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+enum Source {
+    ConfigMap { namespace: String, name: String },
+    /// A secret://... reference the console resolved into the pod.
+    Secret(String),
+}
+
+impl Source {
+    fn describe(&self) -> String {
+        match self {
+            Source::ConfigMap { name, .. } => format!("configmap {}", name),
+            Source::Secret(r) => format!("secret {}", r),
+        }
+    }
+}
+
+/// A pod due a restart because its configuration changed.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PendingRestart {
+    pub namespace: String,
+    pub name: String,
+    /// What changed, e.g. "configmap app-config changed".
+    pub reason: String,
+    pub since: DateTime<Utc>,
+}
+
+/// Watches the configmaps and secret references of pods annotated
+/// mkube.io/restart-on-change and, when one changes, restarts those pods a
+/// batch at a time, never more than one pod of a deployment per batch.
+/// Only fingerprints of secret values are kept, never the values.
+pub struct ConfigRolloutController {
+    aggregator: Arc<Aggregator>,
+    secrets: Arc<SecretResolver>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+    cfg: ConfigRolloutConfig,
+    seen: Mutex<HashMap<Source, u64>>,
+    /// Keyed by "namespace/name".
+    pending: Mutex<BTreeMap<String, PendingRestart>>,
+    last_batch: Mutex<Option<Instant>>,
+}
+
+impl ConfigRolloutController {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        secrets: Arc<SecretResolver>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+        cfg: ConfigRolloutConfig,
+    ) -> Self {
+        Self {
+            aggregator,
+            secrets,
+            activity,
+            leader,
+            cfg,
+            seen: Mutex::new(HashMap::new()),
+            pending: Mutex::new(BTreeMap::new()),
+            last_batch: Mutex::new(None),
+        }
+    }
+
+    /// Pods waiting to be restarted, optionally only those in `namespace`.
+    pub fn pending(&self, namespace: Option<&str>) -> Vec<PendingRestart> {
+        self.pending
+            .lock()
+            .unwrap()
+            .values()
+            .filter(|p| namespace.is_none_or(|ns| p.namespace == ns))
+            .cloned()
+            .collect()
+    }
+
+    pub fn pending_for(&self, namespace: &str, name: &str) -> Option<PendingRestart> {
+        self.pending.lock().unwrap().get(&key(namespace, name)).cloned()
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(self.cfg.interval_secs));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    if self.leader.is_leader() {
+                        self.check().await;
+                        self.roll_out().await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("config rollout controller shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    // Fingerprints every source an opted-in pod uses and marks the pods
+    // using one that changed since the last check. A source seen for the
+    // first time only sets the baseline.
+    async fn check(&self) {
+        let pods: Vec<Pod> = self
+            .aggregator
+            .list_all_pods()
+            .await
+            .unwrap_or_default()
+            .into_iter()
+            .filter(|p| opted_in(p) && p.metadata.deletion_timestamp.is_none())
+            .collect();
+        let used: HashSet<Source> = pods.iter().flat_map(sources).collect();
+
+        let mut current = HashMap::new();
+        for s in &used {
+            if let Some(fp) = self.fingerprint(s).await {
+                current.insert(s.clone(), fp);
+            }
+        }
+        let changed: HashSet<Source> = {
+            let mut seen = self.seen.lock().unwrap();
+            let changed = current
+                .iter()
+                .filter(|(s, fp)| seen.get(*s).is_some_and(|old| old != *fp))
+                .map(|(s, _)| s.clone())
+                .collect();
+            seen.retain(|s, _| used.contains(s));
+            seen.extend(current);
+            changed
+        };
+        if changed.is_empty() {
+            return;
+        }
+
+        let mut pending = self.pending.lock().unwrap();
+        for pod in &pods {
+            let Some(s) = sources(pod).into_iter().find(|s| changed.contains(s)) else {
+                continue;
+            };
+            pending
+                .entry(key(&pod.metadata.namespace, &pod.metadata.name))
+                .or_insert_with(|| PendingRestart {
+                    namespace: pod.metadata.namespace.clone(),
+                    name: pod.metadata.name.clone(),
+                    reason: format!("{} changed", s.describe()),
+                    since: Utc::now(),
+                });
+        }
+    }
+
+    async fn fingerprint(&self, s: &Source) -> Option<u64> {
+        let mut h = DefaultHasher::new();
+        match s {
+            Source::ConfigMap { namespace, name } => {
+                let cm = self.aggregator.get_configmap(namespace, name).await.ok()?;
+                cm.data.into_iter().collect::<BTreeMap<_, _>>().hash(&mut h);
+            }
+            Source::Secret(r) => match self.secrets.resolve(&SecretRef::parse(r)?).await {
+                Ok(value) => value.hash(&mut h),
+                Err(e) => {
+                    warn!("config rollout: resolving {}: {}", r, e);
+                    return None;
+                }
+            },
+        }
+        Some(h.finish())
+    }
+
+    // Restarts the next batch of pending pods once the last batch has had
+    // batch_delay_secs to come up.
+    async fn roll_out(&self) {
+        let delay = Duration::from_secs(self.cfg.batch_delay_secs);
+        if self.last_batch.lock().unwrap().is_some_and(|t| t.elapsed() < delay) {
+            return;
+        }
+        let mut queue = self.pending(None);
+        if queue.is_empty() {
+            return;
+        }
+        queue.sort_by_key(|p| p.since);
+
+        let pods: HashMap<String, Pod> = self
+            .aggregator
+            .list_all_pods()
+            .await
+            .unwrap_or_default()
+            .into_iter()
+            .map(|p| (key(&p.metadata.namespace, &p.metadata.name), p))
+            .collect();
+        let mut owners = HashSet::new();
+        let mut batch = Vec::new();
+        for p in queue {
+            let k = key(&p.namespace, &p.name);
+            let Some(pod) = pods.get(&k) else {
+                // Deleted, or replaced by its controller, since it was marked.
+                self.pending.lock().unwrap().remove(&k);
+                continue;
+            };
+            if let Some(owner) = stuck::owner(pod) {
+                if !owners.insert(owner.to_string()) {
+                    continue;
+                }
+            }
+            batch.push((pod, p.reason));
+            if batch.len() == self.cfg.batch_size {
+                break;
+            }
+        }
+        if batch.is_empty() {
+            return;
+        }
+        *self.last_batch.lock().unwrap() = Some(Instant::now());
+        futures_util::future::join_all(batch.into_iter().map(|(pod, reason)| self.restart(pod, reason))).await;
+    }
+
+    async fn restart(&self, pod: &Pod, reason: String) {
+        let namespace = &pod.metadata.namespace;
+        let name = &pod.metadata.name;
+        let node = pod
+            .metadata
+            .annotations
+            .as_ref()
+            .and_then(|a| a.get("mkube.io/node"))
+            .cloned()
+            .unwrap_or_else(|| pod.spec.node_name.clone());
+        // Resolve secrets afresh, or the new pod gets the old values.
+        let mut replacement = stuck::replacement(pod);
+        secrets::unresolve(&mut replacement);
+        let result = match self.secrets.resolve_pod(&mut replacement).await {
+            Ok(_) => restarts::restart_pod(&self.aggregator, &node, pod, replacement, RestartMode::Restart).await,
+            Err(errors) => Err(format!("resolving secrets: {}", errors.join("; "))),
+        };
+        match result {
+            Ok(done) => {
+                self.pending.lock().unwrap().remove(&key(namespace, name));
+                let message = format!("{}; {}", reason, done);
+                self.activity.record("restart", "pod", namespace, name, "system", &message);
+            }
+            // Left pending, to retry with the next batch.
+            Err(e) => warn!("config rollout: restarting {}/{}: {}", namespace, name, e),
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn opted_in(pod: &Pod) -> bool {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(RESTART_ON_CHANGE_ANNOTATION))
+        .is_some_and(|v| v == "true")
+}
+
+// The configmaps a pod reads through env, envFrom or volumes, and the
+// secret references the console resolved into it.
+fn sources(pod: &Pod) -> Vec<Source> {
+    let namespace = &pod.metadata.namespace;
+    let mut names: Vec<&str> = Vec::new();
+    for c in &pod.spec.containers {
+        for e in &c.env {
+            if let Some(n) = e.value_from.as_ref().and_then(|v| v["configMapKeyRef"]["name"].as_str()) {
+                names.push(n);
+            }
+        }
+        for f in &c.env_from {
+            if let Some(n) = f["configMapRef"]["name"].as_str() {
+                names.push(n);
+            }
+        }
+    }
+    names.extend(
+        pod.spec
+            .volumes
+            .iter()
+            .filter_map(|v| v.config_map.as_ref())
+            .map(|c| c.name.as_str()),
+    );
+
+    let mut out: Vec<Source> = names
+        .into_iter()
+        .map(|name| Source::ConfigMap {
+            namespace: namespace.clone(),
+            name: name.to_string(),
+        })
+        .collect();
+    out.extend(secrets::resolved_refs(pod).into_iter().map(|(_, _, r)| Source::Secret(r)));
+    out.dedup();
+    out
+}
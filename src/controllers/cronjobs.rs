@@ -0,0 +1,279 @@
+use chrono::{DateTime, Utc};
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::clients::aggregator::Aggregator;
+use crate::controllers::jobs::finished;
+use crate::cron::CronSchedule;
+use crate::cronjobs::CronJobStore;
+use crate::jobs::{JobError, JobStore};
+use crate::leader::LeaderElector;
+use crate::models::k8s::{CronJob, Job, ObjectReference};
+
+/// Annotation naming the cron job that started a job.
+pub const OWNER_ANNOTATION: &str = "mkube.io/owner-cronjob";
+
+/// Starts jobs from cron jobs when their schedule comes due, honouring
+/// suspend, startingDeadlineSeconds and the concurrency policy, and deletes
+/// finished jobs beyond the history limits. After downtime only the latest
+/// missed run is started, as in Kubernetes. Jobs are named after the cron
+/// job and the scheduled minute, so a run is never started twice.
+pub struct CronJobController {
+    aggregator: Arc<Aggregator>,
+    cron_jobs: Arc<CronJobStore>,
+    jobs: Arc<JobStore>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+}
+
+impl CronJobController {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        cron_jobs: Arc<CronJobStore>,
+        jobs: Arc<JobStore>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            cron_jobs,
+            jobs,
+            activity,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(10));
+        let mut leading = false;
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {}
+                _ = self.cron_jobs.changed() => {}
+                _ = shutdown.changed() => {
+                    info!("cron job controller shutting down");
+                    return;
+                }
+            }
+            let leader = self.leader.is_leader();
+            if leader && !leading {
+                if let Err(e) = self.cron_jobs.reload() {
+                    warn!("cron job controller: reloading cron jobs: {}", e);
+                    continue;
+                }
+            }
+            leading = leader;
+            if leader {
+                self.sync().await;
+            }
+        }
+    }
+
+    async fn sync(&self) {
+        let now = Utc::now();
+        let (jobs, _) = self.jobs.list(None);
+        // "namespace/cron job" -> the jobs it started.
+        let mut owned: HashMap<String, Vec<Job>> = HashMap::new();
+        for job in jobs {
+            if let Some(owner) = owner(&job) {
+                let k = key(&job.metadata.namespace, owner);
+                owned.entry(k).or_default().push(job);
+            }
+        }
+
+        let (cron_jobs, _) = self.cron_jobs.list(None);
+        let known: HashSet<String> = cron_jobs
+            .iter()
+            .map(|c| key(&c.metadata.namespace, &c.metadata.name))
+            .collect();
+        for c in cron_jobs {
+            let jobs = owned
+                .remove(&key(&c.metadata.namespace, &c.metadata.name))
+                .unwrap_or_default();
+            self.sync_cron_job(c, jobs, now).await;
+        }
+
+        // Whatever is left was started by cron jobs that were deleted; with
+        // no data dir they may just have been lost to a restart.
+        if !self.cron_jobs.persisted() {
+            return;
+        }
+        for (owner, jobs) in owned.into_iter().filter(|(o, _)| !known.contains(o)) {
+            for job in &jobs {
+                self.delete_job(job, &format!("cron job {} was deleted", owner)).await;
+            }
+        }
+    }
+
+    async fn sync_cron_job(&self, c: CronJob, mut jobs: Vec<Job>, now: DateTime<Utc>) {
+        let (namespace, name) = (c.metadata.namespace.clone(), c.metadata.name.clone());
+        let mut status = c.status.clone();
+        jobs.sort_by(|a, b| a.metadata.creation_timestamp.cmp(&b.metadata.creation_timestamp));
+
+        if let Some(at) = self.due(&c, now) {
+            status.last_schedule_time = Some(timestamp(at));
+            let late = (now - at).num_seconds();
+            let active: Vec<&Job> = jobs.iter().filter(|j| !finished(j)).collect();
+            if c.spec.starting_deadline_seconds.is_some_and(|d| late > d) {
+                warn!(
+                    "cron job {}/{}: skipping the {} run, {}s late",
+                    namespace,
+                    name,
+                    timestamp(at),
+                    late
+                );
+            } else if c.spec.concurrency_policy == "Forbid" && !active.is_empty() {
+                info!(
+                    "cron job {}/{}: skipping the {} run, the last is still active",
+                    namespace,
+                    name,
+                    timestamp(at)
+                );
+            } else {
+                if c.spec.concurrency_policy == "Replace" {
+                    let replaced: Vec<Job> = active.into_iter().cloned().collect();
+                    for job in &replaced {
+                        self.delete_job(job, &format!("replaced by the next run of cron job {}", name))
+                            .await;
+                    }
+                    jobs.retain(|j| finished(j));
+                }
+                match self.jobs.create(&namespace, scheduled_job(&c, at)) {
+                    Ok(job) => {
+                        let message = format!("cron job {}", name);
+                        self.activity
+                            .record("create", "job", &namespace, &job.metadata.name, "system", &message);
+                        jobs.push(job);
+                    }
+                    // Already started, by this console or the previous leader
+                    Err(JobError::AlreadyExists) => {}
+                    Err(e) => warn!("cron job {}/{}: creating job: {:?}", namespace, name, e),
+                }
+            }
+        }
+
+        // Finished jobs beyond the history limits go, oldest first.
+        let (succeeded, failed): (Vec<&Job>, Vec<&Job>) =
+            jobs.iter().filter(|j| finished(j)).partition(|j| is_complete(j));
+        let mut expired: Vec<Job> = Vec::new();
+        for (list, limit) in [
+            (&succeeded, c.spec.successful_jobs_history_limit),
+            (&failed, c.spec.failed_jobs_history_limit),
+        ] {
+            let excess = list.len().saturating_sub(limit.max(0) as usize);
+            expired.extend(list[..excess].iter().map(|j| (*j).clone()));
+        }
+        for job in &expired {
+            self.delete_job(job, &format!("history limit of cron job {}", name))
+                .await;
+        }
+
+        status.active = jobs
+            .iter()
+            .filter(|j| !finished(j))
+            .map(|j| ObjectReference {
+                kind: "Job".to_string(),
+                namespace: namespace.clone(),
+                name: j.metadata.name.clone(),
+            })
+            .collect();
+        if let Some(last) = succeeded.iter().filter_map(|j| j.status.completion_time.clone()).max() {
+            if status.last_successful_time.as_ref().is_none_or(|t| *t < last) {
+                status.last_successful_time = Some(last);
+            }
+        }
+
+        let names = |refs: &[ObjectReference]| refs.iter().map(|r| r.name.clone()).collect::<Vec<_>>();
+        let unchanged = names(&status.active) == names(&c.status.active)
+            && status.last_schedule_time == c.status.last_schedule_time
+            && status.last_successful_time == c.status.last_successful_time;
+        if !unchanged {
+            // Deleted meanwhile is fine; the next pass removes its jobs.
+            let _ = self.cron_jobs.update_status(&namespace, &name, status);
+        }
+    }
+
+    // The latest time the schedule came due since the last run was
+    // scheduled, or since the cron job was created; None when suspended or
+    // not yet due.
+    fn due(&self, c: &CronJob, now: DateTime<Utc>) -> Option<DateTime<Utc>> {
+        if c.spec.suspend {
+            return None;
+        }
+        let schedule = match CronSchedule::parse(&c.spec.schedule) {
+            Ok(s) => s,
+            Err(e) => {
+                warn!("cron job {}/{}: {}", c.metadata.namespace, c.metadata.name, e);
+                return None;
+            }
+        };
+        let mut t = c
+            .status
+            .last_schedule_time
+            .as_ref()
+            .or(c.metadata.creation_timestamp.as_ref())
+            .and_then(|t| DateTime::parse_from_rfc3339(t).ok())
+            .map(|t| t.with_timezone(&Utc))
+            .unwrap_or(now);
+        let mut due = None;
+        while let Some(next) = schedule.next_after(t).filter(|next| *next <= now) {
+            due = Some(next);
+            t = next;
+        }
+        due
+    }
+
+    async fn delete_job(&self, job: &Job, why: &str) {
+        let (namespace, name) = (&job.metadata.namespace, &job.metadata.name);
+        if self.jobs.delete(namespace, name).is_err() {
+            return;
+        }
+        for run in &job.status.runs {
+            let _ = self.aggregator.delete_pod(namespace, &run.pod).await;
+        }
+        self.activity.record("delete", "job", namespace, name, "system", why);
+    }
+}
+
+/// Cron job that started a job, from its annotation.
+pub fn owner(job: &Job) -> Option<&str> {
+    job.metadata
+        .annotations
+        .as_ref()?
+        .get(OWNER_ANNOTATION)
+        .map(String::as_str)
+}
+
+fn is_complete(job: &Job) -> bool {
+    job.status.conditions.iter().any(|c| c.type_ == "Complete")
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn timestamp(t: DateTime<Utc>) -> String {
+    t.to_rfc3339_opts(chrono::SecondsFormat::Secs, true)
+}
+
+// The job for the run scheduled at `at`, named after the cron job and the
+// minute.
+fn scheduled_job(c: &CronJob, at: DateTime<Utc>) -> Job {
+    let template = &c.spec.job_template;
+    let mut job = Job {
+        metadata: template.metadata.clone(),
+        spec: template.spec.clone(),
+        ..Default::default()
+    };
+    job.metadata.name = format!("{}-{}", c.metadata.name, at.timestamp() / 60);
+    job.metadata.resource_version.clear();
+    job.metadata
+        .annotations
+        .get_or_insert_with(HashMap::new)
+        .insert(OWNER_ANNOTATION.to_string(), c.metadata.name.clone());
+    job
+}
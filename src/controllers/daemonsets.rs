@@ -0,0 +1,295 @@
+use chrono::Utc;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::sync::Arc;
+use tokio::sync::broadcast;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::admission::Admission;
+use crate::clients::aggregator::Aggregator;
+use crate::clients::events::ClusterEvent;
+use crate::daemonsets::{DaemonNodeStatus, MicroDaemonSet, MicroDaemonSetStatus, MicroDaemonSetStore};
+use crate::leader::LeaderElector;
+use crate::models::k8s::Pod;
+use crate::selector::LabelSelector;
+
+/// Annotation naming the daemon set that owns a pod, as "namespace/name".
+pub const OWNER_ANNOTATION: &str = "mkube.io/owner-daemonset";
+
+/// Keeps one pod of each daemon set on every node its selector matches:
+/// creates pods on matching nodes that lack one, replaces pods that failed,
+/// deletes pods from nodes that stopped matching and the pods of deleted
+/// sets. Runs on a timer, when a set is created or deleted, when a node's
+/// health changes and when a set's pod disappears. Nodes that are offline
+/// are reported but left alone until they are back.
+pub struct MicroDaemonSetController {
+    aggregator: Arc<Aggregator>,
+    sets: Arc<MicroDaemonSetStore>,
+    admission: Arc<Admission>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+}
+
+impl MicroDaemonSetController {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        sets: Arc<MicroDaemonSetStore>,
+        admission: Arc<Admission>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            sets,
+            admission,
+            activity,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(30));
+        let mut events = self.aggregator.subscribe();
+
+        let mut leading = false;
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {}
+                _ = self.sets.changed() => {}
+                msg = events.recv() => match msg.map(|e| e.event) {
+                    Ok(ClusterEvent::NodeHealthChanged { .. }) => {}
+                    Ok(ClusterEvent::PodRemoved { pod, .. }) if owner(&pod).is_some() => {}
+                    Err(broadcast::error::RecvError::Closed) => return,
+                    _ => continue,
+                },
+                _ = shutdown.changed() => {
+                    info!("daemon set controller shutting down");
+                    return;
+                }
+            }
+            let leader = self.leader.is_leader();
+            if leader && !leading {
+                if let Err(e) = self.sets.reload() {
+                    warn!("daemon set controller: reloading daemon sets: {}", e);
+                    continue;
+                }
+            }
+            leading = leader;
+            if leader {
+                self.sync().await;
+            }
+        }
+    }
+
+    async fn sync(&self) {
+        let pods = match self.aggregator.list_all_pods().await {
+            Ok(pods) => pods,
+            Err(e) => {
+                warn!("daemon set controller: listing pods: {}", e);
+                return;
+            }
+        };
+        let labels: HashMap<String, HashMap<String, String>> = self
+            .aggregator
+            .list_all_nodes()
+            .await
+            .unwrap_or_default()
+            .into_iter()
+            .map(|n| (n.metadata.name, n.metadata.labels.unwrap_or_default()))
+            .collect();
+        // Node -> (healthy, labels); nodes that couldn't be fetched fall back
+        // to their configured labels.
+        let nodes: BTreeMap<String, (bool, HashMap<String, String>)> = self
+            .aggregator
+            .snapshot_clients()
+            .await
+            .into_iter()
+            .map(|c| {
+                let l = labels.get(&c.name).cloned().unwrap_or_else(|| c.labels.clone());
+                (c.name.clone(), (c.is_healthy(), l))
+            })
+            .collect();
+
+        // Owner -> node -> pod.
+        let mut owned: HashMap<String, HashMap<String, Pod>> = HashMap::new();
+        for pod in pods.into_iter().filter(|p| p.metadata.deletion_timestamp.is_none()) {
+            if let Some(owner) = owner(&pod).map(String::from) {
+                owned.entry(owner).or_default().insert(pod_node(&pod), pod);
+            }
+        }
+
+        let sets = self.sets.list(None);
+        let known: HashSet<String> = sets.iter().map(|s| key(&s.namespace, &s.name)).collect();
+        for set in sets {
+            let pods = owned.remove(&key(&set.namespace, &set.name)).unwrap_or_default();
+            self.sync_set(set, &nodes, pods).await;
+        }
+
+        // Whatever is left belongs to sets that were deleted; without a data
+        // dir it may belong to sets a restart forgot, so it stays.
+        if !self.sets.persisted() {
+            return;
+        }
+        for (owner, pods) in owned.into_iter().filter(|(o, _)| !known.contains(o)) {
+            for pod in pods.values() {
+                let (namespace, name) = (&pod.metadata.namespace, &pod.metadata.name);
+                match self.aggregator.delete_pod(namespace, name).await {
+                    Ok(()) => {
+                        let message = format!("daemon set {} was deleted", owner);
+                        self.activity
+                            .record("delete", "pod", namespace, name, "system", &message);
+                    }
+                    Err(e) => warn!("daemon set {}: deleting pod {}: {}", owner, name, e),
+                }
+            }
+        }
+    }
+
+    async fn sync_set(
+        &self,
+        set: MicroDaemonSet,
+        nodes: &BTreeMap<String, (bool, HashMap<String, String>)>,
+        mut pods: HashMap<String, Pod>,
+    ) {
+        let namespace = &set.namespace;
+        let selector = match LabelSelector::parse(&set.node_selector) {
+            Ok(s) => s,
+            Err(e) => {
+                warn!("daemon set {}/{}: nodeSelector: {}", namespace, set.name, e);
+                return;
+            }
+        };
+        let mut status = MicroDaemonSetStatus {
+            last_sync: Some(Utc::now()),
+            ..Default::default()
+        };
+
+        for (node, (healthy, _)) in nodes.iter().filter(|(_, (_, l))| selector.matches(Some(l))) {
+            status.desired += 1;
+            let mut entry = DaemonNodeStatus {
+                node: node.clone(),
+                healthy: *healthy,
+                ..Default::default()
+            };
+            match pods.remove(node) {
+                // Deleted, so the pass its removal triggers starts a fresh
+                // one. A pod on an offline node is left to come back with it.
+                Some(pod) if *healthy && pod.status.phase == "Failed" => {
+                    let name = &pod.metadata.name;
+                    match self.aggregator.delete_pod(namespace, name).await {
+                        Ok(()) => {
+                            let message = format!("replacing failed pod of daemon set {}", set.name);
+                            self.activity
+                                .record("delete", "pod", namespace, name, "system", &message);
+                        }
+                        Err(e) => warn!(
+                            "daemon set {}/{}: deleting failed pod {}: {}",
+                            namespace, set.name, name, e
+                        ),
+                    }
+                    entry.pod = pod.metadata.name;
+                    entry.phase = pod.status.phase;
+                }
+                Some(pod) => {
+                    status.current += 1;
+                    if pod.status.phase == "Running" {
+                        status.ready += 1;
+                    }
+                    entry.pod = pod.metadata.name;
+                    entry.phase = pod.status.phase;
+                }
+                None if *healthy => {
+                    let mut pod = set_pod(&set, node);
+                    let created = match self.admission.admit_pod(&mut pod).await {
+                        Ok(()) => self.aggregator.create_pod(&pod).await.map_err(|e| e.to_string()),
+                        Err(e) => Err(e),
+                    };
+                    match created {
+                        Ok(_) => {
+                            status.current += 1;
+                            let message = format!("daemon set {} on {}", set.name, node);
+                            self.activity
+                                .record("create", "pod", namespace, &pod.metadata.name, "system", &message);
+                            entry.pod = pod.metadata.name;
+                            entry.phase = "Pending".to_string();
+                        }
+                        Err(e) => {
+                            warn!("daemon set {}/{}: creating pod on {}: {}", namespace, set.name, node, e);
+                            entry.error = e.to_string();
+                        }
+                    }
+                }
+                None => {}
+            }
+            status.nodes.push(entry);
+        }
+
+        // Pods on nodes that no longer match, where the node can be reached.
+        for (node, pod) in &pods {
+            if !nodes.get(node).is_some_and(|(healthy, _)| *healthy) {
+                continue;
+            }
+            let name = &pod.metadata.name;
+            match self.aggregator.delete_pod(namespace, name).await {
+                Ok(()) => {
+                    let message = format!("{} no longer matches {:?}", node, set.node_selector);
+                    self.activity
+                        .record("delete", "pod", namespace, name, "system", &message);
+                }
+                Err(e) => warn!("daemon set {}/{}: deleting pod {}: {}", namespace, set.name, name, e),
+            }
+        }
+
+        self.sets.update_status(namespace, &set.name, status);
+    }
+}
+
+/// Daemon set owning a pod, as "namespace/name", from its annotation.
+pub fn owner(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .annotations
+        .as_ref()?
+        .get(OWNER_ANNOTATION)
+        .map(String::as_str)
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn pod_node(pod: &Pod) -> String {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get("mkube.io/node"))
+        .cloned()
+        .unwrap_or_else(|| pod.spec.node_name.clone())
+}
+
+// The set's pod for `node`, named after both and pinned to the node.
+fn set_pod(set: &MicroDaemonSet, node: &str) -> Pod {
+    let node_part: String = node
+        .to_lowercase()
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '-' })
+        .collect();
+    let mut pod = Pod {
+        metadata: set.template.metadata.clone(),
+        spec: set.template.spec.clone(),
+        ..Default::default()
+    };
+    pod.metadata.name = format!("{}-{}", set.name, node_part);
+    pod.metadata.namespace = set.namespace.clone();
+    pod.metadata.resource_version.clear();
+    pod.metadata
+        .labels
+        .get_or_insert_with(HashMap::new)
+        .insert("daemonset-name".to_string(), set.name.clone());
+    pod.metadata
+        .annotations
+        .get_or_insert_with(HashMap::new)
+        .insert(OWNER_ANNOTATION.to_string(), key(&set.namespace, &set.name));
+    pod.spec.node_name = node.to_string();
+    pod
+}
@@ -0,0 +1,387 @@
+use chrono::Utc;
+use ring::rand::{SecureRandom, SystemRandom};
+use std::collections::{HashMap, HashSet};
+use std::sync::Arc;
+use tokio::sync::broadcast;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::admission::Admission;
+use crate::clients::aggregator::Aggregator;
+use crate::clients::events::ClusterEvent;
+use crate::controllers::node_health::NodeHealthWatcher;
+use crate::deployments::{DeploymentStore, parse_int_or_percent};
+use crate::leader::LeaderElector;
+use crate::models::k8s::{Deployment, DeploymentStatus, Pod, PodTemplateSpec};
+
+/// Annotation naming the console-managed deployment that owns a pod.
+pub const OWNER_ANNOTATION: &str = "mkube.io/owner-deployment";
+
+/// Label carrying the hash of the template a pod was made from, as in
+/// Kubernetes.
+pub const TEMPLATE_HASH_LABEL: &str = "pod-template-hash";
+
+/// Keeps each console-managed deployment at its replica count across the
+/// nodes, replacing failed pods. When the template changes, pods of the
+/// new template replace the old ones: a few at a time within maxSurge and
+/// maxUnavailable for RollingUpdate, or all old pods first for Recreate.
+/// A pod counts as available once it is Running. Pods on a node the node
+/// health watcher has declared down, after its failover grace or the
+/// longer holdoff for a flapping node, count as gone and are recreated on
+/// other nodes; the console can't delete them meanwhile, so should the node
+/// come back the surplus is scaled away. Runs on a timer, when a deployment
+/// changes and when one of its pods changes phase or goes away.
+pub struct DeploymentController {
+    aggregator: Arc<Aggregator>,
+    deployments: Arc<DeploymentStore>,
+    admission: Arc<Admission>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+    node_health: Arc<NodeHealthWatcher>,
+}
+
+impl DeploymentController {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        deployments: Arc<DeploymentStore>,
+        admission: Arc<Admission>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+        node_health: Arc<NodeHealthWatcher>,
+    ) -> Self {
+        Self {
+            aggregator,
+            deployments,
+            admission,
+            activity,
+            leader,
+            node_health,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(15));
+        let mut events = self.aggregator.subscribe();
+        let mut leading = false;
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {}
+                _ = self.deployments.changed() => {}
+                msg = events.recv() => match msg.map(|e| e.event) {
+                    Ok(ClusterEvent::PodPhaseChanged { pod, .. } | ClusterEvent::PodRemoved { pod, .. })
+                        if owner(&pod).is_some() => {}
+                    Ok(ClusterEvent::NodeHealthChanged { .. }) => {}
+                    Err(broadcast::error::RecvError::Closed) => return,
+                    _ => continue,
+                },
+                _ = shutdown.changed() => {
+                    info!("deployment controller shutting down");
+                    return;
+                }
+            }
+            let leader = self.leader.is_leader();
+            // The previous leader may have changed deployments meanwhile.
+            if leader && !leading {
+                if let Err(e) = self.deployments.reload() {
+                    warn!("deployment controller: reloading deployments: {}", e);
+                    continue;
+                }
+            }
+            leading = leader;
+            if leader {
+                self.sync().await;
+            }
+        }
+    }
+
+    async fn sync(&self) {
+        let pods = match self.aggregator.list_all_pods().await {
+            Ok(pods) => pods,
+            Err(e) => {
+                warn!("deployment controller: listing pods: {}", e);
+                return;
+            }
+        };
+        // "namespace/name" -> the deployment's pods.
+        let mut owned: HashMap<String, Vec<Pod>> = HashMap::new();
+        for pod in pods.into_iter().filter(|p| p.metadata.deletion_timestamp.is_none()) {
+            if let Some(owner) = owner(&pod) {
+                let k = key(&pod.metadata.namespace, owner);
+                owned.entry(k).or_default().push(pod);
+            }
+        }
+
+        let lost = self.node_health.down_nodes().await;
+
+        let (deployments, _) = self.deployments.list(None);
+        let known: HashSet<String> = deployments
+            .iter()
+            .map(|d| key(&d.metadata.namespace, &d.metadata.name))
+            .collect();
+        for d in deployments {
+            let pods = owned
+                .remove(&key(&d.metadata.namespace, &d.metadata.name))
+                .unwrap_or_default();
+            self.sync_deployment(d, pods, &lost).await;
+        }
+
+        // Whatever is left belongs to deployments that were deleted, unless
+        // there is no data dir and they were only forgotten in a restart.
+        if !self.deployments.persisted() {
+            return;
+        }
+        for (owner, pods) in owned.into_iter().filter(|(o, _)| !known.contains(o)) {
+            for pod in &pods {
+                self.delete_pod(pod, &format!("deployment {} was deleted", owner)).await;
+            }
+        }
+    }
+
+    async fn sync_deployment(&self, d: Deployment, pods: Vec<Pod>, lost: &[String]) {
+        let hash = template_hash(&d.spec.template);
+        let replicas = d.spec.replicas.max(0) as usize;
+
+        // Pods on lost nodes are left out, so they're replaced below.
+        let (stranded, pods): (Vec<Pod>, Vec<Pod>) = pods.into_iter().partition(|p| lost.contains(&pod_node(p)));
+        let mut stranded_on: Vec<String> = stranded.iter().map(pod_node).collect();
+        stranded_on.sort();
+        stranded_on.dedup();
+
+        // Failed pods are deleted, and replaced below like missing ones.
+        let (failed, pods): (Vec<Pod>, Vec<Pod>) = pods.into_iter().partition(|p| p.status.phase == "Failed");
+        for pod in &failed {
+            self.delete_pod(pod, &format!("replacing failed pod of deployment {}", d.metadata.name))
+                .await;
+        }
+        let (mut current, mut old): (Vec<Pod>, Vec<Pod>) =
+            pods.into_iter().partition(|p| pod_hash(p) == Some(hash.as_str()));
+
+        // As found at the start of this pass.
+        let status = DeploymentStatus {
+            replicas: (current.len() + old.len()) as i32,
+            ready_replicas: current.iter().chain(&old).filter(|p| is_ready(p)).count() as i32,
+            updated_replicas: current.len() as i32,
+            updated_at: Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true),
+        };
+
+        if d.spec.strategy.type_field == "Recreate" && !old.is_empty() {
+            // The new template starts once every old pod is gone.
+            for pod in &old {
+                self.delete_pod(pod, &format!("recreating deployment {}", d.metadata.name))
+                    .await;
+            }
+            self.write_status(&d, status);
+            return;
+        }
+
+        let (surge, unavailable) = rolling_limits(&d, replicas);
+        let missing = replicas.saturating_sub(current.len());
+        let create = if old.is_empty() {
+            missing
+        } else {
+            missing.min((replicas + surge).saturating_sub(current.len() + old.len()))
+        };
+        for _ in 0..create {
+            let mut pod = deployment_pod(&d, &hash);
+            let created = match self.admission.admit_pod(&mut pod).await {
+                Ok(()) => self.aggregator.create_pod_avoiding(&pod, lost).await.map_err(|e| e.to_string()),
+                Err(e) => Err(e),
+            };
+            match created {
+                Ok(created) => {
+                    let message = if stranded_on.is_empty() {
+                        format!("deployment {}", d.metadata.name)
+                    } else {
+                        format!(
+                            "deployment {}, replacing pods on lost node {}",
+                            d.metadata.name,
+                            stranded_on.join(", ")
+                        )
+                    };
+                    self.activity.record(
+                        "create",
+                        "pod",
+                        &created.metadata.namespace,
+                        &created.metadata.name,
+                        "system",
+                        &message,
+                    );
+                }
+                Err(e) => {
+                    warn!(
+                        "deployment {}/{}: creating pod: {}",
+                        d.metadata.namespace, d.metadata.name, e
+                    );
+                    break;
+                }
+            }
+        }
+
+        // Old pods that aren't available go first and freely; available
+        // ones only while enough stay available.
+        if !old.is_empty() {
+            let available = current.iter().chain(&old).filter(|p| is_ready(p)).count();
+            let mut removable = available.saturating_sub(replicas.saturating_sub(unavailable));
+            old.sort_by_key(|p| is_ready(p));
+            for pod in &old {
+                if is_ready(pod) {
+                    if removable == 0 {
+                        break;
+                    }
+                    removable -= 1;
+                }
+                self.delete_pod(pod, &format!("rolling deployment {}", d.metadata.name))
+                    .await;
+            }
+        }
+
+        // Scaled down: pods that aren't available first, then the newest.
+        if current.len() > replicas {
+            current.sort_by(|a, b| {
+                is_ready(a)
+                    .cmp(&is_ready(b))
+                    .then_with(|| b.metadata.creation_timestamp.cmp(&a.metadata.creation_timestamp))
+            });
+            for pod in &current[..current.len() - replicas] {
+                self.delete_pod(pod, &format!("scaling deployment {} to {}", d.metadata.name, replicas))
+                    .await;
+            }
+        }
+
+        self.write_status(&d, status);
+    }
+
+    async fn delete_pod(&self, pod: &Pod, why: &str) {
+        let (namespace, name) = (&pod.metadata.namespace, &pod.metadata.name);
+        match self.aggregator.delete_pod(namespace, name).await {
+            Ok(()) => self.activity.record("delete", "pod", namespace, name, "system", why),
+            Err(e) => warn!("deployment controller: deleting pod {}/{}: {}", namespace, name, e),
+        }
+    }
+
+    // Writes the status when the counts changed, so updatedAt is when they
+    // last did.
+    fn write_status(&self, d: &Deployment, status: DeploymentStatus) {
+        let unchanged = status.replicas == d.status.replicas
+            && status.ready_replicas == d.status.ready_replicas
+            && status.updated_replicas == d.status.updated_replicas;
+        if !unchanged {
+            // Deleted meanwhile is fine; the next pass removes its pods.
+            let _ = self
+                .deployments
+                .update_status(&d.metadata.namespace, &d.metadata.name, status);
+        }
+    }
+}
+
+/// Console-managed deployment owning a pod, from its annotation.
+pub fn owner(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .annotations
+        .as_ref()?
+        .get(OWNER_ANNOTATION)
+        .map(String::as_str)
+}
+
+fn pod_node(pod: &Pod) -> String {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get("mkube.io/node"))
+        .cloned()
+        .unwrap_or_else(|| pod.spec.node_name.clone())
+}
+
+/// How far the deployment's rollout has got among `pods`: the pods it
+/// owns, those running its current template, and those of them ready.
+pub fn rollout_progress(d: &Deployment, pods: &[Pod]) -> (usize, usize, usize) {
+    let hash = template_hash(&d.spec.template);
+    let owned: Vec<&Pod> = pods
+        .iter()
+        .filter(|p| p.metadata.namespace == d.metadata.namespace && owner(p) == Some(d.metadata.name.as_str()))
+        .collect();
+    let updated: Vec<&&Pod> = owned.iter().filter(|p| pod_hash(p) == Some(hash.as_str())).collect();
+    let ready = updated.iter().filter(|p| is_ready(p)).count();
+    (owned.len(), updated.len(), ready)
+}
+
+fn pod_hash(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .labels
+        .as_ref()?
+        .get(TEMPLATE_HASH_LABEL)
+        .map(String::as_str)
+}
+
+fn is_ready(pod: &Pod) -> bool {
+    pod.status.phase == "Running"
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+// maxSurge and maxUnavailable as pod counts: percentages of the replicas,
+// surge rounded up and unavailable down, each 25% when unset. Both zero
+// would never make progress, so surge is then one.
+fn rolling_limits(d: &Deployment, replicas: usize) -> (usize, usize) {
+    let rolling = d.spec.strategy.rolling_update.clone().unwrap_or_default();
+    let resolve = |v: &Option<serde_json::Value>, round_up: bool| {
+        let (n, percent) = v
+            .as_ref()
+            .and_then(|v| parse_int_or_percent(v).ok())
+            .unwrap_or((25, true));
+        if !percent {
+            return n as usize;
+        }
+        let scaled = replicas * n as usize;
+        if round_up { scaled.div_ceil(100) } else { scaled / 100 }
+    };
+    let surge = resolve(&rolling.max_surge, true);
+    let unavailable = resolve(&rolling.max_unavailable, false);
+    if surge == 0 && unavailable == 0 {
+        (1, 0)
+    } else {
+        (surge, unavailable)
+    }
+}
+
+// Hashes the template, going through serde_json::Value so map order
+// doesn't matter.
+fn template_hash(template: &PodTemplateSpec) -> String {
+    let doc = serde_json::to_value(template).unwrap_or_default();
+    let digest = ring::digest::digest(&ring::digest::SHA256, doc.to_string().as_bytes());
+    digest.as_ref()[..5].iter().map(|b| format!("{:02x}", b)).collect()
+}
+
+// A new pod of the deployment's template, named after the deployment and
+// template hash with a random suffix.
+fn deployment_pod(d: &Deployment, hash: &str) -> Pod {
+    const SUFFIX_CHARS: &[u8] = b"bcdfghjklmnpqrstvwxz2456789";
+    let mut random = [0u8; 5];
+    let _ = SystemRandom::new().fill(&mut random);
+    let suffix: String = random
+        .iter()
+        .map(|b| SUFFIX_CHARS[*b as usize % SUFFIX_CHARS.len()] as char)
+        .collect();
+
+    let template = &d.spec.template;
+    let mut pod = Pod {
+        metadata: template.metadata.clone(),
+        spec: template.spec.clone(),
+        ..Default::default()
+    };
+    pod.metadata.name = format!("{}-{}-{}", d.metadata.name, hash, suffix);
+    pod.metadata.namespace = d.metadata.namespace.clone();
+    pod.metadata.resource_version.clear();
+    let labels = pod.metadata.labels.get_or_insert_with(HashMap::new);
+    labels.insert("deployment-name".to_string(), d.metadata.name.clone());
+    labels.insert(TEMPLATE_HASH_LABEL.to_string(), hash.to_string());
+    pod.metadata
+        .annotations
+        .get_or_insert_with(HashMap::new)
+        .insert(OWNER_ANNOTATION.to_string(), d.metadata.name.clone());
+    pod
+}
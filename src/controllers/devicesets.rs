@@ -0,0 +1,258 @@
+use chrono::Utc;
+use std::collections::{BTreeSet, HashMap, HashSet};
+use std::sync::Arc;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::admission::Admission;
+use crate::clients::aggregator::Aggregator;
+use crate::devicesets::{DeviceSet, DeviceSetNode, DeviceSetStatus, DeviceSetStore};
+use crate::leader::LeaderElector;
+use crate::models::k8s::Pod;
+
+/// Annotation naming the device set that owns a pod, as "namespace/name".
+pub const OWNER_ANNOTATION: &str = "mkube.io/owner-deviceset";
+
+/// Keeps one pod of each device set on every healthy node advertising the
+/// set's device class, DaemonSet-style: creates pods on nodes that gain the
+/// class, deletes them from nodes that lose it, and deletes the pods of sets
+/// that were removed. Pods on nodes that are offline are left alone until
+/// the node is back.
+pub struct DeviceSetController {
+    aggregator: Arc<Aggregator>,
+    sets: Arc<DeviceSetStore>,
+    admission: Arc<Admission>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+}
+
+impl DeviceSetController {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        sets: Arc<DeviceSetStore>,
+        admission: Arc<Admission>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            sets,
+            admission,
+            activity,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(30));
+        let mut leading = false;
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    let leader = self.leader.is_leader();
+                    if leader && !leading {
+                        if let Err(e) = self.sets.reload() {
+                            warn!("device set controller: reloading device sets: {}", e);
+                            continue;
+                        }
+                    }
+                    leading = leader;
+                    if leader {
+                        self.sync().await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("device set controller shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn sync(&self) {
+        let pods = match self.aggregator.list_all_pods().await {
+            Ok(pods) => pods,
+            Err(e) => {
+                warn!("device set controller: listing pods: {}", e);
+                return;
+            }
+        };
+        let healthy: HashSet<String> = self
+            .aggregator
+            .snapshot_clients()
+            .await
+            .into_iter()
+            .filter(|c| c.is_healthy())
+            .map(|c| c.name.clone())
+            .collect();
+        // Device class -> nodes advertising it.
+        let mut classes: HashMap<String, BTreeSet<String>> = HashMap::new();
+        for (node, device) in self.aggregator.list_devices().await {
+            classes.entry(device.kind.to_lowercase()).or_default().insert(node);
+        }
+
+        // Owner -> node -> pod.
+        let mut owned: HashMap<String, HashMap<String, Pod>> = HashMap::new();
+        for pod in pods.into_iter().filter(|p| p.metadata.deletion_timestamp.is_none()) {
+            if let Some(owner) = owner(&pod).map(String::from) {
+                owned.entry(owner).or_default().insert(pod_node(&pod), pod);
+            }
+        }
+
+        let sets = self.sets.list(None);
+        let known: HashSet<String> = sets.iter().map(|s| key(&s.namespace, &s.name)).collect();
+        for set in sets {
+            let nodes = classes.get(&set.device_class).cloned().unwrap_or_default();
+            let pods = owned.remove(&key(&set.namespace, &set.name)).unwrap_or_default();
+            self.sync_set(set, &nodes, pods, &healthy).await;
+        }
+
+        // Whatever is left belongs to sets that were deleted, as long as the
+        // store survives restarts.
+        if !self.sets.persisted() {
+            return;
+        }
+        for (owner, pods) in owned.into_iter().filter(|(o, _)| !known.contains(o)) {
+            for pod in pods.values() {
+                match self
+                    .aggregator
+                    .delete_pod(&pod.metadata.namespace, &pod.metadata.name)
+                    .await
+                {
+                    Ok(()) => self.activity.record(
+                        "delete",
+                        "pod",
+                        &pod.metadata.namespace,
+                        &pod.metadata.name,
+                        "system",
+                        &format!("device set {} was deleted", owner),
+                    ),
+                    Err(e) => warn!("device set {}: deleting pod {}: {}", owner, pod.metadata.name, e),
+                }
+            }
+        }
+    }
+
+    async fn sync_set(
+        &self,
+        set: DeviceSet,
+        nodes: &BTreeSet<String>,
+        mut pods: HashMap<String, Pod>,
+        healthy: &HashSet<String>,
+    ) {
+        let namespace = &set.namespace;
+        let mut status = DeviceSetStatus {
+            desired: nodes.len(),
+            last_sync: Some(Utc::now()),
+            ..Default::default()
+        };
+
+        for node in nodes {
+            if let Some(pod) = pods.remove(node) {
+                status.current += 1;
+                if pod.status.phase == "Running" {
+                    status.ready += 1;
+                }
+                status.nodes.push(DeviceSetNode {
+                    node: node.clone(),
+                    pod: pod.metadata.name.clone(),
+                    phase: pod.status.phase.clone(),
+                    error: String::new(),
+                });
+                continue;
+            }
+            let mut pod = set_pod(&set, node);
+            let mut entry = DeviceSetNode {
+                node: node.clone(),
+                pod: pod.metadata.name.clone(),
+                phase: "Pending".to_string(),
+                error: String::new(),
+            };
+            let created = match self.admission.admit_pod(&mut pod).await {
+                Ok(()) => self.aggregator.create_pod(&pod).await.map_err(|e| e.to_string()),
+                Err(e) => Err(e),
+            };
+            match created {
+                Ok(_) => {
+                    status.current += 1;
+                    let message = format!("device set {} on {}", set.name, node);
+                    self.activity
+                        .record("create", "pod", namespace, &pod.metadata.name, "system", &message);
+                }
+                Err(e) => {
+                    warn!("device set {}/{}: creating pod on {}: {}", namespace, set.name, node, e);
+                    entry.phase = String::new();
+                    entry.error = e.to_string();
+                }
+            }
+            status.nodes.push(entry);
+        }
+
+        // Pods on nodes that no longer advertise the class. A node that is
+        // offline may just not have reported its devices.
+        for (node, pod) in pods.iter().filter(|(node, _)| healthy.contains(*node)) {
+            let name = &pod.metadata.name;
+            match self.aggregator.delete_pod(namespace, name).await {
+                Ok(()) => {
+                    let message = format!("{} no longer has a {} device", node, set.device_class);
+                    self.activity
+                        .record("delete", "pod", namespace, name, "system", &message);
+                }
+                Err(e) => warn!("device set {}/{}: deleting pod {}: {}", namespace, set.name, name, e),
+            }
+        }
+
+        self.sets.update_status(namespace, &set.name, status);
+    }
+}
+
+/// Device set owning a pod, as "namespace/name", from its annotation.
+pub fn owner(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .annotations
+        .as_ref()?
+        .get(OWNER_ANNOTATION)
+        .map(String::as_str)
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn pod_node(pod: &Pod) -> String {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get("mkube.io/node"))
+        .cloned()
+        .unwrap_or_else(|| pod.spec.node_name.clone())
+}
+
+// The set's pod for `node`, named after both and pinned to the node.
+fn set_pod(set: &DeviceSet, node: &str) -> Pod {
+    let node_part: String = node
+        .to_lowercase()
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '-' })
+        .collect();
+    let mut pod = Pod {
+        metadata: set.template.metadata.clone(),
+        spec: set.template.spec.clone(),
+        ..Default::default()
+    };
+    pod.metadata.name = format!("{}-{}", set.name, node_part);
+    pod.metadata.namespace = set.namespace.clone();
+    pod.metadata.resource_version.clear();
+    pod.metadata
+        .labels
+        .get_or_insert_with(HashMap::new)
+        .insert("deviceset-name".to_string(), set.name.clone());
+    pod.metadata
+        .annotations
+        .get_or_insert_with(HashMap::new)
+        .insert(OWNER_ANNOTATION.to_string(), key(&set.namespace, &set.name));
+    pod.spec.node_name = node.to_string();
+    pod
+}
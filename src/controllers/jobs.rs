@@ -0,0 +1,352 @@
+use chrono::{DateTime, Utc};
+use std::collections::{HashMap, HashSet};
+use std::sync::{Arc, Mutex};
+use tokio::sync::broadcast;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::activity::ActivityLog;
+use crate::admission::Admission;
+use crate::clients::aggregator::Aggregator;
+use crate::clients::events::ClusterEvent;
+use crate::jobs::{JobError, JobStore};
+use crate::leader::LeaderElector;
+use crate::models::k8s::{Job, JobCondition, JobRun, Pod};
+
+/// Annotation naming the job that owns a pod.
+pub const OWNER_ANNOTATION: &str = "mkube.io/owner-job";
+
+/// How long a job's pod may be missing from every node before it counts
+/// as failed; covers a node dropping out of one poll.
+const LOST_GRACE_SECS: i64 = 60;
+
+/// Delay before replacing the first failed pod; doubles with each further
+/// failure, up to MAX_BACKOFF_SECS.
+const BASE_BACKOFF_SECS: i64 = 10;
+const MAX_BACKOFF_SECS: i64 = 360;
+
+/// Runs jobs: keeps up to `parallelism` pods running until `completions`
+/// of them have succeeded, replacing failed pods (on other nodes where it
+/// can) until more than `backoffLimit` have failed. Pods are named after the
+/// job and their run number, and kept once finished so their logs stay
+/// readable; deleting the job deletes them.
+pub struct JobController {
+    aggregator: Arc<Aggregator>,
+    jobs: Arc<JobStore>,
+    admission: Arc<Admission>,
+    activity: Arc<ActivityLog>,
+    leader: Arc<LeaderElector>,
+    /// "namespace/pod" -> when the pod was first missing from a listing.
+    missing: Mutex<HashMap<String, DateTime<Utc>>>,
+}
+
+impl JobController {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        jobs: Arc<JobStore>,
+        admission: Arc<Admission>,
+        activity: Arc<ActivityLog>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            jobs,
+            admission,
+            activity,
+            leader,
+            missing: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(10));
+        let mut events = self.aggregator.subscribe();
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {}
+                _ = self.jobs.created() => {}
+                msg = events.recv() => match msg.map(|e| e.event) {
+                    Ok(ClusterEvent::PodPhaseChanged { pod, .. } | ClusterEvent::PodRemoved { pod, .. })
+                        if owner(&pod).is_some() => {}
+                    Err(broadcast::error::RecvError::Closed) => return,
+                    _ => continue,
+                },
+                _ = shutdown.changed() => {
+                    info!("job controller shutting down");
+                    return;
+                }
+            }
+            if self.leader.is_leader() {
+                self.sync().await;
+            }
+        }
+    }
+
+    async fn sync(&self) {
+        let (jobs, _) = self.jobs.list(None);
+        let jobs: Vec<Job> = jobs.into_iter().filter(|j| !finished(j)).collect();
+        if jobs.is_empty() {
+            return;
+        }
+        let pods: HashMap<String, Pod> = match self.aggregator.list_all_pods().await {
+            Ok(pods) => pods
+                .into_iter()
+                .map(|p| (format!("{}/{}", p.metadata.namespace, p.metadata.name), p))
+                .collect(),
+            Err(e) => {
+                warn!("job controller: listing pods: {}", e);
+                return;
+            }
+        };
+        for job in jobs {
+            self.sync_job(job, &pods).await;
+        }
+    }
+
+    async fn sync_job(&self, mut job: Job, pods: &HashMap<String, Pod>) {
+        let namespace = job.metadata.namespace.clone();
+        let name = job.metadata.name.clone();
+        let now = Utc::now();
+        let status = &mut job.status;
+        let mut changed = false;
+
+        if status.start_time.is_none() {
+            status.start_time = Some(timestamp(now));
+            changed = true;
+        }
+
+        // Bring the runs still in flight up to date with their pods.
+        for run in status.runs.iter_mut().filter(|r| !is_terminal(&r.phase)) {
+            let key = format!("{}/{}", namespace, run.pod);
+            match pods.get(&key) {
+                Some(pod) => {
+                    self.missing.lock().unwrap().remove(&key);
+                    changed |= observe(run, pod, now);
+                }
+                None => {
+                    let mut missing = self.missing.lock().unwrap();
+                    let since = *missing.entry(key.clone()).or_insert(now);
+                    if (now - since).num_seconds() >= LOST_GRACE_SECS {
+                        missing.remove(&key);
+                        run.phase = "Failed".to_string();
+                        run.reason = "PodLost".to_string();
+                        run.finished_at = Some(timestamp(now));
+                        changed = true;
+                    }
+                }
+            }
+        }
+
+        let count = |phase: &str| status.runs.iter().filter(|r| r.phase == phase).count() as i32;
+        let (succeeded, failed) = (count("Succeeded"), count("Failed"));
+        let active = status.runs.len() as i32 - succeeded - failed;
+        changed |= (status.active, status.succeeded, status.failed) != (active, succeeded, failed);
+        status.active = active;
+        status.succeeded = succeeded;
+        status.failed = failed;
+
+        if succeeded >= job.spec.completions {
+            job.status.completion_time = Some(timestamp(now));
+            finish(&mut job, "Complete", "", now);
+            let message = match failed {
+                0 => "completed".to_string(),
+                n => format!("completed after {} failed pods", n),
+            };
+            self.activity.record("complete", "job", &namespace, &name, "system", &message);
+            changed = true;
+        } else if failed > job.spec.backoff_limit {
+            let active_pods: Vec<String> = job
+                .status
+                .runs
+                .iter()
+                .filter(|r| !is_terminal(&r.phase))
+                .map(|r| r.pod.clone())
+                .collect();
+            for pod in &active_pods {
+                if let Err(e) = self.aggregator.delete_pod(&namespace, pod).await {
+                    warn!("job {}/{}: deleting pod {}: {}", namespace, name, pod, e);
+                }
+            }
+            for run in job.status.runs.iter_mut().filter(|r| !is_terminal(&r.phase)) {
+                run.phase = "Failed".to_string();
+                run.reason = "BackoffLimitExceeded".to_string();
+                run.finished_at = Some(timestamp(now));
+            }
+            job.status.failed += active_pods.len() as i32;
+            let message = format!("{} pods failed, more than the backoff limit of {}", failed, job.spec.backoff_limit);
+            finish(&mut job, "Failed", &message, now);
+            self.activity.record("fail", "job", &namespace, &name, "system", &message);
+            changed = true;
+        } else if backoff_elapsed(&job, now) {
+            let want = job.spec.parallelism.min(job.spec.completions - succeeded) - active;
+            // Nodes this job's pods failed on; new pods go elsewhere if they can.
+            let avoid: Vec<String> = job
+                .status
+                .runs
+                .iter()
+                .filter(|r| r.phase == "Failed" && !r.node.is_empty())
+                .map(|r| r.node.clone())
+                .collect::<HashSet<_>>()
+                .into_iter()
+                .collect();
+            for _ in 0..want.max(0) {
+                let mut pod = job_pod(&job, job.status.runs.len());
+                let created = match self.admission.admit_pod(&mut pod).await {
+                    Ok(()) => self.aggregator.create_pod_avoiding(&pod, &avoid).await.map_err(|e| e.to_string()),
+                    Err(e) => Err(e),
+                };
+                match created {
+                    Ok(created) => {
+                        job.status.runs.push(JobRun {
+                            pod: pod.metadata.name.clone(),
+                            node: created.spec.node_name,
+                            phase: "Pending".to_string(),
+                            created_at: Some(timestamp(now)),
+                            ..Default::default()
+                        });
+                        job.status.active += 1;
+                        changed = true;
+                    }
+                    Err(e) => {
+                        warn!("job {}/{}: creating pod {}: {}", namespace, name, pod.metadata.name, e);
+                        break;
+                    }
+                }
+            }
+        }
+
+        if !changed {
+            return;
+        }
+        if let Err(JobError::NotFound) = self.jobs.update_status(&namespace, &name, job.status.clone()) {
+            // Deleted while this pass ran; clean up what it started.
+            for run in job.status.runs.iter().filter(|r| !is_terminal(&r.phase)) {
+                let _ = self.aggregator.delete_pod(&namespace, &run.pod).await;
+            }
+        }
+    }
+}
+
+/// Job owning a pod, from its annotation.
+pub fn owner(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .annotations
+        .as_ref()?
+        .get(OWNER_ANNOTATION)
+        .map(String::as_str)
+}
+
+pub fn finished(job: &Job) -> bool {
+    job.status
+        .conditions
+        .iter()
+        .any(|c| (c.type_ == "Complete" || c.type_ == "Failed") && c.status == "True")
+}
+
+fn is_terminal(phase: &str) -> bool {
+    phase == "Succeeded" || phase == "Failed"
+}
+
+fn timestamp(t: DateTime<Utc>) -> String {
+    t.to_rfc3339_opts(chrono::SecondsFormat::Secs, true)
+}
+
+fn finish(job: &mut Job, condition: &str, message: &str, now: DateTime<Utc>) {
+    job.status.active = 0;
+    job.status.conditions.push(JobCondition {
+        type_: condition.to_string(),
+        status: "True".to_string(),
+        reason: match condition {
+            "Failed" => "BackoffLimitExceeded".to_string(),
+            _ => String::new(),
+        },
+        message: message.to_string(),
+        last_transition_time: Some(timestamp(now)),
+    });
+}
+
+// Updates a run from its pod; true if anything changed. Pods whose
+// containers have all exited count as finished even if the node hasn't
+// moved the pod's phase on yet.
+fn observe(run: &mut JobRun, pod: &Pod, now: DateTime<Utc>) -> bool {
+    let before = (run.phase.clone(), run.exit_code, run.node.clone());
+    let statuses = &pod.status.container_statuses;
+    let terminated: Vec<_> = statuses.iter().filter_map(|s| s.state.terminated.as_ref()).collect();
+    let exit_code = terminated
+        .iter()
+        .map(|t| t.exit_code)
+        .find(|&c| c != 0)
+        .or(terminated.first().map(|t| t.exit_code));
+
+    run.phase = match pod.status.phase.as_str() {
+        "Succeeded" | "Failed" => pod.status.phase.clone(),
+        _ if !statuses.is_empty() && terminated.len() == statuses.len() => {
+            (if exit_code == Some(0) { "Succeeded" } else { "Failed" }).to_string()
+        }
+        "" => "Pending".to_string(),
+        phase => phase.to_string(),
+    };
+    run.exit_code = exit_code;
+    if let Some(node) = pod.metadata.annotations.as_ref().and_then(|a| a.get("mkube.io/node")) {
+        run.node = node.clone();
+    }
+    if is_terminal(&run.phase) {
+        run.reason = terminated
+            .iter()
+            .find(|t| t.exit_code != 0)
+            .map(|t| t.reason.clone())
+            .unwrap_or_default();
+        run.finished_at = terminated
+            .iter()
+            .filter_map(|t| t.finished_at.clone())
+            .max()
+            .or_else(|| Some(timestamp(now)));
+    }
+    before != (run.phase.clone(), run.exit_code, run.node.clone())
+}
+
+// Whether enough time has passed since the latest failure to start a
+// replacement.
+fn backoff_elapsed(job: &Job, now: DateTime<Utc>) -> bool {
+    let failed = job.status.failed;
+    if failed == 0 {
+        return true;
+    }
+    let last = job
+        .status
+        .runs
+        .iter()
+        .filter(|r| r.phase == "Failed")
+        .filter_map(|r| r.finished_at.as_deref())
+        .filter_map(|t| DateTime::parse_from_rfc3339(t).ok())
+        .max();
+    let Some(last) = last else {
+        return true;
+    };
+    let delay = (BASE_BACKOFF_SECS << (failed - 1).min(6)).min(MAX_BACKOFF_SECS);
+    (now - last.to_utc()).num_seconds() >= delay
+}
+
+// The pod for a job's run number `index`.
+fn job_pod(job: &Job, index: usize) -> Pod {
+    let template = &job.spec.template;
+    let mut pod = Pod {
+        metadata: template.metadata.clone(),
+        spec: template.spec.clone(),
+        ..Default::default()
+    };
+    pod.metadata.name = format!("{}-{}", job.metadata.name, index);
+    pod.metadata.namespace = job.metadata.namespace.clone();
+    pod.metadata.resource_version.clear();
+    pod.metadata
+        .labels
+        .get_or_insert_with(HashMap::new)
+        .insert("job-name".to_string(), job.metadata.name.clone());
+    pod.metadata
+        .annotations
+        .get_or_insert_with(HashMap::new)
+        .insert(OWNER_ANNOTATION.to_string(), job.metadata.name.clone());
+    pod.spec.restart_policy = "Never".to_string();
+    pod
+}
@@ -0,0 +1,13 @@
+pub mod activity;
+pub mod alert_rules;
+pub mod bandwidth;
+pub mod builds;
+pub mod config_rollout;
+pub mod cronjobs;
+pub mod daemonsets;
+pub mod deployments;
+pub mod devicesets;
+pub mod jobs;
+pub mod node_health;
+pub mod pod_failure;
+pub mod restart_loop;
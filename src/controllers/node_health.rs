@@ -0,0 +1,140 @@
+use chrono::Utc;
+use std::sync::Arc;
+use tokio::time::{self, Duration};
+use tracing::info;
+
+use crate::alerts::AlertManager;
+use crate::clients::NodeClient;
+use crate::clients::aggregator::Aggregator;
+use crate::config::NodeHealthConfig;
+use crate::leader::LeaderElector;
+
+/// Turns the health checker's liveness history into node-down and flapping
+/// alerts. A node is only declared down (the trigger for failover) after it
+/// has been unreachable for the grace period; flapping nodes get the longer
+/// holdoff so a node bouncing up and down doesn't cause churn.
+pub struct NodeHealthWatcher {
+    aggregator: Arc<Aggregator>,
+    alerts: Arc<AlertManager>,
+    cfg: NodeHealthConfig,
+    leader: Arc<LeaderElector>,
+}
+
+impl NodeHealthWatcher {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        alerts: Arc<AlertManager>,
+        cfg: NodeHealthConfig,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            alerts,
+            cfg,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(15));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    if self.leader.is_leader() {
+                        self.check().await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("node health watcher shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    /// Nodes past the point of failover: unhealthy for longer than their
+    /// grace, or the flap holdoff while they flap. Controllers replacing
+    /// pods elsewhere go by this, so they wait exactly as long as the
+    /// node-down alert does.
+    pub async fn down_nodes(&self) -> Vec<String> {
+        let now = Utc::now();
+        self.aggregator
+            .snapshot_clients()
+            .await
+            .into_iter()
+            .filter(|c| {
+                let Some(since) = c.unhealthy_since() else {
+                    return false;
+                };
+                let (_, flapping) = self.flapping(c);
+                (now - since).num_seconds() >= self.grace(c, flapping)
+            })
+            .map(|c| c.name.clone())
+            .collect()
+    }
+
+    // The node's flap score and whether it reaches the threshold.
+    fn flapping(&self, c: &NodeClient) -> (usize, bool) {
+        let score = c.flap_score(chrono::Duration::minutes(self.cfg.flap_window_minutes));
+        (score, score >= self.cfg.flap_threshold)
+    }
+
+    // How long the node must stay unhealthy before it counts as down.
+    fn grace(&self, c: &NodeClient, flapping: bool) -> i64 {
+        if flapping {
+            self.cfg.flap_holdoff_secs
+        } else {
+            c.failover_grace_secs.unwrap_or(self.cfg.failover_grace_secs)
+        }
+    }
+
+    async fn check(&self) {
+        for c in self.aggregator.snapshot_clients().await {
+            let flap_key = format!("node-flapping/{}", c.name);
+            let down_key = format!("node-down/{}", c.name);
+
+            let (score, flapping) = self.flapping(&c);
+            if flapping {
+                self.alerts.raise(
+                    &flap_key,
+                    "warning",
+                    &format!("Node {} is flapping", c.name),
+                    &format!(
+                        "{} health transitions in the last {} minutes",
+                        score, self.cfg.flap_window_minutes
+                    ),
+                );
+            } else {
+                self.alerts.resolve(&flap_key);
+            }
+
+            let since = match c.unhealthy_since() {
+                Some(t) => t,
+                None => {
+                    self.alerts.resolve(&down_key);
+                    continue;
+                }
+            };
+
+            let grace = self.grace(&c, flapping);
+            let down_for = (Utc::now() - since).num_seconds();
+            if down_for < grace {
+                if flapping && !self.alerts.is_firing(&down_key) {
+                    info!(
+                        "node {} unhealthy for {}s but flapping; deferring failover until {}s",
+                        c.name, down_for, grace
+                    );
+                }
+                continue;
+            }
+
+            self.alerts.raise(
+                &down_key,
+                "critical",
+                &format!("Node {} is down", c.name),
+                &format!("unreachable since {}", since.to_rfc3339()),
+            );
+        }
+    }
+}
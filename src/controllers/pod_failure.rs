@@ -0,0 +1,136 @@
+use std::collections::HashSet;
+use std::sync::Arc;
+use tokio::sync::broadcast;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::alerts::AlertManager;
+use crate::clients::aggregator::Aggregator;
+use crate::clients::events::ClusterEvent;
+use crate::leader::LeaderElector;
+use crate::models::k8s::Pod;
+
+const KEY_PREFIX: &str = "pod-failed/";
+
+/// Full pass over all pods, catching up on whatever events couldn't cover
+/// (a leadership change, a lagging subscription).
+const RESYNC_INTERVAL: Duration = Duration::from_secs(300);
+
+/// Raises an alert for every pod in the Failed phase and resolves it once the
+/// pod recovers or is deleted. Follows the aggregator's pod events, with a
+/// periodic full resync.
+pub struct PodFailureWatcher {
+    aggregator: Arc<Aggregator>,
+    alerts: Arc<AlertManager>,
+    leader: Arc<LeaderElector>,
+}
+
+impl PodFailureWatcher {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        alerts: Arc<AlertManager>,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            alerts,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut events = self.aggregator.subscribe();
+        let mut interval = time::interval(RESYNC_INTERVAL);
+        let mut was_leader = false;
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    was_leader = self.leader.is_leader();
+                    if was_leader {
+                        self.check().await;
+                    }
+                }
+                msg = events.recv() => {
+                    let is_leader = self.leader.is_leader();
+                    match msg {
+                        // Events before taking over leadership weren't
+                        // handled, so start from a full pass
+                        Ok(_) | Err(broadcast::error::RecvError::Lagged(_)) if is_leader && !was_leader => {
+                            self.check().await;
+                        }
+                        Ok(e) if is_leader => self.handle(&e.event),
+                        Err(broadcast::error::RecvError::Lagged(_)) if is_leader => self.check().await,
+                        Err(broadcast::error::RecvError::Closed) => return,
+                        _ => {}
+                    }
+                    was_leader = is_leader;
+                }
+                _ = shutdown.changed() => {
+                    info!("pod failure watcher shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn check(&self) {
+        let pods = match self.aggregator.list_all_pods().await {
+            Ok(p) => p,
+            Err(e) => {
+                warn!("pod failure watcher: listing pods: {}", e);
+                return;
+            }
+        };
+
+        let mut failed = HashSet::new();
+        for pod in pods.iter().filter(|p| p.status.phase == "Failed") {
+            failed.insert(self.raise(pod));
+        }
+
+        for a in self.alerts.firing() {
+            if a.key.starts_with(KEY_PREFIX) && !failed.contains(&a.key) {
+                self.alerts.resolve(&a.key);
+            }
+        }
+    }
+
+    fn handle(&self, event: &ClusterEvent) {
+        match event {
+            ClusterEvent::PodAdded { pod, .. } | ClusterEvent::PodPhaseChanged { pod, .. } => {
+                if pod.status.phase == "Failed" {
+                    self.raise(pod);
+                } else {
+                    self.alerts.resolve(&alert_key(&pod.metadata.namespace, &pod.metadata.name));
+                }
+            }
+            ClusterEvent::PodRemoved { pod, .. } => {
+                self.alerts.resolve(&alert_key(&pod.metadata.namespace, &pod.metadata.name));
+            }
+            ClusterEvent::NodeHealthChanged { .. } => {}
+        }
+    }
+
+    // Raises the failure alert for `pod` and returns its key.
+    fn raise(&self, pod: &Pod) -> String {
+        let key = alert_key(&pod.metadata.namespace, &pod.metadata.name);
+        let reason = pod
+            .status
+            .container_statuses
+            .iter()
+            .find_map(|cs| cs.state.terminated.as_ref())
+            .map(|t| format!("{} (exit {})", t.reason, t.exit_code))
+            .unwrap_or_else(|| "no termination reason reported".to_string());
+        self.alerts.raise(
+            &key,
+            "critical",
+            &format!("Pod {}/{} failed", pod.metadata.namespace, pod.metadata.name),
+            &reason,
+        );
+        key
+    }
+}
+
+fn alert_key(namespace: &str, name: &str) -> String {
+    format!("{}{}/{}", KEY_PREFIX, namespace, name)
+}
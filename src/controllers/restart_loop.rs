@@ -0,0 +1,214 @@
+use chrono::{DateTime, Utc};
+use std::collections::{HashMap, HashSet, VecDeque};
+use std::sync::{Arc, Mutex};
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::alerts::AlertManager;
+use crate::archive::{ArchiveEntry, HistoryArchive};
+use crate::clients::aggregator::Aggregator;
+use crate::clients::LogOptions;
+use crate::config::RestartCaptureConfig;
+use crate::leader::LeaderElector;
+use crate::models::k8s::ContainerStateTerminated;
+
+/// Watches container restart counts and, when a container restarts more than
+/// `max_restarts` times within `window_minutes`, archives its previous-instance
+/// logs and termination state and raises an alert.
+pub struct RestartLoopWatcher {
+    aggregator: Arc<Aggregator>,
+    alerts: Arc<AlertManager>,
+    archive: Arc<HistoryArchive>,
+    cfg: RestartCaptureConfig,
+    leader: Arc<LeaderElector>,
+    tracked: Mutex<HashMap<String, RestartTrack>>,
+}
+
+struct RestartTrack {
+    last_count: i32,
+    restarts: VecDeque<DateTime<Utc>>,
+    captured_at: Option<DateTime<Utc>>,
+}
+
+struct Capture {
+    key: String,
+    namespace: String,
+    pod: String,
+    container: String,
+    node: String,
+    restart_count: i32,
+    recent_restarts: usize,
+    termination: Option<ContainerStateTerminated>,
+}
+
+impl RestartLoopWatcher {
+    pub fn new(
+        aggregator: Arc<Aggregator>,
+        alerts: Arc<AlertManager>,
+        archive: Arc<HistoryArchive>,
+        cfg: RestartCaptureConfig,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        Self {
+            aggregator,
+            alerts,
+            archive,
+            cfg,
+            leader,
+            tracked: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(30));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    if self.leader.is_leader() {
+                        self.check().await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("restart loop watcher shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn check(&self) {
+        let pods = match self.aggregator.list_all_pods().await {
+            Ok(p) => p,
+            Err(e) => {
+                warn!("restart loop watcher: listing pods: {}", e);
+                return;
+            }
+        };
+
+        let now = Utc::now();
+        let window = chrono::Duration::minutes(self.cfg.window_minutes);
+        let mut captures = Vec::new();
+        let mut quiet = Vec::new();
+
+        {
+            let mut tracked = self.tracked.lock().unwrap();
+            let mut seen = HashSet::new();
+
+            for pod in &pods {
+                let node = pod
+                    .metadata
+                    .annotations
+                    .as_ref()
+                    .and_then(|a| a.get("mkube.io/node"))
+                    .cloned()
+                    .unwrap_or_default();
+
+                for cs in &pod.status.container_statuses {
+                    let key = format!("{}/{}/{}", pod.metadata.namespace, pod.metadata.name, cs.name);
+                    seen.insert(key.clone());
+
+                    // Restarts that happened before we first saw the container
+                    // have unknown timing, so only count increments from here.
+                    let track = tracked.entry(key.clone()).or_insert_with(|| RestartTrack {
+                        last_count: cs.restart_count,
+                        restarts: VecDeque::new(),
+                        captured_at: None,
+                    });
+
+                    if cs.restart_count > track.last_count {
+                        let delta = (cs.restart_count - track.last_count) as usize;
+                        for _ in 0..delta.min(self.cfg.max_restarts + 1) {
+                            track.restarts.push_back(now);
+                        }
+                    }
+                    track.last_count = cs.restart_count;
+
+                    while track.restarts.front().map(|t| now - *t > window).unwrap_or(false) {
+                        track.restarts.pop_front();
+                    }
+
+                    if track.restarts.is_empty() {
+                        quiet.push(key);
+                        continue;
+                    }
+
+                    let recently_captured = track
+                        .captured_at
+                        .map(|t| now - t < window)
+                        .unwrap_or(false);
+                    if track.restarts.len() > self.cfg.max_restarts && !recently_captured {
+                        track.captured_at = Some(now);
+                        captures.push(Capture {
+                            key,
+                            namespace: pod.metadata.namespace.clone(),
+                            pod: pod.metadata.name.clone(),
+                            container: cs.name.clone(),
+                            node: node.clone(),
+                            restart_count: cs.restart_count,
+                            recent_restarts: track.restarts.len(),
+                            termination: cs
+                                .last_state
+                                .terminated
+                                .clone()
+                                .or_else(|| cs.state.terminated.clone()),
+                        });
+                    }
+                }
+            }
+
+            // Containers that disappeared (pod deleted) no longer loop
+            tracked.retain(|k, _| {
+                let keep = seen.contains(k);
+                if !keep {
+                    quiet.push(k.clone());
+                }
+                keep
+            });
+        }
+
+        for key in quiet {
+            self.alerts.resolve(&format!("restart-loop/{}", key));
+        }
+
+        for c in captures {
+            self.capture(c).await;
+        }
+    }
+
+    async fn capture(&self, c: Capture) {
+        let opts = LogOptions {
+            container: Some(c.container.clone()),
+            previous: true,
+            tail_lines: Some(self.cfg.log_lines),
+            ..Default::default()
+        };
+        let logs = match self.aggregator.get_pod_log(&c.namespace, &c.pod, &opts).await {
+            Ok(l) => l,
+            Err(e) => format!("(previous instance logs unavailable: {})", e),
+        };
+
+        let id = self.archive.record(ArchiveEntry {
+            id: 0,
+            kind: "restart-loop".to_string(),
+            captured_at: Utc::now(),
+            namespace: c.namespace.clone(),
+            pod: c.pod.clone(),
+            container: c.container.clone(),
+            node: c.node,
+            restart_count: c.restart_count,
+            termination: c.termination,
+            logs,
+        });
+
+        self.alerts.raise(
+            &format!("restart-loop/{}", c.key),
+            "warning",
+            &format!(
+                "{}/{} container {} restarted {} times in {}m",
+                c.namespace, c.pod, c.container, c.recent_restarts, self.cfg.window_minutes
+            ),
+            &format!("Previous instance logs and termination state captured as archive entry #{}", id),
+        );
+    }
+}
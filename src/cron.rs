@@ -0,0 +1,149 @@
+use chrono::{DateTime, Datelike, Duration, NaiveDate, Timelike, Utc};
+
+/// A parsed cron schedule: the standard five fields (minute, hour, day of
+/// month, month, day of week) with lists, ranges, steps and month and day
+/// names, or one of the @yearly, @monthly, @weekly, @daily and @hourly
+/// macros. Times are UTC. As in cron, when both day fields are restricted
+/// a day matching either one counts.
+#[derive(Debug, Clone)]
+pub struct CronSchedule {
+    minutes: u64,
+    hours: u64,
+    days: u64,
+    months: u64,
+    weekdays: u64,
+    /// Neither day field is "*", so they are ORed.
+    either_day: bool,
+}
+
+const MONTH_NAMES: &[&str] = &[
+    "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec",
+];
+const DAY_NAMES: &[&str] = &["sun", "mon", "tue", "wed", "thu", "fri", "sat"];
+
+/// How far ahead next_after looks before deciding a schedule such as
+/// "0 0 30 2 *" never fires.
+const SEARCH_YEARS: i32 = 5;
+
+impl CronSchedule {
+    pub fn parse(spec: &str) -> Result<Self, String> {
+        let spec = spec.trim();
+        let expanded = match spec {
+            "@yearly" | "@annually" => "0 0 1 1 *",
+            "@monthly" => "0 0 1 * *",
+            "@weekly" => "0 0 * * 0",
+            "@daily" | "@midnight" => "0 0 * * *",
+            "@hourly" => "0 * * * *",
+            s if s.starts_with('@') => return Err(format!("unknown schedule macro {:?}", s)),
+            s => s,
+        };
+        let fields: Vec<&str> = expanded.split_whitespace().collect();
+        if fields.len() != 5 {
+            return Err(format!(
+                "{:?} needs 5 fields: minute hour day-of-month month day-of-week",
+                spec
+            ));
+        }
+        let mut weekdays = parse_field(fields[4], 0, 7, DAY_NAMES).map_err(|e| format!("day of week: {}", e))?;
+        // 7 is Sunday too
+        if weekdays & (1 << 7) != 0 {
+            weekdays |= 1;
+        }
+        Ok(Self {
+            minutes: parse_field(fields[0], 0, 59, &[]).map_err(|e| format!("minute: {}", e))?,
+            hours: parse_field(fields[1], 0, 23, &[]).map_err(|e| format!("hour: {}", e))?,
+            days: parse_field(fields[2], 1, 31, &[]).map_err(|e| format!("day of month: {}", e))?,
+            months: parse_field(fields[3], 1, 12, MONTH_NAMES).map_err(|e| format!("month: {}", e))?,
+            weekdays,
+            either_day: !fields[2].starts_with('*') && !fields[4].starts_with('*'),
+        })
+    }
+
+    /// The first time the schedule fires strictly after `t`.
+    pub fn next_after(&self, t: DateTime<Utc>) -> Option<DateTime<Utc>> {
+        let mut t = t.with_second(0)?.with_nanosecond(0)? + Duration::minutes(1);
+        let limit = t.year() + SEARCH_YEARS;
+        while t.year() <= limit {
+            if !bit(self.months, t.month()) {
+                let (y, m) = if t.month() == 12 {
+                    (t.year() + 1, 1)
+                } else {
+                    (t.year(), t.month() + 1)
+                };
+                t = NaiveDate::from_ymd_opt(y, m, 1)?.and_hms_opt(0, 0, 0)?.and_utc();
+                continue;
+            }
+            if !self.day_matches(t) {
+                t = (t.date_naive() + Duration::days(1)).and_hms_opt(0, 0, 0)?.and_utc();
+                continue;
+            }
+            if !bit(self.hours, t.hour()) {
+                t = t.with_minute(0)? + Duration::hours(1);
+                continue;
+            }
+            if !bit(self.minutes, t.minute()) {
+                t += Duration::minutes(1);
+                continue;
+            }
+            return Some(t);
+        }
+        None
+    }
+
+    fn day_matches(&self, t: DateTime<Utc>) -> bool {
+        let dom = bit(self.days, t.day());
+        let dow = bit(self.weekdays, t.weekday().num_days_from_sunday());
+        if self.either_day { dom || dow } else { dom && dow }
+    }
+}
+
+fn bit(set: u64, n: u32) -> bool {
+    set & (1 << n) != 0
+}
+
+// One field as a bitset: comma-separated items, each "*", a value or
+// "a-b" range, optionally with a "/step".
+fn parse_field(field: &str, min: u32, max: u32, names: &[&str]) -> Result<u64, String> {
+    let mut set = 0u64;
+    for item in field.split(',') {
+        let (range, step) = match item.split_once('/') {
+            Some((r, s)) => match s.parse::<u32>() {
+                Ok(s) if s > 0 => (r, s),
+                _ => return Err(format!("bad step in {:?}", item)),
+            },
+            None => (item, 1),
+        };
+        let (lo, hi) = if range == "*" {
+            (min, max)
+        } else if let Some((a, b)) = range.split_once('-') {
+            (value(a, names)?, value(b, names)?)
+        } else {
+            let v = value(range, names)?;
+            // "5/15" runs from 5 to the end, as in cron
+            (v, if item.contains('/') { max } else { v })
+        };
+        if lo < min || hi > max {
+            return Err(format!("{:?} is outside {}-{}", item, min, max));
+        }
+        if lo > hi {
+            return Err(format!("{:?} runs backwards", item));
+        }
+        for n in (lo..=hi).step_by(step as usize) {
+            set |= 1 << n;
+        }
+    }
+    Ok(set)
+}
+
+// A number or a name; names count from 0 for days and 1 for months.
+fn value(s: &str, names: &[&str]) -> Result<u32, String> {
+    if let Ok(n) = s.parse::<u32>() {
+        return Ok(n);
+    }
+    let offset = if names.len() == 12 { 1 } else { 0 };
+    names
+        .iter()
+        .position(|n| n.eq_ignore_ascii_case(s))
+        .map(|i| i as u32 + offset)
+        .ok_or_else(|| format!("{:?} is not a number", s))
+}
@@ -0,0 +1,236 @@
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::sync::Notify;
+use tracing::warn;
+
+use crate::cron::CronSchedule;
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::{CronJob, CronJobStatus, TypeMeta};
+
+#[derive(Debug)]
+pub enum CronJobError {
+    NotFound,
+    AlreadyExists,
+    /// The update was based on an older resourceVersion.
+    Conflict,
+    Invalid(String),
+}
+
+/// Cron jobs kept by the console, since nodes have no scheduler of their
+/// own: a job template and a schedule that the cron job controller
+/// (controllers/cronjobs.rs) starts jobs from. Persisted under the data dir
+/// when there is one.
+pub struct CronJobStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<CronJobState>,
+    changed: Notify,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct CronJobState {
+    version: u64,
+    /// Keyed by "namespace/name".
+    cron_jobs: BTreeMap<String, CronJob>,
+}
+
+impl CronJobStore {
+    /// An unreadable store is an error, not an empty one whose controller
+    /// would then delete the jobs of every cron job.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+            changed: Notify::new(),
+        })
+    }
+
+    /// Re-reads the store on taking over leadership, as the last leader
+    /// may have changed it. Keeps the loaded cron jobs on error.
+    pub fn reload(&self) -> Result<(), String> {
+        if self.path.is_none() {
+            return Ok(());
+        }
+        let state = crypto::load_sealed(self.path.as_deref(), &self.sealer)?;
+        *self.state.lock().unwrap() = state;
+        Ok(())
+    }
+
+    /// Whether cron jobs are persisted, so one missing was deleted rather
+    /// than lost to a restart.
+    pub fn persisted(&self) -> bool {
+        self.path.is_some()
+    }
+
+    /// Cron jobs in `namespace`, or in all namespaces, and the store's
+    /// resourceVersion.
+    pub fn list(&self, namespace: Option<&str>) -> (Vec<CronJob>, u64) {
+        let state = self.state.lock().unwrap();
+        let cron_jobs = state
+            .cron_jobs
+            .values()
+            .filter(|c| namespace.is_none_or(|ns| c.metadata.namespace == ns))
+            .cloned()
+            .collect();
+        (cron_jobs, state.version)
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<CronJob> {
+        self.state.lock().unwrap().cron_jobs.get(&key(namespace, name)).cloned()
+    }
+
+    pub fn create(&self, namespace: &str, mut c: CronJob) -> Result<CronJob, CronJobError> {
+        validate(&c)?;
+        let mut state = self.state.lock().unwrap();
+        let k = key(namespace, &c.metadata.name);
+        if state.cron_jobs.contains_key(&k) {
+            return Err(CronJobError::AlreadyExists);
+        }
+        state.version += 1;
+        c.type_meta = TypeMeta {
+            api_version: "batch/v1".to_string(),
+            kind: "CronJob".to_string(),
+        };
+        c.metadata.namespace = namespace.to_string();
+        c.metadata.creation_timestamp = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        c.metadata.resource_version = state.version.to_string();
+        c.status = CronJobStatus::default();
+        state.cron_jobs.insert(k, c.clone());
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(c)
+    }
+
+    /// Replaces a cron job's spec, labels and annotations; the status stays
+    /// the controller's. A resourceVersion, when given, must be the current
+    /// one.
+    pub fn update(&self, namespace: &str, name: &str, c: CronJob) -> Result<CronJob, CronJobError> {
+        validate(&c)?;
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        let version = state.version.to_string();
+        let Some(current) = state.cron_jobs.get_mut(&key(namespace, name)) else {
+            return Err(CronJobError::NotFound);
+        };
+        if !c.metadata.resource_version.is_empty() && c.metadata.resource_version != current.metadata.resource_version {
+            return Err(CronJobError::Conflict);
+        }
+        current.metadata.labels = c.metadata.labels;
+        current.metadata.annotations = c.metadata.annotations;
+        current.metadata.resource_version = version;
+        current.spec = c.spec;
+        let updated = current.clone();
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(updated)
+    }
+
+    /// Pauses or resumes a cron job.
+    pub fn set_suspend(&self, namespace: &str, name: &str, suspend: bool) -> Result<CronJob, CronJobError> {
+        let mut c = self.get(namespace, name).ok_or(CronJobError::NotFound)?;
+        c.spec.suspend = suspend;
+        c.metadata.resource_version.clear();
+        self.update(namespace, name, c)
+    }
+
+    /// Records the controller's view of a cron job.
+    pub fn update_status(&self, namespace: &str, name: &str, status: CronJobStatus) -> Result<(), CronJobError> {
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        let version = state.version.to_string();
+        let Some(c) = state.cron_jobs.get_mut(&key(namespace, name)) else {
+            return Err(CronJobError::NotFound);
+        };
+        c.status = status;
+        c.metadata.resource_version = version;
+        self.save(&state);
+        Ok(())
+    }
+
+    /// Removes a cron job; the controller then deletes the jobs it started.
+    pub fn delete(&self, namespace: &str, name: &str) -> Result<CronJob, CronJobError> {
+        let mut state = self.state.lock().unwrap();
+        let Some(c) = state.cron_jobs.remove(&key(namespace, name)) else {
+            return Err(CronJobError::NotFound);
+        };
+        state.version += 1;
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(c)
+    }
+
+    /// Resolves when a cron job is created, changed or deleted, so the
+    /// controller picks up a new schedule without waiting for its next pass.
+    pub async fn changed(&self) {
+        self.changed.notified().await
+    }
+
+    fn save(&self, state: &CronJobState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing cron jobs {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn validate(c: &CronJob) -> Result<(), CronJobError> {
+    let name = &c.metadata.name;
+    // Jobs are named <cron job>-<minutes since the epoch>
+    let valid_name = !name.is_empty()
+        && name.len() <= 52
+        && name
+            .chars()
+            .all(|ch| ch.is_ascii_lowercase() || ch.is_ascii_digit() || ch == '-');
+    if !valid_name {
+        return Err(CronJobError::Invalid(
+            "metadata.name of at most 52 lowercase letters, digits and dashes is required".to_string(),
+        ));
+    }
+    let spec = &c.spec;
+    CronSchedule::parse(&spec.schedule).map_err(|e| CronJobError::Invalid(format!("schedule: {}", e)))?;
+    match spec.concurrency_policy.as_str() {
+        "Allow" | "Forbid" | "Replace" => {}
+        other => {
+            return Err(CronJobError::Invalid(format!(
+                "concurrencyPolicy {:?} is not Allow, Forbid or Replace",
+                other
+            )));
+        }
+    }
+    if spec.successful_jobs_history_limit < 0 || spec.failed_jobs_history_limit < 0 {
+        return Err(CronJobError::Invalid("history limits must not be negative".to_string()));
+    }
+    if spec.starting_deadline_seconds.is_some_and(|s| s < 0) {
+        return Err(CronJobError::Invalid(
+            "startingDeadlineSeconds must not be negative".to_string(),
+        ));
+    }
+    let job = &spec.job_template.spec;
+    if job.template.spec.containers.is_empty() {
+        return Err(CronJobError::Invalid(
+            "spec.jobTemplate.spec.template.spec.containers is required".to_string(),
+        ));
+    }
+    if job.completions < 1 || job.parallelism < 1 {
+        return Err(CronJobError::Invalid(
+            "completions and parallelism must be at least 1".to_string(),
+        ));
+    }
+    Ok(())
+}
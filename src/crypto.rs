@@ -0,0 +1,333 @@
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD;
+use ring::aead::{AES_256_GCM, Aad, LessSafeKey, NONCE_LEN, Nonce, UnboundKey};
+use ring::rand::{SecureRandom, SystemRandom};
+use serde::Serialize;
+use serde::de::DeserializeOwned;
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+
+use crate::config::{Config, EncryptionConfig};
+
+/// Marks sealed data: enc:v1:<key id>:<base64 nonce + ciphertext>.
+const PREFIX: &str = "enc:v1:";
+
+struct DataKey {
+    id: String,
+    key: LessSafeKey,
+}
+
+/// Encrypts console-side state at rest with AES-256-GCM. The first key
+/// seals; every configured key opens, so retired keys keep working until a
+/// rotation rewrites the data. Plaintext passes through `open` untouched,
+/// which lets encryption be switched on over existing files.
+pub struct Sealer {
+    keys: Vec<DataKey>,
+    rng: SystemRandom,
+}
+
+impl Sealer {
+    pub fn new(cfg: Option<&EncryptionConfig>) -> Result<Self, String> {
+        let mut keys = Vec::new();
+        if let Some(cfg) = cfg {
+            keys.push(load_key(&current_key_material(cfg)?)?);
+            for f in &cfg.previous_key_files {
+                let material = std::fs::read_to_string(f)
+                    .map_err(|e| format!("reading previous key {}: {}", f, e))?;
+                keys.push(load_key(&material)?);
+            }
+        }
+        Ok(Self {
+            keys,
+            rng: SystemRandom::new(),
+        })
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        !self.keys.is_empty()
+    }
+
+    /// Id of the key new data is sealed with.
+    pub fn current_key_id(&self) -> Option<&str> {
+        self.keys.first().map(|k| k.id.as_str())
+    }
+
+    pub fn key_ids(&self) -> Vec<String> {
+        self.keys.iter().map(|k| k.id.clone()).collect()
+    }
+
+    pub fn seal(&self, plain: &str) -> String {
+        String::from_utf8(self.seal_bytes(plain.as_bytes())).expect("sealed data is ASCII")
+    }
+
+    pub fn open(&self, data: &str) -> Result<String, String> {
+        let plain = self.open_bytes(data.as_bytes())?;
+        String::from_utf8(plain).map_err(|e| format!("decrypted data is not UTF-8: {}", e))
+    }
+
+    /// Seals with the current key, or returns the input unchanged when
+    /// encryption is off.
+    pub fn seal_bytes(&self, plain: &[u8]) -> Vec<u8> {
+        let Some(k) = self.keys.first() else {
+            return plain.to_vec();
+        };
+        let mut nonce = [0u8; NONCE_LEN];
+        self.rng.fill(&mut nonce).expect("system RNG failed");
+        let mut buf = plain.to_vec();
+        k.key
+            .seal_in_place_append_tag(Nonce::assume_unique_for_key(nonce), Aad::empty(), &mut buf)
+            .expect("AES-GCM seal failed");
+        let mut payload = nonce.to_vec();
+        payload.extend_from_slice(&buf);
+        format!("{}{}:{}", PREFIX, k.id, STANDARD.encode(payload)).into_bytes()
+    }
+
+    pub fn open_bytes(&self, data: &[u8]) -> Result<Vec<u8>, String> {
+        let Some(key_id) = sealed_key_id(data) else {
+            return Ok(data.to_vec());
+        };
+        let k = self
+            .keys
+            .iter()
+            .find(|k| k.id == key_id)
+            .ok_or_else(|| format!("sealed with key {}, which is not configured", key_id))?;
+        let text = std::str::from_utf8(data).map_err(|_| "sealed data is not ASCII")?;
+        let encoded = text.trim()[PREFIX.len() + key_id.len() + 1..].to_string();
+        let mut payload = STANDARD
+            .decode(encoded)
+            .map_err(|e| format!("sealed data is not valid base64: {}", e))?;
+        if payload.len() < NONCE_LEN {
+            return Err("sealed data is truncated".to_string());
+        }
+        let mut nonce = [0u8; NONCE_LEN];
+        nonce.copy_from_slice(&payload[..NONCE_LEN]);
+        let plain = k
+            .key
+            .open_in_place(Nonce::assume_unique_for_key(nonce), Aad::empty(), &mut payload[NONCE_LEN..])
+            .map_err(|_| format!("decryption with key {} failed", key_id))?;
+        Ok(plain.to_vec())
+    }
+
+    /// Re-seals a store file with the current key, or writes it back as
+    /// plaintext when encryption is off. Returns the number of records.
+    pub fn rewrite(&self, path: &Path, per_line: bool) -> Result<usize, String> {
+        let data = std::fs::read(path).map_err(|e| format!("reading {}: {}", path.display(), e))?;
+        let (out, count) = if per_line {
+            let text = String::from_utf8(data).map_err(|_| format!("{} is not UTF-8", path.display()))?;
+            let mut out = String::new();
+            let mut count = 0;
+            for line in text.lines().filter(|l| !l.trim().is_empty()) {
+                out.push_str(&self.seal(&self.open(line)?));
+                out.push('\n');
+                count += 1;
+            }
+            (out.into_bytes(), count)
+        } else {
+            (self.seal_bytes(&self.open_bytes(&data)?), 1)
+        };
+        let tmp = path.with_extension("rewrite");
+        std::fs::write(&tmp, out).map_err(|e| format!("writing {}: {}", tmp.display(), e))?;
+        std::fs::rename(&tmp, path).map_err(|e| format!("replacing {}: {}", path.display(), e))?;
+        Ok(count)
+    }
+}
+
+/// Loads a sealed JSON store, or the default when there is no file yet. A
+/// file that can't be read, opened or parsed is an error rather than an
+/// empty store, which the next save would write over it.
+pub fn load_sealed<T: DeserializeOwned + Default>(path: Option<&Path>, sealer: &Sealer) -> Result<T, String> {
+    let Some(path) = path else {
+        return Ok(T::default());
+    };
+    let data = match std::fs::read(path) {
+        Ok(data) => data,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(T::default()),
+        Err(e) => return Err(format!("reading {}: {}", path.display(), e)),
+    };
+    let plain = sealer
+        .open_bytes(&data)
+        .map_err(|e| format!("opening {}: {}", path.display(), e))?;
+    serde_json::from_slice(&plain).map_err(|e| format!("parsing {}: {}", path.display(), e))
+}
+
+/// A file the console keeps state in.
+#[derive(Debug, Clone)]
+pub struct StoreFile {
+    pub name: &'static str,
+    pub path: PathBuf,
+    /// JSON-lines files are sealed line by line so appends stay cheap.
+    pub per_line: bool,
+}
+
+/// Every console-side store the config enables.
+pub fn store_files(cfg: &Config) -> Vec<StoreFile> {
+    let mut files = Vec::new();
+    let mut add = |name, path: Option<PathBuf>, per_line| {
+        if let Some(path) = path {
+            files.push(StoreFile { name, path, per_line });
+        }
+    };
+    add("History archive", cfg.data_path("archive.jsonl"), true);
+    add("Activity feed", cfg.data_path("activity.jsonl"), true);
+    add("Diagnostics", cfg.data_path("diagnostics.jsonl"), true);
+    add("Favorites", cfg.data_path("favorites.json"), false);
+    add("Claimed nodes", cfg.data_path("claimed_nodes.json"), false);
+    add("Bootstrap tokens", cfg.data_path("bootstrap_tokens.json"), false);
+    add("Settings", cfg.data_path("settings.json"), false);
+    add("Leases", cfg.data_path("leases.json"), false);
+    add("Custom resources", cfg.data_path("custom_resources.json"), false);
+    add("Jobs", cfg.data_path("jobs.json"), false);
+    add("Cron jobs", cfg.data_path("cronjobs.json"), false);
+    add("Deployments", cfg.data_path("deployments.json"), false);
+    add("Device sets", cfg.data_path("devicesets.json"), false);
+    add("Daemon sets", cfg.data_path("daemonsets.json"), false);
+    add("Namespaces", cfg.data_path("namespaces.json"), false);
+    add("Pool cordons", cfg.data_path("pool_cordons.json"), false);
+    add("Local volumes", cfg.data_path("local_volumes.json"), false);
+    add("Storage catalog", cfg.data_path("storage_catalog.json"), false);
+    add("Alert rules", cfg.data_path("alert_rules.json"), false);
+    add("Telemetry", cfg.data_path("telemetry.json"), false);
+    let share_key = cfg.share_links.key_file.as_ref().map(PathBuf::from).or_else(|| cfg.data_path("share.key"));
+    add("Share link key", share_key, false);
+    if let Some(ref p) = cfg.push {
+        add("Push subscriptions", cfg.data_path("push_subscriptions.json"), false);
+        let key = p.vapid_key_file.as_ref().map(PathBuf::from).or_else(|| cfg.data_path("vapid.pk8"));
+        add("VAPID key", key, false);
+    }
+    files
+}
+
+/// How much of a store file is encrypted, and with which keys.
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct FileStatus {
+    pub name: String,
+    pub path: String,
+    pub exists: bool,
+    pub records: usize,
+    pub plaintext: usize,
+    /// Sealed records per key id.
+    pub by_key: BTreeMap<String, usize>,
+}
+
+pub fn file_status(f: &StoreFile) -> FileStatus {
+    let mut status = FileStatus {
+        name: f.name.to_string(),
+        path: f.path.display().to_string(),
+        ..Default::default()
+    };
+    let Ok(data) = std::fs::read(&f.path) else {
+        return status;
+    };
+    status.exists = true;
+    let records: Vec<&[u8]> = if f.per_line {
+        data.split(|b| *b == b'\n').filter(|l| !l.is_empty()).collect()
+    } else {
+        vec![&data]
+    };
+    for r in records {
+        status.records += 1;
+        match sealed_key_id(r) {
+            Some(id) => *status.by_key.entry(id.to_string()).or_default() += 1,
+            None => status.plaintext += 1,
+        }
+    }
+    status
+}
+
+/// Key id of sealed data, or None for plaintext.
+pub fn sealed_key_id(data: &[u8]) -> Option<&str> {
+    let rest = data.strip_prefix(PREFIX.as_bytes())?;
+    let end = rest.iter().position(|b| *b == b':')?;
+    std::str::from_utf8(&rest[..end]).ok()
+}
+
+/// A new random key, base64-encoded as the config expects.
+pub fn generate_key() -> String {
+    let mut key = [0u8; 32];
+    SystemRandom::new().fill(&mut key).expect("system RNG failed");
+    STANDARD.encode(key)
+}
+
+/// `mkube-console keys <command>`: generate a key, seal a config value, or
+/// rewrite every store with the current key after a rotation.
+pub fn run_cli(args: &[String]) -> i32 {
+    let usage = "usage: mkube-console keys generate | seal <config> <value> | status <config> | rotate <config>";
+    let load = |path: &str| -> Result<(Config, Sealer), String> {
+        let cfg = Config::load(Path::new(path)).map_err(|e| e.to_string())?;
+        let sealer = Sealer::new(cfg.encryption.as_ref())?;
+        Ok((cfg, sealer))
+    };
+    let args: Vec<&str> = args.iter().map(|a| a.as_str()).collect();
+    let result = match args.as_slice() {
+        ["generate"] => {
+            println!("{}", generate_key());
+            Ok(())
+        }
+        ["seal", config, value] => load(config).and_then(|(_, sealer)| {
+            if !sealer.is_enabled() {
+                return Err("encryption is not configured".to_string());
+            }
+            println!("{}", sealer.seal(value));
+            Ok(())
+        }),
+        ["status", config] => load(config).map(|(cfg, sealer)| {
+            match sealer.current_key_id() {
+                Some(id) => println!("encryption: on, current key {}", id),
+                None => println!("encryption: off"),
+            }
+            for f in store_files(&cfg) {
+                let s = file_status(&f);
+                if !s.exists {
+                    println!("{:<20} missing", s.name);
+                    continue;
+                }
+                println!("{:<20} {} records, {} plaintext, sealed {:?}", s.name, s.records, s.plaintext, s.by_key);
+            }
+        }),
+        ["rotate", config] => load(config).and_then(|(cfg, sealer)| {
+            for f in store_files(&cfg) {
+                if !f.path.exists() {
+                    continue;
+                }
+                let n = sealer.rewrite(&f.path, f.per_line)?;
+                println!("{:<20} rewrote {} records", f.name, n);
+            }
+            Ok(())
+        }),
+        _ => Err(usage.to_string()),
+    };
+    match result {
+        Ok(()) => 0,
+        Err(e) => {
+            eprintln!("{}", e);
+            1
+        }
+    }
+}
+
+fn current_key_material(cfg: &EncryptionConfig) -> Result<String, String> {
+    if let Some(ref f) = cfg.key_file {
+        return std::fs::read_to_string(f).map_err(|e| format!("reading key file {}: {}", f, e));
+    }
+    if let Some(ref var) = cfg.key_env {
+        return std::env::var(var).map_err(|_| format!("key variable {} is not set", var));
+    }
+    cfg.key
+        .clone()
+        .ok_or_else(|| "encryption needs one of key_file, key_env or key".to_string())
+}
+
+fn load_key(material: &str) -> Result<DataKey, String> {
+    let bytes = STANDARD
+        .decode(material.trim())
+        .map_err(|e| format!("encryption key is not valid base64: {}", e))?;
+    let unbound = UnboundKey::new(&AES_256_GCM, &bytes)
+        .map_err(|_| format!("encryption key must be 32 bytes, got {}", bytes.len()))?;
+    let digest = ring::digest::digest(&ring::digest::SHA256, &bytes);
+    let id = digest.as_ref()[..4].iter().map(|b| format!("{:02x}", b)).collect();
+    Ok(DataKey {
+        id,
+        key: LessSafeKey::new(unbound),
+    })
+}
@@ -0,0 +1,292 @@
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use std::collections::{BTreeMap, VecDeque};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::sync::broadcast;
+use tracing::warn;
+
+use crate::config::CustomResourceDef;
+use crate::crypto::{self, Sealer};
+
+/// Spec field types a custom resource schema may use.
+pub const FIELD_TYPES: &[&str] = &["string", "integer", "number", "boolean", "object", "array"];
+
+/// Changes kept for watches resuming from a resourceVersion.
+const HISTORY_LEN: usize = 1000;
+
+#[derive(Debug)]
+pub enum CustomError {
+    NotFound,
+    AlreadyExists,
+    /// The write was based on an older resourceVersion.
+    Conflict,
+    Invalid(String),
+}
+
+/// A change to a custom object, as a Kubernetes watch event.
+#[derive(Debug, Clone)]
+pub struct CustomEvent {
+    pub resource_version: u64,
+    /// ADDED, MODIFIED or DELETED.
+    pub event_type: &'static str,
+    /// "group/plural" of the object's type.
+    pub resource: String,
+    pub namespace: String,
+    pub object: Value,
+}
+
+/// Objects of the resource types in config.custom_resources, stored by the
+/// console so small controllers can keep state without running etcd.
+/// Objects are kept as the JSON they were written with, checked only
+/// against the type's schema; metadata.resourceVersion is a store-wide
+/// counter and writes carrying one are compare-and-swap on it.
+pub struct CustomResourceStore {
+    defs: Vec<CustomResourceDef>,
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<StoreState>,
+    tx: broadcast::Sender<CustomEvent>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct StoreState {
+    version: u64,
+    /// Keyed by "group/plural/namespace/name".
+    objects: BTreeMap<String, Value>,
+    /// Oldest version the history has every later event for.
+    #[serde(skip)]
+    oldest: u64,
+    #[serde(skip)]
+    history: VecDeque<CustomEvent>,
+}
+
+impl CustomResourceStore {
+    pub fn new(defs: Vec<CustomResourceDef>, path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let mut state: StoreState = crypto::load_sealed(path.as_deref(), &sealer)?;
+        state.oldest = state.version;
+        let (tx, _) = broadcast::channel(256);
+        Ok(Self {
+            defs,
+            path,
+            sealer,
+            state: Mutex::new(state),
+            tx,
+        })
+    }
+
+    pub fn defs(&self) -> &[CustomResourceDef] {
+        &self.defs
+    }
+
+    pub fn def(&self, group: &str, version: &str, plural: &str) -> Option<&CustomResourceDef> {
+        self.defs
+            .iter()
+            .find(|d| d.group == group && d.version == version && d.plural == plural)
+    }
+
+    /// Objects of a type in `namespace`, or in all namespaces, and the
+    /// store's resourceVersion.
+    pub fn list(&self, def: &CustomResourceDef, namespace: Option<&str>) -> (Vec<Value>, u64) {
+        let state = self.state.lock().unwrap();
+        let prefix = format!("{}/{}/", def.group, def.plural);
+        let items = state
+            .objects
+            .range(prefix.clone()..)
+            .take_while(|(k, _)| k.starts_with(&prefix))
+            .filter(|(_, o)| namespace.is_none_or(|ns| meta_str(o, "namespace") == ns))
+            .map(|(_, o)| o.clone())
+            .collect();
+        (items, state.version)
+    }
+
+    pub fn get(&self, def: &CustomResourceDef, namespace: &str, name: &str) -> Option<Value> {
+        let state = self.state.lock().unwrap();
+        state.objects.get(&key(def, namespace, name)).cloned()
+    }
+
+    pub fn create(&self, def: &CustomResourceDef, namespace: &str, mut object: Value) -> Result<Value, CustomError> {
+        validate(def, &object)?;
+        let name = meta_str(&object, "name").to_string();
+        if name.is_empty() {
+            return Err(CustomError::Invalid("metadata.name is required".to_string()));
+        }
+        let mut state = self.state.lock().unwrap();
+        let k = key(def, namespace, &name);
+        if state.objects.contains_key(&k) {
+            return Err(CustomError::AlreadyExists);
+        }
+        state.version += 1;
+        let created = Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true);
+        stamp(def, &mut object, namespace, &name, &created, state.version);
+        state.objects.insert(k, object.clone());
+        self.commit(&mut state, def, "ADDED", namespace, &object);
+        Ok(object)
+    }
+
+    /// Replaces an object. A resourceVersion in the new object must match
+    /// the stored one; without one the write overwrites unconditionally.
+    pub fn update(
+        &self,
+        def: &CustomResourceDef,
+        namespace: &str,
+        name: &str,
+        mut object: Value,
+    ) -> Result<Value, CustomError> {
+        validate(def, &object)?;
+        let given = meta_str(&object, "name");
+        if !given.is_empty() && given != name {
+            return Err(CustomError::Invalid(format!(
+                "metadata.name {:?} does not match {:?}",
+                given, name
+            )));
+        }
+        let mut state = self.state.lock().unwrap();
+        let k = key(def, namespace, name);
+        let Some(current) = state.objects.get(&k) else {
+            return Err(CustomError::NotFound);
+        };
+        let version = meta_str(&object, "resourceVersion");
+        if !version.is_empty() && version != meta_str(current, "resourceVersion") {
+            return Err(CustomError::Conflict);
+        }
+        let created = meta_str(current, "creationTimestamp").to_string();
+        state.version += 1;
+        stamp(def, &mut object, namespace, name, &created, state.version);
+        state.objects.insert(k, object.clone());
+        self.commit(&mut state, def, "MODIFIED", namespace, &object);
+        Ok(object)
+    }
+
+    pub fn delete(&self, def: &CustomResourceDef, namespace: &str, name: &str) -> Result<Value, CustomError> {
+        let mut state = self.state.lock().unwrap();
+        let Some(mut object) = state.objects.remove(&key(def, namespace, name)) else {
+            return Err(CustomError::NotFound);
+        };
+        state.version += 1;
+        object["metadata"]["resourceVersion"] = Value::String(state.version.to_string());
+        self.commit(&mut state, def, "DELETED", namespace, &object);
+        Ok(object)
+    }
+
+    pub fn resource_version(&self) -> u64 {
+        self.state.lock().unwrap().version
+    }
+
+    /// Changes after `version` and a receiver for the ones that follow, or
+    /// None if the history no longer reaches back that far.
+    pub fn since(&self, version: u64) -> Option<(Vec<CustomEvent>, broadcast::Receiver<CustomEvent>)> {
+        let state = self.state.lock().unwrap();
+        if version < state.oldest || version > state.version {
+            return None;
+        }
+        let missed = state
+            .history
+            .iter()
+            .filter(|e| e.resource_version > version)
+            .cloned()
+            .collect();
+        Some((missed, self.tx.subscribe()))
+    }
+
+    // Saves a change made under `state` and announces it to watchers.
+    fn commit(
+        &self,
+        state: &mut StoreState,
+        def: &CustomResourceDef,
+        event_type: &'static str,
+        namespace: &str,
+        object: &Value,
+    ) {
+        self.save(state);
+        let event = CustomEvent {
+            resource_version: state.version,
+            event_type,
+            resource: format!("{}/{}", def.group, def.plural),
+            namespace: namespace.to_string(),
+            object: object.clone(),
+        };
+        state.history.push_back(event.clone());
+        while state.history.len() > HISTORY_LEN {
+            if let Some(e) = state.history.pop_front() {
+                state.oldest = e.resource_version;
+            }
+        }
+        // Sent under the lock, so `since` can't miss or repeat a change.
+        let _ = self.tx.send(event);
+    }
+
+    fn save(&self, state: &StoreState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| {
+                    std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string())
+                });
+            if let Err(e) = result {
+                warn!("writing custom resources {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(def: &CustomResourceDef, namespace: &str, name: &str) -> String {
+    format!("{}/{}/{}/{}", def.group, def.plural, namespace, name)
+}
+
+fn meta_str<'a>(object: &'a Value, field: &str) -> &'a str {
+    object["metadata"][field].as_str().unwrap_or_default()
+}
+
+// Sets the fields the store owns: type, identity and versioning.
+fn stamp(def: &CustomResourceDef, object: &mut Value, namespace: &str, name: &str, created: &str, version: u64) {
+    object["apiVersion"] = Value::String(format!("{}/{}", def.group, def.version));
+    object["kind"] = Value::String(def.kind.clone());
+    if !object["metadata"].is_object() {
+        object["metadata"] = Value::Object(Default::default());
+    }
+    let meta = &mut object["metadata"];
+    meta["name"] = Value::String(name.to_string());
+    if def.namespaced {
+        meta["namespace"] = Value::String(namespace.to_string());
+    } else if let Some(m) = meta.as_object_mut() {
+        m.remove("namespace");
+    }
+    meta["creationTimestamp"] = Value::String(created.to_string());
+    meta["resourceVersion"] = Value::String(version.to_string());
+}
+
+// Checks an object against its type's schema: spec fields of the declared
+// types and the required ones present.
+fn validate(def: &CustomResourceDef, object: &Value) -> Result<(), CustomError> {
+    if !object.is_object() {
+        return Err(CustomError::Invalid("object must be a JSON object".to_string()));
+    }
+    let spec = &object["spec"];
+    if !spec.is_null() && !spec.is_object() {
+        return Err(CustomError::Invalid("spec must be an object".to_string()));
+    }
+    for field in &def.required {
+        if spec[field].is_null() {
+            return Err(CustomError::Invalid(format!("spec.{} is required", field)));
+        }
+    }
+    for (field, want) in &def.schema {
+        let value = &spec[field];
+        let ok = match want.as_str() {
+            _ if value.is_null() => true,
+            "string" => value.is_string(),
+            "integer" => value.is_i64() || value.is_u64(),
+            "number" => value.is_number(),
+            "boolean" => value.is_boolean(),
+            "object" => value.is_object(),
+            "array" => value.is_array(),
+            _ => true,
+        };
+        if !ok {
+            return Err(CustomError::Invalid(format!("spec.{} must be of type {}", field, want)));
+        }
+    }
+    Ok(())
+}
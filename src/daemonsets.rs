@@ -0,0 +1,207 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::sync::Notify;
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::PodTemplateSpec;
+use crate::selector::LabelSelector;
+
+/// A pod template run once on every node whose labels match its selector,
+/// like a Kubernetes DaemonSet; for node exporters, log shippers and other
+/// per-node agents.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct MicroDaemonSet {
+    pub namespace: String,
+    pub name: String,
+    /// Label selector over node labels, e.g. "mkube.io/pool=edge"; empty
+    /// selects every node.
+    #[serde(default)]
+    pub node_selector: String,
+    pub template: PodTemplateSpec,
+    pub created_by: String,
+    pub created_at: DateTime<Utc>,
+    #[serde(default)]
+    pub status: MicroDaemonSetStatus,
+}
+
+/// The controller's view of a daemon set after its last pass.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct MicroDaemonSetStatus {
+    /// Nodes matching the selector, online or not.
+    pub desired: usize,
+    /// Of those, nodes with the set's pod.
+    pub current: usize,
+    /// Of those, nodes whose pod is running.
+    pub ready: usize,
+    pub nodes: Vec<DaemonNodeStatus>,
+    pub last_sync: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DaemonNodeStatus {
+    pub node: String,
+    pub healthy: bool,
+    /// Empty when the node has no pod of the set.
+    pub pod: String,
+    pub phase: String,
+    /// Why the pod couldn't be created, if it couldn't.
+    #[serde(default)]
+    pub error: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct MicroDaemonSetRequest {
+    pub namespace: String,
+    pub name: String,
+    #[serde(default)]
+    pub node_selector: String,
+    pub template: PodTemplateSpec,
+}
+
+/// Daemon sets, persisted under the data dir when there is one. The daemon
+/// set controller keeps their pods and status up to date.
+pub struct MicroDaemonSetStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<MicroDaemonSetState>,
+    changed: Notify,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct MicroDaemonSetState {
+    /// Keyed by "namespace/name".
+    sets: BTreeMap<String, MicroDaemonSet>,
+}
+
+impl MicroDaemonSetStore {
+    /// Fails on a store that can't be opened: started empty, it would have
+    /// the controller delete every daemon set's pods.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+            changed: Notify::new(),
+        })
+    }
+
+    /// Re-reads the store from disk, for a console taking over from the
+    /// leader that wrote it. Keeps the loaded sets on error.
+    pub fn reload(&self) -> Result<(), String> {
+        if self.path.is_none() {
+            return Ok(());
+        }
+        let state = crypto::load_sealed(self.path.as_deref(), &self.sealer)?;
+        *self.state.lock().unwrap() = state;
+        Ok(())
+    }
+
+    /// Whether sets are kept under the data dir; without one a restart
+    /// forgets them while their pods keep running.
+    pub fn persisted(&self) -> bool {
+        self.path.is_some()
+    }
+
+    /// Daemon sets in `namespace`, or in all namespaces.
+    pub fn list(&self, namespace: Option<&str>) -> Vec<MicroDaemonSet> {
+        self.state
+            .lock()
+            .unwrap()
+            .sets
+            .values()
+            .filter(|s| namespace.is_none_or(|ns| s.namespace == ns))
+            .cloned()
+            .collect()
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<MicroDaemonSet> {
+        self.state.lock().unwrap().sets.get(&key(namespace, name)).cloned()
+    }
+
+    pub fn create(&self, req: MicroDaemonSetRequest, by: &str) -> Result<MicroDaemonSet, String> {
+        let valid_name = !req.name.is_empty()
+            && req
+                .name
+                .chars()
+                .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+        if req.namespace.is_empty() || !valid_name {
+            return Err("namespace and a name of lowercase letters, digits and dashes are required".to_string());
+        }
+        LabelSelector::parse(&req.node_selector).map_err(|e| format!("nodeSelector: {}", e))?;
+        if req.template.spec.containers.is_empty() {
+            return Err("template.spec.containers is required".to_string());
+        }
+
+        let mut state = self.state.lock().unwrap();
+        let k = key(&req.namespace, &req.name);
+        if state.sets.contains_key(&k) {
+            return Err(format!("daemon set {} already exists", k));
+        }
+        let set = MicroDaemonSet {
+            namespace: req.namespace,
+            name: req.name,
+            node_selector: req.node_selector,
+            template: req.template,
+            created_by: by.to_string(),
+            created_at: Utc::now(),
+            status: MicroDaemonSetStatus::default(),
+        };
+        state.sets.insert(k, set.clone());
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(set)
+    }
+
+    /// Records the controller's view of a set; false if it was deleted
+    /// meanwhile.
+    pub fn update_status(&self, namespace: &str, name: &str, status: MicroDaemonSetStatus) -> bool {
+        let mut state = self.state.lock().unwrap();
+        let Some(set) = state.sets.get_mut(&key(namespace, name)) else {
+            return false;
+        };
+        set.status = status;
+        self.save(&state);
+        true
+    }
+
+    /// Removes a set; the controller then deletes its pods.
+    pub fn delete(&self, namespace: &str, name: &str) -> Option<MicroDaemonSet> {
+        let mut state = self.state.lock().unwrap();
+        let removed = state.sets.remove(&key(namespace, name))?;
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Some(removed)
+    }
+
+    /// Resolves when a set is created or deleted, so the controller acts
+    /// on it without waiting for its next pass.
+    pub async fn changed(&self) {
+        self.changed.notified().await
+    }
+
+    fn save(&self, state: &MicroDaemonSetState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing daemon sets {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
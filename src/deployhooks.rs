@@ -0,0 +1,111 @@
+use serde::{Deserialize, Serialize};
+
+use crate::models::k8s::PodSpec;
+use crate::namespaces::DEFAULT_NAMESPACE;
+
+// Deploy hooks close the build-to-deploy loop for home CI: once a build
+// pushes an image, the CI POSTs it to /api/hooks/deploy with the token
+// config.deploy_hooks gives it, and the console rolls the app over to
+// the new image. A console-managed deployment gets its template updated
+// and rolls as usual; a bundle has the pods running the image restarted
+// with the new one in place. The next apply of the bundle puts back the
+// image its definition names.
+
+/// What a CI system posts to /api/hooks/deploy.
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeployRequest {
+    /// Deployment or bundle name, or "namespace/name".
+    pub app: String,
+    #[serde(default)]
+    pub namespace: Option<String>,
+    pub image: String,
+    /// Container to update; by default those running the same image
+    /// repository, or the only one.
+    #[serde(default)]
+    pub container: Option<String>,
+    /// How long to wait for the rollout to finish; 0 answers at once.
+    #[serde(default)]
+    pub wait_secs: u64,
+}
+
+impl DeployRequest {
+    /// Namespace and name of the app.
+    pub fn target(&self) -> (String, String) {
+        match self.app.split_once('/') {
+            Some((ns, name)) => (ns.to_string(), name.to_string()),
+            None => (
+                self.namespace.clone().unwrap_or_else(|| DEFAULT_NAMESPACE.to_string()),
+                self.app.clone(),
+            ),
+        }
+    }
+}
+
+/// How far a deploy has got. Complete once every pod runs the new image
+/// and is ready.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct RolloutStatus {
+    /// "deployment" or "bundle".
+    pub kind: &'static str,
+    pub namespace: String,
+    pub name: String,
+    pub image: String,
+    pub desired: usize,
+    pub updated: usize,
+    pub ready: usize,
+    pub complete: bool,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub errors: Vec<String>,
+}
+
+/// Points the containers the request means at `image`: the one named, or
+/// those running the same repository, or the only one. Returns the names
+/// of those whose image changed.
+pub fn set_image(spec: &mut PodSpec, image: &str, container: Option<&str>) -> Result<Vec<String>, String> {
+    let only_one = spec.containers.len() == 1;
+    let wanted: Vec<usize> = match container {
+        Some(name) => spec
+            .containers
+            .iter()
+            .position(|c| c.name == name)
+            .into_iter()
+            .collect(),
+        None => {
+            let same_repo: Vec<usize> = (0..spec.containers.len())
+                .filter(|&i| repository(&spec.containers[i].image) == repository(image))
+                .collect();
+            if same_repo.is_empty() && only_one {
+                vec![0]
+            } else {
+                same_repo
+            }
+        }
+    };
+    if wanted.is_empty() {
+        return Err(match container {
+            Some(name) => format!("no container named {:?}", name),
+            None => "no container runs that image; name the container to update".to_string(),
+        });
+    }
+    let mut changed = Vec::new();
+    for i in wanted {
+        let c = &mut spec.containers[i];
+        if c.image != image {
+            c.image = image.to_string();
+            changed.push(c.name.clone());
+        }
+    }
+    Ok(changed)
+}
+
+// An image reference without its tag or digest.
+fn repository(image: &str) -> &str {
+    let image = image.split('@').next().unwrap_or(image);
+    match image.rsplit_once(':') {
+        // A colon before the last / is a registry port
+        Some((repo, tag)) if !tag.contains('/') => repo,
+        _ => image,
+    }
+}
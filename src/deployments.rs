@@ -0,0 +1,251 @@
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::sync::Notify;
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::{Deployment, DeploymentStatus, TypeMeta};
+
+#[derive(Debug)]
+pub enum DeploymentError {
+    NotFound,
+    AlreadyExists,
+    /// The update was based on an older resourceVersion.
+    Conflict,
+    Invalid(String),
+}
+
+/// Deployments managed by the console: a pod template kept at a replica
+/// count by the deployment controller (controllers/deployments.rs), which
+/// rolls the pods over when the template changes. Unlike the deployments
+/// nodes report about themselves, these span nodes. Persisted under the
+/// data dir when there is one.
+pub struct DeploymentStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<DeploymentState>,
+    changed: Notify,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct DeploymentState {
+    version: u64,
+    /// Keyed by "namespace/name".
+    deployments: BTreeMap<String, Deployment>,
+}
+
+impl DeploymentStore {
+    /// Fails when the store can't be opened rather than start empty, which
+    /// the controller would take for every deployment having been deleted.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+            changed: Notify::new(),
+        })
+    }
+
+    /// Re-reads the store, e.g. when this console takes over leadership
+    /// from one that has written it since. Keeps what it has on error.
+    pub fn reload(&self) -> Result<(), String> {
+        if self.path.is_none() {
+            return Ok(());
+        }
+        let state = crypto::load_sealed(self.path.as_deref(), &self.sealer)?;
+        *self.state.lock().unwrap() = state;
+        Ok(())
+    }
+
+    /// Whether the store outlives the console, so deployments missing from
+    /// it were deleted rather than forgotten in a restart.
+    pub fn persisted(&self) -> bool {
+        self.path.is_some()
+    }
+
+    /// Deployments in `namespace`, or in all namespaces, and the store's
+    /// resourceVersion.
+    pub fn list(&self, namespace: Option<&str>) -> (Vec<Deployment>, u64) {
+        let state = self.state.lock().unwrap();
+        let deployments = state
+            .deployments
+            .values()
+            .filter(|d| namespace.is_none_or(|ns| d.metadata.namespace == ns))
+            .cloned()
+            .collect();
+        (deployments, state.version)
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<Deployment> {
+        self.state
+            .lock()
+            .unwrap()
+            .deployments
+            .get(&key(namespace, name))
+            .cloned()
+    }
+
+    pub fn create(&self, namespace: &str, mut d: Deployment) -> Result<Deployment, DeploymentError> {
+        validate(&d)?;
+        let mut state = self.state.lock().unwrap();
+        let k = key(namespace, &d.metadata.name);
+        if state.deployments.contains_key(&k) {
+            return Err(DeploymentError::AlreadyExists);
+        }
+        state.version += 1;
+        d.type_meta = TypeMeta {
+            api_version: "apps/v1".to_string(),
+            kind: "Deployment".to_string(),
+        };
+        d.metadata.namespace = namespace.to_string();
+        d.metadata.creation_timestamp = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        d.metadata.resource_version = state.version.to_string();
+        d.status = DeploymentStatus::default();
+        state.deployments.insert(k, d.clone());
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(d)
+    }
+
+    /// Replaces a deployment's spec, labels and annotations; the status
+    /// stays the controller's. A resourceVersion, when given, must be the
+    /// current one.
+    pub fn update(&self, namespace: &str, name: &str, d: Deployment) -> Result<Deployment, DeploymentError> {
+        validate(&d)?;
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        let version = state.version.to_string();
+        let Some(current) = state.deployments.get_mut(&key(namespace, name)) else {
+            return Err(DeploymentError::NotFound);
+        };
+        if !d.metadata.resource_version.is_empty() && d.metadata.resource_version != current.metadata.resource_version {
+            return Err(DeploymentError::Conflict);
+        }
+        current.metadata.labels = d.metadata.labels;
+        current.metadata.annotations = d.metadata.annotations;
+        current.metadata.resource_version = version;
+        current.spec = d.spec;
+        let updated = current.clone();
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(updated)
+    }
+
+    /// Sets a deployment's replica count.
+    pub fn scale(&self, namespace: &str, name: &str, replicas: i32) -> Result<Deployment, DeploymentError> {
+        if replicas < 0 {
+            return Err(DeploymentError::Invalid("replicas must not be negative".to_string()));
+        }
+        let mut d = self.get(namespace, name).ok_or(DeploymentError::NotFound)?;
+        d.spec.replicas = replicas;
+        self.update(namespace, name, d)
+    }
+
+    /// Records the controller's view of a deployment.
+    pub fn update_status(&self, namespace: &str, name: &str, status: DeploymentStatus) -> Result<(), DeploymentError> {
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        let version = state.version.to_string();
+        let Some(d) = state.deployments.get_mut(&key(namespace, name)) else {
+            return Err(DeploymentError::NotFound);
+        };
+        d.status = status;
+        d.metadata.resource_version = version;
+        self.save(&state);
+        Ok(())
+    }
+
+    /// Removes a deployment; the controller then deletes its pods.
+    pub fn delete(&self, namespace: &str, name: &str) -> Result<Deployment, DeploymentError> {
+        let mut state = self.state.lock().unwrap();
+        let Some(d) = state.deployments.remove(&key(namespace, name)) else {
+            return Err(DeploymentError::NotFound);
+        };
+        state.version += 1;
+        self.save(&state);
+        drop(state);
+        self.changed.notify_one();
+        Ok(d)
+    }
+
+    /// Resolves when a deployment is created, changed or deleted, so the
+    /// controller acts on it without waiting for its next pass.
+    pub async fn changed(&self) {
+        self.changed.notified().await
+    }
+
+    fn save(&self, state: &DeploymentState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing deployments {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn validate(d: &Deployment) -> Result<(), DeploymentError> {
+    let name = &d.metadata.name;
+    let valid_name = !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+    if !valid_name {
+        return Err(DeploymentError::Invalid(
+            "metadata.name of lowercase letters, digits and dashes is required".to_string(),
+        ));
+    }
+    let spec = &d.spec;
+    if spec.template.spec.containers.is_empty() {
+        return Err(DeploymentError::Invalid(
+            "spec.template.spec.containers is required".to_string(),
+        ));
+    }
+    if spec.replicas < 0 {
+        return Err(DeploymentError::Invalid("replicas must not be negative".to_string()));
+    }
+    match spec.strategy.type_field.as_str() {
+        "RollingUpdate" | "Recreate" => {}
+        other => {
+            return Err(DeploymentError::Invalid(format!(
+                "strategy.type {:?} is not RollingUpdate or Recreate",
+                other
+            )));
+        }
+    }
+    if let Some(ref r) = spec.strategy.rolling_update {
+        for (field, value) in [("maxSurge", &r.max_surge), ("maxUnavailable", &r.max_unavailable)] {
+            if let Some(v) = value {
+                parse_int_or_percent(v).map_err(|e| DeploymentError::Invalid(format!("{}: {}", field, e)))?;
+            }
+        }
+    }
+    Ok(())
+}
+
+/// A maxSurge or maxUnavailable value and whether it is a percentage.
+pub fn parse_int_or_percent(v: &serde_json::Value) -> Result<(i64, bool), String> {
+    match v {
+        serde_json::Value::Number(n) => match n.as_i64() {
+            Some(n) if n >= 0 => Ok((n, false)),
+            _ => Err(format!("{} is not a non-negative integer", n)),
+        },
+        serde_json::Value::String(s) => match s.strip_suffix('%').and_then(|p| p.parse::<i64>().ok()) {
+            Some(p) if (0..=100).contains(&p) => Ok((p, true)),
+            _ => Err(format!("{:?} is not a percentage", s)),
+        },
+        other => Err(format!("{} is not a count or percentage", other)),
+    }
+}
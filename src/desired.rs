@@ -0,0 +1,202 @@
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashSet};
+
+use crate::claims::normalize_address;
+use crate::clients::events::EVENT_TYPES;
+use crate::config::{AlertRuleConfig, Config, EventHookConfig, Role};
+use crate::controllers::alert_rules;
+use crate::helpers::etag;
+
+// Console as code: the parts of the console that can change at runtime,
+// as one document kept in Git and POSTed to /api/admin/config/apply. It
+// uses the config file's vocabulary and sits on top of it: whatever the
+// config file sets (its nodes, access.users and alert_rules) can't be
+// set again here. Applying diffs the document against what the console
+// has, checks all of it and only then changes anything.
+
+/// The desired console configuration.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct DesiredConfig {
+    /// Nodes to poll besides the config file's, as the managed node API
+    /// keeps them.
+    #[serde(default)]
+    pub nodes: Vec<DesiredNode>,
+    /// Roles, when config.access is set.
+    #[serde(default)]
+    pub users: BTreeMap<String, Role>,
+    #[serde(default)]
+    pub alert_rules: Vec<AlertRuleConfig>,
+    /// Where pod and node events are routed.
+    #[serde(default)]
+    pub event_hooks: Vec<EventHookConfig>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct DesiredNode {
+    pub name: String,
+    pub address: String,
+    /// Bearer token the node was provisioned with.
+    #[serde(default)]
+    pub token: String,
+}
+
+/// What applying a document changes in one section, by name (URL for
+/// event hooks).
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct SectionPlan {
+    pub add: Vec<String>,
+    pub change: Vec<String>,
+    /// In the console but not the document; only removed with prune.
+    pub delete: Vec<String>,
+    pub unchanged: Vec<String>,
+}
+
+impl SectionPlan {
+    pub fn is_noop(&self) -> bool {
+        self.add.is_empty() && self.change.is_empty() && self.delete.is_empty()
+    }
+}
+
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ConfigPlan {
+    pub nodes: SectionPlan,
+    pub users: SectionPlan,
+    pub alert_rules: SectionPlan,
+    pub event_hooks: SectionPlan,
+}
+
+impl ConfigPlan {
+    pub fn is_noop(&self) -> bool {
+        self.nodes.is_noop() && self.users.is_noop() && self.alert_rules.is_noop() && self.event_hooks.is_noop()
+    }
+}
+
+/// Checks the document on its own and against the config file, naming
+/// the first thing that is wrong. Node addresses come back normalized.
+pub fn validate(doc: &mut DesiredConfig, cfg: &Config) -> Result<(), String> {
+    let mut seen = HashSet::new();
+    for n in &mut doc.nodes {
+        if n.name.is_empty() || n.name.contains('/') {
+            return Err(format!("node {:?}: invalid name", n.name));
+        }
+        if !seen.insert(n.name.clone()) {
+            return Err(format!("node {}: listed twice", n.name));
+        }
+        if cfg.nodes.iter().any(|c| c.name == n.name) {
+            return Err(format!("node {}: set in the config file", n.name));
+        }
+        if n.token.is_empty() {
+            return Err(format!("node {}: the node's bearer token is required", n.name));
+        }
+        n.address = normalize_address(&n.address).map_err(|e| format!("node {}: {}", n.name, e))?;
+    }
+    if !doc.nodes.is_empty() && cfg.data_path("claimed_nodes.json").is_none() {
+        return Err("nodes need data_dir, so their tokens survive a restart".to_string());
+    }
+
+    if !doc.users.is_empty() {
+        let Some(ref access) = cfg.access else {
+            return Err("users only take effect when the config file sets access".to_string());
+        };
+        if let Some(u) = doc.users.keys().find(|u| access.users.contains_key(*u)) {
+            return Err(format!("user {}: set in the config file", u));
+        }
+    }
+
+    alert_rules::compile(&doc.alert_rules)?;
+    if let Some(r) = doc
+        .alert_rules
+        .iter()
+        .find(|r| cfg.alert_rules.iter().any(|c| c.name == r.name))
+    {
+        return Err(format!("alert rule {}: set in the config file", r.name));
+    }
+
+    let mut urls = HashSet::new();
+    for h in &doc.event_hooks {
+        if !h.url.starts_with("http://") && !h.url.starts_with("https://") {
+            return Err(format!("event hook {:?}: url needs http:// or https://", h.url));
+        }
+        if !urls.insert(h.url.as_str()) {
+            return Err(format!("event hook {}: listed twice", h.url));
+        }
+        if let Some(e) = h.events.iter().find(|e| !EVENT_TYPES.contains(&e.as_str())) {
+            return Err(format!(
+                "event hook {}: unknown event type {:?}; use one of {}",
+                h.url,
+                e,
+                EVENT_TYPES.join(", ")
+            ));
+        }
+    }
+    Ok(())
+}
+
+/// Diffs the desired document against the console's current one. Without
+/// prune, what the console has and the document doesn't is left alone.
+pub fn plan(current: &DesiredConfig, desired: &DesiredConfig, prune: bool) -> ConfigPlan {
+    let nodes = |d: &DesiredConfig| keyed(&d.nodes, |n| n.name.clone());
+    let rules = |d: &DesiredConfig| keyed(&d.alert_rules, |r| r.name.clone());
+    let hooks = |d: &DesiredConfig| keyed(&d.event_hooks, |h| h.url.clone());
+    let users = |d: &DesiredConfig| keyed(&d.users.iter().collect::<Vec<_>>(), |(u, _)| u.to_string());
+    ConfigPlan {
+        nodes: diff(&nodes(current), &nodes(desired), prune),
+        users: diff(&users(current), &users(desired), prune),
+        alert_rules: diff(&rules(current), &rules(desired), prune),
+        event_hooks: diff(&hooks(current), &hooks(desired), prune),
+    }
+}
+
+/// What the console ends up with: the document, plus without prune
+/// whatever the console had that the document doesn't mention.
+pub fn merged(current: &DesiredConfig, desired: &DesiredConfig, prune: bool) -> DesiredConfig {
+    let mut out = desired.clone();
+    if prune {
+        return out;
+    }
+    for n in &current.nodes {
+        if !out.nodes.iter().any(|d| d.name == n.name) {
+            out.nodes.push(n.clone());
+        }
+    }
+    for (u, role) in &current.users {
+        out.users.entry(u.clone()).or_insert(*role);
+    }
+    for r in &current.alert_rules {
+        if !out.alert_rules.iter().any(|d| d.name == r.name) {
+            out.alert_rules.push(r.clone());
+        }
+    }
+    for h in &current.event_hooks {
+        if !out.event_hooks.iter().any(|d| d.url == h.url) {
+            out.event_hooks.push(h.clone());
+        }
+    }
+    out
+}
+
+// Items by key, each as its ETag so items compare by content.
+fn keyed<T: Serialize>(items: &[T], key: impl Fn(&T) -> String) -> BTreeMap<String, String> {
+    items.iter().map(|i| (key(i), etag(i))).collect()
+}
+
+fn diff(current: &BTreeMap<String, String>, desired: &BTreeMap<String, String>, prune: bool) -> SectionPlan {
+    let mut plan = SectionPlan::default();
+    for (k, tag) in desired {
+        match current.get(k) {
+            None => plan.add.push(k.clone()),
+            Some(t) if t != tag => plan.change.push(k.clone()),
+            Some(_) => plan.unchanged.push(k.clone()),
+        }
+    }
+    for k in current.keys().filter(|k| !desired.contains_key(*k)) {
+        if prune {
+            plan.delete.push(k.clone());
+        } else {
+            plan.unchanged.push(k.clone());
+        }
+    }
+    plan
+}
@@ -0,0 +1,188 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::PodTemplateSpec;
+
+/// A pod template run once on every node advertising a device class, e.g.
+/// a recorder on each node with a camera.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeviceSet {
+    pub namespace: String,
+    pub name: String,
+    /// Device kind nodes must report, e.g. camera or serial.
+    pub device_class: String,
+    pub template: PodTemplateSpec,
+    pub created_by: String,
+    pub created_at: DateTime<Utc>,
+    #[serde(default)]
+    pub status: DeviceSetStatus,
+}
+
+/// The controller's view of a device set after its last pass.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeviceSetStatus {
+    /// Healthy nodes advertising the class.
+    pub desired: usize,
+    /// Of those, nodes with the set's pod.
+    pub current: usize,
+    /// Of those, nodes whose pod is running.
+    pub ready: usize,
+    pub nodes: Vec<DeviceSetNode>,
+    pub last_sync: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeviceSetNode {
+    pub node: String,
+    pub pod: String,
+    pub phase: String,
+    /// Why the pod couldn't be created, if it couldn't.
+    #[serde(default)]
+    pub error: String,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeviceSetRequest {
+    pub namespace: String,
+    pub name: String,
+    pub device_class: String,
+    pub template: PodTemplateSpec,
+}
+
+/// Device sets, persisted under the data dir when there is one. The
+/// device set controller keeps their pods and status up to date.
+pub struct DeviceSetStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<DeviceSetState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct DeviceSetState {
+    /// Keyed by "namespace/name".
+    sets: BTreeMap<String, DeviceSet>,
+}
+
+impl DeviceSetStore {
+    /// Errors when the store can't be opened, since an empty one would leave
+    /// every device set's pods looking orphaned.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    /// Re-reads the store, which the previous leader may have changed.
+    /// Keeps the loaded sets on error.
+    pub fn reload(&self) -> Result<(), String> {
+        if self.path.is_none() {
+            return Ok(());
+        }
+        let state = crypto::load_sealed(self.path.as_deref(), &self.sealer)?;
+        *self.state.lock().unwrap() = state;
+        Ok(())
+    }
+
+    /// Whether sets survive a restart, i.e. there is a data dir.
+    pub fn persisted(&self) -> bool {
+        self.path.is_some()
+    }
+
+    /// Device sets in `namespace`, or in all namespaces.
+    pub fn list(&self, namespace: Option<&str>) -> Vec<DeviceSet> {
+        self.state
+            .lock()
+            .unwrap()
+            .sets
+            .values()
+            .filter(|s| namespace.is_none_or(|ns| s.namespace == ns))
+            .cloned()
+            .collect()
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<DeviceSet> {
+        self.state.lock().unwrap().sets.get(&key(namespace, name)).cloned()
+    }
+
+    pub fn create(&self, req: DeviceSetRequest, by: &str) -> Result<DeviceSet, String> {
+        let valid_name = !req.name.is_empty()
+            && req
+                .name
+                .chars()
+                .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+        if req.namespace.is_empty() || !valid_name {
+            return Err("namespace and a name of lowercase letters, digits and dashes are required".to_string());
+        }
+        if req.device_class.is_empty() {
+            return Err("deviceClass is required".to_string());
+        }
+        if req.template.spec.containers.is_empty() {
+            return Err("template.spec.containers is required".to_string());
+        }
+
+        let mut state = self.state.lock().unwrap();
+        let k = key(&req.namespace, &req.name);
+        if state.sets.contains_key(&k) {
+            return Err(format!("device set {} already exists", k));
+        }
+        let set = DeviceSet {
+            namespace: req.namespace,
+            name: req.name,
+            device_class: req.device_class.to_lowercase(),
+            template: req.template,
+            created_by: by.to_string(),
+            created_at: Utc::now(),
+            status: DeviceSetStatus::default(),
+        };
+        state.sets.insert(k, set.clone());
+        self.save(&state);
+        Ok(set)
+    }
+
+    /// Records the controller's view of a set; false if it was deleted
+    /// meanwhile.
+    pub fn update_status(&self, namespace: &str, name: &str, status: DeviceSetStatus) -> bool {
+        let mut state = self.state.lock().unwrap();
+        let Some(set) = state.sets.get_mut(&key(namespace, name)) else {
+            return false;
+        };
+        set.status = status;
+        self.save(&state);
+        true
+    }
+
+    /// Removes a set; the controller deletes its pods on its next pass.
+    pub fn delete(&self, namespace: &str, name: &str) -> Option<DeviceSet> {
+        let mut state = self.state.lock().unwrap();
+        let removed = state.sets.remove(&key(namespace, name))?;
+        self.save(&state);
+        Some(removed)
+    }
+
+    fn save(&self, state: &DeviceSetState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing device sets {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
@@ -0,0 +1,422 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::VecDeque;
+use std::io::Write;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+use tokio::net::TcpStream;
+use tracing::warn;
+
+use crate::clients::aggregator::Aggregator;
+use crate::crypto::Sealer;
+use crate::dns::{self, DnsResult};
+
+const CHECK_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// An address to check, with a label saying where it came from.
+#[derive(Debug, Clone)]
+pub struct PortTarget {
+    pub label: String,
+    pub host: String,
+    pub port: u16,
+}
+
+/// Outcome of a TCP connect or HTTP request from the console.
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PortCheckResult {
+    pub label: String,
+    pub target: String,
+    pub mode: String,
+    pub reachable: bool,
+    pub latency_ms: Option<f64>,
+    /// HTTP status code, for http checks that got a response.
+    pub status: Option<u16>,
+    pub error: String,
+}
+
+/// Resolves what to check: an explicit host, which must be a pod IP or a
+/// node's address, every TCP port (or the given one) of a pod given as
+/// namespace/name, or a node's address.
+pub async fn resolve_targets(
+    aggregator: &Aggregator,
+    host: &str,
+    pod: &str,
+    node: &str,
+    port: Option<u16>,
+) -> Result<Vec<PortTarget>, String> {
+    if !pod.is_empty() {
+        let (ns, name) = pod
+            .split_once('/')
+            .ok_or_else(|| format!("pod must be namespace/name, got {:?}", pod))?;
+        let (p, _) = aggregator
+            .get_pod(ns, name)
+            .await
+            .map_err(|e| e.to_string())?;
+        if p.status.pod_ip.is_empty() {
+            return Err(format!("pod {} has no IP", pod));
+        }
+        let ports: Vec<(String, u16)> = match port {
+            Some(n) => vec![(n.to_string(), n)],
+            None => p
+                .spec
+                .containers
+                .iter()
+                .flat_map(|c| c.ports.iter())
+                .filter(|cp| cp.protocol.is_empty() || cp.protocol.eq_ignore_ascii_case("tcp"))
+                .map(|cp| {
+                    let label = if cp.name.is_empty() {
+                        cp.container_port.to_string()
+                    } else {
+                        cp.name.clone()
+                    };
+                    (label, cp.container_port)
+                })
+                .collect(),
+        };
+        if ports.is_empty() {
+            return Err(format!("pod {} declares no TCP ports; give one explicitly", pod));
+        }
+        return Ok(ports
+            .into_iter()
+            .map(|(label, n)| PortTarget {
+                label: format!("{} {}", pod, label),
+                host: p.status.pod_ip.clone(),
+                port: n,
+            })
+            .collect());
+    }
+
+    let port = port.ok_or("port is required")?;
+    if !node.is_empty() {
+        let c = aggregator
+            .snapshot_clients()
+            .await
+            .into_iter()
+            .find(|c| c.name == node)
+            .ok_or_else(|| format!("node {:?} not found", node))?;
+        return Ok(vec![PortTarget {
+            label: format!("node {}", node),
+            host: c.host().to_string(),
+            port,
+        }]);
+    }
+    if host.is_empty() {
+        return Err("one of host, pod or node is required".to_string());
+    }
+    // Only the cluster's own addresses: a free-form host would let any
+    // viewer have the console probe, or fetch from, whatever it can reach
+    let is_node = aggregator
+        .snapshot_clients()
+        .await
+        .iter()
+        .any(|c| c.host().eq_ignore_ascii_case(host));
+    if !is_node {
+        let pods = aggregator.list_all_pods().await.map_err(|e| e.to_string())?;
+        if !pods.iter().any(|p| p.status.pod_ip == host) {
+            return Err(format!("{} is neither a pod IP nor a node address", host));
+        }
+    }
+    Ok(vec![PortTarget {
+        label: host.to_string(),
+        host: host.to_string(),
+        port,
+    }])
+}
+
+/// Checks a target with a TCP connect, or an HTTP GET of `path` when mode is
+/// "http".
+pub async fn check_port(t: &PortTarget, mode: &str, path: &str) -> PortCheckResult {
+    let addr = if t.host.contains(':') {
+        format!("[{}]:{}", t.host, t.port)
+    } else {
+        format!("{}:{}", t.host, t.port)
+    };
+    let mut result = PortCheckResult {
+        label: t.label.clone(),
+        target: addr.clone(),
+        mode: mode.to_string(),
+        ..Default::default()
+    };
+    let start = Instant::now();
+
+    if mode == "http" {
+        let path = if path.starts_with('/') {
+            path.to_string()
+        } else {
+            format!("/{}", path)
+        };
+        let client = reqwest::Client::builder()
+            .timeout(CHECK_TIMEOUT)
+            .redirect(reqwest::redirect::Policy::none())
+            .build()
+            .expect("failed to create HTTP client");
+        match client.get(format!("http://{}{}", addr, path)).send().await {
+            Ok(resp) => {
+                result.reachable = true;
+                result.status = Some(resp.status().as_u16());
+                result.latency_ms = Some(start.elapsed().as_secs_f64() * 1000.0);
+            }
+            Err(e) => result.error = e.to_string(),
+        }
+        return result;
+    }
+
+    match tokio::time::timeout(CHECK_TIMEOUT, TcpStream::connect(&addr)).await {
+        Ok(Ok(_)) => {
+            result.reachable = true;
+            result.latency_ms = Some(start.elapsed().as_secs_f64() * 1000.0);
+        }
+        Ok(Err(e)) => result.error = e.to_string(),
+        Err(_) => result.error = format!("no answer within {}s", CHECK_TIMEOUT.as_secs()),
+    }
+    result
+}
+
+/// Looks a name up against every resolver the cluster depends on: the DNS
+/// servers of mkube networks (the discovery DNS), the console's own
+/// resolver, and each healthy node's resolver via its diagnostics endpoint.
+pub async fn dns_lookup(aggregator: &Aggregator, name: &str, qtype: &str) -> Vec<DnsResult> {
+    let mut results = Vec::new();
+
+    let mut servers: Vec<(String, String)> = aggregator
+        .list_networks()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .filter(|n| !n.spec.dns.server.is_empty())
+        .map(|n| (n.metadata.name, n.spec.dns.server))
+        .collect();
+    // Networks sharing a DNS server only need asking once
+    servers.sort_by(|a, b| a.1.cmp(&b.1));
+    servers.dedup_by(|a, b| a.1 == b.1);
+    for (network, server) in &servers {
+        let mut r = dns::query(server, name, qtype).await;
+        r.server = format!("network {} ({})", network, r.server);
+        results.push(r);
+    }
+
+    if qtype == "A" || qtype == "AAAA" {
+        results.push(dns::system_lookup(name).await);
+    }
+
+    let mut handles = Vec::new();
+    for c in aggregator.snapshot_clients().await {
+        if !c.is_healthy() {
+            continue;
+        }
+        let (name, qtype) = (name.to_string(), qtype.to_string());
+        handles.push(tokio::spawn(async move {
+            let mut r = c.resolve(&name, &qtype).await.unwrap_or_else(|e| DnsResult {
+                name: name.clone(),
+                qtype: qtype.clone(),
+                error: format!("node resolver unavailable: {}", e),
+                ..Default::default()
+            });
+            r.server = format!("node {}", c.name);
+            r
+        }));
+    }
+    for handle in handles {
+        if let Ok(r) = handle.await {
+            results.push(r);
+        }
+    }
+    results
+}
+
+const COMMAND_TIMEOUT: Duration = Duration::from_secs(60);
+const MAX_RUNS: usize = 200;
+
+/// Whether a host argument is safe to hand to ping/traceroute: a hostname or
+/// address, never something that could be read as an option.
+pub fn valid_host(host: &str) -> bool {
+    !host.is_empty()
+        && !host.starts_with('-')
+        && host.len() <= 253
+        && host
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '.' | '-' | ':' | '_'))
+}
+
+/// Runs the system `ping` against a host. Returns whether it exited cleanly
+/// and its combined output.
+pub async fn ping(host: &str) -> (bool, String) {
+    run_tool(&[("ping", &["-c", "4", "-W", "2", host])]).await
+}
+
+/// Traces the route to a host with `traceroute`, falling back to `tracepath`
+/// where traceroute isn't installed.
+pub async fn traceroute(host: &str) -> (bool, String) {
+    run_tool(&[
+        ("traceroute", &["-n", "-q", "1", "-w", "2", "-m", "20", host]),
+        ("tracepath", &["-n", "-m", "20", host]),
+    ])
+    .await
+}
+
+// Runs the first of the candidate commands that exists.
+async fn run_tool(candidates: &[(&str, &[&str])]) -> (bool, String) {
+    for (bin, args) in candidates {
+        let cmd = tokio::process::Command::new(bin)
+            .args(args.iter())
+            .kill_on_drop(true)
+            .output();
+        match tokio::time::timeout(COMMAND_TIMEOUT, cmd).await {
+            Ok(Ok(out)) => {
+                let mut text = String::from_utf8_lossy(&out.stdout).to_string();
+                text.push_str(&String::from_utf8_lossy(&out.stderr));
+                return (out.status.success(), text);
+            }
+            Ok(Err(e)) if e.kind() == std::io::ErrorKind::NotFound => continue,
+            Ok(Err(e)) => return (false, format!("running {}: {}", bin, e)),
+            Err(_) => {
+                return (false, format!("{} did not finish within {}s", bin, COMMAND_TIMEOUT.as_secs()));
+            }
+        }
+    }
+    let names: Vec<&str> = candidates.iter().map(|(b, _)| *b).collect();
+    (false, format!("{} not installed on the console host", names.join(" or ")))
+}
+
+/// A stored diagnostic result. Runs are what a diagnostic bundle carries.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DiagnosticRun {
+    pub id: u64,
+    pub at: DateTime<Utc>,
+    /// dns, ping, traceroute or port-check.
+    pub kind: String,
+    pub target: String,
+    pub user: String,
+    pub ok: bool,
+    pub summary: String,
+    /// Tool output, or the JSON results for structured checks.
+    #[serde(default)]
+    pub output: String,
+}
+
+/// Recent diagnostic runs, newest first, kept in memory and optionally
+/// appended to a JSON-lines file under the data dir.
+pub struct DiagnosticsLog {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<DiagnosticsState>,
+}
+
+struct DiagnosticsState {
+    next_id: u64,
+    runs: VecDeque<DiagnosticRun>,
+}
+
+impl DiagnosticsLog {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Self {
+        let mut runs = VecDeque::new();
+        if let Some(ref p) = path {
+            if let Ok(data) = std::fs::read_to_string(p) {
+                for line in data.lines() {
+                    let parsed = sealer
+                        .open(line)
+                        .and_then(|l| {
+                            serde_json::from_str::<DiagnosticRun>(&l).map_err(|e| e.to_string())
+                        });
+                    match parsed {
+                        Ok(r) => runs.push_front(r),
+                        Err(e) => warn!("skipping bad diagnostics line in {}: {}", p.display(), e),
+                    }
+                }
+                runs.truncate(MAX_RUNS);
+            }
+        }
+        let next_id = runs.iter().map(|r| r.id).max().unwrap_or(0) + 1;
+
+        Self {
+            path,
+            sealer,
+            state: Mutex::new(DiagnosticsState { next_id, runs }),
+        }
+    }
+
+    /// Stores a run and returns its id.
+    pub fn record(
+        &self,
+        kind: &str,
+        target: &str,
+        user: &str,
+        ok: bool,
+        summary: &str,
+        output: String,
+    ) -> u64 {
+        let mut state = self.state.lock().unwrap();
+        let run = DiagnosticRun {
+            id: state.next_id,
+            at: Utc::now(),
+            kind: kind.to_string(),
+            target: target.to_string(),
+            user: user.to_string(),
+            ok,
+            summary: summary.to_string(),
+            output,
+        };
+        state.next_id += 1;
+
+        if let Some(ref p) = self.path {
+            if let Err(e) = append_line(p, &run, &self.sealer) {
+                warn!("writing diagnostics log {}: {}", p.display(), e);
+            }
+        }
+
+        let id = run.id;
+        state.runs.push_front(run);
+        state.runs.truncate(MAX_RUNS);
+        id
+    }
+
+    /// Stores a set of port checks as one run.
+    pub fn record_port_checks(&self, user: &str, results: &[PortCheckResult]) -> u64 {
+        let open = results.iter().filter(|r| r.reachable).count();
+        let target: Vec<&str> = results.iter().map(|r| r.target.as_str()).collect();
+        self.record(
+            "port-check",
+            &target.join(", "),
+            user,
+            open == results.len(),
+            &format!("{}/{} reachable", open, results.len()),
+            serde_json::to_string_pretty(results).unwrap_or_default(),
+        )
+    }
+
+    /// Stores a lookup across resolvers as one run.
+    pub fn record_dns(&self, user: &str, name: &str, qtype: &str, results: &[DnsResult]) -> u64 {
+        let answered = results.iter().filter(|r| !r.answers.is_empty()).count();
+        self.record(
+            "dns",
+            &format!("{} {}", qtype, name),
+            user,
+            answered > 0,
+            &format!("{}/{} resolvers answered", answered, results.len()),
+            serde_json::to_string_pretty(results).unwrap_or_default(),
+        )
+    }
+
+    pub fn list(&self) -> Vec<DiagnosticRun> {
+        self.state.lock().unwrap().runs.iter().cloned().collect()
+    }
+}
+
+fn append_line(
+    path: &PathBuf,
+    run: &DiagnosticRun,
+    sealer: &Sealer,
+) -> Result<(), Box<dyn std::error::Error>> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    let mut f = std::fs::OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(path)?;
+    writeln!(f, "{}", sealer.seal(&serde_json::to_string(run)?))?;
+    Ok(())
+}
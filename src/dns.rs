@@ -0,0 +1,318 @@
+use serde::{Deserialize, Serialize};
+use std::net::{IpAddr, SocketAddr};
+use std::time::{Duration, Instant};
+use tokio::net::UdpSocket;
+
+// Minimal DNS-over-UDP client for the troubleshooting page: one question,
+// answers decoded for the record types people usually ask about.
+
+const QUERY_TIMEOUT: Duration = Duration::from_secs(3);
+
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DnsResult {
+    /// Which resolver answered, e.g. "10.0.0.1:53" or "node rpi1".
+    pub server: String,
+    pub name: String,
+    pub qtype: String,
+    /// NOERROR, NXDOMAIN, SERVFAIL, ... or empty if no response.
+    #[serde(default)]
+    pub rcode: String,
+    #[serde(default)]
+    pub answers: Vec<DnsRecord>,
+    #[serde(default)]
+    pub latency_ms: Option<f64>,
+    #[serde(default)]
+    pub error: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DnsRecord {
+    pub name: String,
+    pub rtype: String,
+    pub ttl: u32,
+    pub data: String,
+}
+
+pub const QUERY_TYPES: &[&str] = &["A", "AAAA", "CNAME", "PTR", "TXT", "SRV"];
+
+fn type_code(qtype: &str) -> Option<u16> {
+    Some(match qtype {
+        "A" => 1,
+        "CNAME" => 5,
+        "PTR" => 12,
+        "TXT" => 16,
+        "AAAA" => 28,
+        "SRV" => 33,
+        _ => return None,
+    })
+}
+
+fn type_name(code: u16) -> String {
+    match code {
+        1 => "A".to_string(),
+        5 => "CNAME".to_string(),
+        12 => "PTR".to_string(),
+        16 => "TXT".to_string(),
+        28 => "AAAA".to_string(),
+        33 => "SRV".to_string(),
+        n => format!("TYPE{}", n),
+    }
+}
+
+fn rcode_name(code: u8) -> String {
+    match code {
+        0 => "NOERROR".to_string(),
+        1 => "FORMERR".to_string(),
+        2 => "SERVFAIL".to_string(),
+        3 => "NXDOMAIN".to_string(),
+        4 => "NOTIMP".to_string(),
+        5 => "REFUSED".to_string(),
+        n => format!("RCODE{}", n),
+    }
+}
+
+/// The in-addr.arpa / ip6.arpa name for an address, so PTR lookups can be
+/// given a plain IP.
+pub fn reverse_name(ip: &IpAddr) -> String {
+    match ip {
+        IpAddr::V4(v4) => {
+            let o = v4.octets();
+            format!("{}.{}.{}.{}.in-addr.arpa", o[3], o[2], o[1], o[0])
+        }
+        IpAddr::V6(v6) => {
+            let mut labels = Vec::new();
+            for b in v6.octets().iter().rev() {
+                labels.push(format!("{:x}", b & 0x0f));
+                labels.push(format!("{:x}", b >> 4));
+            }
+            format!("{}.ip6.arpa", labels.join("."))
+        }
+    }
+}
+
+/// Sends one query to `server` (host or host:port, port 53 by default).
+pub async fn query(server: &str, name: &str, qtype: &str) -> DnsResult {
+    let mut result = DnsResult {
+        server: server.to_string(),
+        name: name.to_string(),
+        qtype: qtype.to_string(),
+        ..Default::default()
+    };
+
+    let qname = match (qtype, name.parse::<IpAddr>()) {
+        ("PTR", Ok(ip)) => reverse_name(&ip),
+        _ => name.trim_end_matches('.').to_string(),
+    };
+    let Some(code) = type_code(qtype) else {
+        result.error = format!("unsupported query type {}", qtype);
+        return result;
+    };
+    let addr = match server_addr(server) {
+        Some(a) => a,
+        None => {
+            result.error = format!("invalid server address {:?}", server);
+            return result;
+        }
+    };
+    result.server = addr.to_string();
+
+    let nanos = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.subsec_nanos())
+        .unwrap_or(0);
+    let id = (std::process::id() as u16) ^ (nanos as u16);
+    let packet = match encode_query(id, &qname, code) {
+        Some(p) => p,
+        None => {
+            result.error = format!("invalid name {:?}", name);
+            return result;
+        }
+    };
+
+    let start = Instant::now();
+    let exchange = async {
+        let bind = if addr.is_ipv4() { "0.0.0.0:0" } else { "[::]:0" };
+        let sock = UdpSocket::bind(bind).await?;
+        sock.connect(addr).await?;
+        sock.send(&packet).await?;
+        let mut buf = vec![0u8; 4096];
+        loop {
+            let n = sock.recv(&mut buf).await?;
+            // Ignore stray datagrams that aren't answers to this query
+            if n >= 2 && u16::from_be_bytes([buf[0], buf[1]]) == id {
+                buf.truncate(n);
+                return Ok::<Vec<u8>, std::io::Error>(buf);
+            }
+        }
+    };
+    let resp = match tokio::time::timeout(QUERY_TIMEOUT, exchange).await {
+        Ok(Ok(r)) => r,
+        Ok(Err(e)) => {
+            result.error = e.to_string();
+            return result;
+        }
+        Err(_) => {
+            result.error = format!("no answer within {}s", QUERY_TIMEOUT.as_secs());
+            return result;
+        }
+    };
+    result.latency_ms = Some(start.elapsed().as_secs_f64() * 1000.0);
+
+    match decode_response(&resp) {
+        Some((rcode, answers)) => {
+            result.rcode = rcode_name(rcode);
+            result.answers = answers;
+        }
+        None => result.error = "malformed response".to_string(),
+    }
+    result
+}
+
+/// Resolves through the console host's own resolver (A/AAAA only).
+pub async fn system_lookup(name: &str) -> DnsResult {
+    let mut result = DnsResult {
+        server: "console resolver".to_string(),
+        name: name.to_string(),
+        qtype: "A/AAAA".to_string(),
+        ..Default::default()
+    };
+    let start = Instant::now();
+    match tokio::net::lookup_host(format!("{}:0", name)).await {
+        Ok(addrs) => {
+            result.latency_ms = Some(start.elapsed().as_secs_f64() * 1000.0);
+            result.rcode = "NOERROR".to_string();
+            for a in addrs {
+                result.answers.push(DnsRecord {
+                    name: name.to_string(),
+                    rtype: if a.is_ipv4() { "A" } else { "AAAA" }.to_string(),
+                    ttl: 0,
+                    data: a.ip().to_string(),
+                });
+            }
+        }
+        Err(e) => result.error = e.to_string(),
+    }
+    result
+}
+
+fn server_addr(server: &str) -> Option<SocketAddr> {
+    let server = server.trim();
+    if let Ok(a) = server.parse::<SocketAddr>() {
+        return Some(a);
+    }
+    server
+        .trim_start_matches('[')
+        .trim_end_matches(']')
+        .parse::<IpAddr>()
+        .ok()
+        .map(|ip| SocketAddr::new(ip, 53))
+}
+
+fn encode_query(id: u16, name: &str, qtype: u16) -> Option<Vec<u8>> {
+    let mut p = Vec::with_capacity(512);
+    p.extend_from_slice(&id.to_be_bytes());
+    p.extend_from_slice(&[0x01, 0x00]); // recursion desired
+    p.extend_from_slice(&[0, 1, 0, 0, 0, 0, 0, 0]); // 1 question
+    for label in name.split('.').filter(|l| !l.is_empty()) {
+        if label.len() > 63 {
+            return None;
+        }
+        p.push(label.len() as u8);
+        p.extend_from_slice(label.as_bytes());
+    }
+    p.push(0);
+    p.extend_from_slice(&qtype.to_be_bytes());
+    p.extend_from_slice(&[0, 1]); // class IN
+    Some(p)
+}
+
+fn decode_response(buf: &[u8]) -> Option<(u8, Vec<DnsRecord>)> {
+    if buf.len() < 12 {
+        return None;
+    }
+    let rcode = buf[3] & 0x0f;
+    let qdcount = u16::from_be_bytes([buf[4], buf[5]]);
+    let ancount = u16::from_be_bytes([buf[6], buf[7]]);
+
+    let mut pos = 12;
+    for _ in 0..qdcount {
+        let (_, next) = read_name(buf, pos)?;
+        pos = next + 4;
+    }
+
+    let mut answers = Vec::new();
+    for _ in 0..ancount {
+        let (name, next) = read_name(buf, pos)?;
+        pos = next;
+        let header = buf.get(pos..pos + 10)?;
+        let rtype = u16::from_be_bytes([header[0], header[1]]);
+        let ttl = u32::from_be_bytes([header[4], header[5], header[6], header[7]]);
+        let len = u16::from_be_bytes([header[8], header[9]]) as usize;
+        pos += 10;
+        let rdata = buf.get(pos..pos + len)?;
+
+        let data = match rtype {
+            1 if len == 4 => IpAddr::from([rdata[0], rdata[1], rdata[2], rdata[3]]).to_string(),
+            28 if len == 16 => {
+                let mut o = [0u8; 16];
+                o.copy_from_slice(rdata);
+                IpAddr::from(o).to_string()
+            }
+            5 | 12 => read_name(buf, pos)?.0,
+            16 => {
+                let mut parts = Vec::new();
+                let mut i = 0;
+                while i < rdata.len() {
+                    let n = rdata[i] as usize;
+                    let s = rdata.get(i + 1..i + 1 + n)?;
+                    parts.push(String::from_utf8_lossy(s).to_string());
+                    i += 1 + n;
+                }
+                parts.join("")
+            }
+            33 if len >= 6 => {
+                let prio = u16::from_be_bytes([rdata[0], rdata[1]]);
+                let weight = u16::from_be_bytes([rdata[2], rdata[3]]);
+                let port = u16::from_be_bytes([rdata[4], rdata[5]]);
+                format!("{} {} {} {}", prio, weight, port, read_name(buf, pos + 6)?.0)
+            }
+            _ => rdata.iter().map(|b| format!("{:02x}", b)).collect(),
+        };
+        answers.push(DnsRecord {
+            name,
+            rtype: type_name(rtype),
+            ttl,
+            data,
+        });
+        pos += len;
+    }
+    Some((rcode, answers))
+}
+
+// Reads a possibly compressed name at `pos`, returning it and the offset just
+// past it in the original position.
+fn read_name(buf: &[u8], mut pos: usize) -> Option<(String, usize)> {
+    let mut labels = Vec::new();
+    let mut end = None;
+    for _ in 0..128 {
+        let len = *buf.get(pos)? as usize;
+        if len == 0 {
+            let end = end.unwrap_or(pos + 1);
+            return Some((labels.join("."), end));
+        }
+        if len & 0xc0 == 0xc0 {
+            let ptr = ((len & 0x3f) << 8) | *buf.get(pos + 1)? as usize;
+            if end.is_none() {
+                end = Some(pos + 2);
+            }
+            pos = ptr;
+            continue;
+        }
+        let label = buf.get(pos + 1..pos + 1 + len)?;
+        labels.push(String::from_utf8_lossy(label).to_string());
+        pos += 1 + len;
+    }
+    None
+}
@@ -0,0 +1,192 @@
+use chrono::Utc;
+use serde::Serialize;
+
+use crate::activity::{ActivityEntry, ActivityLog};
+use crate::clients::aggregator::Aggregator;
+use crate::models::k8s::{Event, Pod};
+use crate::stuck::{self, StuckPod};
+
+/// Everything the console knows about why a pod is or isn't running, in
+/// one place: its status, the node's health, whether its images exist,
+/// where it could be scheduled, and what happened to it so far.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Explanation {
+    pub namespace: String,
+    pub name: String,
+    pub node: String,
+    pub phase: String,
+    /// One line answering "why isn't it running", or saying that it is.
+    pub summary: String,
+    pub checks: Vec<Check>,
+    /// Events about the pod from its node, oldest first.
+    pub events: Vec<Event>,
+    /// Changes the console made or saw, newest first.
+    pub history: Vec<ActivityEntry>,
+    /// Set when the pod counts as stuck; see stuck.rs.
+    pub stuck: Option<StuckPod>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Check {
+    /// Status, Node, Images or Scheduling.
+    pub name: &'static str,
+    pub ok: bool,
+    pub message: String,
+}
+
+pub async fn explain(aggregator: &Aggregator, activity: &ActivityLog, pod: &Pod, node: &str) -> Explanation {
+    let namespace = &pod.metadata.namespace;
+    let name = &pod.metadata.name;
+    let phase = if pod.status.phase.is_empty() {
+        "Pending"
+    } else {
+        pod.status.phase.as_str()
+    };
+    let clients = aggregator.snapshot_clients().await;
+    let client = clients.iter().find(|c| c.name == node);
+    let mut checks = Vec::new();
+
+    checks.push(status_check(pod, phase));
+
+    checks.push(match client {
+        None if node.is_empty() => Check {
+            name: "Node",
+            ok: false,
+            message: "not assigned to a node".to_string(),
+        },
+        None => Check {
+            name: "Node",
+            ok: false,
+            message: format!("{} is no longer part of the cluster", node),
+        },
+        Some(c) if !c.is_healthy() => Check {
+            name: "Node",
+            ok: false,
+            message: format!("{} is offline", node),
+        },
+        Some(c) if c.in_maintenance() => Check {
+            name: "Node",
+            ok: phase == "Running",
+            message: format!("{} is in maintenance and takes no new pods", node),
+        },
+        Some(_) => Check {
+            name: "Node",
+            ok: true,
+            message: format!("{} is online", node),
+        },
+    });
+
+    let mut missing = Vec::new();
+    let mut unknown = Vec::new();
+    for c in &pod.spec.containers {
+        match aggregator.image_in_registry(&c.image).await {
+            Some(false) => missing.push(c.image.as_str()),
+            None => unknown.push(c.image.as_str()),
+            Some(true) => {}
+        }
+    }
+    checks.push(Check {
+        name: "Images",
+        ok: missing.is_empty(),
+        message: if !missing.is_empty() {
+            format!("not in the registry: {}", missing.join(", "))
+        } else if !unknown.is_empty() {
+            format!("couldn't check {} against the registry", unknown.join(", "))
+        } else {
+            "all in the registry".to_string()
+        },
+    });
+
+    // Only worth asking the scheduler about pods that aren't running.
+    if phase == "Pending" || phase == "Failed" {
+        checks.push(match aggregator.placement(pod, &[]).await {
+            Ok(target) if target == node => Check {
+                name: "Scheduling",
+                ok: true,
+                message: format!("{} is still the best node for it", node),
+            },
+            Ok(target) => Check {
+                name: "Scheduling",
+                ok: true,
+                message: format!("{} could run it", target),
+            },
+            Err(e) => Check {
+                name: "Scheduling",
+                ok: false,
+                message: format!("no node can run it: {}", e),
+            },
+        });
+    }
+
+    let events = match client {
+        Some(c) => {
+            let mut events = c.list_events().await.map(|l| l.items).unwrap_or_default();
+            events.extend(aggregator.recorded_events());
+            events
+        }
+        None => aggregator.list_events().await.unwrap_or_default(),
+    }
+    .into_iter()
+    .filter(|e| {
+        e.involved_object.kind == "Pod" && e.involved_object.namespace == *namespace && e.involved_object.name == *name
+    })
+    .collect();
+
+    let stuck = stuck::diagnose(aggregator, pod, Utc::now()).await;
+    let summary = match (&stuck, checks.iter().find(|c| !c.ok)) {
+        (Some(s), _) => match s.causes.first() {
+            Some(cause) => format!("{}: {}", s.problem, cause),
+            None => s.problem.clone(),
+        },
+        (None, Some(failed)) => format!("{}: {}", failed.name, failed.message),
+        (None, None) if phase == "Succeeded" => "Completed".to_string(),
+        (None, None) => format!("{}; nothing looks wrong", phase),
+    };
+
+    Explanation {
+        namespace: namespace.clone(),
+        name: name.clone(),
+        node: node.to_string(),
+        phase: phase.to_string(),
+        summary,
+        checks,
+        events,
+        history: activity.for_object("pod", namespace, name),
+        stuck,
+    }
+}
+
+// The pod's phase and what its containers are waiting on or exited with.
+fn status_check(pod: &Pod, phase: &str) -> Check {
+    let problems: Vec<String> = pod
+        .status
+        .container_statuses
+        .iter()
+        .filter_map(|s| {
+            if let Some(w) = &s.state.waiting {
+                let detail = if w.message.is_empty() {
+                    w.reason.clone()
+                } else {
+                    format!("{} ({})", w.reason, w.message)
+                };
+                return Some(format!("{} waiting: {}", s.name, detail));
+            }
+            match &s.state.terminated {
+                Some(t) if t.exit_code != 0 => Some(format!("{} exited {}: {}", s.name, t.exit_code, t.reason)),
+                _ => None,
+            }
+        })
+        .collect();
+    let ready = pod.status.container_statuses.iter().filter(|s| s.ready).count();
+    let total = pod.spec.containers.len();
+    Check {
+        name: "Status",
+        ok: problems.is_empty() && (phase == "Succeeded" || (phase == "Running" && ready == total)),
+        message: if problems.is_empty() {
+            format!("{}, {}/{} containers ready", phase, ready, total)
+        } else {
+            format!("{}; {}", phase, problems.join("; "))
+        },
+    }
+}
@@ -0,0 +1,96 @@
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+
+/// Kinds of resource that can be pinned. "app" is a deployment.
+pub const FAVORITE_KINDS: &[&str] = &["pod", "node", "app"];
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Favorite {
+    pub kind: String,
+    /// Empty for cluster-scoped kinds (nodes).
+    #[serde(default)]
+    pub namespace: String,
+    pub name: String,
+}
+
+/// Per-user pinned resources, persisted as JSON under the data dir.
+pub struct FavoritesStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<HashMap<String, Vec<Favorite>>>,
+}
+
+impl FavoritesStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    pub fn list(&self, user: &str) -> Vec<Favorite> {
+        self.state
+            .lock()
+            .unwrap()
+            .get(user)
+            .cloned()
+            .unwrap_or_default()
+    }
+
+    pub fn is_pinned(&self, user: &str, fav: &Favorite) -> bool {
+        self.state
+            .lock()
+            .unwrap()
+            .get(user)
+            .map(|favs| favs.contains(fav))
+            .unwrap_or(false)
+    }
+
+    /// Pins a resource. Returns false if it was already pinned.
+    pub fn add(&self, user: &str, fav: Favorite) -> bool {
+        let mut state = self.state.lock().unwrap();
+        let favs = state.entry(user.to_string()).or_default();
+        if favs.contains(&fav) {
+            return false;
+        }
+        favs.push(fav);
+        self.save(&state);
+        true
+    }
+
+    pub fn remove(&self, user: &str, fav: &Favorite) -> bool {
+        let mut state = self.state.lock().unwrap();
+        let removed = match state.get_mut(user) {
+            Some(favs) => {
+                let before = favs.len();
+                favs.retain(|f| f != fav);
+                favs.len() != before
+            }
+            None => false,
+        };
+        if removed {
+            self.save(&state);
+        }
+        removed
+    }
+
+    fn save(&self, state: &HashMap<String, Vec<Favorite>>) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| {
+                    std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string())
+                });
+            if let Err(e) = result {
+                warn!("writing favorites {}: {}", p.display(), e);
+            }
+        }
+    }
+}
@@ -0,0 +1,150 @@
+use askama::Values;
+use askama::filters::Safe;
+use chrono::{DateTime, Utc};
+use std::fmt::Display;
+
+use crate::helpers::{human_duration_secs, human_time};
+
+// Template filters. askama resolves `{{ x|name }}` to `filters::name` in the
+// module deriving the template, so routes::ui imports this module.
+//
+// Each filter either returns plain text, which askama escapes as usual, or
+// escapes its input itself before adding markup and marks the result Safe.
+// Never mark caller input Safe without escaping it.
+
+/// A small, safe subset of Markdown for annotation values and descriptions:
+/// paragraphs, "- " lists, `code`, **bold**, *emphasis* and [text](url)
+/// links to http(s) URLs. Raw HTML is escaped, never passed through.
+pub fn markdown<T: Display>(s: T, _: &dyn Values) -> askama::Result<Safe<String>> {
+    let text = escape(&s.to_string().replace("\r\n", "\n"));
+    let mut out = String::new();
+    for block in text.split("\n\n") {
+        let lines: Vec<&str> = block.lines().map(str::trim).filter(|l| !l.is_empty()).collect();
+        if lines.is_empty() {
+            continue;
+        }
+        if lines.iter().all(|l| l.starts_with("- ")) {
+            out.push_str("<ul>");
+            for l in lines {
+                out.push_str(&format!("<li>{}</li>", inline(&l[2..])));
+            }
+            out.push_str("</ul>");
+        } else {
+            let body: Vec<String> = lines.iter().map(|l| inline(l)).collect();
+            out.push_str(&format!("<p>{}</p>", body.join("<br>")));
+        }
+    }
+    Ok(Safe(format!("<div class=\"markdown\">{}</div>", out)))
+}
+
+/// "5 minutes ago" for an RFC 3339 timestamp; anything else is shown as is.
+pub fn ago<T: Display>(ts: T, _: &dyn Values) -> askama::Result<String> {
+    let ts = ts.to_string();
+    Ok(match DateTime::parse_from_rfc3339(&ts) {
+        Ok(t) => human_time(Some(t.with_timezone(&Utc))),
+        Err(_) => ts,
+    })
+}
+
+/// "3h12m" for a number of seconds.
+pub fn duration<T: Display>(secs: T, _: &dyn Values) -> askama::Result<String> {
+    let secs = secs.to_string();
+    Ok(match secs.parse::<i64>() {
+        Ok(n) => human_duration_secs(n.max(0)),
+        Err(_) => secs,
+    })
+}
+
+/// A usage bar filled to the given percentage (clamped to 0-100). Without
+/// a colour class the bar turns yellow from 80% and red from 100%.
+pub fn usage_bar<T: Display, C: Display>(
+    percent: T,
+    _: &dyn Values,
+    class: C,
+) -> askama::Result<Safe<String>> {
+    let percent = percent.to_string().parse::<f64>().unwrap_or(0.0);
+    let class = match class.to_string() {
+        c if !c.is_empty() => escape(&c),
+        _ if percent >= 100.0 => "red".to_string(),
+        _ if percent >= 80.0 => "yellow".to_string(),
+        _ => String::new(),
+    };
+    Ok(Safe(format!(
+        "<div class=\"usage-bar\"><div class=\"usage-fill {}\" style=\"width:{}%\"></div></div>",
+        class,
+        percent.clamp(0.0, 100.0).round()
+    )))
+}
+
+/// Serializes a value as JSON for a data-* attribute, where askama's
+/// escaping keeps it intact; scripts read it back with readJSON() from
+/// app.js. Use this instead of interpolating values into inline JS.
+pub fn data_json<T: serde::Serialize>(v: T, _: &dyn Values) -> askama::Result<String> {
+    serde_json::to_string(&v).map_err(|e| askama::Error::Custom(Box::new(e)))
+}
+
+fn escape(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    for c in s.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            '\'' => out.push_str("&#x27;"),
+            c => out.push(c),
+        }
+    }
+    out
+}
+
+// Inline spans of already-escaped text.
+fn inline(s: &str) -> String {
+    let mut out = String::new();
+    let mut rest = s;
+    while let Some(i) = rest.find(['`', '*', '[']) {
+        out.push_str(&rest[..i]);
+        rest = &rest[i..];
+        match span(rest) {
+            Some((html, len)) => {
+                out.push_str(&html);
+                rest = &rest[len..];
+            }
+            None => {
+                out.push_str(&rest[..1]);
+                rest = &rest[1..];
+            }
+        }
+    }
+    out.push_str(rest);
+    out
+}
+
+// The span starting at s (on `, * or [) as HTML, and how much of s it used.
+fn span(s: &str) -> Option<(String, usize)> {
+    if let Some(body) = s.strip_prefix('`') {
+        let end = body.find('`')?;
+        return Some((format!("<code>{}</code>", &body[..end]), end + 2));
+    }
+    if let Some(body) = s.strip_prefix("**") {
+        let end = body.find("**").filter(|e| *e > 0)?;
+        return Some((format!("<strong>{}</strong>", inline(&body[..end])), end + 4));
+    }
+    if let Some(body) = s.strip_prefix('*') {
+        let end = body.find('*').filter(|e| *e > 0)?;
+        return Some((format!("<em>{}</em>", inline(&body[..end])), end + 2));
+    }
+    let body = s.strip_prefix('[')?;
+    let close = body.find("](")?;
+    let url_len = body[close + 2..].find(')')?;
+    let url = &body[close + 2..close + 2 + url_len];
+    if !(url.starts_with("https://") || url.starts_with("http://")) {
+        return None;
+    }
+    let html = format!(
+        "<a href=\"{}\" target=\"_blank\" rel=\"noopener noreferrer\">{}</a>",
+        url,
+        inline(&body[..close])
+    );
+    Some((html, close + url_len + 4))
+}
@@ -0,0 +1,129 @@
+use std::collections::HashMap;
+use std::future::Future;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+use tokio::sync::broadcast;
+use tracing::info;
+
+use crate::activity::ActivityLog;
+use crate::alerts::AlertManager;
+use crate::clients::aggregator::Aggregator;
+use crate::schedule::Schedule;
+
+/// How long a rendered fragment is served without a re-render when nothing
+/// has changed. Node health and "last seen" times drift without producing
+/// events, so this stays close to the pages' 10s refresh.
+pub const FRAGMENT_TTL: Duration = Duration::from_secs(10);
+
+/// Rendered HTML for cluster-wide parts of expensive pages (dashboard
+/// widgets, the nodes table), so every open dashboard polling the console
+/// doesn't turn into another round of node API calls.
+///
+/// Fragments are keyed by name and tagged with the cluster state version
+/// they were rendered at. The version is bumped by every activity entry,
+/// newly firing alert and aggregator pod or node event, which drops all
+/// fragments at once; the TTL covers
+/// changes that aren't reported as events. Concurrent misses on one key
+/// wait for a single render. Each fragment's TTL is jittered so fragments
+/// rendered together don't all expire, and hit the nodes, together.
+pub struct FragmentCache {
+    ttl: Duration,
+    schedule: Arc<Schedule>,
+    version: AtomicU64,
+    slots: Mutex<HashMap<String, Arc<tokio::sync::Mutex<Option<Fragment>>>>>,
+}
+
+struct Fragment {
+    version: u64,
+    expires_at: Instant,
+    html: String,
+}
+
+impl FragmentCache {
+    pub fn new(ttl: Duration, schedule: Arc<Schedule>) -> Self {
+        schedule.register("page-fragments", "Re-renders dashboard widgets and tables on demand", ttl, false);
+        Self {
+            ttl,
+            schedule,
+            version: AtomicU64::new(0),
+            slots: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// The cached fragment for `key`, or the result of `render` if it is
+    /// missing, older than the TTL or from an earlier cluster state.
+    /// Render errors are returned and not cached.
+    pub async fn get_or_render<F, Fut, E>(&self, key: &str, render: F) -> Result<String, E>
+    where
+        F: FnOnce() -> Fut,
+        Fut: Future<Output = Result<String, E>>,
+    {
+        let slot = self
+            .slots
+            .lock()
+            .unwrap()
+            .entry(key.to_string())
+            .or_default()
+            .clone();
+        let mut cached = slot.lock().await;
+        let version = self.version.load(Ordering::Acquire);
+        if let Some(ref f) = *cached {
+            if f.version == version && Instant::now() < f.expires_at {
+                return Ok(f.html.clone());
+            }
+        }
+        // A change during the render leaves this tagged with the old
+        // version, so the next request renders again.
+        let started = chrono::Utc::now();
+        let html = render().await?;
+        let ttl = self.schedule.jittered(self.ttl);
+        self.schedule.ran("page-fragments", started, ttl);
+        *cached = Some(Fragment {
+            version,
+            expires_at: Instant::now() + ttl,
+            html: html.clone(),
+        });
+        Ok(html)
+    }
+
+    /// Marks every cached fragment stale.
+    pub fn invalidate(&self) {
+        self.version.fetch_add(1, Ordering::AcqRel);
+    }
+
+    /// Invalidates on cluster activity, firing alerts and aggregator events
+    /// until shutdown.
+    pub async fn run(
+        self: Arc<Self>,
+        activity: Arc<ActivityLog>,
+        alerts: Arc<AlertManager>,
+        aggregator: Arc<Aggregator>,
+        mut shutdown: tokio::sync::watch::Receiver<()>,
+    ) {
+        let mut changes = activity.subscribe();
+        let mut fired = alerts.subscribe();
+        let mut events = aggregator.subscribe();
+
+        loop {
+            tokio::select! {
+                msg = changes.recv() => match msg {
+                    Ok(_) | Err(broadcast::error::RecvError::Lagged(_)) => self.invalidate(),
+                    Err(broadcast::error::RecvError::Closed) => return,
+                },
+                msg = fired.recv() => match msg {
+                    Ok(_) | Err(broadcast::error::RecvError::Lagged(_)) => self.invalidate(),
+                    Err(broadcast::error::RecvError::Closed) => return,
+                },
+                msg = events.recv() => match msg {
+                    Ok(_) | Err(broadcast::error::RecvError::Lagged(_)) => self.invalidate(),
+                    Err(broadcast::error::RecvError::Closed) => return,
+                },
+                _ = shutdown.changed() => {
+                    info!("fragment cache invalidator shutting down");
+                    return;
+                }
+            }
+        }
+    }
+}
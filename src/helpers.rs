@@ -1,4 +1,9 @@
+use axum::http::HeaderMap;
 use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::net::IpAddr;
+
+use crate::config::Config;
 
 pub fn human_bytes(b: i64) -> String {
     if b == 0 {
@@ -20,6 +25,50 @@ pub fn human_bytes(b: i64) -> String {
     format!("{:.1} {}", b as f64 / div as f64, suffixes[exp])
 }
 
+/// Formats a byte rate, e.g. "1.2 MB/s".
+pub fn human_rate(bytes_per_sec: f64) -> String {
+    format!("{}/s", human_bytes(bytes_per_sec.round() as i64))
+}
+
+/// Parses a Kubernetes CPU quantity ("500m", "2", "0.5") into millicores.
+pub fn parse_cpu_millis(q: &str) -> Option<i64> {
+    let q = q.trim();
+    if let Some(m) = q.strip_suffix('m') {
+        return m.parse().ok();
+    }
+    q.parse::<f64>().ok().map(|c| (c * 1000.0).round() as i64)
+}
+
+/// Parses a Kubernetes memory quantity ("512Mi", "1G", "1048576") into bytes.
+pub fn parse_memory_bytes(q: &str) -> Option<i64> {
+    let q = q.trim();
+    const SUFFIXES: &[(&str, i64)] = &[
+        ("Ki", 1 << 10),
+        ("Mi", 1 << 20),
+        ("Gi", 1 << 30),
+        ("Ti", 1 << 40),
+        ("k", 1_000),
+        ("K", 1_000),
+        ("M", 1_000_000),
+        ("G", 1_000_000_000),
+        ("T", 1_000_000_000_000),
+    ];
+    for (suffix, mult) in SUFFIXES {
+        if let Some(n) = q.strip_suffix(suffix) {
+            return n.parse::<f64>().ok().map(|v| (v * *mult as f64) as i64);
+        }
+    }
+    q.parse().ok()
+}
+
+pub fn human_cpu(millis: i64) -> String {
+    if millis % 1000 == 0 {
+        format!("{}", millis / 1000)
+    } else {
+        format!("{}m", millis)
+    }
+}
+
 pub fn human_time(t: Option<DateTime<Utc>>) -> String {
     let t = match t {
         Some(t) => t,
@@ -85,3 +134,61 @@ pub fn parse_age(start_time: &Option<String>) -> String {
 
     String::new()
 }
+
+/// Percent-encodes a string for use as a URL query value.
+pub fn url_encode(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    for b in s.bytes() {
+        match b {
+            b'A'..=b'Z' | b'a'..=b'z' | b'0'..=b'9' | b'-' | b'_' | b'.' | b'~' => {
+                out.push(b as char)
+            }
+            _ => out.push_str(&format!("%{:02X}", b)),
+        }
+    }
+    out
+}
+
+/// The authenticated user as forwarded by a fronting auth proxy, if any.
+pub fn request_user(headers: &HeaderMap) -> String {
+    ["remote-user", "x-forwarded-user"]
+        .iter()
+        .find_map(|h| headers.get(*h).and_then(|v| v.to_str().ok()))
+        .filter(|u| !u.is_empty())
+        .unwrap_or("anonymous")
+        .to_string()
+}
+
+/// The address a request came from: the connecting peer's or, when that is
+/// one of config.trusted_proxies, the nearest address in X-Forwarded-For
+/// that isn't. Read from the right, since a client can put anything at the
+/// front of the header.
+pub fn client_addr(cfg: &Config, peer: IpAddr, headers: &HeaderMap) -> String {
+    let hops: Vec<&str> = headers
+        .get_all("x-forwarded-for")
+        .iter()
+        .filter_map(|v| v.to_str().ok())
+        .flat_map(|v| v.split(','))
+        .map(str::trim)
+        .collect();
+    let mut addr = peer;
+    for hop in hops.iter().rev() {
+        if !cfg.is_trusted_proxy(&addr) {
+            break;
+        }
+        let Ok(ip) = hop.parse::<IpAddr>() else { break };
+        addr = ip;
+    }
+    addr.to_string()
+}
+
+/// A strong ETag for `value`: a hash of its JSON, quoted. Equal values get
+/// equal tags, so a client can tell whether an object changed since it
+/// last read it.
+pub fn etag<T: Serialize>(value: &T) -> String {
+    // Through Value so map keys are sorted whatever HashMap order
+    let doc = serde_json::to_value(value).unwrap_or_default().to_string();
+    let digest = ring::digest::digest(&ring::digest::SHA256, doc.as_bytes());
+    let hex: String = digest.as_ref()[..12].iter().map(|b| format!("{:02x}", b)).collect();
+    format!("\"{}\"", hex)
+}
@@ -0,0 +1,94 @@
+use chrono::{DateTime, Utc};
+use reqwest::Client;
+use serde::Serialize;
+use std::sync::Arc;
+use std::time::Duration;
+use tokio::sync::broadcast;
+use tracing::{info, warn};
+
+use crate::clients::aggregator::Aggregator;
+use crate::clients::events::VersionedEvent;
+use crate::config::EventHookConfig;
+use crate::leader::LeaderElector;
+use crate::settings::SettingsStore;
+
+#[derive(Serialize)]
+struct HookPayload<'a> {
+    time: DateTime<Utc>,
+    cluster: &'a str,
+    #[serde(flatten)]
+    event: &'a VersionedEvent,
+}
+
+/// Forwards aggregator events to config.event_hooks and the hooks applied
+/// through the console (settings.rs). Only the leader sends, so an HA pair
+/// doesn't deliver everything twice; delivery failures are logged and
+/// dropped.
+pub struct EventHooks {
+    http: Client,
+    cluster: String,
+    hooks: Vec<EventHookConfig>,
+    settings: Arc<SettingsStore>,
+    leader: Arc<LeaderElector>,
+}
+
+impl EventHooks {
+    pub fn new(
+        hooks: Vec<EventHookConfig>,
+        settings: Arc<SettingsStore>,
+        cluster: String,
+        leader: Arc<LeaderElector>,
+    ) -> Self {
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        Self {
+            http,
+            cluster,
+            hooks,
+            settings,
+            leader,
+        }
+    }
+
+    pub async fn run(self: Arc<Self>, aggregator: Arc<Aggregator>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut events = aggregator.subscribe();
+
+        loop {
+            tokio::select! {
+                msg = events.recv() => match msg {
+                    Ok(e) if self.leader.is_leader() => self.send(&e),
+                    Err(broadcast::error::RecvError::Lagged(n)) => warn!("event hooks: dropped {} events", n),
+                    Err(broadcast::error::RecvError::Closed) => return,
+                    Ok(_) => {}
+                },
+                _ = shutdown.changed() => {
+                    info!("event hooks shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    fn send(&self, event: &VersionedEvent) {
+        let payload = HookPayload {
+            time: Utc::now(),
+            cluster: &self.cluster,
+            event,
+        };
+        let wanted = |h: &&EventHookConfig| h.events.is_empty() || h.events.iter().any(|e| e == event.event.event_type());
+        let applied = self.settings.event_hooks();
+        for h in self.hooks.iter().chain(&applied).filter(wanted) {
+            let req = self.http.post(&h.url).json(&payload);
+            let url = h.url.clone();
+            tokio::spawn(async move {
+                match req.send().await {
+                    Ok(r) if !r.status().is_success() => warn!("event hook {}: {}", url, r.status()),
+                    Err(e) => warn!("event hook {}: {}", url, e),
+                    Ok(_) => {}
+                }
+            });
+        }
+    }
+}
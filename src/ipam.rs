@@ -0,0 +1,237 @@
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::net::IpAddr;
+
+use crate::clients::aggregator::Aggregator;
+use crate::config::{Config, ReservedRange};
+use crate::models::k8s::Pod;
+
+/// An IP network parsed from CIDR notation ("10.0.0.0/24").
+#[derive(Debug, Clone)]
+pub struct Subnet {
+    pub name: String,
+    pub cidr: String,
+    addr: IpAddr,
+    prefix: u32,
+}
+
+impl Subnet {
+    pub fn parse(name: &str, cidr: &str) -> Option<Self> {
+        let (addr, prefix) = cidr.trim().split_once('/')?;
+        let addr: IpAddr = addr.parse().ok()?;
+        let prefix: u32 = prefix.parse().ok()?;
+        let max = if addr.is_ipv4() { 32 } else { 128 };
+        if prefix > max {
+            return None;
+        }
+        Some(Self {
+            name: name.to_string(),
+            cidr: cidr.trim().to_string(),
+            addr,
+            prefix,
+        })
+    }
+
+    pub fn contains(&self, ip: &IpAddr) -> bool {
+        match (self.addr, ip) {
+            (IpAddr::V4(net), IpAddr::V4(ip)) => {
+                let mask = u32::MAX.checked_shl(32 - self.prefix).unwrap_or(0);
+                u32::from(net) & mask == u32::from(*ip) & mask
+            }
+            (IpAddr::V6(net), IpAddr::V6(ip)) => {
+                let mask = u128::MAX.checked_shl(128 - self.prefix).unwrap_or(0);
+                u128::from(net) & mask == u128::from(*ip) & mask
+            }
+            _ => false,
+        }
+    }
+}
+
+/// One address in use by a node or pod, with whatever looks wrong about it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct IpamEntry {
+    pub ip: String,
+    /// node, pod, or host-network pod (which shares its node's address).
+    pub kind: String,
+    pub namespace: String,
+    pub name: String,
+    pub node: String,
+    /// Name of the subnet the address falls in, if any.
+    pub network: String,
+    pub reserved: String,
+    pub issues: Vec<String>,
+}
+
+/// Builds the address table from node addresses (node name -> IPs) and pods,
+/// flagging duplicates, addresses outside every known subnet, and addresses
+/// inside a reserved range.
+pub fn build(
+    node_ips: &BTreeMap<String, Vec<String>>,
+    pods: &[Pod],
+    subnets: &[Subnet],
+    reserved: &[ReservedRange],
+) -> Vec<IpamEntry> {
+    let mut entries = Vec::new();
+    for (node, ips) in node_ips {
+        for ip in ips {
+            entries.push(entry(ip, "node", "", node, node));
+        }
+    }
+    for p in pods {
+        if p.status.pod_ip.is_empty() {
+            continue;
+        }
+        let node = p
+            .metadata
+            .annotations
+            .as_ref()
+            .and_then(|a| a.get("mkube.io/node"))
+            .cloned()
+            .unwrap_or_default();
+        let kind = if p.status.pod_ip == p.status.host_ip {
+            "host-network"
+        } else {
+            "pod"
+        };
+        entries.push(entry(&p.status.pod_ip, kind, &p.metadata.namespace, &p.metadata.name, &node));
+    }
+
+    // Host-network pods legitimately share the node's address
+    let mut owners: BTreeMap<String, Vec<String>> = BTreeMap::new();
+    for e in entries.iter().filter(|e| e.kind != "host-network") {
+        owners.entry(e.ip.clone()).or_default().push(owner_label(e));
+    }
+
+    for e in &mut entries {
+        let Ok(ip) = e.ip.parse::<IpAddr>() else {
+            e.issues.push("not a valid IP address".to_string());
+            continue;
+        };
+        if e.kind != "host-network" {
+            let me = owner_label(e);
+            let others: Vec<&String> = owners
+                .get(&e.ip)
+                .map(|o| o.iter().filter(|x| **x != me).collect())
+                .unwrap_or_default();
+            if !others.is_empty() {
+                let others: Vec<&str> = others.iter().map(|s| s.as_str()).collect();
+                e.issues.push(format!("collides with {}", others.join(", ")));
+            }
+        }
+        match subnets.iter().find(|s| s.contains(&ip)) {
+            Some(s) => e.network = s.name.clone(),
+            None if !subnets.is_empty() => e.issues.push("outside every known subnet".to_string()),
+            None => {}
+        }
+        if let Some(r) = reserved.iter().find(|r| in_range(r, &ip)) {
+            e.reserved = r.name.clone();
+            e.issues.push(format!("inside reserved range {}", r.name));
+        }
+    }
+
+    entries.sort_by(|a, b| {
+        let key = |e: &IpamEntry| e.ip.parse::<IpAddr>().ok();
+        key(a).cmp(&key(b)).then_with(|| a.ip.cmp(&b.ip))
+    });
+    entries
+}
+
+/// Gathers node addresses, pods and subnets (mkube networks plus networks
+/// from the console config) and builds the address table.
+pub async fn collect(aggregator: &Aggregator, cfg: &Config) -> (Vec<IpamEntry>, Vec<Subnet>) {
+    let mut node_ips: BTreeMap<String, Vec<String>> = BTreeMap::new();
+    for c in aggregator.snapshot_clients().await {
+        if c.host().parse::<IpAddr>().is_ok() {
+            node_ips.entry(c.name.clone()).or_default().push(c.host().to_string());
+        }
+    }
+    for n in aggregator.list_all_nodes().await.unwrap_or_default() {
+        let ips = node_ips.entry(n.metadata.name.clone()).or_default();
+        for a in &n.status.addresses {
+            if a.address_type.ends_with("IP") && !ips.contains(&a.address) {
+                ips.push(a.address.clone());
+            }
+        }
+    }
+
+    let mut subnets: Vec<Subnet> = aggregator
+        .list_networks()
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|n| Subnet::parse(&n.metadata.name, &n.spec.cidr))
+        .collect();
+    for n in &cfg.networks {
+        if subnets.iter().any(|s| s.name == n.name) {
+            continue;
+        }
+        if let Some(s) = n.cidr.as_deref().and_then(|c| Subnet::parse(&n.name, c)) {
+            subnets.push(s);
+        }
+    }
+
+    let pods = aggregator.list_all_pods().await.unwrap_or_default();
+    let entries = build(&node_ips, &pods, &subnets, &cfg.ipam.reserved);
+    (entries, subnets)
+}
+
+pub fn in_range(r: &ReservedRange, ip: &IpAddr) -> bool {
+    match (r.start.trim().parse::<IpAddr>(), r.end.trim().parse::<IpAddr>()) {
+        (Ok(start), Ok(end)) => {
+            start.is_ipv4() == ip.is_ipv4() && start <= *ip && *ip <= end
+        }
+        _ => false,
+    }
+}
+
+/// Renders entries as CSV with a header row.
+pub fn to_csv(entries: &[IpamEntry]) -> String {
+    let mut out = String::from("ip,kind,namespace,name,node,network,reserved,issues\n");
+    for e in entries {
+        let issues = e.issues.join("; ");
+        let fields = [
+            e.ip.as_str(),
+            e.kind.as_str(),
+            e.namespace.as_str(),
+            e.name.as_str(),
+            e.node.as_str(),
+            e.network.as_str(),
+            e.reserved.as_str(),
+            issues.as_str(),
+        ];
+        let line: Vec<String> = fields.iter().map(|f| csv_field(f)).collect();
+        out.push_str(&line.join(","));
+        out.push('\n');
+    }
+    out
+}
+
+fn entry(ip: &str, kind: &str, namespace: &str, name: &str, node: &str) -> IpamEntry {
+    IpamEntry {
+        ip: ip.to_string(),
+        kind: kind.to_string(),
+        namespace: namespace.to_string(),
+        name: name.to_string(),
+        node: node.to_string(),
+        network: String::new(),
+        reserved: String::new(),
+        issues: Vec::new(),
+    }
+}
+
+fn owner_label(e: &IpamEntry) -> String {
+    if e.namespace.is_empty() {
+        format!("{} {}", e.kind, e.name)
+    } else {
+        format!("{} {}/{}", e.kind, e.namespace, e.name)
+    }
+}
+
+fn csv_field(s: &str) -> String {
+    if s.contains([',', '"', '\n']) {
+        format!("\"{}\"", s.replace('"', "\"\""))
+    } else {
+        s.to_string()
+    }
+}
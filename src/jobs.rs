@@ -0,0 +1,152 @@
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::sync::Notify;
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::{Job, JobStatus, TypeMeta};
+
+#[derive(Debug)]
+pub enum JobError {
+    NotFound,
+    AlreadyExists,
+    Invalid(String),
+}
+
+/// Jobs submitted to the console: run-to-completion pods that the job
+/// controller (controllers/jobs.rs) creates, tracks and retries. The store
+/// holds each job's spec as submitted and the status the controller last
+/// wrote. Persisted under the data dir when there is one.
+pub struct JobStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<JobState>,
+    created: Notify,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct JobState {
+    version: u64,
+    /// Keyed by "namespace/name".
+    jobs: BTreeMap<String, Job>,
+}
+
+impl JobStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+            created: Notify::new(),
+        })
+    }
+
+    /// Jobs in `namespace`, or in all namespaces, and the store's
+    /// resourceVersion.
+    pub fn list(&self, namespace: Option<&str>) -> (Vec<Job>, u64) {
+        let state = self.state.lock().unwrap();
+        let jobs = state
+            .jobs
+            .values()
+            .filter(|j| namespace.is_none_or(|ns| j.metadata.namespace == ns))
+            .cloned()
+            .collect();
+        (jobs, state.version)
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<Job> {
+        self.state.lock().unwrap().jobs.get(&key(namespace, name)).cloned()
+    }
+
+    pub fn create(&self, namespace: &str, mut job: Job) -> Result<Job, JobError> {
+        validate(&job)?;
+        let mut state = self.state.lock().unwrap();
+        let k = key(namespace, &job.metadata.name);
+        if state.jobs.contains_key(&k) {
+            return Err(JobError::AlreadyExists);
+        }
+        state.version += 1;
+        job.type_meta = TypeMeta {
+            api_version: "batch/v1".to_string(),
+            kind: "Job".to_string(),
+        };
+        job.metadata.namespace = namespace.to_string();
+        job.metadata.creation_timestamp = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        job.metadata.resource_version = state.version.to_string();
+        job.status = JobStatus::default();
+        state.jobs.insert(k, job.clone());
+        self.save(&state);
+        drop(state);
+        self.created.notify_one();
+        Ok(job)
+    }
+
+    /// Records the controller's view of a job.
+    pub fn update_status(&self, namespace: &str, name: &str, status: JobStatus) -> Result<Job, JobError> {
+        let mut state = self.state.lock().unwrap();
+        state.version += 1;
+        let version = state.version.to_string();
+        let Some(job) = state.jobs.get_mut(&key(namespace, name)) else {
+            return Err(JobError::NotFound);
+        };
+        job.status = status;
+        job.metadata.resource_version = version;
+        let job = job.clone();
+        self.save(&state);
+        Ok(job)
+    }
+
+    pub fn delete(&self, namespace: &str, name: &str) -> Result<Job, JobError> {
+        let mut state = self.state.lock().unwrap();
+        let Some(job) = state.jobs.remove(&key(namespace, name)) else {
+            return Err(JobError::NotFound);
+        };
+        state.version += 1;
+        self.save(&state);
+        Ok(job)
+    }
+
+    /// Resolves when a job is created, so the controller starts it without
+    /// waiting for its next pass.
+    pub async fn created(&self) {
+        self.created.notified().await
+    }
+
+    fn save(&self, state: &JobState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| {
+                    std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string())
+                });
+            if let Err(e) = result {
+                warn!("writing jobs {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn validate(job: &Job) -> Result<(), JobError> {
+    let spec = &job.spec;
+    if job.metadata.name.is_empty() {
+        return Err(JobError::Invalid("metadata.name is required".to_string()));
+    }
+    if spec.template.spec.containers.is_empty() {
+        return Err(JobError::Invalid("spec.template.spec.containers is required".to_string()));
+    }
+    if spec.completions < 1 || spec.parallelism < 1 {
+        return Err(JobError::Invalid("completions and parallelism must be at least 1".to_string()));
+    }
+    if spec.backoff_limit < 0 {
+        return Err(JobError::Invalid("backoffLimit must not be negative".to_string()));
+    }
+    Ok(())
+}
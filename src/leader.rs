@@ -0,0 +1,279 @@
+use chrono::{DateTime, Utc};
+use ring::rand::{SecureRandom, SystemRandom};
+use serde::{Deserialize, Serialize};
+use std::fs::{File, OpenOptions, TryLockError};
+use std::path::PathBuf;
+use std::sync::Mutex;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::config::HaConfig;
+
+/// Lease record shared between console instances. Stored as JSON in a file
+/// both instances can reach (typically an NFS or iSCSI-backed data dir).
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Lease {
+    pub holder: String,
+    #[serde(default)]
+    pub advertise_url: String,
+    pub renewed_at: DateTime<Utc>,
+}
+
+/// Active/standby leader election over a lease file, read and written
+/// under an exclusive lock on a sibling ".lock" file so two instances can't
+/// both take an expired lease. Only the leader runs controllers; both instances serve reads and the standby redirects
+/// mutations to the leader's advertised URL. Without an `ha` section the
+/// console is always leader.
+pub struct LeaderElector {
+    cfg: Option<HaConfig>,
+    /// Fixed redirect target for follower consoles, which never lead.
+    upstream: Option<String>,
+    state: Mutex<LeaderState>,
+}
+
+struct LeaderState {
+    leader: bool,
+    current: Option<Lease>,
+    /// Set while handing off: the lease is left alone until then so the
+    /// standby can take it.
+    yield_until: Option<DateTime<Utc>>,
+}
+
+impl LeaderElector {
+    pub fn new(cfg: Option<HaConfig>) -> Self {
+        let leader = cfg.is_none();
+        Self {
+            cfg,
+            upstream: None,
+            state: Mutex::new(LeaderState {
+                leader,
+                current: None,
+                yield_until: None,
+            }),
+        }
+    }
+
+    pub fn follower(upstream: String) -> Self {
+        Self {
+            cfg: None,
+            upstream: Some(upstream),
+            state: Mutex::new(LeaderState {
+                leader: false,
+                current: None,
+                yield_until: None,
+            }),
+        }
+    }
+
+    pub fn is_leader(&self) -> bool {
+        self.state.lock().unwrap().leader
+    }
+
+    /// Whether another instance stands by to take over, i.e. HA is set up.
+    pub fn has_standby(&self) -> bool {
+        self.cfg.is_some()
+    }
+
+    pub fn identity(&self) -> String {
+        match (&self.cfg, &self.upstream) {
+            (Some(c), _) => c.identity.clone(),
+            (None, Some(_)) => "follower".to_string(),
+            (None, None) => "standalone".to_string(),
+        }
+    }
+
+    /// The most recently observed lease, whoever holds it.
+    pub fn current_lease(&self) -> Option<Lease> {
+        self.state.lock().unwrap().current.clone()
+    }
+
+    /// Base URL of the current leader, for redirecting mutations from a standby.
+    pub fn leader_url(&self) -> Option<String> {
+        if let Some(ref u) = self.upstream {
+            return Some(u.clone());
+        }
+        self.current_lease()
+            .map(|l| l.advertise_url)
+            .filter(|u| !u.is_empty())
+    }
+
+    pub async fn run(&self, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let cfg = match self.cfg {
+            Some(ref c) => c.clone(),
+            None => return,
+        };
+
+        let mut interval = time::interval(Duration::from_secs(cfg.renew_secs));
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    self.tick(&cfg);
+                }
+                _ = shutdown.changed() => {
+                    if self.is_leader() {
+                        self.release(&cfg);
+                    }
+                    info!("leader election shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    fn tick(&self, cfg: &HaConfig) {
+        let path = PathBuf::from(&cfg.lease_path);
+        let now = Utc::now();
+        // Held until the lease is written, so the other instance can't
+        // decide on the same expired lease in between. Without it this
+        // tick only reads.
+        let lock = match lock_lease(&path) {
+            Ok(lock) => lock,
+            Err(e) => {
+                warn!("locking lease {}: {}", path.display(), e);
+                None
+            }
+        };
+        let existing = read_lease(&path);
+
+        let lease_expired = existing.as_ref().is_none_or(|l| expired(l, now, cfg));
+        let ours = existing
+            .as_ref()
+            .map(|l| l.holder == cfg.identity)
+            .unwrap_or(false);
+
+        let yielding = self.state.lock().unwrap().yield_until.is_some_and(|t| now < t);
+        let observed = if lock.is_some() && (ours || lease_expired) && !yielding {
+            let lease = Lease {
+                holder: cfg.identity.clone(),
+                advertise_url: cfg.advertise_url.clone(),
+                renewed_at: now,
+            };
+            if let Err(e) = write_lease(&path, &lease) {
+                warn!("writing lease {}: {}", path.display(), e);
+            }
+            read_lease(&path)
+        } else {
+            existing
+        };
+        drop(lock);
+
+        // A lease of ours that failed to renew stops counting once it
+        // expires, since the standby may take it from then on.
+        let leader = observed
+            .as_ref()
+            .map(|l| l.holder == cfg.identity && !expired(l, now, cfg))
+            .unwrap_or(false);
+
+        let mut state = self.state.lock().unwrap();
+        if leader != state.leader {
+            if leader {
+                info!("acquired console leadership as {}", cfg.identity);
+            } else {
+                let holder = observed.as_ref().map(|l| l.holder.as_str()).unwrap_or("nobody");
+                info!("running as standby; leader is {}", holder);
+            }
+        }
+        state.leader = leader;
+        state.current = observed;
+    }
+
+    /// Hands leadership to the standby, e.g. before this instance's pod is
+    /// restarted: expires our lease, stays off it while the standby picks
+    /// it up, and returns the new leader. If no standby takes over within
+    /// two lease periods, leadership is taken back and an error returned.
+    pub async fn hand_off(&self) -> Result<String, String> {
+        let Some(ref cfg) = self.cfg else {
+            return Err("no ha section is configured, so there is no standby to hand off to".to_string());
+        };
+        if !self.is_leader() {
+            return Err("this console is not the leader".to_string());
+        }
+        let wait = chrono::Duration::seconds(2 * cfg.lease_secs as i64);
+        self.state.lock().unwrap().yield_until = Some(Utc::now() + wait);
+        self.release(cfg);
+        info!("handing console leadership off");
+
+        let path = PathBuf::from(&cfg.lease_path);
+        let deadline = time::Instant::now() + Duration::from_secs(2 * cfg.lease_secs);
+        while time::Instant::now() < deadline {
+            time::sleep(Duration::from_secs(1)).await;
+            if let Some(lease) = read_lease(&path).filter(|l| l.holder != cfg.identity) {
+                self.state.lock().unwrap().current = Some(lease.clone());
+                info!("handed console leadership to {}", lease.holder);
+                return Ok(lease.holder);
+            }
+        }
+        self.state.lock().unwrap().yield_until = None;
+        Err(format!("no standby took over within {}s; keeping leadership", 2 * cfg.lease_secs))
+    }
+
+    // Expire our lease on clean shutdown so the standby takes over right away.
+    fn release(&self, cfg: &HaConfig) {
+        let path = PathBuf::from(&cfg.lease_path);
+        let lock = match lock_lease(&path) {
+            Ok(lock) => lock,
+            Err(e) => {
+                warn!("locking lease {}: {}", path.display(), e);
+                None
+            }
+        };
+        if let Some(mut lease) = read_lease(&path).filter(|_| lock.is_some()) {
+            if lease.holder == cfg.identity {
+                lease.renewed_at = DateTime::<Utc>::MIN_UTC;
+                if let Err(e) = write_lease(&path, &lease) {
+                    warn!("releasing lease {}: {}", path.display(), e);
+                }
+            }
+        }
+        self.state.lock().unwrap().leader = false;
+    }
+}
+
+fn expired(lease: &Lease, now: DateTime<Utc>, cfg: &HaConfig) -> bool {
+    (now - lease.renewed_at).num_seconds() > cfg.lease_secs as i64
+}
+
+// An exclusive lock on the lease's ".lock" file, released when dropped;
+// None while the other instance holds it.
+fn lock_lease(path: &PathBuf) -> std::io::Result<Option<File>> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    let file = OpenOptions::new()
+        .create(true)
+        .write(true)
+        .truncate(false)
+        .open(path.with_extension("lock"))?;
+    match file.try_lock() {
+        Ok(()) => Ok(Some(file)),
+        Err(TryLockError::WouldBlock) => Ok(None),
+        Err(TryLockError::Error(e)) => Err(e),
+    }
+}
+
+fn read_lease(path: &PathBuf) -> Option<Lease> {
+    let data = std::fs::read_to_string(path).ok()?;
+    serde_json::from_str(&data).ok()
+}
+
+fn write_lease(path: &PathBuf, lease: &Lease) -> Result<(), Box<dyn std::error::Error>> {
+    if let Some(dir) = path.parent() {
+        std::fs::create_dir_all(dir)?;
+    }
+    // Both instances may run as PID 1 in their containers, so the temp
+    // name is the holder's plus a random suffix.
+    let mut random = [0u8; 4];
+    let _ = SystemRandom::new().fill(&mut random);
+    let suffix: String = random.iter().map(|b| format!("{:02x}", b)).collect();
+    let holder: String = lease
+        .holder
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() || c == '-' { c } else { '_' })
+        .collect();
+    let tmp = path.with_extension(format!("tmp.{}.{}", holder, suffix));
+    std::fs::write(&tmp, serde_json::to_vec(lease)?)?;
+    std::fs::rename(&tmp, path)?;
+    Ok(())
+}
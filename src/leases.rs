@@ -0,0 +1,148 @@
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::{Lease, TypeMeta};
+
+/// Why a lease write was refused; maps onto Kubernetes' status reasons.
+#[derive(Debug)]
+pub enum LeaseError {
+    NotFound,
+    AlreadyExists,
+    /// The write was based on an older resourceVersion.
+    Conflict,
+    Invalid(String),
+}
+
+/// coordination.k8s.io Leases kept by the console, so components that
+/// elect a leader through leases can do so against the aggregated API.
+/// Writes are compare-and-swap on resourceVersion, like the real API
+/// server, which is all lease-based election relies on. Persisted under
+/// the data dir when there is one.
+pub struct LeaseStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<LeaseState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct LeaseState {
+    version: u64,
+    /// Keyed by "namespace/name".
+    leases: BTreeMap<String, Lease>,
+}
+
+impl LeaseStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    /// Leases in `namespace`, or in all namespaces, and the store's
+    /// resourceVersion.
+    pub fn list(&self, namespace: Option<&str>) -> (Vec<Lease>, u64) {
+        let state = self.state.lock().unwrap();
+        let leases = state
+            .leases
+            .values()
+            .filter(|l| namespace.is_none_or(|ns| l.metadata.namespace == ns))
+            .cloned()
+            .collect();
+        (leases, state.version)
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<Lease> {
+        self.state.lock().unwrap().leases.get(&key(namespace, name)).cloned()
+    }
+
+    pub fn create(&self, namespace: &str, mut lease: Lease) -> Result<Lease, LeaseError> {
+        if lease.metadata.name.is_empty() {
+            return Err(LeaseError::Invalid("metadata.name is required".to_string()));
+        }
+        let mut state = self.state.lock().unwrap();
+        let k = key(namespace, &lease.metadata.name);
+        if state.leases.contains_key(&k) {
+            return Err(LeaseError::AlreadyExists);
+        }
+        state.version += 1;
+        lease.type_meta = lease_type();
+        lease.metadata.namespace = namespace.to_string();
+        lease.metadata.creation_timestamp = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        lease.metadata.resource_version = state.version.to_string();
+        state.leases.insert(k, lease.clone());
+        self.save(&state);
+        Ok(lease)
+    }
+
+    /// Replaces a lease. A resourceVersion in the new lease must match the
+    /// stored one; an empty one overwrites unconditionally.
+    pub fn update(&self, namespace: &str, name: &str, mut lease: Lease) -> Result<Lease, LeaseError> {
+        if !lease.metadata.name.is_empty() && lease.metadata.name != name {
+            return Err(LeaseError::Invalid(format!(
+                "metadata.name {:?} does not match {:?}",
+                lease.metadata.name, name
+            )));
+        }
+        let mut state = self.state.lock().unwrap();
+        let k = key(namespace, name);
+        let Some(current) = state.leases.get(&k) else {
+            return Err(LeaseError::NotFound);
+        };
+        let version = &lease.metadata.resource_version;
+        if !version.is_empty() && *version != current.metadata.resource_version {
+            return Err(LeaseError::Conflict);
+        }
+        let created = current.metadata.creation_timestamp.clone();
+        state.version += 1;
+        lease.type_meta = lease_type();
+        lease.metadata.name = name.to_string();
+        lease.metadata.namespace = namespace.to_string();
+        lease.metadata.creation_timestamp = created;
+        lease.metadata.resource_version = state.version.to_string();
+        state.leases.insert(k, lease.clone());
+        self.save(&state);
+        Ok(lease)
+    }
+
+    pub fn delete(&self, namespace: &str, name: &str) -> Result<Lease, LeaseError> {
+        let mut state = self.state.lock().unwrap();
+        let Some(lease) = state.leases.remove(&key(namespace, name)) else {
+            return Err(LeaseError::NotFound);
+        };
+        state.version += 1;
+        self.save(&state);
+        Ok(lease)
+    }
+
+    fn save(&self, state: &LeaseState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| {
+                    std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string())
+                });
+            if let Err(e) = result {
+                warn!("writing leases {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+fn lease_type() -> TypeMeta {
+    TypeMeta {
+        api_version: "coordination.k8s.io/v1".to_string(),
+        kind: "Lease".to_string(),
+    }
+}
@@ -0,0 +1,219 @@
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::HashSet;
+
+use crate::models::k8s::{Pod, PodSpec};
+
+/// How bad a finding is. Errors refuse the manifest; warnings go through
+/// and come back with the response.
+#[derive(Debug, Clone, Copy, PartialEq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Severity {
+    Error,
+    Warning,
+}
+
+/// One problem with a manifest.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Finding {
+    pub severity: Severity,
+    /// Short rule name, e.g. "latest-tag".
+    pub rule: &'static str,
+    /// Where in the manifest, e.g. spec.containers[0].image.
+    pub path: String,
+    pub message: String,
+}
+
+impl Finding {
+    fn error(rule: &'static str, path: String, message: impl Into<String>) -> Self {
+        Self {
+            severity: Severity::Error,
+            rule,
+            path,
+            message: message.into(),
+        }
+    }
+
+    fn warning(rule: &'static str, path: String, message: impl Into<String>) -> Self {
+        Self {
+            severity: Severity::Warning,
+            rule,
+            path,
+            message: message.into(),
+        }
+    }
+}
+
+/// Whether any finding refuses the manifest.
+pub fn has_errors(findings: &[Finding]) -> bool {
+    findings.iter().any(|f| f.severity == Severity::Error)
+}
+
+/// A finding as a Warning header value, which kubectl prints.
+pub fn warning_header(f: &Finding) -> String {
+    format!("299 - \"{}: {}\"", f.path, f.message.replace('"', "'"))
+}
+
+/// Lints a pod manifest as sent: checks it against the pod schema, flags
+/// fields the console doesn't know (mkube ignores them, probes included),
+/// then lints the spec. Returns the pod when the schema check passed.
+pub fn lint_pod_manifest(raw: &Value) -> (Option<Pod>, Vec<Finding>) {
+    let mut findings = Vec::new();
+    match raw.get("kind").and_then(Value::as_str) {
+        None | Some("Pod") => {}
+        Some(kind) => findings.push(Finding::error(
+            "kind",
+            "kind".to_string(),
+            format!("expected Pod, not {}", kind),
+        )),
+    }
+    match raw.get("apiVersion").and_then(Value::as_str) {
+        None | Some("v1") => {}
+        Some(v) => findings.push(Finding::error(
+            "api-version",
+            "apiVersion".to_string(),
+            format!("expected v1, not {}", v),
+        )),
+    }
+    let pod: Pod = match serde_json::from_value(raw.clone()) {
+        Ok(pod) => pod,
+        Err(e) => {
+            findings.push(Finding::error("schema", String::new(), e.to_string()));
+            return (None, findings);
+        }
+    };
+    // Whatever doesn't survive a round trip through the model is dropped
+    let known = serde_json::to_value(&pod).unwrap_or_default();
+    for key in ["metadata", "spec"] {
+        if let Some(v) = raw.get(key) {
+            unknown_fields(v, known.get(key).unwrap_or(&Value::Null), key, &mut findings);
+        }
+    }
+    findings.extend(lint_pod_spec(&pod.spec, "spec"));
+    (Some(pod), findings)
+}
+
+/// Common mistakes in a pod spec; `path` is where the spec sits, e.g.
+/// spec.template.spec.
+pub fn lint_pod_spec(spec: &PodSpec, path: &str) -> Vec<Finding> {
+    let mut findings = Vec::new();
+    if spec.containers.is_empty() {
+        findings.push(Finding::error(
+            "no-containers",
+            format!("{}.containers", path),
+            "at least one container is required",
+        ));
+    }
+    let mut names = HashSet::new();
+    let mut ports = HashSet::new();
+    for (i, c) in spec.containers.iter().enumerate() {
+        let at = format!("{}.containers[{}]", path, i);
+        if c.name.is_empty() {
+            findings.push(Finding::error(
+                "container-name",
+                format!("{}.name", at),
+                "a name is required",
+            ));
+        } else if !names.insert(c.name.as_str()) {
+            findings.push(Finding::error(
+                "container-name",
+                format!("{}.name", at),
+                format!("{} is used by another container", c.name),
+            ));
+        }
+        findings.extend(lint_image(&c.image, &format!("{}.image", at)));
+        for (j, p) in c.ports.iter().enumerate() {
+            let protocol = if p.protocol.is_empty() {
+                "TCP"
+            } else {
+                p.protocol.as_str()
+            };
+            if !ports.insert((p.container_port, protocol)) {
+                findings.push(Finding::error(
+                    "duplicate-port",
+                    format!("{}.ports[{}]", at, j),
+                    format!("{}/{} is exposed twice", p.container_port, protocol),
+                ));
+            }
+        }
+        let mut env = HashSet::new();
+        for (j, e) in c.env.iter().enumerate() {
+            if !env.insert(e.name.as_str()) {
+                findings.push(Finding::warning(
+                    "duplicate-env",
+                    format!("{}.env[{}]", at, j),
+                    format!("{} is set twice; the last value wins", e.name),
+                ));
+            }
+        }
+        if c.resources.limits.is_empty() {
+            findings.push(Finding::warning(
+                "no-limits",
+                format!("{}.resources.limits", at),
+                "no limits; only the resource defaults, if any, keep it from starving others",
+            ));
+        }
+    }
+    findings
+}
+
+fn lint_image(image: &str, path: &str) -> Vec<Finding> {
+    if image.trim().is_empty() {
+        return vec![Finding::error("image", path.to_string(), "an image is required")];
+    }
+    if image.contains('@') {
+        return Vec::new();
+    }
+    let name = image.rsplit('/').next().unwrap_or(image);
+    match name.split_once(':') {
+        None => vec![Finding::warning(
+            "missing-tag",
+            path.to_string(),
+            format!("{} has no tag, so it means :latest and changes under you", image),
+        )],
+        Some((_, "latest")) => vec![Finding::warning(
+            "latest-tag",
+            path.to_string(),
+            ":latest changes under you; pin a version",
+        )],
+        Some(_) => Vec::new(),
+    }
+}
+
+// Flags non-empty fields of `raw` missing from `known`, the same object
+// after a round trip through the model.
+fn unknown_fields(raw: &Value, known: &Value, path: &str, findings: &mut Vec<Finding>) {
+    match (raw, known) {
+        (Value::Object(fields), _) => {
+            for (k, v) in fields {
+                let at = format!("{}.{}", path, k);
+                match known.get(k) {
+                    Some(kv) => unknown_fields(v, kv, &at, findings),
+                    None if !is_empty(v) => findings.push(Finding::warning(
+                        "unknown-field",
+                        at,
+                        "not supported by mkube; it is ignored",
+                    )),
+                    None => {}
+                }
+            }
+        }
+        (Value::Array(items), Value::Array(known_items)) => {
+            for (i, (v, kv)) in items.iter().zip(known_items).enumerate() {
+                unknown_fields(v, kv, &format!("{}[{}]", path, i), findings);
+            }
+        }
+        _ => {}
+    }
+}
+
+fn is_empty(v: &Value) -> bool {
+    match v {
+        Value::Null => true,
+        Value::String(s) => s.is_empty(),
+        Value::Array(a) => a.is_empty(),
+        Value::Object(o) => o.is_empty(),
+        _ => false,
+    }
+}
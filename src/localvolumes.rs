@@ -0,0 +1,217 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::clients::aggregator::Aggregator;
+use crate::crypto::{self, Sealer};
+use crate::helpers::parse_memory_bytes;
+use crate::models::k8s::{ObjectMeta, PVCSpec, PersistentVolumeClaim, Pod, ResourceRequirements, TypeMeta};
+
+/// Annotation on the claim the console creates on a node, asking it for a
+/// directory on its own disk rather than shared storage.
+pub const NODE_LOCAL_ANNOTATION: &str = "mkube.io/node-local";
+
+/// Node-local directories the console has had nodes provision, and which
+/// node each lives on. A pod mounting one by claim name only runs on that
+/// node, so restarts, migrations and failover never start it away from its
+/// data. Persisted under the data dir when there is one.
+pub struct LocalVolumeStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<LocalVolumeState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct LocalVolumeState {
+    /// Keyed by "namespace/name".
+    volumes: BTreeMap<String, LocalVolume>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct LocalVolume {
+    pub namespace: String,
+    pub name: String,
+    /// The node holding the directory.
+    pub node: String,
+    /// Requested size as a quantity, e.g. 10Gi.
+    pub size: String,
+    pub created_by: String,
+    pub created_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct LocalVolumeRequest {
+    pub namespace: String,
+    pub name: String,
+    pub size: String,
+    /// Where to put it; the scheduler picks when unset.
+    #[serde(default)]
+    pub node: Option<String>,
+}
+
+impl LocalVolumeStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    /// Volumes in `namespace`, or in all namespaces.
+    pub fn list(&self, namespace: Option<&str>) -> Vec<LocalVolume> {
+        self.state
+            .lock()
+            .unwrap()
+            .volumes
+            .values()
+            .filter(|v| namespace.is_none_or(|ns| v.namespace == ns))
+            .cloned()
+            .collect()
+    }
+
+    pub fn get(&self, namespace: &str, name: &str) -> Option<LocalVolume> {
+        self.state.lock().unwrap().volumes.get(&key(namespace, name)).cloned()
+    }
+
+    /// Forgets a volume's binding; the directory stays on its node.
+    pub fn release(&self, namespace: &str, name: &str) -> Option<LocalVolume> {
+        let mut state = self.state.lock().unwrap();
+        let removed = state.volumes.remove(&key(namespace, name))?;
+        self.save(&state);
+        Some(removed)
+    }
+
+    /// Why `node` can't run `pod`: a volume it mounts is bound elsewhere.
+    pub fn rejection(&self, node: &str, pod: &Pod) -> Option<String> {
+        let state = self.state.lock().unwrap();
+        pod_claims(pod)
+            .filter_map(|claim| state.volumes.get(&key(&pod.metadata.namespace, claim)))
+            .find(|v| v.node != node)
+            .map(|v| format!("local volume {} is on {}", v.name, v.node))
+    }
+
+    fn bind(&self, volume: LocalVolume) -> bool {
+        let mut state = self.state.lock().unwrap();
+        let k = key(&volume.namespace, &volume.name);
+        if state.volumes.contains_key(&k) {
+            return false;
+        }
+        state.volumes.insert(k, volume);
+        self.save(&state);
+        true
+    }
+
+    fn save(&self, state: &LocalVolumeState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing local volumes {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+fn key(namespace: &str, name: &str) -> String {
+    format!("{}/{}", namespace, name)
+}
+
+/// Claim names of the pod's persistentVolumeClaim volumes.
+pub fn pod_claims(pod: &Pod) -> impl Iterator<Item = &str> {
+    pod.spec
+        .volumes
+        .iter()
+        .filter_map(|v| v.persistent_volume_claim.as_ref())
+        .map(|c| c.claim_name.as_str())
+}
+
+/// Picks a node for the volume unless the request names one, has that
+/// node create the directory as a node-local claim, then records the
+/// binding. Pods mounting the claim are scheduled onto that node.
+pub async fn provision(
+    aggregator: &Aggregator,
+    store: &LocalVolumeStore,
+    req: LocalVolumeRequest,
+    user: &str,
+) -> Result<LocalVolume, String> {
+    if req.namespace.is_empty() || req.name.is_empty() || req.name.contains('/') {
+        return Err("namespace and a name without slashes are required".to_string());
+    }
+    if parse_memory_bytes(&req.size).is_none_or(|b| b <= 0) {
+        return Err(format!("size {:?} is not a quantity such as 10Gi", req.size));
+    }
+    if store.get(&req.namespace, &req.name).is_some() {
+        return Err(format!("local volume {}/{} already exists", req.namespace, req.name));
+    }
+
+    let node = match req.node {
+        Some(node) => node,
+        // Placed like a pod of the namespace, so pools and namespace pins
+        // apply.
+        None => {
+            let probe = Pod {
+                metadata: ObjectMeta {
+                    namespace: req.namespace.clone(),
+                    name: req.name.clone(),
+                    ..Default::default()
+                },
+                ..Default::default()
+            };
+            aggregator.placement(&probe, &[]).await?
+        }
+    };
+    let client = aggregator
+        .snapshot_clients()
+        .await
+        .into_iter()
+        .find(|c| c.name == node)
+        .ok_or_else(|| format!("node {:?} not found", node))?;
+    if !client.is_healthy() {
+        return Err(format!("node {} is offline", node));
+    }
+
+    let claim = PersistentVolumeClaim {
+        type_meta: TypeMeta {
+            api_version: "v1".to_string(),
+            kind: "PersistentVolumeClaim".to_string(),
+        },
+        metadata: ObjectMeta {
+            namespace: req.namespace.clone(),
+            name: req.name.clone(),
+            annotations: Some(HashMap::from([(NODE_LOCAL_ANNOTATION.to_string(), "true".to_string())])),
+            ..Default::default()
+        },
+        spec: PVCSpec {
+            access_modes: vec!["ReadWriteOnce".to_string()],
+            resources: ResourceRequirements {
+                requests: HashMap::from([("storage".to_string(), req.size.clone())]),
+            },
+        },
+        ..Default::default()
+    };
+    client
+        .create_pvc(&claim)
+        .await
+        .map_err(|e| format!("provisioning on {}: {}", node, e))?;
+
+    let volume = LocalVolume {
+        namespace: req.namespace,
+        name: req.name,
+        node,
+        size: req.size,
+        created_by: user.to_string(),
+        created_at: Utc::now(),
+    };
+    if !store.bind(volume.clone()) {
+        return Err(format!("local volume {}/{} already exists", volume.namespace, volume.name));
+    }
+    Ok(volume)
+}
@@ -1,9 +1,68 @@
+mod accesslog;
+mod activity;
+mod admission;
+mod alertrules;
+mod alerts;
+mod archive;
+mod audit;
+mod bootstrap;
+mod builds;
+mod bundles;
+mod claims;
 mod clients;
+mod commitstatus;
 mod config;
+mod controllers;
+mod cron;
+mod cronjobs;
+mod crypto;
+mod custom;
+mod daemonsets;
+mod deployhooks;
+mod deployments;
+mod desired;
+mod devicesets;
+mod diagnostics;
+mod dns;
+mod explain;
+mod favorites;
+mod filters;
+mod fragments;
 mod helpers;
+mod hooks;
+mod ipam;
+mod jobs;
+mod leader;
+mod leases;
+mod lint;
+mod localvolumes;
+mod metrics;
 mod models;
+mod namespaces;
+mod pools;
+mod push;
+mod readonly;
+mod reporting;
+mod resources;
+mod restarts;
 mod routes;
+mod schedule;
+mod scrub;
+mod secrets;
+mod selector;
+mod selfhost;
+mod settings;
+mod share;
+mod storage;
+mod stuck;
+mod telemetry;
+mod throttle;
+mod tunnel;
+mod update;
+mod volumes;
+mod wake;
 
+use std::net::SocketAddr;
 use std::path::PathBuf;
 use std::sync::Arc;
 
@@ -11,13 +70,112 @@ use tokio::net::TcpListener;
 use tokio::signal;
 use tracing::info;
 
+use accesslog::AccessLog;
+use activity::{ActivityLog, RecentViews};
+use admission::Admission;
+use alertrules::AlertRuleStore;
+use alerts::AlertManager;
+use archive::HistoryArchive;
+use audit::AuditSink;
+use bootstrap::BootstrapTokens;
+use claims::ClaimStore;
 use clients::aggregator::Aggregator;
 use clients::NodeClient;
+use clients::replica::ReplicaCache;
+use clients::tunnel::TunnelHub;
+use commitstatus::CommitStatusReporter;
+use controllers::activity::ActivityWatcher;
+use controllers::alert_rules::AlertRuleEngine;
+use controllers::bandwidth::BandwidthCollector;
+use controllers::builds::BuildDeployer;
+use controllers::config_rollout::ConfigRolloutController;
+use controllers::cronjobs::CronJobController;
+use controllers::daemonsets::MicroDaemonSetController;
+use controllers::deployments::DeploymentController;
+use controllers::devicesets::DeviceSetController;
+use controllers::jobs::JobController;
+use controllers::node_health::NodeHealthWatcher;
+use controllers::pod_failure::PodFailureWatcher;
+use controllers::restart_loop::RestartLoopWatcher;
+use cronjobs::CronJobStore;
+use crypto::Sealer;
+use custom::CustomResourceStore;
+use daemonsets::MicroDaemonSetStore;
+use deployments::DeploymentStore;
+use devicesets::DeviceSetStore;
+use diagnostics::DiagnosticsLog;
+use favorites::FavoritesStore;
+use fragments::{FRAGMENT_TTL, FragmentCache};
+use hooks::EventHooks;
+use jobs::JobStore;
+use leader::LeaderElector;
+use leases::LeaseStore;
+use localvolumes::LocalVolumeStore;
+use metrics::{HttpMetrics, MetricsHistory};
+use namespaces::NamespaceStore;
+use pools::PoolStore;
+use push::PushNotifier;
+use reporting::ErrorReporter;
+use restarts::NodeRestarts;
+use schedule::Schedule;
+use secrets::SecretResolver;
+use selfhost::SelfHost;
+use settings::SettingsStore;
+use share::ShareLinks;
+use storage::StorageCatalog;
+use telemetry::Telemetry;
+use throttle::LoginThrottle;
+use tunnel::TunnelSupervisor;
+use update::Updater;
+use wake::WakeService;
 
 #[derive(Clone)]
 pub struct AppState {
     pub aggregator: Arc<Aggregator>,
     pub config: Arc<config::Config>,
+    pub alerts: Arc<AlertManager>,
+    pub alert_rules: Arc<AlertRuleEngine>,
+    pub alert_rule_store: Arc<AlertRuleStore>,
+    pub settings: Arc<SettingsStore>,
+    pub archive: Arc<HistoryArchive>,
+    pub leader: Arc<LeaderElector>,
+    pub push: Option<Arc<PushNotifier>>,
+    pub claims: Arc<ClaimStore>,
+    pub bootstrap: Arc<BootstrapTokens>,
+    pub tunnels: Arc<TunnelHub>,
+    pub login_throttle: Arc<LoginThrottle>,
+    pub share_links: Arc<ShareLinks>,
+    pub leases: Arc<LeaseStore>,
+    pub custom: Arc<CustomResourceStore>,
+    pub jobs: Arc<JobStore>,
+    pub cron_jobs: Arc<CronJobStore>,
+    pub deployments: Arc<DeploymentStore>,
+    pub device_sets: Arc<DeviceSetStore>,
+    pub daemon_sets: Arc<MicroDaemonSetStore>,
+    pub namespaces: Arc<NamespaceStore>,
+    pub pools: Arc<PoolStore>,
+    pub local_volumes: Arc<LocalVolumeStore>,
+    pub storage: Arc<StorageCatalog>,
+    pub reporter: Option<Arc<ErrorReporter>>,
+    pub commit_status: Option<Arc<CommitStatusReporter>>,
+    pub access_log: Option<Arc<AccessLog>>,
+    pub telemetry: Option<Arc<Telemetry>>,
+    pub favorites: Arc<FavoritesStore>,
+    pub activity: Arc<ActivityLog>,
+    pub recent: Arc<RecentViews>,
+    pub wake: Arc<WakeService>,
+    pub restarts: Arc<NodeRestarts>,
+    pub self_host: Arc<SelfHost>,
+    pub updater: Arc<Updater>,
+    pub config_rollouts: Arc<ConfigRolloutController>,
+    pub metrics: Arc<MetricsHistory>,
+    pub fragments: Arc<FragmentCache>,
+    pub schedule: Arc<Schedule>,
+    pub http_metrics: Arc<HttpMetrics>,
+    pub diagnostics: Arc<DiagnosticsLog>,
+    pub sealer: Arc<Sealer>,
+    pub secrets: Arc<SecretResolver>,
+    pub admission: Arc<Admission>,
 }
 
 #[tokio::main]
@@ -28,6 +186,19 @@ async fn main() {
                 .unwrap_or_else(|_| "mkube_console=info".parse().unwrap()),
         )
         .init();
+    routes::layers::install_panic_hook();
+
+    let args: Vec<String> = std::env::args().collect();
+    match args.get(1).map(String::as_str) {
+        Some("keys") => std::process::exit(crypto::run_cli(&args[2..])),
+        // Also how an update checks a downloaded binary runs at all
+        Some("version") => {
+            println!("{}", update::VERSION);
+            return;
+        }
+        _ => {}
+    }
+    update::check_boot();
 
     let config_path = std::env::args()
         .nth(1)
@@ -42,38 +213,640 @@ async fn main() {
         })
         .unwrap_or_else(|| "/etc/mkube-console/config.yaml".to_string());
 
-    let cfg = config::Config::load(&PathBuf::from(&config_path)).unwrap_or_else(|e| {
+    let mut cfg = config::Config::load(&PathBuf::from(&config_path)).unwrap_or_else(|e| {
         eprintln!("error loading config: {}", e);
         std::process::exit(1);
     });
 
+    let sealer = Arc::new(Sealer::new(cfg.encryption.as_ref()).unwrap_or_else(|e| {
+        eprintln!("error loading encryption key: {}", e);
+        std::process::exit(1);
+    }));
+    for n in &mut cfg.nodes {
+        let Some(ref mut auth) = n.auth else { continue };
+        for secret in [&mut auth.bearer_token, &mut auth.password].into_iter().flatten() {
+            *secret = sealer.open(secret).unwrap_or_else(|e| {
+                eprintln!("node {}: decrypting credentials: {}", n.name, e);
+                std::process::exit(1);
+            });
+        }
+    }
+
+    for h in &mut cfg.deploy_hooks {
+        h.token = sealer.open(&h.token).unwrap_or_else(|e| {
+            eprintln!("deploy hook {}: decrypting token: {}", h.name, e);
+            std::process::exit(1);
+        });
+    }
+
+    if let Some(ref mut c) = cfg.commit_status {
+        c.token = sealer.open(&c.token).unwrap_or_else(|e| {
+            eprintln!("commit_status: decrypting token: {}", e);
+            std::process::exit(1);
+        });
+    }
+
+    for sink in &mut cfg.audit_sinks {
+        let config::AuditSinkConfig::Http(ref mut h) = *sink else { continue };
+        let Some(ref mut token) = h.bearer_token else { continue };
+        *token = sealer.open(token).unwrap_or_else(|e| {
+            eprintln!("audit sink {}: decrypting bearer_token: {}", h.url, e);
+            std::process::exit(1);
+        });
+    }
+
+    if let Some(ref mut f) = cfg.follow {
+        for secret in f.bearer_token.iter_mut().chain(f.headers.values_mut()) {
+            *secret = sealer.open(secret).unwrap_or_else(|e| {
+                eprintln!("follow: decrypting credentials: {}", e);
+                std::process::exit(1);
+            });
+        }
+    }
+
+    if cfg.read_only {
+        readonly::set(true, "config");
+    }
+
     let mut node_clients = Vec::new();
     for n in &cfg.nodes {
-        node_clients.push(NodeClient::new(n.name.clone(), n.address.clone()));
+        node_clients.push(NodeClient::new(n));
     }
+    // Push-mode nodes are reached over their tunnels via a loopback gateway
+    let tunnels = Arc::new(TunnelHub::bind().await.unwrap_or_else(|e| {
+        eprintln!("failed to bind node tunnel gateway: {}", e);
+        std::process::exit(1);
+    }));
+    // Nodes claimed or registered through the console; config.yaml wins on
+    // a name clash
+    let claims = Arc::new(
+        ClaimStore::new(cfg.data_path("claimed_nodes.json"), sealer.clone(), tunnels.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load claimed nodes: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    for c in claims.list() {
+        if cfg.nodes.iter().any(|n| n.name == c.name) {
+            eprintln!("claimed node {} is also in the config; using the config entry", c.name);
+            continue;
+        }
+        node_clients.push(claims.client(&c));
+    }
+    let bootstrap = Arc::new(
+        BootstrapTokens::new(cfg.data_path("bootstrap_tokens.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load bootstrap tokens: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let leases = Arc::new(
+        LeaseStore::new(cfg.data_path("leases.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load leases: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let custom = Arc::new(
+        CustomResourceStore::new(
+            cfg.custom_resources.clone(),
+            cfg.data_path("custom_resources.json"),
+            sealer.clone(),
+        )
+        .unwrap_or_else(|e| {
+            eprintln!("failed to load custom resources: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let jobs = Arc::new(
+        JobStore::new(cfg.data_path("jobs.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load jobs: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let cron_jobs = Arc::new(
+        CronJobStore::new(cfg.data_path("cronjobs.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load cron jobs: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let deployments = Arc::new(
+        DeploymentStore::new(cfg.data_path("deployments.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load deployments: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let device_sets = Arc::new(
+        DeviceSetStore::new(cfg.data_path("devicesets.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load device sets: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let daemon_sets = Arc::new(
+        MicroDaemonSetStore::new(cfg.data_path("daemonsets.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load daemon sets: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let namespaces = Arc::new(
+        NamespaceStore::new(cfg.data_path("namespaces.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load namespaces: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let pools = Arc::new(
+        PoolStore::new(cfg.data_path("pool_cordons.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load pool cordons: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let local_volumes = Arc::new(
+        LocalVolumeStore::new(cfg.data_path("local_volumes.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load local volumes: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let storage = Arc::new(
+        StorageCatalog::new(cfg.data_path("storage_catalog.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load the storage catalog: {}", e);
+            std::process::exit(1);
+        }),
+    );
 
-    if node_clients.is_empty() {
+    if node_clients.is_empty() && cfg.follow.is_none() {
         eprintln!("no nodes configured");
         std::process::exit(1);
     }
 
-    let aggregator = Arc::new(Aggregator::new(node_clients));
+    let replica = cfg
+        .follow
+        .as_ref()
+        .map(|f| {
+            Arc::new(ReplicaCache::new(f).unwrap_or_else(|e| {
+                eprintln!("follow: {}", e);
+                std::process::exit(1);
+            }))
+        });
+    let mut aggregator = match replica {
+        Some(ref r) => Aggregator::follower(node_clients, r.clone()),
+        None => Aggregator::new(node_clients),
+    };
+    if !cfg.registry_url().is_empty() {
+        aggregator = aggregator.with_registry(cfg.registry_url());
+    }
+    aggregator = aggregator.with_strategy(cfg.scheduler.strategy);
+    aggregator = aggregator.with_namespace_placement(cfg.scheduler.namespaces.clone());
+    aggregator = aggregator.with_namespaces(namespaces.clone());
+    aggregator = aggregator.with_pools(pools.clone());
+    aggregator = aggregator.with_local_volumes(local_volumes.clone());
+    let schedule = Arc::new(Schedule::new(cfg.schedule.clone()));
+    aggregator = aggregator.with_schedule(schedule.clone());
+    let aggregator = Arc::new(aggregator);
+    let alerts = Arc::new(AlertManager::new());
+    let archive = Arc::new(HistoryArchive::new(cfg.data_path("archive.jsonl"), sealer.clone()));
+    let favorites = Arc::new(
+        FavoritesStore::new(cfg.data_path("favorites.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load favorites: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let activity = Arc::new(ActivityLog::new(cfg.data_path("activity.jsonl"), sealer.clone()));
+    let recent = Arc::new(RecentViews::new());
+    let wake = Arc::new(WakeService::new(&cfg.nodes));
+    let self_host = Arc::new(SelfHost::new(cfg.self_pod.clone()));
+    let restarts = Arc::new(NodeRestarts::new(aggregator.clone(), activity.clone(), self_host.clone()));
+    let updater = Arc::new(Updater::new(cfg.update.clone(), self_host.clone()));
+    let metrics = Arc::new(MetricsHistory::new());
+    let http_metrics = Arc::new(HttpMetrics::new());
+    let diagnostics = Arc::new(DiagnosticsLog::new(cfg.data_path("diagnostics.jsonl"), sealer.clone()));
+    let secrets = Arc::new(SecretResolver::new(cfg.secret_providers.clone()));
+    let leader = Arc::new(match cfg.follow {
+        Some(ref f) => LeaderElector::follower(f.upstream_url.clone()),
+        None => LeaderElector::new(cfg.ha.clone()),
+    });
     let cfg = Arc::new(cfg);
+    let admission = Arc::new(Admission::new(cfg.clone(), secrets.clone()));
 
     // Shutdown signal
     let (shutdown_tx, shutdown_rx) = tokio::sync::watch::channel(());
 
-    // Start health checker
-    let agg_clone = aggregator.clone();
+    // Followers replicate from their upstream console; everyone else polls nodes
+    if let Some(r) = replica {
+        let replica_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            r.run(replica_shutdown).await;
+        });
+    } else {
+        let agg_clone = aggregator.clone();
+        let health_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            agg_clone.run_health_checker(health_shutdown).await;
+        });
+        let event_source = aggregator.clone();
+        let events_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            event_source.run_event_source(events_shutdown).await;
+        });
+    }
+
+    // Start leader election (no-op without an ha section)
+    let leader_clone = leader.clone();
+    let leader_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        leader_clone.run(leader_shutdown).await;
+    });
+
+    // Start node liveness / flapping watcher
+    let node_watcher = Arc::new(NodeHealthWatcher::new(
+        aggregator.clone(),
+        alerts.clone(),
+        cfg.node_health.clone(),
+        leader.clone(),
+    ));
+    let node_watcher_clone = node_watcher.clone();
+    let node_watcher_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        node_watcher_clone.run(node_watcher_shutdown).await;
+    });
+
+    // Start failed pod alerting
+    let pod_watcher = Arc::new(PodFailureWatcher::new(
+        aggregator.clone(),
+        alerts.clone(),
+        leader.clone(),
+    ));
+    let pod_watcher_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        pod_watcher.run(pod_watcher_shutdown).await;
+    });
+
+    // Start activity feed watcher
+    let activity_watcher = Arc::new(ActivityWatcher::new(
+        aggregator.clone(),
+        activity.clone(),
+        leader.clone(),
+    ));
+    let activity_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        activity_watcher.run(activity_shutdown).await;
+    });
+
+    // Run jobs' pods to completion
+    let job_controller = Arc::new(JobController::new(
+        aggregator.clone(),
+        jobs.clone(),
+        admission.clone(),
+        activity.clone(),
+        leader.clone(),
+    ));
+    let jobs_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        job_controller.run(jobs_shutdown).await;
+    });
+
+    // Start jobs from cron jobs as their schedules come due
+    let cron_job_controller = Arc::new(CronJobController::new(
+        aggregator.clone(),
+        cron_jobs.clone(),
+        jobs.clone(),
+        activity.clone(),
+        leader.clone(),
+    ));
+    let cron_jobs_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        cron_job_controller.run(cron_jobs_shutdown).await;
+    });
+
+    // Keep console-managed deployments at their replica counts
+    let deployment_controller = Arc::new(DeploymentController::new(
+        aggregator.clone(),
+        deployments.clone(),
+        admission.clone(),
+        activity.clone(),
+        leader.clone(),
+        node_watcher.clone(),
+    ));
+    let deployments_shutdown = shutdown_rx.clone();
     tokio::spawn(async move {
-        agg_clone.run_health_checker(shutdown_rx).await;
+        deployment_controller.run(deployments_shutdown).await;
     });
 
+    // Roll deployments onto the images their builds push
+    let build_deployer = Arc::new(BuildDeployer::new(
+        jobs.clone(),
+        deployments.clone(),
+        activity.clone(),
+        leader.clone(),
+    ));
+    let builds_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        build_deployer.run(builds_shutdown).await;
+    });
+
+    // Keep device sets' pods on the nodes advertising their device class
+    let device_set_controller = Arc::new(DeviceSetController::new(
+        aggregator.clone(),
+        device_sets.clone(),
+        admission.clone(),
+        activity.clone(),
+        leader.clone(),
+    ));
+    let device_sets_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        device_set_controller.run(device_sets_shutdown).await;
+    });
+
+    // Keep daemon sets' pods on every node their selectors match
+    let daemon_set_controller = Arc::new(MicroDaemonSetController::new(
+        aggregator.clone(),
+        daemon_sets.clone(),
+        admission.clone(),
+        activity.clone(),
+        leader.clone(),
+    ));
+    let daemon_sets_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        daemon_set_controller.run(daemon_sets_shutdown).await;
+    });
+
+    // Drop cached page fragments when the cluster changes
+    let fragments = Arc::new(FragmentCache::new(FRAGMENT_TTL, schedule.clone()));
+    let fragments_invalidator = fragments.clone();
+    let fragments_activity = activity.clone();
+    let fragments_alerts = alerts.clone();
+    let fragments_aggregator = aggregator.clone();
+    let fragments_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        fragments_invalidator
+            .run(fragments_activity, fragments_alerts, fragments_aggregator, fragments_shutdown)
+            .await;
+    });
+
+    // Forward pod and node events to webhooks, including any applied
+    // through the console later
+    let settings = Arc::new(
+        SettingsStore::new(cfg.data_path("settings.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load settings: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let hooks = Arc::new(EventHooks::new(
+        cfg.event_hooks.clone(),
+        settings.clone(),
+        cfg.cluster_name.clone(),
+        leader.clone(),
+    ));
+    let hooks_aggregator = aggregator.clone();
+    let hooks_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        hooks.run(hooks_aggregator, hooks_shutdown).await;
+    });
+
+    // Start network counter collection; followers have no nodes to poll
+    if cfg.follow.is_none() {
+        let collector = Arc::new(BandwidthCollector::new(
+            aggregator.clone(),
+            metrics.clone(),
+            alerts.clone(),
+            cfg.bandwidth.clone(),
+            leader.clone(),
+        ));
+        let collector_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            collector.run(collector_shutdown).await;
+        });
+    }
+
+    // Evaluate the config's alert rules, picking up edits to the file, and
+    // those created through the API
+    let alert_rule_store = Arc::new(
+        AlertRuleStore::new(cfg.data_path("alert_rules.json"), sealer.clone()).unwrap_or_else(|e| {
+            eprintln!("failed to load alert rules: {}", e);
+            std::process::exit(1);
+        }),
+    );
+    let alert_rules = Arc::new(AlertRuleEngine::new(
+        aggregator.clone(),
+        alert_rule_store.clone(),
+        metrics.clone(),
+        alerts.clone(),
+        leader.clone(),
+        PathBuf::from(&config_path),
+        &cfg.alert_rules,
+        cfg.bandwidth.link_mbps,
+    ));
+    let alert_rules_clone = alert_rules.clone();
+    let alert_rules_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        alert_rules_clone.run(alert_rules_shutdown).await;
+    });
+
+    // Restart opted-in pods when their configmaps or secrets change
+    let config_rollouts = Arc::new(ConfigRolloutController::new(
+        aggregator.clone(),
+        secrets.clone(),
+        activity.clone(),
+        leader.clone(),
+        cfg.config_rollout.clone(),
+    ));
+    let rollout_controller = config_rollouts.clone();
+    let rollout_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        rollout_controller.run(rollout_shutdown).await;
+    });
+
+    // Key for signing share links
+    let share_key = match cfg.share_links.key_file {
+        Some(ref f) => Some(PathBuf::from(f)),
+        None => cfg.data_path("share.key"),
+    };
+    let share_links = Arc::new(
+        ShareLinks::new(share_key, &sealer, cfg.share_links.max_hours).unwrap_or_else(|e| {
+            eprintln!("error loading share link key: {}", e);
+            std::process::exit(1);
+        }),
+    );
+
+    // Lock out clients that keep presenting bad node tokens
+    let login_throttle = Arc::new(LoginThrottle::new(alerts.clone()));
+    let throttle_sweeper = login_throttle.clone();
+    let throttle_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        throttle_sweeper.run(throttle_shutdown).await;
+    });
+
+    // Keep a freshly updated binary once it has stayed up
+    let update_confirm = updater.clone();
+    let update_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        update_confirm.confirm(update_shutdown).await;
+    });
+
+    // Look for new releases to mention in the UI
+    let update_checker = updater.clone();
+    let update_check_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        update_checker.run(update_check_shutdown).await;
+    });
+
+    // Start restart loop capture
+    if cfg.restart_capture.enabled {
+        let watcher = Arc::new(RestartLoopWatcher::new(
+            aggregator.clone(),
+            alerts.clone(),
+            archive.clone(),
+            cfg.restart_capture.clone(),
+            leader.clone(),
+        ));
+        let watcher_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            watcher.run(watcher_shutdown).await;
+        });
+    }
+
+    // Start Web Push notifications for firing alerts
+    let push = cfg.push.as_ref().and_then(|p| {
+        let key_path = match p.vapid_key_file {
+            Some(ref f) => Some(PathBuf::from(f)),
+            None => cfg.data_path("vapid.pk8"),
+        };
+        let Some(key_path) = key_path else {
+            eprintln!("push: vapid_key_file or data_dir is required");
+            return None;
+        };
+        match PushNotifier::new(
+            key_path,
+            p.subject.clone(),
+            cfg.data_path("push_subscriptions.json"),
+            sealer.clone(),
+        ) {
+            Ok(n) => Some(Arc::new(n)),
+            Err(e) => {
+                eprintln!("push disabled: {}", e);
+                None
+            }
+        }
+    });
+    if let Some(ref n) = push {
+        let notifier = n.clone();
+        let push_alerts = alerts.clone();
+        let push_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            notifier.run(push_alerts, push_shutdown).await;
+        });
+    }
+
+    // Serve the node tunnel gateway
+    let gateway = tunnels.clone();
+    let gateway_shutdown = shutdown_rx.clone();
+    tokio::spawn(async move {
+        gateway.run(gateway_shutdown).await;
+    });
+
+    // Start remote access tunnel
+    if let Some(ref t) = cfg.tunnel {
+        let supervisor = TunnelSupervisor::new(t.clone(), cfg.listen_port);
+        let tunnel_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            supervisor.run(tunnel_shutdown).await;
+        });
+    }
+
+    let reporter = cfg.error_reporting.as_ref().and_then(|r| match ErrorReporter::new(r) {
+        Ok(r) => Some(Arc::new(r)),
+        Err(e) => {
+            eprintln!("error reporting disabled: {}", e);
+            None
+        }
+    });
+
+    let commit_status = cfg
+        .commit_status
+        .as_ref()
+        .map(|c| Arc::new(CommitStatusReporter::new(c, &cfg.cluster_name)));
+
+    let access_log = cfg.access_log.as_ref().and_then(|a| match AccessLog::new(a) {
+        Ok(l) => Some(Arc::new(l)),
+        Err(e) => {
+            eprintln!("access log disabled: {}", e);
+            None
+        }
+    });
+
+    // Ship activity records to the audit sinks
+    for sink in &cfg.audit_sinks {
+        let sink = Arc::new(AuditSink::new(sink.clone(), cfg.cluster_name.clone()));
+        let sink_activity = activity.clone();
+        let sink_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            sink.run(sink_activity, sink_shutdown).await;
+        });
+    }
+
+    let telemetry = cfg
+        .telemetry
+        .as_ref()
+        .map(|t| {
+            Arc::new(Telemetry::new(t.clone(), cfg.data_path("telemetry.json"), sealer.clone()).unwrap_or_else(|e| {
+                eprintln!("failed to load telemetry state: {}", e);
+                std::process::exit(1);
+            }))
+        });
+
     let state = AppState {
         aggregator,
         config: cfg.clone(),
+        alerts,
+        alert_rules,
+        alert_rule_store,
+        settings,
+        archive,
+        leader,
+        push,
+        claims,
+        bootstrap,
+        tunnels,
+        login_throttle,
+        share_links,
+        leases,
+        custom,
+        jobs,
+        cron_jobs,
+        deployments,
+        device_sets,
+        daemon_sets,
+        namespaces,
+        pools,
+        local_volumes,
+        storage,
+        reporter,
+        commit_status,
+        access_log,
+        telemetry: telemetry.clone(),
+        favorites,
+        activity,
+        recent,
+        wake,
+        restarts,
+        self_host,
+        updater: updater.clone(),
+        config_rollouts,
+        metrics,
+        fragments,
+        schedule,
+        http_metrics,
+        diagnostics,
+        sealer,
+        secrets,
+        admission,
     };
 
+    // Send anonymized usage reports, when opted in
+    if let Some(t) = telemetry {
+        let telemetry_state = state.clone();
+        let telemetry_shutdown = shutdown_rx.clone();
+        tokio::spawn(async move {
+            t.run(telemetry_state, telemetry_shutdown).await;
+        });
+    }
+
     let router = routes::build_router(state);
 
     let listen_addr = cfg.listen_addr();
@@ -84,9 +857,14 @@ async fn main() {
 
     info!("mkube-console listening on {}", listen_addr);
 
-    axum::serve(listener, router)
+    // Connect info gives push-mode tunnels their remote address
+    let restart_updater = updater.clone();
+    axum::serve(listener, router.into_make_service_with_connect_info::<SocketAddr>())
         .with_graceful_shutdown(async move {
-            shutdown_signal().await;
+            tokio::select! {
+                _ = shutdown_signal() => {}
+                _ = restart_updater.restart_requested() => {}
+            }
             let _ = shutdown_tx.send(());
         })
         .await
@@ -94,6 +872,10 @@ async fn main() {
             eprintln!("server error: {}", e);
             std::process::exit(1);
         });
+
+    // An installed update takes over with the same arguments, so the same
+    // config and data dir
+    updater.restart_if_pending();
 }
 
 async fn shutdown_signal() {
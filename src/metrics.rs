@@ -0,0 +1,301 @@
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::collections::{BTreeMap, HashMap, VecDeque};
+use std::fmt::Write;
+use std::sync::Mutex;
+
+use crate::models::k8s::NetworkStats;
+
+/// Samples kept per node; one hour at the 15s collection interval.
+const METRICS_HISTORY_LEN: usize = 240;
+
+/// Node-wide throughput over one collection interval, in bytes per second.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct BandwidthSample {
+    pub at: DateTime<Utc>,
+    pub rx_rate: f64,
+    pub tx_rate: f64,
+}
+
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct InterfaceRate {
+    pub name: String,
+    pub rx_rate: f64,
+    pub tx_rate: f64,
+    pub speed_mbps: Option<u64>,
+}
+
+impl InterfaceRate {
+    /// Utilisation of the busier direction, in percent of link speed. None
+    /// when neither the interface nor the fallback gives a speed.
+    pub fn utilisation(&self, fallback_mbps: Option<u64>) -> Option<f64> {
+        let mbps = self.speed_mbps.or(fallback_mbps).filter(|s| *s > 0)?;
+        let bits = self.rx_rate.max(self.tx_rate) * 8.0;
+        Some(bits * 100.0 / (mbps as f64 * 1_000_000.0))
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PodRate {
+    pub namespace: String,
+    pub name: String,
+    pub node: String,
+    pub rx_rate: f64,
+    pub tx_rate: f64,
+}
+
+/// Rates derived from node counters. Counters are cumulative, so each node's
+/// previous reading is kept to turn the next one into a rate.
+pub struct MetricsHistory {
+    state: Mutex<HashMap<String, NodeMetrics>>,
+}
+
+#[derive(Default)]
+struct NodeMetrics {
+    last: Option<(DateTime<Utc>, NetworkStats)>,
+    history: VecDeque<BandwidthSample>,
+    interfaces: Vec<InterfaceRate>,
+    pods: Vec<PodRate>,
+}
+
+impl MetricsHistory {
+    pub fn new() -> Self {
+        Self {
+            state: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Records a counter reading for a node. The first reading only seeds
+    /// the counters; rates start with the second.
+    pub fn record_network(&self, node: &str, stats: NetworkStats) {
+        let now = Utc::now();
+        let mut state = self.state.lock().unwrap();
+        let m = state.entry(node.to_string()).or_default();
+
+        if let Some((at, ref prev)) = m.last {
+            let secs = (now - at).num_milliseconds() as f64 / 1000.0;
+
+            m.interfaces = stats
+                .interfaces
+                .iter()
+                .filter(|i| i.name != "lo")
+                .map(|i| {
+                    let old = prev.interfaces.iter().find(|p| p.name == i.name);
+                    InterfaceRate {
+                        name: i.name.clone(),
+                        rx_rate: rate(old.map(|p| p.rx_bytes), i.rx_bytes, secs),
+                        tx_rate: rate(old.map(|p| p.tx_bytes), i.tx_bytes, secs),
+                        speed_mbps: i.speed_mbps,
+                    }
+                })
+                .collect();
+
+            m.pods = stats
+                .pods
+                .iter()
+                .map(|p| {
+                    let old = prev
+                        .pods
+                        .iter()
+                        .find(|o| o.namespace == p.namespace && o.name == p.name);
+                    PodRate {
+                        namespace: p.namespace.clone(),
+                        name: p.name.clone(),
+                        node: node.to_string(),
+                        rx_rate: rate(old.map(|o| o.rx_bytes), p.rx_bytes, secs),
+                        tx_rate: rate(old.map(|o| o.tx_bytes), p.tx_bytes, secs),
+                    }
+                })
+                .collect();
+
+            m.history.push_back(BandwidthSample {
+                at: now,
+                rx_rate: m.interfaces.iter().map(|i| i.rx_rate).sum(),
+                tx_rate: m.interfaces.iter().map(|i| i.tx_rate).sum(),
+            });
+            while m.history.len() > METRICS_HISTORY_LEN {
+                m.history.pop_front();
+            }
+        }
+
+        m.last = Some((now, stats));
+    }
+
+    pub fn bandwidth_history(&self, node: &str) -> Vec<BandwidthSample> {
+        self.state
+            .lock()
+            .unwrap()
+            .get(node)
+            .map(|m| m.history.iter().cloned().collect())
+            .unwrap_or_default()
+    }
+
+    pub fn interface_rates(&self, node: &str) -> Vec<InterfaceRate> {
+        self.state
+            .lock()
+            .unwrap()
+            .get(node)
+            .map(|m| m.interfaces.clone())
+            .unwrap_or_default()
+    }
+
+    /// Latest interface rates of every node, as (node, interface) pairs.
+    pub fn all_interface_rates(&self) -> Vec<(String, InterfaceRate)> {
+        let state = self.state.lock().unwrap();
+        state
+            .iter()
+            .flat_map(|(node, m)| m.interfaces.iter().map(move |i| (node.clone(), i.clone())))
+            .collect()
+    }
+
+    pub fn pod_rates(&self, node: &str) -> Vec<PodRate> {
+        self.state
+            .lock()
+            .unwrap()
+            .get(node)
+            .map(|m| m.pods.clone())
+            .unwrap_or_default()
+    }
+
+    /// Pods moving the most traffic (RX + TX) across all nodes right now.
+    pub fn top_talkers(&self, limit: usize) -> Vec<PodRate> {
+        let state = self.state.lock().unwrap();
+        let mut pods: Vec<PodRate> = state
+            .values()
+            .flat_map(|m| m.pods.iter().cloned())
+            .filter(|p| p.rx_rate + p.tx_rate > 0.0)
+            .collect();
+        pods.sort_by(|a, b| (b.rx_rate + b.tx_rate).total_cmp(&(a.rx_rate + a.tx_rate)));
+        pods.truncate(limit);
+        pods
+    }
+
+    /// Drops a node's data, e.g. when it stops answering, so stale rates
+    /// don't linger in top talkers or keep alerts firing.
+    pub fn clear_rates(&self, node: &str) {
+        if let Some(m) = self.state.lock().unwrap().get_mut(node) {
+            m.last = None;
+            m.interfaces.clear();
+            m.pods.clear();
+        }
+    }
+}
+
+/// Counters for the console's own HTTP server, served at /metrics in the
+/// Prometheus text format. Requests are labelled by route pattern rather
+/// than path so the series stay bounded.
+pub struct HttpMetrics {
+    state: Mutex<HttpCounters>,
+}
+
+#[derive(Default)]
+struct HttpCounters {
+    /// (method, route, status) -> (requests, total seconds)
+    requests: BTreeMap<(String, String, u16), (u64, f64)>,
+    /// route -> (lists served, bytes before compression, lists truncated)
+    lists: BTreeMap<String, (u64, u64, u64)>,
+    panics: u64,
+}
+
+impl HttpMetrics {
+    pub fn new() -> Self {
+        Self {
+            state: Mutex::new(HttpCounters::default()),
+        }
+    }
+
+    pub fn observe(&self, method: &str, route: &str, status: u16, secs: f64) {
+        let mut state = self.state.lock().unwrap();
+        let e = state
+            .requests
+            .entry((method.to_string(), route.to_string(), status))
+            .or_default();
+        e.0 += 1;
+        e.1 += secs;
+    }
+
+    /// Size of a list response, and whether it was cut at the size cap.
+    pub fn observe_list(&self, route: &str, bytes: usize, truncated: bool) {
+        let mut state = self.state.lock().unwrap();
+        let e = state.lists.entry(route.to_string()).or_default();
+        e.0 += 1;
+        e.1 += bytes as u64;
+        if truncated {
+            e.2 += 1;
+        }
+    }
+
+    pub fn record_panic(&self) {
+        self.state.lock().unwrap().panics += 1;
+    }
+
+    /// Requests per route pattern, non-2xx/3xx responses per status code,
+    /// and panics, since the console started. Route patterns carry no
+    /// names, so this is safe to share.
+    pub fn usage(&self) -> (BTreeMap<String, u64>, BTreeMap<String, u64>, u64) {
+        let state = self.state.lock().unwrap();
+        let mut routes: BTreeMap<String, u64> = BTreeMap::new();
+        let mut errors: BTreeMap<String, u64> = BTreeMap::new();
+        for ((_, route, status), (n, _)) in &state.requests {
+            *routes.entry(route.clone()).or_default() += n;
+            if *status >= 400 {
+                *errors.entry(status.to_string()).or_default() += n;
+            }
+        }
+        (routes, errors, state.panics)
+    }
+
+    pub fn render(&self) -> String {
+        let state = self.state.lock().unwrap();
+        let mut out = String::new();
+        out.push_str("# HELP mkube_console_http_requests_total HTTP requests served.\n");
+        out.push_str("# TYPE mkube_console_http_requests_total counter\n");
+        for ((method, route, status), (n, _)) in &state.requests {
+            let _ = writeln!(
+                out,
+                "mkube_console_http_requests_total{{method=\"{}\",route=\"{}\",status=\"{}\"}} {}",
+                method, route, status, n
+            );
+        }
+        out.push_str("# HELP mkube_console_http_request_seconds_total Time spent serving HTTP requests.\n");
+        out.push_str("# TYPE mkube_console_http_request_seconds_total counter\n");
+        for ((method, route, status), (_, secs)) in &state.requests {
+            let _ = writeln!(
+                out,
+                "mkube_console_http_request_seconds_total{{method=\"{}\",route=\"{}\",status=\"{}\"}} {:.6}",
+                method, route, status, secs
+            );
+        }
+        out.push_str("# HELP mkube_console_list_responses_total List responses served.\n");
+        out.push_str("# TYPE mkube_console_list_responses_total counter\n");
+        for (route, (n, _, _)) in &state.lists {
+            let _ = writeln!(out, "mkube_console_list_responses_total{{route=\"{}\"}} {}", route, n);
+        }
+        out.push_str("# HELP mkube_console_list_response_bytes_total Bytes of list responses before compression.\n");
+        out.push_str("# TYPE mkube_console_list_response_bytes_total counter\n");
+        for (route, (_, bytes, _)) in &state.lists {
+            let _ = writeln!(out, "mkube_console_list_response_bytes_total{{route=\"{}\"}} {}", route, bytes);
+        }
+        out.push_str("# HELP mkube_console_list_truncations_total List responses cut short at api.max_list_bytes.\n");
+        out.push_str("# TYPE mkube_console_list_truncations_total counter\n");
+        for (route, (_, _, truncated)) in &state.lists {
+            let _ = writeln!(out, "mkube_console_list_truncations_total{{route=\"{}\"}} {}", route, truncated);
+        }
+        out.push_str("# HELP mkube_console_http_panics_total Request handlers that panicked.\n");
+        out.push_str("# TYPE mkube_console_http_panics_total counter\n");
+        let _ = writeln!(out, "mkube_console_http_panics_total {}", state.panics);
+        out
+    }
+}
+
+// Bytes per second between two counter readings. A counter that is new or
+// went backwards (interface reset, pod restart) yields no rate this round.
+fn rate(prev: Option<u64>, cur: u64, secs: f64) -> f64 {
+    match prev {
+        Some(p) if cur >= p && secs > 0.0 => (cur - p) as f64 / secs,
+        _ => 0.0,
+    }
+}
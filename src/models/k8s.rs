@@ -25,6 +25,18 @@ pub struct ObjectMeta {
     pub annotations: Option<HashMap<String, String>>,
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub creation_timestamp: Option<String>,
+    /// Set once deletion was requested, while the object is terminating.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deletion_timestamp: Option<String>,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub resource_version: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ListMeta {
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub resource_version: String,
 }
 
 // --- Pod ---
@@ -51,6 +63,9 @@ pub struct PodSpec {
     pub containers: Vec<Container>,
     #[serde(default)]
     pub volumes: Vec<Volume>,
+    /// Always (the default), OnFailure or Never; job pods run with Never.
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub restart_policy: String,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -60,8 +75,53 @@ pub struct Container {
     pub name: String,
     #[serde(default)]
     pub image: String,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub command: Vec<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub args: Vec<String>,
     #[serde(default)]
     pub volume_mounts: Vec<VolumeMount>,
+    #[serde(default)]
+    pub resources: ContainerResources,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub ports: Vec<ContainerPort>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub env: Vec<EnvVar>,
+    /// configMapRef/secretRef sources, passed through as-is.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub env_from: Vec<serde_json::Value>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct EnvVar {
+    pub name: String,
+    #[serde(default)]
+    pub value: String,
+    /// secretKeyRef/configMapKeyRef/fieldRef sources, passed through as-is.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub value_from: Option<serde_json::Value>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ContainerPort {
+    #[serde(default)]
+    pub name: String,
+    #[serde(default)]
+    pub container_port: u16,
+    /// TCP (the default), UDP or SCTP.
+    #[serde(default)]
+    pub protocol: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ContainerResources {
+    #[serde(default)]
+    pub requests: HashMap<String, String>,
+    #[serde(default)]
+    pub limits: HashMap<String, String>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -69,6 +129,63 @@ pub struct Container {
 pub struct Volume {
     #[serde(default)]
     pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub host_path: Option<HostPathVolumeSource>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub persistent_volume_claim: Option<PVCVolumeSource>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub nfs: Option<NFSVolumeSource>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub csi: Option<CSIVolumeSource>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub config_map: Option<ConfigMapVolumeSource>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct HostPathVolumeSource {
+    #[serde(default)]
+    pub path: String,
+    #[serde(default, rename = "type", skip_serializing_if = "String::is_empty")]
+    pub path_type: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct PVCVolumeSource {
+    #[serde(default)]
+    pub claim_name: String,
+    #[serde(default)]
+    pub read_only: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ConfigMapVolumeSource {
+    #[serde(default)]
+    pub name: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct NFSVolumeSource {
+    #[serde(default)]
+    pub server: String,
+    #[serde(default)]
+    pub path: String,
+    #[serde(default)]
+    pub read_only: bool,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct CSIVolumeSource {
+    #[serde(default)]
+    pub driver: String,
+    #[serde(default)]
+    pub read_only: bool,
+    #[serde(default)]
+    pub volume_attributes: HashMap<String, String>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -78,6 +195,8 @@ pub struct VolumeMount {
     pub name: String,
     #[serde(default)]
     pub mount_path: String,
+    #[serde(default)]
+    pub read_only: bool,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -105,7 +224,11 @@ pub struct ContainerStatus {
     #[serde(default)]
     pub ready: bool,
     #[serde(default)]
+    pub restart_count: i32,
+    #[serde(default)]
     pub state: ContainerState,
+    #[serde(default)]
+    pub last_state: ContainerState,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -134,11 +257,18 @@ pub struct ContainerStateWaiting {
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
 pub struct ContainerStateTerminated {
     #[serde(default)]
     pub reason: String,
     #[serde(default)]
     pub exit_code: i32,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub message: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub started_at: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub finished_at: Option<String>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize)]
@@ -146,6 +276,8 @@ pub struct ContainerStateTerminated {
 pub struct PodList {
     #[serde(flatten)]
     pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ListMeta,
     pub items: Vec<Pod>,
 }
 
@@ -156,6 +288,7 @@ impl Default for PodList {
                 api_version: "v1".to_string(),
                 kind: "PodList".to_string(),
             },
+            metadata: ListMeta::default(),
             items: Vec::new(),
         }
     }
@@ -185,6 +318,18 @@ pub struct NodeStatus {
     pub allocatable: HashMap<String, String>,
     #[serde(default)]
     pub node_info: NodeSystemInfo,
+    #[serde(default)]
+    pub addresses: Vec<NodeAddress>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct NodeAddress {
+    /// InternalIP, ExternalIP or Hostname.
+    #[serde(default, rename = "type")]
+    pub address_type: String,
+    #[serde(default)]
+    pub address: String,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -210,6 +355,8 @@ pub struct NodeSystemInfo {
 pub struct NodeList {
     #[serde(flatten)]
     pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ListMeta,
     pub items: Vec<Node>,
 }
 
@@ -220,6 +367,223 @@ impl Default for NodeList {
                 api_version: "v1".to_string(),
                 kind: "NodeList".to_string(),
             },
+            metadata: ListMeta::default(),
+            items: Vec::new(),
+        }
+    }
+}
+
+// --- Secret ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Secret {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default, rename = "type", skip_serializing_if = "String::is_empty")]
+    pub secret_type: String,
+    /// Values, base64-encoded.
+    #[serde(default)]
+    pub data: HashMap<String, String>,
+    /// Plain-text values, merged into data on create.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub string_data: HashMap<String, String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct SecretList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    pub items: Vec<Secret>,
+}
+
+impl Default for SecretList {
+    fn default() -> Self {
+        Self {
+            type_meta: TypeMeta {
+                api_version: "v1".to_string(),
+                kind: "SecretList".to_string(),
+            },
+            items: Vec::new(),
+        }
+    }
+}
+
+// --- Service ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Service {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub spec: ServiceSpec,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ServiceSpec {
+    /// ClusterIP (the default), NodePort or LoadBalancer.
+    #[serde(default, rename = "type", skip_serializing_if = "String::is_empty")]
+    pub service_type: String,
+    #[serde(default, rename = "clusterIP", skip_serializing_if = "String::is_empty")]
+    pub cluster_ip: String,
+    #[serde(default)]
+    pub selector: HashMap<String, String>,
+    #[serde(default)]
+    pub ports: Vec<ServicePort>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ServicePort {
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub name: String,
+    #[serde(default)]
+    pub protocol: String,
+    pub port: u16,
+    /// A port number or a container port name.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub target_port: Option<serde_json::Value>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub node_port: Option<u16>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ServiceList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    pub items: Vec<Service>,
+}
+
+impl Default for ServiceList {
+    fn default() -> Self {
+        Self {
+            type_meta: TypeMeta {
+                api_version: "v1".to_string(),
+                kind: "ServiceList".to_string(),
+            },
+            items: Vec::new(),
+        }
+    }
+}
+
+// --- Endpoints ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Endpoints {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub subsets: Vec<EndpointSubset>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct EndpointSubset {
+    #[serde(default)]
+    pub addresses: Vec<EndpointAddress>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub not_ready_addresses: Vec<EndpointAddress>,
+    #[serde(default)]
+    pub ports: Vec<EndpointPort>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct EndpointAddress {
+    pub ip: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub node_name: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub target_ref: Option<ObjectReference>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default, PartialEq)]
+#[serde(rename_all = "camelCase")]
+pub struct EndpointPort {
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub name: String,
+    pub port: u16,
+    #[serde(default)]
+    pub protocol: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ObjectReference {
+    #[serde(default)]
+    pub kind: String,
+    #[serde(default)]
+    pub namespace: String,
+    #[serde(default)]
+    pub name: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct EndpointsList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    pub items: Vec<Endpoints>,
+}
+
+impl Default for EndpointsList {
+    fn default() -> Self {
+        Self {
+            type_meta: TypeMeta {
+                api_version: "v1".to_string(),
+                kind: "EndpointsList".to_string(),
+            },
+            items: Vec::new(),
+        }
+    }
+}
+
+// --- Namespace ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Namespace {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub status: NamespaceStatus,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct NamespaceStatus {
+    #[serde(default)]
+    pub phase: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct NamespaceList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    pub items: Vec<Namespace>,
+}
+
+impl Default for NamespaceList {
+    fn default() -> Self {
+        Self {
+            type_meta: TypeMeta {
+                api_version: "v1".to_string(),
+                kind: "NamespaceList".to_string(),
+            },
             items: Vec::new(),
         }
     }
@@ -299,7 +663,42 @@ pub struct DeploymentSpec {
     #[serde(default)]
     pub replicas: i32,
     #[serde(default)]
-    pub template: serde_json::Value,
+    pub template: PodTemplateSpec,
+    /// How console-managed deployments replace their pods when the
+    /// template changes.
+    #[serde(default)]
+    pub strategy: DeploymentStrategy,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeploymentStrategy {
+    /// RollingUpdate (the default) or Recreate.
+    #[serde(rename = "type", default = "default_strategy_type")]
+    pub type_field: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rolling_update: Option<RollingUpdateDeployment>,
+}
+
+impl Default for DeploymentStrategy {
+    fn default() -> Self {
+        Self {
+            type_field: default_strategy_type(),
+            rolling_update: None,
+        }
+    }
+}
+
+/// Each a pod count or a percentage of the replicas, e.g. 1 or "25%".
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct RollingUpdateDeployment {
+    /// Pods above the replica count while rolling; defaults to 25%.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_surge: Option<serde_json::Value>,
+    /// Replicas that may be unavailable while rolling; defaults to 25%.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_unavailable: Option<serde_json::Value>,
 }
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -309,6 +708,9 @@ pub struct DeploymentStatus {
     pub replicas: i32,
     #[serde(default)]
     pub ready_replicas: i32,
+    /// Pods running the current template.
+    #[serde(default)]
+    pub updated_replicas: i32,
     #[serde(default)]
     pub updated_at: String,
 }
@@ -318,6 +720,8 @@ pub struct DeploymentStatus {
 pub struct DeploymentList {
     #[serde(flatten)]
     pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ListMeta,
     pub items: Vec<Deployment>,
 }
 
@@ -328,11 +732,16 @@ impl Default for DeploymentList {
                 api_version: "apps/v1".to_string(),
                 kind: "DeploymentList".to_string(),
             },
+            metadata: ListMeta::default(),
             items: Vec::new(),
         }
     }
 }
 
+fn default_strategy_type() -> String {
+    "RollingUpdate".to_string()
+}
+
 // --- Network ---
 
 #[derive(Debug, Clone, Serialize, Deserialize, Default)]
@@ -815,3 +1224,327 @@ impl Default for EventList {
         }
     }
 }
+
+// --- Network Stats ---
+
+/// Cumulative network counters reported by a node, per host interface and
+/// per pod.
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct NetworkStats {
+    #[serde(default)]
+    pub interfaces: Vec<InterfaceStats>,
+    #[serde(default)]
+    pub pods: Vec<PodNetworkStats>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct InterfaceStats {
+    pub name: String,
+    #[serde(default)]
+    pub rx_bytes: u64,
+    #[serde(default)]
+    pub tx_bytes: u64,
+    /// Negotiated link speed, when the node knows it.
+    #[serde(default)]
+    pub speed_mbps: Option<u64>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct PodNetworkStats {
+    #[serde(default)]
+    pub namespace: String,
+    pub name: String,
+    #[serde(default)]
+    pub rx_bytes: u64,
+    #[serde(default)]
+    pub tx_bytes: u64,
+}
+
+// --- Device ---
+
+/// A peripheral attached to a node (USB, serial, GPIO chip, camera), as
+/// reported by the node's device inventory endpoint.
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Device {
+    #[serde(default)]
+    pub name: String,
+    /// usb, serial, gpio, camera, ...
+    #[serde(default)]
+    pub kind: String,
+    /// Device node, e.g. /dev/ttyUSB0
+    #[serde(default)]
+    pub path: String,
+    #[serde(default)]
+    pub vendor: String,
+    #[serde(default)]
+    pub product: String,
+    #[serde(default)]
+    pub serial: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct DeviceList {
+    #[serde(default)]
+    pub items: Vec<Device>,
+}
+
+// --- Lease (coordination.k8s.io/v1) ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Lease {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub spec: LeaseSpec,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct LeaseSpec {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub holder_identity: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub lease_duration_seconds: Option<i32>,
+    /// RFC 3339 with microseconds, as Kubernetes' MicroTime.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub acquire_time: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub renew_time: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub lease_transitions: Option<i32>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct LeaseList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ListMeta,
+    pub items: Vec<Lease>,
+}
+
+// --- Job (batch/v1) ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct Job {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub spec: JobSpec,
+    #[serde(default)]
+    pub status: JobStatus,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct JobSpec {
+    /// Pods that must succeed for the job to complete.
+    #[serde(default = "default_one")]
+    pub completions: i32,
+    /// Pods run at the same time.
+    #[serde(default = "default_one")]
+    pub parallelism: i32,
+    /// Failed pods tolerated before the job fails.
+    #[serde(default = "default_backoff_limit")]
+    pub backoff_limit: i32,
+    #[serde(default)]
+    pub template: PodTemplateSpec,
+}
+
+impl Default for JobSpec {
+    fn default() -> Self {
+        Self {
+            completions: 1,
+            parallelism: 1,
+            backoff_limit: default_backoff_limit(),
+            template: PodTemplateSpec::default(),
+        }
+    }
+}
+
+fn default_one() -> i32 {
+    1
+}
+
+fn default_backoff_limit() -> i32 {
+    6
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct PodTemplateSpec {
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub spec: PodSpec,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct JobStatus {
+    #[serde(default)]
+    pub active: i32,
+    #[serde(default)]
+    pub succeeded: i32,
+    #[serde(default)]
+    pub failed: i32,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub start_time: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub completion_time: Option<String>,
+    /// Complete or Failed once the job has finished.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub conditions: Vec<JobCondition>,
+    /// Every pod the job has run, oldest first.
+    #[serde(default)]
+    pub runs: Vec<JobRun>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct JobCondition {
+    #[serde(rename = "type")]
+    pub type_: String,
+    pub status: String,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub reason: String,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub message: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub last_transition_time: Option<String>,
+}
+
+/// One pod of a job and how it ended.
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct JobRun {
+    pub pod: String,
+    #[serde(default)]
+    pub node: String,
+    /// Pending, Running, Succeeded or Failed.
+    #[serde(default)]
+    pub phase: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub exit_code: Option<i32>,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub reason: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub created_at: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub finished_at: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct JobList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ListMeta,
+    pub items: Vec<Job>,
+}
+
+// --- CronJob (batch/v1) ---
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct CronJob {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub spec: CronJobSpec,
+    #[serde(default)]
+    pub status: CronJobStatus,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CronJobSpec {
+    /// Standard five-field cron syntax or a macro such as @daily, in UTC.
+    #[serde(default)]
+    pub schedule: String,
+    /// Paused: no further jobs are started until it is cleared.
+    #[serde(default)]
+    pub suspend: bool,
+    /// Allow, Forbid (skip a run while the last is active) or Replace
+    /// (delete the active one first).
+    #[serde(default = "default_concurrency_policy")]
+    pub concurrency_policy: String,
+    /// A run missed by more than this, e.g. while no console was leader,
+    /// is skipped.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub starting_deadline_seconds: Option<i64>,
+    #[serde(default = "default_successful_history")]
+    pub successful_jobs_history_limit: i32,
+    #[serde(default = "default_one")]
+    pub failed_jobs_history_limit: i32,
+    #[serde(default)]
+    pub job_template: JobTemplateSpec,
+}
+
+impl Default for CronJobSpec {
+    fn default() -> Self {
+        Self {
+            schedule: String::new(),
+            suspend: false,
+            concurrency_policy: default_concurrency_policy(),
+            starting_deadline_seconds: None,
+            successful_jobs_history_limit: default_successful_history(),
+            failed_jobs_history_limit: 1,
+            job_template: JobTemplateSpec::default(),
+        }
+    }
+}
+
+fn default_concurrency_policy() -> String {
+    "Allow".to_string()
+}
+
+fn default_successful_history() -> i32 {
+    3
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct JobTemplateSpec {
+    #[serde(default)]
+    pub metadata: ObjectMeta,
+    #[serde(default)]
+    pub spec: JobSpec,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct CronJobStatus {
+    /// Jobs started by this cron job that haven't finished.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub active: Vec<ObjectReference>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub last_schedule_time: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub last_successful_time: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CronJobList {
+    #[serde(flatten)]
+    pub type_meta: TypeMeta,
+    #[serde(default)]
+    pub metadata: ListMeta,
+    pub items: Vec<CronJob>,
+}
@@ -1,6 +1,8 @@
 use chrono::{DateTime, Utc};
+use serde::Serialize;
 
-#[derive(Debug, Clone, Default)]
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
 pub struct ClusterSummary {
     pub node_count: usize,
     pub healthy_nodes: usize,
@@ -9,7 +11,8 @@ pub struct ClusterSummary {
     pub nodes: Vec<NodeSummary>,
 }
 
-#[derive(Debug, Clone)]
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
 pub struct NodeSummary {
     pub name: String,
     pub healthy: bool,
@@ -28,6 +31,16 @@ pub struct PodView {
     pub age: String,
     pub containers: usize,
     pub ready: usize,
+    pub links: Vec<PodLinkView>,
+    /// Waiting for a restart because its configmaps or secrets changed.
+    pub restart_pending: bool,
+}
+
+/// Quick link advertised by a workload through its pod annotations.
+#[derive(Debug, Clone, Default)]
+pub struct PodLinkView {
+    pub name: String,
+    pub url: String,
 }
 
 #[derive(Debug, Clone, Default)]
@@ -39,6 +52,18 @@ pub struct ContainerView {
     pub reason: String,
 }
 
+#[derive(Debug, Clone, Default)]
+pub struct EnvVarView {
+    pub container: String,
+    pub name: String,
+    pub value: String,
+    /// Whether admission added it rather than the pod's author.
+    pub injected: bool,
+    /// The secret:// reference the value was resolved from, shown instead
+    /// of the value.
+    pub secret_ref: String,
+}
+
 #[derive(Debug, Clone, Default)]
 pub struct VolumeView {
     pub name: String,
@@ -55,6 +80,25 @@ pub struct NamespaceView {
     pub status_class: String,
 }
 
+/// Requested vs. quota for one resource on the namespace overview.
+#[derive(Debug, Clone, Default)]
+pub struct QuotaUsageView {
+    pub resource: String,
+    pub requested: String,
+    pub limits: String,
+    /// Empty when no quota is configured for the namespace.
+    pub quota: String,
+    pub percent: i64,
+    pub bar_class: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct NodeShareView {
+    pub node: String,
+    pub pods: usize,
+    pub percent: usize,
+}
+
 #[derive(Debug, Clone, Default)]
 pub struct ContainerDetailView {
     pub name: String,
@@ -64,6 +108,8 @@ pub struct ContainerDetailView {
     pub state: String,
     pub state_class: String,
     pub ready: bool,
+    pub restart_count: i32,
+    pub last_termination: String,
     pub volume_mounts: Vec<VolumeView>,
 }
 
@@ -79,6 +125,235 @@ pub struct NodeView {
     pub architecture: String,
     pub board: String,
     pub cpu_load: String,
+    /// How the console reaches the node: "direct", "tunnel", or empty when
+    /// unknown (on followers).
+    pub transport: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ClaimedNodeView {
+    pub name: String,
+    pub address: String,
+    pub claimed_by: String,
+    pub claimed: String,
+}
+
+/// A node on the cluster map, with its health as a map-* class: map-ok,
+/// map-warn (flapping), map-maint or map-down.
+#[derive(Debug, Clone, Default)]
+pub struct MapNodeView {
+    pub name: String,
+    pub status: String,
+    pub health_class: String,
+    /// Shelf or slot within the rack.
+    pub shelf: String,
+    pub photo_url: String,
+    /// Floor plan position in percent, empty when the node has none.
+    pub x: String,
+    pub y: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct MapRackView {
+    pub rack: String,
+    pub nodes: Vec<MapNodeView>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct MapRoomView {
+    pub room: String,
+    pub racks: Vec<MapRackView>,
+}
+
+/// A tile in the kiosk node heatmap.
+#[derive(Debug, Clone, Default)]
+pub struct KioskNodeView {
+    pub name: String,
+    pub healthy: bool,
+    pub pod_count: usize,
+    /// CPU load in percent, empty when the node doesn't report it.
+    pub load: String,
+    /// heat-ok, heat-warm, heat-hot or heat-down.
+    pub heat_class: String,
+}
+
+/// An extended resource (GPU, NPU, ...) on a node or summed across the cluster.
+#[derive(Debug, Clone, Default)]
+pub struct AcceleratorView {
+    pub resource: String,
+    pub capacity: i64,
+    pub allocated: i64,
+    pub free: i64,
+    pub percent: i64,
+    /// Pods holding the resource, as namespace/name (node pages only).
+    pub holders: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ConnectivityRowView {
+    pub source: String,
+    pub cells: Vec<ConnectivityCellView>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ConnectivityCellView {
+    /// Latency and loss, or "n/a" where the pair wasn't probed.
+    pub label: String,
+    pub detail: String,
+    /// good, fair, poor, down or none
+    pub class: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct PortCheckView {
+    pub label: String,
+    pub target: String,
+    pub mode: String,
+    /// "open", an HTTP status, or "unreachable".
+    pub result: String,
+    pub class: String,
+    pub latency: String,
+    pub error: String,
+}
+
+/// A pod with declared ports, offered as a one-click check.
+#[derive(Debug, Clone, Default)]
+pub struct KnownPortsView {
+    pub pod: String,
+    pub node: String,
+    pub ip: String,
+    pub ports: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct DnsResultView {
+    pub server: String,
+    pub rcode: String,
+    /// One "TYPE data (ttl)" line per answer.
+    pub answers: Vec<String>,
+    pub latency: String,
+    pub error: String,
+    pub class: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct DiagnosticRunView {
+    pub id: u64,
+    pub at: String,
+    pub kind: String,
+    pub target: String,
+    pub user: String,
+    pub summary: String,
+    pub class: String,
+    pub output: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct EncryptionFileView {
+    pub name: String,
+    pub path: String,
+    pub records: usize,
+    /// encrypted, plaintext, mixed, old key or missing.
+    pub state: String,
+    pub class: String,
+    /// Sealed record counts per key id, e.g. "3f2a9c1d: 120".
+    pub keys: String,
+}
+
+/// The latest placement decision for a pod, on its detail page.
+#[derive(Debug, Clone, Default)]
+pub struct PlacementView {
+    pub node: String,
+    pub strategy: String,
+    pub reason: String,
+    pub when: String,
+    pub candidates: Vec<PlacementCandidateView>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct PlacementCandidateView {
+    pub node: String,
+    pub score: String,
+    /// Why it was ruled out; empty if it wasn't.
+    pub excluded: String,
+    pub avoided: bool,
+    /// Outside the namespace's soft pin.
+    pub unpreferred: bool,
+    pub chosen: bool,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ScheduledTaskView {
+    pub name: String,
+    pub description: String,
+    pub interval: String,
+    pub staggered: bool,
+    pub runs: u64,
+    pub last_run: String,
+    /// How long the last run took.
+    pub took: String,
+    pub next_run: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct LockoutView {
+    pub key: String,
+    pub failures: u32,
+    /// Time left, e.g. "4m 10s".
+    pub remaining: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct FailedSignInView {
+    pub when: String,
+    pub remote: String,
+    pub identity: String,
+    pub endpoint: String,
+    pub reason: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct DeviceView {
+    pub node: String,
+    pub name: String,
+    pub kind: String,
+    pub path: String,
+    pub vendor: String,
+    pub product: String,
+    pub serial: String,
+    pub granted_to: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct DeviceSetView {
+    pub namespace: String,
+    pub name: String,
+    pub device_class: String,
+    pub desired: usize,
+    pub current: usize,
+    pub ready: usize,
+    /// "node: reason" for each node the set's pod couldn't be created on.
+    pub errors: Vec<String>,
+    pub synced_age: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct IpamEntryView {
+    pub ip: String,
+    pub kind: String,
+    pub owner: String,
+    pub url: String,
+    pub node: String,
+    pub network: String,
+    pub issues: Vec<String>,
+}
+
+/// A known subnet or reserved range with how many listed addresses fall in it.
+#[derive(Debug, Clone, Default)]
+pub struct IpRangeView {
+    pub name: String,
+    pub range: String,
+    pub used: usize,
 }
 
 #[derive(Debug, Clone, Default)]
@@ -87,9 +362,169 @@ pub struct DeploymentView {
     pub namespace: String,
     pub replicas: i32,
     pub ready_replicas: i32,
+    /// Pods on the current template.
+    pub updated_replicas: i32,
+    pub status: String,
+    pub status_class: String,
+    pub age: String,
+    /// Run by the console's deployment controller rather than a node.
+    pub managed: bool,
+    /// The first container's image.
+    pub image: String,
+    pub strategy: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct JobView {
+    pub name: String,
+    pub namespace: String,
+    pub status: String,
+    pub status_class: String,
+    pub succeeded: i32,
+    pub completions: i32,
+    pub parallelism: i32,
+    pub active: i32,
+    pub failed: i32,
+    pub backoff_limit: i32,
+    /// Run time so far, or until the job finished.
+    pub duration: String,
+    pub age: String,
+    pub message: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct CronJobView {
+    pub name: String,
+    pub namespace: String,
+    pub schedule: String,
+    pub suspended: bool,
+    pub concurrency_policy: String,
+    pub image: String,
+    pub active: usize,
+    /// Time since the last run was scheduled, or "" if none has been.
+    pub last_schedule: String,
+    pub last_success: String,
+    /// Status of the newest job, as on the Jobs page.
+    pub last_status: String,
+    pub last_status_class: String,
+    /// e.g. "in 4m", or "" when suspended or never due.
+    pub next_run: String,
+    pub age: String,
+}
+
+/// An image build: a job as on the Jobs page, plus what it builds.
+#[derive(Debug, Clone, Default)]
+pub struct BuildView {
+    pub job: JobView,
+    pub context: String,
+    pub image: String,
+    pub node: String,
+    /// Deployment rolled onto the image, or "".
+    pub deploy: String,
+    /// "namespace/pod" of the newest run, for its logs; "" before any.
+    pub log_pod: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct JobRunView {
+    pub pod: String,
+    pub node: String,
+    pub phase: String,
+    pub phase_class: String,
+    /// Exit code of the pod's failing (or only) container, or "" while it
+    /// runs.
+    pub exit_code: String,
+    pub reason: String,
+    pub duration: String,
+    pub age: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct DaemonSetView {
+    pub namespace: String,
+    pub name: String,
+    /// "" when the set runs on every node.
+    pub node_selector: String,
+    pub desired: usize,
+    pub current: usize,
+    pub ready: usize,
     pub status: String,
     pub status_class: String,
+    pub synced_age: String,
+    pub age: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct DaemonNodeView {
+    pub node: String,
+    pub healthy: bool,
+    pub pod: String,
+    pub phase: String,
+    pub phase_class: String,
+    pub error: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct RestartRunView {
+    /// restart or migrate.
+    pub mode: String,
+    pub started_by: String,
+    pub started: String,
+    /// Empty while the run is going.
+    pub finished: String,
+    pub done: usize,
+    pub failed: usize,
+    pub total: usize,
+    pub pods: Vec<PodRestartView>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct PodRestartView {
+    pub namespace: String,
+    pub name: String,
+    pub state: String,
+    pub state_class: String,
+    pub message: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct PoolView {
+    pub name: String,
+    /// (node, healthy) pairs.
+    pub nodes: Vec<(String, bool)>,
+    pub healthy_nodes: usize,
+    pub pods: usize,
+    pub cordoned: bool,
+    /// Who cordoned the pool and how long ago; empty unless cordoned.
+    pub cordoned_by: String,
+    pub cordoned_age: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct SharedStorageView {
+    pub name: String,
+    /// NFS or SMB.
+    pub kind: String,
+    /// server:/path for NFS, //server/share for SMB.
+    pub source: String,
+    pub read_only: bool,
+    pub description: String,
+    pub added_by: String,
+    pub added_age: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct StuckPodView {
+    pub namespace: String,
+    pub name: String,
+    pub node: String,
+    pub problem: String,
+    pub problem_class: String,
+    /// How long the pod has been stuck.
     pub age: String,
+    pub causes: Vec<String>,
+    /// (action, button label) pairs.
+    pub remediations: Vec<(String, String)>,
 }
 
 #[derive(Debug, Clone, Default)]
@@ -159,6 +594,49 @@ pub struct ConfigMapView {
     pub age: String,
 }
 
+#[derive(Debug, Clone, Default)]
+pub struct ServiceView {
+    pub name: String,
+    pub namespace: String,
+    pub service_type: String,
+    pub cluster_ip: String,
+    /// e.g. "80→8080/TCP".
+    pub ports: Vec<String>,
+    pub selector: String,
+    pub backends: Vec<ServiceBackendView>,
+    pub age: String,
+}
+
+/// A pod address behind a service, from its merged endpoints.
+#[derive(Debug, Clone, Default)]
+pub struct ServiceBackendView {
+    pub ip: String,
+    /// Empty when the endpoint doesn't name a pod.
+    pub pod: String,
+    pub namespace: String,
+    pub node: String,
+    pub ready: bool,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct SecretView {
+    pub name: String,
+    pub namespace: String,
+    pub secret_type: String,
+    pub key_count: usize,
+    pub age: String,
+}
+
+/// One key of a secret on its detail page; the value stays hidden until
+/// revealed.
+#[derive(Debug, Clone, Default)]
+pub struct SecretKeyView {
+    pub key: String,
+    /// Decoded value, or a note when it isn't text.
+    pub value: String,
+    pub bytes: usize,
+}
+
 #[derive(Debug, Clone, Default)]
 pub struct CheckItemView {
     pub name: String,
@@ -200,3 +678,113 @@ pub struct SubscriberView {
     pub initiator_iqn: String,
     pub since: String,
 }
+
+#[derive(Debug, Clone, Default)]
+pub struct AlertView {
+    pub key: String,
+    pub severity: String,
+    pub severity_class: String,
+    pub summary: String,
+    pub description: String,
+    /// RFC 3339; templates format it with the ago filter.
+    pub started: String,
+    pub resolved: String,
+    /// How long the alert fired, or has been firing.
+    pub duration_secs: i64,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ArchiveEntryView {
+    pub id: u64,
+    pub kind: String,
+    pub namespace: String,
+    pub pod: String,
+    pub container: String,
+    pub node: String,
+    pub restart_count: i32,
+    pub reason: String,
+    pub exit_code: String,
+    pub message: String,
+    pub finished_at: String,
+    pub captured: String,
+    pub logs: String,
+}
+
+/// One contiguous run of health check results on a node's timeline strip.
+#[derive(Debug, Clone, Default)]
+pub struct HealthSegmentView {
+    pub class: String,
+    pub width: String,
+    pub title: String,
+}
+
+/// Node RX/TX history as SVG polyline points in a 100x40 viewBox.
+#[derive(Debug, Clone, Default)]
+pub struct ThroughputChartView {
+    pub rx_points: String,
+    pub tx_points: String,
+    pub rx_now: String,
+    pub tx_now: String,
+    pub peak: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct InterfaceRateView {
+    pub name: String,
+    pub rx: String,
+    pub tx: String,
+    pub speed: String,
+    /// Empty when the link speed is unknown.
+    pub utilisation: String,
+    pub saturated: bool,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct TopTalkerView {
+    pub namespace: String,
+    pub name: String,
+    pub node: String,
+    pub rx: String,
+    pub tx: String,
+    pub total: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct RecentlyViewedView {
+    pub kind: String,
+    pub label: String,
+    pub url: String,
+    pub viewed: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ActivityView {
+    pub action: String,
+    pub action_class: String,
+    pub kind: String,
+    pub label: String,
+    /// Empty when the resource is gone (deletes).
+    pub url: String,
+    pub user: String,
+    pub message: String,
+    pub when: String,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct FavoriteView {
+    pub kind: String,
+    pub namespace: String,
+    pub name: String,
+    pub label: String,
+    pub url: String,
+    pub status: String,
+    pub status_class: String,
+}
+
+/// A node, pod or cluster a sandboxed rule or policy would match.
+#[derive(Debug, Clone, Default)]
+pub struct SandboxMatchView {
+    pub subject: String,
+    /// The metric's value, or what admission would change.
+    pub detail: String,
+}
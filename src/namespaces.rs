@@ -0,0 +1,141 @@
+use chrono::Utc;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::{Namespace, NamespaceStatus, ObjectMeta, TypeMeta};
+
+/// Namespace every cluster has, which can't be deleted.
+pub const DEFAULT_NAMESPACE: &str = "default";
+
+#[derive(Debug)]
+pub enum NamespaceError {
+    NotFound,
+    AlreadyExists,
+    Invalid(String),
+}
+
+/// Namespaces created through the API. Nodes keep no namespace objects of
+/// their own, so the console tracks them centrally: a namespace exists once
+/// it is created here or once a pod runs in it (see
+/// Aggregator::list_namespaces), and the store only has to remember the
+/// ones created ahead of their pods. Persisted under the data dir when
+/// there is one.
+pub struct NamespaceStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<NamespaceState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct NamespaceState {
+    namespaces: BTreeMap<String, Namespace>,
+}
+
+impl NamespaceStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    pub fn list(&self) -> Vec<Namespace> {
+        self.state.lock().unwrap().namespaces.values().cloned().collect()
+    }
+
+    pub fn get(&self, name: &str) -> Option<Namespace> {
+        self.state.lock().unwrap().namespaces.get(name).cloned()
+    }
+
+    pub fn create(&self, mut ns: Namespace, by: &str) -> Result<Namespace, NamespaceError> {
+        validate_name(&ns.metadata.name)?;
+        let mut state = self.state.lock().unwrap();
+        if ns.metadata.name == DEFAULT_NAMESPACE || state.namespaces.contains_key(&ns.metadata.name) {
+            return Err(NamespaceError::AlreadyExists);
+        }
+        ns.type_meta = namespace_type();
+        ns.metadata.namespace.clear();
+        ns.metadata.resource_version.clear();
+        ns.metadata.deletion_timestamp = None;
+        ns.metadata.creation_timestamp = Some(Utc::now().to_rfc3339_opts(chrono::SecondsFormat::Secs, true));
+        ns.metadata
+            .annotations
+            .get_or_insert_with(Default::default)
+            .insert("mkube.io/created-by".to_string(), by.to_string());
+        ns.status = active();
+        state.namespaces.insert(ns.metadata.name.clone(), ns.clone());
+        self.save(&state);
+        Ok(ns)
+    }
+
+    pub fn delete(&self, name: &str) -> Result<Namespace, NamespaceError> {
+        let mut state = self.state.lock().unwrap();
+        let Some(ns) = state.namespaces.remove(name) else {
+            return Err(NamespaceError::NotFound);
+        };
+        self.save(&state);
+        Ok(ns)
+    }
+
+    fn save(&self, state: &NamespaceState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing namespaces {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+/// A namespace nobody created through the API, which exists because pods
+/// run in it or, for "default", always.
+pub fn implicit(name: &str) -> Namespace {
+    Namespace {
+        type_meta: namespace_type(),
+        metadata: ObjectMeta {
+            name: name.to_string(),
+            ..Default::default()
+        },
+        status: active(),
+    }
+}
+
+fn namespace_type() -> TypeMeta {
+    TypeMeta {
+        api_version: "v1".to_string(),
+        kind: "Namespace".to_string(),
+    }
+}
+
+fn active() -> NamespaceStatus {
+    NamespaceStatus {
+        phase: "Active".to_string(),
+    }
+}
+
+// Namespaces are DNS labels, as in Kubernetes.
+fn validate_name(name: &str) -> Result<(), NamespaceError> {
+    let valid = !name.is_empty()
+        && name.len() <= 63
+        && name
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-')
+        && !name.starts_with('-')
+        && !name.ends_with('-');
+    if valid {
+        Ok(())
+    } else {
+        Err(NamespaceError::Invalid(format!(
+            "namespace {:?} must be at most 63 lowercase letters, digits and dashes, starting and ending with a letter or digit",
+            name
+        )))
+    }
+}
@@ -0,0 +1,166 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::clients::aggregator::Aggregator;
+use crate::crypto::{self, Sealer};
+use crate::models::k8s::Pod;
+
+/// Pod annotation naming the pool a pod must run in.
+pub const POOL_ANNOTATION: &str = "mkube.io/pool";
+
+/// Pools the operator has cordoned: the scheduler places no new pods on
+/// their nodes unless a pod names its node, as with a node in maintenance.
+/// Lets a whole pool, e.g. boards trialing a new agent version, be drained
+/// at once. Persisted under the data dir when there is one.
+pub struct PoolStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<PoolState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct PoolState {
+    cordoned: BTreeMap<String, Cordon>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Cordon {
+    pub by: String,
+    pub at: DateTime<Utc>,
+}
+
+/// A pool as the API and the pools page show it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PoolStatus {
+    pub name: String,
+    pub nodes: Vec<String>,
+    pub healthy_nodes: usize,
+    /// Pods running on the pool's nodes.
+    pub pods: usize,
+    pub cordon: Option<Cordon>,
+}
+
+impl PoolStore {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    pub fn is_cordoned(&self, pool: &str) -> bool {
+        self.state.lock().unwrap().cordoned.contains_key(pool)
+    }
+
+    /// Cordons `pool`; false if it already was.
+    pub fn cordon(&self, pool: &str, by: &str) -> bool {
+        let mut state = self.state.lock().unwrap();
+        if state.cordoned.contains_key(pool) {
+            return false;
+        }
+        state.cordoned.insert(
+            pool.to_string(),
+            Cordon {
+                by: by.to_string(),
+                at: Utc::now(),
+            },
+        );
+        self.save(&state);
+        true
+    }
+
+    /// Lifts a cordon; false if `pool` wasn't cordoned.
+    pub fn uncordon(&self, pool: &str) -> bool {
+        let mut state = self.state.lock().unwrap();
+        if state.cordoned.remove(pool).is_none() {
+            return false;
+        }
+        self.save(&state);
+        true
+    }
+
+    fn save(&self, state: &PoolState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing pool cordons {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+/// The pool a pod asks to run in, if any.
+pub fn pod_pool(pod: &Pod) -> Option<&str> {
+    pod.metadata
+        .annotations
+        .as_ref()?
+        .get(POOL_ANNOTATION)
+        .map(String::as_str)
+        .filter(|p| !p.is_empty())
+}
+
+/// Whether any node is configured into `pool`.
+pub async fn exists(aggregator: &Aggregator, pool: &str) -> bool {
+    aggregator
+        .snapshot_clients()
+        .await
+        .iter()
+        .any(|c| c.pool.as_deref() == Some(pool))
+}
+
+/// Every pool some node is configured into, with its nodes, pods and
+/// cordon. Cordoned pools no node belongs to any more are listed too, so
+/// the cordon can be lifted.
+pub async fn summarize(aggregator: &Aggregator, store: &PoolStore) -> Vec<PoolStatus> {
+    let mut pools: BTreeMap<String, PoolStatus> = BTreeMap::new();
+    for c in aggregator.snapshot_clients().await {
+        let Some(ref pool) = c.pool else { continue };
+        let status = pools.entry(pool.clone()).or_insert_with(|| PoolStatus {
+            name: pool.clone(),
+            nodes: Vec::new(),
+            healthy_nodes: 0,
+            pods: 0,
+            cordon: None,
+        });
+        status.nodes.push(c.name.clone());
+        if c.is_healthy() {
+            status.healthy_nodes += 1;
+        }
+    }
+    for (name, cordon) in &store.state.lock().unwrap().cordoned {
+        pools
+            .entry(name.clone())
+            .or_insert_with(|| PoolStatus {
+                name: name.clone(),
+                nodes: Vec::new(),
+                healthy_nodes: 0,
+                pods: 0,
+                cordon: None,
+            })
+            .cordon = Some(cordon.clone());
+    }
+
+    for pod in aggregator.list_all_pods().await.unwrap_or_default() {
+        let Some(node) = pod.metadata.annotations.as_ref().and_then(|a| a.get("mkube.io/node")) else {
+            continue;
+        };
+        if let Some(status) = pools.values_mut().find(|p| p.nodes.contains(node)) {
+            status.pods += 1;
+        }
+    }
+    let mut pools: Vec<PoolStatus> = pools.into_values().collect();
+    for p in &mut pools {
+        p.nodes.sort();
+    }
+    pools
+}
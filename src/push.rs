@@ -0,0 +1,329 @@
+use base64::Engine;
+use base64::engine::general_purpose::URL_SAFE_NO_PAD;
+use chrono::Utc;
+use reqwest::Client;
+use ring::rand::{SecureRandom, SystemRandom};
+use ring::signature::{ECDSA_P256_SHA256_FIXED_SIGNING, EcdsaKeyPair, KeyPair};
+use ring::{aead, agreement, hkdf};
+use serde::{Deserialize, Serialize};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use tokio::sync::broadcast;
+use tracing::{info, warn};
+
+use crate::alerts::{Alert, AlertManager};
+use crate::crypto::{self, Sealer};
+
+/// A browser push subscription as produced by `PushManager.subscribe()`,
+/// tagged with the user who registered it.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PushSubscription {
+    pub endpoint: String,
+    pub keys: PushKeys,
+    #[serde(default)]
+    pub user: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PushKeys {
+    pub p256dh: String,
+    pub auth: String,
+}
+
+#[derive(Serialize)]
+struct Notification<'a> {
+    title: &'a str,
+    body: &'a str,
+    tag: &'a str,
+    url: &'a str,
+}
+
+/// Sends Web Push notifications (RFC 8030/8291/8292) for newly firing alerts
+/// to every registered browser subscription.
+pub struct PushNotifier {
+    key: EcdsaKeyPair,
+    subject: String,
+    rng: SystemRandom,
+    http: Client,
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    subs: Mutex<Vec<PushSubscription>>,
+}
+
+impl PushNotifier {
+    /// Loads the VAPID key, generating one on first start, and any saved
+    /// subscriptions.
+    pub fn new(
+        key_path: PathBuf,
+        subject: String,
+        subs_path: Option<PathBuf>,
+        sealer: Arc<Sealer>,
+    ) -> Result<Self, Box<dyn std::error::Error + Send + Sync>> {
+        let rng = SystemRandom::new();
+
+        let pkcs8 = match std::fs::read(&key_path) {
+            Ok(b) => sealer
+                .open_bytes(&b)
+                .map_err(|e| format!("loading VAPID key {}: {}", key_path.display(), e))?,
+            Err(e) if e.kind() != std::io::ErrorKind::NotFound => {
+                return Err(format!("reading VAPID key {}: {}", key_path.display(), e).into());
+            }
+            Err(_) => {
+                let doc = EcdsaKeyPair::generate_pkcs8(&ECDSA_P256_SHA256_FIXED_SIGNING, &rng)
+                    .map_err(|_| "generating VAPID key")?;
+                if let Some(dir) = key_path.parent() {
+                    std::fs::create_dir_all(dir)?;
+                }
+                std::fs::write(&key_path, sealer.seal_bytes(doc.as_ref()))
+                    .map_err(|e| format!("writing VAPID key {}: {}", key_path.display(), e))?;
+                info!("generated VAPID key at {}", key_path.display());
+                doc.as_ref().to_vec()
+            }
+        };
+        let key = EcdsaKeyPair::from_pkcs8(&ECDSA_P256_SHA256_FIXED_SIGNING, &pkcs8, &rng)
+            .map_err(|e| format!("loading VAPID key {}: {}", key_path.display(), e))?;
+
+        let subs = crypto::load_sealed(subs_path.as_deref(), &sealer)?;
+
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+
+        Ok(Self {
+            key,
+            subject,
+            rng,
+            http,
+            path: subs_path,
+            sealer,
+            subs: Mutex::new(subs),
+        })
+    }
+
+    /// Public VAPID key, base64url encoded, for `applicationServerKey`.
+    pub fn public_key(&self) -> String {
+        URL_SAFE_NO_PAD.encode(self.key.public_key().as_ref())
+    }
+
+    pub fn subscribe(&self, sub: PushSubscription) {
+        let mut subs = self.subs.lock().unwrap();
+        subs.retain(|s| s.endpoint != sub.endpoint);
+        subs.push(sub);
+        self.save(&subs);
+    }
+
+    pub fn unsubscribe(&self, endpoint: &str) -> bool {
+        let mut subs = self.subs.lock().unwrap();
+        let before = subs.len();
+        subs.retain(|s| s.endpoint != endpoint);
+        let removed = subs.len() != before;
+        if removed {
+            self.save(&subs);
+        }
+        removed
+    }
+
+    pub fn subscriptions_for(&self, user: &str) -> Vec<PushSubscription> {
+        self.subs
+            .lock()
+            .unwrap()
+            .iter()
+            .filter(|s| s.user == user)
+            .cloned()
+            .collect()
+    }
+
+    pub async fn run(
+        self: Arc<Self>,
+        alerts: Arc<AlertManager>,
+        mut shutdown: tokio::sync::watch::Receiver<()>,
+    ) {
+        let mut rx = alerts.subscribe();
+
+        loop {
+            tokio::select! {
+                msg = rx.recv() => match msg {
+                    Ok(alert) => self.notify(&alert).await,
+                    Err(broadcast::error::RecvError::Lagged(n)) => {
+                        warn!("push notifier skipped {} alerts", n);
+                    }
+                    Err(broadcast::error::RecvError::Closed) => return,
+                },
+                _ = shutdown.changed() => {
+                    info!("push notifier shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    async fn notify(&self, alert: &Alert) {
+        let payload = serde_json::to_vec(&Notification {
+            title: &alert.summary,
+            body: &alert.description,
+            tag: &alert.key,
+            url: "/ui/alerts",
+        })
+        .unwrap_or_default();
+
+        let subs = self.subs.lock().unwrap().clone();
+        for sub in subs {
+            match self.send(&sub, &payload).await {
+                Ok(()) => {}
+                Err(SendError::Gone) => {
+                    info!("push subscription expired, removing {}", sub.endpoint);
+                    self.unsubscribe(&sub.endpoint);
+                }
+                Err(SendError::Other(e)) => warn!("push to {}: {}", sub.endpoint, e),
+            }
+        }
+    }
+
+    async fn send(&self, sub: &PushSubscription, payload: &[u8]) -> Result<(), SendError> {
+        let body = self.encrypt(sub, payload).map_err(SendError::Other)?;
+        let auth = self.vapid_header(&sub.endpoint).map_err(SendError::Other)?;
+
+        let resp = self
+            .http
+            .post(&sub.endpoint)
+            .header("TTL", "3600")
+            .header("Urgency", "high")
+            .header("Content-Encoding", "aes128gcm")
+            .header("Content-Type", "application/octet-stream")
+            .header("Authorization", auth)
+            .body(body)
+            .send()
+            .await
+            .map_err(|e| SendError::Other(e.to_string()))?;
+
+        match resp.status().as_u16() {
+            200..=299 => Ok(()),
+            404 | 410 => Err(SendError::Gone),
+            code => {
+                let text = resp.text().await.unwrap_or_default();
+                Err(SendError::Other(format!("push service returned {}: {}", code, text)))
+            }
+        }
+    }
+
+    // VAPID (RFC 8292): an ES256 JWT scoped to the push service origin.
+    fn vapid_header(&self, endpoint: &str) -> Result<String, String> {
+        let audience = endpoint
+            .splitn(4, '/')
+            .take(3)
+            .collect::<Vec<_>>()
+            .join("/");
+        let header = URL_SAFE_NO_PAD.encode(br#"{"typ":"JWT","alg":"ES256"}"#);
+        let claims = URL_SAFE_NO_PAD.encode(
+            serde_json::json!({
+                "aud": audience,
+                "exp": Utc::now().timestamp() + 12 * 3600,
+                "sub": self.subject,
+            })
+            .to_string(),
+        );
+        let signing_input = format!("{}.{}", header, claims);
+        let sig = self
+            .key
+            .sign(&self.rng, signing_input.as_bytes())
+            .map_err(|_| "signing VAPID token".to_string())?;
+        Ok(format!(
+            "vapid t={}.{}, k={}",
+            signing_input,
+            URL_SAFE_NO_PAD.encode(sig.as_ref()),
+            self.public_key()
+        ))
+    }
+
+    // Message encryption (RFC 8291) with the aes128gcm content coding (RFC 8188),
+    // as a single record.
+    fn encrypt(&self, sub: &PushSubscription, payload: &[u8]) -> Result<Vec<u8>, String> {
+        let ua_public = URL_SAFE_NO_PAD
+            .decode(sub.keys.p256dh.trim_end_matches('='))
+            .map_err(|e| format!("bad p256dh key: {}", e))?;
+        let auth_secret = URL_SAFE_NO_PAD
+            .decode(sub.keys.auth.trim_end_matches('='))
+            .map_err(|e| format!("bad auth secret: {}", e))?;
+
+        let as_private = agreement::EphemeralPrivateKey::generate(&agreement::ECDH_P256, &self.rng)
+            .map_err(|_| "generating ECDH key")?;
+        let as_public = as_private
+            .compute_public_key()
+            .map_err(|_| "computing ECDH public key")?
+            .as_ref()
+            .to_vec();
+        let ecdh_secret = agreement::agree_ephemeral(
+            as_private,
+            &agreement::UnparsedPublicKey::new(&agreement::ECDH_P256, &ua_public),
+            |s| s.to_vec(),
+        )
+        .map_err(|_| "ECDH agreement failed")?;
+
+        let mut key_info = b"WebPush: info\0".to_vec();
+        key_info.extend_from_slice(&ua_public);
+        key_info.extend_from_slice(&as_public);
+        let ikm = hkdf_derive(&auth_secret, &ecdh_secret, &key_info, 32)?;
+
+        let mut salt = [0u8; 16];
+        self.rng.fill(&mut salt).map_err(|_| "generating salt")?;
+        let cek = hkdf_derive(&salt, &ikm, b"Content-Encoding: aes128gcm\0", 16)?;
+        let nonce = hkdf_derive(&salt, &ikm, b"Content-Encoding: nonce\0", 12)?;
+
+        let mut record = payload.to_vec();
+        record.push(0x02); // last-record delimiter, no padding
+
+        let key = aead::LessSafeKey::new(
+            aead::UnboundKey::new(&aead::AES_128_GCM, &cek).map_err(|_| "bad content key")?,
+        );
+        let nonce = aead::Nonce::try_assume_unique_for_key(&nonce).map_err(|_| "bad nonce")?;
+        key.seal_in_place_append_tag(nonce, aead::Aad::empty(), &mut record)
+            .map_err(|_| "encrypting payload")?;
+
+        let mut body = Vec::with_capacity(16 + 4 + 1 + as_public.len() + record.len());
+        body.extend_from_slice(&salt);
+        body.extend_from_slice(&4096u32.to_be_bytes());
+        body.push(as_public.len() as u8);
+        body.extend_from_slice(&as_public);
+        body.extend_from_slice(&record);
+        Ok(body)
+    }
+
+    fn save(&self, subs: &[PushSubscription]) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(subs)
+                .map_err(|e| e.to_string())
+                .and_then(|data| {
+                    std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string())
+                });
+            if let Err(e) = result {
+                warn!("writing push subscriptions {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+enum SendError {
+    /// The subscription no longer exists at the push service.
+    Gone,
+    Other(String),
+}
+
+struct OkmLen(usize);
+
+impl hkdf::KeyType for OkmLen {
+    fn len(&self) -> usize {
+        self.0
+    }
+}
+
+fn hkdf_derive(salt: &[u8], ikm: &[u8], info: &[u8], len: usize) -> Result<Vec<u8>, String> {
+    let prk = hkdf::Salt::new(hkdf::HKDF_SHA256, salt).extract(ikm);
+    let info = [info];
+    let okm = prk.expand(&info, OkmLen(len)).map_err(|_| "HKDF expand failed")?;
+    let mut out = vec![0u8; len];
+    okm.fill(&mut out).map_err(|_| "HKDF fill failed")?;
+    Ok(out)
+}
@@ -0,0 +1,43 @@
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+use std::sync::Mutex;
+
+/// Whether the console refuses changes, with who switched it and when.
+#[derive(Debug, Clone, Default, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ReadOnlyStatus {
+    pub enabled: bool,
+    /// "config" when it started that way.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub by: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub since: Option<DateTime<Utc>>,
+}
+
+// Global rather than in AppState so layout.html can hide the change forms
+// on every page without each template carrying a flag.
+static STATUS: Mutex<ReadOnlyStatus> = Mutex::new(ReadOnlyStatus {
+    enabled: false,
+    by: String::new(),
+    since: None,
+});
+
+/// Read-only mode: every change through the API or UI is refused with 403
+/// and the UI hides its change forms, for maintenance windows or showing
+/// the dashboard to guests. Starts as config.read_only says; admins switch
+/// it at /ui/read-only or /api/admin/read-only until the next restart.
+pub fn enabled() -> bool {
+    STATUS.lock().unwrap().enabled
+}
+
+pub fn status() -> ReadOnlyStatus {
+    STATUS.lock().unwrap().clone()
+}
+
+pub fn set(enabled: bool, by: &str) {
+    *STATUS.lock().unwrap() = ReadOnlyStatus {
+        enabled,
+        by: by.to_string(),
+        since: Some(Utc::now()),
+    };
+}
@@ -0,0 +1,128 @@
+use chrono::{DateTime, Utc};
+use reqwest::{Client, Url};
+use ring::rand::{SecureRandom, SystemRandom};
+use serde::Serialize;
+use std::time::Duration;
+use tracing::warn;
+
+use crate::config::ErrorReportingConfig;
+
+/// A handler panic, with the request it happened on.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ErrorReport {
+    pub time: DateTime<Utc>,
+    pub cluster: String,
+    pub request_id: String,
+    pub method: String,
+    pub path: String,
+    pub user: String,
+    pub message: String,
+    /// file:line:column of the panic.
+    pub location: String,
+    pub backtrace: String,
+}
+
+/// Forwards error reports to a webhook as JSON and/or to a Sentry-compatible
+/// store endpoint. Delivery runs in the background and failures are only
+/// logged, so reporting never holds up or breaks the response.
+pub struct ErrorReporter {
+    http: Client,
+    webhook_url: Option<String>,
+    sentry: Option<SentryDsn>,
+}
+
+#[derive(Clone)]
+struct SentryDsn {
+    store_url: String,
+    key: String,
+}
+
+impl ErrorReporter {
+    pub fn new(cfg: &ErrorReportingConfig) -> Result<Self, String> {
+        let sentry = cfg.sentry_dsn.as_deref().map(parse_dsn).transpose()?;
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        Ok(Self {
+            http,
+            webhook_url: cfg.webhook_url.clone(),
+            sentry,
+        })
+    }
+
+    pub fn report(&self, report: ErrorReport) {
+        if let Some(ref url) = self.webhook_url {
+            let req = self.http.post(url).json(&report);
+            tokio::spawn(async move {
+                match req.send().await {
+                    Ok(r) if !r.status().is_success() => warn!("error webhook: {}", r.status()),
+                    Err(e) => warn!("error webhook: {}", e),
+                    Ok(_) => {}
+                }
+            });
+        }
+        if let Some(ref dsn) = self.sentry {
+            let auth = format!(
+                "Sentry sentry_version=7, sentry_client=mkube-console/{}, sentry_key={}",
+                env!("CARGO_PKG_VERSION"),
+                dsn.key
+            );
+            let req = self
+                .http
+                .post(&dsn.store_url)
+                .header("X-Sentry-Auth", auth)
+                .json(&sentry_event(&report));
+            tokio::spawn(async move {
+                match req.send().await {
+                    Ok(r) if !r.status().is_success() => warn!("sentry report: {}", r.status()),
+                    Err(e) => warn!("sentry report: {}", e),
+                    Ok(_) => {}
+                }
+            });
+        }
+    }
+}
+
+// https://<key>@<host>[:port][/prefix]/<project> ->
+// https://<host>[:port][/prefix]/api/<project>/store/
+fn parse_dsn(dsn: &str) -> Result<SentryDsn, String> {
+    let url = Url::parse(dsn).map_err(|e| format!("sentry_dsn: {}", e))?;
+    let key = url.username().to_string();
+    let host = url.host_str().unwrap_or_default();
+    let path = url.path().trim_end_matches('/');
+    let (prefix, project) = path.rsplit_once('/').unwrap_or(("", path));
+    if key.is_empty() || host.is_empty() || project.is_empty() {
+        return Err("sentry_dsn: expected https://<key>@<host>/<project>".to_string());
+    }
+    let port = url.port().map(|p| format!(":{}", p)).unwrap_or_default();
+    Ok(SentryDsn {
+        store_url: format!("{}://{}{}{}/api/{}/store/", url.scheme(), host, port, prefix, project),
+        key,
+    })
+}
+
+fn sentry_event(r: &ErrorReport) -> serde_json::Value {
+    serde_json::json!({
+        "event_id": event_id(),
+        "timestamp": r.time.to_rfc3339(),
+        "level": "error",
+        "platform": "other",
+        "logger": "mkube-console",
+        "server_name": r.cluster,
+        "message": r.message,
+        "culprit": r.location,
+        "request": { "method": r.method, "url": r.path },
+        "user": { "username": r.user },
+        "tags": { "request_id": r.request_id },
+        "extra": { "backtrace": r.backtrace },
+    })
+}
+
+// Sentry wants 32 hex digits.
+fn event_id() -> String {
+    let mut id = [0u8; 16];
+    let _ = SystemRandom::new().fill(&mut id);
+    id.iter().map(|b| format!("{:02x}", b)).collect()
+}
@@ -0,0 +1,153 @@
+use std::collections::{BTreeMap, HashMap};
+
+use crate::helpers::parse_cpu_millis;
+use crate::models::k8s::{Device, Node, Pod};
+
+/// Resources every node has; anything else in capacity (nvidia.com/gpu,
+/// npu, coral.ai/tpu, ...) is an extended resource such as an accelerator.
+const STANDARD_RESOURCES: &[&str] = &["cpu", "memory", "pods", "ephemeral-storage", "storage"];
+
+/// Floor on the idle share used in performance scores, so a busy node still
+/// gets the occasional pod instead of dropping out entirely.
+const MIN_IDLE_SHARE: f64 = 0.1;
+
+pub fn is_extended(name: &str) -> bool {
+    !STANDARD_RESOURCES.contains(&name) && !name.starts_with("hugepages-")
+}
+
+/// Extended resources advertised by a node, preferring allocatable over
+/// capacity as the kubelet does.
+pub fn node_extended(node: &Node) -> BTreeMap<String, i64> {
+    let source = if node.status.allocatable.is_empty() {
+        &node.status.capacity
+    } else {
+        &node.status.allocatable
+    };
+    source
+        .iter()
+        .filter(|(k, _)| is_extended(k))
+        .filter_map(|(k, v)| Some((k.clone(), v.trim().parse().ok()?)))
+        .collect()
+}
+
+/// Extended resources a pod consumes. Extended resources can't be
+/// overcommitted, so a limit counts the same as a request.
+pub fn pod_extended(pod: &Pod) -> BTreeMap<String, i64> {
+    let mut out = BTreeMap::new();
+    for c in &pod.spec.containers {
+        let r = &c.resources;
+        let requests_only = r.requests.iter().filter(|(k, _)| !r.limits.contains_key(*k));
+        for (k, v) in r.limits.iter().chain(requests_only) {
+            if !is_extended(k) {
+                continue;
+            }
+            if let Ok(n) = v.trim().parse::<i64>() {
+                *out.entry(k.clone()).or_default() += n;
+            }
+        }
+    }
+    out
+}
+
+/// Sum of extended resources held by pods that are still running or pending.
+pub fn allocated(pods: &[Pod]) -> BTreeMap<String, i64> {
+    let mut out = BTreeMap::new();
+    for p in pods {
+        if p.status.phase == "Succeeded" || p.status.phase == "Failed" {
+            continue;
+        }
+        for (k, n) in pod_extended(p) {
+            *out.entry(k).or_default() += n;
+        }
+    }
+    out
+}
+
+/// Pod annotation listing the node devices (paths or names, comma
+/// separated) the pod has been granted, e.g. "/dev/ttyUSB0,/dev/video0".
+pub const DEVICES_ANNOTATION: &str = "mkube.io/devices";
+
+pub fn pod_devices(pod: &Pod) -> Vec<String> {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(DEVICES_ANNOTATION))
+        .map(|v| {
+            v.split(',')
+                .map(|d| d.trim().to_string())
+                .filter(|d| !d.is_empty())
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Device grants by (node, device path or name) -> pods as namespace/name.
+pub fn device_grants(pods: &[Pod]) -> HashMap<(String, String), Vec<String>> {
+    let mut out: HashMap<(String, String), Vec<String>> = HashMap::new();
+    for p in pods {
+        let node = p
+            .metadata
+            .annotations
+            .as_ref()
+            .and_then(|a| a.get("mkube.io/node"))
+            .cloned()
+            .unwrap_or_default();
+        for d in pod_devices(p) {
+            out.entry((node.clone(), d))
+                .or_default()
+                .push(format!("{}/{}", p.metadata.namespace, p.metadata.name));
+        }
+    }
+    out
+}
+
+/// Pods holding a device, matched on either its path or its name.
+pub fn device_granted_to(
+    grants: &HashMap<(String, String), Vec<String>>,
+    node: &str,
+    device: &Device,
+) -> Vec<String> {
+    let mut out = Vec::new();
+    for key in [&device.path, &device.name] {
+        if key.is_empty() {
+            continue;
+        }
+        if let Some(pods) = grants.get(&(node.to_string(), key.clone())) {
+            for p in pods {
+                if !out.contains(p) {
+                    out.push(p.clone());
+                }
+            }
+        }
+    }
+    out
+}
+
+/// Relative capacity of a node for weighted scheduling: CPU count times
+/// clock in GHz (1 GHz where the node doesn't report mkube.io/cpu-mhz),
+/// scaled by how idle the node currently is.
+pub fn performance_score(node: &Node) -> f64 {
+    let cores = node
+        .status
+        .capacity
+        .get("cpu")
+        .and_then(|c| parse_cpu_millis(c))
+        .map(|m| m as f64 / 1000.0)
+        .filter(|c| *c > 0.0)
+        .unwrap_or(1.0);
+    let annotation = |key: &str| {
+        node.metadata
+            .annotations
+            .as_ref()
+            .and_then(|a| a.get(key))
+            .and_then(|v| v.trim().trim_end_matches('%').parse::<f64>().ok())
+    };
+    let ghz = annotation("mkube.io/cpu-mhz")
+        .filter(|m| *m > 0.0)
+        .map(|m| m / 1000.0)
+        .unwrap_or(1.0);
+    let idle = annotation("mkube.io/cpu-load")
+        .map(|load| (1.0 - load / 100.0).max(MIN_IDLE_SHARE))
+        .unwrap_or(1.0);
+    cores * ghz * idle
+}
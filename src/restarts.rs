@@ -0,0 +1,332 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use tokio::time::{self, Duration, Instant};
+
+use crate::activity::ActivityLog;
+use crate::clients::aggregator::Aggregator;
+use crate::models::k8s::Pod;
+use crate::selfhost::SelfHost;
+use crate::stuck;
+
+/// Most pods a run restarts at once.
+pub const MAX_CONCURRENCY: usize = 10;
+
+/// Longest pause a run may take between batches.
+pub const MAX_DELAY_SECS: u64 = 600;
+
+/// How long a restart waits for the old pod to go before recreating it.
+const DELETE_TIMEOUT: Duration = Duration::from_secs(120);
+const DELETE_POLL: Duration = Duration::from_secs(2);
+
+#[derive(Debug, Clone, Copy, PartialEq, Default, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum RestartMode {
+    /// Stop each pod and start it again on the same node.
+    #[default]
+    Restart,
+    /// Move each pod to another node.
+    Migrate,
+}
+
+impl RestartMode {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            RestartMode::Restart => "restart",
+            RestartMode::Migrate => "migrate",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct RestartOptions {
+    #[serde(default)]
+    pub mode: RestartMode,
+    /// Pods restarted at once; 1 goes one pod at a time.
+    #[serde(default = "default_concurrency")]
+    pub concurrency: usize,
+    /// Pause after each batch, so a pod can come up before the next goes.
+    #[serde(default = "default_delay_secs")]
+    pub delay_secs: u64,
+}
+
+fn default_concurrency() -> usize {
+    1
+}
+
+fn default_delay_secs() -> u64 {
+    5
+}
+
+/// A restart of every pod on a node, as the API and node page show it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct RestartRun {
+    pub node: String,
+    pub mode: RestartMode,
+    pub concurrency: usize,
+    pub delay_secs: u64,
+    pub started_by: String,
+    pub started_at: DateTime<Utc>,
+    /// None while the run is going.
+    pub finished_at: Option<DateTime<Utc>>,
+    pub pods: Vec<PodRestart>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PodRestart {
+    pub namespace: String,
+    pub name: String,
+    /// pending, running, done, failed or skipped.
+    pub state: &'static str,
+    pub message: String,
+}
+
+impl RestartRun {
+    pub fn running(&self) -> bool {
+        self.finished_at.is_none()
+    }
+
+    pub fn count(&self, state: &str) -> usize {
+        self.pods.iter().filter(|p| p.state == state).count()
+    }
+}
+
+/// Restarts or migrates every pod on a node a batch at a time, to pick up
+/// a host-level change (kernel, runtime, network config) without draining
+/// the whole node at once. Pods a deployment or job owns are deleted and
+/// left to their controller; standalone pods are recreated by the console.
+/// The console's own pod is skipped, to be restarted on its own after
+/// handing off to a standby. Keeps the latest run per node; only one runs
+/// per node at a time.
+pub struct NodeRestarts {
+    aggregator: Arc<Aggregator>,
+    activity: Arc<ActivityLog>,
+    self_host: Arc<SelfHost>,
+    runs: Mutex<HashMap<String, RestartRun>>,
+}
+
+impl NodeRestarts {
+    pub fn new(aggregator: Arc<Aggregator>, activity: Arc<ActivityLog>, self_host: Arc<SelfHost>) -> Self {
+        Self {
+            aggregator,
+            activity,
+            self_host,
+            runs: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// The node's current or latest run.
+    pub fn get(&self, node: &str) -> Option<RestartRun> {
+        self.runs.lock().unwrap().get(node).cloned()
+    }
+
+    /// Starts restarting the node's pods in the background and returns the
+    /// run as started.
+    pub async fn start(self: &Arc<Self>, node: &str, opts: RestartOptions, user: &str) -> Result<RestartRun, String> {
+        if opts.concurrency == 0 || opts.concurrency > MAX_CONCURRENCY {
+            return Err(format!("concurrency must be between 1 and {}", MAX_CONCURRENCY));
+        }
+        if opts.delay_secs > MAX_DELAY_SECS {
+            return Err(format!("delay must be at most {}s", MAX_DELAY_SECS));
+        }
+        let client = self
+            .aggregator
+            .snapshot_clients()
+            .await
+            .into_iter()
+            .find(|c| c.name == node)
+            .ok_or_else(|| format!("node {:?} not found", node))?;
+        if !client.is_healthy() {
+            return Err(format!("node {} is offline", node));
+        }
+        // Completed pods and ones already on their way out are left alone.
+        let pods: Vec<Pod> = client
+            .list_pods()
+            .await
+            .map_err(|e| format!("listing pods on {}: {}", node, e))?
+            .items
+            .into_iter()
+            .filter(|p| p.metadata.deletion_timestamp.is_none())
+            .filter(|p| p.status.phase != "Succeeded" && p.status.phase != "Failed")
+            .collect();
+        if pods.is_empty() {
+            return Err(format!("no pods to {} on {}", opts.mode.as_str(), node));
+        }
+
+        let run = RestartRun {
+            node: node.to_string(),
+            mode: opts.mode,
+            concurrency: opts.concurrency,
+            delay_secs: opts.delay_secs,
+            started_by: user.to_string(),
+            started_at: Utc::now(),
+            finished_at: None,
+            pods: pods
+                .iter()
+                .map(|p| {
+                    let own = self.self_host.is_self(&p.metadata.namespace, &p.metadata.name);
+                    PodRestart {
+                        namespace: p.metadata.namespace.clone(),
+                        name: p.metadata.name.clone(),
+                        state: if own { "skipped" } else { "pending" },
+                        message: if own {
+                            "the console's own pod; restart it separately to hand off first".to_string()
+                        } else {
+                            String::new()
+                        },
+                    }
+                })
+                .collect(),
+        };
+        let pods: Vec<Pod> = pods
+            .into_iter()
+            .filter(|p| !self.self_host.is_self(&p.metadata.namespace, &p.metadata.name))
+            .collect();
+        {
+            let mut runs = self.runs.lock().unwrap();
+            if runs.get(node).is_some_and(|r| r.running()) {
+                return Err(format!("pods on {} are already being restarted", node));
+            }
+            runs.insert(node.to_string(), run.clone());
+        }
+        self.activity.record(
+            &format!("{}-pods", opts.mode.as_str()),
+            "node",
+            "",
+            node,
+            user,
+            &format!(
+                "{} pods, {} at a time, {}s apart",
+                pods.len(),
+                opts.concurrency,
+                opts.delay_secs
+            ),
+        );
+
+        let this = self.clone();
+        let node = node.to_string();
+        let user = user.to_string();
+        tokio::spawn(async move {
+            this.run(&node, pods, opts, &user).await;
+        });
+        Ok(run)
+    }
+
+    async fn run(&self, node: &str, pods: Vec<Pod>, opts: RestartOptions, user: &str) {
+        let batches: Vec<&[Pod]> = pods.chunks(opts.concurrency).collect();
+        for (i, batch) in batches.iter().enumerate() {
+            futures_util::future::join_all(batch.iter().map(|pod| async move {
+                self.set_state(node, pod, "running", String::new());
+                let replacement = stuck::replacement(pod);
+                let (state, message) = match restart_pod(&self.aggregator, node, pod, replacement, opts.mode).await {
+                    Ok(done) => ("done", done),
+                    Err(e) => ("failed", e),
+                };
+                self.activity.record(
+                    opts.mode.as_str(),
+                    "pod",
+                    &pod.metadata.namespace,
+                    &pod.metadata.name,
+                    user,
+                    &message,
+                );
+                self.set_state(node, pod, state, message);
+            }))
+            .await;
+            if i + 1 < batches.len() {
+                time::sleep(Duration::from_secs(opts.delay_secs)).await;
+            }
+        }
+        if let Some(run) = self.runs.lock().unwrap().get_mut(node) {
+            run.finished_at = Some(Utc::now());
+        }
+    }
+
+    fn set_state(&self, node: &str, pod: &Pod, state: &'static str, message: String) {
+        let mut runs = self.runs.lock().unwrap();
+        let Some(entry) = runs.get_mut(node).and_then(|r| {
+            r.pods
+                .iter_mut()
+                .find(|p| p.namespace == pod.metadata.namespace && p.name == pod.metadata.name)
+        }) else {
+            return;
+        };
+        entry.state = state;
+        entry.message = message;
+    }
+}
+
+/// Restarts `pod` on `node`, or with Migrate moves it to another node. A pod
+/// a deployment or job owns is only deleted, for its controller to replace;
+/// a standalone pod is recreated from `replacement` once the old one is
+/// gone.
+pub async fn restart_pod(
+    aggregator: &Aggregator,
+    node: &str,
+    pod: &Pod,
+    mut replacement: Pod,
+    mode: RestartMode,
+) -> Result<String, String> {
+    let namespace = &pod.metadata.namespace;
+    let name = &pod.metadata.name;
+    let owned = stuck::owner(pod).is_some();
+    let avoid = [node.to_string()];
+    match mode {
+        RestartMode::Restart => replacement.spec.node_name = node.to_string(),
+        // Only give up the pod once another node will take it.
+        RestartMode::Migrate => {
+            let target = aggregator.placement(&replacement, &avoid).await?;
+            if target == node {
+                return Err(format!("no node other than {} can run it", node));
+            }
+        }
+    }
+
+    aggregator
+        .delete_pod(namespace, name)
+        .await
+        .map_err(|e| format!("deleting: {}", e))?;
+    if owned {
+        return Ok("deleted; its controller replaces it".to_string());
+    }
+    wait_deleted(aggregator, namespace, name).await?;
+    match mode {
+        RestartMode::Restart => {
+            aggregator
+                .create_pod(&replacement)
+                .await
+                .map_err(|e| format!("deleted but recreating failed: {}", e))?;
+            Ok(format!("restarted on {}", node))
+        }
+        RestartMode::Migrate => {
+            let created = aggregator
+                .create_pod_avoiding(&replacement, &avoid)
+                .await
+                .map_err(|e| format!("deleted but recreating failed: {}", e))?;
+            let target = created
+                .metadata
+                .annotations
+                .as_ref()
+                .and_then(|a| a.get("mkube.io/node"))
+                .cloned()
+                .unwrap_or(created.spec.node_name);
+            Ok(format!("moved from {} to {}", node, target))
+        }
+    }
+}
+
+// Waits for a deleted pod to disappear, so its name is free to reuse.
+async fn wait_deleted(aggregator: &Aggregator, namespace: &str, name: &str) -> Result<(), String> {
+    let deadline = Instant::now() + DELETE_TIMEOUT;
+    while aggregator.get_pod(namespace, name).await.is_ok() {
+        if Instant::now() >= deadline {
+            return Err(format!("still terminating after {}s", DELETE_TIMEOUT.as_secs()));
+        }
+        time::sleep(DELETE_POLL).await;
+    }
+    Ok(())
+}
@@ -1,11 +1,66 @@
 use axum::{
     Json,
-    extract::{Path, State},
-    http::StatusCode,
+    body::{Body, Bytes},
+    extract::{ConnectInfo, Path, Query, RawQuery, Request, State, WebSocketUpgrade},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
     response::{IntoResponse, Response},
 };
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD;
+use futures_util::stream::{self, Stream, StreamExt};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::convert::Infallible;
+use std::net::SocketAddr;
+use std::pin::Pin;
+use std::time::{Duration, Instant};
+use tokio::sync::broadcast;
 
+use crate::activity::ActivityEntry;
+use crate::admission;
+use crate::bootstrap::{BootstrapToken, DEFAULT_TTL_SECS};
+use crate::bundles::{self, AppBundle};
+use crate::claims::{self, ClaimedNode};
+use crate::commitstatus::{self, CommitState};
+use crate::clients::LogOptions;
+use crate::clients::aggregator::{Aggregator, PodCursor};
+use crate::clients::decisions::PlacementDecision;
+use crate::clients::events::{ClusterEvent, VersionedEvent};
+use crate::config::{AlertRuleConfig, CustomResourceDef, EnvInjectionConfig, ResourceDefaults};
+use crate::controllers::alert_rules::{self, Snapshot};
+use crate::controllers::deployments::rollout_progress;
+use crate::custom::{CustomError, CustomEvent};
+use crate::cronjobs::CronJobError;
+use crate::crypto;
+use crate::daemonsets::MicroDaemonSetRequest;
+use crate::deployhooks::{self, DeployRequest, RolloutStatus};
+use crate::deployments::DeploymentError;
+use crate::desired::{self, DesiredConfig, DesiredNode};
+use crate::devicesets::DeviceSetRequest;
+use crate::diagnostics;
+use crate::dns;
+use crate::explain;
+use crate::favorites::{FAVORITE_KINDS, Favorite};
+use crate::helpers::{client_addr, etag, request_user, url_encode};
+use crate::ipam;
+use crate::jobs::JobError;
+use crate::leases::LeaseError;
+use crate::lint::{self, Finding};
+use crate::localvolumes::{self, LocalVolumeRequest};
+use crate::namespaces::{DEFAULT_NAMESPACE, NamespaceError};
+use crate::push::PushSubscription;
 use crate::models::k8s::*;
+use crate::pools;
+use crate::readonly;
+use crate::restarts::{self, RestartMode, RestartOptions};
+use crate::scrub;
+use crate::secrets;
+use crate::storage::{self, ShareRequest};
+use crate::update;
+use crate::resources;
+use crate::selector::LabelSelector;
+use crate::settings::Settings;
+use crate::stuck::{self, Remediation};
 use crate::AppState;
 
 pub async fn handle_api_versions(State(state): State<AppState>) -> Json<ApiVersions> {
@@ -31,6 +86,7 @@ pub async fn handle_api_resources() -> Json<ApiResourceList> {
                 verbs: vec![
                     "get".to_string(),
                     "list".to_string(),
+                    "watch".to_string(),
                     "create".to_string(),
                     "delete".to_string(),
                 ],
@@ -42,15 +98,89 @@ pub async fn handle_api_resources() -> Json<ApiResourceList> {
                 verbs: vec!["get".to_string()],
             },
             ApiResource {
-                name: "pods/status".to_string(),
+                name: "pods/exec".to_string(),
+                namespaced: true,
+                kind: "PodExecOptions".to_string(),
+                verbs: vec!["create".to_string(), "get".to_string()],
+            },
+            ApiResource {
+                name: "pods/attach".to_string(),
+                namespaced: true,
+                kind: "PodAttachOptions".to_string(),
+                verbs: vec!["create".to_string(), "get".to_string()],
+            },
+            ApiResource {
+                name: "pods/portforward".to_string(),
+                namespaced: true,
+                kind: "PodPortForwardOptions".to_string(),
+                verbs: vec!["create".to_string(), "get".to_string()],
+            },
+            ApiResource {
+                name: "pods/explain".to_string(),
                 namespaced: true,
                 kind: "Pod".to_string(),
                 verbs: vec!["get".to_string()],
             },
+            ApiResource {
+                name: "pods/status".to_string(),
+                namespaced: true,
+                kind: "Pod".to_string(),
+                verbs: vec!["get".to_string(), "update".to_string()],
+            },
             ApiResource {
                 name: "namespaces".to_string(),
                 namespaced: false,
                 kind: "Namespace".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+            ApiResource {
+                name: "configmaps".to_string(),
+                namespaced: true,
+                kind: "ConfigMap".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+            ApiResource {
+                name: "secrets".to_string(),
+                namespaced: true,
+                kind: "Secret".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+            ApiResource {
+                name: "services".to_string(),
+                namespaced: true,
+                kind: "Service".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+            ApiResource {
+                name: "endpoints".to_string(),
+                namespaced: true,
+                kind: "Endpoints".to_string(),
+                verbs: vec!["get".to_string(), "list".to_string()],
+            },
+            ApiResource {
+                name: "events".to_string(),
+                namespaced: true,
+                kind: "Event".to_string(),
                 verbs: vec!["get".to_string(), "list".to_string()],
             },
             ApiResource {
@@ -63,86 +193,486 @@ pub async fn handle_api_resources() -> Json<ApiResourceList> {
     })
 }
 
-pub async fn handle_list_all_pods(State(state): State<AppState>) -> Response {
-    match state.aggregator.list_all_pods().await {
-        Ok(pods) => Json(PodList {
-            type_meta: TypeMeta {
-                api_version: "v1".to_string(),
-                kind: "PodList".to_string(),
-            },
-            items: pods,
-        })
-        .into_response(),
-        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
-    }
+/// Options of pod list requests, of which node lists take the watch ones.
+/// `watch` turns the list into a stream of watch events, resuming after
+/// `resourceVersion` when one is given. Without it, `Accept: application/x-ndjson` streams the list a pod per
+/// line, and `fields` trims each pod to the paths it names.
+#[derive(Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct ListQuery {
+    #[serde(default)]
+    pub watch: bool,
+    #[serde(default)]
+    pub resource_version: String,
+    #[serde(default)]
+    pub timeout_seconds: Option<u64>,
+    /// Comma-separated dotted paths, e.g. metadata.labels,status.phase, to
+    /// send instead of whole pods.
+    #[serde(default)]
+    pub fields: String,
+    /// Overrides config.api.scrub for this request.
+    #[serde(default)]
+    pub scrub: Option<bool>,
+    /// Pods per page; the response's metadata.continue fetches the next.
+    #[serde(default)]
+    pub limit: usize,
+    #[serde(default, rename = "continue")]
+    pub continue_token: String,
+}
+
+pub async fn handle_list_all_pods(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(q): Query<ListQuery>,
+) -> Response {
+    list_pods(&state, &headers, None, &q).await
 }
 
 pub async fn handle_list_namespaced_pods(
     State(state): State<AppState>,
+    headers: HeaderMap,
     Path(namespace): Path<String>,
+    Query(q): Query<ListQuery>,
 ) -> Response {
-    match state.aggregator.list_all_pods().await {
-        Ok(pods) => {
-            let items: Vec<Pod> = pods
+    list_pods(&state, &headers, Some(namespace), &q).await
+}
+
+async fn list_pods(state: &AppState, headers: &HeaderMap, namespace: Option<String>, q: &ListQuery) -> Response {
+    if q.watch {
+        return watch_pods(state, namespace, q).await;
+    }
+    if accepts_ndjson(headers) {
+        return stream_pods(state, namespace, q).await;
+    }
+    let cursor = match q.continue_token.as_str() {
+        "" => None,
+        token => match PodCursor::decode(token) {
+            Some(c) => Some(c),
+            None => return (StatusCode::BAD_REQUEST, "invalid continue token").into_response(),
+        },
+    };
+    let paged = q.limit > 0 || cursor.is_some();
+    // Taken before listing, so a watch from this version can repeat a
+    // change the list already shows but never miss one. Later pages keep
+    // the first page's.
+    let version = match cursor {
+        Some(ref c) => c.resource_version,
+        None => state.aggregator.resource_version(),
+    };
+    let listed = if paged {
+        let limit = if q.limit > 0 { q.limit } else { usize::MAX };
+        state
+            .aggregator
+            .list_pods_page(namespace.as_deref(), limit, cursor.as_ref())
+            .await
+    } else {
+        state.aggregator.list_all_pods().await.map(|pods| {
+            let pods = pods
                 .into_iter()
-                .filter(|p| p.metadata.namespace == namespace)
+                .filter(|p| namespace.as_ref().is_none_or(|ns| p.metadata.namespace == *ns))
                 .collect();
-            Json(PodList {
-                type_meta: TypeMeta {
-                    api_version: "v1".to_string(),
-                    kind: "PodList".to_string(),
-                },
-                items,
-            })
-            .into_response()
+            (pods, false)
+        })
+    };
+    let (pods, more): (Vec<Pod>, bool) = match listed {
+        Ok(listed) => listed,
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    let fields = list_fields(&q.fields);
+    let noisy = scrub::patterns(&state.config.api, q.scrub);
+    let cap = state.config.api.max_list_bytes;
+    let total = pods.len();
+    let mut items = Vec::new();
+    let mut bytes = 0;
+    for pod in &pods {
+        let version = state.aggregator.pod_version(&pod.metadata.namespace, &pod.metadata.name);
+        let item = project(pod, version, &fields, noisy);
+        let size = serde_json::to_vec(&item).map(|b| b.len()).unwrap_or(0);
+        if cap > 0 && bytes + size > cap {
+            break;
         }
-        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+        bytes += size;
+        items.push(item);
+    }
+    let truncated = items.len() < total;
+    let route = match namespace {
+        Some(_) => "/api/v1/namespaces/{namespace}/pods",
+        None => "/api/v1/pods",
+    };
+    state.http_metrics.observe_list(route, bytes, truncated);
+
+    let shown = items.len();
+    // A page cut short by the byte cap continues where it stopped
+    let next = match shown.checked_sub(1) {
+        Some(last) if paged && (truncated || more) => Some(PodCursor::after(&pods[last], version).encode()),
+        _ => None,
+    };
+    let mut metadata = serde_json::json!({"resourceVersion": version.to_string()});
+    if let Some(ref token) = next {
+        metadata["continue"] = serde_json::json!(token);
+    }
+    let list = Json(serde_json::json!({
+        "apiVersion": "v1",
+        "kind": "PodList",
+        "metadata": metadata,
+        "items": items,
+    }));
+    if truncated && next.is_none() {
+        let warning = format!(
+            "299 - \"pod list cut to {} of {} pods at api.max_list_bytes; narrow it with ?fields=, page it with ?limit= or stream it with Accept: {}\"",
+            shown, total, NDJSON
+        );
+        return ([(header::WARNING, warning)], list).into_response();
+    }
+    list.into_response()
+}
+
+// The dotted paths a list's ?fields= keeps, plus the pod's name and
+// namespace; empty for whole objects.
+fn list_fields(fields: &str) -> Vec<&str> {
+    let mut paths: Vec<&str> = fields.split(',').map(str::trim).filter(|f| !f.is_empty()).collect();
+    if !paths.is_empty() {
+        paths.extend(["metadata.name", "metadata.namespace"]);
+    }
+    paths
+}
+
+// A pod as JSON at resourceVersion `version`, with resolved secrets
+// redacted, scrubbed of `noisy` annotations and cut down to the given
+// dotted paths, e.g. status.phase.
+fn project(pod: &Pod, version: u64, fields: &[&str], noisy: Option<&[String]>) -> serde_json::Value {
+    let mut pod = pod.clone();
+    pod.metadata.resource_version = version.to_string();
+    secrets::redact(&mut pod);
+    let mut value = serde_json::to_value(&pod).unwrap_or_default();
+    if let Some(patterns) = noisy {
+        scrub::scrub_value(&mut value, patterns);
+    }
+    if fields.is_empty() {
+        return value;
+    }
+    let mut out = serde_json::Value::Object(Default::default());
+    for path in fields {
+        let Some(found) = value.pointer(&format!("/{}", path.replace('.', "/"))) else {
+            continue;
+        };
+        let mut at = &mut out;
+        let mut keys = path.split('.').peekable();
+        while let Some(key) = keys.next() {
+            let Some(obj) = at.as_object_mut() else { break };
+            if keys.peek().is_none() {
+                obj.insert(key.to_string(), found.clone());
+                break;
+            }
+            at = obj
+                .entry(key)
+                .or_insert_with(|| serde_json::Value::Object(Default::default()));
+        }
+    }
+    out
+}
+
+const NDJSON: &str = "application/x-ndjson";
+
+/// Whether the client asked for newline-delimited JSON.
+fn accepts_ndjson(headers: &HeaderMap) -> bool {
+    headers
+        .get(header::ACCEPT)
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|v| v.split(',').any(|t| t.trim().starts_with(NDJSON)))
+}
+
+/// A pod list as one pod per line, written as each node answers, so big
+/// clusters never sit in memory whole on either end. No list metadata; a
+/// client that needs a resourceVersion to watch from lists as JSON.
+async fn stream_pods(state: &AppState, namespace: Option<String>, q: &ListQuery) -> Response {
+    let fields: Vec<String> = list_fields(&q.fields).into_iter().map(String::from).collect();
+    let noisy: Option<Vec<String>> = scrub::patterns(&state.config.api, q.scrub).map(<[String]>::to_vec);
+    let aggregator = state.aggregator.clone();
+    let lines = state
+        .aggregator
+        .clone()
+        .stream_all_pods()
+        .await
+        .flat_map(move |pods| {
+            let fields: Vec<&str> = fields.iter().map(String::as_str).collect();
+            let lines: Vec<Result<String, Infallible>> = pods
+                .into_iter()
+                .filter(|p| namespace.as_ref().is_none_or(|ns| p.metadata.namespace == *ns))
+                .filter_map(|p| {
+                    let version = aggregator.pod_version(&p.metadata.namespace, &p.metadata.name);
+                    serde_json::to_string(&project(&p, version, &fields, noisy.as_deref())).ok()
+                })
+                .map(|line| Ok(line + "\n"))
+                .collect();
+            stream::iter(lines)
+        });
+    ([(header::CONTENT_TYPE, NDJSON)], Body::from_stream(lines)).into_response()
+}
+
+type WatchStream = Pin<Box<dyn Stream<Item = Result<String, Infallible>> + Send>>;
+
+/// Kubernetes-style pod watch over the aggregator's events, as
+/// newline-delimited JSON. Without a resourceVersion (or with "0") it
+/// starts with the current pods as ADDED; with one it replays what changed
+/// since. A version too old to resume, or a watcher falling behind, gets a
+/// 410 Expired error event, which informers answer by relisting.
+async fn watch_pods(state: &AppState, namespace: Option<String>, q: &ListQuery) -> Response {
+    let resume = match q.resource_version.as_str() {
+        "" | "0" => None,
+        v => match v.parse::<u64>() {
+            Ok(v) => Some(v),
+            Err(_) => return (StatusCode::BAD_REQUEST, format!("invalid resourceVersion {:?}", v)).into_response(),
+        },
+    };
+    let from = resume.unwrap_or_else(|| state.aggregator.resource_version());
+
+    let mut lines = Vec::new();
+    let Some((missed, rx)) = state.aggregator.events_since(from) else {
+        lines.push(expired_event(from));
+        return watch_response(Box::pin(stream::iter(lines.into_iter().map(Ok::<_, Infallible>))));
+    };
+    if resume.is_none() {
+        let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+        for mut pod in pods {
+            if namespace.as_ref().is_some_and(|ns| pod.metadata.namespace != *ns) {
+                continue;
+            }
+            let version = state.aggregator.pod_version(&pod.metadata.namespace, &pod.metadata.name);
+            pod.metadata.resource_version = version.to_string();
+            secrets::redact(&mut pod);
+            lines.push(format!("{}\n", serde_json::json!({"type": "ADDED", "object": pod})));
+        }
+    }
+    let mut last = from;
+    for e in &missed {
+        lines.extend(watch_line(e, namespace.as_deref()));
+        last = e.resource_version;
+    }
+
+    let live = stream::unfold((Some(rx), namespace, last), |(rx, namespace, last)| async move {
+        let mut rx = rx?;
+        loop {
+            match rx.recv().await {
+                Ok(e) if e.resource_version <= last => continue,
+                Ok(e) => {
+                    if let Some(line) = watch_line(&e, namespace.as_deref()) {
+                        return Some((Ok(line), (Some(rx), namespace, e.resource_version)));
+                    }
+                }
+                Err(broadcast::error::RecvError::Lagged(_)) => {
+                    return Some((Ok(expired_event(last)), (None, namespace, last)));
+                }
+                Err(broadcast::error::RecvError::Closed) => return None,
+            }
+        }
+    });
+    let events = stream::iter(lines.into_iter().map(Ok::<_, Infallible>)).chain(live);
+    match q.timeout_seconds {
+        Some(secs) => watch_response(Box::pin(
+            events.take_until(tokio::time::sleep(Duration::from_secs(secs))),
+        )),
+        None => watch_response(Box::pin(events)),
+    }
+}
+
+fn watch_response(events: WatchStream) -> Response {
+    (
+        [(header::CONTENT_TYPE, "application/json")],
+        Body::from_stream(events),
+    )
+        .into_response()
+}
+
+// A pod event as a watch line, unless it isn't one or is in another
+// namespace.
+fn watch_line(e: &VersionedEvent, namespace: Option<&str>) -> Option<String> {
+    let (kind, pod) = e.event.watch_event()?;
+    if namespace.is_some_and(|ns| pod.metadata.namespace != ns) {
+        return None;
     }
+    let mut pod = pod.clone();
+    pod.metadata.resource_version = e.resource_version.to_string();
+    secrets::redact(&mut pod);
+    Some(format!("{}\n", serde_json::json!({"type": kind, "object": pod})))
+}
+
+fn expired_event(version: u64) -> String {
+    let status = serde_json::json!({
+        "apiVersion": "v1",
+        "kind": "Status",
+        "status": "Failure",
+        "message": format!("too old resource version: {}", version),
+        "reason": "Expired",
+        "code": 410,
+    });
+    format!("{}\n", serde_json::json!({"type": "ERROR", "object": status}))
+}
+
+#[derive(Deserialize, Default)]
+pub struct ScrubQuery {
+    /// Overrides config.api.scrub for this request.
+    #[serde(default)]
+    pub scrub: Option<bool>,
 }
 
 pub async fn handle_get_pod(
     State(state): State<AppState>,
     Path((namespace, name)): Path<(String, String)>,
+    Query(q): Query<ScrubQuery>,
+) -> Response {
+    match state.aggregator.get_pod(&namespace, &name).await {
+        Ok((pod, _)) => {
+            let version = state.aggregator.pod_version(&namespace, &name);
+            Json(project(&pod, version, &[], scrub::patterns(&state.config.api, q.scrub))).into_response()
+        }
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_explain_pod(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
 ) -> Response {
     match state.aggregator.get_pod(&namespace, &name).await {
-        Ok((pod, _)) => Json(pod).into_response(),
+        Ok((pod, node)) => {
+            Json(explain::explain(&state.aggregator, &state.activity, &pod, &node).await).into_response()
+        }
         Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
     }
 }
 
+/// PUT .../pods/{name}/status: replaces the pod's status and nothing
+/// else, as controllers and `kubectl replace --subresource=status` do. A
+/// resourceVersion in the body must match the pod's current one, as the
+/// API serves it (Aggregator::pod_version), not the node's.
+pub async fn handle_update_pod_status(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(pod): Json<Pod>,
+) -> Response {
+    if !pod.metadata.name.is_empty() && pod.metadata.name != name {
+        let msg = format!("the name in the body ({}) does not match the URL ({})", pod.metadata.name, name);
+        return (StatusCode::BAD_REQUEST, msg).into_response();
+    }
+    if let Err(e) = state.aggregator.get_pod(&namespace, &name).await {
+        return (StatusCode::NOT_FOUND, e.to_string()).into_response();
+    }
+    let version = &pod.metadata.resource_version;
+    if !version.is_empty() && *version != state.aggregator.pod_version(&namespace, &name).to_string() {
+        let msg = format!("pod {} has changed; read it again and retry", name);
+        return (StatusCode::CONFLICT, msg).into_response();
+    }
+    match state.aggregator.update_pod_status(&namespace, &name, pod.status).await {
+        Ok(mut updated) => {
+            secrets::redact(&mut updated);
+            Json(updated).into_response()
+        }
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
 pub async fn handle_create_pod(
     State(state): State<AppState>,
+    headers: HeaderMap,
     Path(namespace): Path<String>,
-    Json(mut pod): Json<Pod>,
+    Json(raw): Json<serde_json::Value>,
 ) -> Response {
+    let (pod, findings) = lint::lint_pod_manifest(&raw);
+    let Some(mut pod) = pod.filter(|_| !lint::has_errors(&findings)) else {
+        return lint_failed(findings);
+    };
     pod.metadata.namespace = namespace;
+    if let Err(e) = state.admission.admit_pod(&mut pod).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
+    if let Err(e) = storage::verify_mounts(&state.aggregator, &state.storage, &pod).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
     match state.aggregator.create_pod(&pod).await {
-        Ok(result) => (StatusCode::CREATED, Json(result)).into_response(),
+        Ok(mut result) => {
+            let version = state.aggregator.pod_version(&result.metadata.namespace, &result.metadata.name);
+            result.metadata.resource_version = version.to_string();
+            secrets::redact(&mut result);
+            state.activity.record(
+                "create",
+                "pod",
+                &result.metadata.namespace,
+                &result.metadata.name,
+                &request_user(&headers),
+                "",
+            );
+            with_warnings(&findings, (StatusCode::CREATED, Json(result)).into_response())
+        }
         Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
     }
 }
 
+#[derive(Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct DeletePodQuery {
+    /// Delete the console's own pod even though that takes the console down.
+    #[serde(default)]
+    pub confirm_self: bool,
+}
+
 pub async fn handle_delete_pod(
     State(state): State<AppState>,
+    headers: HeaderMap,
     Path((namespace, name)): Path<(String, String)>,
+    Query(q): Query<DeletePodQuery>,
 ) -> Response {
+    if let Some(reason) = state.self_host.guard(&namespace, &name).filter(|_| !q.confirm_self) {
+        return (StatusCode::CONFLICT, reason).into_response();
+    }
     match state.aggregator.delete_pod(&namespace, &name).await {
-        Ok(()) => Json(Status {
-            api_version: "v1".to_string(),
-            kind: "Status".to_string(),
-            status: "Success".to_string(),
-            message: format!("pod {:?} deleted", name),
-        })
-        .into_response(),
+        Ok(()) => {
+            state
+                .activity
+                .record("delete", "pod", &namespace, &name, &request_user(&headers), "");
+            Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message: format!("pod {:?} deleted", name),
+            })
+            .into_response()
+        }
         Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
     }
 }
 
+#[derive(Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct LogQuery {
+    #[serde(default)]
+    pub container: Option<String>,
+    #[serde(default)]
+    pub timestamps: bool,
+    #[serde(default)]
+    pub tail_lines: Option<i64>,
+    /// Return logs from the previous (crashed) instance of the container.
+    #[serde(default)]
+    pub previous: bool,
+}
+
+impl LogQuery {
+    fn options(&self) -> LogOptions {
+        LogOptions {
+            container: self.container.clone().filter(|c| !c.is_empty()),
+            timestamps: self.timestamps,
+            tail_lines: self.tail_lines,
+            previous: self.previous,
+        }
+    }
+}
+
 pub async fn handle_get_pod_log(
     State(state): State<AppState>,
     Path((namespace, name)): Path<(String, String)>,
+    Query(query): Query<LogQuery>,
 ) -> Response {
-    match state.aggregator.get_pod_log(&namespace, &name).await {
+    match state.aggregator.get_pod_log(&namespace, &name, &query.options()).await {
         Ok(logs) => (
             StatusCode::OK,
             [("content-type", "text/plain; charset=utf-8")],
@@ -153,30 +683,3933 @@ pub async fn handle_get_pod_log(
     }
 }
 
-pub async fn handle_list_nodes(State(state): State<AppState>) -> Response {
-    match state.aggregator.list_all_nodes().await {
-        Ok(nodes) => Json(NodeList {
-            type_meta: TypeMeta {
+/// Exec into a pod the way kubectl exec does; see proxy_pod_stream.
+pub async fn handle_pod_exec(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    RawQuery(query): RawQuery,
+    req: Request,
+) -> Response {
+    proxy_pod_stream(state, namespace, name, "exec", query.unwrap_or_default(), req).await
+}
+
+/// Attach to a running container's stdin and stdout, as kubectl attach
+/// does; see proxy_pod_stream.
+pub async fn handle_pod_attach(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    RawQuery(query): RawQuery,
+    req: Request,
+) -> Response {
+    proxy_pod_stream(state, namespace, name, "attach", query.unwrap_or_default(), req).await
+}
+
+/// Tunnel TCP to a pod's ports, as kubectl port-forward does, so the
+/// console is the one way in to services on the nodes; see
+/// proxy_pod_stream.
+pub async fn handle_pod_port_forward(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    RawQuery(query): RawQuery,
+    req: Request,
+) -> Response {
+    proxy_pod_stream(state, namespace, name, "portforward", query.unwrap_or_default(), req).await
+}
+
+// Streams a pod subresource (exec, attach or portforward): the client's
+// WebSocket or SPDY upgrade is passed to the pod's node and, once the node
+// switches protocols, the two connections are spliced together byte for
+// byte, so whichever stream protocol they agree on works.
+async fn proxy_pod_stream(
+    state: AppState,
+    namespace: String,
+    name: String,
+    subresource: &'static str,
+    query: String,
+    mut req: Request,
+) -> Response {
+    let is_upgrade = req
+        .headers()
+        .get(header::CONNECTION)
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|v| v.to_ascii_lowercase().contains("upgrade"));
+    if !is_upgrade {
+        return (
+            StatusCode::BAD_REQUEST,
+            format!("{} needs a WebSocket or SPDY upgrade, as kubectl sends", subresource),
+        )
+            .into_response();
+    }
+    // Names are path segments on the node; "." and ".." would climb out
+    // of the pod's URL even encoded
+    if [&namespace, &name].iter().any(|s| s.is_empty() || *s == "." || *s == "..") {
+        return (StatusCode::BAD_REQUEST, "invalid namespace or pod name").into_response();
+    }
+    let path = format!(
+        "/api/v1/namespaces/{}/pods/{}/{}?{}",
+        url_encode(&namespace),
+        url_encode(&name),
+        subresource,
+        query
+    );
+    // Only what the protocol switch needs; the caller's own credentials
+    // stay here.
+    let forward: HeaderMap = req
+        .headers()
+        .iter()
+        .filter(|(k, _)| {
+            let k = k.as_str();
+            k == "connection" || k == "upgrade" || k == "x-stream-protocol-version" || k.starts_with("sec-websocket-")
+        })
+        .map(|(k, v)| (k.clone(), v.clone()))
+        .collect();
+    let method = req.method().clone();
+    let user = request_user(req.headers());
+    // The command run, or the ports forwarded over WebSocket; SPDY names
+    // its ports per stream instead
+    let detail: Vec<String> = Query::<Vec<(String, String)>>::try_from_uri(req.uri())
+        .map(|Query(params)| {
+            let wanted = |k: &str| k == "command" || k == "ports";
+            params.into_iter().filter(|(k, _)| wanted(k)).map(|(_, v)| v).collect()
+        })
+        .unwrap_or_default();
+    let client_upgrade = hyper::upgrade::on(&mut req);
+
+    let resp = match state.aggregator.stream_pod(&namespace, &name, method, &path, forward).await {
+        Ok(r) => r,
+        Err(e) => return (StatusCode::BAD_GATEWAY, e.to_string()).into_response(),
+    };
+    let status = resp.status();
+    if status != StatusCode::SWITCHING_PROTOCOLS {
+        let body = resp.bytes().await.unwrap_or_default();
+        return (status, body).into_response();
+    }
+
+    state
+        .activity
+        .record(subresource, "pod", &namespace, &name, &user, &detail.join(" "));
+
+    let mut switched = Response::builder().status(StatusCode::SWITCHING_PROTOCOLS);
+    for (k, v) in resp.headers() {
+        switched = switched.header(k, v);
+    }
+    tokio::spawn(async move {
+        let (node, client) = match tokio::join!(resp.upgrade(), client_upgrade) {
+            (Ok(node), Ok(client)) => (node, client),
+            (Err(e), _) => {
+                tracing::warn!("{} {}/{}: node upgrade failed: {}", subresource, namespace, name, e);
+                return;
+            }
+            (_, Err(e)) => {
+                tracing::warn!("{} {}/{}: client upgrade failed: {}", subresource, namespace, name, e);
+                return;
+            }
+        };
+        let (mut node, mut client) = (node, hyper_util::rt::TokioIo::new(client));
+        if let Err(e) = tokio::io::copy_bidirectional(&mut client, &mut node).await {
+            tracing::debug!("{} {}/{} ended: {}", subresource, namespace, name, e);
+        }
+    });
+    switched.body(Body::empty()).unwrap_or_else(|_| StatusCode::BAD_GATEWAY.into_response())
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct MergedLogQuery {
+    #[serde(default)]
+    pub label_selector: String,
+    #[serde(default)]
+    pub namespace: Option<String>,
+    #[serde(default)]
+    pub container: Option<String>,
+    #[serde(default)]
+    pub timestamps: bool,
+    #[serde(default)]
+    pub tail_lines: Option<i64>,
+    #[serde(default)]
+    pub previous: bool,
+}
+
+/// Merged "tail app" logs across all pods matching a label selector, each
+/// line prefixed with its node and pod.
+pub async fn handle_merged_logs(
+    State(state): State<AppState>,
+    Query(query): Query<MergedLogQuery>,
+) -> Response {
+    let selector = match LabelSelector::parse(&query.label_selector) {
+        Ok(s) if !s.is_empty() => s,
+        Ok(_) => {
+            return (StatusCode::BAD_REQUEST, "labelSelector is required").into_response();
+        }
+        Err(e) => return (StatusCode::BAD_REQUEST, e).into_response(),
+    };
+    let namespace = query.namespace.as_deref().filter(|ns| !ns.is_empty());
+    let opts = LogOptions {
+        container: query.container.clone().filter(|c| !c.is_empty()),
+        timestamps: query.timestamps,
+        tail_lines: query.tail_lines,
+        previous: query.previous,
+    };
+
+    match state
+        .aggregator
+        .get_merged_logs(namespace, &selector, &opts)
+        .await
+    {
+        Ok(lines) => {
+            let mut out = String::new();
+            for l in lines {
+                if query.timestamps {
+                    if let Some(ts) = l.timestamp {
+                        out.push_str(&ts.to_rfc3339());
+                        out.push(' ');
+                    }
+                }
+                out.push_str(&format!("[{}/{}] {}\n", l.node, l.pod, l.line));
+            }
+            (
+                StatusCode::OK,
+                [("content-type", "text/plain; charset=utf-8")],
+                out,
+            )
+                .into_response()
+        }
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+// --- ConfigMaps, kept on every node (see Aggregator::create_configmap) ---
+
+pub async fn handle_list_configmaps(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    match state.aggregator.list_configmaps(&namespace).await {
+        Ok(items) => Json(ConfigMapList {
+            items,
+            ..Default::default()
+        })
+        .into_response(),
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_get_configmap(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.aggregator.get_configmap(&namespace, &name).await {
+        Ok(cm) => Json(cm).into_response(),
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_create_configmap(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(namespace): Path<String>,
+    Json(mut cm): Json<ConfigMap>,
+) -> Response {
+    if cm.metadata.name.is_empty() {
+        return (StatusCode::UNPROCESSABLE_ENTITY, "metadata.name is required").into_response();
+    }
+    cm.metadata.namespace = namespace;
+    match state.aggregator.create_configmap(&cm).await {
+        Ok((created, failed)) => {
+            let message = format!("{} keys", created.data.len());
+            state.activity.record(
+                "create",
+                "configmap",
+                &created.metadata.namespace,
+                &created.metadata.name,
+                &request_user(&headers),
+                &message,
+            );
+            partial_warning(StatusCode::CREATED, Json(created), "not created on", &failed)
+        }
+        Err(e) => (StatusCode::BAD_GATEWAY, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_delete_configmap(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.aggregator.delete_configmap(&namespace, &name).await {
+        Ok(failed) => {
+            state
+                .activity
+                .record("delete", "configmap", &namespace, &name, &request_user(&headers), "");
+            let status = Json(Status {
                 api_version: "v1".to_string(),
-                kind: "NodeList".to_string(),
-            },
-            items: nodes,
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message: format!("configmap {:?} deleted", name),
+            });
+            partial_warning(StatusCode::OK, status, "not deleted from", &failed)
+        }
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+// --- Secrets, kept on every node like configmaps ---
+
+pub async fn handle_list_secrets(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    match state.aggregator.list_secrets(&namespace).await {
+        Ok(items) => Json(SecretList {
+            items,
+            ..Default::default()
         })
         .into_response(),
         Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
     }
 }
 
-pub async fn handle_get_node(
+pub async fn handle_get_secret(
     State(state): State<AppState>,
-    Path(name): Path<String>,
+    Path((namespace, name)): Path<(String, String)>,
 ) -> Response {
-    match state.aggregator.get_node(&name).await {
-        Ok(node) => Json(node).into_response(),
+    match state.aggregator.get_secret(&namespace, &name).await {
+        Ok(secret) => Json(secret).into_response(),
         Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
     }
 }
 
-pub async fn handle_healthz() -> &'static str {
-    "ok\n"
+pub async fn handle_create_secret(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(namespace): Path<String>,
+    Json(mut secret): Json<Secret>,
+) -> Response {
+    if secret.metadata.name.is_empty() {
+        return (StatusCode::UNPROCESSABLE_ENTITY, "metadata.name is required").into_response();
+    }
+    secret.metadata.namespace = namespace;
+    // stringData is write-only, as in Kubernetes
+    for (key, value) in std::mem::take(&mut secret.string_data) {
+        secret.data.insert(key, STANDARD.encode(value));
+    }
+    match state.aggregator.create_secret(&secret).await {
+        Ok((created, failed)) => {
+            // Key names only; values never reach the activity feed
+            let mut keys: Vec<&str> = created.data.keys().map(String::as_str).collect();
+            keys.sort();
+            state.activity.record(
+                "create",
+                "secret",
+                &created.metadata.namespace,
+                &created.metadata.name,
+                &request_user(&headers),
+                &format!("keys {}", keys.join(", ")),
+            );
+            partial_warning(StatusCode::CREATED, Json(created), "not created on", &failed)
+        }
+        Err(e) => (StatusCode::BAD_GATEWAY, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_delete_secret(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.aggregator.delete_secret(&namespace, &name).await {
+        Ok(failed) => {
+            state
+                .activity
+                .record("delete", "secret", &namespace, &name, &request_user(&headers), "");
+            let status = Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message: format!("secret {:?} deleted", name),
+            });
+            partial_warning(StatusCode::OK, status, "not deleted from", &failed)
+        }
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+// --- Services, kept on every node like configmaps ---
+
+pub async fn handle_list_services(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    match state.aggregator.list_services(&namespace).await {
+        Ok(items) => Json(ServiceList {
+            items,
+            ..Default::default()
+        })
+        .into_response(),
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_get_service(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.aggregator.get_service(&namespace, &name).await {
+        Ok(svc) => Json(svc).into_response(),
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_create_service(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(namespace): Path<String>,
+    Json(mut svc): Json<Service>,
+) -> Response {
+    if svc.metadata.name.is_empty() {
+        return (StatusCode::UNPROCESSABLE_ENTITY, "metadata.name is required").into_response();
+    }
+    if svc.spec.ports.is_empty() {
+        return (StatusCode::UNPROCESSABLE_ENTITY, "spec.ports must not be empty").into_response();
+    }
+    svc.metadata.namespace = namespace;
+    match state.aggregator.create_service(&svc).await {
+        Ok((created, failed)) => {
+            let ports: Vec<String> = created.spec.ports.iter().map(|p| p.port.to_string()).collect();
+            state.activity.record(
+                "create",
+                "service",
+                &created.metadata.namespace,
+                &created.metadata.name,
+                &request_user(&headers),
+                &format!("ports {}", ports.join(", ")),
+            );
+            partial_warning(StatusCode::CREATED, Json(created), "not created on", &failed)
+        }
+        Err(e) => (StatusCode::BAD_GATEWAY, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_delete_service(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.aggregator.delete_service(&namespace, &name).await {
+        Ok(failed) => {
+            state
+                .activity
+                .record("delete", "service", &namespace, &name, &request_user(&headers), "");
+            let status = Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message: format!("service {:?} deleted", name),
+            });
+            partial_warning(StatusCode::OK, status, "not deleted from", &failed)
+        }
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+// --- Endpoints, merged from every node (see Aggregator::list_endpoints) ---
+
+pub async fn handle_list_endpoints(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    match state.aggregator.list_endpoints(&namespace).await {
+        Ok(items) => Json(EndpointsList {
+            items,
+            ..Default::default()
+        })
+        .into_response(),
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_get_endpoints(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.aggregator.get_endpoints(&namespace, &name).await {
+        Ok(ep) => Json(ep).into_response(),
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+// --- Events: the nodes' own and those the console records ---
+
+/// Options of event list requests. `fieldSelector` takes the equality
+/// clauses kubectl describe sends, e.g.
+/// involvedObject.kind=Pod,involvedObject.name=web-1.
+#[derive(Deserialize, Default)]
+#[serde(rename_all = "camelCase")]
+pub struct EventQuery {
+    #[serde(default)]
+    pub field_selector: String,
+}
+
+pub async fn handle_list_events(State(state): State<AppState>, Query(q): Query<EventQuery>) -> Response {
+    list_events(&state, None, &q).await
+}
+
+pub async fn handle_list_namespace_events(
+    State(state): State<AppState>,
+    Path(namespace): Path<String>,
+    Query(q): Query<EventQuery>,
+) -> Response {
+    list_events(&state, Some(namespace), &q).await
+}
+
+async fn list_events(state: &AppState, namespace: Option<String>, q: &EventQuery) -> Response {
+    let mut fields = Vec::new();
+    for clause in q.field_selector.split(',').filter(|c| !c.is_empty()) {
+        let Some((key, value)) = clause.split_once('=') else {
+            return (StatusCode::BAD_REQUEST, format!("fieldSelector: {:?} is not key=value", clause)).into_response();
+        };
+        // key==value means the same as key=value
+        let value = value.strip_prefix('=').unwrap_or(value);
+        match key {
+            "involvedObject.kind" | "involvedObject.name" | "involvedObject.namespace" | "type" | "reason" => {
+                fields.push((key, value))
+            }
+            // Not tracked; kubectl describe sends it alongside the name
+            "involvedObject.uid" => {}
+            _ => {
+                return (StatusCode::BAD_REQUEST, format!("fieldSelector: unsupported field {:?}", key)).into_response();
+            }
+        }
+    }
+    match state.aggregator.list_events().await {
+        Ok(items) => {
+            let items = items
+                .into_iter()
+                .filter(|e| namespace.as_ref().is_none_or(|ns| e.metadata.namespace == *ns))
+                .filter(|e| {
+                    fields.iter().all(|(key, value)| {
+                        let actual = match *key {
+                            "involvedObject.kind" => &e.involved_object.kind,
+                            "involvedObject.name" => &e.involved_object.name,
+                            "involvedObject.namespace" => &e.involved_object.namespace,
+                            "type" => &e.type_field,
+                            _ => &e.reason,
+                        };
+                        actual.as_str() == *value
+                    })
+                })
+                .collect();
+            Json(EventList {
+                items,
+                ..Default::default()
+            })
+            .into_response()
+        }
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+// A response that names, in a Warning header kubectl prints, the nodes a
+// fanned-out change didn't reach.
+fn partial_warning(code: StatusCode, body: impl IntoResponse, what: &str, failed: &[String]) -> Response {
+    if failed.is_empty() {
+        return (code, body).into_response();
+    }
+    let warning = format!("299 - \"{} {}\"", what, failed.join("; ").replace(['"', '\r', '\n'], " "));
+    (code, [(header::WARNING, warning)], body).into_response()
+}
+
+// --- Namespaces ---
+
+pub async fn handle_list_namespaces(State(state): State<AppState>) -> Response {
+    match state.aggregator.list_namespaces().await {
+        Ok(items) => Json(NamespaceList {
+            items,
+            ..Default::default()
+        })
+        .into_response(),
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_get_namespace(State(state): State<AppState>, Path(name): Path<String>) -> Response {
+    match state.aggregator.get_namespace(&name).await {
+        Ok(ns) => Json(ns).into_response(),
+        Err(_) => namespace_error(NamespaceError::NotFound, &name),
+    }
+}
+
+pub async fn handle_create_namespace(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(ns): Json<Namespace>,
+) -> Response {
+    let name = ns.metadata.name.clone();
+    // One pods already run in exists, even if nobody created it here
+    if state.aggregator.get_namespace(&name).await.is_ok() {
+        return namespace_error(NamespaceError::AlreadyExists, &name);
+    }
+    match state.namespaces.create(ns, &request_user(&headers)) {
+        Ok(ns) => {
+            state
+                .activity
+                .record("create", "namespace", "", &name, &request_user(&headers), "");
+            (StatusCode::CREATED, Json(ns)).into_response()
+        }
+        Err(e) => namespace_error(e, &name),
+    }
+}
+
+// Only empty namespaces are deleted; pods still in one would keep it
+// listed, and deleting them is left to whoever owns them.
+pub async fn handle_delete_namespace(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    if name == DEFAULT_NAMESPACE {
+        return (StatusCode::FORBIDDEN, "the default namespace can't be deleted").into_response();
+    }
+    let pods = match state.aggregator.list_all_pods().await {
+        Ok(pods) => pods.iter().filter(|p| p.metadata.namespace == name).count(),
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    if pods > 0 {
+        return (
+            StatusCode::CONFLICT,
+            format!("namespace {:?} still has {} pods; delete them first", name, pods),
+        )
+            .into_response();
+    }
+    if let Err(e) = state.namespaces.delete(&name) {
+        return namespace_error(e, &name);
+    }
+    state
+        .activity
+        .record("delete", "namespace", "", &name, &request_user(&headers), "");
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message: format!("namespace {:?} deleted", name),
+    })
+    .into_response()
+}
+
+fn namespace_error(e: NamespaceError, name: &str) -> Response {
+    match e {
+        NamespaceError::NotFound => (StatusCode::NOT_FOUND, format!("namespace {:?} not found", name)),
+        NamespaceError::AlreadyExists => (StatusCode::CONFLICT, format!("namespace {:?} already exists", name)),
+        NamespaceError::Invalid(m) => (StatusCode::UNPROCESSABLE_ENTITY, m),
+    }
+    .into_response()
+}
+
+/// Lists nodes, each at the version its health last changed at, under
+/// the aggregator's change counter. With `watch` the list becomes a stream
+/// of watch events instead, like pods'.
+pub async fn handle_list_nodes(State(state): State<AppState>, Query(q): Query<ListQuery>) -> Response {
+    if q.watch {
+        return watch_nodes(&state, &q).await;
+    }
+    // The same change counter pod lists report, which node health changes
+    // advance too; taken before listing, as for pods
+    let version = state.aggregator.resource_version();
+    match state.aggregator.list_all_nodes().await {
+        Ok(mut nodes) => {
+            for n in &mut nodes {
+                n.metadata.resource_version = state.aggregator.node_version(&n.metadata.name).to_string();
+            }
+            Json(NodeList {
+                type_meta: TypeMeta {
+                    api_version: "v1".to_string(),
+                    kind: "NodeList".to_string(),
+                },
+                metadata: ListMeta {
+                    resource_version: version.to_string(),
+                },
+                items: nodes,
+            })
+            .into_response()
+        }
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_get_node(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+) -> Response {
+    match state.aggregator.get_node(&name).await {
+        Ok(mut node) => {
+            node.metadata.resource_version = state.aggregator.node_version(&name).to_string();
+            Json(node).into_response()
+        }
+        Err(e) => (StatusCode::NOT_FOUND, e.to_string()).into_response(),
+    }
+}
+
+/// Node watch over the same events as watch_pods: the current nodes as
+/// ADDED unless resuming, then each health change as MODIFIED with the
+/// node as it is now. Nodes joining or leaving the console show up on the
+/// informer's next relist, as no event announces them.
+async fn watch_nodes(state: &AppState, q: &ListQuery) -> Response {
+    let resume = match q.resource_version.as_str() {
+        "" | "0" => None,
+        v => match v.parse::<u64>() {
+            Ok(v) => Some(v),
+            Err(_) => return (StatusCode::BAD_REQUEST, format!("invalid resourceVersion {:?}", v)).into_response(),
+        },
+    };
+    let from = resume.unwrap_or_else(|| state.aggregator.resource_version());
+
+    let mut lines = Vec::new();
+    let Some((missed, rx)) = state.aggregator.events_since(from) else {
+        lines.push(expired_event(from));
+        return watch_response(Box::pin(stream::iter(lines.into_iter().map(Ok::<_, Infallible>))));
+    };
+    let aggregator = state.aggregator.clone();
+    if resume.is_none() {
+        for mut node in aggregator.list_all_nodes().await.unwrap_or_default() {
+            node.metadata.resource_version = aggregator.node_version(&node.metadata.name).to_string();
+            lines.push(format!("{}\n", serde_json::json!({"type": "ADDED", "object": node})));
+        }
+    }
+    let mut last = from;
+    for e in &missed {
+        lines.extend(node_watch_line(&aggregator, e).await);
+        last = e.resource_version;
+    }
+
+    let live = stream::unfold((Some(rx), aggregator, last), |(rx, aggregator, last)| async move {
+        let mut rx = rx?;
+        loop {
+            match rx.recv().await {
+                Ok(e) if e.resource_version <= last => continue,
+                Ok(e) => {
+                    if let Some(line) = node_watch_line(&aggregator, &e).await {
+                        return Some((Ok(line), (Some(rx), aggregator, e.resource_version)));
+                    }
+                }
+                Err(broadcast::error::RecvError::Lagged(_)) => {
+                    return Some((Ok(expired_event(last)), (None, aggregator, last)));
+                }
+                Err(broadcast::error::RecvError::Closed) => return None,
+            }
+        }
+    });
+    let events = stream::iter(lines.into_iter().map(Ok::<_, Infallible>)).chain(live);
+    match q.timeout_seconds {
+        Some(secs) => watch_response(Box::pin(
+            events.take_until(tokio::time::sleep(Duration::from_secs(secs))),
+        )),
+        None => watch_response(Box::pin(events)),
+    }
+}
+
+// A node health change as a watch line, carrying the node fetched now;
+// nothing for pod events or a node that is gone.
+async fn node_watch_line(aggregator: &Aggregator, e: &VersionedEvent) -> Option<String> {
+    let ClusterEvent::NodeHealthChanged { ref node, .. } = e.event else {
+        return None;
+    };
+    let mut node = aggregator.get_node(node).await.ok()?;
+    node.metadata.resource_version = e.resource_version.to_string();
+    Some(format!("{}\n", serde_json::json!({"type": "MODIFIED", "object": node})))
+}
+
+/// Liveness history for a node as recorded by the console health checker.
+pub async fn handle_get_node_health(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+) -> Response {
+    let clients = state.aggregator.snapshot_clients().await;
+    let c = match clients.iter().find(|c| c.name == name) {
+        Some(c) => c,
+        None => return (StatusCode::NOT_FOUND, format!("node {:?} not found", name)).into_response(),
+    };
+    let window = chrono::Duration::minutes(state.config.node_health.flap_window_minutes);
+    let score = c.flap_score(window);
+
+    Json(serde_json::json!({
+        "name": c.name,
+        "healthy": c.is_healthy(),
+        "flapScore": score,
+        "flapping": score >= state.config.node_health.flap_threshold,
+        "unhealthySince": c.unhealthy_since(),
+        "history": c.health_history(),
+    }))
+    .into_response()
+}
+
+/// Network throughput for a node: current interface and pod rates plus the
+/// node-wide RX/TX history.
+pub async fn handle_get_node_bandwidth(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+) -> Response {
+    let clients = state.aggregator.snapshot_clients().await;
+    if !clients.iter().any(|c| c.name == name) {
+        return (StatusCode::NOT_FOUND, format!("node {:?} not found", name)).into_response();
+    }
+
+    Json(serde_json::json!({
+        "name": name,
+        "interfaces": state.metrics.interface_rates(&name),
+        "pods": state.metrics.pod_rates(&name),
+        "history": state.metrics.bandwidth_history(&name),
+    }))
+    .into_response()
+}
+
+// --- High Availability ---
+
+pub async fn handle_get_leader(State(state): State<AppState>) -> Response {
+    Json(serde_json::json!({
+        "identity": state.leader.identity(),
+        "leader": state.leader.is_leader(),
+        "lease": state.leader.current_lease(),
+        "follower": state.aggregator.replica().map(|r| serde_json::json!({
+            "upstream": r.upstream,
+            "connected": r.is_connected(),
+            "syncedAt": r.snapshot().taken_at,
+        })),
+    }))
+    .into_response()
+}
+
+// --- Cluster summary ---
+
+/// Node and pod counts with each node's health, as the dashboard shows
+/// them.
+pub async fn handle_cluster_summary(State(state): State<AppState>) -> Response {
+    Json(state.aggregator.get_cluster_summary().await).into_response()
+}
+
+// --- Alerts & History Archive ---
+
+pub async fn handle_list_alerts(State(state): State<AppState>) -> Response {
+    Json(serde_json::json!({
+        "firing": state.alerts.firing(),
+        "resolved": state.alerts.recently_resolved(),
+    }))
+    .into_response()
+}
+
+/// The alert rules in force, as compiled from the config, with the metrics
+/// they can use and why the last edit to the file was rejected, if it was.
+pub async fn handle_list_alert_rules(State(state): State<AppState>) -> Response {
+    let metrics: BTreeMap<&str, &str> = alert_rules::METRICS.iter().copied().collect();
+    Json(serde_json::json!({
+        "ruleSet": state.alert_rules.rules(),
+        "metrics": metrics,
+    }))
+    .into_response()
+}
+
+#[derive(Deserialize)]
+pub struct RuleTestRequest {
+    /// A rule as it would appear under alert_rules in the config.
+    pub rule: AlertRuleConfig,
+    /// State to test against instead of the live cluster.
+    #[serde(default)]
+    pub snapshot: Option<Snapshot>,
+}
+
+/// Dry run of an alert rule: where it would match now, without raising
+/// anything. Nothing about the rule is kept.
+pub async fn handle_test_alert_rule(
+    State(state): State<AppState>,
+    Json(req): Json<RuleTestRequest>,
+) -> Response {
+    let rule = match alert_rules::compile(std::slice::from_ref(&req.rule)) {
+        Ok(mut rules) => rules.remove(0),
+        Err(e) => return (StatusCode::BAD_REQUEST, e).into_response(),
+    };
+    let (source, snapshot) = match req.snapshot {
+        Some(s) => ("supplied", s),
+        None => ("live", state.alert_rules.snapshot().await),
+    };
+    let matches = alert_rules::dry_run(&rule, &snapshot);
+    Json(serde_json::json!({
+        "rule": rule,
+        "snapshot": source,
+        "matches": matches,
+    }))
+    .into_response()
+}
+
+#[derive(Deserialize)]
+pub struct AdmissionTestRequest {
+    /// Policies as they would appear in the config; omitted ones are off.
+    #[serde(default)]
+    pub resource_defaults: HashMap<String, ResourceDefaults>,
+    #[serde(default)]
+    pub env_injection: EnvInjectionConfig,
+    /// Pods to test against instead of the live cluster's.
+    #[serde(default)]
+    pub pods: Option<Vec<Pod>>,
+}
+
+/// Dry run of admission policies: which pods they would change, and how.
+pub async fn handle_test_admission(
+    State(state): State<AppState>,
+    Json(req): Json<AdmissionTestRequest>,
+) -> Response {
+    let (source, pods) = match req.pods {
+        Some(pods) => ("supplied", pods),
+        None => ("live", state.aggregator.list_all_pods().await.unwrap_or_default()),
+    };
+    let changed: Vec<serde_json::Value> = admission::dry_run(&pods, &req.resource_defaults, &req.env_injection)
+        .into_iter()
+        .map(|(pod, changes)| serde_json::json!({ "pod": pod, "changes": changes }))
+        .collect();
+    Json(serde_json::json!({
+        "snapshot": source,
+        "pods": changed,
+    }))
+    .into_response()
+}
+
+pub async fn handle_list_archive(State(state): State<AppState>) -> Response {
+    Json(state.archive.list()).into_response()
+}
+
+pub async fn handle_get_archive_entry(
+    State(state): State<AppState>,
+    Path(id): Path<u64>,
+) -> Response {
+    match state.archive.get(id) {
+        Some(entry) => Json(entry).into_response(),
+        None => (StatusCode::NOT_FOUND, format!("archive entry {} not found", id)).into_response(),
+    }
+}
+
+// --- Web Push ---
+
+pub async fn handle_push_public_key(State(state): State<AppState>) -> Response {
+    match state.push {
+        Some(ref p) => Json(serde_json::json!({ "publicKey": p.public_key() })).into_response(),
+        None => (StatusCode::NOT_FOUND, "push notifications are not configured").into_response(),
+    }
+}
+
+pub async fn handle_list_push_subscriptions(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+) -> Response {
+    match state.push {
+        Some(ref p) => Json(p.subscriptions_for(&request_user(&headers))).into_response(),
+        None => (StatusCode::NOT_FOUND, "push notifications are not configured").into_response(),
+    }
+}
+
+pub async fn handle_create_push_subscription(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(mut sub): Json<PushSubscription>,
+) -> Response {
+    let push = match state.push {
+        Some(ref p) => p,
+        None => return (StatusCode::NOT_FOUND, "push notifications are not configured").into_response(),
+    };
+    if !sub.endpoint.starts_with("https://") {
+        return (StatusCode::BAD_REQUEST, "subscription endpoint must be https").into_response();
+    }
+    sub.user = request_user(&headers);
+    push.subscribe(sub.clone());
+    (StatusCode::CREATED, Json(sub)).into_response()
+}
+
+#[derive(Deserialize)]
+pub struct PushUnsubscribe {
+    pub endpoint: String,
+}
+
+pub async fn handle_delete_push_subscription(
+    State(state): State<AppState>,
+    Json(req): Json<PushUnsubscribe>,
+) -> Response {
+    match state.push {
+        Some(ref p) if p.unsubscribe(&req.endpoint) => StatusCode::NO_CONTENT.into_response(),
+        Some(_) => (StatusCode::NOT_FOUND, "subscription not found").into_response(),
+        None => (StatusCode::NOT_FOUND, "push notifications are not configured").into_response(),
+    }
+}
+
+// --- Favorites ---
+
+pub async fn handle_list_favorites(State(state): State<AppState>, headers: HeaderMap) -> Response {
+    Json(state.favorites.list(&request_user(&headers))).into_response()
+}
+
+pub async fn handle_add_favorite(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(fav): Json<Favorite>,
+) -> Response {
+    if !FAVORITE_KINDS.contains(&fav.kind.as_str()) || fav.name.is_empty() {
+        return (StatusCode::BAD_REQUEST, format!("invalid favorite kind {:?}", fav.kind)).into_response();
+    }
+    state.favorites.add(&request_user(&headers), fav.clone());
+    (StatusCode::CREATED, Json(fav)).into_response()
+}
+
+pub async fn handle_remove_favorite(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(fav): Json<Favorite>,
+) -> Response {
+    if state.favorites.remove(&request_user(&headers), &fav) {
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        (StatusCode::NOT_FOUND, "favorite not found").into_response()
+    }
+}
+
+pub async fn handle_wake_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    if !state.wake.can_wake(&name) {
+        return (StatusCode::NOT_FOUND, format!("node {:?} has no wake method configured", name)).into_response();
+    }
+    match state.wake.wake(&name).await {
+        Ok(sent) => {
+            state.activity.record("wake", "node", "", &name, &request_user(&headers), &sent);
+            (
+                StatusCode::ACCEPTED,
+                Json(Status {
+                    api_version: "v1".to_string(),
+                    kind: "Status".to_string(),
+                    status: "Success".to_string(),
+                    message: sent,
+                }),
+            )
+                .into_response()
+        }
+        Err(e) => (StatusCode::BAD_GATEWAY, e.to_string()).into_response(),
+    }
+}
+
+pub async fn handle_get_pod_restarts(State(state): State<AppState>, Path(name): Path<String>) -> Response {
+    match state.restarts.get(&name) {
+        Some(run) => Json(run).into_response(),
+        None => (StatusCode::NOT_FOUND, format!("no pod restarts on node {:?}", name)).into_response(),
+    }
+}
+
+pub async fn handle_restart_node_pods(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+    Json(opts): Json<RestartOptions>,
+) -> Response {
+    match state.restarts.start(&name, opts, &request_user(&headers)).await {
+        Ok(run) => (StatusCode::ACCEPTED, Json(run)).into_response(),
+        Err(e) => (StatusCode::CONFLICT, e).into_response(),
+    }
+}
+
+pub async fn handle_connectivity(State(state): State<AppState>) -> Response {
+    Json(state.aggregator.connectivity_matrix().await).into_response()
+}
+
+#[derive(Deserialize)]
+pub struct PortCheckQuery {
+    #[serde(default)]
+    pub host: String,
+    /// namespace/name; checks all declared TCP ports unless port is given.
+    #[serde(default)]
+    pub pod: String,
+    #[serde(default)]
+    pub node: String,
+    #[serde(default)]
+    pub port: Option<u16>,
+    /// tcp (default) or http
+    #[serde(default)]
+    pub mode: String,
+    #[serde(default)]
+    pub path: String,
+}
+
+/// Checks from the console whether a pod or node port is listening.
+pub async fn handle_port_check(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(q): Query<PortCheckQuery>,
+) -> Response {
+    let targets = match diagnostics::resolve_targets(
+        &state.aggregator,
+        &q.host,
+        &q.pod,
+        &q.node,
+        q.port,
+    )
+    .await
+    {
+        Ok(t) => t,
+        Err(e) => return (StatusCode::BAD_REQUEST, e).into_response(),
+    };
+    let mode = if q.mode.is_empty() { "tcp" } else { q.mode.as_str() };
+
+    let mut results = Vec::new();
+    for t in &targets {
+        results.push(diagnostics::check_port(t, mode, &q.path).await);
+    }
+    state.diagnostics.record_port_checks(&request_user(&headers), &results);
+    Json(results).into_response()
+}
+
+#[derive(Deserialize)]
+pub struct DnsQuery {
+    pub name: String,
+    #[serde(default = "default_dns_type", rename = "type")]
+    pub qtype: String,
+}
+
+fn default_dns_type() -> String {
+    "A".to_string()
+}
+
+/// Resolves a name against the discovery DNS, the console and every node.
+pub async fn handle_dns_lookup(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(q): Query<DnsQuery>,
+) -> Response {
+    if q.name.trim().is_empty() {
+        return (StatusCode::BAD_REQUEST, "name is required".to_string()).into_response();
+    }
+    let qtype = q.qtype.to_uppercase();
+    if !dns::QUERY_TYPES.contains(&qtype.as_str()) {
+        return (StatusCode::BAD_REQUEST, format!("unsupported query type {}", q.qtype)).into_response();
+    }
+    let results = diagnostics::dns_lookup(&state.aggregator, q.name.trim(), &qtype).await;
+    state
+        .diagnostics
+        .record_dns(&request_user(&headers), q.name.trim(), &qtype, &results);
+    Json(results).into_response()
+}
+
+#[derive(Deserialize)]
+pub struct HostQuery {
+    pub host: String,
+}
+
+pub async fn handle_ping(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(q): Query<HostQuery>,
+) -> Response {
+    run_host_tool(&state, &request_user(&headers), "ping", q.host.trim()).await
+}
+
+pub async fn handle_traceroute(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(q): Query<HostQuery>,
+) -> Response {
+    run_host_tool(&state, &request_user(&headers), "traceroute", q.host.trim()).await
+}
+
+async fn run_host_tool(state: &AppState, user: &str, kind: &str, host: &str) -> Response {
+    if !diagnostics::valid_host(host) {
+        return (StatusCode::BAD_REQUEST, format!("invalid host {:?}", host)).into_response();
+    }
+    let (ok, output) = if kind == "ping" {
+        diagnostics::ping(host).await
+    } else {
+        diagnostics::traceroute(host).await
+    };
+    let summary = if ok { "completed" } else { "failed" };
+    let id = state.diagnostics.record(kind, host, user, ok, summary, output.clone());
+    Json(serde_json::json!({
+        "id": id,
+        "host": host,
+        "ok": ok,
+        "output": output,
+    }))
+    .into_response()
+}
+
+pub async fn handle_list_diagnostics(State(state): State<AppState>) -> Response {
+    Json(state.diagnostics.list()).into_response()
+}
+
+/// Everything useful for a bug report in one download: node health, firing
+/// alerts and the stored diagnostic runs.
+pub async fn handle_diagnostic_bundle(State(state): State<AppState>) -> Response {
+    let nodes: Vec<serde_json::Value> = state
+        .aggregator
+        .snapshot_clients()
+        .await
+        .iter()
+        .map(|c| {
+            serde_json::json!({
+                "name": c.name,
+                "address": c.address,
+                "healthy": c.is_healthy(),
+                "lastPing": c.last_ping(),
+                "unhealthySince": c.unhealthy_since(),
+            })
+        })
+        .collect();
+    let now = chrono::Utc::now();
+    let bundle = serde_json::json!({
+        "cluster": state.config.cluster_name,
+        "generatedAt": now,
+        "nodes": nodes,
+        "alerts": state.alerts.firing(),
+        "diagnostics": state.diagnostics.list(),
+    });
+    let filename = format!(
+        "attachment; filename=\"diagnostics-{}-{}.json\"",
+        state.config.cluster_name,
+        now.format("%Y%m%d-%H%M%S")
+    );
+    (
+        [(axum::http::header::CONTENT_DISPOSITION, filename)],
+        Json(bundle),
+    )
+        .into_response()
+}
+
+// --- Scheduling decisions ---
+
+#[derive(Deserialize)]
+pub struct DecisionQuery {
+    #[serde(default)]
+    pub namespace: Option<String>,
+    #[serde(default)]
+    pub pod: Option<String>,
+}
+
+pub async fn handle_list_decisions(State(state): State<AppState>, Query(q): Query<DecisionQuery>) -> Response {
+    Json(state.aggregator.decisions(q.namespace.as_deref(), q.pod.as_deref())).into_response()
+}
+
+// --- Stuck pods ---
+
+pub async fn handle_stuck_pods(State(state): State<AppState>) -> Response {
+    Json(stuck::analyze(&state.aggregator).await).into_response()
+}
+
+#[derive(Deserialize)]
+pub struct RemediateRequest {
+    pub action: Remediation,
+}
+
+pub async fn handle_remediate_pod(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(req): Json<RemediateRequest>,
+) -> Response {
+    match stuck::remediate(&state.aggregator, &namespace, &name, req.action).await {
+        Ok(done) => {
+            state
+                .activity
+                .record(req.action.as_str(), "pod", &namespace, &name, &request_user(&headers), &done);
+            Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message: done,
+            })
+            .into_response()
+        }
+        Err(e) => (StatusCode::CONFLICT, e).into_response(),
+    }
+}
+
+// --- Node pools ---
+
+pub async fn handle_list_pools(State(state): State<AppState>) -> Response {
+    Json(pools::summarize(&state.aggregator, &state.pools).await).into_response()
+}
+
+pub async fn handle_cordon_pool(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    if !pools::exists(&state.aggregator, &name).await {
+        return (StatusCode::NOT_FOUND, format!("no node is in pool {:?}", name)).into_response();
+    }
+    if !state.pools.cordon(&name, &request_user(&headers)) {
+        return (StatusCode::CONFLICT, format!("pool {:?} is already cordoned", name)).into_response();
+    }
+    let message = format!("pool {} takes no new pods", name);
+    state.activity.record("cordon", "pool", "", &name, &request_user(&headers), &message);
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message,
+    })
+    .into_response()
+}
+
+pub async fn handle_uncordon_pool(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    if !state.pools.uncordon(&name) {
+        return (StatusCode::NOT_FOUND, format!("pool {:?} is not cordoned", name)).into_response();
+    }
+    let message = format!("pool {} takes pods again", name);
+    state.activity.record("uncordon", "pool", "", &name, &request_user(&headers), &message);
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message,
+    })
+    .into_response()
+}
+
+// --- Node-local volumes ---
+
+/// ?namespace= for the console list endpoints that take it.
+#[derive(Deserialize)]
+pub struct NamespaceFilter {
+    #[serde(default)]
+    pub namespace: Option<String>,
+}
+
+pub async fn handle_list_local_volumes(
+    State(state): State<AppState>,
+    Query(q): Query<NamespaceFilter>,
+) -> Response {
+    Json(state.local_volumes.list(q.namespace.as_deref())).into_response()
+}
+
+pub async fn handle_create_local_volume(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<LocalVolumeRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    match localvolumes::provision(&state.aggregator, &state.local_volumes, req, &user).await {
+        Ok(volume) => {
+            state.activity.record(
+                "provision",
+                "localvolume",
+                &volume.namespace,
+                &volume.name,
+                &user,
+                &format!("{} on {}", volume.size, volume.node),
+            );
+            (StatusCode::CREATED, Json(volume)).into_response()
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_release_local_volume(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let Some(volume) = state.local_volumes.release(&namespace, &name) else {
+        return (StatusCode::NOT_FOUND, format!("local volume {}/{} not found", namespace, name)).into_response();
+    };
+    let message = format!("released; its data stays on {}", volume.node);
+    state.activity.record("release", "localvolume", &namespace, &name, &request_user(&headers), &message);
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message,
+    })
+    .into_response()
+}
+
+// --- Shared storage catalog ---
+
+pub async fn handle_list_storage(State(state): State<AppState>) -> Response {
+    Json(state.storage.list()).into_response()
+}
+
+pub async fn handle_add_storage(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<ShareRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    match state.storage.add(req, &user) {
+        Ok(export) => {
+            let message = format!("{} {}", export.kind.as_str(), export.source());
+            state.activity.record("add", "storage", "", &export.name, &user, &message);
+            (StatusCode::CREATED, Json(export)).into_response()
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_remove_storage(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    if !state.storage.remove(&name) {
+        return (StatusCode::NOT_FOUND, format!("{} is not in the storage catalog", name)).into_response();
+    }
+    let message = format!("{} removed from the storage catalog", name);
+    state.activity.record("remove", "storage", "", &name, &request_user(&headers), &message);
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message,
+    })
+    .into_response()
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct StanzaQuery {
+    pub mount_path: String,
+}
+
+/// The volume and volumeMount that mount a catalog export at mountPath.
+pub async fn handle_storage_stanza(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+    Query(q): Query<StanzaQuery>,
+) -> Response {
+    if !q.mount_path.starts_with('/') {
+        return (StatusCode::BAD_REQUEST, "mountPath must be absolute").into_response();
+    }
+    match state.storage.get(&name) {
+        Some(export) => Json(export.stanza(&q.mount_path)).into_response(),
+        None => (StatusCode::NOT_FOUND, format!("{} is not in the storage catalog", name)).into_response(),
+    }
+}
+
+// --- Config-change restarts ---
+
+pub async fn handle_list_pending_restarts(
+    State(state): State<AppState>,
+    Query(q): Query<NamespaceFilter>,
+) -> Response {
+    Json(state.config_rollouts.pending(q.namespace.as_deref())).into_response()
+}
+
+// --- Device sets ---
+
+pub async fn handle_list_device_sets(
+    State(state): State<AppState>,
+    Query(q): Query<NamespaceFilter>,
+) -> Response {
+    Json(state.device_sets.list(q.namespace.as_deref())).into_response()
+}
+
+pub async fn handle_create_device_set(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<DeviceSetRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    match state.device_sets.create(req, &user) {
+        Ok(set) => {
+            let message = format!("one pod per node with a {} device", set.device_class);
+            state.activity.record("create", "deviceset", &set.namespace, &set.name, &user, &message);
+            (StatusCode::CREATED, Json(set)).into_response()
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_delete_device_set(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    if state.device_sets.delete(&namespace, &name).is_none() {
+        return (StatusCode::NOT_FOUND, format!("device set {}/{} not found", namespace, name)).into_response();
+    }
+    let message = "deleted; its pods are removed on the next pass".to_string();
+    state.activity.record("delete", "deviceset", &namespace, &name, &request_user(&headers), &message);
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message,
+    })
+    .into_response()
+}
+
+// --- Daemon sets ---
+
+pub async fn handle_list_daemon_sets(
+    State(state): State<AppState>,
+    Query(q): Query<NamespaceFilter>,
+) -> Response {
+    Json(state.daemon_sets.list(q.namespace.as_deref())).into_response()
+}
+
+pub async fn handle_get_daemon_set(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.daemon_sets.get(&namespace, &name) {
+        Some(set) => Json(set).into_response(),
+        None => (StatusCode::NOT_FOUND, format!("daemon set {}/{} not found", namespace, name)).into_response(),
+    }
+}
+
+pub async fn handle_create_daemon_set(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<MicroDaemonSetRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    match state.daemon_sets.create(req, &user) {
+        Ok(set) => {
+            let message = match set.node_selector.as_str() {
+                "" => "one pod per node".to_string(),
+                selector => format!("one pod per node matching {}", selector),
+            };
+            state.activity.record("create", "daemonset", &set.namespace, &set.name, &user, &message);
+            (StatusCode::CREATED, Json(set)).into_response()
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_delete_daemon_set(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    if state.daemon_sets.delete(&namespace, &name).is_none() {
+        return (StatusCode::NOT_FOUND, format!("daemon set {}/{} not found", namespace, name)).into_response();
+    }
+    let message = "deleted; its pods are being removed".to_string();
+    state.activity.record("delete", "daemonset", &namespace, &name, &request_user(&headers), &message);
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message,
+    })
+    .into_response()
+}
+
+// --- Self-hosting ---
+
+pub async fn handle_self_status(State(state): State<AppState>) -> Response {
+    Json(state.self_host.status(&state.aggregator, &state.leader).await).into_response()
+}
+
+pub async fn handle_self_handoff(State(state): State<AppState>, headers: HeaderMap) -> Response {
+    match state.leader.hand_off().await {
+        Ok(holder) => {
+            let message = format!("leadership handed to {}", holder);
+            let identity = state.leader.identity();
+            state.activity.record("handoff", "console", "", &identity, &request_user(&headers), &message);
+            Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message,
+            })
+            .into_response()
+        }
+        Err(e) => (StatusCode::CONFLICT, e).into_response(),
+    }
+}
+
+pub async fn handle_self_restart(State(state): State<AppState>, headers: HeaderMap) -> Response {
+    match state.self_host.restart(&state.aggregator, &state.leader).await {
+        Ok(done) => {
+            let (namespace, name) = state
+                .self_host
+                .pod()
+                .map(|p| (p.namespace.as_str(), p.name.as_str()))
+                .unwrap_or_default();
+            state.activity.record("restart", "pod", namespace, name, &request_user(&headers), &done);
+            Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message: done,
+            })
+            .into_response()
+        }
+        Err(e) => (StatusCode::CONFLICT, e).into_response(),
+    }
+}
+
+// --- Telemetry ---
+
+/// The last usage report sent, verbatim, and the one that would be sent
+/// now.
+pub async fn handle_telemetry(State(state): State<AppState>) -> Response {
+    let Some(ref t) = state.telemetry else {
+        return Json(serde_json::json!({ "enabled": false })).into_response();
+    };
+    Json(serde_json::json!({
+        "enabled": true,
+        "endpoint": t.endpoint(),
+        "intervalHours": t.interval_hours(),
+        "last": t.last(),
+        "next": t.collect(&state).await,
+    }))
+    .into_response()
+}
+
+// --- Console updates ---
+
+/// The running console's build, and the newer release if one was found.
+pub async fn handle_version(State(state): State<AppState>) -> Response {
+    let check = state.updater.last_check().filter(|c| c.available);
+    Json(serde_json::json!({
+        "version": update::VERSION,
+        "gitCommit": update::GIT_COMMIT,
+        "buildDate": update::BUILD_DATE,
+        "latest": check.as_ref().map(|c| c.latest.clone()),
+        "changelogUrl": check.map(|c| c.changelog_url),
+    }))
+    .into_response()
+}
+
+
+pub async fn handle_update_status(State(state): State<AppState>) -> Response {
+    Json(serde_json::json!({
+        "enabled": state.updater.is_enabled(),
+        "current": update::VERSION,
+        "lastCheck": state.updater.last_check(),
+        "lastUpdate": state.updater.marker(),
+    }))
+    .into_response()
+}
+
+pub async fn handle_update_check(State(state): State<AppState>) -> Response {
+    match state.updater.check().await {
+        Ok(check) => Json(check).into_response(),
+        Err(e) => (StatusCode::BAD_GATEWAY, e).into_response(),
+    }
+}
+
+pub async fn handle_update_apply(State(state): State<AppState>, headers: HeaderMap) -> Response {
+    match state.updater.apply().await {
+        Ok(message) => {
+            let identity = state.leader.identity();
+            state.activity.record("update", "console", "", &identity, &request_user(&headers), &message);
+            Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Success".to_string(),
+                message,
+            })
+            .into_response()
+        }
+        Err(e) => (StatusCode::CONFLICT, e).into_response(),
+    }
+}
+
+// --- Background work ---
+
+pub async fn handle_schedule(State(state): State<AppState>) -> Response {
+    Json(serde_json::json!({
+        "config": state.schedule.config(),
+        "tasks": state.schedule.tasks(),
+    }))
+    .into_response()
+}
+
+/// Failed node sign-ins and the lockouts they caused (throttle.rs).
+pub async fn handle_login_attempts(State(state): State<AppState>) -> Response {
+    Json(serde_json::json!({
+        "lockouts": state.login_throttle.lockouts(),
+        "recent": state.login_throttle.recent(),
+    }))
+    .into_response()
+}
+
+// --- Read-only mode ---
+
+#[derive(Deserialize)]
+pub struct ReadOnlyRequest {
+    pub enabled: bool,
+}
+
+pub async fn handle_read_only() -> Response {
+    Json(readonly::status()).into_response()
+}
+
+pub async fn handle_set_read_only(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<ReadOnlyRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    readonly::set(req.enabled, &user);
+    let message = if req.enabled { "read-only mode on" } else { "read-only mode off" };
+    state
+        .activity
+        .record("update", "console", "", &state.leader.identity(), &user, message);
+    Json(readonly::status()).into_response()
+}
+
+// --- Encryption ---
+
+pub async fn handle_encryption_status(State(state): State<AppState>) -> Response {
+    let files: Vec<crypto::FileStatus> = crypto::store_files(&state.config)
+        .iter()
+        .map(crypto::file_status)
+        .collect();
+    Json(serde_json::json!({
+        "enabled": state.sealer.is_enabled(),
+        "currentKey": state.sealer.current_key_id(),
+        "keys": state.sealer.key_ids(),
+        "files": files,
+    }))
+    .into_response()
+}
+
+// --- Devices ---
+
+#[derive(serde::Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct NodeDevice {
+    pub node: String,
+    #[serde(flatten)]
+    pub device: Device,
+    /// Pods granted this device, as namespace/name.
+    pub granted_to: Vec<String>,
+}
+
+pub async fn handle_list_devices(State(state): State<AppState>) -> Response {
+    let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+    let grants = resources::device_grants(&pods);
+
+    let items: Vec<NodeDevice> = state
+        .aggregator
+        .list_devices()
+        .await
+        .into_iter()
+        .map(|(node, device)| {
+            let granted_to = resources::device_granted_to(&grants, &node, &device);
+            NodeDevice {
+                node,
+                device,
+                granted_to,
+            }
+        })
+        .collect();
+    Json(items).into_response()
+}
+
+/// Every node and pod address with collision, subnet and reservation checks.
+pub async fn handle_ipam(State(state): State<AppState>) -> Response {
+    let (entries, _) = ipam::collect(&state.aggregator, &state.config).await;
+    Json(entries).into_response()
+}
+
+// --- Activity & recently viewed ---
+
+#[derive(Deserialize)]
+pub struct ActivityQuery {
+    #[serde(default)]
+    pub offset: usize,
+    #[serde(default = "default_activity_limit")]
+    pub limit: usize,
+}
+
+fn default_activity_limit() -> usize {
+    50
+}
+
+#[derive(serde::Serialize)]
+pub struct ActivityPage {
+    pub items: Vec<ActivityEntry>,
+    pub total: usize,
+    pub offset: usize,
+    pub limit: usize,
+}
+
+pub async fn handle_list_activity(
+    State(state): State<AppState>,
+    Query(query): Query<ActivityQuery>,
+) -> Response {
+    let limit = query.limit.clamp(1, 500);
+    let (items, total) = state.activity.page(query.offset, limit);
+    Json(ActivityPage {
+        items,
+        total,
+        offset: query.offset,
+        limit,
+    })
+    .into_response()
+}
+
+pub async fn handle_list_recent(State(state): State<AppState>, headers: HeaderMap) -> Response {
+    Json(state.recent.list(&request_user(&headers))).into_response()
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ApplyQuery {
+    /// Return the plan without changing anything.
+    #[serde(default)]
+    pub dry_run: bool,
+    #[serde(default = "default_prune")]
+    pub prune: bool,
+    /// Repo ("owner/name") and commit the applied document comes from;
+    /// with config.commit_status, the outcome is reported to that commit.
+    #[serde(default)]
+    pub repo: Option<String>,
+    #[serde(default)]
+    pub commit: Option<String>,
+}
+
+fn default_prune() -> bool {
+    true
+}
+
+impl ApplyQuery {
+    // Checks repo and commit before anything is applied.
+    fn check_commit(&self) -> Result<(), Response> {
+        match (&self.repo, &self.commit) {
+            (None, None) => Ok(()),
+            (Some(repo), Some(commit)) if commitstatus::valid_target(repo, commit) => Ok(()),
+            _ => {
+                let msg = "repo must be owner/name and commit a SHA, both or neither";
+                Err((StatusCode::BAD_REQUEST, msg).into_response())
+            }
+        }
+    }
+}
+
+// Reports how applying the request's commit went (see commitstatus.rs).
+// Dry runs change nothing, so they report nothing.
+fn report_commit(state: &AppState, query: &ApplyQuery, ok: bool, description: &str) {
+    let (Some(reporter), Some(repo), Some(commit)) = (&state.commit_status, &query.repo, &query.commit) else {
+        return;
+    };
+    if !query.dry_run {
+        let outcome = if ok { CommitState::Success } else { CommitState::Failure };
+        reporter.report(repo, commit, outcome, description);
+    }
+}
+
+// Marks the request's commit pending: a dry run found changes that no
+// apply has made yet.
+fn report_pending(state: &AppState, query: &ApplyQuery, description: &str) {
+    if let (Some(reporter), Some(repo), Some(commit)) = (&state.commit_status, &query.repo, &query.commit) {
+        reporter.report(repo, commit, CommitState::Pending, description);
+    }
+}
+
+#[derive(serde::Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ApplyResult {
+    pub plan: bundles::BundlePlan,
+    pub applied: bool,
+    pub errors: Vec<String>,
+    /// Lint warnings about the bundle's pods; they don't stop the apply.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<Finding>,
+    /// On a dry run, where each pod to be created would be placed and why.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub placements: Vec<PlacementDecision>,
+}
+
+/// Applies an app bundle idempotently. Pods the previous apply created are
+/// found by their bundle label; changed ones are recreated and, with
+/// prune (the default), ones no longer in the bundle are deleted. With
+/// dryRun only the plan is returned.
+pub async fn handle_apply_bundle(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(query): Query<ApplyQuery>,
+    Json(bundle): Json<AppBundle>,
+) -> Response {
+    if let Err(resp) = query.check_commit() {
+        return resp;
+    }
+    let desired = match bundles::prepare(&bundle) {
+        Ok(pods) => pods,
+        Err(e) => {
+            report_commit(&state, &query, false, &format!("bundle {}: {}", bundle.name, e));
+            return (StatusCode::BAD_REQUEST, e).into_response();
+        }
+    };
+    let warnings: Vec<Finding> = bundle
+        .pods
+        .iter()
+        .enumerate()
+        .flat_map(|(i, p)| lint::lint_pod_spec(&p.spec, &format!("pods[{}].spec", i)))
+        .collect();
+    if lint::has_errors(&warnings) {
+        report_commit(&state, &query, false, &format!("bundle {}: the manifest has errors", bundle.name));
+        return lint_failed(warnings);
+    }
+    let existing = match state.aggregator.list_all_pods().await {
+        Ok(pods) => pods,
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    let plan = bundles::plan(&bundle, &desired, &existing, query.prune);
+    if !plan.conflicts.is_empty() {
+        let msg = format!("bundle {}: pods exist that it does not own", bundle.name);
+        report_commit(&state, &query, false, &msg);
+        return (
+            StatusCode::CONFLICT,
+            Json(ApplyResult {
+                plan,
+                applied: false,
+                errors: vec!["pods exist that this bundle does not own".to_string()],
+                placements: Vec::new(),
+                warnings,
+            }),
+        )
+            .into_response();
+    }
+    if query.dry_run || plan.is_noop() {
+        let mut placements = Vec::new();
+        if query.dry_run {
+            let placed = desired
+                .iter()
+                .filter(|p| plan.add.contains(&p.metadata.name) || plan.change.contains(&p.metadata.name));
+            for pod in placed {
+                placements.push(state.aggregator.plan_placement(pod, &[]).await);
+            }
+        }
+        report_commit(&state, &query, true, &format!("bundle {} is up to date", bundle.name));
+        return Json(ApplyResult {
+            plan,
+            applied: false,
+            errors: Vec::new(),
+            placements,
+            warnings,
+        })
+        .into_response();
+    }
+
+    let errors = apply_bundle(&state, &request_user(&headers), &bundle, desired, &plan).await;
+    let outcome = match errors.first() {
+        None => format!("bundle {} applied", bundle.name),
+        Some(e) => format!("bundle {}: {} error(s), first {}", bundle.name, errors.len(), e),
+    };
+    report_commit(&state, &query, errors.is_empty(), &outcome);
+    let status = if errors.is_empty() {
+        StatusCode::OK
+    } else {
+        StatusCode::MULTI_STATUS
+    };
+    (
+        status,
+        Json(ApplyResult {
+            plan,
+            applied: true,
+            errors,
+            placements: Vec::new(),
+            warnings,
+        }),
+    )
+        .into_response()
+}
+
+// Carries out a bundle's plan: deletes pods it no longer has or that
+// changed, then creates new and changed ones. Returns what failed.
+async fn apply_bundle(
+    state: &AppState,
+    user: &str,
+    bundle: &AppBundle,
+    desired: Vec<Pod>,
+    plan: &bundles::BundlePlan,
+) -> Vec<String> {
+    let ns = &bundle.namespace;
+    let note = format!("bundle {}", bundle.name);
+    let mut errors = Vec::new();
+    for name in plan.delete.iter().chain(&plan.change) {
+        match state.aggregator.delete_pod(ns, name).await {
+            Ok(()) => state.activity.record("delete", "pod", ns, name, user, &note),
+            Err(e) => errors.push(format!("delete {}: {}", name, e)),
+        }
+    }
+    for mut pod in desired {
+        let name = pod.metadata.name.clone();
+        if !plan.add.contains(&name) && !plan.change.contains(&name) {
+            continue;
+        }
+        if let Err(e) = state.admission.admit_pod(&mut pod).await {
+            errors.push(format!("create {}: {}", name, e));
+            continue;
+        }
+        match state.aggregator.create_pod(&pod).await {
+            Ok(_) => state.activity.record("create", "pod", ns, &name, user, &note),
+            Err(e) => errors.push(format!("create {}: {}", name, e)),
+        }
+    }
+    errors
+}
+
+/// Bundles found on the cluster, with the pods each one owns.
+pub async fn handle_list_bundles(State(state): State<AppState>) -> Response {
+    match state.aggregator.list_all_pods().await {
+        Ok(pods) => {
+            let mut out: std::collections::BTreeMap<String, Vec<String>> = Default::default();
+            for p in &pods {
+                if let Some(b) = bundles::owner(p) {
+                    out.entry(format!("{}/{}", p.metadata.namespace, b))
+                        .or_default()
+                        .push(p.metadata.name.clone());
+                }
+            }
+            out.values_mut().for_each(|v| v.sort());
+            Json(out).into_response()
+        }
+        Err(e) => (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    }
+}
+
+/// A claim for a new node: its address and the code it shows, or the
+/// payload of its claim QR code.
+#[derive(Debug, Default, Deserialize)]
+pub struct ClaimRequest {
+    #[serde(default)]
+    pub address: String,
+    #[serde(default)]
+    pub code: String,
+    #[serde(default)]
+    pub payload: String,
+}
+
+/// Claims a node and records it in the activity feed. Shared by the API
+/// and the claim page.
+pub async fn claim_node(state: &AppState, headers: &HeaderMap, req: &ClaimRequest) -> Result<ClaimedNode, String> {
+    if state.config.follow.is_some() {
+        return Err("this console follows another one; claim nodes there".to_string());
+    }
+    let (address, code) = if req.payload.trim().is_empty() {
+        (req.address.clone(), req.code.clone())
+    } else {
+        claims::parse_payload(&req.payload)?
+    };
+    // The URL this console was reached at, for the node to call back on.
+    let host = headers.get(header::HOST).and_then(|v| v.to_str().ok()).unwrap_or_default();
+    let proto = headers
+        .get("x-forwarded-proto")
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or("http");
+    let console = format!("{}://{}", proto, host);
+
+    let user = request_user(headers);
+    let node = state
+        .claims
+        .claim(&state.aggregator, &address, &code, &console, &user)
+        .await?;
+    state
+        .activity
+        .record("claim", "node", "", &node.name, &user, &format!("claimed at {}", node.address));
+    Ok(node)
+}
+
+pub async fn handle_list_claims(State(state): State<AppState>) -> Response {
+    let nodes: Vec<ClaimedNode> = state.claims.list().iter().map(ClaimedNode::redacted).collect();
+    Json(nodes).into_response()
+}
+
+pub async fn handle_claim_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<ClaimRequest>,
+) -> Response {
+    match claim_node(&state, &headers, &req).await {
+        Ok(node) => (StatusCode::CREATED, Json(node.redacted())).into_response(),
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_list_bootstrap_tokens(State(state): State<AppState>) -> Response {
+    Json(state.bootstrap.list()).into_response()
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct BootstrapTokenRequest {
+    #[serde(default)]
+    pub description: String,
+    #[serde(default = "default_bootstrap_ttl")]
+    pub ttl_secs: i64,
+    #[serde(default = "default_bootstrap_uses")]
+    pub uses: u32,
+}
+
+fn default_bootstrap_ttl() -> i64 {
+    DEFAULT_TTL_SECS
+}
+
+fn default_bootstrap_uses() -> u32 {
+    1
+}
+
+/// A freshly minted token; the only time the token itself is returned.
+#[derive(Serialize)]
+struct MintedToken {
+    #[serde(flatten)]
+    record: BootstrapToken,
+    token: String,
+}
+
+pub async fn handle_create_bootstrap_token(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Json(req): Json<BootstrapTokenRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    match state.bootstrap.mint(None, &req.description, req.ttl_secs, req.uses, &user) {
+        Ok((record, token)) => {
+            state.activity.record(
+                "create",
+                "bootstrap-token",
+                "",
+                &record.id,
+                &user,
+                &format!("{} use(s), expires {}", record.uses_left, record.expires_at.to_rfc3339()),
+            );
+            (StatusCode::CREATED, Json(MintedToken { record, token })).into_response()
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_revoke_bootstrap_token(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(id): Path<String>,
+) -> Response {
+    if state.bootstrap.revoke(&id) {
+        state
+            .activity
+            .record("delete", "bootstrap-token", "", &id, &request_user(&headers), "revoked");
+        StatusCode::NO_CONTENT.into_response()
+    } else {
+        (StatusCode::NOT_FOUND, format!("bootstrap token {} not found", id)).into_response()
+    }
+}
+
+/// What a node agent sends to register itself or report a new address.
+/// Nodes that can't be reached set `tunnel` and connect in push mode.
+#[derive(Debug, Deserialize)]
+pub struct RegisterRequest {
+    #[serde(default)]
+    pub name: String,
+    #[serde(default)]
+    pub address: String,
+    #[serde(default)]
+    pub tunnel: bool,
+}
+
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct RegisterResponse {
+    name: String,
+    address: String,
+    tunnel: bool,
+    /// Bearer token the console calls the node with.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    api_token: Option<String>,
+    /// Token the node re-registers with.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    agent_token: Option<String>,
+}
+
+/// Push registration for node agents, authenticated by bearer token rather
+/// than the console's user headers. A bootstrap token registers a new node
+/// (201, with its tokens); the agent token it gets back moves an already
+/// registered node to a new address (200).
+pub async fn handle_register_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    ConnectInfo(remote): ConnectInfo<SocketAddr>,
+    Json(req): Json<RegisterRequest>,
+) -> Response {
+    if state.config.follow.is_some() {
+        return (StatusCode::CONFLICT, "this console follows another one; register nodes there").into_response();
+    }
+    let ip = client_addr(&state.config, remote.ip(), &headers);
+    if let Some(retry) = state.login_throttle.locked(&ip) {
+        return too_many_attempts(retry);
+    }
+    let token = bearer_token(&headers);
+    if token.is_empty() {
+        state.login_throttle.failed(&ip, &req.name, "nodes/register", "no token");
+        return (StatusCode::UNAUTHORIZED, "a bootstrap or agent token is required").into_response();
+    }
+
+    if let Some(name) = state.claims.agent_node(token) {
+        state.login_throttle.succeeded(&ip);
+        return match state.claims.update_address(&state.aggregator, &name, &req.address, req.tunnel).await {
+            Ok((node, changed)) => {
+                if changed {
+                    let detail = if node.tunnel {
+                        "switched to push mode".to_string()
+                    } else {
+                        format!("moved to {}", node.address)
+                    };
+                    state.activity.record("register", "node", "", &node.name, &node.name, &detail);
+                }
+                Json(RegisterResponse {
+                    name: node.name,
+                    address: node.address,
+                    tunnel: node.tunnel,
+                    api_token: None,
+                    agent_token: None,
+                })
+                .into_response()
+            }
+            Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+        };
+    }
+
+    let Some(id) = state.bootstrap.reserve(token) else {
+        state.login_throttle.failed(&ip, &req.name, "nodes/register", "invalid token");
+        return (StatusCode::UNAUTHORIZED, "the token is invalid, expired or used up").into_response();
+    };
+    state.login_throttle.succeeded(&ip);
+    let by = format!("bootstrap token {}", id);
+    let (node, agent_token) = match state
+        .claims
+        .register(&state.aggregator, &req.name, &req.address, req.tunnel, &by)
+        .await
+    {
+        Ok(r) => r,
+        Err(e) => {
+            // Only used up once the node is in, so a rejected registration
+            // can be retried with the same token.
+            state.bootstrap.release(&id);
+            return (StatusCode::BAD_REQUEST, e).into_response();
+        }
+    };
+    state.bootstrap.redeem(&id);
+    let detail = if node.tunnel {
+        "registered in push mode".to_string()
+    } else {
+        format!("registered at {}", node.address)
+    };
+    state.activity.record("register", "node", "", &node.name, &by, &detail);
+    (
+        StatusCode::CREATED,
+        Json(RegisterResponse {
+            name: node.name,
+            address: node.address,
+            tunnel: node.tunnel,
+            api_token: Some(node.token),
+            agent_token: Some(agent_token),
+        }),
+    )
+        .into_response()
+}
+
+/// Push-mode connection: a registered node's agent opens a WebSocket here
+/// with its agent token, and the console reaches the node over it.
+pub async fn handle_connect_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    ConnectInfo(remote): ConnectInfo<SocketAddr>,
+    ws: WebSocketUpgrade,
+) -> Response {
+    let ip = client_addr(&state.config, remote.ip(), &headers);
+    if let Some(retry) = state.login_throttle.locked(&ip) {
+        return too_many_attempts(retry);
+    }
+    let token = bearer_token(&headers);
+    let Some(name) = state.claims.agent_node(token).filter(|_| !token.is_empty()) else {
+        let reason = if token.is_empty() { "no token" } else { "invalid token" };
+        state.login_throttle.failed(&ip, "", "nodes/connect", reason);
+        return (StatusCode::UNAUTHORIZED, "an agent token is required").into_response();
+    };
+    state.login_throttle.succeeded(&ip);
+    if !state.claims.list().iter().any(|n| n.name == name && n.tunnel) {
+        return (
+            StatusCode::CONFLICT,
+            format!("node {} is not in push mode; register it with tunnel set", name),
+        )
+            .into_response();
+    }
+    let tunnels = state.tunnels.clone();
+    ws.on_upgrade(move |socket| async move { tunnels.attach(name, ip, socket).await })
+}
+
+// 429 for a client locked out by the login throttle (throttle.rs).
+fn too_many_attempts(retry_secs: i64) -> Response {
+    (
+        StatusCode::TOO_MANY_REQUESTS,
+        [(header::RETRY_AFTER, retry_secs.to_string())],
+        format!("too many failed attempts; try again in {}s", retry_secs),
+    )
+        .into_response()
+}
+
+pub async fn handle_list_tunnels(State(state): State<AppState>) -> Response {
+    Json(state.tunnels.list()).into_response()
+}
+
+// --- Managed objects, for infrastructure-as-code tools ---
+//
+// Terraform/OpenTofu-style CRUD over what the console itself keeps: the
+// nodes it polls, app bundles, alert rules and bootstrap tokens. Each
+// object lives at a path naming it by an ID the client chose. GET returns
+// it with an ETag; PUT creates (201) or replaces (200) it and changes
+// nothing when the object already matches; DELETE removes it, or answers
+// 404 if it's gone. If-Match makes a PUT or DELETE conditional on the ETag
+// the client last read, and If-None-Match: * makes a PUT create-only;
+// either fails with 412.
+
+// Checks a PUT's or DELETE's If-Match and If-None-Match against the
+// object's current ETag, None when it doesn't exist.
+fn check_preconditions(headers: &HeaderMap, current: Option<&str>) -> Result<(), Response> {
+    let listed = |name: header::HeaderName| {
+        headers
+            .get(name)
+            .and_then(|v| v.to_str().ok())
+            .map(|v| v.split(',').any(|t| t.trim() == "*" || Some(t.trim()) == current))
+    };
+    let if_match = listed(header::IF_MATCH);
+    if if_match.is_some() && (current.is_none() || if_match == Some(false)) {
+        return Err((StatusCode::PRECONDITION_FAILED, "the object changed or is gone; read it again").into_response());
+    }
+    if current.is_some() && listed(header::IF_NONE_MATCH) == Some(true) {
+        return Err((StatusCode::PRECONDITION_FAILED, "the object already exists").into_response());
+    }
+    Ok(())
+}
+
+// A managed object with its ETag.
+fn tagged<T: Serialize>(status: StatusCode, tag: &str, value: &T) -> Response {
+    (status, [(header::ETAG, tag.to_string())], Json(value)).into_response()
+}
+
+// A GET of a managed object: 304 when If-None-Match already has its ETag.
+fn read_tagged<T: Serialize>(headers: &HeaderMap, tag: &str, value: &T) -> Response {
+    let cached = headers
+        .get(header::IF_NONE_MATCH)
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|v| v.split(',').any(|t| t.trim() == tag));
+    if cached {
+        return (StatusCode::NOT_MODIFIED, [(header::ETAG, tag.to_string())]).into_response();
+    }
+    tagged(StatusCode::OK, tag, value)
+}
+
+fn created_or_ok(created: bool) -> StatusCode {
+    if created { StatusCode::CREATED } else { StatusCode::OK }
+}
+
+/// A node the console polls at `address` with the bearer token it was
+/// provisioned with.
+#[derive(Debug, Deserialize)]
+pub struct ManagedNodeSpec {
+    #[serde(default)]
+    pub address: String,
+    #[serde(default)]
+    pub token: String,
+}
+
+// Covers the token by its hash, so rotating it changes the ETag without
+// the token ever being sent back.
+fn node_etag(n: &ClaimedNode) -> String {
+    etag(&(&n.name, &n.address, n.tunnel, claims::token_hash(&n.token)))
+}
+
+fn managed_node(state: &AppState, name: &str) -> Option<ClaimedNode> {
+    state.claims.list().into_iter().find(|n| n.name == name)
+}
+
+pub async fn handle_get_managed_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    match managed_node(&state, &name) {
+        Some(n) => read_tagged(&headers, &node_etag(&n), &n.redacted()),
+        None => (StatusCode::NOT_FOUND, format!("node {} is not managed by the console", name)).into_response(),
+    }
+}
+
+/// Adds a node or moves it to a new address or token. Nodes from the
+/// config file can't be managed here.
+pub async fn handle_put_managed_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+    Json(spec): Json<ManagedNodeSpec>,
+) -> Response {
+    if state.config.follow.is_some() {
+        return (StatusCode::CONFLICT, "this console follows another one; manage nodes there").into_response();
+    }
+    let current = managed_node(&state, &name).map(|n| node_etag(&n));
+    if current.is_none() && state.aggregator.snapshot_clients().await.iter().any(|c| c.name == name) {
+        return (StatusCode::CONFLICT, format!("node {} is in the config file", name)).into_response();
+    }
+    if let Err(resp) = check_preconditions(&headers, current.as_deref()) {
+        return resp;
+    }
+    let user = request_user(&headers);
+    match state.claims.put(&state.aggregator, &name, &spec.address, &spec.token, &user).await {
+        Ok((node, created)) => {
+            let tag = node_etag(&node);
+            if current.as_deref() != Some(tag.as_str()) {
+                let action = if created { "create" } else { "update" };
+                state
+                    .activity
+                    .record(action, "node", "", &name, &user, &format!("at {}", node.address));
+            }
+            tagged(created_or_ok(created), &tag, &node.redacted())
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+/// Forgets a node and stops polling it; the node keeps running its pods.
+pub async fn handle_delete_managed_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    let Some(current) = managed_node(&state, &name).map(|n| node_etag(&n)) else {
+        return (StatusCode::NOT_FOUND, format!("node {} is not managed by the console", name)).into_response();
+    };
+    if let Err(resp) = check_preconditions(&headers, Some(&current)) {
+        return resp;
+    }
+    if state.claims.remove(&state.aggregator, &name).await.is_none() {
+        return (StatusCode::NOT_FOUND, format!("node {} is not managed by the console", name)).into_response();
+    }
+    state
+        .activity
+        .record("delete", "node", "", &name, &request_user(&headers), "removed");
+    StatusCode::NO_CONTENT.into_response()
+}
+
+/// A bundle as the cluster has it: the pods it owns, each with the hash
+/// of the definition it was applied from.
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ManagedBundle {
+    pub name: String,
+    pub namespace: String,
+    pub pods: BTreeMap<String, String>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct ManagedBundleSpec {
+    #[serde(default)]
+    pub pods: Vec<Pod>,
+}
+
+// Bundle `name` in `namespace` among `pods`, or None when it owns none.
+fn owned_bundle(pods: &[Pod], namespace: &str, name: &str) -> Option<ManagedBundle> {
+    let owned = pods
+        .iter()
+        .filter(|p| p.metadata.namespace == namespace && bundles::owner(p) == Some(name));
+    let pods = bundles::fingerprint(owned);
+    (!pods.is_empty()).then(|| ManagedBundle {
+        name: name.to_string(),
+        namespace: namespace.to_string(),
+        pods,
+    })
+}
+
+pub async fn handle_get_managed_bundle(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let pods = match state.aggregator.list_all_pods().await {
+        Ok(pods) => pods,
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    match owned_bundle(&pods, &namespace, &name) {
+        Some(b) => read_tagged(&headers, &etag(&b), &b),
+        None => (StatusCode::NOT_FOUND, format!("bundle {}/{} owns no pods", namespace, name)).into_response(),
+    }
+}
+
+/// Applies a bundle as handle_apply_bundle does, always pruning, so the
+/// cluster ends up with exactly the pods in the request.
+pub async fn handle_put_managed_bundle(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(spec): Json<ManagedBundleSpec>,
+) -> Response {
+    if spec.pods.is_empty() {
+        return (StatusCode::BAD_REQUEST, "a bundle needs at least one pod; delete it instead").into_response();
+    }
+    let bundle = AppBundle {
+        name,
+        namespace,
+        pods: spec.pods,
+    };
+    let desired = match bundles::prepare(&bundle) {
+        Ok(pods) => pods,
+        Err(e) => return (StatusCode::BAD_REQUEST, e).into_response(),
+    };
+    let existing = match state.aggregator.list_all_pods().await {
+        Ok(pods) => pods,
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    let current = owned_bundle(&existing, &bundle.namespace, &bundle.name).map(|b| etag(&b));
+    if let Err(resp) = check_preconditions(&headers, current.as_deref()) {
+        return resp;
+    }
+    let plan = bundles::plan(&bundle, &desired, &existing, true);
+    if !plan.conflicts.is_empty() {
+        let msg = format!("pods exist that this bundle does not own: {}", plan.conflicts.join(", "));
+        return (StatusCode::CONFLICT, msg).into_response();
+    }
+
+    let applied = ManagedBundle {
+        name: bundle.name.clone(),
+        namespace: bundle.namespace.clone(),
+        pods: bundles::fingerprint(&desired),
+    };
+    if !plan.is_noop() {
+        let errors = apply_bundle(&state, &request_user(&headers), &bundle, desired, &plan).await;
+        if !errors.is_empty() {
+            return (StatusCode::BAD_GATEWAY, errors.join("; ")).into_response();
+        }
+    }
+    tagged(created_or_ok(current.is_none()), &etag(&applied), &applied)
+}
+
+/// Deletes every pod the bundle owns.
+pub async fn handle_delete_managed_bundle(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let pods = match state.aggregator.list_all_pods().await {
+        Ok(pods) => pods,
+        Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response(),
+    };
+    let Some(bundle) = owned_bundle(&pods, &namespace, &name) else {
+        return (StatusCode::NOT_FOUND, format!("bundle {}/{} owns no pods", namespace, name)).into_response();
+    };
+    if let Err(resp) = check_preconditions(&headers, Some(&etag(&bundle))) {
+        return resp;
+    }
+    let user = request_user(&headers);
+    let note = format!("bundle {} deleted", name);
+    let mut errors = Vec::new();
+    for pod in bundle.pods.keys() {
+        match state.aggregator.delete_pod(&namespace, pod).await {
+            Ok(()) => state.activity.record("delete", "pod", &namespace, pod, &user, &note),
+            Err(e) => errors.push(format!("delete {}: {}", pod, e)),
+        }
+    }
+    if !errors.is_empty() {
+        return (StatusCode::BAD_GATEWAY, errors.join("; ")).into_response();
+    }
+    StatusCode::NO_CONTENT.into_response()
+}
+
+pub async fn handle_get_managed_alert_rule(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    match state.alert_rule_store.get(&name) {
+        Some(rule) => read_tagged(&headers, &etag(&rule), &rule),
+        None => (StatusCode::NOT_FOUND, format!("alert rule {} not found", name)).into_response(),
+    }
+}
+
+/// Creates or replaces an alert rule, written as under alert_rules in the
+/// config. Rules the config file defines can't be replaced here.
+pub async fn handle_put_managed_alert_rule(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+    Json(mut rule): Json<AlertRuleConfig>,
+) -> Response {
+    rule.name = name;
+    if state.alert_rules.in_config(&rule.name) {
+        let msg = format!("alert rule {} is defined in the config file", rule.name);
+        return (StatusCode::CONFLICT, msg).into_response();
+    }
+    let current = state.alert_rule_store.get(&rule.name).map(|r| etag(&r));
+    if let Err(resp) = check_preconditions(&headers, current.as_deref()) {
+        return resp;
+    }
+    let tag = etag(&rule);
+    if current.as_deref() == Some(tag.as_str()) {
+        return tagged(StatusCode::OK, &tag, &rule);
+    }
+    match state.alert_rule_store.put(rule.clone()) {
+        Ok(created) => {
+            let action = if created { "create" } else { "update" };
+            state
+                .activity
+                .record(action, "alert-rule", "", &rule.name, &request_user(&headers), &rule.expr);
+            tagged(created_or_ok(created), &tag, &rule)
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_delete_managed_alert_rule(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    let Some(current) = state.alert_rule_store.get(&name).map(|r| etag(&r)) else {
+        return (StatusCode::NOT_FOUND, format!("alert rule {} not found", name)).into_response();
+    };
+    if let Err(resp) = check_preconditions(&headers, Some(&current)) {
+        return resp;
+    }
+    if state.alert_rule_store.remove(&name).is_none() {
+        return (StatusCode::NOT_FOUND, format!("alert rule {} not found", name)).into_response();
+    }
+    state
+        .activity
+        .record("delete", "alert-rule", "", &name, &request_user(&headers), "deleted");
+    StatusCode::NO_CONTENT.into_response()
+}
+
+pub async fn handle_get_managed_token(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(id): Path<String>,
+) -> Response {
+    match state.bootstrap.get(&id) {
+        Some(t) => read_tagged(&headers, &etag(&t), &t),
+        None => (StatusCode::NOT_FOUND, format!("bootstrap token {} not found", id)).into_response(),
+    }
+}
+
+/// Mints a bootstrap token under the given id; the token itself is only
+/// in the 201 response. Tokens can't be changed, so a PUT to one that
+/// exists returns it as it is: replace a token by deleting it first.
+pub async fn handle_put_managed_token(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(id): Path<String>,
+    Json(req): Json<BootstrapTokenRequest>,
+) -> Response {
+    let existing = state.bootstrap.get(&id);
+    let current = existing.as_ref().map(etag);
+    if let Err(resp) = check_preconditions(&headers, current.as_deref()) {
+        return resp;
+    }
+    if let Some(t) = existing {
+        return tagged(StatusCode::OK, &etag(&t), &t);
+    }
+    let user = request_user(&headers);
+    match state.bootstrap.mint(Some(&id), &req.description, req.ttl_secs, req.uses, &user) {
+        Ok((record, token)) => {
+            state.activity.record(
+                "create",
+                "bootstrap-token",
+                "",
+                &record.id,
+                &user,
+                &format!("{} use(s), expires {}", record.uses_left, record.expires_at.to_rfc3339()),
+            );
+            tagged(StatusCode::CREATED, &etag(&record), &MintedToken { record, token })
+        }
+        Err(e) => (StatusCode::BAD_REQUEST, e).into_response(),
+    }
+}
+
+pub async fn handle_delete_managed_token(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(id): Path<String>,
+) -> Response {
+    let Some(current) = state.bootstrap.get(&id).map(|t| etag(&t)) else {
+        return (StatusCode::NOT_FOUND, format!("bootstrap token {} not found", id)).into_response();
+    };
+    if let Err(resp) = check_preconditions(&headers, Some(&current)) {
+        return resp;
+    }
+    handle_revoke_bootstrap_token(State(state), headers, Path(id)).await
+}
+
+// --- Console as code (see desired.rs) ---
+
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ConfigApplyResult {
+    pub plan: desired::ConfigPlan,
+    pub applied: bool,
+    pub errors: Vec<String>,
+}
+
+// What the console has now, in the document's shape.
+fn current_config(state: &AppState) -> DesiredConfig {
+    let settings = state.settings.get();
+    DesiredConfig {
+        nodes: state
+            .claims
+            .list()
+            .into_iter()
+            .map(|n| DesiredNode {
+                name: n.name,
+                address: n.address,
+                token: n.token,
+            })
+            .collect(),
+        users: settings.users,
+        alert_rules: state.alert_rule_store.list(),
+        event_hooks: settings.event_hooks,
+    }
+}
+
+/// Applies a console configuration document, in JSON or YAML: diffs it
+/// against the console, checks all of it, and only then changes nodes,
+/// user roles, alert rules and event hooks, all of them or none. With
+/// dryRun only the plan is returned, and a reported commit is left
+/// pending while it has changes; without prune, what the document leaves
+/// out is kept.
+pub async fn handle_apply_config(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Query(query): Query<ApplyQuery>,
+    body: Bytes,
+) -> Response {
+    if state.config.follow.is_some() {
+        return (StatusCode::CONFLICT, "this console follows another one; apply there").into_response();
+    }
+    if let Err(resp) = query.check_commit() {
+        return resp;
+    }
+    let mut doc: DesiredConfig = match serde_yaml::from_slice(&body) {
+        Ok(doc) => doc,
+        Err(e) => {
+            let msg = format!("parsing the document: {}", e);
+            report_commit(&state, &query, false, &msg);
+            return (StatusCode::BAD_REQUEST, msg).into_response();
+        }
+    };
+    if let Err(e) = desired::validate(&mut doc, &state.config) {
+        report_commit(&state, &query, false, &e);
+        return (StatusCode::BAD_REQUEST, e).into_response();
+    }
+    let current = current_config(&state);
+    let plan = desired::plan(&current, &doc, query.prune);
+    let count = |s: &desired::SectionPlan| s.add.len() + s.change.len() + s.delete.len();
+    let summary = format!(
+        "{} node(s), {} user(s), {} alert rule(s) and {} event hook(s) changed",
+        count(&plan.nodes),
+        count(&plan.users),
+        count(&plan.alert_rules),
+        count(&plan.event_hooks)
+    );
+    if plan.is_noop() {
+        report_commit(&state, &query, true, "console configuration is up to date");
+    } else if query.dry_run {
+        report_pending(&state, &query, &format!("not applied yet: {}", summary));
+    }
+    if query.dry_run || plan.is_noop() {
+        return Json(ConfigApplyResult {
+            plan,
+            applied: false,
+            errors: Vec::new(),
+        })
+        .into_response();
+    }
+
+    // Stage everything and check it before the first change; nodes go
+    // first as their store write is what can still fail, and they are
+    // put back if the rest doesn't take.
+    let target = desired::merged(&current, &doc, query.prune);
+    if let Err(e) = alert_rules::compile(&target.alert_rules) {
+        report_commit(&state, &query, false, &e);
+        return (StatusCode::BAD_REQUEST, e).into_response();
+    }
+    let user = request_user(&headers);
+    let declared: Vec<DesiredNode> = target
+        .nodes
+        .iter()
+        .filter(|n| plan.nodes.add.contains(&n.name) || plan.nodes.change.contains(&n.name))
+        .cloned()
+        .collect();
+    let declared = state.claims.declare(&state.aggregator, &declared, &plan.nodes.delete, &user).await;
+    let previous = match declared {
+        Ok(previous) => previous,
+        Err(e) => {
+            report_commit(&state, &query, false, &e);
+            return (StatusCode::INTERNAL_SERVER_ERROR, e).into_response();
+        }
+    };
+    if let Err(e) = state.alert_rule_store.replace(target.alert_rules) {
+        state.claims.restore(&state.aggregator, previous).await;
+        report_commit(&state, &query, false, &e);
+        return (StatusCode::BAD_REQUEST, e).into_response();
+    }
+    state.settings.set(Settings {
+        users: target.users,
+        event_hooks: target.event_hooks,
+    });
+
+    state.activity.record("apply", "console-config", "", "", &user, &summary);
+    report_commit(&state, &query, true, &summary);
+    Json(ConfigApplyResult {
+        plan,
+        applied: true,
+        errors: Vec::new(),
+    })
+    .into_response()
+}
+
+// --- Manifest linting (see lint.rs) ---
+
+/// Lints a pod manifest, in JSON or YAML, without creating anything: the
+/// errors that would refuse it and the warnings that would come back.
+pub async fn handle_lint(body: Bytes) -> Response {
+    let raw: serde_json::Value = match serde_yaml::from_slice(&body) {
+        Ok(raw) => raw,
+        Err(e) => return (StatusCode::BAD_REQUEST, format!("parsing the manifest: {}", e)).into_response(),
+    };
+    let (_, findings) = lint::lint_pod_manifest(&raw);
+    Json(serde_json::json!({ "findings": findings })).into_response()
+}
+
+// Refuses a manifest with lint errors, listing every finding.
+fn lint_failed(findings: Vec<Finding>) -> Response {
+    let body = serde_json::json!({
+        "message": "the manifest has errors",
+        "findings": findings,
+    });
+    (StatusCode::UNPROCESSABLE_ENTITY, Json(body)).into_response()
+}
+
+// Passes lint warnings on as Warning headers, which kubectl prints.
+fn with_warnings(findings: &[Finding], mut resp: Response) -> Response {
+    for f in findings {
+        if let Ok(v) = HeaderValue::from_str(&lint::warning_header(f)) {
+            resp.headers_mut().append(header::WARNING, v);
+        }
+    }
+    resp
+}
+
+// --- Deploy hooks for CI (see deployhooks.rs) ---
+
+/// Longest a deploy hook may wait for its rollout.
+const MAX_DEPLOY_WAIT_SECS: u64 = 600;
+
+/// Rolls an app over to an image a CI system built. Authenticated by one
+/// of config.deploy_hooks' bearer tokens rather than a user. Answers with
+/// the rollout status: 200 once complete, 202 while it still rolls out,
+/// 504 when waitSecs ran out first and 502 when pods couldn't be
+/// restarted.
+pub async fn handle_deploy_hook(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    ConnectInfo(remote): ConnectInfo<SocketAddr>,
+    Json(req): Json<DeployRequest>,
+) -> Response {
+    let ip = client_addr(&state.config, remote.ip(), &headers);
+    if let Some(retry) = state.login_throttle.locked(&ip) {
+        return too_many_attempts(retry);
+    }
+    let token = bearer_token(&headers);
+    let hash = claims::token_hash(token);
+    let hook = state
+        .config
+        .deploy_hooks
+        .iter()
+        .find(|h| !token.is_empty() && claims::token_hash(&h.token) == hash);
+    let Some(hook) = hook else {
+        let reason = if token.is_empty() { "no token" } else { "invalid token" };
+        state.login_throttle.failed(&ip, "", "hooks/deploy", reason);
+        return (StatusCode::UNAUTHORIZED, "a deploy hook token is required").into_response();
+    };
+    state.login_throttle.succeeded(&ip);
+
+    let (namespace, name) = req.target();
+    if !hook.namespaces.is_empty() && !hook.namespaces.contains(&namespace) {
+        let msg = format!("deploy hook {} may not deploy to namespace {}", hook.name, namespace);
+        return (StatusCode::FORBIDDEN, msg).into_response();
+    }
+    let image = req.image.trim();
+    if image.is_empty() {
+        return (StatusCode::BAD_REQUEST, "image is required").into_response();
+    }
+    let by = format!("deploy hook {}", hook.name);
+
+    let (kind, errors) = if let Some(mut d) = state.deployments.get(&namespace, &name) {
+        let changed = match deployhooks::set_image(&mut d.spec.template.spec, image, req.container.as_deref()) {
+            Ok(changed) => changed,
+            Err(e) => return (StatusCode::BAD_REQUEST, e).into_response(),
+        };
+        if !changed.is_empty() {
+            d.metadata.resource_version.clear();
+            if let Err(e) = state.deployments.update(&namespace, &name, d) {
+                return deployment_error(e, &name);
+            }
+            state.activity.record("deploy", "deployment", &namespace, &name, &by, image);
+        }
+        ("deployment", Vec::new())
+    } else {
+        match deploy_bundle(&state, &namespace, &name, &req, &by).await {
+            Ok(errors) => ("bundle", errors),
+            Err(resp) => return resp,
+        }
+    };
+
+    let deadline = Instant::now() + Duration::from_secs(req.wait_secs.min(MAX_DEPLOY_WAIT_SECS));
+    loop {
+        let mut status = match deploy_progress(&state, kind, &namespace, &name, &req).await {
+            Ok(s) => s,
+            Err(e) => return (StatusCode::INTERNAL_SERVER_ERROR, e).into_response(),
+        };
+        status.errors = errors.clone();
+        let code = if !errors.is_empty() {
+            StatusCode::BAD_GATEWAY
+        } else if status.complete {
+            StatusCode::OK
+        } else if Instant::now() < deadline {
+            tokio::time::sleep(Duration::from_secs(3)).await;
+            continue;
+        } else if req.wait_secs > 0 {
+            StatusCode::GATEWAY_TIMEOUT
+        } else {
+            StatusCode::ACCEPTED
+        };
+        return (code, Json(status)).into_response();
+    }
+}
+
+// Restarts the bundle's pods whose containers the request means with the
+// new image, as a config rollout restarts pods. Returns what failed.
+async fn deploy_bundle(
+    state: &AppState,
+    namespace: &str,
+    name: &str,
+    req: &DeployRequest,
+    by: &str,
+) -> Result<Vec<String>, Response> {
+    let pods = state
+        .aggregator
+        .list_all_pods()
+        .await
+        .map_err(|e| (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()).into_response())?;
+    let owned: Vec<&Pod> = pods
+        .iter()
+        .filter(|p| p.metadata.namespace == namespace && bundles::owner(p) == Some(name))
+        .collect();
+    if owned.is_empty() {
+        let msg = format!("no deployment or bundle named {}/{}", namespace, name);
+        return Err((StatusCode::NOT_FOUND, msg).into_response());
+    }
+
+    let image = req.image.trim();
+    let mut matched = false;
+    let mut errors = Vec::new();
+    for pod in owned {
+        let mut replacement = stuck::replacement(pod);
+        let Ok(changed) = deployhooks::set_image(&mut replacement.spec, image, req.container.as_deref()) else {
+            continue;
+        };
+        matched = true;
+        if changed.is_empty() {
+            continue;
+        }
+        let node = pod
+            .metadata
+            .annotations
+            .as_ref()
+            .and_then(|a| a.get("mkube.io/node"))
+            .cloned()
+            .unwrap_or_else(|| pod.spec.node_name.clone());
+        // Resolve secrets afresh, or the new pod gets the old values.
+        secrets::unresolve(&mut replacement);
+        let result = match state.secrets.resolve_pod(&mut replacement).await {
+            Ok(_) => restarts::restart_pod(&state.aggregator, &node, pod, replacement, RestartMode::Restart).await,
+            Err(errs) => Err(format!("resolving secrets: {}", errs.join("; "))),
+        };
+        match result {
+            Ok(_) => state
+                .activity
+                .record("deploy", "pod", namespace, &pod.metadata.name, by, image),
+            Err(e) => errors.push(format!("{}: {}", pod.metadata.name, e)),
+        }
+    }
+    if !matched {
+        let msg = format!(
+            "no pod of bundle {} runs that image; name the container to update",
+            name
+        );
+        return Err((StatusCode::BAD_REQUEST, msg).into_response());
+    }
+    Ok(errors)
+}
+
+// How far a deploy has got: the pods it concerns, those on the new image,
+// and those of them ready.
+async fn deploy_progress(
+    state: &AppState,
+    kind: &'static str,
+    namespace: &str,
+    name: &str,
+    req: &DeployRequest,
+) -> Result<RolloutStatus, String> {
+    let pods = state.aggregator.list_all_pods().await.map_err(|e| e.to_string())?;
+    let image = req.image.trim();
+    let (desired, updated, ready, complete) = if kind == "deployment" {
+        let Some(d) = state.deployments.get(namespace, name) else {
+            return Err(format!("deployment {} was deleted", name));
+        };
+        let desired = d.spec.replicas.max(0) as usize;
+        let (owned, updated, ready) = rollout_progress(&d, &pods);
+        (desired, updated, ready, owned == desired && ready == desired)
+    } else {
+        let (mut desired, mut updated, mut ready) = (0, 0, 0);
+        let owned = pods
+            .iter()
+            .filter(|p| p.metadata.namespace == namespace && bundles::owner(p) == Some(name));
+        for p in owned {
+            let mut spec = p.spec.clone();
+            let Ok(changed) = deployhooks::set_image(&mut spec, image, req.container.as_deref()) else {
+                continue;
+            };
+            desired += 1;
+            if changed.is_empty() {
+                updated += 1;
+                if p.status.phase == "Running" {
+                    ready += 1;
+                }
+            }
+        }
+        (desired, updated, ready, ready == desired)
+    };
+    Ok(RolloutStatus {
+        kind,
+        namespace: namespace.to_string(),
+        name: name.to_string(),
+        image: image.to_string(),
+        desired,
+        updated,
+        ready,
+        complete,
+        errors: Vec::new(),
+    })
+}
+
+// --- coordination.k8s.io Leases, kept by the console (see leases.rs) ---
+
+pub async fn handle_api_groups(State(state): State<AppState>) -> Response {
+    let mut versions: BTreeMap<&str, Vec<&str>> = BTreeMap::new();
+    versions.insert("apps", vec!["v1"]);
+    versions.insert("batch", vec!["v1"]);
+    versions.insert("coordination.k8s.io", vec!["v1"]);
+    for d in state.custom.defs() {
+        let v = versions.entry(&d.group).or_default();
+        if !v.contains(&d.version.as_str()) {
+            v.push(&d.version);
+        }
+    }
+    let mut groups = Vec::new();
+    for (group, v) in versions {
+        let versions: Vec<_> = v
+            .iter()
+            .map(|v| serde_json::json!({"groupVersion": format!("{}/{}", group, v), "version": v}))
+            .collect();
+        groups.push(serde_json::json!({
+            "name": group,
+            "preferredVersion": versions[0],
+            "versions": versions,
+        }));
+    }
+    Json(serde_json::json!({
+        "kind": "APIGroupList",
+        "apiVersion": "v1",
+        "groups": groups,
+    }))
+    .into_response()
+}
+
+pub async fn handle_coordination_resources() -> Json<ApiResourceList> {
+    Json(ApiResourceList {
+        kind: "APIResourceList".to_string(),
+        group_version: "coordination.k8s.io/v1".to_string(),
+        api_resources: vec![ApiResource {
+            name: "leases".to_string(),
+            namespaced: true,
+            kind: "Lease".to_string(),
+            verbs: vec![
+                "get".to_string(),
+                "list".to_string(),
+                "create".to_string(),
+                "update".to_string(),
+                "delete".to_string(),
+            ],
+        }],
+    })
+}
+
+pub async fn handle_list_all_leases(State(state): State<AppState>) -> Response {
+    lease_list(&state, None)
+}
+
+pub async fn handle_list_leases(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    lease_list(&state, Some(&namespace))
+}
+
+fn lease_list(state: &AppState, namespace: Option<&str>) -> Response {
+    let (items, version) = state.leases.list(namespace);
+    Json(LeaseList {
+        type_meta: TypeMeta {
+            api_version: "coordination.k8s.io/v1".to_string(),
+            kind: "LeaseList".to_string(),
+        },
+        metadata: ListMeta {
+            resource_version: version.to_string(),
+        },
+        items,
+    })
+    .into_response()
+}
+
+pub async fn handle_get_lease(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.leases.get(&namespace, &name) {
+        Some(lease) => Json(lease).into_response(),
+        None => lease_error(LeaseError::NotFound, &name),
+    }
+}
+
+pub async fn handle_create_lease(
+    State(state): State<AppState>,
+    Path(namespace): Path<String>,
+    Json(lease): Json<Lease>,
+) -> Response {
+    let name = lease.metadata.name.clone();
+    match state.leases.create(&namespace, lease) {
+        Ok(lease) => (StatusCode::CREATED, Json(lease)).into_response(),
+        Err(e) => lease_error(e, &name),
+    }
+}
+
+// Lease renewals are frequent and not user actions, so unlike other writes
+// they aren't recorded in the activity log.
+pub async fn handle_update_lease(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(lease): Json<Lease>,
+) -> Response {
+    match state.leases.update(&namespace, &name, lease) {
+        Ok(lease) => Json(lease).into_response(),
+        Err(e) => lease_error(e, &name),
+    }
+}
+
+pub async fn handle_delete_lease(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.leases.delete(&namespace, &name) {
+        Ok(_) => Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Success".to_string(),
+            message: format!("lease {:?} deleted", name),
+        })
+        .into_response(),
+        Err(e) => lease_error(e, &name),
+    }
+}
+
+fn lease_error(e: LeaseError, name: &str) -> Response {
+    let (code, message) = match e {
+        LeaseError::NotFound => (StatusCode::NOT_FOUND, format!("lease {:?} not found", name)),
+        LeaseError::AlreadyExists => (StatusCode::CONFLICT, format!("lease {:?} already exists", name)),
+        LeaseError::Conflict => (
+            StatusCode::CONFLICT,
+            format!("lease {:?} has been modified; get it again and retry", name),
+        ),
+        LeaseError::Invalid(m) => (StatusCode::UNPROCESSABLE_ENTITY, m),
+    };
+    (
+        code,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message,
+        }),
+    )
+        .into_response()
+}
+
+// --- batch/v1 Jobs, run by the job controller (see controllers/jobs.rs) ---
+
+pub async fn handle_batch_resources() -> Json<ApiResourceList> {
+    Json(ApiResourceList {
+        kind: "APIResourceList".to_string(),
+        group_version: "batch/v1".to_string(),
+        api_resources: vec![
+            ApiResource {
+                name: "jobs".to_string(),
+                namespaced: true,
+                kind: "Job".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+            ApiResource {
+                name: "cronjobs".to_string(),
+                namespaced: true,
+                kind: "CronJob".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "update".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+        ],
+    })
+}
+
+pub async fn handle_list_all_jobs(State(state): State<AppState>) -> Response {
+    job_list(&state, None)
+}
+
+pub async fn handle_list_jobs(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    job_list(&state, Some(&namespace))
+}
+
+fn job_list(state: &AppState, namespace: Option<&str>) -> Response {
+    let (items, version) = state.jobs.list(namespace);
+    Json(JobList {
+        type_meta: TypeMeta {
+            api_version: "batch/v1".to_string(),
+            kind: "JobList".to_string(),
+        },
+        metadata: ListMeta {
+            resource_version: version.to_string(),
+        },
+        items,
+    })
+    .into_response()
+}
+
+pub async fn handle_get_job(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.jobs.get(&namespace, &name) {
+        Some(job) => Json(job).into_response(),
+        None => job_error(JobError::NotFound, &name),
+    }
+}
+
+pub async fn handle_create_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(namespace): Path<String>,
+    Json(job): Json<Job>,
+) -> Response {
+    let findings = lint::lint_pod_spec(&job.spec.template.spec, "spec.template.spec");
+    if lint::has_errors(&findings) {
+        return lint_failed(findings);
+    }
+    if let Err(e) = admit_job(&state, &namespace, &job).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
+
+    let name = job.metadata.name.clone();
+    match state.jobs.create(&namespace, job) {
+        Ok(job) => {
+            let message = format!("{} completions, parallelism {}", job.spec.completions, job.spec.parallelism);
+            state
+                .activity
+                .record("create", "job", &namespace, &name, &request_user(&headers), &message);
+            with_warnings(&findings, (StatusCode::CREATED, Json(job)).into_response())
+        }
+        Err(e) => job_error(e, &name),
+    }
+}
+
+pub async fn handle_delete_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    if let Err(e) = delete_job(&state, &namespace, &name).await {
+        return job_error(e, &name);
+    }
+    state
+        .activity
+        .record("delete", "job", &namespace, &name, &request_user(&headers), "");
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message: format!("job {:?} deleted", name),
+    })
+    .into_response()
+}
+
+/// Checks a job's pod template passes admission, so a bad secret reference
+/// is refused up front. The template is kept as written; the job controller
+/// admits each pod as it creates it.
+pub async fn admit_job(state: &AppState, namespace: &str, job: &Job) -> Result<(), String> {
+    let mut pod = Pod {
+        metadata: job.spec.template.metadata.clone(),
+        spec: job.spec.template.spec.clone(),
+        ..Default::default()
+    };
+    pod.metadata.namespace = namespace.to_string();
+    state.admission.admit_pod(&mut pod).await
+}
+
+/// Deletes the job and its pods, finished ones included.
+pub async fn delete_job(state: &AppState, namespace: &str, name: &str) -> Result<Job, JobError> {
+    let job = state.jobs.delete(namespace, name)?;
+    for run in &job.status.runs {
+        let _ = state.aggregator.delete_pod(namespace, &run.pod).await;
+    }
+    Ok(job)
+}
+
+fn job_error(e: JobError, name: &str) -> Response {
+    let (code, message) = match e {
+        JobError::NotFound => (StatusCode::NOT_FOUND, format!("job {:?} not found", name)),
+        JobError::AlreadyExists => (StatusCode::CONFLICT, format!("job {:?} already exists", name)),
+        JobError::Invalid(m) => (StatusCode::UNPROCESSABLE_ENTITY, m),
+    };
+    (
+        code,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message,
+        }),
+    )
+        .into_response()
+}
+
+// --- batch/v1 CronJobs, started by the cron job controller (see
+// controllers/cronjobs.rs) ---
+
+pub async fn handle_list_all_cron_jobs(State(state): State<AppState>) -> Response {
+    cron_job_list(&state, None)
+}
+
+pub async fn handle_list_cron_jobs(State(state): State<AppState>, Path(namespace): Path<String>) -> Response {
+    cron_job_list(&state, Some(&namespace))
+}
+
+fn cron_job_list(state: &AppState, namespace: Option<&str>) -> Response {
+    let (items, version) = state.cron_jobs.list(namespace);
+    Json(CronJobList {
+        type_meta: TypeMeta {
+            api_version: "batch/v1".to_string(),
+            kind: "CronJobList".to_string(),
+        },
+        metadata: ListMeta {
+            resource_version: version.to_string(),
+        },
+        items,
+    })
+    .into_response()
+}
+
+pub async fn handle_get_cron_job(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.cron_jobs.get(&namespace, &name) {
+        Some(c) => Json(c).into_response(),
+        None => cron_job_error(CronJobError::NotFound, &name),
+    }
+}
+
+pub async fn handle_create_cron_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(namespace): Path<String>,
+    Json(c): Json<CronJob>,
+) -> Response {
+    if let Err(e) = admit_cron_job(&state, &namespace, &c).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
+    let name = c.metadata.name.clone();
+    match state.cron_jobs.create(&namespace, c) {
+        Ok(c) => {
+            let message = format!("schedule {}", c.spec.schedule);
+            state
+                .activity
+                .record("create", "cronjob", &namespace, &name, &request_user(&headers), &message);
+            (StatusCode::CREATED, Json(c)).into_response()
+        }
+        Err(e) => cron_job_error(e, &name),
+    }
+}
+
+/// Replaces a cron job's spec; setting spec.suspend pauses or resumes it.
+pub async fn handle_update_cron_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(mut c): Json<CronJob>,
+) -> Response {
+    if c.metadata.name.is_empty() {
+        c.metadata.name = name.clone();
+    }
+    if let Err(e) = admit_cron_job(&state, &namespace, &c).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
+    match state.cron_jobs.update(&namespace, &name, c) {
+        Ok(c) => {
+            let message = if c.spec.suspend {
+                "suspended".to_string()
+            } else {
+                format!("schedule {}", c.spec.schedule)
+            };
+            state
+                .activity
+                .record("update", "cronjob", &namespace, &name, &request_user(&headers), &message);
+            Json(c).into_response()
+        }
+        Err(e) => cron_job_error(e, &name),
+    }
+}
+
+// The controller deletes the jobs it started on its next pass.
+pub async fn handle_delete_cron_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    if let Err(e) = state.cron_jobs.delete(&namespace, &name) {
+        return cron_job_error(e, &name);
+    }
+    state
+        .activity
+        .record("delete", "cronjob", &namespace, &name, &request_user(&headers), "");
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message: format!("cron job {:?} deleted", name),
+    })
+    .into_response()
+}
+
+/// Checks a cron job's job template, as admit_job does for a job.
+pub async fn admit_cron_job(state: &AppState, namespace: &str, c: &CronJob) -> Result<(), String> {
+    let job = Job {
+        metadata: c.spec.job_template.metadata.clone(),
+        spec: c.spec.job_template.spec.clone(),
+        ..Default::default()
+    };
+    admit_job(state, namespace, &job).await
+}
+
+fn cron_job_error(e: CronJobError, name: &str) -> Response {
+    let (code, message) = match e {
+        CronJobError::NotFound => (StatusCode::NOT_FOUND, format!("cron job {:?} not found", name)),
+        CronJobError::AlreadyExists => (StatusCode::CONFLICT, format!("cron job {:?} already exists", name)),
+        CronJobError::Conflict => (
+            StatusCode::CONFLICT,
+            format!("cron job {:?} was changed since it was read; get it and try again", name),
+        ),
+        CronJobError::Invalid(m) => (StatusCode::UNPROCESSABLE_ENTITY, m),
+    };
+    (
+        code,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message,
+        }),
+    )
+        .into_response()
+}
+
+// --- apps/v1 Deployments, run by the deployment controller (see
+// controllers/deployments.rs) ---
+
+pub async fn handle_apps_resources() -> Json<ApiResourceList> {
+    Json(ApiResourceList {
+        kind: "APIResourceList".to_string(),
+        group_version: "apps/v1".to_string(),
+        api_resources: vec![
+            ApiResource {
+                name: "deployments".to_string(),
+                namespaced: true,
+                kind: "Deployment".to_string(),
+                verbs: vec![
+                    "get".to_string(),
+                    "list".to_string(),
+                    "create".to_string(),
+                    "update".to_string(),
+                    "delete".to_string(),
+                ],
+            },
+            ApiResource {
+                name: "deployments/scale".to_string(),
+                namespaced: true,
+                kind: "Scale".to_string(),
+                verbs: vec!["get".to_string(), "update".to_string()],
+            },
+        ],
+    })
+}
+
+pub async fn handle_list_all_deployments(State(state): State<AppState>) -> Response {
+    deployment_list(&state, None)
+}
+
+pub async fn handle_list_managed_deployments(
+    State(state): State<AppState>,
+    Path(namespace): Path<String>,
+) -> Response {
+    deployment_list(&state, Some(&namespace))
+}
+
+fn deployment_list(state: &AppState, namespace: Option<&str>) -> Response {
+    let (items, version) = state.deployments.list(namespace);
+    Json(DeploymentList {
+        metadata: ListMeta {
+            resource_version: version.to_string(),
+        },
+        items,
+        ..Default::default()
+    })
+    .into_response()
+}
+
+pub async fn handle_get_managed_deployment(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.deployments.get(&namespace, &name) {
+        Some(d) => Json(d).into_response(),
+        None => deployment_error(DeploymentError::NotFound, &name),
+    }
+}
+
+pub async fn handle_create_deployment(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(namespace): Path<String>,
+    Json(d): Json<Deployment>,
+) -> Response {
+    let findings = lint::lint_pod_spec(&d.spec.template.spec, "spec.template.spec");
+    if lint::has_errors(&findings) {
+        return lint_failed(findings);
+    }
+    if let Err(e) = admit_template(&state, &namespace, &d).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
+    let name = d.metadata.name.clone();
+    match state.deployments.create(&namespace, d) {
+        Ok(d) => {
+            let message = format!("{} replicas", d.spec.replicas);
+            state
+                .activity
+                .record("create", "deployment", &namespace, &name, &request_user(&headers), &message);
+            with_warnings(&findings, (StatusCode::CREATED, Json(d)).into_response())
+        }
+        Err(e) => deployment_error(e, &name),
+    }
+}
+
+// A changed template rolls the deployment's pods over.
+pub async fn handle_update_deployment(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(mut d): Json<Deployment>,
+) -> Response {
+    if d.metadata.name.is_empty() {
+        d.metadata.name = name.clone();
+    }
+    if let Err(e) = admit_template(&state, &namespace, &d).await {
+        return (StatusCode::UNPROCESSABLE_ENTITY, e).into_response();
+    }
+    match state.deployments.update(&namespace, &name, d) {
+        Ok(d) => {
+            let message = format!("{} replicas", d.spec.replicas);
+            state
+                .activity
+                .record("update", "deployment", &namespace, &name, &request_user(&headers), &message);
+            Json(d).into_response()
+        }
+        Err(e) => deployment_error(e, &name),
+    }
+}
+
+// The controller deletes the deployment's pods on its next pass.
+pub async fn handle_delete_deployment(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    if let Err(e) = state.deployments.delete(&namespace, &name) {
+        return deployment_error(e, &name);
+    }
+    state
+        .activity
+        .record("delete", "deployment", &namespace, &name, &request_user(&headers), "");
+    Json(Status {
+        api_version: "v1".to_string(),
+        kind: "Status".to_string(),
+        status: "Success".to_string(),
+        message: format!("deployment {:?} deleted", name),
+    })
+    .into_response()
+}
+
+pub async fn handle_get_deployment_scale(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    match state.deployments.get(&namespace, &name) {
+        Some(d) => Json(deployment_scale(&d)).into_response(),
+        None => deployment_error(DeploymentError::NotFound, &name),
+    }
+}
+
+/// The autoscaling/v1 Scale body kubectl scale sends; only spec.replicas
+/// is read.
+#[derive(Deserialize)]
+pub struct ScaleRequest {
+    pub spec: ScaleSpec,
+}
+
+#[derive(Deserialize)]
+pub struct ScaleSpec {
+    #[serde(default)]
+    pub replicas: i32,
+}
+
+pub async fn handle_update_deployment_scale(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Json(req): Json<ScaleRequest>,
+) -> Response {
+    match state.deployments.scale(&namespace, &name, req.spec.replicas) {
+        Ok(d) => {
+            let message = format!("scaled to {}", d.spec.replicas);
+            state
+                .activity
+                .record("update", "deployment", &namespace, &name, &request_user(&headers), &message);
+            Json(deployment_scale(&d)).into_response()
+        }
+        Err(e) => deployment_error(e, &name),
+    }
+}
+
+fn deployment_scale(d: &Deployment) -> serde_json::Value {
+    serde_json::json!({
+        "apiVersion": "autoscaling/v1",
+        "kind": "Scale",
+        "metadata": {
+            "name": d.metadata.name,
+            "namespace": d.metadata.namespace,
+            "resourceVersion": d.metadata.resource_version,
+        },
+        "spec": {"replicas": d.spec.replicas},
+        "status": {"replicas": d.status.replicas},
+    })
+}
+
+// Checks the template passes admission, like a job's; the deployment
+// controller admits each pod as it creates it.
+pub async fn admit_template(state: &AppState, namespace: &str, d: &Deployment) -> Result<(), String> {
+    let mut pod = Pod {
+        metadata: d.spec.template.metadata.clone(),
+        spec: d.spec.template.spec.clone(),
+        ..Default::default()
+    };
+    pod.metadata.namespace = namespace.to_string();
+    state.admission.admit_pod(&mut pod).await
+}
+
+fn deployment_error(e: DeploymentError, name: &str) -> Response {
+    let (code, message) = match e {
+        DeploymentError::NotFound => (StatusCode::NOT_FOUND, format!("deployment {:?} not found", name)),
+        DeploymentError::AlreadyExists => (StatusCode::CONFLICT, format!("deployment {:?} already exists", name)),
+        DeploymentError::Conflict => (
+            StatusCode::CONFLICT,
+            format!("deployment {:?} was changed since it was read; get it and try again", name),
+        ),
+        DeploymentError::Invalid(m) => (StatusCode::UNPROCESSABLE_ENTITY, m),
+    };
+    (
+        code,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message,
+        }),
+    )
+        .into_response()
+}
+
+// --- Custom resources from config.custom_resources (see custom.rs) ---
+
+/// Path of a custom resource request; namespace is empty for
+/// cluster-scoped paths and name for collection paths.
+#[derive(Deserialize)]
+pub struct CustomPath {
+    group: String,
+    version: String,
+    plural: String,
+    #[serde(default)]
+    namespace: String,
+    #[serde(default)]
+    name: String,
+}
+
+pub async fn handle_custom_resources(
+    State(state): State<AppState>,
+    Path((group, version)): Path<(String, String)>,
+) -> Response {
+    let api_resources: Vec<_> = state
+        .custom
+        .defs()
+        .iter()
+        .filter(|d| d.group == group && d.version == version)
+        .map(|d| ApiResource {
+            name: d.plural.clone(),
+            namespaced: d.namespaced,
+            kind: d.kind.clone(),
+            verbs: ["get", "list", "watch", "create", "update", "delete"]
+                .iter()
+                .map(|v| v.to_string())
+                .collect(),
+        })
+        .collect();
+    if api_resources.is_empty() {
+        return custom_not_found(&format!("{}/{}", group, version));
+    }
+    Json(ApiResourceList {
+        kind: "APIResourceList".to_string(),
+        group_version: format!("{}/{}", group, version),
+        api_resources,
+    })
+    .into_response()
+}
+
+pub async fn handle_list_custom(
+    State(state): State<AppState>,
+    Path(p): Path<CustomPath>,
+    Query(q): Query<ListQuery>,
+) -> Response {
+    let def = match custom_def(&state, &p, false) {
+        Ok(def) => def,
+        Err(resp) => return resp,
+    };
+    let namespace = (!p.namespace.is_empty()).then_some(p.namespace.clone());
+    if q.watch {
+        return watch_custom(&state, def, namespace, &q);
+    }
+    let (items, version) = state.custom.list(def, namespace.as_deref());
+    Json(serde_json::json!({
+        "apiVersion": format!("{}/{}", def.group, def.version),
+        "kind": format!("{}List", def.kind),
+        "metadata": {"resourceVersion": version.to_string()},
+        "items": items,
+    }))
+    .into_response()
+}
+
+// Watch over the store's changes to one type, like watch_pods.
+fn watch_custom(state: &AppState, def: &CustomResourceDef, namespace: Option<String>, q: &ListQuery) -> Response {
+    let resume = match q.resource_version.as_str() {
+        "" | "0" => None,
+        v => match v.parse::<u64>() {
+            Ok(v) => Some(v),
+            Err(_) => return (StatusCode::BAD_REQUEST, format!("invalid resourceVersion {:?}", v)).into_response(),
+        },
+    };
+    let from = resume.unwrap_or_else(|| state.custom.resource_version());
+    let Some((missed, rx)) = state.custom.since(from) else {
+        return watch_response(Box::pin(stream::iter([Ok::<_, Infallible>(expired_event(from))])));
+    };
+    let resource = format!("{}/{}", def.group, def.plural);
+    let mut lines = Vec::new();
+    if resume.is_none() {
+        // Listed after subscribing, so a change racing the list is sent
+        // again rather than lost.
+        let (items, _) = state.custom.list(def, namespace.as_deref());
+        for object in items {
+            lines.push(format!("{}\n", serde_json::json!({"type": "ADDED", "object": object})));
+        }
+    }
+    let mut last = from;
+    for e in &missed {
+        lines.extend(custom_watch_line(e, &resource, namespace.as_deref()));
+        last = e.resource_version;
+    }
+
+    let live = stream::unfold((Some(rx), resource, namespace, last), |(rx, resource, namespace, last)| async move {
+        let mut rx = rx?;
+        loop {
+            match rx.recv().await {
+                Ok(e) if e.resource_version <= last => continue,
+                Ok(e) => {
+                    if let Some(line) = custom_watch_line(&e, &resource, namespace.as_deref()) {
+                        return Some((Ok(line), (Some(rx), resource, namespace, e.resource_version)));
+                    }
+                }
+                Err(broadcast::error::RecvError::Lagged(_)) => {
+                    return Some((Ok(expired_event(last)), (None, resource, namespace, last)));
+                }
+                Err(broadcast::error::RecvError::Closed) => return None,
+            }
+        }
+    });
+    let events = stream::iter(lines.into_iter().map(Ok::<_, Infallible>)).chain(live);
+    match q.timeout_seconds {
+        Some(secs) => watch_response(Box::pin(
+            events.take_until(tokio::time::sleep(Duration::from_secs(secs))),
+        )),
+        None => watch_response(Box::pin(events)),
+    }
+}
+
+fn custom_watch_line(e: &CustomEvent, resource: &str, namespace: Option<&str>) -> Option<String> {
+    if e.resource != resource || namespace.is_some_and(|ns| e.namespace != ns) {
+        return None;
+    }
+    Some(format!("{}\n", serde_json::json!({"type": e.event_type, "object": e.object})))
+}
+
+pub async fn handle_get_custom(State(state): State<AppState>, Path(p): Path<CustomPath>) -> Response {
+    let def = match custom_def(&state, &p, true) {
+        Ok(def) => def,
+        Err(resp) => return resp,
+    };
+    match state.custom.get(def, &p.namespace, &p.name) {
+        Some(object) => Json(object).into_response(),
+        None => custom_error(CustomError::NotFound, def, &p.name),
+    }
+}
+
+pub async fn handle_create_custom(
+    State(state): State<AppState>,
+    Path(p): Path<CustomPath>,
+    Json(object): Json<serde_json::Value>,
+) -> Response {
+    let def = match custom_def(&state, &p, true) {
+        Ok(def) => def,
+        Err(resp) => return resp,
+    };
+    let name = object["metadata"]["name"].as_str().unwrap_or_default().to_string();
+    match state.custom.create(def, &p.namespace, object) {
+        Ok(object) => (StatusCode::CREATED, Json(object)).into_response(),
+        Err(e) => custom_error(e, def, &name),
+    }
+}
+
+pub async fn handle_update_custom(
+    State(state): State<AppState>,
+    Path(p): Path<CustomPath>,
+    Json(object): Json<serde_json::Value>,
+) -> Response {
+    let def = match custom_def(&state, &p, true) {
+        Ok(def) => def,
+        Err(resp) => return resp,
+    };
+    match state.custom.update(def, &p.namespace, &p.name, object) {
+        Ok(object) => Json(object).into_response(),
+        Err(e) => custom_error(e, def, &p.name),
+    }
+}
+
+pub async fn handle_delete_custom(State(state): State<AppState>, Path(p): Path<CustomPath>) -> Response {
+    let def = match custom_def(&state, &p, true) {
+        Ok(def) => def,
+        Err(resp) => return resp,
+    };
+    match state.custom.delete(def, &p.namespace, &p.name) {
+        Ok(_) => Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Success".to_string(),
+            message: format!("{} {:?} deleted", def.kind, p.name),
+        })
+        .into_response(),
+        Err(e) => custom_error(e, def, &p.name),
+    }
+}
+
+// The configured type a path addresses. Namespaced types are listed across
+// namespaces at the cluster-scoped path, but their objects are only
+// reachable under a namespace; cluster-scoped types have no namespace paths.
+fn custom_def<'a>(state: &'a AppState, p: &CustomPath, item: bool) -> Result<&'a CustomResourceDef, Response> {
+    let resource = format!("{}/{}/{}", p.group, p.version, p.plural);
+    let Some(def) = state.custom.def(&p.group, &p.version, &p.plural) else {
+        return Err(custom_not_found(&resource));
+    };
+    let namespaced_path = !p.namespace.is_empty();
+    if (namespaced_path && !def.namespaced) || (item && def.namespaced && !namespaced_path) {
+        return Err(custom_not_found(&resource));
+    }
+    Ok(def)
+}
+
+fn custom_not_found(resource: &str) -> Response {
+    (
+        StatusCode::NOT_FOUND,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message: format!("the server could not find the requested resource {}", resource),
+        }),
+    )
+        .into_response()
+}
+
+fn custom_error(e: CustomError, def: &CustomResourceDef, name: &str) -> Response {
+    let (code, message) = match e {
+        CustomError::NotFound => (StatusCode::NOT_FOUND, format!("{} {:?} not found", def.kind, name)),
+        CustomError::AlreadyExists => (StatusCode::CONFLICT, format!("{} {:?} already exists", def.kind, name)),
+        CustomError::Conflict => (
+            StatusCode::CONFLICT,
+            format!("{} {:?} has been modified; get it again and retry", def.kind, name),
+        ),
+        CustomError::Invalid(m) => (StatusCode::UNPROCESSABLE_ENTITY, m),
+    };
+    (
+        code,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message,
+        }),
+    )
+        .into_response()
+}
+
+// Token of an "Authorization: Bearer" header, or "".
+fn bearer_token(headers: &HeaderMap) -> &str {
+    headers
+        .get(header::AUTHORIZATION)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.strip_prefix("Bearer "))
+        .map(str::trim)
+        .unwrap_or_default()
+}
+
+pub async fn handle_healthz() -> &'static str {
+    "ok\n"
+}
+
+pub async fn handle_metrics(State(state): State<AppState>) -> Response {
+    (
+        [(header::CONTENT_TYPE, "text/plain; version=0.0.4")],
+        state.http_metrics.render(),
+    )
+        .into_response()
 }
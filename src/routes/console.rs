@@ -0,0 +1,276 @@
+use axum::{
+    Json, Router,
+    extract::{Path, Request},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    middleware::{self, Next},
+    response::{IntoResponse, Response},
+    routing::{delete, get, post},
+};
+use serde::Serialize;
+
+use super::{api, sse};
+use crate::AppState;
+
+// Console-specific endpoints (alerts, diagnostics, favorites, ...) are
+// versioned under /api/console/<version>/, apart from the Kubernetes-style
+// /api/v1 surface, so they can change without breaking kubectl-like
+// clients. A breaking change ships as a new version next to the old one:
+// add it to VERSIONS, give it its own router (reusing handlers that didn't
+// change), and move PREFERRED once it's stable.
+
+/// Version served to clients that don't ask for one.
+pub const PREFERRED: &str = "v1alpha1";
+
+/// Header naming the console API version of a request or response.
+pub const VERSION_HEADER: &str = "x-console-api-version";
+
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ConsoleApiVersion {
+    pub version: &'static str,
+    /// alpha, beta, stable or deprecated.
+    pub status: &'static str,
+}
+
+pub const VERSIONS: &[ConsoleApiVersion] = &[ConsoleApiVersion {
+    version: "v1alpha1",
+    status: "alpha",
+}];
+
+/// Console endpoints that used to live under /api/v1. Requests to them still
+/// work but carry Deprecation and Link headers pointing at the versioned
+/// path.
+const LEGACY_PREFIXES: &[&str] = &[
+    "/api/v1/alerts",
+    "/api/v1/archive",
+    "/api/v1/favorites",
+    "/api/v1/activity",
+    "/api/v1/recent",
+    "/api/v1/push/",
+    "/api/v1/diagnostics/",
+    "/api/v1/ipam",
+    "/api/v1/devices",
+    "/api/v1/encryption",
+    "/api/v1/logs",
+];
+
+/// Node sub-resources that are console features rather than Kubernetes ones.
+const LEGACY_NODE_SUFFIXES: &[&str] = &["/health", "/bandwidth", "/wake"];
+
+pub fn v1alpha1() -> Router<AppState> {
+    Router::new()
+        .route("/", get(handle_v1alpha1_resources))
+        .route("/summary", get(api::handle_cluster_summary))
+        .route("/alerts", get(api::handle_list_alerts))
+        .route("/archive", get(api::handle_list_archive))
+        .route("/archive/{id}", get(api::handle_get_archive_entry))
+        .route("/logs", get(api::handle_merged_logs))
+        .route("/nodes/{name}/health", get(api::handle_get_node_health))
+        .route("/nodes/{name}/bandwidth", get(api::handle_get_node_bandwidth))
+        .route("/nodes/{name}/wake", post(api::handle_wake_node))
+        .route(
+            "/nodes/{name}/restart-pods",
+            get(api::handle_get_pod_restarts).post(api::handle_restart_node_pods),
+        )
+        .route("/nodes/register", post(api::handle_register_node))
+        .route("/nodes/connect", get(api::handle_connect_node))
+        .route("/tunnels", get(api::handle_list_tunnels))
+        .route("/devices", get(api::handle_list_devices))
+        .route("/ipam", get(api::handle_ipam))
+        .route("/diagnostics/connectivity", get(api::handle_connectivity))
+        .route("/diagnostics/port-check", get(api::handle_port_check))
+        .route("/diagnostics/dns", get(api::handle_dns_lookup))
+        .route("/diagnostics/ping", get(api::handle_ping))
+        .route("/diagnostics/traceroute", get(api::handle_traceroute))
+        .route("/diagnostics/runs", get(api::handle_list_diagnostics))
+        .route("/diagnostics/bundle", get(api::handle_diagnostic_bundle))
+        .route("/stuck-pods", get(api::handle_stuck_pods))
+        .route("/stuck-pods/{namespace}/{name}/remediate", post(api::handle_remediate_pod))
+        .route("/pools", get(api::handle_list_pools))
+        .route(
+            "/pools/{name}/cordon",
+            post(api::handle_cordon_pool).delete(api::handle_uncordon_pool),
+        )
+        .route(
+            "/localvolumes",
+            get(api::handle_list_local_volumes).post(api::handle_create_local_volume),
+        )
+        .route("/localvolumes/{namespace}/{name}", delete(api::handle_release_local_volume))
+        .route("/storage", get(api::handle_list_storage).post(api::handle_add_storage))
+        .route("/storage/{name}", delete(api::handle_remove_storage))
+        .route("/storage/{name}/stanza", get(api::handle_storage_stanza))
+        .route("/rollouts", get(api::handle_list_pending_restarts))
+        .route(
+            "/devicesets",
+            get(api::handle_list_device_sets).post(api::handle_create_device_set),
+        )
+        .route("/devicesets/{namespace}/{name}", delete(api::handle_delete_device_set))
+        .route(
+            "/daemonsets",
+            get(api::handle_list_daemon_sets).post(api::handle_create_daemon_set),
+        )
+        .route(
+            "/daemonsets/{namespace}/{name}",
+            get(api::handle_get_daemon_set).delete(api::handle_delete_daemon_set),
+        )
+        .route("/self", get(api::handle_self_status))
+        .route("/self/handoff", post(api::handle_self_handoff))
+        .route("/self/restart", post(api::handle_self_restart))
+        .route("/encryption", get(api::handle_encryption_status))
+        .route(
+            "/favorites",
+            get(api::handle_list_favorites)
+                .post(api::handle_add_favorite)
+                .delete(api::handle_remove_favorite),
+        )
+        .route("/activity", get(api::handle_list_activity))
+        .route("/recent", get(api::handle_list_recent))
+        .route("/push/vapid-public-key", get(api::handle_push_public_key))
+        .route(
+            "/push/subscriptions",
+            get(api::handle_list_push_subscriptions)
+                .post(api::handle_create_push_subscription)
+                .delete(api::handle_delete_push_subscription),
+        )
+        .route("/bundles", get(api::handle_list_bundles))
+        .route("/bundles/apply", post(api::handle_apply_bundle))
+        .route("/lint", post(api::handle_lint))
+        .route("/claims", get(api::handle_list_claims).post(api::handle_claim_node))
+        .route("/replication/stream", get(sse::handle_replication_stream))
+        .route(
+            "/managed/nodes/{name}",
+            get(api::handle_get_managed_node)
+                .put(api::handle_put_managed_node)
+                .delete(api::handle_delete_managed_node),
+        )
+        .route(
+            "/managed/bundles/{namespace}/{name}",
+            get(api::handle_get_managed_bundle)
+                .put(api::handle_put_managed_bundle)
+                .delete(api::handle_delete_managed_bundle),
+        )
+        .route(
+            "/managed/alertrules/{name}",
+            get(api::handle_get_managed_alert_rule)
+                .put(api::handle_put_managed_alert_rule)
+                .delete(api::handle_delete_managed_alert_rule),
+        )
+        .route(
+            "/managed/tokens/{id}",
+            get(api::handle_get_managed_token)
+                .put(api::handle_put_managed_token)
+                .delete(api::handle_delete_managed_token),
+        )
+        .layer(middleware::from_fn(stamp_v1alpha1))
+}
+
+/// GET /api/console: the versions this console serves.
+pub async fn handle_discovery() -> Response {
+    Json(serde_json::json!({
+        "kind": "ConsoleAPIVersions",
+        "preferredVersion": PREFERRED,
+        "versions": VERSIONS,
+    }))
+    .into_response()
+}
+
+async fn handle_v1alpha1_resources() -> Response {
+    Json(serde_json::json!({
+        "kind": "ConsoleAPIResourceList",
+        "version": "v1alpha1",
+        "resources": [
+            "summary", "alerts", "archive", "logs", "nodes/health", "nodes/bandwidth", "nodes/wake",
+            "nodes/restart-pods", "nodes/register", "nodes/connect", "tunnels", "devices", "ipam", "diagnostics",
+            "stuck-pods", "pools", "localvolumes", "storage", "rollouts", "encryption", "favorites", "activity", "recent",
+            "push", "bundles", "claims", "replication", "devicesets", "daemonsets", "self", "managed/nodes",
+            "managed/bundles", "managed/alertrules", "managed/tokens",
+        ],
+    }))
+    .into_response()
+}
+
+/// Anything under /api/console that no version router matched. Paths
+/// without a version are redirected to the version the client negotiated
+/// (X-Console-API-Version header or a version= parameter on Accept,
+/// otherwise PREFERRED); unknown versions get the list of supported ones.
+pub async fn handle_negotiate(Path(rest): Path<String>, req: Request) -> Response {
+    let first = rest.split('/').next().unwrap_or("");
+    if looks_like_version(first) {
+        return unsupported(first, StatusCode::NOT_FOUND);
+    }
+
+    let requested = requested_version(req.headers());
+    let version = match requested {
+        Some(ref v) if VERSIONS.iter().any(|s| s.version == v) => v.as_str(),
+        Some(ref v) => return unsupported(v, StatusCode::NOT_ACCEPTABLE),
+        None => PREFERRED,
+    };
+    let query = req.uri().query().map(|q| format!("?{}", q)).unwrap_or_default();
+    let location = format!("/api/console/{}/{}{}", version, rest, query);
+    // 308 keeps the method and body, so mutations survive the redirect
+    (
+        StatusCode::PERMANENT_REDIRECT,
+        [(header::LOCATION, location)],
+    )
+        .into_response()
+}
+
+/// Marks responses from deprecated /api/v1 console endpoints with their
+/// successor under the preferred console API version.
+pub async fn deprecate_legacy(req: Request, next: Next) -> Response {
+    let successor = legacy_successor(req.uri().path());
+    let mut resp = next.run(req).await;
+    if let Some(successor) = successor {
+        let headers = resp.headers_mut();
+        headers.insert("deprecation", HeaderValue::from_static("true"));
+        let link = format!("<{}>; rel=\"successor-version\"", successor);
+        if let Ok(link) = HeaderValue::from_str(&link) {
+            headers.insert(header::LINK, link);
+        }
+    }
+    resp
+}
+
+fn legacy_successor(path: &str) -> Option<String> {
+    let rest = path.strip_prefix("/api/v1/")?;
+    let is_legacy = LEGACY_PREFIXES.iter().any(|p| path.starts_with(p))
+        || (rest.starts_with("nodes/") && LEGACY_NODE_SUFFIXES.iter().any(|s| rest.ends_with(s)));
+    is_legacy.then(|| format!("/api/console/{}/{}", PREFERRED, rest))
+}
+
+async fn stamp_v1alpha1(req: Request, next: Next) -> Response {
+    let mut resp = next.run(req).await;
+    resp.headers_mut()
+        .insert(VERSION_HEADER, HeaderValue::from_static("v1alpha1"));
+    resp
+}
+
+fn requested_version(headers: &HeaderMap) -> Option<String> {
+    if let Some(v) = headers.get(VERSION_HEADER).and_then(|v| v.to_str().ok()) {
+        return Some(v.trim().to_string());
+    }
+    let accept = headers.get(header::ACCEPT)?.to_str().ok()?;
+    accept
+        .split(',')
+        .flat_map(|media| media.split(';').skip(1))
+        .filter_map(|param| param.trim().strip_prefix("version="))
+        .map(|v| v.trim_matches('"').to_string())
+        .next()
+}
+
+fn looks_like_version(segment: &str) -> bool {
+    let mut chars = segment.chars();
+    chars.next() == Some('v') && chars.next().is_some_and(|c| c.is_ascii_digit())
+}
+
+fn unsupported(version: &str, status: StatusCode) -> Response {
+    (
+        status,
+        Json(serde_json::json!({
+            "error": format!("unsupported console API version {:?}", version),
+            "preferredVersion": PREFERRED,
+            "supportedVersions": VERSIONS.iter().map(|v| v.version).collect::<Vec<_>>(),
+        })),
+    )
+        .into_response()
+}
@@ -0,0 +1,370 @@
+use axum::{
+    Json,
+    extract::{ConnectInfo, MatchedPath, Request, State},
+    http::{HeaderMap, HeaderValue, Method, StatusCode, header},
+    middleware::Next,
+    response::{IntoResponse, Response},
+};
+use chrono::Utc;
+use futures_util::FutureExt;
+use std::backtrace::Backtrace;
+use std::cell::RefCell;
+use std::net::SocketAddr;
+use std::panic::AssertUnwindSafe;
+use std::time::Instant;
+
+use crate::accesslog::AccessEntry;
+use crate::config::Role;
+use crate::helpers::request_user;
+use crate::models::k8s::Status;
+use crate::reporting::ErrorReport;
+use crate::AppState;
+
+// Middleware shared by the API and the UI; build_router sets the order.
+// Panic recovery goes innermost so a panicking handler still produces a
+// response that the outer layers can log, count and render.
+
+/// What the panic hook saw, for recover() to log and report.
+#[derive(Debug, Clone, Default)]
+pub struct PanicReport {
+    pub message: String,
+    pub location: String,
+    pub backtrace: String,
+}
+
+thread_local! {
+    // A handler panics on the thread polling it, which is where recover()
+    // catches the unwind, so the hook can hand the report over here.
+    static LAST_PANIC: RefCell<Option<PanicReport>> = const { RefCell::new(None) };
+}
+
+/// Captures the message, location and stack of every panic for recover().
+/// The default hook still runs, so panics elsewhere are reported as before.
+pub fn install_panic_hook() {
+    let default = std::panic::take_hook();
+    std::panic::set_hook(Box::new(move |info| {
+        let report = PanicReport {
+            message: payload_message(info.payload()),
+            location: info.location().map(|l| l.to_string()).unwrap_or_default(),
+            backtrace: Backtrace::force_capture().to_string(),
+        };
+        LAST_PANIC.with(|p| *p.borrow_mut() = Some(report));
+        default(info);
+    }));
+}
+
+/// Turns a panicking handler into a 500 instead of a dropped connection:
+/// logs the panic with its stack and request, counts it, and forwards it
+/// to config.error_reporting. UI pages get the error page from
+/// ui::html_errors; API clients get a Status naming the request ID.
+pub async fn recover(State(state): State<AppState>, req: Request, next: Next) -> Response {
+    let method = req.method().to_string();
+    let path = req.uri().path().to_string();
+    let user = request_user(req.headers());
+    let request_id = req
+        .headers()
+        .get("x-request-id")
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or_default()
+        .to_string();
+    let payload = match AssertUnwindSafe(next.run(req)).catch_unwind().await {
+        Ok(resp) => return resp,
+        Err(payload) => payload,
+    };
+
+    let panic = LAST_PANIC
+        .with(|p| p.borrow_mut().take())
+        .unwrap_or_else(|| PanicReport {
+            message: payload_message(payload.as_ref()),
+            ..Default::default()
+        });
+    tracing::error!(
+        request_id = %request_id,
+        method = %method,
+        path = %path,
+        user = %user,
+        location = %panic.location,
+        "handler panicked: {}\n{}",
+        panic.message,
+        panic.backtrace
+    );
+    state.http_metrics.record_panic();
+    if let Some(ref reporter) = state.reporter {
+        reporter.report(ErrorReport {
+            time: Utc::now(),
+            cluster: state.config.cluster_name.clone(),
+            request_id: request_id.clone(),
+            method,
+            path: path.clone(),
+            user,
+            message: panic.message,
+            location: panic.location,
+            backtrace: panic.backtrace,
+        });
+    }
+
+    let mut resp = if path.starts_with("/ui") {
+        (StatusCode::INTERNAL_SERVER_ERROR, "Internal Server Error").into_response()
+    } else {
+        (
+            StatusCode::INTERNAL_SERVER_ERROR,
+            Json(Status {
+                api_version: "v1".to_string(),
+                kind: "Status".to_string(),
+                status: "Failure".to_string(),
+                message: format!("internal error, request ID {}", request_id),
+            }),
+        )
+            .into_response()
+    };
+    if let Ok(v) = HeaderValue::from_str(&request_id) {
+        resp.headers_mut().insert("x-request-id", v);
+    }
+    resp
+}
+
+/// One line per request. Health checks, metrics scrapes and static files
+/// are logged at debug.
+pub async fn log_requests(req: Request, next: Next) -> Response {
+    let start = Instant::now();
+    let method = req.method().clone();
+    let path = req.uri().path().to_string();
+    let user = request_user(req.headers());
+    let resp = next.run(req).await;
+    let status = resp.status().as_u16();
+    let ms = start.elapsed().as_millis();
+    if is_public(&path) {
+        tracing::debug!(%method, %path, status, ms, %user, "request");
+    } else {
+        tracing::info!(%method, %path, status, ms, %user, "request");
+    }
+    resp
+}
+
+/// Writes config.access_log, when set.
+pub async fn access_log(State(state): State<AppState>, req: Request, next: Next) -> Response {
+    let Some(log) = state.access_log.clone() else {
+        return next.run(req).await;
+    };
+    let time = Utc::now();
+    let start = Instant::now();
+    let remote = req
+        .extensions()
+        .get::<ConnectInfo<SocketAddr>>()
+        .map(|c| c.0.ip().to_string())
+        .unwrap_or_else(|| "-".to_string());
+    let user = match request_user(req.headers()).as_str() {
+        "anonymous" => "-".to_string(),
+        user => user.replace(char::is_whitespace, "_"),
+    };
+    let header_value = |name: header::HeaderName| {
+        req.headers()
+            .get(name)
+            .and_then(|v| v.to_str().ok())
+            .unwrap_or_default()
+            .to_string()
+    };
+    let referer = header_value(header::REFERER);
+    let user_agent = header_value(header::USER_AGENT);
+    let method = req.method().to_string();
+    let uri = req
+        .uri()
+        .path_and_query()
+        .map(|p| p.as_str().to_string())
+        .unwrap_or_else(|| "/".to_string());
+    let protocol = format!("{:?}", req.version());
+    let resp = next.run(req).await;
+    let bytes = resp
+        .headers()
+        .get(header::CONTENT_LENGTH)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.parse().ok());
+    log.write(&AccessEntry {
+        time,
+        remote,
+        user,
+        method,
+        uri,
+        protocol,
+        status: resp.status().as_u16(),
+        bytes,
+        referer,
+        user_agent,
+        duration_ms: start.elapsed().as_millis(),
+    });
+    resp
+}
+
+pub async fn record_metrics(State(state): State<AppState>, req: Request, next: Next) -> Response {
+    let start = Instant::now();
+    let method = req.method().to_string();
+    let route = req
+        .extensions()
+        .get::<MatchedPath>()
+        .map(|m| m.as_str().to_string())
+        .unwrap_or_else(|| "unmatched".to_string());
+    let resp = next.run(req).await;
+    state
+        .http_metrics
+        .observe(&method, &route, resp.status().as_u16(), start.elapsed().as_secs_f64());
+    resp
+}
+
+/// Enforces config.access, and the roles applied through the console
+/// below it: reads need viewer, changes need editor, and console
+/// administration (encryption, claiming nodes, /api/admin) needs admin.
+pub async fn authorize(State(state): State<AppState>, req: Request, next: Next) -> Response {
+    let Some(ref access) = state.config.access else {
+        return next.run(req).await;
+    };
+    let path = req.uri().path();
+    // Node agents registering or connecting carry a bootstrap or agent
+    // token instead of a user, CI deploy hooks a hook token and share
+    // links a signature; the handlers check them.
+    if is_public(path) || AGENT_PATHS.contains(&path) || HOOK_PATHS.contains(&path) || path.starts_with("/share/") {
+        return next.run(req).await;
+    }
+
+    let user = request_user(req.headers());
+    if access.require_user && user == "anonymous" {
+        return (StatusCode::FORBIDDEN, "Sign in to use the console.").into_response();
+    }
+    let role = access
+        .users
+        .get(&user)
+        .copied()
+        .or_else(|| state.settings.role(&user))
+        .unwrap_or(access.default_role);
+    let needed = required_role(req.method(), path);
+    if role < needed {
+        let message = format!(
+            "{} has the {} role; this needs {}.",
+            user,
+            role_name(role),
+            role_name(needed)
+        );
+        return (StatusCode::FORBIDDEN, message).into_response();
+    }
+    next.run(req).await
+}
+
+/// Refuses changes, exec, attach and port-forward included, while the
+/// console is in read-only mode (readonly.rs), except switching it back
+/// and node agents signing in. Runs after authorize, so a user lacking
+/// the role hears about that first.
+pub async fn read_only(req: Request, next: Next) -> Response {
+    let path = req.uri().path();
+    let change = !matches!(*req.method(), Method::GET | Method::HEAD | Method::OPTIONS) || is_pod_stream(path);
+    let exempt = READ_ONLY_TOGGLES.contains(&path) || AGENT_PATHS.contains(&path);
+    if !change || exempt || !crate::readonly::enabled() {
+        return next.run(req).await;
+    }
+    (
+        StatusCode::FORBIDDEN,
+        "The console is in read-only mode; an admin can switch it off at /ui/read-only.",
+    )
+        .into_response()
+}
+
+/// Refuses changes, exec, attach and port-forward included, that a page on
+/// another site started: with the user's proxy session riding along, a
+/// cross-site form or WebSocket could otherwise act as them. Browsers name
+/// the requesting site in Sec-Fetch-Site, or at least send Origin; kubectl,
+/// curl and node agents send neither and carry no ambient session.
+pub async fn same_origin(req: Request, next: Next) -> Response {
+    let path = req.uri().path();
+    let change = !matches!(*req.method(), Method::GET | Method::HEAD | Method::OPTIONS) || is_pod_stream(path);
+    let exempt = AGENT_PATHS.contains(&path) || HOOK_PATHS.contains(&path);
+    if !change || exempt || !cross_site(req.headers()) {
+        return next.run(req).await;
+    }
+    (StatusCode::FORBIDDEN, "The console doesn't take changes from other sites.").into_response()
+}
+
+// Whether a browser made the request for another site's page. Origin is
+// checked against the host the client asked for, which the proxy passes
+// on in X-Forwarded-Host when it rewrites Host.
+fn cross_site(headers: &HeaderMap) -> bool {
+    if let Some(site) = headers.get("sec-fetch-site").and_then(|v| v.to_str().ok()) {
+        return !matches!(site, "same-origin" | "none");
+    }
+    let Some(origin) = headers.get(header::ORIGIN).and_then(|v| v.to_str().ok()) else {
+        return false;
+    };
+    let Some((_, origin_host)) = origin.split_once("://") else {
+        return true;
+    };
+    !["x-forwarded-host", "host"]
+        .iter()
+        .filter_map(|h| headers.get(*h)?.to_str().ok())
+        .map(|h| h.split(',').next().unwrap_or(h).trim())
+        .any(|host| host.eq_ignore_ascii_case(origin_host))
+}
+
+const READ_ONLY_TOGGLES: &[&str] = &["/api/admin/read-only", "/ui/read-only"];
+
+const AGENT_PATHS: &[&str] = &[
+    "/api/console/v1alpha1/nodes/register",
+    "/api/console/v1alpha1/nodes/connect",
+];
+
+// Unlike agents, deploy hooks change workloads, so read-only mode holds
+// them off.
+const HOOK_PATHS: &[&str] = &["/api/hooks/deploy"];
+
+fn required_role(method: &Method, path: &str) -> Role {
+    if path.starts_with("/api/admin/")
+        || path.ends_with("/encryption")
+        || path.ends_with("/claims")
+        || path.contains("/managed/nodes/")
+        || path.contains("/managed/tokens/")
+        || path == "/ui/nodes/claim"
+        || path == "/ui/read-only"
+        || path == "/ui/login-attempts"
+        || path == "/ui/schedule"
+        || path == "/ui/update"
+    {
+        Role::Admin
+    } else if (path.starts_with("/api/v1/namespaces/") && path.contains("/secrets"))
+        || path.starts_with("/ui/secrets/")
+        || is_pod_stream(path)
+    {
+        // Secret values, unlike the list of secret names, are for editors,
+        // as is a shell in, attaching to or forwarding a port of a pod,
+        // even over GET
+        Role::Editor
+    } else if matches!(*method, Method::GET | Method::HEAD | Method::OPTIONS) {
+        Role::Viewer
+    } else {
+        Role::Editor
+    }
+}
+
+// kubectl exec, attach and port-forward open their WebSocket with a GET.
+fn is_pod_stream(path: &str) -> bool {
+    path.starts_with("/api/v1/namespaces/")
+        && ["/exec", "/attach", "/portforward"].iter().any(|s| path.ends_with(s))
+}
+
+fn role_name(r: Role) -> &'static str {
+    match r {
+        Role::Viewer => "viewer",
+        Role::Editor => "editor",
+        Role::Admin => "admin",
+    }
+}
+
+// Paths anyone may fetch: probes, scrapes and the UI's static assets.
+fn is_public(path: &str) -> bool {
+    path == "/healthz" || path == "/metrics" || path == "/ui/sw.js" || path.starts_with("/ui/static/")
+}
+
+fn payload_message(payload: &(dyn std::any::Any + Send)) -> String {
+    if let Some(s) = payload.downcast_ref::<&str>() {
+        s.to_string()
+    } else if let Some(s) = payload.downcast_ref::<String>() {
+        s.clone()
+    } else {
+        "non-string panic payload".to_string()
+    }
+}
@@ -1,13 +1,22 @@
 pub mod api;
+pub mod console;
+pub mod layers;
+pub mod pages;
 pub mod sse;
 pub mod ui;
 
 use axum::{
-    Router,
-    routing::get,
+    Json, Router,
+    extract::{Request, State},
+    http::{Method, StatusCode, Uri, header},
+    middleware::{self, Next},
+    response::{IntoResponse, Response},
+    routing::{any, delete, get, post},
 };
-use tower_http::services::ServeDir;
+use tower_http::compression::CompressionLayer;
+use tower_http::services::{ServeDir, ServeFile};
 
+use crate::models::k8s::Status;
 use crate::AppState;
 
 pub fn build_router(state: AppState) -> Router {
@@ -15,6 +24,7 @@ pub fn build_router(state: AppState) -> Router {
         // API discovery
         .route("/api", get(api::handle_api_versions))
         .route("/api/v1", get(api::handle_api_resources))
+        .route("/api/version", get(api::handle_version))
         // Pods
         .route("/api/v1/pods", get(api::handle_list_all_pods))
         .route(
@@ -29,46 +39,231 @@ pub fn build_router(state: AppState) -> Router {
             "/api/v1/namespaces/{namespace}/pods/{name}/log",
             get(api::handle_get_pod_log),
         )
+        // kubectl exec, attach and port-forward: GET for WebSocket, POST
+        // for SPDY
+        .route(
+            "/api/v1/namespaces/{namespace}/pods/{name}/exec",
+            get(api::handle_pod_exec).post(api::handle_pod_exec),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/pods/{name}/attach",
+            get(api::handle_pod_attach).post(api::handle_pod_attach),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/pods/{name}/portforward",
+            get(api::handle_pod_port_forward).post(api::handle_pod_port_forward),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/pods/{name}/status",
+            get(api::handle_get_pod).put(api::handle_update_pod_status),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/pods/{name}/explain",
+            get(api::handle_explain_pod),
+        )
+        // ConfigMaps
+        .route(
+            "/api/v1/namespaces/{namespace}/configmaps",
+            get(api::handle_list_configmaps).post(api::handle_create_configmap),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/configmaps/{name}",
+            get(api::handle_get_configmap).delete(api::handle_delete_configmap),
+        )
+        // Secrets
+        .route(
+            "/api/v1/namespaces/{namespace}/secrets",
+            get(api::handle_list_secrets).post(api::handle_create_secret),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/secrets/{name}",
+            get(api::handle_get_secret).delete(api::handle_delete_secret),
+        )
+        // Services and their endpoints
+        .route(
+            "/api/v1/namespaces/{namespace}/services",
+            get(api::handle_list_services).post(api::handle_create_service),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}/services/{name}",
+            get(api::handle_get_service).delete(api::handle_delete_service),
+        )
+        .route("/api/v1/namespaces/{namespace}/endpoints", get(api::handle_list_endpoints))
+        .route("/api/v1/namespaces/{namespace}/endpoints/{name}", get(api::handle_get_endpoints))
+        // Events
+        .route("/api/v1/events", get(api::handle_list_events))
+        .route("/api/v1/namespaces/{namespace}/events", get(api::handle_list_namespace_events))
+        // Namespaces
+        .route(
+            "/api/v1/namespaces",
+            get(api::handle_list_namespaces).post(api::handle_create_namespace),
+        )
+        .route(
+            "/api/v1/namespaces/{namespace}",
+            get(api::handle_get_namespace).delete(api::handle_delete_namespace),
+        )
+        // Merged multi-pod logs
+        .route("/api/v1/logs", get(api::handle_merged_logs))
         // Nodes
         .route("/api/v1/nodes", get(api::handle_list_nodes))
         .route("/api/v1/nodes/{name}", get(api::handle_get_node))
+        .route("/api/v1/nodes/{name}/health", get(api::handle_get_node_health))
+        .route("/api/v1/nodes/{name}/bandwidth", get(api::handle_get_node_bandwidth))
+        .route("/api/v1/nodes/{name}/wake", post(api::handle_wake_node))
+        .route("/api/v1/devices", get(api::handle_list_devices))
+        .route("/api/v1/ipam", get(api::handle_ipam))
+        .route("/api/v1/diagnostics/connectivity", get(api::handle_connectivity))
+        .route("/api/v1/diagnostics/port-check", get(api::handle_port_check))
+        .route("/api/v1/diagnostics/dns", get(api::handle_dns_lookup))
+        .route("/api/v1/diagnostics/ping", get(api::handle_ping))
+        .route("/api/v1/diagnostics/traceroute", get(api::handle_traceroute))
+        .route("/api/v1/diagnostics/runs", get(api::handle_list_diagnostics))
+        .route("/api/v1/diagnostics/bundle", get(api::handle_diagnostic_bundle))
+        .route("/api/v1/schedule/decisions", get(api::handle_list_decisions))
+        .route("/api/v1/encryption", get(api::handle_encryption_status))
+        // Alerts & history archive
+        .route("/api/v1/alerts", get(api::handle_list_alerts))
+        .route("/api/v1/alerts/rules", get(api::handle_list_alert_rules))
+        .route("/api/v1/alerts/rules/test", post(api::handle_test_alert_rule))
+        .route("/api/v1/admission/test", post(api::handle_test_admission))
+        .route("/api/v1/archive", get(api::handle_list_archive))
+        .route("/api/v1/archive/{id}", get(api::handle_get_archive_entry))
+        // Favorites
+        .route(
+            "/api/v1/favorites",
+            get(api::handle_list_favorites)
+                .post(api::handle_add_favorite)
+                .delete(api::handle_remove_favorite),
+        )
+        // Activity feed & recently viewed
+        .route("/api/v1/activity", get(api::handle_list_activity))
+        .route("/api/v1/recent", get(api::handle_list_recent))
+        // Web Push
+        .route("/api/v1/push/vapid-public-key", get(api::handle_push_public_key))
+        .route(
+            "/api/v1/push/subscriptions",
+            get(api::handle_list_push_subscriptions)
+                .post(api::handle_create_push_subscription)
+                .delete(api::handle_delete_push_subscription),
+        )
+        // High availability & replication
+        .route("/api/v1/leader", get(api::handle_get_leader))
+        .route("/api/v1/replication/stream", get(sse::handle_replication_stream))
+        // coordination.k8s.io, served by the console itself
+        .route("/apis", get(api::handle_api_groups))
+        .route("/apis/coordination.k8s.io/v1", get(api::handle_coordination_resources))
+        .route("/apis/coordination.k8s.io/v1/leases", get(api::handle_list_all_leases))
+        .route(
+            "/apis/coordination.k8s.io/v1/namespaces/{namespace}/leases",
+            get(api::handle_list_leases).post(api::handle_create_lease),
+        )
+        .route(
+            "/apis/coordination.k8s.io/v1/namespaces/{namespace}/leases/{name}",
+            get(api::handle_get_lease)
+                .put(api::handle_update_lease)
+                .delete(api::handle_delete_lease),
+        )
+        // batch/v1 Jobs and CronJobs, run by the console's controllers
+        .route("/apis/batch/v1", get(api::handle_batch_resources))
+        .route("/apis/batch/v1/jobs", get(api::handle_list_all_jobs))
+        .route(
+            "/apis/batch/v1/namespaces/{namespace}/jobs",
+            get(api::handle_list_jobs).post(api::handle_create_job),
+        )
+        .route(
+            "/apis/batch/v1/namespaces/{namespace}/jobs/{name}",
+            get(api::handle_get_job).delete(api::handle_delete_job),
+        )
+        .route("/apis/batch/v1/cronjobs", get(api::handle_list_all_cron_jobs))
+        .route(
+            "/apis/batch/v1/namespaces/{namespace}/cronjobs",
+            get(api::handle_list_cron_jobs).post(api::handle_create_cron_job),
+        )
+        .route(
+            "/apis/batch/v1/namespaces/{namespace}/cronjobs/{name}",
+            get(api::handle_get_cron_job)
+                .put(api::handle_update_cron_job)
+                .delete(api::handle_delete_cron_job),
+        )
+        // apps/v1 Deployments, run by the console's deployment controller
+        .route("/apis/apps/v1", get(api::handle_apps_resources))
+        .route("/apis/apps/v1/deployments", get(api::handle_list_all_deployments))
+        .route(
+            "/apis/apps/v1/namespaces/{namespace}/deployments",
+            get(api::handle_list_managed_deployments).post(api::handle_create_deployment),
+        )
+        .route(
+            "/apis/apps/v1/namespaces/{namespace}/deployments/{name}",
+            get(api::handle_get_managed_deployment)
+                .put(api::handle_update_deployment)
+                .delete(api::handle_delete_deployment),
+        )
+        .route(
+            "/apis/apps/v1/namespaces/{namespace}/deployments/{name}/scale",
+            get(api::handle_get_deployment_scale).put(api::handle_update_deployment_scale),
+        )
+        // Custom resources, see config.custom_resources
+        .route("/apis/{group}/{version}", get(api::handle_custom_resources))
+        .route(
+            "/apis/{group}/{version}/{plural}",
+            get(api::handle_list_custom).post(api::handle_create_custom),
+        )
+        .route(
+            "/apis/{group}/{version}/{plural}/{name}",
+            get(api::handle_get_custom)
+                .put(api::handle_update_custom)
+                .delete(api::handle_delete_custom),
+        )
+        .route(
+            "/apis/{group}/{version}/namespaces/{namespace}/{plural}",
+            get(api::handle_list_custom).post(api::handle_create_custom),
+        )
+        .route(
+            "/apis/{group}/{version}/namespaces/{namespace}/{plural}/{name}",
+            get(api::handle_get_custom)
+                .put(api::handle_update_custom)
+                .delete(api::handle_delete_custom),
+        )
+        // Console administration
+        .route(
+            "/api/admin/bootstrap-tokens",
+            get(api::handle_list_bootstrap_tokens).post(api::handle_create_bootstrap_token),
+        )
+        .route("/api/admin/bootstrap-tokens/{id}", delete(api::handle_revoke_bootstrap_token))
+        .route("/api/admin/login-attempts", get(api::handle_login_attempts))
+        .route(
+            "/api/admin/read-only",
+            get(api::handle_read_only).put(api::handle_set_read_only),
+        )
+        .route("/api/admin/schedule", get(api::handle_schedule))
+        .route("/api/admin/telemetry", get(api::handle_telemetry))
+        .route("/api/admin/update", get(api::handle_update_status))
+        .route("/api/admin/update/check", post(api::handle_update_check))
+        .route("/api/admin/update/apply", post(api::handle_update_apply))
+        .route("/api/admin/config/apply", post(api::handle_apply_config))
+        .route("/api/hooks/deploy", post(api::handle_deploy_hook))
+        // Versioned console API; /api/v1 console endpoints above are
+        // deprecated aliases
+        .route("/api/console", get(console::handle_discovery))
+        .nest("/api/console/v1alpha1", console::v1alpha1())
+        .route("/api/console/{*rest}", any(console::handle_negotiate))
         // Health
         .route("/healthz", get(api::handle_healthz))
-        // Dashboard UI
-        .route("/ui/", get(ui::handle_dashboard))
-        .route("/ui/namespaces", get(ui::handle_namespaces))
-        .route("/ui/namespaces/{name}", get(ui::handle_namespace_detail))
-        .route("/ui/namespaces/{namespace}/pods/{name}", get(ui::handle_pod_detail))
-        .route("/ui/namespaces/{namespace}/pods/{pod}/containers/{name}", get(ui::handle_container_detail))
-        // SSE events
-        .route("/ui/events/pods", get(sse::handle_pod_events))
-        .route("/ui/pods", get(ui::handle_pods))
-        .route("/ui/pods/{namespace}/{name}", get(ui::handle_pod_detail))
-        .route("/ui/nodes", get(ui::handle_nodes))
-        .route("/ui/nodes/{name}", get(ui::handle_node_detail))
-        .route("/ui/registry", get(ui::handle_registry))
-        // Deployments
-        .route("/ui/deployments", get(ui::handle_deployments))
-        .route("/ui/deployments/{namespace}/{name}", get(ui::handle_deployment_detail))
-        // Networks
-        .route("/ui/networks", get(ui::handle_networks))
-        .route("/ui/networks/{name}", get(ui::handle_network_detail))
-        // PVCs
-        .route("/ui/pvcs", get(ui::handle_pvcs))
-        // BareMetalHosts
-        .route("/ui/bmh", get(ui::handle_bmhs))
-        .route("/ui/bmh/{namespace}/{name}", get(ui::handle_bmh_detail))
-        // iSCSI CDROMs
-        .route("/ui/iscsi-cdroms", get(ui::handle_iscsi_cdroms))
-        .route("/ui/iscsi-cdroms/{name}", get(ui::handle_iscsi_cdrom_detail))
-        // ConfigMaps
-        .route("/ui/configmaps", get(ui::handle_configmaps))
-        .route("/ui/configmaps/{namespace}/{name}", get(ui::handle_configmap_detail))
-        // Operations
-        .route("/ui/consistency", get(ui::handle_consistency))
-        .route("/ui/events", get(ui::handle_events))
-        // Static files
+        .route("/metrics", get(api::handle_metrics))
+        // Dashboard UI, see pages.rs
+        .merge(pages::router())
+        // Read-only views behind signed, expiring links (share.rs); the
+        // signature stands in for a user
+        .route("/share/status", get(ui::handle_shared_status))
+        .route("/share/nodes/{name}", get(ui::handle_shared_node))
+        .route("/share/logs/{namespace}/{name}", get(ui::handle_shared_logs))
+        .route("/share/widgets/health", get(ui::handle_health_widget))
+        .route("/share/widgets/nodes", get(ui::handle_node_strip_widget))
+        .route("/share/badge.json", get(ui::handle_badge))
+        // Static files. The service worker lives under /ui/ so its scope
+        // covers every console page.
         .nest_service("/ui/static", ServeDir::new("static"))
+        .route_service("/ui/sw.js", ServeFile::new("static/js/sw.js"))
         // Root redirect
         .route(
             "/",
@@ -76,5 +271,74 @@ pub fn build_router(state: AppState) -> Router {
                 axum::response::Redirect::to("/ui/")
             }),
         )
+        .fallback(not_found)
+        // Middleware, innermost first: each layer wraps the ones above it.
+        // recover turns handler panics into 500s for everything outside it;
+        // html_errors sits outside authorize and same_origin so their 403s
+        // get the sign-in link; metrics and logging see the final status,
+        // and the access log the body size before compression.
+        .layer(middleware::from_fn_with_state(state.clone(), layers::recover))
+        .layer(middleware::from_fn(console::deprecate_legacy))
+        .layer(middleware::from_fn_with_state(state.clone(), standby_redirect))
+        .layer(middleware::from_fn(layers::read_only))
+        .layer(middleware::from_fn_with_state(state.clone(), layers::authorize))
+        .layer(middleware::from_fn(layers::same_origin))
+        .layer(middleware::from_fn_with_state(state.clone(), ui::html_errors))
+        .layer(middleware::from_fn_with_state(state.clone(), layers::record_metrics))
+        .layer(middleware::from_fn(layers::log_requests))
+        .layer(middleware::from_fn_with_state(state.clone(), layers::access_log))
+        .layer(CompressionLayer::new())
         .with_state(state)
 }
+
+// Unknown paths: a 404 page under /ui (rendered by ui::html_errors), a
+// Kubernetes-style Status everywhere else.
+async fn not_found(uri: Uri) -> Response {
+    let message = format!("the server could not find {}", uri.path());
+    if uri.path().starts_with("/ui") {
+        return (StatusCode::NOT_FOUND, message).into_response();
+    }
+    (
+        StatusCode::NOT_FOUND,
+        Json(Status {
+            api_version: "v1".to_string(),
+            kind: "Status".to_string(),
+            status: "Failure".to_string(),
+            message,
+        }),
+    )
+        .into_response()
+}
+
+// A standby console serves reads itself but sends mutations to the leader,
+// except those acting on the instance itself.
+async fn standby_redirect(State(state): State<AppState>, req: Request, next: Next) -> Response {
+    let read_only = matches!(*req.method(), Method::GET | Method::HEAD | Method::OPTIONS);
+    // Restarts and updates act on the instance they are sent to
+    let own = req.uri().path().starts_with("/api/console/v1alpha1/self/")
+        || req.uri().path().starts_with("/api/admin/update/");
+    if read_only || own || state.leader.is_leader() {
+        return next.run(req).await;
+    }
+
+    match state.leader.leader_url() {
+        Some(base) => {
+            let path = req
+                .uri()
+                .path_and_query()
+                .map(|pq| pq.as_str())
+                .unwrap_or("/");
+            let location = format!("{}{}", base.trim_end_matches('/'), path);
+            (
+                StatusCode::TEMPORARY_REDIRECT,
+                [(header::LOCATION, location)],
+            )
+                .into_response()
+        }
+        None => (
+            StatusCode::SERVICE_UNAVAILABLE,
+            "this console is a standby and the leader is unknown",
+        )
+            .into_response(),
+    }
+}
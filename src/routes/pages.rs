@@ -0,0 +1,523 @@
+use axum::{
+    Router,
+    extract::{FromRequestParts, MatchedPath, RawPathParams},
+    http::request::Parts,
+    routing::{MethodRouter, get, post},
+};
+use std::collections::HashMap;
+use std::convert::Infallible;
+use std::sync::LazyLock;
+
+use super::{sse, ui};
+use crate::AppState;
+
+// Registry of console pages. Each page declares its path pattern, title,
+// breadcrumb label and parent, and top-level pages their sidebar entry; the
+// router, the sidebar in layout.html and every page's breadcrumbs are
+// generated from it. Adding a page is one entry here plus its handler.
+// Titles and labels may use the pattern's {params}.
+//
+// Entries are ordinary axum routes on the shared router, so the API's
+// layers apply to them too, a page can add other methods next to GET or
+// layer its own middleware in its route fn, and handlers take path values
+// with Path as usual.
+
+/// A console page.
+pub struct Page {
+    pub pattern: &'static str,
+    pub title: &'static str,
+    /// Breadcrumb label; defaults to the title.
+    pub crumb: &'static str,
+    /// Pattern of the page one level up the breadcrumb trail.
+    pub parent: Option<&'static str>,
+    /// Sidebar entry highlighted on this page; inherited from the parent
+    /// when empty.
+    pub nav: &'static str,
+    pub menu: Option<MenuEntry>,
+    pub route: fn() -> MethodRouter<AppState>,
+}
+
+/// Where a page appears in the sidebar.
+#[derive(Debug, Clone, Copy)]
+pub struct MenuEntry {
+    pub section: &'static str,
+    pub label: &'static str,
+    /// Inner markup of the entry's 24x24 stroke icon.
+    pub icon: &'static str,
+}
+
+impl Page {
+    fn new(pattern: &'static str, title: &'static str, route: fn() -> MethodRouter<AppState>) -> Self {
+        Self {
+            pattern,
+            title,
+            crumb: title,
+            parent: (pattern != ROOT).then_some(ROOT),
+            nav: "",
+            menu: None,
+            route,
+        }
+    }
+
+    fn crumb(mut self, crumb: &'static str) -> Self {
+        self.crumb = crumb;
+        self
+    }
+
+    fn parent(mut self, parent: &'static str) -> Self {
+        self.parent = Some(parent);
+        self
+    }
+
+    fn nav(mut self, nav: &'static str) -> Self {
+        self.nav = nav;
+        self
+    }
+
+    fn menu(mut self, section: &'static str, nav: &'static str, label: &'static str, icon: &'static str) -> Self {
+        self.nav = nav;
+        self.menu = Some(MenuEntry { section, label, icon });
+        self
+    }
+}
+
+const ROOT: &str = "/ui/";
+
+static PAGES: LazyLock<Vec<Page>> = LazyLock::new(|| {
+    vec![
+        Page::new(ROOT, "Dashboard", || get(ui::handle_dashboard)).menu(
+            "Overview",
+            "dashboard",
+            "Dashboard",
+            r#"<rect x="3" y="3" width="7" height="7"/><rect x="14" y="3" width="7" height="7"/><rect x="3" y="14" width="7" height="7"/><rect x="14" y="14" width="7" height="7"/>"#,
+        ),
+        // Workloads
+        Page::new("/ui/namespaces", "Namespaces", || get(ui::handle_namespaces)).menu(
+            "Workloads",
+            "namespaces",
+            "Namespaces",
+            r#"<path d="M22 19a2 2 0 0 1-2 2H4a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h5l2 3h9a2 2 0 0 1 2 2z"/><line x1="12" y1="11" x2="12" y2="17"/><line x1="9" y1="14" x2="15" y2="14"/>"#,
+        ),
+        Page::new("/ui/namespaces/{namespace}", "Namespace: {namespace}", || get(ui::handle_namespace_detail))
+            .crumb("{namespace}")
+            .parent("/ui/namespaces"),
+        Page::new("/ui/namespaces/{namespace}/pods/{pod}", "Pod: {pod}", || get(ui::handle_pod_detail))
+            .crumb("{pod}")
+            .parent("/ui/namespaces/{namespace}"),
+        Page::new(
+            "/ui/namespaces/{namespace}/pods/{pod}/containers/{name}",
+            "Container: {name}",
+            || get(ui::handle_container_detail),
+        )
+        .crumb("{name}")
+        .parent("/ui/namespaces/{namespace}/pods/{pod}"),
+        Page::new("/ui/pods", "Pods", || get(ui::handle_pods)).nav("pods"),
+        Page::new("/ui/pods/{namespace}/{pod}", "Pod: {pod}", || get(ui::handle_pod_detail))
+            .crumb("{pod}")
+            .parent("/ui/namespaces/{namespace}"),
+        Page::new("/ui/deployments", "Deployments", || {
+            get(ui::handle_deployments).post(ui::handle_create_deployment)
+        })
+        .menu(
+            "Workloads",
+            "deployments",
+            "Deployments",
+            r#"<polyline points="16 18 22 12 16 6"/><polyline points="8 6 2 12 8 18"/>"#,
+        ),
+        Page::new("/ui/deployments/{namespace}/{name}", "Deployment: {name}", || {
+            get(ui::handle_deployment_detail).post(ui::handle_update_deployment)
+        })
+        .crumb("{name}")
+        .parent("/ui/deployments"),
+        Page::new("/ui/jobs", "Jobs", || get(ui::handle_jobs).post(ui::handle_create_job)).menu(
+            "Workloads",
+            "jobs",
+            "Jobs",
+            r#"<polyline points="9 11 12 14 22 4"/><path d="M21 12v7a2 2 0 0 1-2 2H5a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h11"/>"#,
+        ),
+        Page::new("/ui/jobs/{namespace}/{name}", "Job: {name}", || get(ui::handle_job_detail))
+            .crumb("{name}")
+            .parent("/ui/jobs"),
+        Page::new("/ui/builds", "Builds", || get(ui::handle_builds).post(ui::handle_create_build)).menu(
+            "Workloads",
+            "builds",
+            "Builds",
+            r#"<path d="M14.7 6.3a1 1 0 0 0 0 1.4l1.6 1.6a1 1 0 0 0 1.4 0l3.77-3.77a6 6 0 0 1-7.94 7.94l-6.91 6.91a2.12 2.12 0 0 1-3-3l6.91-6.91a6 6 0 0 1 7.94-7.94l-3.76 3.76z"/>"#,
+        ),
+        Page::new("/ui/cronjobs", "Cron Jobs", || {
+            get(ui::handle_cron_jobs).post(ui::handle_create_cron_job)
+        })
+        .menu(
+            "Workloads",
+            "cronjobs",
+            "Cron Jobs",
+            r#"<rect x="3" y="4" width="18" height="18" rx="2"/><line x1="16" y1="2" x2="16" y2="6"/><line x1="8" y1="2" x2="8" y2="6"/><line x1="3" y1="10" x2="21" y2="10"/>"#,
+        ),
+        Page::new("/ui/cronjobs/{namespace}/{name}", "Cron Job: {name}", || {
+            get(ui::handle_cron_job_detail).post(ui::handle_update_cron_job)
+        })
+        .crumb("{name}")
+        .parent("/ui/cronjobs"),
+        Page::new("/ui/daemonsets", "Daemon Sets", || get(ui::handle_daemon_sets)).menu(
+            "Workloads",
+            "daemonsets",
+            "Daemon Sets",
+            r#"<rect x="2" y="3" width="20" height="6" rx="1"/><rect x="2" y="15" width="20" height="6" rx="1"/><line x1="6" y1="6" x2="6.01" y2="6"/><line x1="6" y1="18" x2="6.01" y2="18"/>"#,
+        ),
+        Page::new("/ui/daemonsets/{namespace}/{name}", "Daemon Set: {name}", || get(ui::handle_daemon_set_detail))
+            .crumb("{name}")
+            .parent("/ui/daemonsets"),
+        Page::new("/ui/configmaps", "ConfigMaps", || {
+            get(ui::handle_configmaps).post(ui::handle_create_configmap)
+        })
+        .menu(
+            "Workloads",
+            "configmaps",
+            "ConfigMaps",
+            r#"<path d="M14 2H6a2 2 0 0 0-2 2v16a2 2 0 0 0 2 2h12a2 2 0 0 0 2-2V8z"/><polyline points="14 2 14 8 20 8"/>"#,
+        ),
+        Page::new("/ui/configmaps/{namespace}/{name}", "ConfigMap: {name}", || get(ui::handle_configmap_detail))
+            .crumb("{name}")
+            .parent("/ui/configmaps"),
+        Page::new("/ui/services", "Services", || get(ui::handle_services)).menu(
+            "Workloads",
+            "services",
+            "Services",
+            r#"<circle cx="12" cy="5" r="3"/><circle cx="5" cy="19" r="3"/><circle cx="19" cy="19" r="3"/><line x1="12" y1="8" x2="5" y2="16"/><line x1="12" y1="8" x2="19" y2="16"/>"#,
+        ),
+        Page::new("/ui/secrets", "Secrets", || get(ui::handle_secrets).post(ui::handle_create_secret)).menu(
+            "Workloads",
+            "secrets",
+            "Secrets",
+            r#"<rect x="3" y="11" width="18" height="11" rx="2" ry="2"/><path d="M7 11V7a5 5 0 0 1 10 0v4"/>"#,
+        ),
+        Page::new("/ui/secrets/{namespace}/{name}", "Secret: {name}", || get(ui::handle_secret_detail))
+            .crumb("{name}")
+            .parent("/ui/secrets"),
+        // Infrastructure
+        Page::new("/ui/nodes", "Nodes", || get(ui::handle_nodes)).menu(
+            "Infrastructure",
+            "nodes",
+            "Nodes",
+            r#"<rect x="2" y="2" width="20" height="8" rx="2"/><rect x="2" y="14" width="20" height="8" rx="2"/><line x1="6" y1="6" x2="6.01" y2="6"/><line x1="6" y1="18" x2="6.01" y2="18"/>"#,
+        ),
+        Page::new("/ui/nodes/claim", "Claim a Node", || {
+            get(ui::handle_claim_page).post(ui::handle_claim_submit)
+        })
+        .crumb("Claim")
+        .parent("/ui/nodes"),
+        Page::new("/ui/nodes/{name}", "Node: {name}", || get(ui::handle_node_detail))
+            .crumb("{name}")
+            .parent("/ui/nodes"),
+        Page::new("/ui/map", "Cluster Map", || get(ui::handle_map)).menu(
+            "Infrastructure",
+            "map",
+            "Cluster Map",
+            r#"<polygon points="1 6 1 22 8 18 16 22 23 18 23 2 16 6 8 2 1 6"/><line x1="8" y1="2" x2="8" y2="18"/><line x1="16" y1="6" x2="16" y2="22"/>"#,
+        ),
+        Page::new("/ui/pools", "Node Pools", || get(ui::handle_pools)).menu(
+            "Infrastructure",
+            "pools",
+            "Node Pools",
+            r#"<rect x="3" y="3" width="7" height="7" rx="1"/><rect x="14" y="3" width="7" height="7" rx="1"/><rect x="3" y="14" width="7" height="7" rx="1"/><rect x="14" y="14" width="7" height="7" rx="1"/>"#,
+        ),
+        Page::new("/ui/devices", "Devices", || get(ui::handle_devices)).menu(
+            "Infrastructure",
+            "devices",
+            "Devices",
+            r#"<rect x="4" y="4" width="16" height="16" rx="2"/><rect x="9" y="9" width="6" height="6"/><line x1="9" y1="1" x2="9" y2="4"/><line x1="15" y1="1" x2="15" y2="4"/><line x1="9" y1="20" x2="9" y2="23"/><line x1="15" y1="20" x2="15" y2="23"/><line x1="20" y1="9" x2="23" y2="9"/><line x1="20" y1="14" x2="23" y2="14"/><line x1="1" y1="9" x2="4" y2="9"/><line x1="1" y1="14" x2="4" y2="14"/>"#,
+        ),
+        Page::new("/ui/networks", "Networks", || get(ui::handle_networks)).menu(
+            "Infrastructure",
+            "networks",
+            "Networks",
+            r#"<circle cx="12" cy="12" r="10"/><line x1="2" y1="12" x2="22" y2="12"/><path d="M12 2a15.3 15.3 0 0 1 4 10 15.3 15.3 0 0 1-4 10 15.3 15.3 0 0 1-4-10 15.3 15.3 0 0 1 4-10z"/>"#,
+        ),
+        Page::new("/ui/networks/{name}", "Network: {name}", || get(ui::handle_network_detail))
+            .crumb("{name}")
+            .parent("/ui/networks"),
+        Page::new("/ui/ipam", "IP Addresses", || get(ui::handle_ipam)).menu(
+            "Infrastructure",
+            "ipam",
+            "IP Addresses",
+            r#"<rect x="3" y="4" width="18" height="16" rx="2"/><line x1="7" y1="9" x2="17" y2="9"/><line x1="7" y1="13" x2="17" y2="13"/><line x1="7" y1="17" x2="12" y2="17"/>"#,
+        ),
+        Page::new("/ui/bmh", "Bare Metal Hosts", || get(ui::handle_bmhs)).menu(
+            "Infrastructure",
+            "bmh",
+            "Bare Metal",
+            r#"<rect x="2" y="7" width="20" height="14" rx="2"/><path d="M16 7V5a2 2 0 0 0-2-2h-4a2 2 0 0 0-2 2v2"/>"#,
+        ),
+        Page::new("/ui/bmh/{namespace}/{name}", "BMH: {name}", || get(ui::handle_bmh_detail))
+            .crumb("{name}")
+            .parent("/ui/bmh"),
+        Page::new("/ui/registry", "Registry", || get(ui::handle_registry)).menu(
+            "Infrastructure",
+            "registry",
+            "Registry",
+            r#"<path d="M22 19a2 2 0 0 1-2 2H4a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h5l2 3h9a2 2 0 0 1 2 2z"/>"#,
+        ),
+        Page::new("/ui/pvcs", "PVCs", || get(ui::handle_pvcs)).menu(
+            "Infrastructure",
+            "pvcs",
+            "PVCs",
+            r#"<path d="M21 16V8a2 2 0 0 0-1-1.73l-7-4a2 2 0 0 0-2 0l-7 4A2 2 0 0 0 3 8v8a2 2 0 0 0 1 1.73l7 4a2 2 0 0 0 2 0l7-4A2 2 0 0 0 21 16z"/>"#,
+        ),
+        Page::new("/ui/storage", "Shared Storage", || {
+            get(ui::handle_storage).post(ui::handle_add_storage)
+        })
+        .menu(
+            "Infrastructure",
+            "storage",
+            "Shared Storage",
+            r#"<ellipse cx="12" cy="5" rx="9" ry="3"/><path d="M21 12c0 1.66-4 3-9 3s-9-1.34-9-3"/><path d="M3 5v14c0 1.66 4 3 9 3s9-1.34 9-3V5"/>"#,
+        ),
+        Page::new("/ui/iscsi-cdroms", "iSCSI CDROMs", || get(ui::handle_iscsi_cdroms)).menu(
+            "Infrastructure",
+            "iscsi-cdroms",
+            "iSCSI CDROMs",
+            r#"<circle cx="12" cy="12" r="10"/><circle cx="12" cy="12" r="3"/>"#,
+        ),
+        Page::new("/ui/iscsi-cdroms/{name}", "iSCSI CDROM: {name}", || get(ui::handle_iscsi_cdrom_detail))
+            .crumb("{name}")
+            .parent("/ui/iscsi-cdroms"),
+        // Operations
+        Page::new("/ui/consistency", "Consistency", || get(ui::handle_consistency)).menu(
+            "Operations",
+            "consistency",
+            "Consistency",
+            r#"<path d="M22 11.08V12a10 10 0 1 1-5.93-9.14"/><polyline points="22 4 12 14.01 9 11.01"/>"#,
+        ),
+        Page::new("/ui/connectivity", "Connectivity", || get(ui::handle_connectivity)).menu(
+            "Operations",
+            "connectivity",
+            "Connectivity",
+            r#"<circle cx="5" cy="12" r="2"/><circle cx="19" cy="5" r="2"/><circle cx="19" cy="19" r="2"/><line x1="7" y1="11" x2="17" y2="6"/><line x1="7" y1="13" x2="17" y2="18"/>"#,
+        ),
+        Page::new("/ui/port-check", "Port Check", || get(ui::handle_port_check)).menu(
+            "Operations",
+            "port-check",
+            "Port Check",
+            r#"<path d="M5 12h14"/><path d="M12 5l7 7-7 7"/><line x1="3" y1="5" x2="3" y2="19"/>"#,
+        ),
+        Page::new("/ui/diagnostics", "Diagnostics", || get(ui::handle_diagnostics)).menu(
+            "Operations",
+            "diagnostics",
+            "Diagnostics",
+            r#"<circle cx="11" cy="11" r="7"/><line x1="21" y1="21" x2="16.65" y2="16.65"/><path d="M8 11h6"/>"#,
+        ),
+        Page::new("/ui/stuck-pods", "Stuck Pods", || get(ui::handle_stuck_pods)).menu(
+            "Operations",
+            "stuck-pods",
+            "Stuck Pods",
+            r#"<circle cx="12" cy="12" r="10"/><line x1="10" y1="15" x2="10" y2="9"/><line x1="14" y1="15" x2="14" y2="9"/>"#,
+        ),
+        Page::new("/ui/events", "Events", || get(ui::handle_events)).menu(
+            "Operations",
+            "events",
+            "Events",
+            r#"<polyline points="22 12 18 12 15 21 9 3 6 12 2 12"/>"#,
+        ),
+        Page::new("/ui/logs", "Logs", || get(ui::handle_logs)).menu(
+            "Operations",
+            "logs",
+            "Logs",
+            r#"<line x1="8" y1="6" x2="21" y2="6"/><line x1="8" y1="12" x2="21" y2="12"/><line x1="8" y1="18" x2="21" y2="18"/><line x1="3" y1="6" x2="3.01" y2="6"/><line x1="3" y1="12" x2="3.01" y2="12"/><line x1="3" y1="18" x2="3.01" y2="18"/>"#,
+        ),
+        Page::new("/ui/alerts", "Alerts", || get(ui::handle_alerts)).menu(
+            "Operations",
+            "alerts",
+            "Alerts",
+            r#"<path d="M18 8A6 6 0 0 0 6 8c0 7-3 9-3 9h18s-3-2-3-9"/><path d="M13.73 21a2 2 0 0 1-3.46 0"/>"#,
+        ),
+        Page::new("/ui/archive/{id}", "Capture #{id}", || get(ui::handle_archive_entry)).parent("/ui/alerts"),
+        Page::new("/ui/alerts/sandbox", "Rule Sandbox", || get(ui::handle_rule_sandbox)).parent("/ui/alerts"),
+        Page::new("/ui/activity", "Activity", || get(ui::handle_activity)).menu(
+            "Operations",
+            "activity",
+            "Activity",
+            r#"<circle cx="12" cy="12" r="10"/><polyline points="12 6 12 12 16 14"/>"#,
+        ),
+        // Wall display; full screen, outside the sidebar
+        Page::new("/ui/kiosk", "Kiosk", || get(ui::handle_kiosk)),
+        // Reached from the Share buttons on the dashboard, nodes and logs
+        Page::new("/ui/share", "Share a View", || {
+            get(ui::handle_share_page).post(ui::handle_create_share_link)
+        }),
+        // Admin
+        Page::new("/ui/encryption", "Encryption", || get(ui::handle_encryption)).menu(
+            "Admin",
+            "encryption",
+            "Encryption",
+            r#"<rect x="4" y="11" width="16" height="10" rx="2"/><path d="M8 11V7a4 4 0 0 1 8 0v4"/>"#,
+        ),
+        Page::new("/ui/login-attempts", "Sign-in Attempts", || get(ui::handle_login_attempts)).menu(
+            "Admin",
+            "login-attempts",
+            "Sign-in Attempts",
+            r#"<rect x="3" y="11" width="18" height="11" rx="2"/><path d="M7 11V7a5 5 0 0 1 9.9-1"/>"#,
+        ),
+        Page::new("/ui/read-only", "Read-only Mode", || {
+            get(ui::handle_read_only).post(ui::handle_set_read_only)
+        })
+        .menu(
+            "Admin",
+            "read-only",
+            "Read-only Mode",
+            r#"<path d="M1 12s4-8 11-8 11 8 11 8-4 8-11 8-11-8-11-8z"/><circle cx="12" cy="12" r="3"/>"#,
+        ),
+        Page::new("/ui/schedule", "Background Work", || get(ui::handle_schedule)).menu(
+            "Admin",
+            "schedule",
+            "Background Work",
+            r#"<path d="M21 12a9 9 0 1 1-6.22-8.56"/><polyline points="21 3 21 9 15 9"/>"#,
+        ),
+        Page::new("/ui/telemetry", "Telemetry", || get(ui::handle_telemetry)).menu(
+            "Admin",
+            "telemetry",
+            "Telemetry",
+            r#"<path d="M22 12h-4l-3 9L9 3l-3 9H2"/>"#,
+        ),
+        Page::new("/ui/update", "Console Update", || get(ui::handle_update)).menu(
+            "Admin",
+            "update",
+            "Console Update",
+            r#"<path d="M12 3v12"/><polyline points="7 10 12 15 17 10"/><path d="M5 21h14"/>"#,
+        ),
+    ]
+});
+
+/// Routes for every registered page, plus the UI's form actions, downloads
+/// and event streams, which share the /ui prefix but aren't pages.
+pub fn router() -> Router<AppState> {
+    PAGES
+        .iter()
+        .fold(Router::new(), |r, p| r.route(p.pattern, (p.route)()))
+        .route("/ui/favorites", post(ui::handle_toggle_favorite))
+        .route("/ui/nodes/{name}/wake", post(ui::handle_wake_node))
+        .route(
+            "/ui/nodes/{name}/restart-pods",
+            get(ui::handle_node_restarts).post(ui::handle_restart_node_pods),
+        )
+        .route("/ui/pools/{name}/cordon", post(ui::handle_cordon_pool))
+        .route("/ui/storage/{name}/remove", post(ui::handle_remove_storage))
+        .route("/ui/configmaps/{namespace}/{name}/delete", post(ui::handle_delete_configmap))
+        .route("/ui/deployments/{namespace}/{name}/delete", post(ui::handle_delete_deployment))
+        .route("/ui/jobs/{namespace}/{name}/delete", post(ui::handle_delete_job))
+        .route("/ui/cronjobs/{namespace}/{name}/delete", post(ui::handle_delete_cron_job))
+        .route("/ui/secrets/{namespace}/{name}/delete", post(ui::handle_delete_secret))
+        .route("/ui/pods/{namespace}/{pod}/explain", get(ui::handle_explain_pod))
+        .route("/ui/stuck-pods/{namespace}/{name}", post(ui::handle_remediate_pod))
+        .route("/ui/ipam.csv", get(ui::handle_ipam_csv))
+        .route("/ui/stale", get(ui::handle_stale_banner))
+        .route("/ui/version", get(ui::handle_version_notice))
+        .route("/ui/events/pods", get(sse::handle_pod_events))
+}
+
+fn find(pattern: &str) -> Option<&'static Page> {
+    PAGES.iter().find(|p| p.pattern == pattern)
+}
+
+#[derive(Debug, Clone)]
+pub struct Breadcrumb {
+    pub label: String,
+    pub url: String,
+}
+
+#[derive(Debug, Clone)]
+pub struct MenuSection {
+    pub title: &'static str,
+    pub items: Vec<MenuItem>,
+}
+
+#[derive(Debug, Clone)]
+pub struct MenuItem {
+    pub nav: &'static str,
+    pub label: &'static str,
+    pub url: &'static str,
+    pub icon: &'static str,
+}
+
+/// The sidebar, in registry order.
+pub fn menu() -> Vec<MenuSection> {
+    let mut sections: Vec<MenuSection> = Vec::new();
+    for p in PAGES.iter() {
+        let Some(m) = p.menu else {
+            continue;
+        };
+        let item = MenuItem {
+            nav: p.nav,
+            label: m.label,
+            url: p.pattern,
+            icon: m.icon,
+        };
+        match sections.iter_mut().find(|s| s.title == m.section) {
+            Some(s) => s.items.push(item),
+            None => sections.push(MenuSection {
+                title: m.section,
+                items: vec![item],
+            }),
+        }
+    }
+    sections
+}
+
+/// Title, sidebar highlight and breadcrumbs of the page being served, from
+/// the registry entry its route matched. UI handlers take this as an
+/// extractor and copy it into their template.
+#[derive(Debug, Clone, Default)]
+pub struct PageNav {
+    pub title: String,
+    pub current_nav: String,
+    pub breadcrumbs: Vec<Breadcrumb>,
+}
+
+impl PageNav {
+    fn build(page: &'static Page, params: &HashMap<String, String>) -> Self {
+        let mut trail = Vec::new();
+        let mut current_nav = "";
+        let mut next = Some(page);
+        while let Some(p) = next {
+            if current_nav.is_empty() {
+                current_nav = p.nav;
+            }
+            trail.push(Breadcrumb {
+                label: fill(p.crumb, params),
+                url: fill(p.pattern, params),
+            });
+            next = p.parent.and_then(find);
+        }
+        trail.reverse();
+        Self {
+            title: fill(page.title, params),
+            current_nav: current_nav.to_string(),
+            breadcrumbs: trail,
+        }
+    }
+}
+
+impl<S: Send + Sync> FromRequestParts<S> for PageNav {
+    type Rejection = Infallible;
+
+    async fn from_request_parts(parts: &mut Parts, state: &S) -> Result<Self, Self::Rejection> {
+        let Some(page) = parts
+            .extensions
+            .get::<MatchedPath>()
+            .and_then(|m| find(m.as_str()))
+        else {
+            return Ok(Self::default());
+        };
+        let params = match RawPathParams::from_request_parts(parts, state).await {
+            Ok(raw) => raw.iter().map(|(k, v)| (k.to_string(), v.to_string())).collect(),
+            Err(_) => HashMap::new(),
+        };
+        Ok(Self::build(page, &params))
+    }
+}
+
+// Replaces {param} placeholders with the request's path parameters.
+fn fill(template: &str, params: &HashMap<String, String>) -> String {
+    params
+        .iter()
+        .fold(template.to_string(), |s, (k, v)| s.replace(&format!("{{{}}}", k), v))
+}
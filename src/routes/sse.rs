@@ -11,6 +11,8 @@ use std::pin::Pin;
 use std::time::Duration;
 
 use crate::AppState;
+use crate::models::k8s::Pod;
+use crate::secrets;
 
 type SseStream = Pin<Box<dyn Stream<Item = Result<Event, Infallible>> + Send>>;
 
@@ -38,7 +40,7 @@ pub async fn handle_pod_events(State(state): State<AppState>) -> Response {
                 if let Ok(body) = resp.text().await {
                     for line in body.lines() {
                         if !line.trim().is_empty() {
-                            watch_lines.push(line.to_string());
+                            watch_lines.push(redact_watch_line(line));
                         }
                     }
                     has_watch = true;
@@ -70,7 +72,8 @@ pub async fn handle_pod_events(State(state): State<AppState>) -> Response {
 
             // Poll for full state every 3 seconds
             tokio::time::sleep(Duration::from_secs(3)).await;
-            let pods = agg.list_all_pods().await.unwrap_or_default();
+            let mut pods = agg.list_all_pods().await.unwrap_or_default();
+            pods.iter_mut().for_each(secrets::redact);
             let data = serde_json::to_string(&pods).unwrap_or_default();
             let event = Event::default().event("pod-list").data(data);
             Some((Ok(event), (agg, _has_watch, Vec::new(), false)))
@@ -81,3 +84,53 @@ pub async fn handle_pod_events(State(state): State<AppState>) -> Response {
         .keep_alive(KeepAlive::default().interval(Duration::from_secs(15)))
         .into_response()
 }
+
+/// Replication stream consumed by follower consoles. Sends a full snapshot
+/// on connect and afterwards only when pods, nodes or node health changed.
+pub async fn handle_replication_stream(State(state): State<AppState>) -> Response {
+    let agg = state.aggregator.clone();
+
+    let stream = stream::unfold(
+        (agg, String::new(), true),
+        |(agg, last, is_first)| async move {
+            if !is_first {
+                tokio::time::sleep(Duration::from_secs(5)).await;
+            }
+            let mut last = last;
+            loop {
+                let mut snap = agg.replication_snapshot().await;
+                snap.pods.iter_mut().for_each(secrets::redact);
+                let body = serde_json::to_string(&snap).unwrap_or_default();
+                if body != last {
+                    snap.taken_at = Some(chrono::Utc::now());
+                    let data = serde_json::to_string(&snap).unwrap_or_default();
+                    let event = Event::default().event("snapshot").data(data);
+                    return Some((Ok::<_, Infallible>(event), (agg, body, false)));
+                }
+                last = body;
+                tokio::time::sleep(Duration::from_secs(5)).await;
+            }
+        },
+    );
+
+    Sse::new(stream)
+        .keep_alive(KeepAlive::default().interval(Duration::from_secs(15)))
+        .into_response()
+}
+
+// A node's watch line with the pod's resolved secrets redacted. Lines
+// that don't hold a pod pass through.
+fn redact_watch_line(line: &str) -> String {
+    let Ok(mut event) = serde_json::from_str::<serde_json::Value>(line) else {
+        return line.to_string();
+    };
+    let Some(object) = event.get_mut("object") else {
+        return line.to_string();
+    };
+    let Ok(mut pod) = serde_json::from_value::<Pod>(object.take()) else {
+        return line.to_string();
+    };
+    secrets::redact(&mut pod);
+    *object = serde_json::to_value(&pod).unwrap_or_default();
+    event.to_string()
+}
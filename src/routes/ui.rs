@@ -1,17 +1,60 @@
 use askama::Template;
 use axum::{
-    extract::{Path, Query, State},
-    http::StatusCode,
-    response::{Html, IntoResponse, Response},
+    Form,
+    extract::{Path, Query, Request, State},
+    http::{HeaderMap, HeaderValue, StatusCode, header},
+    middleware::Next,
+    response::{Html, IntoResponse, Redirect, Response},
 };
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD;
 use serde::Deserialize;
-use std::collections::{BTreeMap, BTreeSet, HashMap};
-
-use crate::helpers::{human_bytes, human_time, parse_age};
+use std::collections::{BTreeMap, BTreeSet, HashMap, HashSet};
+
+use crate::activity::ActivityEntry;
+use crate::admission;
+use crate::alerts::Alert;
+use crate::archive::ArchiveEntry;
+use crate::builds;
+use crate::clients::{HealthSample, LogOptions};
+use crate::clients::aggregator;
+use crate::clients::decisions::PlacementDecision;
+use crate::config::{AlertRuleConfig, KioskPanel, NodeLocation};
+use crate::controllers::alert_rules;
+use crate::cronjobs::CronJobError;
+use crate::crypto;
+use crate::daemonsets::MicroDaemonSet;
+use crate::deployments::DeploymentError;
+use crate::diagnostics;
+use crate::dns;
+use crate::explain;
+use crate::favorites::{FAVORITE_KINDS, Favorite};
+use crate::filters;
+use crate::helpers::{
+    human_bytes, human_cpu, human_duration_secs, human_rate, human_time, parse_age,
+    parse_cpu_millis, parse_memory_bytes, request_user, url_encode,
+};
+use crate::ipam;
+use crate::jobs::JobError;
+use crate::metrics::{BandwidthSample, InterfaceRate};
 use crate::models::k8s;
 use crate::models::views::*;
+use crate::namespaces::DEFAULT_NAMESPACE;
+use crate::pools;
+use crate::restarts::{RestartOptions, RestartRun};
+use crate::scrub;
+use crate::resources;
+use crate::secrets;
+use crate::share::ShareTarget;
+use crate::storage::ShareRequest;
+use crate::stuck;
 use crate::AppState;
 
+use super::api::{
+    AdmissionTestRequest, ClaimRequest, ScrubQuery, admit_cron_job, admit_job, admit_template, claim_node, delete_job,
+};
+use super::pages::{Breadcrumb, PageNav};
+
 // --- Namespaces ---
 
 #[derive(Template)]
@@ -23,12 +66,24 @@ struct NamespacesTemplate {
     namespaces: Vec<NamespaceView>,
 }
 
-pub async fn handle_namespaces(State(state): State<AppState>) -> Response {
+pub async fn handle_namespaces(State(state): State<AppState>, nav: PageNav) -> Response {
     let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
 
     let mut ns_map: std::collections::BTreeMap<String, NamespaceView> =
         std::collections::BTreeMap::new();
 
+    // Namespaces created ahead of their pods are listed empty
+    let created = state.namespaces.list().into_iter().map(|ns| ns.metadata.name);
+    for name in std::iter::once(DEFAULT_NAMESPACE.to_string()).chain(created) {
+        ns_map.insert(
+            name.clone(),
+            NamespaceView {
+                name,
+                ..Default::default()
+            },
+        );
+    }
+
     for pod in &all_pods {
         let entry = ns_map
             .entry(pod.metadata.namespace.clone())
@@ -60,18 +115,9 @@ pub async fn handle_namespaces(State(state): State<AppState>) -> Response {
         .collect();
 
     let tmpl = NamespacesTemplate {
-        title: "Namespaces".to_string(),
-        current_nav: "namespaces".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Namespaces".to_string(),
-                url: "/ui/namespaces".to_string(),
-            },
-        ],
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
         namespaces,
     };
 
@@ -92,11 +138,17 @@ struct NamespaceDetailTemplate {
     pending: usize,
     failed: usize,
     pods: Vec<PodView>,
+    apps: Vec<DeploymentView>,
+    configmaps: Vec<ConfigMapView>,
+    quota: Vec<QuotaUsageView>,
+    node_shares: Vec<NodeShareView>,
+    events: Vec<EventView>,
 }
 
 pub async fn handle_namespace_detail(
     State(state): State<AppState>,
     Path(name): Path<String>,
+    nav: PageNav,
 ) -> Response {
     let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
 
@@ -104,6 +156,9 @@ pub async fn handle_namespace_detail(
     let mut pending = 0usize;
     let mut failed = 0usize;
     let mut pod_views = Vec::new();
+    let mut per_node: BTreeMap<String, usize> = BTreeMap::new();
+    let mut cpu = (0i64, 0i64);
+    let mut memory = (0i64, 0i64);
 
     for pod in &all_pods {
         if pod.metadata.namespace != name {
@@ -115,39 +170,132 @@ pub async fn handle_namespace_detail(
             "Failed" => failed += 1,
             _ => {}
         }
-        pod_views.push(build_pod_view(pod));
+        // Finished pods no longer hold their resources
+        if pod.status.phase != "Succeeded" && pod.status.phase != "Failed" {
+            for c in &pod.spec.containers {
+                let r = &c.resources;
+                cpu.0 += r.requests.get("cpu").and_then(|q| parse_cpu_millis(q)).unwrap_or(0);
+                cpu.1 += r.limits.get("cpu").and_then(|q| parse_cpu_millis(q)).unwrap_or(0);
+                memory.0 += r.requests.get("memory").and_then(|q| parse_memory_bytes(q)).unwrap_or(0);
+                memory.1 += r.limits.get("memory").and_then(|q| parse_memory_bytes(q)).unwrap_or(0);
+            }
+        }
+        let pv = build_pod_view(pod);
+        let node = if pv.node.is_empty() { "unscheduled".to_string() } else { pv.node.clone() };
+        *per_node.entry(node).or_default() += 1;
+        pod_views.push(pv);
     }
 
     let pod_count = pod_views.len();
 
+    let node_shares = per_node
+        .into_iter()
+        .map(|(node, pods)| NodeShareView {
+            node,
+            pods,
+            percent: pods * 100 / pod_count.max(1),
+        })
+        .collect();
+
+    let quota_cfg = state.config.namespace_quotas.get(&name);
+    let quota = vec![
+        build_quota_usage(
+            "CPU",
+            cpu,
+            quota_cfg.and_then(|q| q.cpu.as_deref()).and_then(parse_cpu_millis),
+            human_cpu,
+        ),
+        build_quota_usage(
+            "Memory",
+            memory,
+            quota_cfg.and_then(|q| q.memory.as_deref()).and_then(parse_memory_bytes),
+            human_bytes,
+        ),
+    ];
+
+    let apps = state
+        .aggregator
+        .list_deployments()
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter(|d| d.metadata.namespace == name)
+        .map(build_deployment_view)
+        .collect();
+
+    let configmaps = state
+        .aggregator
+        .list_configmaps(&name)
+        .await
+        .unwrap_or_default()
+        .iter()
+        .map(|cm| ConfigMapView {
+            name: cm.metadata.name.clone(),
+            namespace: cm.metadata.namespace.clone(),
+            key_count: cm.data.len(),
+            age: parse_age(&cm.metadata.creation_timestamp),
+        })
+        .collect();
+
+    let events: Vec<EventView> = state
+        .aggregator
+        .list_events()
+        .await
+        .unwrap_or_default()
+        .iter()
+        .rev()
+        .filter(|e| e.involved_object.namespace == name || e.metadata.namespace == name)
+        .take(20)
+        .map(build_event_view)
+        .collect();
+
     let tmpl = NamespaceDetailTemplate {
-        title: format!("Namespace: {}", name),
-        current_nav: "namespaces".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Namespaces".to_string(),
-                url: "/ui/namespaces".to_string(),
-            },
-            Breadcrumb {
-                label: name.clone(),
-                url: String::new(),
-            },
-        ],
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
         namespace_name: name,
         pod_count,
         running,
         pending,
         failed,
         pods: pod_views,
+        apps,
+        configmaps,
+        quota,
+        node_shares,
+        events,
     };
 
     render_template(&tmpl)
 }
 
+fn build_quota_usage(
+    resource: &str,
+    (requested, limits): (i64, i64),
+    quota: Option<i64>,
+    fmt: fn(i64) -> String,
+) -> QuotaUsageView {
+    let percent = match quota {
+        Some(q) if q > 0 => requested * 100 / q,
+        _ => 0,
+    };
+    QuotaUsageView {
+        resource: resource.to_string(),
+        requested: fmt(requested),
+        limits: fmt(limits),
+        quota: quota.map(fmt).unwrap_or_default(),
+        percent,
+        bar_class: if percent >= 100 {
+            "red"
+        } else if percent >= 80 {
+            "yellow"
+        } else {
+            "green"
+        }
+        .to_string(),
+    }
+}
+
 // --- Container Detail ---
 
 #[derive(Template)]
@@ -157,11 +305,20 @@ struct ContainerDetailTemplate {
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
     container: ContainerDetailView,
+    previous: bool,
+}
+
+#[derive(Deserialize)]
+pub struct ContainerQuery {
+    #[serde(default)]
+    pub previous: bool,
 }
 
 pub async fn handle_container_detail(
     State(state): State<AppState>,
     Path((namespace, pod_name, container_name)): Path<(String, String, String)>,
+    Query(query): Query<ContainerQuery>,
+    nav: PageNav,
 ) -> Response {
     let (pod, _node_name) = match state.aggregator.get_pod(&namespace, &pod_name).await {
         Ok(r) => r,
@@ -193,6 +350,11 @@ pub async fn handle_container_detail(
     };
 
     let ready = container_status.map(|cs| cs.ready).unwrap_or(false);
+    let restart_count = container_status.map(|cs| cs.restart_count).unwrap_or(0);
+    let last_termination = container_status
+        .and_then(|cs| cs.last_state.terminated.as_ref())
+        .map(|t| format!("{} (exit {})", t.reason, t.exit_code))
+        .unwrap_or_default();
     let image = container_status
         .map(|cs| cs.image.clone())
         .or_else(|| container_spec.map(|c| c.image.clone()))
@@ -218,35 +380,17 @@ pub async fn handle_container_detail(
         state: state_str,
         state_class,
         ready,
+        restart_count,
+        last_termination,
         volume_mounts,
     };
 
     let tmpl = ContainerDetailTemplate {
-        title: format!("Container: {}", container_name),
-        current_nav: "namespaces".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Namespaces".to_string(),
-                url: "/ui/namespaces".to_string(),
-            },
-            Breadcrumb {
-                label: namespace.clone(),
-                url: format!("/ui/namespaces/{}", namespace),
-            },
-            Breadcrumb {
-                label: pod_name.clone(),
-                url: format!("/ui/namespaces/{}/pods/{}", namespace, pod_name),
-            },
-            Breadcrumb {
-                label: container_name,
-                url: String::new(),
-            },
-        ],
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
         container: detail,
+        previous: query.previous,
     };
 
     render_template(&tmpl)
@@ -254,6 +398,42 @@ pub async fn handle_container_detail(
 
 // --- View Builders ---
 
+/// Pod annotation holding a JSON list of `{"name": ..., "url": ...}` quick
+/// links. `{podIP}` in a URL is replaced with the pod's current IP.
+const LINKS_ANNOTATION: &str = "console.mkube.io/links";
+
+#[derive(Deserialize)]
+struct PodLink {
+    name: String,
+    url: String,
+}
+
+fn build_pod_links(pod: &k8s::Pod) -> Vec<PodLinkView> {
+    let raw = match pod
+        .metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(LINKS_ANNOTATION))
+    {
+        Some(r) => r,
+        None => return Vec::new(),
+    };
+    let links: Vec<PodLink> = serde_json::from_str(raw).unwrap_or_default();
+
+    links
+        .into_iter()
+        .map(|l| PodLinkView {
+            name: l.name,
+            url: l.url.replace("{podIP}", &pod.status.pod_ip),
+        })
+        // Only plain web links; anything else (javascript: etc.) is dropped
+        .filter(|l| {
+            !l.name.is_empty()
+                && (l.url.starts_with("http://") || l.url.starts_with("https://"))
+        })
+        .collect()
+}
+
 fn build_pod_view(pod: &k8s::Pod) -> PodView {
     let mut pv = PodView {
         name: pod.metadata.name.clone(),
@@ -269,6 +449,7 @@ fn build_pod_view(pod: &k8s::Pod) -> PodView {
         containers: pod.spec.containers.len(),
         ip: pod.status.pod_ip.clone(),
         age: parse_age(&pod.status.start_time),
+        links: build_pod_links(pod),
         ..Default::default()
     };
 
@@ -289,6 +470,15 @@ fn build_pod_view(pod: &k8s::Pod) -> PodView {
     pv
 }
 
+// Marks a node unreachable while keeping what it last reported.
+fn unreachable(nv: NodeView) -> NodeView {
+    NodeView {
+        status: "Unreachable".to_string(),
+        status_class: "badge-error".to_string(),
+        ..nv
+    }
+}
+
 fn build_node_view(node: &k8s::Node) -> NodeView {
     let mut nv = NodeView {
         name: node.metadata.name.clone(),
@@ -367,6 +557,36 @@ fn build_container_views(pod: &k8s::Pod) -> Vec<ContainerView> {
         .collect()
 }
 
+fn build_env_views(pod: &k8s::Pod) -> Vec<EnvVarView> {
+    let injected = admission::injected_env(pod);
+    let refs = secrets::resolved_refs(pod);
+    pod.spec
+        .containers
+        .iter()
+        .flat_map(|c| {
+            let (injected, refs) = (&injected, &refs);
+            c.env.iter().map(move |e| {
+                let secret_ref = refs
+                    .iter()
+                    .find(|(rc, n, _)| *rc == c.name && *n == e.name)
+                    .map(|(_, _, r)| r.clone())
+                    .unwrap_or_default();
+                EnvVarView {
+                    container: c.name.clone(),
+                    name: e.name.clone(),
+                    value: match e.value_from {
+                        Some(ref from) => from.to_string(),
+                        None if !secret_ref.is_empty() => String::new(),
+                        None => e.value.clone(),
+                    },
+                    injected: injected.iter().any(|(ic, n)| *ic == c.name && *n == e.name),
+                    secret_ref,
+                }
+            })
+        })
+        .collect()
+}
+
 fn build_volume_views(pod: &k8s::Pod) -> Vec<VolumeView> {
     pod.spec
         .containers
@@ -382,12 +602,6 @@ fn build_volume_views(pod: &k8s::Pod) -> Vec<VolumeView> {
 
 // --- Template Structs ---
 
-#[derive(Debug, Clone)]
-struct Breadcrumb {
-    label: String,
-    url: String,
-}
-
 fn render_template(tmpl: &impl Template) -> Response {
     match tmpl.render() {
         Ok(html) => Html(html).into_response(),
@@ -398,8 +612,168 @@ fn render_template(tmpl: &impl Template) -> Response {
     }
 }
 
+// For parts of a page kept in FragmentCache, rendered into the page
+// with |safe.
+fn render_fragment(tmpl: &impl Template) -> Result<String, askama::Error> {
+    tmpl.render().inspect_err(|e| tracing::error!("template error: {}", e))
+}
+
+// --- Error pages ---
+
+#[derive(Template)]
+#[template(path = "error.html")]
+struct ErrorTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    status: u16,
+    heading: String,
+    message: String,
+    /// Pre-filled pod search on 404s: the last segment of the missing path.
+    search: String,
+    request_id: String,
+    login_url: String,
+}
+
+/// Renders plain-text error responses from /ui pages (and the 404 fallback)
+/// as error pages. Handlers keep returning (StatusCode, &str); API routes
+/// are left alone and keep their JSON or text errors. Server errors are
+/// logged with a request ID that the page shows, taken from X-Request-Id
+/// when a proxy set one.
+pub async fn html_errors(State(state): State<AppState>, mut req: Request, next: Next) -> Response {
+    let path = req.uri().path().to_string();
+    let request_id = req
+        .headers()
+        .get("x-request-id")
+        .and_then(|v| v.to_str().ok())
+        .map(String::from)
+        .unwrap_or_else(new_request_id);
+    // Inner layers (recover) log and report under the same ID.
+    if let Ok(v) = HeaderValue::from_str(&request_id) {
+        req.headers_mut().insert("x-request-id", v);
+    }
+    let resp = next.run(req).await;
+
+    let status = resp.status();
+    let is_html = resp
+        .headers()
+        .get(header::CONTENT_TYPE)
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|t| t.starts_with("text/html"));
+    let is_page = path.starts_with("/ui") && !path.starts_with("/ui/static/") && !path.starts_with("/ui/events/");
+    if !is_page || is_html || !(status.is_client_error() || status.is_server_error()) {
+        return resp;
+    }
+
+    let message = match axum::body::to_bytes(resp.into_body(), 64 * 1024).await {
+        Ok(b) => String::from_utf8_lossy(&b).trim().to_string(),
+        Err(_) => String::new(),
+    };
+    if status.is_server_error() {
+        tracing::error!(request_id = %request_id, path = %path, "{}", message);
+    }
+    let heading = match status {
+        StatusCode::NOT_FOUND => "Page not found",
+        StatusCode::FORBIDDEN => "Access denied",
+        s if s.is_server_error() => "Something went wrong",
+        s => s.canonical_reason().unwrap_or("Error"),
+    }
+    .to_string();
+    let tmpl = ErrorTemplate {
+        title: heading.clone(),
+        current_nav: String::new(),
+        breadcrumbs: vec![Breadcrumb {
+            label: "Dashboard".to_string(),
+            url: "/ui/".to_string(),
+        }],
+        status: status.as_u16(),
+        heading,
+        // "Internal Server Error" and the like say nothing the heading doesn't
+        message: if Some(message.as_str()) == status.canonical_reason() {
+            String::new()
+        } else {
+            message.clone()
+        },
+        search: path.rsplit('/').find(|s| !s.is_empty()).unwrap_or_default().to_string(),
+        request_id: request_id.clone(),
+        login_url: state.config.login_url.clone().unwrap_or_default(),
+    };
+    let mut resp = match tmpl.render() {
+        Ok(html) => (status, Html(html)).into_response(),
+        Err(e) => {
+            tracing::error!("error page template: {}", e);
+            (status, message).into_response()
+        }
+    };
+    if let Ok(v) = HeaderValue::from_str(&request_id) {
+        resp.headers_mut().insert("x-request-id", v);
+    }
+    resp
+}
+
+fn new_request_id() -> String {
+    use ring::rand::{SecureRandom, SystemRandom};
+    let mut id = [0u8; 6];
+    let _ = SystemRandom::new().fill(&mut id);
+    id.iter().map(|b| format!("{:02x}", b)).collect()
+}
+
+// --- Stale state ---
+
+#[derive(Template)]
+#[template(path = "stale_banner.html")]
+struct StaleBannerTemplate {
+    /// Empty when every node answers.
+    message: String,
+}
+
+/// The layout's "stale as of" banner: which nodes can't be reached and how
+/// old the state shown for them is. Empty while every node answers.
+pub async fn handle_stale_banner(State(state): State<AppState>) -> Response {
+    let stale = state.aggregator.stale_nodes().await;
+    let message = match stale.first() {
+        None => String::new(),
+        Some((_, oldest)) => {
+            let total = state.aggregator.snapshot_clients().await.len();
+            let names: Vec<&str> = stale.iter().map(|(n, _)| n.as_str()).collect();
+            let which = if names.len() == total {
+                "Can't reach any node".to_string()
+            } else {
+                format!("Can't reach {}", names.join(", "))
+            };
+            format!(
+                "{}; showing last-known state, stale as of {} UTC ({}).",
+                which,
+                oldest.format("%H:%M:%S"),
+                human_time(Some(*oldest))
+            )
+        }
+    };
+    render_template(&StaleBannerTemplate { message })
+}
+
+// --- Version notice ---
+
+#[derive(Template)]
+#[template(path = "version_notice.html")]
+struct VersionNoticeTemplate {
+    latest: String,
+    changelog_url: String,
+}
+
+/// Sidebar notice of a newer release, empty while there is none.
+pub async fn handle_version_notice(State(state): State<AppState>) -> Response {
+    let check = state.updater.last_check().filter(|c| c.available);
+    render_template(&VersionNoticeTemplate {
+        latest: check.as_ref().map(|c| c.latest.clone()).unwrap_or_default(),
+        changelog_url: check.map(|c| c.changelog_url).unwrap_or_default(),
+    })
+}
+
 // --- Dashboard ---
 
+const TOP_TALKERS: usize = 5;
+
 // Pre-computed node summary for templates
 #[derive(Debug, Clone)]
 struct DashboardNodeView {
@@ -415,20 +789,135 @@ struct DashboardTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
+    stats_html: String,
+    cluster_html: String,
+    favorites: Vec<FavoriteView>,
+    recently_viewed: Vec<RecentlyViewedView>,
+    activity: Vec<ActivityView>,
+    more_activity: bool,
+}
+
+#[derive(Template)]
+#[template(path = "dashboard_stats.html")]
+struct DashboardStatsTemplate {
     node_count: usize,
     healthy_nodes: usize,
     pod_count: usize,
     running_pods: usize,
+}
+
+#[derive(Template)]
+#[template(path = "dashboard_cluster.html")]
+struct DashboardClusterTemplate {
     nodes: Vec<DashboardNodeView>,
     recent_pods: Vec<PodView>,
+    accelerators: Vec<AcceleratorView>,
+    top_talkers: Vec<TopTalkerView>,
+}
+
+// The stats row and the cluster-wide sections are the same for everyone and
+// come from FragmentCache; favorites, recently viewed and activity are
+// per user or cheap and are rendered on every request.
+pub async fn handle_dashboard(State(state): State<AppState>, headers: HeaderMap, nav: PageNav) -> Response {
+    let stats_html = state
+        .fragments
+        .get_or_render("dashboard/stats", || render_dashboard_stats(&state))
+        .await;
+    let cluster_html = state
+        .fragments
+        .get_or_render("dashboard/cluster", || render_dashboard_cluster(&state))
+        .await;
+    let (Ok(stats_html), Ok(cluster_html)) = (stats_html, cluster_html) else {
+        return (StatusCode::INTERNAL_SERVER_ERROR, "Internal Server Error").into_response();
+    };
+
+    let user = request_user(&headers);
+    let favs = state.favorites.list(&user);
+    let favorites = if favs.is_empty() {
+        Vec::new()
+    } else {
+        let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+        let nodes = state.aggregator.get_cluster_summary().await.nodes;
+        let deployments = if favs.iter().any(|f| f.kind == "app") {
+            state.aggregator.list_deployments().await.unwrap_or_default()
+        } else {
+            Vec::new()
+        };
+        favs.iter()
+            .map(|f| build_favorite_view(f, &pods, &nodes, &deployments))
+            .collect()
+    };
+
+    let recently_viewed = state
+        .recent
+        .list(&user)
+        .iter()
+        .map(|v| {
+            let (label, url) = resource_link(&v.kind, &v.namespace, &v.name);
+            RecentlyViewedView {
+                kind: v.kind.clone(),
+                label,
+                url,
+                viewed: human_time(Some(v.viewed_at)),
+            }
+        })
+        .collect();
+
+    let (entries, total) = state.activity.page(0, DASHBOARD_ACTIVITY);
+    let activity = entries.iter().map(build_activity_view).collect();
+
+    let tmpl = DashboardTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        stats_html,
+        cluster_html,
+        favorites,
+        recently_viewed,
+        activity,
+        more_activity: total > DASHBOARD_ACTIVITY,
+    };
+
+    render_template(&tmpl)
 }
 
-pub async fn handle_dashboard(State(state): State<AppState>) -> Response {
+async fn render_dashboard_stats(state: &AppState) -> Result<String, askama::Error> {
     let summary = state.aggregator.get_cluster_summary().await;
+    render_fragment(&DashboardStatsTemplate {
+        node_count: summary.node_count,
+        healthy_nodes: summary.healthy_nodes,
+        pod_count: summary.pod_count,
+        running_pods: summary.running_pods,
+    })
+}
 
+async fn render_dashboard_cluster(state: &AppState) -> Result<String, askama::Error> {
+    let summary = state.aggregator.get_cluster_summary().await;
     let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
     let recent_pods: Vec<PodView> = pods.iter().take(10).map(build_pod_view).collect();
 
+    let mut capacity: BTreeMap<String, i64> = BTreeMap::new();
+    for n in state.aggregator.list_all_nodes().await.unwrap_or_default() {
+        for (res, count) in resources::node_extended(&n) {
+            *capacity.entry(res).or_default() += count;
+        }
+    }
+    let accelerators = build_accelerator_views(&capacity, &pods, false);
+
+    let top_talkers = state
+        .metrics
+        .top_talkers(TOP_TALKERS)
+        .into_iter()
+        .map(|p| TopTalkerView {
+            namespace: p.namespace,
+            name: p.name,
+            node: p.node,
+            rx: human_rate(p.rx_rate),
+            tx: human_rate(p.tx_rate),
+            total: human_rate(p.rx_rate + p.tx_rate),
+        })
+        .collect();
+
     let nodes: Vec<DashboardNodeView> = summary
         .nodes
         .iter()
@@ -440,22 +929,124 @@ pub async fn handle_dashboard(State(state): State<AppState>) -> Response {
         })
         .collect();
 
-    let tmpl = DashboardTemplate {
-        title: "Dashboard".to_string(),
-        current_nav: "dashboard".to_string(),
-        breadcrumbs: vec![Breadcrumb {
-            label: "Dashboard".to_string(),
-            url: "/ui/".to_string(),
-        }],
-        node_count: summary.node_count,
-        healthy_nodes: summary.healthy_nodes,
-        pod_count: summary.pod_count,
-        running_pods: summary.running_pods,
+    render_fragment(&DashboardClusterTemplate {
         nodes,
         recent_pods,
+        accelerators,
+        top_talkers,
+    })
+}
+
+// A pinned resource with its current health. Resources that no longer exist
+// stay listed as "Missing" so the user can unpin them.
+fn build_favorite_view(
+    f: &Favorite,
+    pods: &[k8s::Pod],
+    nodes: &[NodeSummary],
+    deployments: &[k8s::Deployment],
+) -> FavoriteView {
+    let missing = ("Missing".to_string(), "badge-error".to_string());
+    let (status, status_class) = match f.kind.as_str() {
+        "pod" => {
+            pods
+                .iter()
+                .find(|p| p.metadata.namespace == f.namespace && p.metadata.name == f.name)
+                .map(|p| {
+                    let v = build_pod_view(p);
+                    (v.status, v.status_class)
+                })
+                .unwrap_or(missing)
+        }
+        "node" => {
+            nodes
+                .iter()
+                .find(|n| n.name == f.name)
+                .map(|n| {
+                    if n.healthy {
+                        ("Ready".to_string(), "badge-success".to_string())
+                    } else {
+                        ("NotReady".to_string(), "badge-error".to_string())
+                    }
+                })
+                .unwrap_or(missing)
+        }
+        _ => {
+            deployments
+                .iter()
+                .find(|d| d.metadata.namespace == f.namespace && d.metadata.name == f.name)
+                .map(|d| {
+                    let v = build_deployment_view(d);
+                    (
+                        format!("{} {}/{}", v.status, v.ready_replicas, v.replicas),
+                        v.status_class,
+                    )
+                })
+                .unwrap_or(missing)
+        }
     };
+    let (label, url) = resource_link(&f.kind, &f.namespace, &f.name);
+
+    FavoriteView {
+        kind: f.kind.clone(),
+        namespace: f.namespace.clone(),
+        name: f.name.clone(),
+        label,
+        url,
+        status,
+        status_class,
+    }
+}
 
-    render_template(&tmpl)
+/// Display label and console URL for a pod, node or app reference.
+fn resource_link(kind: &str, namespace: &str, name: &str) -> (String, String) {
+    match kind {
+        "pod" => (
+            format!("{}/{}", namespace, name),
+            format!("/ui/pods/{}/{}", namespace, name),
+        ),
+        "node" => (name.to_string(), format!("/ui/nodes/{}", name)),
+        _ => (
+            format!("{}/{}", namespace, name),
+            format!("/ui/deployments/{}/{}", namespace, name),
+        ),
+    }
+}
+
+#[derive(Deserialize)]
+pub struct FavoriteForm {
+    pub kind: String,
+    #[serde(default)]
+    pub namespace: String,
+    pub name: String,
+    #[serde(default)]
+    pub redirect: String,
+}
+
+/// Pin/unpin toggle used by the star buttons on detail pages.
+pub async fn handle_toggle_favorite(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<FavoriteForm>,
+) -> Response {
+    if !FAVORITE_KINDS.contains(&form.kind.as_str()) {
+        return (StatusCode::BAD_REQUEST, "invalid favorite kind").into_response();
+    }
+    let user = request_user(&headers);
+    let fav = Favorite {
+        kind: form.kind,
+        namespace: form.namespace,
+        name: form.name,
+    };
+    if !state.favorites.remove(&user, &fav) {
+        state.favorites.add(&user, fav);
+    }
+
+    let redirect = if form.redirect.starts_with("/ui/") {
+        form.redirect
+    } else {
+        "/ui/".to_string()
+    };
+    Redirect::to(&redirect).into_response()
 }
 
 // --- Pods ---
@@ -464,6 +1055,9 @@ pub async fn handle_dashboard(State(state): State<AppState>) -> Response {
 pub struct PodQuery {
     #[serde(default)]
     pub namespace: Option<String>,
+    /// Case-insensitive substring of the pod name.
+    #[serde(default)]
+    pub q: Option<String>,
 }
 
 #[derive(Template)]
@@ -475,42 +1069,59 @@ struct PodsTemplate {
     pods: Vec<PodView>,
     namespaces: Vec<String>,
     filter: String,
+    search: String,
+    /// This page with its filters, for the table's periodic refresh.
+    refresh_url: String,
+    /// Storage catalog entries Create Pod offers to mount.
+    shares: Vec<String>,
 }
 
 pub async fn handle_pods(
     State(state): State<AppState>,
     Query(query): Query<PodQuery>,
+    nav: PageNav,
 ) -> Response {
     let ns_filter = query.namespace.unwrap_or_default();
+    let search = query.q.unwrap_or_default();
+    let needle = search.to_lowercase();
     let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
 
     let mut namespaces = BTreeSet::new();
     let mut pod_views = Vec::new();
+    let pending: HashSet<(String, String)> = state
+        .config_rollouts
+        .pending(None)
+        .into_iter()
+        .map(|p| (p.namespace, p.name))
+        .collect();
 
     for pod in &all_pods {
         namespaces.insert(pod.metadata.namespace.clone());
         if !ns_filter.is_empty() && pod.metadata.namespace != ns_filter {
             continue;
         }
-        pod_views.push(build_pod_view(pod));
+        if !needle.is_empty() && !pod.metadata.name.to_lowercase().contains(&needle) {
+            continue;
+        }
+        let mut pv = build_pod_view(pod);
+        pv.restart_pending = pending.contains(&(pv.namespace.clone(), pv.name.clone()));
+        pod_views.push(pv);
     }
 
     let tmpl = PodsTemplate {
-        title: "Pods".to_string(),
-        current_nav: "pods".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Pods".to_string(),
-                url: "/ui/pods".to_string(),
-            },
-        ],
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
         pods: pod_views,
         namespaces: namespaces.into_iter().collect(),
+        refresh_url: format!(
+            "/ui/pods?namespace={}&q={}",
+            url_encode(&ns_filter),
+            url_encode(&search)
+        ),
         filter: ns_filter,
+        search,
+        shares: state.storage.list().into_iter().map(|s| s.name).collect(),
     };
 
     render_template(&tmpl)
@@ -526,118 +1137,405 @@ struct PodDetailTemplate {
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
     pod: PodView,
+    /// Namespace and name for the page's scripts, via data_json.
+    pod_key: (String, String),
     containers: Vec<ContainerView>,
     volumes: Vec<VolumeView>,
+    env: Vec<EnvVarView>,
     annotations: HashMap<String, String>,
+    /// Annotations left out by config.api.scrub; ?scrub=false shows them.
+    hidden_annotations: usize,
     labels: HashMap<String, String>,
     node: String,
+    pinned: bool,
+    /// Set when the pod's images have no variant for its node's architecture.
+    arch_warning: String,
+    devices: Vec<String>,
+    /// How the console chose the pod's node, if it placed the pod since
+    /// the console started.
+    placement: Option<PlacementView>,
+    /// Why the pod is waiting for a config-change restart, and since when;
+    /// empty when it isn't.
+    pending_restart: String,
+    /// Whether this is the console's own pod.
+    self_pod: bool,
+    /// Whether restarting the console's pod hands off to a standby first.
+    has_standby: bool,
 }
 
 pub async fn handle_pod_detail(
     State(state): State<AppState>,
     Path((namespace, name)): Path<(String, String)>,
+    Query(q): Query<ScrubQuery>,
+    headers: HeaderMap,
+    nav: PageNav,
 ) -> Response {
     let (pod, node_name) = match state.aggregator.get_pod(&namespace, &name).await {
         Ok(r) => r,
         Err(_) => return (StatusCode::NOT_FOUND, "Pod not found").into_response(),
     };
 
+    state.recent.touch(&request_user(&headers), "pod", &namespace, &name);
+
     let pv = build_pod_view(&pod);
     let containers = build_container_views(&pod);
     let volumes = build_volume_views(&pod);
+    let env = build_env_views(&pod);
+
+    let mut annotations = pod.metadata.annotations.clone().unwrap_or_default();
+    let hidden_annotations = scrub::patterns(&state.config.api, q.scrub)
+        .map(|p| scrub::scrub_annotations(&mut annotations, p))
+        .unwrap_or(0);
+
+    let devices = resources::pod_devices(&pod);
+    let arch_warning = match state.aggregator.get_node(&node_name).await {
+        Ok(n) => state
+            .aggregator
+            .arch_mismatch(&pod, &n.status.node_info.architecture)
+            .await
+            .map(|reason| format!("Node {} ({}): {}", node_name, n.status.node_info.architecture, reason))
+            .unwrap_or_default(),
+        Err(_) => String::new(),
+    };
 
     let tmpl = PodDetailTemplate {
-        title: format!("Pod: {}", name),
-        current_nav: "namespaces".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Namespaces".to_string(),
-                url: "/ui/namespaces".to_string(),
-            },
-            Breadcrumb {
-                label: namespace.clone(),
-                url: format!("/ui/namespaces/{}", namespace),
-            },
-            Breadcrumb {
-                label: name.clone(),
-                url: String::new(),
-            },
-        ],
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
         pod: pv,
+        pod_key: (namespace.clone(), name.clone()),
         containers,
         volumes,
-        annotations: pod.metadata.annotations.unwrap_or_default(),
+        env,
+        annotations,
+        hidden_annotations,
         labels: pod.metadata.labels.unwrap_or_default(),
         node: node_name,
+        pinned: state.favorites.is_pinned(
+            &request_user(&headers),
+            &Favorite { kind: "pod".to_string(), namespace: namespace.clone(), name: name.clone() },
+        ),
+        arch_warning,
+        devices,
+        placement: state
+            .aggregator
+            .decisions(Some(&namespace), Some(&name))
+            .first()
+            .map(build_placement_view),
+        pending_restart: state
+            .config_rollouts
+            .pending_for(&namespace, &name)
+            .map(|p| format!("{} {}", p.reason, human_time(Some(p.since))))
+            .unwrap_or_default(),
+        self_pod: state.self_host.is_self(&namespace, &name),
+        has_standby: state.leader.has_standby(),
     };
 
     render_template(&tmpl)
 }
 
-// --- Nodes ---
+fn build_placement_view(d: &PlacementDecision) -> PlacementView {
+    let chosen = d.node.clone().unwrap_or_default();
+    PlacementView {
+        strategy: d.strategy.clone(),
+        reason: d.reason.clone(),
+        when: human_time(Some(d.at)),
+        candidates: d
+            .candidates
+            .iter()
+            .map(|c| PlacementCandidateView {
+                node: c.node.clone(),
+                score: c.score.map(|s| format!("{:.2}", s)).unwrap_or_default(),
+                excluded: c.excluded.clone().unwrap_or_default(),
+                avoided: c.avoided,
+                unpreferred: c.unpreferred,
+                chosen: c.node == chosen,
+            })
+            .collect(),
+        node: chosen,
+    }
+}
 
+/// The pod detail page's "why isn't it running" panel, loaded after the
+/// page since it asks the registry and the scheduler.
 #[derive(Template)]
-#[template(path = "nodes.html")]
-struct NodesTemplate {
-    title: String,
-    current_nav: String,
-    breadcrumbs: Vec<Breadcrumb>,
-    nodes: Vec<NodeView>,
+#[template(path = "explain_panel.html")]
+struct ExplainPanelTemplate {
+    namespace: String,
+    name: String,
+    summary: String,
+    /// Every check passed and the pod isn't stuck.
+    healthy: bool,
+    checks: Vec<explain::Check>,
+    events: Vec<EventView>,
+    history: Vec<ActivityView>,
+    /// (action, label) for the stuck-pod fixes on offer.
+    remediations: Vec<(String, String)>,
 }
 
-pub async fn handle_nodes(State(state): State<AppState>) -> Response {
-    let all_nodes = state.aggregator.list_all_nodes().await.unwrap_or_default();
-    let node_views: Vec<NodeView> = all_nodes.iter().map(build_node_view).collect();
-
-    let tmpl = NodesTemplate {
-        title: "Nodes".to_string(),
-        current_nav: "nodes".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Nodes".to_string(),
-                url: "/ui/nodes".to_string(),
-            },
-        ],
-        nodes: node_views,
+pub async fn handle_explain_pod(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let (pod, node) = match state.aggregator.get_pod(&namespace, &name).await {
+        Ok(r) => r,
+        Err(_) => return (StatusCode::NOT_FOUND, "Pod not found").into_response(),
     };
+    let e = explain::explain(&state.aggregator, &state.activity, &pod, &node).await;
 
+    let tmpl = ExplainPanelTemplate {
+        healthy: e.stuck.is_none() && e.checks.iter().all(|c| c.ok),
+        remediations: e
+            .stuck
+            .iter()
+            .flat_map(|s| &s.remediations)
+            .map(|r| (r.as_str().to_string(), r.label().to_string()))
+            .collect(),
+        events: e.events.iter().rev().map(build_event_view).collect(),
+        history: e.history.iter().map(build_activity_view).collect(),
+        namespace,
+        name,
+        summary: e.summary,
+        checks: e.checks,
+    };
     render_template(&tmpl)
 }
 
-// --- Node Detail ---
+// --- Logs ---
 
-#[derive(Template)]
-#[template(path = "node_detail.html")]
-struct NodeDetailTemplate {
-    title: String,
-    current_nav: String,
+#[derive(Deserialize)]
+pub struct LogsQuery {
+    #[serde(default)]
+    pub pod: Option<String>,
+    #[serde(default)]
+    pub selector: Option<String>,
+    #[serde(default)]
+    pub namespace: Option<String>,
+    #[serde(default)]
+    pub previous: bool,
+}
+
+#[derive(Template)]
+#[template(path = "logs.html")]
+struct LogsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    pods: Vec<String>,
+    namespaces: Vec<String>,
+    selected_pod: String,
+    selector: String,
+    namespace: String,
+    previous: bool,
+    log_url: String,
+}
+
+pub async fn handle_logs(
+    State(state): State<AppState>,
+    Query(query): Query<LogsQuery>,
+    nav: PageNav,
+) -> Response {
+    let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+
+    let mut namespaces = BTreeSet::new();
+    for pod in &all_pods {
+        namespaces.insert(pod.metadata.namespace.clone());
+    }
+    let pods: Vec<String> = all_pods
+        .iter()
+        .map(|p| format!("{}/{}", p.metadata.namespace, p.metadata.name))
+        .collect();
+
+    let selected_pod = query.pod.unwrap_or_default();
+    let selector = query.selector.unwrap_or_default().trim().to_string();
+    let namespace = query.namespace.unwrap_or_default();
+
+    // Selector ("tail app") mode takes precedence over a single pod
+    let log_url = if !selector.is_empty() {
+        let mut url = format!("/api/v1/logs?labelSelector={}", url_encode(&selector));
+        if !namespace.is_empty() {
+            url.push_str(&format!("&namespace={}", url_encode(&namespace)));
+        }
+        url
+    } else if let Some((ns, name)) = selected_pod.split_once('/') {
+        format!("/api/v1/namespaces/{}/pods/{}/log", url_encode(ns), url_encode(name))
+    } else {
+        String::new()
+    };
+    let log_url = if query.previous && !log_url.is_empty() {
+        let sep = if log_url.contains('?') { '&' } else { '?' };
+        format!("{}{}previous=true", log_url, sep)
+    } else {
+        log_url
+    };
+
+    let tmpl = LogsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        pods,
+        namespaces: namespaces.into_iter().collect(),
+        selected_pod,
+        selector,
+        namespace,
+        previous: query.previous,
+        log_url,
+    };
+    render_template(&tmpl)
+}
+
+// --- Nodes ---
+
+#[derive(Template)]
+#[template(path = "nodes.html")]
+struct NodesTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    table_html: String,
+}
+
+#[derive(Template)]
+#[template(path = "nodes_table.html")]
+struct NodesTableTemplate {
+    nodes: Vec<NodeView>,
+}
+
+pub async fn handle_nodes(State(state): State<AppState>, nav: PageNav) -> Response {
+    let table_html = state
+        .fragments
+        .get_or_render("nodes/table", || async {
+            let all_nodes = state.aggregator.list_all_nodes().await.unwrap_or_default();
+            let clients: HashMap<String, (&'static str, bool)> = state
+                .aggregator
+                .snapshot_clients()
+                .await
+                .iter()
+                .map(|c| (c.name.clone(), (c.transport(), c.is_healthy())))
+                .collect();
+            let nodes = all_nodes
+                .iter()
+                .map(|n| {
+                    let (transport, healthy) = clients.get(&n.metadata.name).copied().unwrap_or(("", true));
+                    let nv = NodeView {
+                        transport: transport.to_string(),
+                        ..build_node_view(n)
+                    };
+                    // Unreachable nodes are listed from their last-known state.
+                    if healthy { nv } else { unreachable(nv) }
+                })
+                .collect();
+            render_fragment(&NodesTableTemplate { nodes })
+        })
+        .await;
+    let Ok(table_html) = table_html else {
+        return (StatusCode::INTERNAL_SERVER_ERROR, "Internal Server Error").into_response();
+    };
+
+    let tmpl = NodesTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        table_html,
+    };
+
+    render_template(&tmpl)
+}
+
+// --- Node Detail ---
+
+#[derive(Template)]
+#[template(path = "node_detail.html")]
+struct NodeDetailTemplate {
+    title: String,
+    current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
     node: NodeView,
     pods: Vec<PodView>,
+    health_timeline: Vec<HealthSegmentView>,
+    flap_score: usize,
+    flapping: bool,
+    pinned: bool,
+    accelerators: Vec<AcceleratorView>,
+    online: bool,
+    can_wake: bool,
+    /// How long ago a wake was sent, while waiting for the node to rejoin.
+    wake_pending: Option<String>,
+    throughput: Option<ThroughputChartView>,
+    interfaces: Vec<InterfaceRateView>,
+    maintenance: bool,
+    capabilities: Vec<String>,
+    /// Room, rack and shelf, from the node's configured location.
+    location: String,
+    photo_url: String,
+    /// Last heartbeat, for nodes reached over a push-mode tunnel.
+    heartbeat: Option<String>,
+    /// Weighted scheduling score and where it came from.
+    score: String,
+    score_source: String,
 }
 
 pub async fn handle_node_detail(
     State(state): State<AppState>,
     Path(name): Path<String>,
+    headers: HeaderMap,
+    nav: PageNav,
 ) -> Response {
-    let k8s_node = match state.aggregator.get_node(&name).await {
-        Ok(n) => n,
+    let client = state
+        .aggregator
+        .snapshot_clients()
+        .await
+        .into_iter()
+        .find(|c| c.name == name);
+    let online = client.as_ref().map(|c| c.is_healthy()).unwrap_or(false);
+    let maintenance = client.as_ref().map(|c| c.in_maintenance()).unwrap_or(false);
+    let capabilities = client.as_ref().map(|c| c.capabilities.clone()).unwrap_or_default();
+    let location = client.as_ref().and_then(|c| c.location.as_ref());
+    let location_text = location.map(location_label).unwrap_or_default();
+    let photo_url = location.and_then(|l| l.photo_url.clone()).unwrap_or_default();
+    let heartbeat = client
+        .as_ref()
+        .filter(|c| c.transport() == "tunnel")
+        .map(|c| human_time(c.last_ping()));
+
+    // A configured node that is down can't describe itself; show what we
+    // know so it can still be woken.
+    let (k8s_node, nv) = match state.aggregator.get_node(&name).await {
+        // Unreachable: what it last reported, from the aggregator's cache.
+        Ok(n) if client.is_some() && !online => {
+            let nv = unreachable(build_node_view(&n));
+            (n, nv)
+        }
+        Ok(n) => {
+            let nv = build_node_view(&n);
+            (n, nv)
+        }
+        Err(_) if client.is_some() => (
+            k8s::Node::default(),
+            unreachable(NodeView {
+                name: name.clone(),
+                ..Default::default()
+            }),
+        ),
         Err(_) => return (StatusCode::NOT_FOUND, "Node not found").into_response(),
     };
+    state.recent.touch(&request_user(&headers), "node", "", &name);
+    let (score, score_source) = match client {
+        Some(ref c) => (
+            format!("{:.1}", aggregator::node_score(c, Some(&k8s_node))),
+            if c.weight.is_some() { "configured weight" } else { "from CPU, clock and load" }.to_string(),
+        ),
+        None => (String::new(), String::new()),
+    };
 
-    let nv = build_node_view(&k8s_node);
+    if online {
+        state.wake.clear(&name);
+    }
+    let wake_pending = state.wake.pending_since(&name).map(|t| human_time(Some(t)));
 
     let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
-    let pod_views: Vec<PodView> = all_pods
-        .iter()
+    let node_pods: Vec<k8s::Pod> = all_pods
+        .into_iter()
         .filter(|p| {
             p.metadata
                 .annotations
@@ -646,796 +1544,4190 @@ pub async fn handle_node_detail(
                 .map(|n| n == &name)
                 .unwrap_or(false)
         })
-        .map(build_pod_view)
+        .collect();
+    let pod_views: Vec<PodView> = node_pods.iter().map(build_pod_view).collect();
+    let accelerators = build_accelerator_views(
+        &resources::node_extended(&k8s_node),
+        &node_pods,
+        true,
+    );
+
+    let window = chrono::Duration::minutes(state.config.node_health.flap_window_minutes);
+    let (health_timeline, flap_score) = match client {
+        Some(c) => (build_health_timeline(&c.health_history()), c.flap_score(window)),
+        None => (Vec::new(), 0),
+    };
+    let flapping = flap_score >= state.config.node_health.flap_threshold;
+
+    let throughput = build_throughput_chart(&state.metrics.bandwidth_history(&name));
+    let interfaces = state
+        .metrics
+        .interface_rates(&name)
+        .iter()
+        .map(|i| build_interface_rate_view(i, &state.config.bandwidth))
         .collect();
 
     let tmpl = NodeDetailTemplate {
-        title: format!("Node: {}", name),
-        current_nav: "nodes".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Nodes".to_string(),
-                url: "/ui/nodes".to_string(),
-            },
-            Breadcrumb {
-                label: name.clone(),
-                url: String::new(),
-            },
-        ],
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
         node: nv,
         pods: pod_views,
+        health_timeline,
+        flap_score,
+        flapping,
+        pinned: state.favorites.is_pinned(
+            &request_user(&headers),
+            &Favorite { kind: "node".to_string(), namespace: String::new(), name: name.clone() },
+        ),
+        accelerators,
+        online,
+        can_wake: state.wake.can_wake(&name),
+        wake_pending,
+        throughput,
+        interfaces,
+        maintenance,
+        capabilities,
+        location: location_text,
+        photo_url,
+        heartbeat,
+        score,
+        score_source,
     };
 
     render_template(&tmpl)
 }
 
-// --- Registry ---
+// --- Claim a Node ---
 
-#[derive(Debug, Clone)]
-pub struct RepoView {
-    pub name: String,
-    pub tags: Vec<String>,
+#[derive(Template)]
+#[template(path = "claim.html")]
+struct ClaimTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    address: String,
+    code: String,
+    error: String,
+    claimed: Vec<ClaimedNodeView>,
+}
+
+fn claim_page(nav: PageNav, state: &AppState, req: &ClaimRequest, error: String) -> ClaimTemplate {
+    let mut claimed: Vec<ClaimedNodeView> = state
+        .claims
+        .list()
+        .iter()
+        .map(|c| ClaimedNodeView {
+            name: c.name.clone(),
+            address: c.address.clone(),
+            claimed_by: c.claimed_by.clone(),
+            claimed: human_time(Some(c.claimed_at)),
+        })
+        .collect();
+    claimed.reverse();
+    ClaimTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        address: req.address.clone(),
+        code: req.code.clone(),
+        error,
+        claimed,
+    }
+}
+
+/// Claim form. ?address= and ?code= prefill it, so a link can carry them.
+pub async fn handle_claim_page(
+    State(state): State<AppState>,
+    Query(req): Query<ClaimRequest>,
+    nav: PageNav,
+) -> Response {
+    render_template(&claim_page(nav, &state, &req, String::new()))
+}
+
+pub async fn handle_claim_submit(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    nav: PageNav,
+    Form(req): Form<ClaimRequest>,
+) -> Response {
+    match claim_node(&state, &headers, &req).await {
+        Ok(node) => Redirect::to(&format!("/ui/nodes/{}", url_encode(&node.name))).into_response(),
+        Err(e) => {
+            let mut resp = render_template(&claim_page(nav, &state, &req, e));
+            *resp.status_mut() = StatusCode::BAD_REQUEST;
+            resp
+        }
+    }
 }
 
+// --- Cluster Map ---
+
 #[derive(Template)]
-#[template(path = "registry.html")]
-struct RegistryTemplate {
+#[template(path = "map.html")]
+struct MapTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    available: bool,
-    repos: Vec<RepoView>,
+    floor_plan_url: String,
+    /// Nodes with a floor plan position.
+    markers: Vec<MapNodeView>,
+    rooms: Vec<MapRoomView>,
+    /// Nodes without a configured location.
+    unplaced: Vec<MapNodeView>,
 }
 
-pub async fn handle_registry(State(state): State<AppState>) -> Response {
-    let registry_url = state.config.registry_url();
-    let available = !registry_url.is_empty();
-    let mut repos = Vec::new();
-
-    if available {
-        if let Some(catalog) = fetch_catalog(&registry_url).await {
-            for repo_name in catalog {
-                let tags = fetch_tags(&registry_url, &repo_name).await;
-                repos.push(RepoView {
-                    name: repo_name,
-                    tags,
-                });
-            }
+/// Physical layout of the cluster from each node's configured location:
+/// markers on the floor plan and a room / rack / shelf listing, coloured
+/// by health so the right box can be found and power-cycled.
+pub async fn handle_map(State(state): State<AppState>, nav: PageNav) -> Response {
+    let mut markers = Vec::new();
+    let mut unplaced = Vec::new();
+    let mut rooms: BTreeMap<String, BTreeMap<String, Vec<MapNodeView>>> = BTreeMap::new();
+
+    let mut clients = state.aggregator.snapshot_clients().await;
+    clients.sort_by(|a, b| a.name.cmp(&b.name));
+    for c in &clients {
+        let (status, health_class) = if !c.is_healthy() {
+            ("Down", "map-down")
+        } else if c.in_maintenance() {
+            ("Maintenance", "map-maint")
+        } else if state.alerts.is_firing(&format!("node-flapping/{}", c.name)) {
+            ("Flapping", "map-warn")
+        } else {
+            ("Ready", "map-ok")
+        };
+        let mut v = MapNodeView {
+            name: c.name.clone(),
+            status: status.to_string(),
+            health_class: health_class.to_string(),
+            ..Default::default()
+        };
+        let Some(ref loc) = c.location else {
+            unplaced.push(v);
+            continue;
+        };
+        v.shelf = loc.shelf.clone();
+        v.photo_url = loc.photo_url.clone().unwrap_or_default();
+        if let (Some(x), Some(y)) = (loc.x, loc.y) {
+            v.x = format!("{:.2}", x);
+            v.y = format!("{:.2}", y);
+            markers.push(v.clone());
         }
+        rooms
+            .entry(loc.room.clone())
+            .or_default()
+            .entry(loc.rack.clone())
+            .or_default()
+            .push(v);
     }
 
-    let tmpl = RegistryTemplate {
-        title: "Registry".to_string(),
-        current_nav: "registry".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb {
-                label: "Dashboard".to_string(),
-                url: "/ui/".to_string(),
-            },
-            Breadcrumb {
-                label: "Registry".to_string(),
-                url: "/ui/registry".to_string(),
-            },
-        ],
-        available,
-        repos,
+    let rooms = rooms
+        .into_iter()
+        .map(|(room, racks)| MapRoomView {
+            room,
+            racks: racks
+                .into_iter()
+                .map(|(rack, mut nodes)| {
+                    nodes.sort_by(|a, b| a.shelf.cmp(&b.shelf).then_with(|| a.name.cmp(&b.name)));
+                    MapRackView { rack, nodes }
+                })
+                .collect(),
+        })
+        .collect();
+
+    let tmpl = MapTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        floor_plan_url: state.config.cluster_map.floor_plan_url.clone().unwrap_or_default(),
+        markers,
+        rooms,
+        unplaced,
     };
+    render_template(&tmpl)
+}
+
+// "Lab · rack 2 · U12" from whichever parts are set.
+fn location_label(loc: &NodeLocation) -> String {
+    let rack = (!loc.rack.is_empty()).then(|| format!("rack {}", loc.rack));
+    [Some(loc.room.clone()), rack, Some(loc.shelf.clone())]
+        .into_iter()
+        .flatten()
+        .filter(|s| !s.is_empty())
+        .collect::<Vec<_>>()
+        .join(" · ")
+}
+
+pub async fn handle_wake_node(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    match state.wake.wake(&name).await {
+        Ok(sent) => {
+            state.activity.record("wake", "node", "", &name, &request_user(&headers), &sent);
+            Redirect::to(&format!("/ui/nodes/{}", name)).into_response()
+        }
+        Err(e) => (StatusCode::BAD_GATEWAY, e.to_string()).into_response(),
+    }
+}
+
+#[derive(Template)]
+#[template(path = "node_restarts_panel.html")]
+struct NodeRestartsPanelTemplate {
+    node: String,
+    run: Option<RestartRunView>,
+    running: bool,
+    error: String,
+}
+
+pub async fn handle_node_restarts(State(state): State<AppState>, Path(name): Path<String>) -> Response {
+    render_restarts_panel(&name, state.restarts.get(&name), String::new())
+}
+
+pub async fn handle_restart_node_pods(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+    Form(opts): Form<RestartOptions>,
+) -> Response {
+    match state.restarts.start(&name, opts, &request_user(&headers)).await {
+        Ok(run) => render_restarts_panel(&name, Some(run), String::new()),
+        Err(e) => render_restarts_panel(&name, state.restarts.get(&name), e),
+    }
+}
 
+fn render_restarts_panel(node: &str, run: Option<RestartRun>, error: String) -> Response {
+    let tmpl = NodeRestartsPanelTemplate {
+        node: node.to_string(),
+        running: run.as_ref().is_some_and(|r| r.running()),
+        run: run.as_ref().map(build_restart_run_view),
+        error,
+    };
     render_template(&tmpl)
 }
 
-async fn fetch_catalog(registry_url: &str) -> Option<Vec<String>> {
-    #[derive(Deserialize)]
-    struct Catalog {
-        repositories: Vec<String>,
+fn build_restart_run_view(run: &RestartRun) -> RestartRunView {
+    RestartRunView {
+        mode: run.mode.as_str().to_string(),
+        started_by: run.started_by.clone(),
+        started: human_time(Some(run.started_at)),
+        finished: run.finished_at.map(|t| human_time(Some(t))).unwrap_or_default(),
+        done: run.count("done"),
+        failed: run.count("failed"),
+        total: run.pods.len(),
+        pods: run
+            .pods
+            .iter()
+            .map(|p| PodRestartView {
+                namespace: p.namespace.clone(),
+                name: p.name.clone(),
+                state: p.state.to_string(),
+                state_class: match p.state {
+                    "done" => "badge-success",
+                    "failed" => "badge-error",
+                    "running" => "badge-info",
+                    _ => "badge-warning",
+                }
+                .to_string(),
+                message: p.message.clone(),
+            })
+            .collect(),
     }
-    let resp: Catalog = reqwest::get(format!("{}/v2/_catalog", registry_url))
-        .await
-        .ok()?
-        .json()
-        .await
-        .ok()?;
-    Some(resp.repositories)
 }
 
-async fn fetch_tags(registry_url: &str, repo: &str) -> Vec<String> {
-    #[derive(Deserialize)]
-    struct TagList {
-        tags: Option<Vec<String>>,
+// Scale RX/TX history into the chart's 100x40 box. Needs two samples to
+// draw a line.
+fn build_throughput_chart(history: &[BandwidthSample]) -> Option<ThroughputChartView> {
+    let last = history.last()?;
+    if history.len() < 2 {
+        return None;
     }
-    let resp = match reqwest::get(format!("{}/v2/{}/tags/list", registry_url, repo)).await {
-        Ok(r) => r,
-        Err(_) => return Vec::new(),
+    let peak = history
+        .iter()
+        .map(|s| s.rx_rate.max(s.tx_rate))
+        .fold(1.0, f64::max);
+    let step = 100.0 / (history.len() - 1) as f64;
+    let points = |rate: fn(&BandwidthSample) -> f64| {
+        history
+            .iter()
+            .enumerate()
+            .map(|(i, s)| format!("{:.2},{:.2}", i as f64 * step, 40.0 - rate(s) * 38.0 / peak))
+            .collect::<Vec<_>>()
+            .join(" ")
     };
-    match resp.json::<TagList>().await {
-        Ok(t) => t.tags.unwrap_or_default(),
-        Err(_) => Vec::new(),
+    Some(ThroughputChartView {
+        rx_points: points(|s| s.rx_rate),
+        tx_points: points(|s| s.tx_rate),
+        rx_now: human_rate(last.rx_rate),
+        tx_now: human_rate(last.tx_rate),
+        peak: human_rate(peak),
+    })
+}
+
+fn build_interface_rate_view(
+    i: &InterfaceRate,
+    cfg: &crate::config::BandwidthConfig,
+) -> InterfaceRateView {
+    let util = i.utilisation(cfg.link_mbps);
+    InterfaceRateView {
+        name: i.name.clone(),
+        rx: human_rate(i.rx_rate),
+        tx: human_rate(i.tx_rate),
+        speed: i
+            .speed_mbps
+            .or(cfg.link_mbps)
+            .map(|s| format!("{} Mbps", s))
+            .unwrap_or_default(),
+        utilisation: util.map(|u| format!("{:.0}%", u)).unwrap_or_default(),
+        saturated: util.map(|u| u >= cfg.saturation_percent).unwrap_or(false),
     }
 }
 
-// --- Deployments ---
+// Capacity vs. allocation per extended resource. Resources pods request but
+// no node advertises still show up, with zero capacity.
+fn build_accelerator_views(
+    capacity: &BTreeMap<String, i64>,
+    pods: &[k8s::Pod],
+    with_holders: bool,
+) -> Vec<AcceleratorView> {
+    let allocated = resources::allocated(pods);
+    let mut names: BTreeSet<&String> = capacity.keys().collect();
+    names.extend(allocated.keys());
+
+    names
+        .into_iter()
+        .map(|res| {
+            let cap = capacity.get(res).copied().unwrap_or(0);
+            let used = allocated.get(res).copied().unwrap_or(0);
+            let holders = if with_holders {
+                pods.iter()
+                    .filter(|p| p.status.phase != "Succeeded" && p.status.phase != "Failed")
+                    .filter(|p| resources::pod_extended(p).contains_key(res))
+                    .map(|p| format!("{}/{}", p.metadata.namespace, p.metadata.name))
+                    .collect()
+            } else {
+                Vec::new()
+            };
+            AcceleratorView {
+                resource: res.clone(),
+                capacity: cap,
+                allocated: used,
+                free: (cap - used).max(0),
+                percent: if cap > 0 { (used * 100 / cap).min(100) } else { 0 },
+                holders,
+            }
+        })
+        .collect()
+}
+
+// --- Connectivity ---
 
 #[derive(Template)]
-#[template(path = "deployments.html")]
-struct DeploymentsTemplate {
+#[template(path = "connectivity.html")]
+struct ConnectivityTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    deployments: Vec<DeploymentView>,
+    nodes: Vec<String>,
+    rows: Vec<ConnectivityRowView>,
 }
 
-pub async fn handle_deployments(State(state): State<AppState>) -> Response {
-    let items = state.aggregator.list_deployments().await.unwrap_or_default();
-    let deployments: Vec<DeploymentView> = items.iter().map(build_deployment_view).collect();
+pub async fn handle_connectivity(State(state): State<AppState>, nav: PageNav) -> Response {
+    let matrix = state.aggregator.connectivity_matrix().await;
 
-    let tmpl = DeploymentsTemplate {
-        title: "Deployments".to_string(),
-        current_nav: "deployments".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Deployments".to_string(), url: "/ui/deployments".to_string() },
-        ],
-        deployments,
+    let rows = matrix
+        .rows
+        .iter()
+        .map(|r| ConnectivityRowView {
+            source: r.source.clone(),
+            cells: r.results.iter().map(build_connectivity_cell).collect(),
+        })
+        .collect();
+
+    let tmpl = ConnectivityTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        nodes: matrix.nodes,
+        rows,
     };
     render_template(&tmpl)
 }
 
+fn build_connectivity_cell(r: &Option<crate::clients::ProbeResult>) -> ConnectivityCellView {
+    let r = match r {
+        Some(r) => r,
+        None => {
+            return ConnectivityCellView {
+                label: "n/a".to_string(),
+                detail: "not probed".to_string(),
+                class: "none".to_string(),
+            };
+        }
+    };
+    let loss = r.loss_percent();
+    let class = if loss == 100 {
+        "down"
+    } else if loss > 0 || r.avg_ms >= 50.0 {
+        "poor"
+    } else if r.avg_ms >= 5.0 {
+        "fair"
+    } else {
+        "good"
+    };
+    ConnectivityCellView {
+        label: if loss == 100 {
+            "unreachable".to_string()
+        } else {
+            format!("{:.1} ms", r.avg_ms)
+        },
+        detail: format!("{}/{} replies, {}% loss", r.received, r.sent, loss),
+        class: class.to_string(),
+    }
+}
+
+// --- Port Check ---
+
+#[derive(Deserialize)]
+pub struct PortCheckForm {
+    #[serde(default)]
+    pub host: String,
+    #[serde(default)]
+    pub pod: String,
+    #[serde(default)]
+    pub node: String,
+    #[serde(default)]
+    pub port: String,
+    #[serde(default)]
+    pub mode: String,
+    #[serde(default)]
+    pub path: String,
+}
+
 #[derive(Template)]
-#[template(path = "deployment_detail.html")]
-struct DeploymentDetailTemplate {
+#[template(path = "port_check.html")]
+struct PortCheckTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    deploy: DeploymentView,
-    pods: Vec<PodView>,
+    host: String,
+    pod: String,
+    node: String,
+    port: String,
+    mode: String,
+    path: String,
+    nodes: Vec<String>,
+    results: Vec<PortCheckView>,
+    error: String,
+    known: Vec<KnownPortsView>,
 }
 
-pub async fn handle_deployment_detail(
+pub async fn handle_port_check(
     State(state): State<AppState>,
-    Path((namespace, name)): Path<(String, String)>,
+    headers: HeaderMap,
+    Query(form): Query<PortCheckForm>,
+    nav: PageNav,
 ) -> Response {
-    let dep = match state.aggregator.get_deployment(&namespace, &name).await {
-        Ok(d) => d,
-        Err(_) => return (StatusCode::NOT_FOUND, "Deployment not found").into_response(),
-    };
-
-    let dv = build_deployment_view(&dep);
+    let mode = if form.mode == "http" { "http" } else { "tcp" };
+    let mut results = Vec::new();
+    let mut error = String::new();
+
+    let requested = !form.host.is_empty() || !form.pod.is_empty() || !form.node.is_empty();
+    if requested {
+        let port = match form.port.trim() {
+            "" => Ok(None),
+            p => p.parse::<u16>().map(Some).map_err(|_| format!("invalid port {:?}", p)),
+        };
+        let targets = match port {
+            Ok(port) => {
+                diagnostics::resolve_targets(&state.aggregator, form.host.trim(), &form.pod, &form.node, port)
+                    .await
+            }
+            Err(e) => Err(e),
+        };
+        match targets {
+            Ok(targets) => {
+                let mut checks = Vec::new();
+                for t in &targets {
+                    checks.push(diagnostics::check_port(t, mode, &form.path).await);
+                }
+                state.diagnostics.record_port_checks(&request_user(&headers), &checks);
+                results = checks.iter().map(build_port_check_view).collect();
+            }
+            Err(e) => error = e,
+        }
+    }
 
-    // Find pods owned by this deployment
-    let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
-    let pods: Vec<PodView> = all_pods
+    let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+    let known = pods
         .iter()
-        .filter(|p| {
-            p.metadata.namespace == namespace
-                && p.metadata
+        .filter(|p| !p.status.pod_ip.is_empty())
+        .filter_map(|p| {
+            let ports: Vec<String> = p
+                .spec
+                .containers
+                .iter()
+                .flat_map(|c| c.ports.iter())
+                .map(|cp| match cp.name.as_str() {
+                    "" => cp.container_port.to_string(),
+                    name => format!("{} ({})", cp.container_port, name),
+                })
+                .collect();
+            if ports.is_empty() {
+                return None;
+            }
+            Some(KnownPortsView {
+                pod: format!("{}/{}", p.metadata.namespace, p.metadata.name),
+                node: p
+                    .metadata
                     .annotations
                     .as_ref()
-                    .and_then(|a| a.get("vkube.io/owner-deployment"))
-                    .map(|v| v == &name)
-                    .unwrap_or(false)
+                    .and_then(|a| a.get("mkube.io/node"))
+                    .cloned()
+                    .unwrap_or_default(),
+                ip: p.status.pod_ip.clone(),
+                ports: ports.join(", "),
+            })
         })
-        .map(build_pod_view)
         .collect();
-
-    let tmpl = DeploymentDetailTemplate {
-        title: format!("Deployment: {}", name),
-        current_nav: "deployments".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Deployments".to_string(), url: "/ui/deployments".to_string() },
-            Breadcrumb { label: name.clone(), url: String::new() },
-        ],
-        deploy: dv,
-        pods,
+    let mut nodes: Vec<String> = state
+        .aggregator
+        .snapshot_clients()
+        .await
+        .iter()
+        .map(|c| c.name.clone())
+        .collect();
+    nodes.sort();
+
+    let tmpl = PortCheckTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        host: form.host,
+        pod: form.pod,
+        node: form.node,
+        port: form.port,
+        mode: mode.to_string(),
+        path: form.path,
+        nodes,
+        results,
+        error,
+        known,
     };
     render_template(&tmpl)
 }
 
-fn build_deployment_view(d: &k8s::Deployment) -> DeploymentView {
-    let status = if d.status.ready_replicas >= d.spec.replicas && d.spec.replicas > 0 {
-        "Ready".to_string()
-    } else if d.status.ready_replicas > 0 {
-        "Degraded".to_string()
-    } else {
-        "Pending".to_string()
+fn build_port_check_view(r: &diagnostics::PortCheckResult) -> PortCheckView {
+    let (result, class) = match (r.reachable, r.status) {
+        (false, _) => ("unreachable".to_string(), "badge-error"),
+        (true, Some(code)) if code >= 500 => (format!("HTTP {}", code), "badge-error"),
+        (true, Some(code)) if code >= 400 => (format!("HTTP {}", code), "badge-warning"),
+        (true, Some(code)) => (format!("HTTP {}", code), "badge-success"),
+        (true, None) => ("open".to_string(), "badge-success"),
     };
-    let status_class = match status.as_str() {
-        "Ready" => "badge-success",
-        "Degraded" => "badge-warning",
-        _ => "badge-info",
-    }
-    .to_string();
-
-    DeploymentView {
-        name: d.metadata.name.clone(),
-        namespace: d.metadata.namespace.clone(),
-        replicas: d.spec.replicas,
-        ready_replicas: d.status.ready_replicas,
-        status,
-        status_class,
-        age: parse_age(&d.metadata.creation_timestamp),
+    PortCheckView {
+        label: r.label.clone(),
+        target: r.target.clone(),
+        mode: r.mode.clone(),
+        result,
+        class: class.to_string(),
+        latency: r.latency_ms.map(|ms| format!("{:.1} ms", ms)).unwrap_or_default(),
+        error: r.error.clone(),
     }
 }
 
-// --- Networks ---
-
-#[derive(Template)]
-#[template(path = "networks.html")]
-struct NetworksTemplate {
-    title: String,
-    current_nav: String,
-    breadcrumbs: Vec<Breadcrumb>,
-    networks: Vec<NetworkView>,
-}
-
-pub async fn handle_networks(State(state): State<AppState>) -> Response {
-    let items = state.aggregator.list_networks().await.unwrap_or_default();
-    let networks: Vec<NetworkView> = items.iter().map(build_network_view).collect();
+// --- Diagnostics ---
 
-    let tmpl = NetworksTemplate {
-        title: "Networks".to_string(),
-        current_nav: "networks".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Networks".to_string(), url: "/ui/networks".to_string() },
-        ],
-        networks,
-    };
-    render_template(&tmpl)
+#[derive(Deserialize)]
+pub struct DiagnosticsQuery {
+    #[serde(default)]
+    pub tool: String,
+    #[serde(default)]
+    pub name: String,
+    #[serde(default, rename = "type")]
+    pub qtype: String,
+    #[serde(default)]
+    pub host: String,
 }
 
 #[derive(Template)]
-#[template(path = "network_detail.html")]
-struct NetworkDetailTemplate {
+#[template(path = "diagnostics.html")]
+struct DiagnosticsTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    net: NetworkView,
-    reservations: Vec<DHCPReservationView>,
-    static_records: Vec<StaticRecordView>,
+    tool: String,
+    name: String,
+    qtype: String,
+    host: String,
+    query_types: Vec<String>,
+    dns_results: Vec<DnsResultView>,
+    output: String,
+    error: String,
+    runs: Vec<DiagnosticRunView>,
 }
 
-pub async fn handle_network_detail(
+pub async fn handle_diagnostics(
     State(state): State<AppState>,
-    Path(name): Path<String>,
+    headers: HeaderMap,
+    Query(q): Query<DiagnosticsQuery>,
+    nav: PageNav,
 ) -> Response {
-    let net = match state.aggregator.get_network(&name).await {
-        Ok(n) => n,
-        Err(_) => return (StatusCode::NOT_FOUND, "Network not found").into_response(),
+    let user = request_user(&headers);
+    let tool = match q.tool.as_str() {
+        "ping" | "traceroute" => q.tool.clone(),
+        _ => "dns".to_string(),
     };
+    let qtype = if q.qtype.is_empty() {
+        "A".to_string()
+    } else {
+        q.qtype.to_uppercase()
+    };
+    let mut dns_results = Vec::new();
+    let mut output = String::new();
+    let mut error = String::new();
+
+    match tool.as_str() {
+        "dns" if !q.name.trim().is_empty() => {
+            if dns::QUERY_TYPES.contains(&qtype.as_str()) {
+                let name = q.name.trim();
+                let results = diagnostics::dns_lookup(&state.aggregator, name, &qtype).await;
+                state.diagnostics.record_dns(&user, name, &qtype, &results);
+                dns_results = results.iter().map(build_dns_result_view).collect();
+            } else {
+                error = format!("unsupported query type {}", qtype);
+            }
+        }
+        "ping" | "traceroute" if !q.host.trim().is_empty() => {
+            let host = q.host.trim();
+            if diagnostics::valid_host(host) {
+                let (ok, out) = if tool == "ping" {
+                    diagnostics::ping(host).await
+                } else {
+                    diagnostics::traceroute(host).await
+                };
+                let summary = if ok { "completed" } else { "failed" };
+                state.diagnostics.record(&tool, host, &user, ok, summary, out.clone());
+                output = out;
+            } else {
+                error = format!("invalid host {:?}", host);
+            }
+        }
+        _ => {}
+    }
 
-    let nv = build_network_view(&net);
-
-    let reservations: Vec<DHCPReservationView> = net
-        .spec
-        .dhcp
-        .reservations
-        .iter()
-        .map(|r| DHCPReservationView {
-            mac: r.mac.clone(),
-            ip: r.ip.clone(),
-            hostname: r.hostname.clone(),
-        })
-        .collect();
-
-    let static_records: Vec<StaticRecordView> = net
-        .spec
-        .static_records
+    let runs = state
+        .diagnostics
+        .list()
         .iter()
-        .map(|r| StaticRecordView {
-            name: r.name.clone(),
-            ip: r.ip.clone(),
+        .map(|r| DiagnosticRunView {
+            id: r.id,
+            at: human_time(Some(r.at)),
+            kind: r.kind.clone(),
+            target: r.target.clone(),
+            user: r.user.clone(),
+            summary: r.summary.clone(),
+            class: if r.ok { "badge-success" } else { "badge-error" }.to_string(),
+            output: r.output.clone(),
         })
         .collect();
 
-    let tmpl = NetworkDetailTemplate {
-        title: format!("Network: {}", name),
-        current_nav: "networks".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Networks".to_string(), url: "/ui/networks".to_string() },
-            Breadcrumb { label: name.clone(), url: String::new() },
-        ],
-        net: nv,
-        reservations,
-        static_records,
+    let tmpl = DiagnosticsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        tool,
+        name: q.name,
+        qtype,
+        host: q.host,
+        query_types: dns::QUERY_TYPES.iter().map(|t| t.to_string()).collect(),
+        dns_results,
+        output,
+        error,
+        runs,
     };
     render_template(&tmpl)
 }
 
-fn build_network_view(n: &k8s::Network) -> NetworkView {
-    let status = if n.status.dns_alive {
-        "Active".to_string()
-    } else if n.spec.external_dns {
-        "External".to_string()
+fn build_dns_result_view(r: &dns::DnsResult) -> DnsResultView {
+    let class = if !r.error.is_empty() {
+        "badge-error"
+    } else if r.answers.is_empty() {
+        "badge-warning"
     } else {
-        "Unknown".to_string()
+        "badge-success"
     };
-    let status_class = match status.as_str() {
-        "Active" => "badge-success",
-        "External" => "badge-info",
-        _ => "badge-warning",
-    }
-    .to_string();
-
-    NetworkView {
-        name: n.metadata.name.clone(),
-        type_field: n.spec.type_field.clone(),
-        cidr: n.spec.cidr.clone(),
-        gateway: n.spec.gateway.clone(),
-        dns_zone: n.spec.dns.zone.clone(),
-        dns_server: n.spec.dns.server.clone(),
-        dhcp_enabled: n.spec.dhcp.enabled,
-        managed: n.spec.managed,
-        dns_alive: n.status.dns_alive,
-        pod_count: n.status.pod_count,
-        status,
-        status_class,
-        age: parse_age(&n.metadata.creation_timestamp),
+    DnsResultView {
+        server: r.server.clone(),
+        rcode: if r.rcode.is_empty() { "no response".to_string() } else { r.rcode.clone() },
+        answers: r
+            .answers
+            .iter()
+            .map(|a| format!("{} {} ({}s)", a.rtype, a.data, a.ttl))
+            .collect(),
+        latency: r.latency_ms.map(|ms| format!("{:.1} ms", ms)).unwrap_or_default(),
+        error: r.error.clone(),
+        class: class.to_string(),
     }
 }
 
-// --- PVCs ---
+// --- Encryption ---
 
 #[derive(Template)]
-#[template(path = "pvcs.html")]
-struct PVCsTemplate {
+#[template(path = "encryption.html")]
+struct EncryptionTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    pvcs: Vec<PVCView>,
+    enabled: bool,
+    current_key: String,
+    previous_keys: Vec<String>,
+    files: Vec<EncryptionFileView>,
 }
 
-pub async fn handle_pvcs(State(state): State<AppState>) -> Response {
-    let items = state.aggregator.list_pvcs().await.unwrap_or_default();
-    let pvcs: Vec<PVCView> = items.iter().map(build_pvc_view).collect();
+pub async fn handle_encryption(State(state): State<AppState>, nav: PageNav) -> Response {
+    let current_key = state.sealer.current_key_id().unwrap_or_default().to_string();
+    let files = crypto::store_files(&state.config)
+        .iter()
+        .map(|f| build_encryption_file_view(&crypto::file_status(f), &current_key))
+        .collect();
 
-    let tmpl = PVCsTemplate {
-        title: "PVCs".to_string(),
-        current_nav: "pvcs".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "PVCs".to_string(), url: "/ui/pvcs".to_string() },
-        ],
-        pvcs,
+    let tmpl = EncryptionTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        enabled: state.sealer.is_enabled(),
+        previous_keys: state.sealer.key_ids().into_iter().skip(1).collect(),
+        current_key,
+        files,
     };
     render_template(&tmpl)
 }
 
-fn build_pvc_view(p: &k8s::PersistentVolumeClaim) -> PVCView {
-    let status_class = match p.status.phase.as_str() {
-        "Bound" => "badge-success",
-        "Pending" => "badge-warning",
-        "Lost" => "badge-error",
-        _ => "badge-info",
-    }
-    .to_string();
-
-    let capacity = p
-        .status
-        .capacity
-        .get("storage")
-        .cloned()
-        .unwrap_or_default();
-
-    PVCView {
-        name: p.metadata.name.clone(),
-        namespace: p.metadata.namespace.clone(),
-        status: p.status.phase.clone(),
-        status_class,
-        capacity,
-        access_modes: p.spec.access_modes.join(", "),
-        age: parse_age(&p.metadata.creation_timestamp),
+fn build_encryption_file_view(s: &crypto::FileStatus, current_key: &str) -> EncryptionFileView {
+    let sealed: usize = s.by_key.values().sum();
+    let stale = s.by_key.keys().any(|k| k != current_key);
+    let (state, class) = if !s.exists {
+        ("missing", "badge-info")
+    } else if s.records == 0 {
+        ("empty", "badge-info")
+    } else if sealed == 0 {
+        ("plaintext", if current_key.is_empty() { "badge-info" } else { "badge-warning" })
+    } else if s.plaintext > 0 {
+        ("mixed", "badge-warning")
+    } else if stale {
+        ("old key", "badge-warning")
+    } else {
+        ("encrypted", "badge-success")
+    };
+    EncryptionFileView {
+        name: s.name.clone(),
+        path: s.path.clone(),
+        records: s.records,
+        state: state.to_string(),
+        class: class.to_string(),
+        keys: s
+            .by_key
+            .iter()
+            .map(|(k, n)| format!("{}: {}", k, n))
+            .collect::<Vec<_>>()
+            .join(", "),
     }
 }
 
-// --- BareMetalHosts ---
+// --- Telemetry ---
 
 #[derive(Template)]
-#[template(path = "bmhs.html")]
-struct BMHsTemplate {
+#[template(path = "telemetry.html")]
+struct TelemetryTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    bmhs: Vec<BMHView>,
+    enabled: bool,
+    endpoint: String,
+    interval_hours: u64,
+    last_sent: String,
+    last_outcome: String,
+    /// Pretty-printed JSON of the last report, empty before the first.
+    last_report: String,
+    next_report: String,
 }
 
-pub async fn handle_bmhs(State(state): State<AppState>) -> Response {
-    let items = state.aggregator.list_bmhs().await.unwrap_or_default();
-    let bmhs: Vec<BMHView> = items.iter().map(build_bmh_view).collect();
-
-    let tmpl = BMHsTemplate {
-        title: "Bare Metal Hosts".to_string(),
-        current_nav: "bmh".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Bare Metal Hosts".to_string(), url: "/ui/bmh".to_string() },
-        ],
-        bmhs,
+pub async fn handle_telemetry(State(state): State<AppState>, nav: PageNav) -> Response {
+    let mut tmpl = TelemetryTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        enabled: false,
+        endpoint: String::new(),
+        interval_hours: 0,
+        last_sent: String::new(),
+        last_outcome: String::new(),
+        last_report: String::new(),
+        next_report: String::new(),
     };
+    if let Some(ref t) = state.telemetry {
+        let last = t.last();
+        tmpl.enabled = true;
+        tmpl.endpoint = t.endpoint().to_string();
+        tmpl.interval_hours = t.interval_hours();
+        tmpl.last_sent = human_time(last.as_ref().map(|l| l.sent_at));
+        tmpl.last_outcome = last.as_ref().map(|l| l.outcome.clone()).unwrap_or_default();
+        tmpl.last_report = last
+            .and_then(|l| serde_json::to_string_pretty(&l.report).ok())
+            .unwrap_or_default();
+        tmpl.next_report = serde_json::to_string_pretty(&t.collect(&state).await).unwrap_or_default();
+    }
     render_template(&tmpl)
 }
 
+// --- Console updates ---
+
 #[derive(Template)]
-#[template(path = "bmh_detail.html")]
-struct BMHDetailTemplate {
+#[template(path = "update.html")]
+struct UpdateTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    bmh: BMHView,
+    enabled: bool,
+    current: String,
+    self_hosted: bool,
+    can_install: bool,
+    latest: String,
+    available: bool,
+    notes: String,
+    changelog_url: String,
+    checked: String,
+    last_from: String,
+    last_to: String,
+    last_state: String,
+    last_at: String,
 }
 
-pub async fn handle_bmh_detail(
-    State(state): State<AppState>,
-    Path((namespace, name)): Path<(String, String)>,
-) -> Response {
-    let bmh = match state.aggregator.get_bmh(&namespace, &name).await {
-        Ok(b) => b,
-        Err(_) => return (StatusCode::NOT_FOUND, "BMH not found").into_response(),
+pub async fn handle_update(State(state): State<AppState>, nav: PageNav) -> Response {
+    let check = state.updater.last_check();
+    let marker = state.updater.marker();
+    let tmpl = UpdateTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        enabled: state.updater.is_enabled(),
+        current: crate::update::VERSION.to_string(),
+        self_hosted: state.self_host.pod().is_some(),
+        can_install: state.updater.can_install(),
+        latest: check.as_ref().map(|c| c.latest.clone()).unwrap_or_default(),
+        available: check.as_ref().is_some_and(|c| c.available),
+        notes: check.as_ref().map(|c| c.notes.clone()).unwrap_or_default(),
+        changelog_url: check.as_ref().map(|c| c.changelog_url.clone()).unwrap_or_default(),
+        checked: human_time(check.map(|c| c.checked_at)),
+        last_from: marker.as_ref().map(|m| m.from.clone()).unwrap_or_default(),
+        last_to: marker.as_ref().map(|m| m.to.clone()).unwrap_or_default(),
+        last_state: marker.as_ref().map(|m| m.state.clone()).unwrap_or_default(),
+        last_at: human_time(marker.map(|m| m.at)),
     };
+    render_template(&tmpl)
+}
 
-    let bv = build_bmh_view(&bmh);
+// --- Read-only mode ---
 
-    let tmpl = BMHDetailTemplate {
-        title: format!("BMH: {}", name),
-        current_nav: "bmh".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Bare Metal Hosts".to_string(), url: "/ui/bmh".to_string() },
-            Breadcrumb { label: name.clone(), url: String::new() },
-        ],
-        bmh: bv,
+#[derive(Template)]
+#[template(path = "read_only.html")]
+struct ReadOnlyTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    enabled: bool,
+    by: String,
+    since: String,
+    done: String,
+}
+
+pub async fn handle_read_only(Query(q): Query<FormOutcomeQuery>, nav: PageNav) -> Response {
+    let status = crate::readonly::status();
+    let tmpl = ReadOnlyTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        enabled: status.enabled,
+        by: status.by,
+        since: human_time(status.since),
+        done: q.done,
     };
     render_template(&tmpl)
 }
 
-fn build_bmh_view(b: &k8s::BareMetalHost) -> BMHView {
-    let status_class = match b.status.phase.as_str() {
-        "Ready" => "badge-success",
-        "Provisioning" | "Registering" => "badge-warning",
-        "Error" => "badge-error",
-        _ => "badge-info",
-    }
-    .to_string();
+#[derive(Deserialize)]
+pub struct ReadOnlyForm {
+    enabled: bool,
+}
 
-    BMHView {
-        name: b.metadata.name.clone(),
-        namespace: b.metadata.namespace.clone(),
-        phase: b.status.phase.clone(),
-        status_class,
-        powered_on: b.status.powered_on,
-        network: b.spec.network.clone(),
-        image: b.spec.image.clone(),
-        ip: b.spec.ip.clone(),
-        mac: b.spec.boot_mac_address.clone(),
-        bmc_address: b.spec.bmc.address.clone(),
-        bmc_network: b.spec.bmc.network.clone(),
-        bmc_username: b.spec.bmc.username.clone(),
-        age: parse_age(&b.metadata.creation_timestamp),
-    }
+pub async fn handle_set_read_only(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<ReadOnlyForm>,
+) -> Response {
+    let user = request_user(&headers);
+    crate::readonly::set(form.enabled, &user);
+    let message = if form.enabled { "read-only mode on" } else { "read-only mode off" };
+    state
+        .activity
+        .record("update", "console", "", &state.leader.identity(), &user, message);
+    Redirect::to(&format!("/ui/read-only?done={}", url_encode(message))).into_response()
 }
 
-// --- iSCSI CDROMs ---
+// --- Background work ---
 
 #[derive(Template)]
-#[template(path = "iscsi_cdroms.html")]
-struct ISCSICdromsTemplate {
+#[template(path = "schedule.html")]
+struct ScheduleTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    cdroms: Vec<ISCSICdromView>,
+    health_interval_secs: u64,
+    spread_secs: u64,
+    jitter_percent: u64,
+    tasks: Vec<ScheduledTaskView>,
 }
 
-pub async fn handle_iscsi_cdroms(State(state): State<AppState>) -> Response {
-    let items = state.aggregator.list_iscsi_cdroms().await.unwrap_or_default();
-    let cdroms: Vec<ISCSICdromView> = items.iter().map(build_iscsi_cdrom_view).collect();
+pub async fn handle_schedule(State(state): State<AppState>, nav: PageNav) -> Response {
+    let config = state.schedule.config();
+    let tasks = state
+        .schedule
+        .tasks()
+        .into_iter()
+        .map(|t| ScheduledTaskView {
+            name: t.name.to_string(),
+            description: t.description.to_string(),
+            interval: human_duration_secs(t.interval_secs as i64),
+            staggered: t.staggered,
+            runs: t.runs,
+            last_run: human_time(t.last_started),
+            took: t.last_duration_ms.map(|ms| format!("{} ms", ms)).unwrap_or_default(),
+            next_run: match t.next_run {
+                Some(at) => format!("in {}", human_duration_secs((at - chrono::Utc::now()).num_seconds().max(0))),
+                None => String::new(),
+            },
+        })
+        .collect();
 
-    let tmpl = ISCSICdromsTemplate {
-        title: "iSCSI CDROMs".to_string(),
-        current_nav: "iscsi-cdroms".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "iSCSI CDROMs".to_string(), url: "/ui/iscsi-cdroms".to_string() },
-        ],
-        cdroms,
+    let tmpl = ScheduleTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        health_interval_secs: config.health_interval_secs,
+        spread_secs: config.spread_secs,
+        jitter_percent: config.jitter_percent,
+        tasks,
     };
     render_template(&tmpl)
 }
 
 #[derive(Template)]
-#[template(path = "iscsi_cdrom_detail.html")]
-struct ISCSICdromDetailTemplate {
+#[template(path = "login_attempts.html")]
+struct LoginAttemptsTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    cdrom: ISCSICdromView,
-    subscribers: Vec<SubscriberView>,
+    lockouts: Vec<LockoutView>,
+    attempts: Vec<FailedSignInView>,
 }
 
-pub async fn handle_iscsi_cdrom_detail(
-    State(state): State<AppState>,
-    Path(name): Path<String>,
-) -> Response {
-    let cdrom = match state.aggregator.get_iscsi_cdrom(&name).await {
-        Ok(c) => c,
-        Err(_) => return (StatusCode::NOT_FOUND, "iSCSI CDROM not found").into_response(),
-    };
-
-    let cv = build_iscsi_cdrom_view(&cdrom);
-
-    let subscribers: Vec<SubscriberView> = cdrom
-        .status
-        .subscribers
-        .iter()
-        .map(|s| SubscriberView {
-            name: s.name.clone(),
-            initiator_iqn: s.initiator_iqn.clone(),
-            since: parse_age(&Some(s.since.clone())),
+pub async fn handle_login_attempts(State(state): State<AppState>, nav: PageNav) -> Response {
+    let now = chrono::Utc::now();
+    let lockouts = state
+        .login_throttle
+        .lockouts()
+        .into_iter()
+        .map(|l| LockoutView {
+            key: l.key,
+            failures: l.failures,
+            remaining: human_duration_secs((l.until - now).num_seconds().max(1)),
+        })
+        .collect();
+    let attempts = state
+        .login_throttle
+        .recent()
+        .into_iter()
+        .map(|a| FailedSignInView {
+            when: human_time(Some(a.at)),
+            remote: a.remote,
+            identity: a.identity,
+            endpoint: a.endpoint,
+            reason: a.reason,
         })
         .collect();
 
-    let tmpl = ISCSICdromDetailTemplate {
-        title: format!("iSCSI CDROM: {}", name),
-        current_nav: "iscsi-cdroms".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "iSCSI CDROMs".to_string(), url: "/ui/iscsi-cdroms".to_string() },
-            Breadcrumb { label: name.clone(), url: String::new() },
-        ],
-        cdrom: cv,
-        subscribers,
+    let tmpl = LoginAttemptsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        lockouts,
+        attempts,
     };
     render_template(&tmpl)
 }
 
-fn build_iscsi_cdrom_view(c: &k8s::ISCSICdrom) -> ISCSICdromView {
-    let status_class = match c.status.phase.as_str() {
-        "Ready" => "badge-success",
-        "Uploading" | "Pending" => "badge-warning",
-        "Error" => "badge-error",
-        _ => "badge-info",
-    }
-    .to_string();
-
-    let iso_size_display = if c.status.iso_size == 0 {
-        "-".to_string()
-    } else {
-        human_bytes(c.status.iso_size)
-    };
-
-    let portal = if c.status.portal_ip.is_empty() {
-        String::new()
-    } else {
-        format!("{}:{}", c.status.portal_ip, c.status.portal_port)
-    };
+// --- Devices ---
 
-    ISCSICdromView {
-        name: c.metadata.name.clone(),
-        phase: c.status.phase.clone(),
-        status_class,
-        iso_file: c.spec.iso_file.clone(),
-        iso_size_display,
-        description: c.spec.description.clone(),
-        target_iqn: c.status.target_iqn.clone(),
-        portal,
-        subscriber_count: c.status.subscribers.len(),
-        age: parse_age(&c.metadata.creation_timestamp),
-    }
+#[derive(Deserialize)]
+pub struct DeviceQuery {
+    #[serde(default)]
+    pub node: String,
+    #[serde(default)]
+    pub kind: String,
 }
 
-// --- ConfigMaps ---
-
 #[derive(Template)]
-#[template(path = "configmaps.html")]
-struct ConfigMapsTemplate {
+#[template(path = "devices.html")]
+struct DevicesTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    configmaps: Vec<ConfigMapView>,
+    devices: Vec<DeviceView>,
+    device_sets: Vec<DeviceSetView>,
+    nodes: Vec<String>,
+    kinds: Vec<String>,
+    node_filter: String,
+    kind_filter: String,
 }
 
-pub async fn handle_configmaps(State(state): State<AppState>) -> Response {
-    // Collect configmaps from all namespaces we know about
-    let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
-    let mut namespaces = BTreeSet::new();
-    for pod in &all_pods {
-        namespaces.insert(pod.metadata.namespace.clone());
-    }
-
-    let mut configmaps = Vec::new();
-    for ns in &namespaces {
-        if let Ok(cms) = state.aggregator.list_configmaps(ns).await {
-            for cm in &cms {
-                configmaps.push(ConfigMapView {
-                    name: cm.metadata.name.clone(),
-                    namespace: cm.metadata.namespace.clone(),
-                    key_count: cm.data.len(),
-                    age: parse_age(&cm.metadata.creation_timestamp),
-                });
-            }
+pub async fn handle_devices(
+    State(state): State<AppState>,
+    Query(query): Query<DeviceQuery>,
+    nav: PageNav,
+) -> Response {
+    let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+    let grants = resources::device_grants(&pods);
+
+    let mut nodes = BTreeSet::new();
+    let mut kinds = BTreeSet::new();
+    let mut devices = Vec::new();
+
+    for (node, d) in state.aggregator.list_devices().await {
+        nodes.insert(node.clone());
+        kinds.insert(d.kind.clone());
+        if (!query.node.is_empty() && node != query.node)
+            || (!query.kind.is_empty() && d.kind != query.kind)
+        {
+            continue;
         }
+        devices.push(DeviceView {
+            granted_to: resources::device_granted_to(&grants, &node, &d),
+            node,
+            name: d.name,
+            kind: d.kind,
+            path: d.path,
+            vendor: d.vendor,
+            product: d.product,
+            serial: d.serial,
+        });
     }
 
-    let tmpl = ConfigMapsTemplate {
-        title: "ConfigMaps".to_string(),
-        current_nav: "configmaps".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "ConfigMaps".to_string(), url: "/ui/configmaps".to_string() },
-        ],
-        configmaps,
+    let device_sets = state
+        .device_sets
+        .list(None)
+        .into_iter()
+        .map(|s| DeviceSetView {
+            desired: s.status.desired,
+            current: s.status.current,
+            ready: s.status.ready,
+            errors: s
+                .status
+                .nodes
+                .iter()
+                .filter(|n| !n.error.is_empty())
+                .map(|n| format!("{}: {}", n.node, n.error))
+                .collect(),
+            synced_age: human_time(s.status.last_sync),
+            namespace: s.namespace,
+            name: s.name,
+            device_class: s.device_class,
+        })
+        .collect();
+
+    let tmpl = DevicesTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        devices,
+        device_sets,
+        nodes: nodes.into_iter().collect(),
+        kinds: kinds.into_iter().filter(|k| !k.is_empty()).collect(),
+        node_filter: query.node,
+        kind_filter: query.kind,
     };
     render_template(&tmpl)
 }
 
+// --- IPAM ---
+
+#[derive(Deserialize)]
+pub struct IpamQuery {
+    /// Only show addresses with problems.
+    #[serde(default)]
+    pub issues: bool,
+}
+
 #[derive(Template)]
-#[template(path = "configmap_detail.html")]
-struct ConfigMapDetailTemplate {
+#[template(path = "ipam.html")]
+struct IpamTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    cm_name: String,
-    cm_namespace: String,
-    keys: Vec<String>,
-    data: HashMap<String, String>,
+    entries: Vec<IpamEntryView>,
+    subnets: Vec<IpRangeView>,
+    reserved: Vec<IpRangeView>,
+    problem_count: usize,
+    issues_only: bool,
 }
 
-pub async fn handle_configmap_detail(
+pub async fn handle_ipam(
     State(state): State<AppState>,
-    Path((namespace, name)): Path<(String, String)>,
+    Query(query): Query<IpamQuery>,
+    nav: PageNav,
 ) -> Response {
-    let cm = match state.aggregator.get_configmap(&namespace, &name).await {
-        Ok(c) => c,
-        Err(_) => return (StatusCode::NOT_FOUND, "ConfigMap not found").into_response(),
-    };
+    let (entries, subnets) = ipam::collect(&state.aggregator, &state.config).await;
+    let problem_count = entries.iter().filter(|e| !e.issues.is_empty()).count();
 
-    let mut keys: Vec<String> = cm.data.keys().cloned().collect();
-    keys.sort();
+    let subnets = subnets
+        .iter()
+        .map(|s| IpRangeView {
+            name: s.name.clone(),
+            range: s.cidr.clone(),
+            used: entries.iter().filter(|e| e.network == s.name).count(),
+        })
+        .collect();
+    let reserved = state
+        .config
+        .ipam
+        .reserved
+        .iter()
+        .map(|r| IpRangeView {
+            name: r.name.clone(),
+            range: format!("{} - {}", r.start, r.end),
+            used: entries.iter().filter(|e| e.reserved == r.name).count(),
+        })
+        .collect();
 
-    let tmpl = ConfigMapDetailTemplate {
-        title: format!("ConfigMap: {}", name),
-        current_nav: "configmaps".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "ConfigMaps".to_string(), url: "/ui/configmaps".to_string() },
-            Breadcrumb { label: name.clone(), url: String::new() },
-        ],
-        cm_name: name,
-        cm_namespace: namespace,
-        keys,
-        data: cm.data,
+    let entries = entries
+        .into_iter()
+        .filter(|e| !query.issues || !e.issues.is_empty())
+        .map(|e| {
+            let (owner, url) = match e.kind.as_str() {
+                "node" => (e.name.clone(), format!("/ui/nodes/{}", e.name)),
+                _ => (
+                    format!("{}/{}", e.namespace, e.name),
+                    format!("/ui/pods/{}/{}", e.namespace, e.name),
+                ),
+            };
+            IpamEntryView {
+                ip: e.ip,
+                kind: e.kind,
+                owner,
+                url,
+                node: e.node,
+                network: e.network,
+                issues: e.issues,
+            }
+        })
+        .collect();
+
+    let tmpl = IpamTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        entries,
+        subnets,
+        reserved,
+        problem_count,
+        issues_only: query.issues,
     };
     render_template(&tmpl)
 }
 
-// --- Consistency ---
+pub async fn handle_ipam_csv(State(state): State<AppState>) -> Response {
+    let (entries, _) = ipam::collect(&state.aggregator, &state.config).await;
+    (
+        [
+            (header::CONTENT_TYPE, "text/csv; charset=utf-8"),
+            (header::CONTENT_DISPOSITION, "attachment; filename=\"ipam.csv\""),
+        ],
+        ipam::to_csv(&entries),
+    )
+        .into_response()
+}
+
+// --- Registry ---
+
+#[derive(Debug, Clone)]
+pub struct RepoView {
+    pub name: String,
+    pub tags: Vec<String>,
+}
 
 #[derive(Template)]
-#[template(path = "consistency.html")]
-struct ConsistencyTemplate {
+#[template(path = "registry.html")]
+struct RegistryTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    pass_count: usize,
-    fail_count: usize,
-    warn_count: usize,
-    timestamp: String,
-    categories: Vec<(String, Vec<CheckItemView>)>,
+    available: bool,
+    repos: Vec<RepoView>,
 }
 
-pub async fn handle_consistency(State(state): State<AppState>) -> Response {
-    let report = state.aggregator.get_consistency().await.unwrap_or_default();
-
-    let mut categories: Vec<(String, Vec<CheckItemView>)> = Vec::new();
+pub async fn handle_registry(State(state): State<AppState>, nav: PageNav) -> Response {
+    let registry_url = state.config.registry_url();
+    let available = !registry_url.is_empty();
+    let mut repos = Vec::new();
 
-    // Sort categories by name for stable ordering
-    let mut sorted_checks: BTreeMap<String, Vec<k8s::CheckItem>> = BTreeMap::new();
-    for (k, v) in &report.checks {
-        sorted_checks.insert(k.clone(), v.clone());
+    if available {
+        if let Some(catalog) = fetch_catalog(&registry_url).await {
+            for repo_name in catalog {
+                let tags = fetch_tags(&registry_url, &repo_name).await;
+                repos.push(RepoView {
+                    name: repo_name,
+                    tags,
+                });
+            }
+        }
     }
 
-    for (cat_name, checks) in &sorted_checks {
-        let items: Vec<CheckItemView> = checks
-            .iter()
-            .map(|c| {
-                let status_class = match c.status.as_str() {
-                    "pass" => "badge-success",
-                    "fail" => "badge-error",
-                    "warn" => "badge-warning",
-                    _ => "badge-info",
-                }
-                .to_string();
-                CheckItemView {
-                    name: c.name.clone(),
-                    status: c.status.clone(),
-                    status_class,
-                    message: c.message.clone(),
-                    details: c.details.clone(),
-                }
-            })
-            .collect();
-        categories.push((cat_name.clone(), items));
+    let tmpl = RegistryTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        available,
+        repos,
+    };
+
+    render_template(&tmpl)
+}
+
+async fn fetch_catalog(registry_url: &str) -> Option<Vec<String>> {
+    #[derive(Deserialize)]
+    struct Catalog {
+        repositories: Vec<String>,
     }
+    let resp: Catalog = reqwest::get(format!("{}/v2/_catalog", registry_url))
+        .await
+        .ok()?
+        .json()
+        .await
+        .ok()?;
+    Some(resp.repositories)
+}
 
-    let tmpl = ConsistencyTemplate {
-        title: "Consistency".to_string(),
-        current_nav: "consistency".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Consistency".to_string(), url: "/ui/consistency".to_string() },
-        ],
-        pass_count: report.summary.pass,
-        fail_count: report.summary.fail,
-        warn_count: report.summary.warn,
-        timestamp: parse_age(&Some(report.timestamp)),
-        categories,
+async fn fetch_tags(registry_url: &str, repo: &str) -> Vec<String> {
+    #[derive(Deserialize)]
+    struct TagList {
+        tags: Option<Vec<String>>,
+    }
+    let resp = match reqwest::get(format!("{}/v2/{}/tags/list", registry_url, repo)).await {
+        Ok(r) => r,
+        Err(_) => return Vec::new(),
     };
-    render_template(&tmpl)
+    match resp.json::<TagList>().await {
+        Ok(t) => t.tags.unwrap_or_default(),
+        Err(_) => Vec::new(),
+    }
 }
 
-// --- Events ---
+// --- Deployments ---
 
 #[derive(Template)]
-#[template(path = "events.html")]
-struct EventsTemplate {
+#[template(path = "deployments.html")]
+struct DeploymentsTemplate {
     title: String,
     current_nav: String,
     breadcrumbs: Vec<Breadcrumb>,
-    events: Vec<EventView>,
+    deployments: Vec<DeploymentView>,
+    namespaces: Vec<String>,
+    done: String,
+    error: String,
 }
 
-pub async fn handle_events(State(state): State<AppState>) -> Response {
-    let items = state.aggregator.list_events().await.unwrap_or_default();
-
-    let mut events: Vec<EventView> = items
+/// Console-managed deployments, then the ones nodes report.
+pub async fn handle_deployments(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let (managed, _) = state.deployments.list(None);
+    let mut deployments: Vec<DeploymentView> = managed
         .iter()
-        .map(|e| {
-            let type_class = match e.type_field.as_str() {
-                "Normal" => "badge-success",
-                "Warning" => "badge-warning",
-                _ => "badge-info",
-            }
-            .to_string();
-
-            let involved = if e.involved_object.namespace.is_empty() {
-                format!("{}/{}", e.involved_object.kind, e.involved_object.name)
-            } else {
-                format!(
-                    "{}/{}/{}",
-                    e.involved_object.kind, e.involved_object.namespace, e.involved_object.name
-                )
-            };
-
-            EventView {
-                namespace: e.metadata.namespace.clone(),
-                name: e.metadata.name.clone(),
-                reason: e.reason.clone(),
-                message: e.message.clone(),
-                type_field: e.type_field.clone(),
-                type_class,
-                involved_object: involved,
-                count: e.count,
-                age: parse_age(&e.last_timestamp),
-            }
+        .map(|d| DeploymentView {
+            managed: true,
+            ..build_deployment_view(d)
         })
         .collect();
+    let items = state.aggregator.list_deployments().await.unwrap_or_default();
+    deployments.extend(items.iter().map(build_deployment_view));
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+
+    let tmpl = DeploymentsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        deployments,
+        namespaces,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct DeploymentForm {
+    namespace: String,
+    name: String,
+    image: String,
+    #[serde(default = "default_form_replicas")]
+    replicas: i32,
+}
+
+fn default_form_replicas() -> i32 {
+    1
+}
+
+/// Creates a console-managed deployment of one container.
+pub async fn handle_create_deployment(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<DeploymentForm>,
+) -> Response {
+    let name = form.name.trim().to_string();
+    let mut d = k8s::Deployment::default();
+    d.metadata.name = name.clone();
+    d.spec.replicas = form.replicas;
+    d.spec.template.metadata.labels = Some(HashMap::from([("app".to_string(), name.clone())]));
+    d.spec.template.spec.containers = vec![k8s::Container {
+        name: name.clone(),
+        image: form.image.trim().to_string(),
+        ..Default::default()
+    }];
+    if let Err(e) = admit_template(&state, &form.namespace, &d).await {
+        return Redirect::to(&format!("/ui/deployments?error={}", url_encode(&e))).into_response();
+    }
+    let query = match state.deployments.create(&form.namespace, d) {
+        Ok(d) => {
+            let message = format!("{} replicas", d.spec.replicas);
+            state
+                .activity
+                .record("create", "deployment", &form.namespace, &name, &request_user(&headers), &message);
+            format!("done={}", url_encode(&format!("created {}/{}", form.namespace, name)))
+        }
+        Err(e) => format!("error={}", url_encode(&deployment_error_message(e, &name))),
+    };
+    Redirect::to(&format!("/ui/deployments?{}", query)).into_response()
+}
+
+#[derive(Template)]
+#[template(path = "deployment_detail.html")]
+struct DeploymentDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    deploy: DeploymentView,
+    pods: Vec<PodView>,
+    pinned: bool,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_deployment_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    Query(q): Query<FormOutcomeQuery>,
+    headers: HeaderMap,
+    nav: PageNav,
+) -> Response {
+    let (dv, owner_annotation) = match state.deployments.get(&namespace, &name) {
+        Some(d) => (
+            DeploymentView {
+                managed: true,
+                ..build_deployment_view(&d)
+            },
+            crate::controllers::deployments::OWNER_ANNOTATION,
+        ),
+        None => match state.aggregator.get_deployment(&namespace, &name).await {
+            Ok(d) => (build_deployment_view(&d), "vkube.io/owner-deployment"),
+            Err(_) => return (StatusCode::NOT_FOUND, "Deployment not found").into_response(),
+        },
+    };
+    state.recent.touch(&request_user(&headers), "app", &namespace, &name);
+
+    // Find pods owned by this deployment
+    let all_pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+    let pods: Vec<PodView> = all_pods
+        .iter()
+        .filter(|p| {
+            p.metadata.namespace == namespace
+                && p.metadata
+                    .annotations
+                    .as_ref()
+                    .and_then(|a| a.get(owner_annotation))
+                    .map(|v| v == &name)
+                    .unwrap_or(false)
+        })
+        .map(build_pod_view)
+        .collect();
+
+    let tmpl = DeploymentDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        deploy: dv,
+        pods,
+        pinned: state.favorites.is_pinned(
+            &request_user(&headers),
+            &Favorite { kind: "app".to_string(), namespace: namespace.clone(), name: name.clone() },
+        ),
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct DeploymentChangeForm {
+    #[serde(default)]
+    replicas: Option<i32>,
+    /// New image for the first container; rolls the pods over.
+    #[serde(default)]
+    image: Option<String>,
+}
+
+/// Scales a console-managed deployment or changes its image.
+pub async fn handle_update_deployment(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Form(form): Form<DeploymentChangeForm>,
+) -> Response {
+    let back = format!("/ui/deployments/{}/{}", namespace, name);
+    let result = match (form.replicas, form.image.as_deref().map(str::trim)) {
+        (Some(replicas), _) => state
+            .deployments
+            .scale(&namespace, &name, replicas)
+            .map(|_| format!("scaled to {}", replicas)),
+        (None, Some(image)) if !image.is_empty() => {
+            let Some(mut d) = state.deployments.get(&namespace, &name) else {
+                return Redirect::to(&format!("{}?error=deployment%20not%20found", back)).into_response();
+            };
+            match d.spec.template.spec.containers.first_mut() {
+                Some(c) => c.image = image.to_string(),
+                None => return Redirect::to(&format!("{}?error=no%20container", back)).into_response(),
+            }
+            state
+                .deployments
+                .update(&namespace, &name, d)
+                .map(|_| format!("rolling out {}", image))
+        }
+        _ => return Redirect::to(&format!("{}?error=nothing%20to%20change", back)).into_response(),
+    };
+    let query = match result {
+        Ok(message) => {
+            state
+                .activity
+                .record("update", "deployment", &namespace, &name, &request_user(&headers), &message);
+            format!("done={}", url_encode(&message))
+        }
+        Err(e) => format!("error={}", url_encode(&deployment_error_message(e, &name))),
+    };
+    Redirect::to(&format!("{}?{}", back, query)).into_response()
+}
+
+pub async fn handle_delete_deployment(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let query = match state.deployments.delete(&namespace, &name) {
+        Ok(_) => {
+            state
+                .activity
+                .record("delete", "deployment", &namespace, &name, &request_user(&headers), "");
+            format!("done={}", url_encode(&format!("deleted {}/{}; its pods go next", namespace, name)))
+        }
+        Err(e) => format!("error={}", url_encode(&deployment_error_message(e, &name))),
+    };
+    Redirect::to(&format!("/ui/deployments?{}", query)).into_response()
+}
+
+fn deployment_error_message(e: DeploymentError, name: &str) -> String {
+    match e {
+        DeploymentError::NotFound => format!("deployment {} not found", name),
+        DeploymentError::AlreadyExists => format!("deployment {} already exists", name),
+        DeploymentError::Conflict => format!("deployment {} changed meanwhile; try again", name),
+        DeploymentError::Invalid(m) => m,
+    }
+}
+
+fn build_deployment_view(d: &k8s::Deployment) -> DeploymentView {
+    let status = if d.status.ready_replicas >= d.spec.replicas && d.spec.replicas > 0 {
+        "Ready".to_string()
+    } else if d.status.ready_replicas > 0 {
+        "Degraded".to_string()
+    } else {
+        "Pending".to_string()
+    };
+    let status_class = match status.as_str() {
+        "Ready" => "badge-success",
+        "Degraded" => "badge-warning",
+        _ => "badge-info",
+    }
+    .to_string();
+
+    DeploymentView {
+        name: d.metadata.name.clone(),
+        namespace: d.metadata.namespace.clone(),
+        replicas: d.spec.replicas,
+        ready_replicas: d.status.ready_replicas,
+        updated_replicas: d.status.updated_replicas,
+        status,
+        status_class,
+        age: parse_age(&d.metadata.creation_timestamp),
+        managed: false,
+        image: d
+            .spec
+            .template
+            .spec
+            .containers
+            .first()
+            .map(|c| c.image.clone())
+            .unwrap_or_default(),
+        strategy: d.spec.strategy.type_field.clone(),
+    }
+}
+
+// --- Jobs ---
+
+#[derive(Template)]
+#[template(path = "jobs.html")]
+struct JobsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    jobs: Vec<JobView>,
+    namespaces: Vec<String>,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_jobs(State(state): State<AppState>, Query(q): Query<FormOutcomeQuery>, nav: PageNav) -> Response {
+    let (items, _) = state.jobs.list(None);
+    let mut jobs: Vec<JobView> = items.iter().map(build_job_view).collect();
+    jobs.sort_by(|a, b| (&a.namespace, &a.name).cmp(&(&b.namespace, &b.name)));
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+
+    let tmpl = JobsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        jobs,
+        namespaces,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct JobForm {
+    namespace: String,
+    name: String,
+    image: String,
+    /// Overrides the image's entrypoint; split on whitespace.
+    #[serde(default)]
+    command: String,
+    #[serde(default = "default_form_completions")]
+    completions: i32,
+}
+
+fn default_form_completions() -> i32 {
+    1
+}
+
+/// Runs a one-container job.
+pub async fn handle_create_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<JobForm>,
+) -> Response {
+    let name = form.name.trim().to_string();
+    let mut job = k8s::Job::default();
+    job.metadata.name = name.clone();
+    job.spec.completions = form.completions;
+    job.spec.template.spec.containers = vec![k8s::Container {
+        name: name.clone(),
+        image: form.image.trim().to_string(),
+        command: form.command.split_whitespace().map(str::to_string).collect(),
+        ..Default::default()
+    }];
+    if let Err(e) = admit_job(&state, &form.namespace, &job).await {
+        return Redirect::to(&format!("/ui/jobs?error={}", url_encode(&e))).into_response();
+    }
+    let query = match state.jobs.create(&form.namespace, job) {
+        Ok(job) => {
+            let message = format!("{} completions, parallelism {}", job.spec.completions, job.spec.parallelism);
+            state
+                .activity
+                .record("create", "job", &form.namespace, &name, &request_user(&headers), &message);
+            format!("done={}", url_encode(&format!("started {}/{}", form.namespace, name)))
+        }
+        Err(e) => format!("error={}", url_encode(&job_error_message(e, &name))),
+    };
+    Redirect::to(&format!("/ui/jobs?{}", query)).into_response()
+}
+
+pub async fn handle_delete_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let query = match delete_job(&state, &namespace, &name).await {
+        Ok(_) => {
+            state
+                .activity
+                .record("delete", "job", &namespace, &name, &request_user(&headers), "");
+            format!("done={}", url_encode(&format!("deleted {}/{} and its pods", namespace, name)))
+        }
+        Err(e) => format!("error={}", url_encode(&job_error_message(e, &name))),
+    };
+    Redirect::to(&format!("/ui/jobs?{}", query)).into_response()
+}
+
+fn job_error_message(e: JobError, name: &str) -> String {
+    match e {
+        JobError::NotFound => format!("job {} not found", name),
+        JobError::AlreadyExists => format!("job {} already exists", name),
+        JobError::Invalid(m) => m,
+    }
+}
+
+// --- Builds ---
+
+#[derive(Template)]
+#[template(path = "builds.html")]
+struct BuildsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    builds: Vec<BuildView>,
+    namespaces: Vec<String>,
+    /// Nodes with the build capability.
+    nodes: Vec<String>,
+    registry: String,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_builds(State(state): State<AppState>, Query(q): Query<FormOutcomeQuery>, nav: PageNav) -> Response {
+    let (items, _) = state.jobs.list(None);
+    let mut items: Vec<&k8s::Job> = items.iter().filter(|j| builds::is_build(j)).collect();
+    items.sort_by(|a, b| b.metadata.creation_timestamp.cmp(&a.metadata.creation_timestamp));
+    let builds = items
+        .into_iter()
+        .map(|j| BuildView {
+            job: build_job_view(j),
+            context: builds::annotation(j, builds::CONTEXT_ANNOTATION).to_string(),
+            image: builds::annotation(j, builds::IMAGE_ANNOTATION).to_string(),
+            node: j.spec.template.spec.node_name.clone(),
+            deploy: builds::annotation(j, builds::DEPLOY_ANNOTATION).to_string(),
+            log_pod: j
+                .status
+                .runs
+                .last()
+                .map(|r| format!("{}/{}", j.metadata.namespace, r.pod))
+                .unwrap_or_default(),
+        })
+        .collect();
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+    let mut nodes: Vec<String> = state
+        .aggregator
+        .list_all_nodes()
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter(|n| builds::is_builder(n))
+        .map(|n| n.metadata.name.clone())
+        .collect();
+    nodes.sort();
+
+    let tmpl = BuildsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        builds,
+        namespaces,
+        nodes,
+        registry: state.config.registry_url(),
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct BuildForm {
+    namespace: String,
+    name: String,
+    context: String,
+    #[serde(default)]
+    dockerfile: String,
+    image: String,
+    node: String,
+    /// Deployment to roll onto the image once built; none when empty.
+    #[serde(default)]
+    deploy: String,
+}
+
+/// Starts a build job on the chosen node.
+pub async fn handle_create_build(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<BuildForm>,
+) -> Response {
+    let req = builds::BuildRequest {
+        namespace: form.namespace.clone(),
+        name: form.name.trim().to_string(),
+        context: form.context,
+        dockerfile: form.dockerfile,
+        image: form.image,
+        node: form.node,
+        deploy: Some(form.deploy.trim().to_string()).filter(|d| !d.is_empty()),
+    };
+    let job = match builds::build_job(&req, &state.config.builds.builder_image, &state.config.registry_url()) {
+        Ok(job) => job,
+        Err(e) => return Redirect::to(&format!("/ui/builds?error={}", url_encode(&e))).into_response(),
+    };
+    if let Err(e) = admit_job(&state, &form.namespace, &job).await {
+        return Redirect::to(&format!("/ui/builds?error={}", url_encode(&e))).into_response();
+    }
+    let image = builds::annotation(&job, builds::IMAGE_ANNOTATION).to_string();
+    let query = match state.jobs.create(&form.namespace, job) {
+        Ok(_) => {
+            let message = format!("building {} on {}", image, req.node);
+            state
+                .activity
+                .record("build", "job", &form.namespace, &req.name, &request_user(&headers), &message);
+            format!("done={}", url_encode(&format!("started build {}/{}", form.namespace, req.name)))
+        }
+        Err(e) => format!("error={}", url_encode(&job_error_message(e, &req.name))),
+    };
+    Redirect::to(&format!("/ui/builds?{}", query)).into_response()
+}
+
+// --- Cron jobs ---
+
+#[derive(Template)]
+#[template(path = "cronjobs.html")]
+struct CronJobsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    cron_jobs: Vec<CronJobView>,
+    namespaces: Vec<String>,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_cron_jobs(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let (items, _) = state.cron_jobs.list(None);
+    let (jobs, _) = state.jobs.list(None);
+    let cron_jobs = items.iter().map(|c| build_cron_job_view(c, &jobs)).collect();
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+
+    let tmpl = CronJobsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        cron_jobs,
+        namespaces,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct CronJobForm {
+    namespace: String,
+    name: String,
+    schedule: String,
+    image: String,
+    /// Overrides the image's entrypoint; split on whitespace.
+    #[serde(default)]
+    command: String,
+}
+
+/// Creates a cron job running one container.
+pub async fn handle_create_cron_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<CronJobForm>,
+) -> Response {
+    let name = form.name.trim().to_string();
+    let mut c = k8s::CronJob::default();
+    c.metadata.name = name.clone();
+    c.spec.schedule = form.schedule.trim().to_string();
+    c.spec.job_template.spec.template.spec.containers = vec![k8s::Container {
+        name: name.clone(),
+        image: form.image.trim().to_string(),
+        command: form.command.split_whitespace().map(str::to_string).collect(),
+        ..Default::default()
+    }];
+    if let Err(e) = admit_cron_job(&state, &form.namespace, &c).await {
+        return Redirect::to(&format!("/ui/cronjobs?error={}", url_encode(&e))).into_response();
+    }
+    let query = match state.cron_jobs.create(&form.namespace, c) {
+        Ok(c) => {
+            let message = format!("schedule {}", c.spec.schedule);
+            state
+                .activity
+                .record("create", "cronjob", &form.namespace, &name, &request_user(&headers), &message);
+            format!("done={}", url_encode(&format!("created {}/{}", form.namespace, name)))
+        }
+        Err(e) => format!("error={}", url_encode(&cron_job_error_message(e, &name))),
+    };
+    Redirect::to(&format!("/ui/cronjobs?{}", query)).into_response()
+}
+
+#[derive(Template)]
+#[template(path = "cronjob_detail.html")]
+struct CronJobDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    cron: CronJobView,
+    jobs: Vec<JobView>,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_cron_job_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let Some(c) = state.cron_jobs.get(&namespace, &name) else {
+        return (StatusCode::NOT_FOUND, "Cron job not found").into_response();
+    };
+    let (jobs, _) = state.jobs.list(Some(&namespace));
+    let cron = build_cron_job_view(&c, &jobs);
+    let mut owned: Vec<&k8s::Job> = jobs
+        .iter()
+        .filter(|j| crate::controllers::cronjobs::owner(j) == Some(name.as_str()))
+        .collect();
+    // Newest first
+    owned.sort_by(|a, b| b.metadata.creation_timestamp.cmp(&a.metadata.creation_timestamp));
+
+    let tmpl = CronJobDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        cron,
+        jobs: owned.into_iter().map(build_job_view).collect(),
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct CronJobChangeForm {
+    suspend: bool,
+}
+
+/// Pauses or resumes a cron job.
+pub async fn handle_update_cron_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Form(form): Form<CronJobChangeForm>,
+) -> Response {
+    let back = format!("/ui/cronjobs/{}/{}", namespace, name);
+    let query = match state.cron_jobs.set_suspend(&namespace, &name, form.suspend) {
+        Ok(_) => {
+            let message = if form.suspend { "suspended" } else { "resumed" };
+            state
+                .activity
+                .record("update", "cronjob", &namespace, &name, &request_user(&headers), message);
+            format!("done={}", message)
+        }
+        Err(e) => format!("error={}", url_encode(&cron_job_error_message(e, &name))),
+    };
+    Redirect::to(&format!("{}?{}", back, query)).into_response()
+}
+
+pub async fn handle_delete_cron_job(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let query = match state.cron_jobs.delete(&namespace, &name) {
+        Ok(_) => {
+            state
+                .activity
+                .record("delete", "cronjob", &namespace, &name, &request_user(&headers), "");
+            format!("done={}", url_encode(&format!("deleted {}/{}; its jobs go next", namespace, name)))
+        }
+        Err(e) => format!("error={}", url_encode(&cron_job_error_message(e, &name))),
+    };
+    Redirect::to(&format!("/ui/cronjobs?{}", query)).into_response()
+}
+
+fn cron_job_error_message(e: CronJobError, name: &str) -> String {
+    match e {
+        CronJobError::NotFound => format!("cron job {} not found", name),
+        CronJobError::AlreadyExists => format!("cron job {} already exists", name),
+        CronJobError::Conflict => format!("cron job {} changed meanwhile; try again", name),
+        CronJobError::Invalid(m) => m,
+    }
+}
+
+// `jobs` may hold other cron jobs' jobs too.
+fn build_cron_job_view(c: &k8s::CronJob, jobs: &[k8s::Job]) -> CronJobView {
+    let newest = jobs
+        .iter()
+        .filter(|j| {
+            j.metadata.namespace == c.metadata.namespace
+                && crate::controllers::cronjobs::owner(j) == Some(c.metadata.name.as_str())
+        })
+        .max_by(|a, b| a.metadata.creation_timestamp.cmp(&b.metadata.creation_timestamp))
+        .map(build_job_view);
+    let next_run = if c.spec.suspend {
+        String::new()
+    } else {
+        crate::cron::CronSchedule::parse(&c.spec.schedule)
+            .ok()
+            .and_then(|s| s.next_after(chrono::Utc::now()))
+            .map(|at| format!("in {}", human_duration_secs((at - chrono::Utc::now()).num_seconds().max(0))))
+            .unwrap_or_default()
+    };
+    CronJobView {
+        name: c.metadata.name.clone(),
+        namespace: c.metadata.namespace.clone(),
+        schedule: c.spec.schedule.clone(),
+        suspended: c.spec.suspend,
+        concurrency_policy: c.spec.concurrency_policy.clone(),
+        image: c
+            .spec
+            .job_template
+            .spec
+            .template
+            .spec
+            .containers
+            .first()
+            .map(|ct| ct.image.clone())
+            .unwrap_or_default(),
+        active: c.status.active.len(),
+        last_schedule: parse_age(&c.status.last_schedule_time),
+        last_success: parse_age(&c.status.last_successful_time),
+        last_status: newest.as_ref().map(|j| j.status.clone()).unwrap_or_default(),
+        last_status_class: newest.map(|j| j.status_class).unwrap_or_default(),
+        next_run,
+        age: parse_age(&c.metadata.creation_timestamp),
+    }
+}
+
+#[derive(Template)]
+#[template(path = "job_detail.html")]
+struct JobDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    job: JobView,
+    runs: Vec<JobRunView>,
+}
+
+pub async fn handle_job_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    nav: PageNav,
+) -> Response {
+    let Some(job) = state.jobs.get(&namespace, &name) else {
+        return (StatusCode::NOT_FOUND, "Job not found").into_response();
+    };
+    // Newest first
+    let runs = job.status.runs.iter().rev().map(build_job_run_view).collect();
+
+    let tmpl = JobDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        job: build_job_view(&job),
+        runs,
+    };
+    render_template(&tmpl)
+}
+
+fn build_job_view(j: &k8s::Job) -> JobView {
+    let condition = j.status.conditions.last();
+    let (status, status_class) = match condition.map(|c| c.type_.as_str()) {
+        Some("Complete") => ("Complete", "badge-success"),
+        Some("Failed") => ("Failed", "badge-error"),
+        _ if j.status.active > 0 => ("Running", "badge-info"),
+        _ => ("Pending", "badge-warning"),
+    };
+    JobView {
+        name: j.metadata.name.clone(),
+        namespace: j.metadata.namespace.clone(),
+        status: status.to_string(),
+        status_class: status_class.to_string(),
+        succeeded: j.status.succeeded,
+        completions: j.spec.completions,
+        parallelism: j.spec.parallelism,
+        active: j.status.active,
+        failed: j.status.failed,
+        backoff_limit: j.spec.backoff_limit,
+        duration: span(j.status.start_time.as_deref(), j.status.completion_time.as_deref()),
+        age: parse_age(&j.metadata.creation_timestamp),
+        message: condition.map(|c| c.message.clone()).unwrap_or_default(),
+    }
+}
+
+fn build_job_run_view(r: &k8s::JobRun) -> JobRunView {
+    let phase_class = match r.phase.as_str() {
+        "Succeeded" => "badge-success",
+        "Running" => "badge-info",
+        "Failed" => "badge-error",
+        _ => "badge-warning",
+    };
+    JobRunView {
+        pod: r.pod.clone(),
+        node: r.node.clone(),
+        phase: r.phase.clone(),
+        phase_class: phase_class.to_string(),
+        exit_code: r.exit_code.map(|c| c.to_string()).unwrap_or_default(),
+        reason: r.reason.clone(),
+        duration: span(r.created_at.as_deref(), r.finished_at.as_deref()),
+        age: parse_age(&r.created_at),
+    }
+}
+
+// Time from `start` to `end`, or to now while there is no end.
+fn span(start: Option<&str>, end: Option<&str>) -> String {
+    let parse = |t: &str| chrono::DateTime::parse_from_rfc3339(t).ok().map(|t| t.to_utc());
+    let Some(start) = start.and_then(parse) else {
+        return String::new();
+    };
+    let end = end.and_then(parse).unwrap_or_else(chrono::Utc::now);
+    human_duration_secs((end - start).num_seconds().max(0))
+}
+
+// --- Daemon sets ---
+
+#[derive(Template)]
+#[template(path = "daemonsets.html")]
+struct DaemonSetsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    sets: Vec<DaemonSetView>,
+}
+
+pub async fn handle_daemon_sets(State(state): State<AppState>, nav: PageNav) -> Response {
+    let sets = state.daemon_sets.list(None).iter().map(build_daemon_set_view).collect();
+
+    let tmpl = DaemonSetsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        sets,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Template)]
+#[template(path = "daemonset_detail.html")]
+struct DaemonSetDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    set: DaemonSetView,
+    nodes: Vec<DaemonNodeView>,
+}
+
+pub async fn handle_daemon_set_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    nav: PageNav,
+) -> Response {
+    let Some(set) = state.daemon_sets.get(&namespace, &name) else {
+        return (StatusCode::NOT_FOUND, "Daemon set not found").into_response();
+    };
+    let nodes = set
+        .status
+        .nodes
+        .iter()
+        .map(|n| {
+            let phase_class = match n.phase.as_str() {
+                _ if !n.error.is_empty() => "badge-error",
+                "Running" => "badge-success",
+                "Failed" => "badge-error",
+                _ => "badge-warning",
+            };
+            DaemonNodeView {
+                node: n.node.clone(),
+                healthy: n.healthy,
+                pod: n.pod.clone(),
+                phase: n.phase.clone(),
+                phase_class: phase_class.to_string(),
+                error: n.error.clone(),
+            }
+        })
+        .collect();
+
+    let tmpl = DaemonSetDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        set: build_daemon_set_view(&set),
+        nodes,
+    };
+    render_template(&tmpl)
+}
+
+fn build_daemon_set_view(s: &MicroDaemonSet) -> DaemonSetView {
+    let st = &s.status;
+    let (status, status_class) = match st.last_sync {
+        None => ("Pending", "badge-warning"),
+        Some(_) if st.desired > 0 && st.ready == st.desired => ("Ready", "badge-success"),
+        Some(_) if st.nodes.iter().any(|n| !n.error.is_empty()) => ("Degraded", "badge-error"),
+        Some(_) => ("Progressing", "badge-info"),
+    };
+    DaemonSetView {
+        namespace: s.namespace.clone(),
+        name: s.name.clone(),
+        node_selector: s.node_selector.clone(),
+        desired: st.desired,
+        current: st.current,
+        ready: st.ready,
+        status: status.to_string(),
+        status_class: status_class.to_string(),
+        synced_age: human_time(st.last_sync),
+        age: human_time(Some(s.created_at)),
+    }
+}
+
+// --- Stuck pods ---
+
+#[derive(Template)]
+#[template(path = "stuck_pods.html")]
+struct StuckPodsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    pods: Vec<StuckPodView>,
+    done: String,
+    error: String,
+}
+
+/// Result of a form post, passed along its redirect for the page's banner.
+#[derive(Deserialize, Default)]
+pub struct FormOutcomeQuery {
+    #[serde(default)]
+    pub done: String,
+    #[serde(default)]
+    pub error: String,
+}
+
+pub async fn handle_stuck_pods(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let pods = stuck::analyze(&state.aggregator)
+        .await
+        .into_iter()
+        .map(|p| StuckPodView {
+            problem_class: match p.problem.as_str() {
+                "Pending" => "badge-warning",
+                _ => "badge-error",
+            }
+            .to_string(),
+            age: parse_age(&p.since),
+            remediations: p
+                .remediations
+                .iter()
+                .map(|r| (r.as_str().to_string(), r.label().to_string()))
+                .collect(),
+            namespace: p.namespace,
+            name: p.name,
+            node: p.node,
+            problem: p.problem,
+            causes: p.causes,
+        })
+        .collect();
+
+    let tmpl = StuckPodsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        pods,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct RemediateForm {
+    pub action: stuck::Remediation,
+}
+
+pub async fn handle_remediate_pod(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+    Form(form): Form<RemediateForm>,
+) -> Response {
+    let result = stuck::remediate(&state.aggregator, &namespace, &name, form.action).await;
+    let query = match result {
+        Ok(done) => {
+            state.activity.record(
+                form.action.as_str(),
+                "pod",
+                &namespace,
+                &name,
+                &request_user(&headers),
+                &done,
+            );
+            format!("done={}", url_encode(&format!("{}/{}: {}", namespace, name, done)))
+        }
+        Err(e) => format!("error={}", url_encode(&format!("{}/{}: {}", namespace, name, e))),
+    };
+    Redirect::to(&format!("/ui/stuck-pods?{}", query)).into_response()
+}
+
+// --- Node pools ---
+
+#[derive(Template)]
+#[template(path = "pools.html")]
+struct PoolsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    pools: Vec<PoolView>,
+    /// Nodes outside any pool.
+    unpooled: Vec<String>,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_pools(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let clients = state.aggregator.snapshot_clients().await;
+    let healthy: HashMap<&str, bool> = clients.iter().map(|c| (c.name.as_str(), c.is_healthy())).collect();
+    let mut unpooled: Vec<String> = clients
+        .iter()
+        .filter(|c| c.pool.is_none())
+        .map(|c| c.name.clone())
+        .collect();
+    unpooled.sort();
+
+    let pools = pools::summarize(&state.aggregator, &state.pools)
+        .await
+        .into_iter()
+        .map(|p| PoolView {
+            nodes: p
+                .nodes
+                .iter()
+                .map(|n| (n.clone(), healthy.get(n.as_str()).copied().unwrap_or(false)))
+                .collect(),
+            healthy_nodes: p.healthy_nodes,
+            pods: p.pods,
+            cordoned: p.cordon.is_some(),
+            cordoned_by: p.cordon.as_ref().map(|c| c.by.clone()).unwrap_or_default(),
+            cordoned_age: p.cordon.as_ref().map(|c| human_time(Some(c.at))).unwrap_or_default(),
+            name: p.name,
+        })
+        .collect();
+
+    let tmpl = PoolsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        pools,
+        unpooled,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct CordonForm {
+    /// true to cordon, false to lift the cordon.
+    pub cordon: bool,
+}
+
+pub async fn handle_cordon_pool(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+    Form(form): Form<CordonForm>,
+) -> Response {
+    let user = request_user(&headers);
+    let query = if form.cordon && !pools::exists(&state.aggregator, &name).await {
+        format!("error={}", url_encode(&format!("no node is in pool {}", name)))
+    } else if form.cordon && state.pools.cordon(&name, &user) {
+        let done = format!("pool {} takes no new pods", name);
+        state.activity.record("cordon", "pool", "", &name, &user, &done);
+        format!("done={}", url_encode(&done))
+    } else if !form.cordon && state.pools.uncordon(&name) {
+        let done = format!("pool {} takes pods again", name);
+        state.activity.record("uncordon", "pool", "", &name, &user, &done);
+        format!("done={}", url_encode(&done))
+    } else if form.cordon {
+        format!("error={}", url_encode(&format!("pool {} is already cordoned", name)))
+    } else {
+        format!("error={}", url_encode(&format!("pool {} is not cordoned", name)))
+    };
+    Redirect::to(&format!("/ui/pools?{}", query)).into_response()
+}
+
+// --- Shared storage ---
+
+#[derive(Template)]
+#[template(path = "storage.html")]
+struct StorageTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    shares: Vec<SharedStorageView>,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_storage(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let shares = state
+        .storage
+        .list()
+        .into_iter()
+        .map(|s| SharedStorageView {
+            kind: s.kind.as_str().to_string(),
+            source: s.source(),
+            read_only: s.read_only,
+            added_age: human_time(Some(s.added_at)),
+            added_by: s.added_by,
+            description: s.description,
+            name: s.name,
+        })
+        .collect();
+
+    let tmpl = StorageTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        shares,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+pub async fn handle_add_storage(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(req): Form<ShareRequest>,
+) -> Response {
+    let user = request_user(&headers);
+    let query = match state.storage.add(req, &user) {
+        Ok(export) => {
+            let done = format!("added {}", export.name);
+            let message = format!("{} {}", export.kind.as_str(), export.source());
+            state.activity.record("add", "storage", "", &export.name, &user, &message);
+            format!("done={}", url_encode(&done))
+        }
+        Err(e) => format!("error={}", url_encode(&e)),
+    };
+    Redirect::to(&format!("/ui/storage?{}", query)).into_response()
+}
+
+pub async fn handle_remove_storage(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path(name): Path<String>,
+) -> Response {
+    let query = if state.storage.remove(&name) {
+        let done = format!("{} removed from the storage catalog", name);
+        state.activity.record("remove", "storage", "", &name, &request_user(&headers), &done);
+        format!("done={}", url_encode(&done))
+    } else {
+        format!("error={}", url_encode(&format!("{} is not in the storage catalog", name)))
+    };
+    Redirect::to(&format!("/ui/storage?{}", query)).into_response()
+}
+
+// --- Networks ---
+
+#[derive(Template)]
+#[template(path = "networks.html")]
+struct NetworksTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    networks: Vec<NetworkView>,
+}
+
+pub async fn handle_networks(State(state): State<AppState>, nav: PageNav) -> Response {
+    let items = state.aggregator.list_networks().await.unwrap_or_default();
+    let networks: Vec<NetworkView> = items.iter().map(build_network_view).collect();
+
+    let tmpl = NetworksTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        networks,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Template)]
+#[template(path = "network_detail.html")]
+struct NetworkDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    net: NetworkView,
+    reservations: Vec<DHCPReservationView>,
+    static_records: Vec<StaticRecordView>,
+}
+
+pub async fn handle_network_detail(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+    nav: PageNav,
+) -> Response {
+    let net = match state.aggregator.get_network(&name).await {
+        Ok(n) => n,
+        Err(_) => return (StatusCode::NOT_FOUND, "Network not found").into_response(),
+    };
+
+    let nv = build_network_view(&net);
+
+    let reservations: Vec<DHCPReservationView> = net
+        .spec
+        .dhcp
+        .reservations
+        .iter()
+        .map(|r| DHCPReservationView {
+            mac: r.mac.clone(),
+            ip: r.ip.clone(),
+            hostname: r.hostname.clone(),
+        })
+        .collect();
+
+    let static_records: Vec<StaticRecordView> = net
+        .spec
+        .static_records
+        .iter()
+        .map(|r| StaticRecordView {
+            name: r.name.clone(),
+            ip: r.ip.clone(),
+        })
+        .collect();
+
+    let tmpl = NetworkDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        net: nv,
+        reservations,
+        static_records,
+    };
+    render_template(&tmpl)
+}
+
+fn build_network_view(n: &k8s::Network) -> NetworkView {
+    let status = if n.status.dns_alive {
+        "Active".to_string()
+    } else if n.spec.external_dns {
+        "External".to_string()
+    } else {
+        "Unknown".to_string()
+    };
+    let status_class = match status.as_str() {
+        "Active" => "badge-success",
+        "External" => "badge-info",
+        _ => "badge-warning",
+    }
+    .to_string();
+
+    NetworkView {
+        name: n.metadata.name.clone(),
+        type_field: n.spec.type_field.clone(),
+        cidr: n.spec.cidr.clone(),
+        gateway: n.spec.gateway.clone(),
+        dns_zone: n.spec.dns.zone.clone(),
+        dns_server: n.spec.dns.server.clone(),
+        dhcp_enabled: n.spec.dhcp.enabled,
+        managed: n.spec.managed,
+        dns_alive: n.status.dns_alive,
+        pod_count: n.status.pod_count,
+        status,
+        status_class,
+        age: parse_age(&n.metadata.creation_timestamp),
+    }
+}
+
+// --- PVCs ---
+
+#[derive(Template)]
+#[template(path = "pvcs.html")]
+struct PVCsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    pvcs: Vec<PVCView>,
+}
+
+pub async fn handle_pvcs(State(state): State<AppState>, nav: PageNav) -> Response {
+    let items = state.aggregator.list_pvcs().await.unwrap_or_default();
+    let pvcs: Vec<PVCView> = items.iter().map(build_pvc_view).collect();
+
+    let tmpl = PVCsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        pvcs,
+    };
+    render_template(&tmpl)
+}
+
+fn build_pvc_view(p: &k8s::PersistentVolumeClaim) -> PVCView {
+    let status_class = match p.status.phase.as_str() {
+        "Bound" => "badge-success",
+        "Pending" => "badge-warning",
+        "Lost" => "badge-error",
+        _ => "badge-info",
+    }
+    .to_string();
+
+    let capacity = p
+        .status
+        .capacity
+        .get("storage")
+        .cloned()
+        .unwrap_or_default();
+
+    PVCView {
+        name: p.metadata.name.clone(),
+        namespace: p.metadata.namespace.clone(),
+        status: p.status.phase.clone(),
+        status_class,
+        capacity,
+        access_modes: p.spec.access_modes.join(", "),
+        age: parse_age(&p.metadata.creation_timestamp),
+    }
+}
+
+// --- BareMetalHosts ---
+
+#[derive(Template)]
+#[template(path = "bmhs.html")]
+struct BMHsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    bmhs: Vec<BMHView>,
+}
+
+pub async fn handle_bmhs(State(state): State<AppState>, nav: PageNav) -> Response {
+    let items = state.aggregator.list_bmhs().await.unwrap_or_default();
+    let bmhs: Vec<BMHView> = items.iter().map(build_bmh_view).collect();
+
+    let tmpl = BMHsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        bmhs,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Template)]
+#[template(path = "bmh_detail.html")]
+struct BMHDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    bmh: BMHView,
+}
+
+pub async fn handle_bmh_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    nav: PageNav,
+) -> Response {
+    let bmh = match state.aggregator.get_bmh(&namespace, &name).await {
+        Ok(b) => b,
+        Err(_) => return (StatusCode::NOT_FOUND, "BMH not found").into_response(),
+    };
+
+    let bv = build_bmh_view(&bmh);
+
+    let tmpl = BMHDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        bmh: bv,
+    };
+    render_template(&tmpl)
+}
+
+fn build_bmh_view(b: &k8s::BareMetalHost) -> BMHView {
+    let status_class = match b.status.phase.as_str() {
+        "Ready" => "badge-success",
+        "Provisioning" | "Registering" => "badge-warning",
+        "Error" => "badge-error",
+        _ => "badge-info",
+    }
+    .to_string();
+
+    BMHView {
+        name: b.metadata.name.clone(),
+        namespace: b.metadata.namespace.clone(),
+        phase: b.status.phase.clone(),
+        status_class,
+        powered_on: b.status.powered_on,
+        network: b.spec.network.clone(),
+        image: b.spec.image.clone(),
+        ip: b.spec.ip.clone(),
+        mac: b.spec.boot_mac_address.clone(),
+        bmc_address: b.spec.bmc.address.clone(),
+        bmc_network: b.spec.bmc.network.clone(),
+        bmc_username: b.spec.bmc.username.clone(),
+        age: parse_age(&b.metadata.creation_timestamp),
+    }
+}
+
+// --- iSCSI CDROMs ---
+
+#[derive(Template)]
+#[template(path = "iscsi_cdroms.html")]
+struct ISCSICdromsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    cdroms: Vec<ISCSICdromView>,
+}
+
+pub async fn handle_iscsi_cdroms(State(state): State<AppState>, nav: PageNav) -> Response {
+    let items = state.aggregator.list_iscsi_cdroms().await.unwrap_or_default();
+    let cdroms: Vec<ISCSICdromView> = items.iter().map(build_iscsi_cdrom_view).collect();
+
+    let tmpl = ISCSICdromsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        cdroms,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Template)]
+#[template(path = "iscsi_cdrom_detail.html")]
+struct ISCSICdromDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    cdrom: ISCSICdromView,
+    subscribers: Vec<SubscriberView>,
+}
+
+pub async fn handle_iscsi_cdrom_detail(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+    nav: PageNav,
+) -> Response {
+    let cdrom = match state.aggregator.get_iscsi_cdrom(&name).await {
+        Ok(c) => c,
+        Err(_) => return (StatusCode::NOT_FOUND, "iSCSI CDROM not found").into_response(),
+    };
+
+    let cv = build_iscsi_cdrom_view(&cdrom);
+
+    let subscribers: Vec<SubscriberView> = cdrom
+        .status
+        .subscribers
+        .iter()
+        .map(|s| SubscriberView {
+            name: s.name.clone(),
+            initiator_iqn: s.initiator_iqn.clone(),
+            since: parse_age(&Some(s.since.clone())),
+        })
+        .collect();
+
+    let tmpl = ISCSICdromDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        cdrom: cv,
+        subscribers,
+    };
+    render_template(&tmpl)
+}
+
+fn build_iscsi_cdrom_view(c: &k8s::ISCSICdrom) -> ISCSICdromView {
+    let status_class = match c.status.phase.as_str() {
+        "Ready" => "badge-success",
+        "Uploading" | "Pending" => "badge-warning",
+        "Error" => "badge-error",
+        _ => "badge-info",
+    }
+    .to_string();
+
+    let iso_size_display = if c.status.iso_size == 0 {
+        "-".to_string()
+    } else {
+        human_bytes(c.status.iso_size)
+    };
+
+    let portal = if c.status.portal_ip.is_empty() {
+        String::new()
+    } else {
+        format!("{}:{}", c.status.portal_ip, c.status.portal_port)
+    };
+
+    ISCSICdromView {
+        name: c.metadata.name.clone(),
+        phase: c.status.phase.clone(),
+        status_class,
+        iso_file: c.spec.iso_file.clone(),
+        iso_size_display,
+        description: c.spec.description.clone(),
+        target_iqn: c.status.target_iqn.clone(),
+        portal,
+        subscriber_count: c.status.subscribers.len(),
+        age: parse_age(&c.metadata.creation_timestamp),
+    }
+}
+
+// --- ConfigMaps ---
+
+#[derive(Template)]
+#[template(path = "configmaps.html")]
+struct ConfigMapsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    configmaps: Vec<ConfigMapView>,
+    namespaces: Vec<String>,
+    done: String,
+    error: String,
+}
+
+pub async fn handle_configmaps(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    // Collect configmaps from all namespaces we know about
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+
+    let mut configmaps = Vec::new();
+    for ns in &namespaces {
+        if let Ok(cms) = state.aggregator.list_configmaps(ns).await {
+            for cm in &cms {
+                configmaps.push(ConfigMapView {
+                    name: cm.metadata.name.clone(),
+                    namespace: cm.metadata.namespace.clone(),
+                    key_count: cm.data.len(),
+                    age: parse_age(&cm.metadata.creation_timestamp),
+                });
+            }
+        }
+    }
+
+    let tmpl = ConfigMapsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        configmaps,
+        namespaces,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize)]
+pub struct ConfigMapForm {
+    namespace: String,
+    name: String,
+    /// One key=value per line.
+    #[serde(default)]
+    data: String,
+}
+
+pub async fn handle_create_configmap(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<ConfigMapForm>,
+) -> Response {
+    let mut cm = k8s::ConfigMap::default();
+    cm.metadata.namespace = form.namespace;
+    cm.metadata.name = form.name.trim().to_string();
+    for line in form.data.lines().filter(|l| !l.trim().is_empty()) {
+        let Some((key, value)) = line.split_once('=') else {
+            let error = format!("{:?} is not key=value", line);
+            return Redirect::to(&format!("/ui/configmaps?error={}", url_encode(&error))).into_response();
+        };
+        cm.data.insert(key.trim().to_string(), value.to_string());
+    }
+    if cm.metadata.name.is_empty() {
+        return Redirect::to("/ui/configmaps?error=a%20name%20is%20required").into_response();
+    }
+
+    let query = match state.aggregator.create_configmap(&cm).await {
+        Ok((created, failed)) => {
+            let (namespace, name) = (&created.metadata.namespace, &created.metadata.name);
+            let message = format!("{} keys", created.data.len());
+            state
+                .activity
+                .record("create", "configmap", namespace, name, &request_user(&headers), &message);
+            let done = match failed.as_slice() {
+                [] => format!("created {}/{}", namespace, name),
+                _ => format!("created {}/{}, but not on {}", namespace, name, failed.join("; ")),
+            };
+            format!("done={}", url_encode(&done))
+        }
+        Err(e) => format!("error={}", url_encode(&e.to_string())),
+    };
+    Redirect::to(&format!("/ui/configmaps?{}", query)).into_response()
+}
+
+pub async fn handle_delete_configmap(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let query = match state.aggregator.delete_configmap(&namespace, &name).await {
+        Ok(failed) => {
+            state
+                .activity
+                .record("delete", "configmap", &namespace, &name, &request_user(&headers), "");
+            let done = match failed.as_slice() {
+                [] => format!("deleted {}/{}", namespace, name),
+                _ => format!("deleted {}/{}, but not from {}", namespace, name, failed.join("; ")),
+            };
+            format!("done={}", url_encode(&done))
+        }
+        Err(e) => format!("error={}", url_encode(&e.to_string())),
+    };
+    Redirect::to(&format!("/ui/configmaps?{}", query)).into_response()
+}
+
+#[derive(Template)]
+#[template(path = "configmap_detail.html")]
+struct ConfigMapDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    cm_name: String,
+    cm_namespace: String,
+    keys: Vec<String>,
+    data: HashMap<String, String>,
+}
+
+pub async fn handle_configmap_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    nav: PageNav,
+) -> Response {
+    let cm = match state.aggregator.get_configmap(&namespace, &name).await {
+        Ok(c) => c,
+        Err(_) => return (StatusCode::NOT_FOUND, "ConfigMap not found").into_response(),
+    };
+
+    let mut keys: Vec<String> = cm.data.keys().cloned().collect();
+    keys.sort();
+
+    let tmpl = ConfigMapDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        cm_name: name,
+        cm_namespace: namespace,
+        keys,
+        data: cm.data,
+    };
+    render_template(&tmpl)
+}
+
+// --- Services ---
+
+#[derive(Template)]
+#[template(path = "services.html")]
+struct ServicesTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    services: Vec<ServiceView>,
+}
+
+/// Services with the pods behind them, from the endpoints merged across
+/// nodes.
+pub async fn handle_services(State(state): State<AppState>, nav: PageNav) -> Response {
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+
+    let mut services = Vec::new();
+    for ns in &namespaces {
+        let Ok(svcs) = state.aggregator.list_services(ns).await else {
+            continue;
+        };
+        if svcs.is_empty() {
+            continue;
+        }
+        let endpoints = state.aggregator.list_endpoints(ns).await.unwrap_or_default();
+        for svc in &svcs {
+            services.push(build_service_view(svc, endpoints.iter().find(|ep| ep.metadata.name == svc.metadata.name)));
+        }
+    }
+
+    let tmpl = ServicesTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        services,
+    };
+    render_template(&tmpl)
+}
+
+fn build_service_view(svc: &k8s::Service, endpoints: Option<&k8s::Endpoints>) -> ServiceView {
+    let ports = svc
+        .spec
+        .ports
+        .iter()
+        .map(|p| {
+            let protocol = if p.protocol.is_empty() { "TCP" } else { p.protocol.as_str() };
+            match p.target_port {
+                Some(serde_json::Value::Number(ref n)) => format!("{}\u{2192}{}/{}", p.port, n, protocol),
+                Some(serde_json::Value::String(ref name)) => format!("{}\u{2192}{}/{}", p.port, name, protocol),
+                _ => format!("{}/{}", p.port, protocol),
+            }
+        })
+        .collect();
+    let mut selector: Vec<String> = svc.spec.selector.iter().map(|(k, v)| format!("{}={}", k, v)).collect();
+    selector.sort();
+
+    let mut backends = Vec::new();
+    for subset in endpoints.map(|ep| ep.subsets.as_slice()).unwrap_or_default() {
+        let ready = subset.addresses.iter().map(|a| (a, true));
+        let not_ready = subset.not_ready_addresses.iter().map(|a| (a, false));
+        for (a, ready) in ready.chain(not_ready) {
+            let target = a.target_ref.as_ref();
+            backends.push(ServiceBackendView {
+                ip: a.ip.clone(),
+                pod: target.map(|t| t.name.clone()).unwrap_or_default(),
+                namespace: target.map(|t| t.namespace.clone()).unwrap_or_default(),
+                node: a.node_name.clone().unwrap_or_default(),
+                ready,
+            });
+        }
+    }
+
+    ServiceView {
+        name: svc.metadata.name.clone(),
+        namespace: svc.metadata.namespace.clone(),
+        service_type: if svc.spec.service_type.is_empty() {
+            "ClusterIP".to_string()
+        } else {
+            svc.spec.service_type.clone()
+        },
+        cluster_ip: svc.spec.cluster_ip.clone(),
+        ports,
+        selector: selector.join(", "),
+        backends,
+        age: parse_age(&svc.metadata.creation_timestamp),
+    }
+}
+
+// --- Secrets ---
+
+#[derive(Template)]
+#[template(path = "secrets.html")]
+struct SecretsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    secrets: Vec<SecretView>,
+    namespaces: Vec<String>,
+    done: String,
+    error: String,
+}
+
+/// Secret names and key counts; values are only on the detail page.
+pub async fn handle_secrets(
+    State(state): State<AppState>,
+    Query(q): Query<FormOutcomeQuery>,
+    nav: PageNav,
+) -> Response {
+    let namespaces: Vec<String> = state
+        .aggregator
+        .list_namespaces()
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|ns| ns.metadata.name)
+        .collect();
+
+    let mut secrets = Vec::new();
+    for ns in &namespaces {
+        for s in state.aggregator.list_secrets(ns).await.unwrap_or_default() {
+            secrets.push(SecretView {
+                name: s.metadata.name.clone(),
+                namespace: s.metadata.namespace.clone(),
+                secret_type: if s.secret_type.is_empty() { "Opaque".to_string() } else { s.secret_type.clone() },
+                key_count: s.data.len(),
+                age: parse_age(&s.metadata.creation_timestamp),
+            });
+        }
+    }
+
+    let tmpl = SecretsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        secrets,
+        namespaces,
+        done: q.done,
+        error: q.error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Template)]
+#[template(path = "secret_detail.html")]
+struct SecretDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    secret_name: String,
+    secret_namespace: String,
+    secret_type: String,
+    keys: Vec<SecretKeyView>,
+}
+
+pub async fn handle_secret_detail(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    nav: PageNav,
+) -> Response {
+    let secret = match state.aggregator.get_secret(&namespace, &name).await {
+        Ok(s) => s,
+        Err(_) => return (StatusCode::NOT_FOUND, "Secret not found").into_response(),
+    };
+
+    let mut keys: Vec<SecretKeyView> = secret
+        .data
+        .iter()
+        .map(|(key, encoded)| {
+            let raw = STANDARD.decode(encoded).unwrap_or_default();
+            let value = match String::from_utf8(raw.clone()) {
+                Ok(text) => text,
+                Err(_) => format!("({} bytes of binary data)", raw.len()),
+            };
+            SecretKeyView {
+                key: key.clone(),
+                value,
+                bytes: raw.len(),
+            }
+        })
+        .collect();
+    keys.sort_by(|a, b| a.key.cmp(&b.key));
+
+    let tmpl = SecretDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        secret_name: name,
+        secret_namespace: namespace,
+        secret_type: if secret.secret_type.is_empty() { "Opaque".to_string() } else { secret.secret_type },
+        keys,
+    };
+    // The values are in the page; keep it out of browser and proxy caches
+    ([(header::CACHE_CONTROL, "no-store")], render_template(&tmpl)).into_response()
+}
+
+pub async fn handle_create_secret(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Form(form): Form<ConfigMapForm>,
+) -> Response {
+    let mut secret = k8s::Secret::default();
+    secret.metadata.namespace = form.namespace;
+    secret.metadata.name = form.name.trim().to_string();
+    for line in form.data.lines().filter(|l| !l.trim().is_empty()) {
+        let Some((key, value)) = line.split_once('=') else {
+            // The line may hold a value; don't echo it back
+            let error = "each line must be key=value";
+            return Redirect::to(&format!("/ui/secrets?error={}", url_encode(error))).into_response();
+        };
+        secret.data.insert(key.trim().to_string(), STANDARD.encode(value));
+    }
+    if secret.metadata.name.is_empty() {
+        return Redirect::to("/ui/secrets?error=a%20name%20is%20required").into_response();
+    }
+
+    let query = match state.aggregator.create_secret(&secret).await {
+        Ok((created, failed)) => {
+            let (namespace, name) = (&created.metadata.namespace, &created.metadata.name);
+            let mut keys: Vec<&str> = created.data.keys().map(String::as_str).collect();
+            keys.sort();
+            let message = format!("keys {}", keys.join(", "));
+            state
+                .activity
+                .record("create", "secret", namespace, name, &request_user(&headers), &message);
+            let done = match failed.as_slice() {
+                [] => format!("created {}/{}", namespace, name),
+                _ => format!("created {}/{}, but not on {}", namespace, name, failed.join("; ")),
+            };
+            format!("done={}", url_encode(&done))
+        }
+        Err(e) => format!("error={}", url_encode(&e.to_string())),
+    };
+    Redirect::to(&format!("/ui/secrets?{}", query)).into_response()
+}
+
+pub async fn handle_delete_secret(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    Path((namespace, name)): Path<(String, String)>,
+) -> Response {
+    let query = match state.aggregator.delete_secret(&namespace, &name).await {
+        Ok(failed) => {
+            state
+                .activity
+                .record("delete", "secret", &namespace, &name, &request_user(&headers), "");
+            let done = match failed.as_slice() {
+                [] => format!("deleted {}/{}", namespace, name),
+                _ => format!("deleted {}/{}, but not from {}", namespace, name, failed.join("; ")),
+            };
+            format!("done={}", url_encode(&done))
+        }
+        Err(e) => format!("error={}", url_encode(&e.to_string())),
+    };
+    Redirect::to(&format!("/ui/secrets?{}", query)).into_response()
+}
+
+// --- Consistency ---
+
+#[derive(Template)]
+#[template(path = "consistency.html")]
+struct ConsistencyTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    pass_count: usize,
+    fail_count: usize,
+    warn_count: usize,
+    timestamp: String,
+    categories: Vec<(String, Vec<CheckItemView>)>,
+}
+
+pub async fn handle_consistency(State(state): State<AppState>, nav: PageNav) -> Response {
+    let report = state.aggregator.get_consistency().await.unwrap_or_default();
+
+    let mut categories: Vec<(String, Vec<CheckItemView>)> = Vec::new();
+
+    // Sort categories by name for stable ordering
+    let mut sorted_checks: BTreeMap<String, Vec<k8s::CheckItem>> = BTreeMap::new();
+    for (k, v) in &report.checks {
+        sorted_checks.insert(k.clone(), v.clone());
+    }
+
+    for (cat_name, checks) in &sorted_checks {
+        let items: Vec<CheckItemView> = checks
+            .iter()
+            .map(|c| {
+                let status_class = match c.status.as_str() {
+                    "pass" => "badge-success",
+                    "fail" => "badge-error",
+                    "warn" => "badge-warning",
+                    _ => "badge-info",
+                }
+                .to_string();
+                CheckItemView {
+                    name: c.name.clone(),
+                    status: c.status.clone(),
+                    status_class,
+                    message: c.message.clone(),
+                    details: c.details.clone(),
+                }
+            })
+            .collect();
+        categories.push((cat_name.clone(), items));
+    }
+
+    let tmpl = ConsistencyTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        pass_count: report.summary.pass,
+        fail_count: report.summary.fail,
+        warn_count: report.summary.warn,
+        timestamp: parse_age(&Some(report.timestamp)),
+        categories,
+    };
+    render_template(&tmpl)
+}
+
+// --- Events ---
+
+#[derive(Template)]
+#[template(path = "events.html")]
+struct EventsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    events: Vec<EventView>,
+}
+
+fn build_event_view(e: &k8s::Event) -> EventView {
+    let type_class = match e.type_field.as_str() {
+        "Normal" => "badge-success",
+        "Warning" => "badge-warning",
+        _ => "badge-info",
+    }
+    .to_string();
+
+    let involved = if e.involved_object.namespace.is_empty() {
+        format!("{}/{}", e.involved_object.kind, e.involved_object.name)
+    } else {
+        format!(
+            "{}/{}/{}",
+            e.involved_object.kind, e.involved_object.namespace, e.involved_object.name
+        )
+    };
+
+    EventView {
+        namespace: e.metadata.namespace.clone(),
+        name: e.metadata.name.clone(),
+        reason: e.reason.clone(),
+        message: e.message.clone(),
+        type_field: e.type_field.clone(),
+        type_class,
+        involved_object: involved,
+        count: e.count,
+        age: parse_age(&e.last_timestamp),
+    }
+}
+
+pub async fn handle_events(State(state): State<AppState>, nav: PageNav) -> Response {
+    let items = state.aggregator.list_events().await.unwrap_or_default();
+
+    let mut events: Vec<EventView> = items.iter().map(build_event_view).collect();
 
     // Show most recent first
     events.reverse();
 
-    let tmpl = EventsTemplate {
-        title: "Events".to_string(),
-        current_nav: "events".to_string(),
-        breadcrumbs: vec![
-            Breadcrumb { label: "Dashboard".to_string(), url: "/ui/".to_string() },
-            Breadcrumb { label: "Events".to_string(), url: "/ui/events".to_string() },
-        ],
-        events,
+    let tmpl = EventsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        events,
+    };
+    render_template(&tmpl)
+}
+
+// --- Activity ---
+
+const DASHBOARD_ACTIVITY: usize = 10;
+const ACTIVITY_PAGE_SIZE: usize = 50;
+
+fn build_activity_view(e: &ActivityEntry) -> ActivityView {
+    let action_class = match e.action.as_str() {
+        "create" | "node-up" => "badge-success",
+        "delete" | "node-down" => "badge-error",
+        "scale" => "badge-info",
+        _ => "badge-warning",
+    }
+    .to_string();
+    let (label, url) = resource_link(&e.kind, &e.namespace, &e.name);
+
+    ActivityView {
+        action: e.action.clone(),
+        action_class,
+        kind: e.kind.clone(),
+        label,
+        url: if e.action == "delete" { String::new() } else { url },
+        user: e.user.clone(),
+        message: e.message.clone(),
+        when: human_time(Some(e.at)),
+    }
+}
+
+#[derive(Deserialize)]
+pub struct ActivityPageQuery {
+    #[serde(default = "default_page")]
+    pub page: usize,
+}
+
+fn default_page() -> usize {
+    1
+}
+
+#[derive(Template)]
+#[template(path = "activity.html")]
+struct ActivityTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    entries: Vec<ActivityView>,
+    total: usize,
+    page: usize,
+    pages: usize,
+}
+
+pub async fn handle_activity(
+    State(state): State<AppState>,
+    Query(query): Query<ActivityPageQuery>,
+    nav: PageNav,
+) -> Response {
+    let page = query.page.max(1);
+    let (entries, total) = state
+        .activity
+        .page((page - 1) * ACTIVITY_PAGE_SIZE, ACTIVITY_PAGE_SIZE);
+
+    let tmpl = ActivityTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        entries: entries.iter().map(build_activity_view).collect(),
+        total,
+        page,
+        pages: total.div_ceil(ACTIVITY_PAGE_SIZE).max(1),
+    };
+    render_template(&tmpl)
+}
+
+// --- Alerts ---
+
+#[derive(Template)]
+#[template(path = "alerts.html")]
+struct AlertsTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    firing: Vec<AlertView>,
+    resolved: Vec<AlertView>,
+    captures: Vec<ArchiveEntryView>,
+    push_enabled: bool,
+}
+
+pub async fn handle_alerts(State(state): State<AppState>, nav: PageNav) -> Response {
+    let firing = state.alerts.firing().iter().map(build_alert_view).collect();
+    let resolved = state
+        .alerts
+        .recently_resolved()
+        .iter()
+        .take(20)
+        .map(build_alert_view)
+        .collect();
+    let captures = state.archive.list().iter().map(build_archive_view).collect();
+
+    let tmpl = AlertsTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        firing,
+        resolved,
+        captures,
+        push_enabled: state.push.is_some(),
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Deserialize, Default)]
+pub struct SandboxForm {
+    /// "rule" or "admission".
+    #[serde(default)]
+    pub kind: String,
+    #[serde(default)]
+    pub expr: String,
+    #[serde(default)]
+    pub for_secs: String,
+    /// resource_defaults and env_injection sections, as in the config.
+    #[serde(default)]
+    pub policy: String,
+}
+
+#[derive(Template)]
+#[template(path = "rule_sandbox.html")]
+struct RuleSandboxTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    kind: String,
+    expr: String,
+    for_secs: String,
+    policy: String,
+    metrics: Vec<(String, String)>,
+    tested: bool,
+    matches: Vec<SandboxMatchView>,
+    error: String,
+}
+
+/// Dry runs of an alert rule or admission policy against the live cluster,
+/// before it goes into the config.
+pub async fn handle_rule_sandbox(
+    State(state): State<AppState>,
+    Query(form): Query<SandboxForm>,
+    nav: PageNav,
+) -> Response {
+    let mut matches = Vec::new();
+    let mut error = String::new();
+    let mut tested = false;
+
+    if form.kind == "rule" && !form.expr.trim().is_empty() {
+        let rule = AlertRuleConfig {
+            name: "sandbox".to_string(),
+            expr: form.expr.trim().to_string(),
+            for_secs: form.for_secs.trim().parse().unwrap_or_default(),
+            severity: "warning".to_string(),
+            summary: None,
+            labels: Default::default(),
+        };
+        match alert_rules::compile(std::slice::from_ref(&rule)) {
+            Ok(rules) => {
+                let snapshot = state.alert_rules.snapshot().await;
+                matches = alert_rules::dry_run(&rules[0], &snapshot)
+                    .into_iter()
+                    .map(|m| SandboxMatchView {
+                        subject: m.subject,
+                        detail: format!("value {}", m.value),
+                    })
+                    .collect();
+                tested = true;
+            }
+            Err(e) => error = e,
+        }
+    } else if form.kind == "admission" && !form.policy.trim().is_empty() {
+        match serde_yaml::from_str::<AdmissionTestRequest>(&form.policy) {
+            Ok(req) => {
+                let pods = match req.pods {
+                    Some(pods) => pods,
+                    None => state.aggregator.list_all_pods().await.unwrap_or_default(),
+                };
+                matches = admission::dry_run(&pods, &req.resource_defaults, &req.env_injection)
+                    .into_iter()
+                    .map(|(subject, changes)| SandboxMatchView {
+                        subject,
+                        detail: changes.join(", "),
+                    })
+                    .collect();
+                tested = true;
+            }
+            Err(e) => error = format!("parsing policy: {}", e),
+        }
+    }
+
+    let tmpl = RuleSandboxTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        kind: form.kind,
+        expr: form.expr,
+        for_secs: form.for_secs,
+        policy: form.policy,
+        metrics: alert_rules::METRICS
+            .iter()
+            .map(|(m, d)| (m.to_string(), d.to_string()))
+            .collect(),
+        tested,
+        matches,
+        error,
+    };
+    render_template(&tmpl)
+}
+
+#[derive(Template)]
+#[template(path = "archive_detail.html")]
+struct ArchiveDetailTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    entry: ArchiveEntryView,
+}
+
+pub async fn handle_archive_entry(
+    State(state): State<AppState>,
+    Path(id): Path<u64>,
+    nav: PageNav,
+) -> Response {
+    let entry = match state.archive.get(id) {
+        Some(e) => e,
+        None => return (StatusCode::NOT_FOUND, "Archive entry not found").into_response(),
+    };
+
+    let tmpl = ArchiveDetailTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        entry: build_archive_view(&entry),
+    };
+    render_template(&tmpl)
+}
+
+// --- Kiosk ---
+
+#[derive(Deserialize)]
+pub struct KioskQuery {
+    /// Seconds per panel.
+    #[serde(default)]
+    pub rotate: Option<u64>,
+    /// Comma-separated panel names, in display order.
+    #[serde(default)]
+    pub panels: Option<String>,
+}
+
+/// Shortest rotation accepted, so a typo can't make the display flicker.
+const KIOSK_MIN_ROTATE_SECS: u64 = 5;
+
+#[derive(Template)]
+#[template(path = "kiosk.html")]
+struct KioskTemplate {
+    title: String,
+    cluster: String,
+    rotate_secs: u64,
+    /// Panel names in display order.
+    panels: Vec<String>,
+    stats_html: String,
+    firing: Vec<AlertView>,
+    nodes_html: String,
+}
+
+#[derive(Template)]
+#[template(path = "kiosk_nodes.html")]
+struct KioskNodesTemplate {
+    nodes: Vec<KioskNodeView>,
+}
+
+/// Full-screen wall display without navigation. The summary and node
+/// panels come from FragmentCache, so extra displays cost no node calls.
+pub async fn handle_kiosk(
+    State(state): State<AppState>,
+    Query(q): Query<KioskQuery>,
+    nav: PageNav,
+) -> Response {
+    let cfg = &state.config.kiosk;
+    let panels = match q.panels.as_deref().filter(|p| !p.trim().is_empty()) {
+        Some(list) => {
+            match list
+                .split(',')
+                .map(|p| p.trim().parse::<KioskPanel>())
+                .collect::<Result<Vec<_>, _>>()
+            {
+                Ok(p) => p,
+                Err(e) => return (StatusCode::BAD_REQUEST, e).into_response(),
+            }
+        }
+        None => cfg.panels.clone(),
+    };
+    let rotate_secs = q.rotate.unwrap_or(cfg.rotate_secs).max(KIOSK_MIN_ROTATE_SECS);
+
+    let mut stats_html = Ok(String::new());
+    let mut nodes_html = Ok(String::new());
+    let mut firing = Vec::new();
+    for p in &panels {
+        match p {
+            KioskPanel::Summary => {
+                stats_html = state
+                    .fragments
+                    .get_or_render("dashboard/stats", || render_dashboard_stats(&state))
+                    .await;
+            }
+            KioskPanel::Alerts => firing = state.alerts.firing().iter().map(build_alert_view).collect(),
+            KioskPanel::Nodes => {
+                nodes_html = state
+                    .fragments
+                    .get_or_render("kiosk/nodes", || render_kiosk_nodes(&state))
+                    .await;
+            }
+        }
+    }
+    let (Ok(stats_html), Ok(nodes_html)) = (stats_html, nodes_html) else {
+        return (StatusCode::INTERNAL_SERVER_ERROR, "Internal Server Error").into_response();
+    };
+
+    let tmpl = KioskTemplate {
+        title: nav.title,
+        cluster: state.config.cluster_name.clone(),
+        rotate_secs,
+        panels: panels
+            .iter()
+            .map(|p| match p {
+                KioskPanel::Summary => "summary",
+                KioskPanel::Alerts => "alerts",
+                KioskPanel::Nodes => "nodes",
+            })
+            .map(String::from)
+            .collect(),
+        stats_html,
+        firing,
+        nodes_html,
+    };
+    render_template(&tmpl)
+}
+
+async fn render_kiosk_nodes(state: &AppState) -> Result<String, askama::Error> {
+    render_fragment(&KioskNodesTemplate {
+        nodes: kiosk_node_views(state).await,
+    })
+}
+
+// A tile per node, coloured by health and CPU load.
+async fn kiosk_node_views(state: &AppState) -> Vec<KioskNodeView> {
+    let summary = state.aggregator.get_cluster_summary().await;
+    let loads: HashMap<String, f64> = state
+        .aggregator
+        .list_all_nodes()
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|n| {
+            let load = n.metadata.annotations.as_ref()?.get("mkube.io/cpu-load")?;
+            let load = load.trim().trim_end_matches('%').parse::<f64>().ok()?;
+            Some((n.metadata.name.clone(), load))
+        })
+        .collect();
+
+    summary
+        .nodes
+        .iter()
+        .map(|n| {
+            let load = loads.get(&n.name).copied();
+            let heat_class = match load {
+                _ if !n.healthy => "heat-down",
+                Some(l) if l >= 90.0 => "heat-hot",
+                Some(l) if l >= 70.0 => "heat-warm",
+                _ => "heat-ok",
+            };
+            KioskNodeView {
+                name: n.name.clone(),
+                healthy: n.healthy,
+                pod_count: n.pod_count,
+                load: load.map(|l| format!("{:.0}", l)).unwrap_or_default(),
+                heat_class: heat_class.to_string(),
+            }
+        })
+        .collect()
+}
+
+// --- Share links ---
+
+/// Log lines a shared pod log shows.
+const SHARED_LOG_LINES: i64 = 500;
+
+#[derive(Deserialize, Default)]
+pub struct ShareForm {
+    /// "status", "node" or "logs".
+    #[serde(default)]
+    pub view: String,
+    /// Node name, or "namespace/pod" for logs.
+    #[serde(default)]
+    pub target: String,
+    #[serde(default)]
+    pub hours: Option<i64>,
+    /// Widget links only: never expire.
+    #[serde(default)]
+    pub never: bool,
+}
+
+#[derive(Template)]
+#[template(path = "share.html")]
+struct ShareTemplate {
+    title: String,
+    current_nav: String,
+    breadcrumbs: Vec<Breadcrumb>,
+    view: String,
+    target: String,
+    hours: i64,
+    max_hours: i64,
+    never: bool,
+    /// The link just created, absolute.
+    link: String,
+    expires: String,
+    /// What to paste into a page or README, for widget links.
+    embed: String,
+    error: String,
+}
+
+fn share_page(nav: PageNav, state: &AppState, form: &ShareForm) -> ShareTemplate {
+    let max_hours = state.share_links.max_secs() / 3600;
+    ShareTemplate {
+        title: nav.title,
+        current_nav: nav.current_nav,
+        breadcrumbs: nav.breadcrumbs,
+        view: if form.view.is_empty() { "status".to_string() } else { form.view.clone() },
+        target: form.target.clone(),
+        hours: form.hours.unwrap_or(24).clamp(1, max_hours),
+        max_hours,
+        never: form.never,
+        link: String::new(),
+        expires: String::new(),
+        embed: String::new(),
+        error: String::new(),
+    }
+}
+
+/// Share link form, for views and embeddable widgets. ?view= and ?target=
+/// prefill it, so the dashboard, node and log pages can link here.
+pub async fn handle_share_page(
+    State(state): State<AppState>,
+    Query(form): Query<ShareForm>,
+    nav: PageNav,
+) -> Response {
+    render_template(&share_page(nav, &state, &form))
+}
+
+pub async fn handle_create_share_link(
+    State(state): State<AppState>,
+    headers: HeaderMap,
+    nav: PageNav,
+    Form(form): Form<ShareForm>,
+) -> Response {
+    let mut tmpl = share_page(nav, &state, &form);
+    let target = match ShareTarget::parse(&form.view, &form.target) {
+        Ok(t) => t,
+        Err(e) => {
+            tmpl.error = e;
+            let mut resp = render_template(&tmpl);
+            *resp.status_mut() = StatusCode::BAD_REQUEST;
+            return resp;
+        }
+    };
+    let ttl = if form.never && target.is_widget() { None } else { Some(tmpl.hours * 3600) };
+    let (path, expires) = state.share_links.sign(&target, ttl);
+    let (kind, namespace, name) = match target {
+        ShareTarget::Status => ("dashboard", "", "status"),
+        ShareTarget::HealthWidget => ("widget", "", "health"),
+        ShareTarget::NodeStrip => ("widget", "", "nodes"),
+        ShareTarget::Badge => ("widget", "", "badge"),
+        ShareTarget::Node(ref n) => ("node", "", n.as_str()),
+        ShareTarget::PodLogs {
+            ref namespace,
+            ref name,
+        } => ("pod", namespace.as_str(), name.as_str()),
+    };
+    let until = match expires {
+        Some(t) => format!("until {}", t.to_rfc3339()),
+        None => "without expiry".to_string(),
+    };
+    let message = format!("share link to {} {}", target.describe(), until);
+    state
+        .activity
+        .record("share", kind, namespace, name, &request_user(&headers), &message);
+
+    let link = format!("{}{}", share_base_url(&state, &headers), path);
+    tmpl.embed = match target {
+        ShareTarget::HealthWidget => format!(
+            r#"<iframe src="{}" width="320" height="96" style="border:0"></iframe>"#,
+            link
+        ),
+        ShareTarget::NodeStrip => format!(
+            r#"<iframe src="{}" width="100%" height="72" style="border:0"></iframe>"#,
+            link
+        ),
+        ShareTarget::Badge => format!(
+            "![cluster](https://img.shields.io/endpoint?url={})",
+            url_encode(&link)
+        ),
+        _ => String::new(),
+    };
+    tmpl.link = link;
+    tmpl.expires = match expires {
+        Some(t) => t.format("%Y-%m-%d %H:%M UTC").to_string(),
+        None => String::new(),
+    };
+    render_template(&tmpl)
+}
+
+// Where links start: the configured base URL, or the address this request
+// came in on.
+fn share_base_url(state: &AppState, headers: &HeaderMap) -> String {
+    if let Some(ref base) = state.config.share_links.base_url {
+        return base.trim_end_matches('/').to_string();
+    }
+    let header = |name: &str| headers.get(name).and_then(|v| v.to_str().ok());
+    let host = header("x-forwarded-host").or(header("host")).unwrap_or("localhost");
+    let scheme = header("x-forwarded-proto").unwrap_or("http");
+    format!("{}://{}", scheme, host)
+}
+
+#[derive(Deserialize)]
+pub struct SharedViewQuery {
+    #[serde(default)]
+    pub expires: i64,
+    #[serde(default)]
+    pub sig: String,
+}
+
+#[derive(Template, Default)]
+#[template(path = "shared.html")]
+struct SharedViewTemplate {
+    title: String,
+    cluster: String,
+    /// "status", "node" or "logs".
+    view: String,
+    expires_in: String,
+    stats_html: String,
+    firing: Vec<AlertView>,
+    node: NodeView,
+    pods: Vec<PodView>,
+    log: String,
+}
+
+fn check_share_link(state: &AppState, target: &ShareTarget, q: &SharedViewQuery) -> Result<(), Response> {
+    state
+        .share_links
+        .verify(target, q.expires, &q.sig)
+        .map_err(|e| (StatusCode::FORBIDDEN, e).into_response())
+}
+
+// The page for a share link, once its signature and expiry check out.
+fn shared_view(
+    state: &AppState,
+    target: &ShareTarget,
+    q: &SharedViewQuery,
+    view: &str,
+    title: String,
+) -> Result<SharedViewTemplate, Response> {
+    check_share_link(state, target, q)?;
+    Ok(SharedViewTemplate {
+        title,
+        cluster: state.config.cluster_name.clone(),
+        view: view.to_string(),
+        expires_in: human_duration_secs(q.expires - chrono::Utc::now().timestamp()),
+        ..Default::default()
+    })
+}
+
+/// Cluster summary and firing alerts for a share link.
+pub async fn handle_shared_status(State(state): State<AppState>, Query(q): Query<SharedViewQuery>) -> Response {
+    let mut tmpl = match shared_view(&state, &ShareTarget::Status, &q, "status", "Cluster Status".to_string()) {
+        Ok(t) => t,
+        Err(resp) => return resp,
+    };
+    let Ok(stats_html) = state
+        .fragments
+        .get_or_render("dashboard/stats", || render_dashboard_stats(&state))
+        .await
+    else {
+        return (StatusCode::INTERNAL_SERVER_ERROR, "Internal Server Error").into_response();
+    };
+    tmpl.stats_html = stats_html;
+    tmpl.firing = state.alerts.firing().iter().map(build_alert_view).collect();
+    render_template(&tmpl)
+}
+
+/// A node's status and pods for a share link.
+pub async fn handle_shared_node(
+    State(state): State<AppState>,
+    Path(name): Path<String>,
+    Query(q): Query<SharedViewQuery>,
+) -> Response {
+    let target = ShareTarget::Node(name.clone());
+    let mut tmpl = match shared_view(&state, &target, &q, "node", format!("Node: {}", name)) {
+        Ok(t) => t,
+        Err(resp) => return resp,
+    };
+    let online = state
+        .aggregator
+        .snapshot_clients()
+        .await
+        .iter()
+        .any(|c| c.name == name && c.is_healthy());
+    tmpl.node = match state.aggregator.get_node(&name).await {
+        Ok(n) if online => build_node_view(&n),
+        Ok(n) => unreachable(build_node_view(&n)),
+        Err(_) => return (StatusCode::NOT_FOUND, "Node not found").into_response(),
+    };
+    tmpl.pods = state
+        .aggregator
+        .list_all_pods()
+        .await
+        .unwrap_or_default()
+        .iter()
+        .filter(|p| {
+            p.metadata
+                .annotations
+                .as_ref()
+                .and_then(|a| a.get("mkube.io/node"))
+                .is_some_and(|n| *n == name)
+        })
+        .map(build_pod_view)
+        .collect();
+    render_template(&tmpl)
+}
+
+/// The tail of a pod's log for a share link.
+pub async fn handle_shared_logs(
+    State(state): State<AppState>,
+    Path((namespace, name)): Path<(String, String)>,
+    Query(q): Query<SharedViewQuery>,
+) -> Response {
+    let target = ShareTarget::PodLogs {
+        namespace: namespace.clone(),
+        name: name.clone(),
+    };
+    let title = format!("Logs: {}/{}", namespace, name);
+    let mut tmpl = match shared_view(&state, &target, &q, "logs", title) {
+        Ok(t) => t,
+        Err(resp) => return resp,
+    };
+    let opts = LogOptions {
+        tail_lines: Some(SHARED_LOG_LINES),
+        ..Default::default()
+    };
+    tmpl.log = match state.aggregator.get_pod_log(&namespace, &name, &opts).await {
+        Ok(log) => log,
+        Err(e) => return (StatusCode::NOT_FOUND, e.to_string()).into_response(),
     };
     render_template(&tmpl)
 }
 
+// --- Widgets ---
+
+/// Cluster health as the widgets and badge show it: down without a healthy
+/// node, degraded with any node down or a critical alert firing.
+struct ClusterHealth {
+    status: &'static str,
+    badge_class: &'static str,
+    /// shields.io colour name.
+    color: &'static str,
+    summary: ClusterSummary,
+    critical: usize,
+}
+
+async fn cluster_health(state: &AppState) -> ClusterHealth {
+    let summary = state.aggregator.get_cluster_summary().await;
+    let critical = state
+        .alerts
+        .firing()
+        .iter()
+        .filter(|a| a.severity == "critical")
+        .count();
+    let (status, badge_class, color) = if summary.healthy_nodes == 0 {
+        ("down", "badge-error", "red")
+    } else if summary.healthy_nodes < summary.node_count || critical > 0 {
+        ("degraded", "badge-warning", "orange")
+    } else {
+        ("healthy", "badge-success", "brightgreen")
+    };
+    ClusterHealth {
+        status,
+        badge_class,
+        color,
+        summary,
+        critical,
+    }
+}
+
+#[derive(Template)]
+#[template(path = "widget_health.html")]
+struct HealthWidgetTemplate {
+    cluster: String,
+    status: String,
+    badge_class: String,
+    healthy_nodes: usize,
+    node_count: usize,
+    running_pods: usize,
+    pod_count: usize,
+    critical: usize,
+}
+
+/// Cluster health badge for an iframe on an intranet page.
+pub async fn handle_health_widget(State(state): State<AppState>, Query(q): Query<SharedViewQuery>) -> Response {
+    if let Err(resp) = check_share_link(&state, &ShareTarget::HealthWidget, &q) {
+        return resp;
+    }
+    let health = cluster_health(&state).await;
+    render_template(&HealthWidgetTemplate {
+        cluster: state.config.cluster_name.clone(),
+        status: health.status.to_string(),
+        badge_class: health.badge_class.to_string(),
+        healthy_nodes: health.summary.healthy_nodes,
+        node_count: health.summary.node_count,
+        running_pods: health.summary.running_pods,
+        pod_count: health.summary.pod_count,
+        critical: health.critical,
+    })
+}
+
+#[derive(Template)]
+#[template(path = "widget_nodes.html")]
+struct NodeStripTemplate {
+    cluster: String,
+    nodes: Vec<KioskNodeView>,
+}
+
+/// A square per node, coloured like the kiosk heatmap, for an iframe.
+pub async fn handle_node_strip_widget(
+    State(state): State<AppState>,
+    Query(q): Query<SharedViewQuery>,
+) -> Response {
+    if let Err(resp) = check_share_link(&state, &ShareTarget::NodeStrip, &q) {
+        return resp;
+    }
+    render_template(&NodeStripTemplate {
+        cluster: state.config.cluster_name.clone(),
+        nodes: kiosk_node_views(&state).await,
+    })
+}
+
+/// Cluster health in the shields.io endpoint badge format, for READMEs:
+/// https://img.shields.io/endpoint?url=<this link>. Any origin may fetch
+/// it, so intranet pages can render it themselves.
+pub async fn handle_badge(State(state): State<AppState>, Query(q): Query<SharedViewQuery>) -> Response {
+    if let Err(resp) = check_share_link(&state, &ShareTarget::Badge, &q) {
+        return resp;
+    }
+    let health = cluster_health(&state).await;
+    let message = format!(
+        "{} ({}/{} nodes)",
+        health.status, health.summary.healthy_nodes, health.summary.node_count
+    );
+    let badge = serde_json::json!({
+        "schemaVersion": 1,
+        "label": state.config.cluster_name,
+        "message": message,
+        "color": health.color,
+        "cacheSeconds": 60,
+    });
+    (
+        [(header::ACCESS_CONTROL_ALLOW_ORIGIN, "*"), (header::CACHE_CONTROL, "max-age=60")],
+        axum::Json(badge),
+    )
+        .into_response()
+}
+
+fn build_alert_view(a: &Alert) -> AlertView {
+    let severity_class = match a.severity.as_str() {
+        "critical" => "badge-error",
+        "warning" => "badge-warning",
+        _ => "badge-info",
+    }
+    .to_string();
+
+    AlertView {
+        key: a.key.clone(),
+        severity: a.severity.clone(),
+        severity_class,
+        summary: a.summary.clone(),
+        description: a.description.clone(),
+        started: a.started_at.to_rfc3339(),
+        resolved: a.resolved_at.map(|t| t.to_rfc3339()).unwrap_or_default(),
+        duration_secs: (a.resolved_at.unwrap_or_else(chrono::Utc::now) - a.started_at).num_seconds(),
+    }
+}
+
+fn build_archive_view(e: &ArchiveEntry) -> ArchiveEntryView {
+    let mut v = ArchiveEntryView {
+        id: e.id,
+        kind: e.kind.clone(),
+        namespace: e.namespace.clone(),
+        pod: e.pod.clone(),
+        container: e.container.clone(),
+        node: e.node.clone(),
+        restart_count: e.restart_count,
+        captured: human_time(Some(e.captured_at)),
+        logs: e.logs.clone(),
+        ..Default::default()
+    };
+    if let Some(ref t) = e.termination {
+        v.reason = t.reason.clone();
+        v.exit_code = t.exit_code.to_string();
+        v.message = t.message.clone();
+        v.finished_at = parse_age(&t.finished_at);
+    }
+    v
+}
+
+// Collapse consecutive health samples into up/down segments sized by the
+// share of samples they cover.
+fn build_health_timeline(history: &[HealthSample]) -> Vec<HealthSegmentView> {
+    let mut segments = Vec::new();
+    let total = history.len();
+    let mut start = 0;
+    while start < total {
+        let healthy = history[start].healthy;
+        let mut end = start;
+        while end + 1 < total && history[end + 1].healthy == healthy {
+            end += 1;
+        }
+        let n = end - start + 1;
+        segments.push(HealthSegmentView {
+            class: if healthy { "up" } else { "down" }.to_string(),
+            width: format!("{:.2}", n as f64 * 100.0 / total as f64),
+            title: format!(
+                "{} from {} to {}",
+                if healthy { "Healthy" } else { "Unreachable" },
+                history[start].at.format("%H:%M:%S"),
+                history[end].at.format("%H:%M:%S"),
+            ),
+        });
+        start = end + 1;
+    }
+    segments
+}
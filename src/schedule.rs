@@ -0,0 +1,102 @@
+use chrono::{DateTime, Utc};
+use ring::rand::{SecureRandom, SystemRandom};
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::sync::Mutex;
+use std::time::Duration;
+
+use crate::config::ScheduleConfig;
+
+/// Timing for background work that fans out to every node: jittered
+/// intervals and staggered per-node requests, so health checks, pod polls
+/// and page refreshes don't all reach the nodes at once. Loops report their
+/// runs here for the admin page.
+pub struct Schedule {
+    config: ScheduleConfig,
+    tasks: Mutex<BTreeMap<&'static str, TaskStatus>>,
+}
+
+/// A background loop as the admin page shows it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct TaskStatus {
+    pub name: &'static str,
+    pub description: &'static str,
+    pub interval_secs: u64,
+    /// Whether its per-node requests are spread over config.spread_secs.
+    pub staggered: bool,
+    pub runs: u64,
+    pub last_started: Option<DateTime<Utc>>,
+    pub last_duration_ms: Option<u64>,
+    pub next_run: Option<DateTime<Utc>>,
+}
+
+impl Schedule {
+    pub fn new(config: ScheduleConfig) -> Self {
+        Self {
+            config,
+            tasks: Mutex::new(BTreeMap::new()),
+        }
+    }
+
+    pub fn config(&self) -> &ScheduleConfig {
+        &self.config
+    }
+
+    pub fn health_interval(&self) -> Duration {
+        Duration::from_secs(self.config.health_interval_secs)
+    }
+
+    /// Lists a loop on the admin page before its first run.
+    pub fn register(&self, name: &'static str, description: &'static str, interval: Duration, staggered: bool) {
+        self.tasks.lock().unwrap().entry(name).or_insert(TaskStatus {
+            name,
+            description,
+            interval_secs: interval.as_secs(),
+            staggered,
+            runs: 0,
+            last_started: None,
+            last_duration_ms: None,
+            next_run: None,
+        });
+    }
+
+    /// `base` varied at random by up to config.jitter_percent either way.
+    pub fn jittered(&self, base: Duration) -> Duration {
+        let jitter = self.config.jitter_percent as f64 / 100.0;
+        base.mul_f64(1.0 + jitter * (2.0 * random_fraction() - 1.0))
+    }
+
+    /// Start delays for `n` per-node requests: one in each equal slice of
+    /// config.spread_secs, at a random point within it.
+    pub fn offsets(&self, n: usize) -> Vec<Duration> {
+        let spread = Duration::from_secs(self.config.spread_secs);
+        (0..n)
+            .map(|i| spread.mul_f64((i as f64 + random_fraction()) / n as f64))
+            .collect()
+    }
+
+    /// Records a finished run of a registered loop and when it runs next.
+    pub fn ran(&self, name: &'static str, started: DateTime<Utc>, next_in: Duration) {
+        let mut tasks = self.tasks.lock().unwrap();
+        let Some(task) = tasks.get_mut(name) else {
+            return;
+        };
+        let now = Utc::now();
+        task.runs += 1;
+        task.last_started = Some(started);
+        task.last_duration_ms = Some((now - started).num_milliseconds().max(0) as u64);
+        task.next_run = chrono::Duration::from_std(next_in).ok().map(|d| now + d);
+    }
+
+    pub fn tasks(&self) -> Vec<TaskStatus> {
+        self.tasks.lock().unwrap().values().cloned().collect()
+    }
+}
+
+// Uniform in [0, 1).
+fn random_fraction() -> f64 {
+    let mut b = [0u8; 8];
+    let _ = SystemRandom::new().fill(&mut b);
+    (u64::from_le_bytes(b) >> 11) as f64 / (1u64 << 53) as f64
+}
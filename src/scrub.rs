@@ -0,0 +1,47 @@
+use serde_json::Value;
+use std::collections::HashMap;
+
+use crate::config::ApiConfig;
+
+// Response scrubbing: managedFields and annotations nobody reads
+// (kubectl's last-applied-configuration and the like) make up much of an
+// object's size and bury what matters in the UI. config.api decides what
+// goes, and a request's ?scrub= overrides whether it goes.
+
+/// The annotation patterns to remove for a request: the configured ones,
+/// or none when scrubbing is off by config or by the request's `param`.
+pub fn patterns(cfg: &ApiConfig, param: Option<bool>) -> Option<&[String]> {
+    param.unwrap_or(cfg.scrub).then_some(cfg.scrub_annotations.as_slice())
+}
+
+/// Whether an annotation key matches a pattern; a trailing * matches a
+/// prefix.
+pub fn is_noisy(patterns: &[String], key: &str) -> bool {
+    patterns.iter().any(|p| match p.strip_suffix('*') {
+        Some(prefix) => key.starts_with(prefix),
+        None => key == p,
+    })
+}
+
+/// Removes noisy annotations; returns how many went.
+pub fn scrub_annotations(annotations: &mut HashMap<String, String>, patterns: &[String]) -> usize {
+    let before = annotations.len();
+    annotations.retain(|k, _| !is_noisy(patterns, k));
+    before - annotations.len()
+}
+
+/// Removes metadata.managedFields and noisy annotations from an API
+/// object as JSON, whatever its kind.
+pub fn scrub_value(value: &mut Value, patterns: &[String]) {
+    let Some(meta) = value.get_mut("metadata").and_then(Value::as_object_mut) else {
+        return;
+    };
+    meta.remove("managedFields");
+    let Some(annotations) = meta.get_mut("annotations").and_then(Value::as_object_mut) else {
+        return;
+    };
+    annotations.retain(|k, _| !is_noisy(patterns, k));
+    if annotations.is_empty() {
+        meta.remove("annotations");
+    }
+}
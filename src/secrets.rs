@@ -0,0 +1,229 @@
+use reqwest::Client;
+use std::collections::HashMap;
+use std::time::Duration;
+
+use crate::config::SecretProvidersConfig;
+use crate::models::k8s::Pod;
+
+/// Scheme of a secret reference: secret://<provider>/<path>[#<key>].
+pub const REF_SCHEME: &str = "secret://";
+
+/// Annotation recording which env vars were resolved from which reference,
+/// e.g. "app: DB_PASSWORD=secret://vault/apps/db#password". Never values.
+pub const RESOLVED_ANNOTATION: &str = "console.mkube.io/resolved-secrets";
+
+/// A parsed secret reference.
+#[derive(Debug, Clone, PartialEq)]
+pub struct SecretRef {
+    pub provider: String,
+    pub path: String,
+    pub key: String,
+}
+
+impl SecretRef {
+    pub fn parse(s: &str) -> Option<Self> {
+        let rest = s.trim().strip_prefix(REF_SCHEME)?;
+        let (provider, rest) = rest.split_once('/')?;
+        let (path, key) = rest.split_once('#').unwrap_or((rest, ""));
+        if provider.is_empty() || path.is_empty() {
+            return None;
+        }
+        Some(Self {
+            provider: provider.to_string(),
+            path: path.to_string(),
+            key: key.to_string(),
+        })
+    }
+}
+
+/// Resolves secret references against the configured providers: Vault
+/// (secret://vault/<path>#<key>), SOPS files (secret://sops/<file>#<a.b.c>)
+/// and prefixed environment variables (secret://env/<NAME>).
+pub struct SecretResolver {
+    cfg: SecretProvidersConfig,
+    http: Client,
+}
+
+impl SecretResolver {
+    pub fn new(cfg: SecretProvidersConfig) -> Self {
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        Self { cfg, http }
+    }
+
+    /// Names of the configured providers.
+    pub fn providers(&self) -> Vec<&'static str> {
+        let mut out = Vec::new();
+        if self.cfg.vault.is_some() {
+            out.push("vault");
+        }
+        if self.cfg.sops.is_some() {
+            out.push("sops");
+        }
+        if self.cfg.env.is_some() {
+            out.push("env");
+        }
+        out
+    }
+
+    pub async fn resolve(&self, r: &SecretRef) -> Result<String, String> {
+        match r.provider.as_str() {
+            "vault" => self.resolve_vault(r).await,
+            "sops" => self.resolve_sops(r).await,
+            "env" => self.resolve_env(r),
+            other => Err(format!("unknown secret provider {:?}", other)),
+        }
+    }
+
+    /// Replaces every env value in the pod that is a secret reference with
+    /// the secret it names. All references are attempted; any failures are
+    /// returned together and the pod is left unusable. On success the
+    /// resolved references (not values) are recorded as an annotation.
+    pub async fn resolve_pod(&self, pod: &mut Pod) -> Result<Vec<String>, Vec<String>> {
+        let mut resolved = Vec::new();
+        let mut errors = Vec::new();
+        for c in &mut pod.spec.containers {
+            for e in &mut c.env {
+                let Some(r) = SecretRef::parse(&e.value) else {
+                    continue;
+                };
+                match self.resolve(&r).await {
+                    Ok(v) => {
+                        resolved.push(format!("{}: {}={}", c.name, e.name, e.value.trim()));
+                        e.value = v;
+                    }
+                    Err(err) => errors.push(format!("{} {}: {}", c.name, e.name, err)),
+                }
+            }
+        }
+        if !errors.is_empty() {
+            return Err(errors);
+        }
+        if !resolved.is_empty() {
+            pod.metadata
+                .annotations
+                .get_or_insert_with(HashMap::new)
+                .insert(RESOLVED_ANNOTATION.to_string(), resolved.join(", "));
+        }
+        Ok(resolved)
+    }
+
+    async fn resolve_vault(&self, r: &SecretRef) -> Result<String, String> {
+        let v = self.cfg.vault.as_ref().ok_or("vault provider is not configured")?;
+        let token = match v.token_file {
+            Some(ref f) => std::fs::read_to_string(f).map_err(|e| format!("reading {}: {}", f, e))?,
+            None => std::env::var(&v.token_env).map_err(|_| format!("{} is not set", v.token_env))?,
+        };
+        let url = format!(
+            "{}/v1/{}/data/{}",
+            v.address.trim_end_matches('/'),
+            v.mount.trim_matches('/'),
+            r.path.trim_matches('/')
+        );
+        let resp = self
+            .http
+            .get(&url)
+            .header("X-Vault-Token", token.trim())
+            .send()
+            .await
+            .map_err(|e| format!("vault: {}", e))?;
+        if !resp.status().is_success() {
+            return Err(format!("vault returned {} for {}", resp.status(), r.path));
+        }
+        let body: serde_json::Value = resp.json().await.map_err(|e| format!("vault: {}", e))?;
+        lookup(&body["data"]["data"], &r.key)
+            .ok_or_else(|| format!("vault secret {} has no key {:?}", r.path, r.key))
+    }
+
+    async fn resolve_sops(&self, r: &SecretRef) -> Result<String, String> {
+        let s = self.cfg.sops.as_ref().ok_or("sops provider is not configured")?;
+        let file = s
+            .files
+            .get(&r.path)
+            .ok_or_else(|| format!("no sops file named {:?}", r.path))?;
+        let out = tokio::process::Command::new(&s.binary)
+            .args(["--decrypt", "--output-type", "json", file])
+            .kill_on_drop(true)
+            .output()
+            .await
+            .map_err(|e| format!("running {}: {}", s.binary, e))?;
+        if !out.status.success() {
+            return Err(format!(
+                "sops could not decrypt {}: {}",
+                file,
+                String::from_utf8_lossy(&out.stderr).trim()
+            ));
+        }
+        let doc: serde_json::Value =
+            serde_json::from_slice(&out.stdout).map_err(|e| format!("sops output: {}", e))?;
+        lookup(&doc, &r.key).ok_or_else(|| format!("sops file {} has no key {:?}", r.path, r.key))
+    }
+
+    fn resolve_env(&self, r: &SecretRef) -> Result<String, String> {
+        let e = self.cfg.env.as_ref().ok_or("env provider is not configured")?;
+        let name = format!("{}{}", e.prefix, r.path);
+        std::env::var(&name).map_err(|_| format!("{} is not set", name))
+    }
+}
+
+/// Container/variable/reference triples from a pod's resolved annotation.
+pub fn resolved_refs(pod: &Pod) -> Vec<(String, String, String)> {
+    pod.metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(RESOLVED_ANNOTATION))
+        .map(|v| {
+            v.split(", ")
+                .filter_map(|e| {
+                    let (c, rest) = e.split_once(": ")?;
+                    let (name, r) = rest.split_once('=')?;
+                    Some((c.to_string(), name.to_string(), r.to_string()))
+                })
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Puts the references recorded in the resolved annotation back in place
+/// of the values, so the pod can be resolved afresh, e.g. after a secret
+/// rotates.
+pub fn unresolve(pod: &mut Pod) {
+    redact(pod);
+    if let Some(a) = pod.metadata.annotations.as_mut() {
+        a.remove(RESOLVED_ANNOTATION);
+    }
+}
+
+/// Shows resolved env vars as their references, keeping the annotation.
+/// Pods are readable by viewers, who mustn't see secret values, so every
+/// pod the API sends goes through this.
+pub fn redact(pod: &mut Pod) {
+    for (container, name, r) in resolved_refs(pod) {
+        let env = pod
+            .spec
+            .containers
+            .iter_mut()
+            .filter(|c| c.name == container)
+            .flat_map(|c| c.env.iter_mut())
+            .find(|e| e.name == name);
+        if let Some(e) = env {
+            e.value = r;
+        }
+    }
+}
+
+// Follows a dotted key path into a JSON document; strings are returned
+// as-is, other values as JSON.
+fn lookup(doc: &serde_json::Value, key: &str) -> Option<String> {
+    let mut v = doc;
+    for part in key.split('.').filter(|p| !p.is_empty()) {
+        v = v.get(part)?;
+    }
+    match v {
+        serde_json::Value::Null => None,
+        serde_json::Value::String(s) => Some(s.clone()),
+        other => Some(other.to_string()),
+    }
+}
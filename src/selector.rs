@@ -0,0 +1,119 @@
+use std::collections::HashMap;
+
+// K8s label selector parsing and matching (equality- and set-based forms).
+
+#[derive(Debug, Clone)]
+enum Requirement {
+    Equals(String, String),
+    NotEquals(String, String),
+    In(String, Vec<String>),
+    NotIn(String, Vec<String>),
+    Exists(String),
+    DoesNotExist(String),
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct LabelSelector {
+    requirements: Vec<Requirement>,
+}
+
+impl LabelSelector {
+    /// Parses selectors such as `app=web,tier!=cache`, `env in (prod,staging)`
+    /// and `!canary`. An empty string selects everything.
+    pub fn parse(s: &str) -> Result<Self, String> {
+        let mut requirements = Vec::new();
+        for term in split_terms(s)? {
+            let term = term.trim();
+            if term.is_empty() {
+                continue;
+            }
+            requirements.push(parse_requirement(term)?);
+        }
+        Ok(Self { requirements })
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.requirements.is_empty()
+    }
+
+    pub fn matches(&self, labels: Option<&HashMap<String, String>>) -> bool {
+        let get = |k: &str| labels.and_then(|l| l.get(k));
+        self.requirements.iter().all(|r| match r {
+            Requirement::Equals(k, v) => get(k) == Some(v),
+            Requirement::NotEquals(k, v) => get(k) != Some(v),
+            Requirement::In(k, vs) => get(k).map(|v| vs.contains(v)).unwrap_or(false),
+            Requirement::NotIn(k, vs) => get(k).map(|v| !vs.contains(v)).unwrap_or(true),
+            Requirement::Exists(k) => get(k).is_some(),
+            Requirement::DoesNotExist(k) => get(k).is_none(),
+        })
+    }
+}
+
+// Split on commas that are not inside a set-based value list.
+fn split_terms(s: &str) -> Result<Vec<&str>, String> {
+    let mut terms = Vec::new();
+    let mut depth = 0i32;
+    let mut start = 0;
+    for (i, ch) in s.char_indices() {
+        match ch {
+            '(' => depth += 1,
+            ')' => {
+                depth -= 1;
+                if depth < 0 {
+                    return Err(format!("unbalanced ')' in selector {:?}", s));
+                }
+            }
+            ',' if depth == 0 => {
+                terms.push(&s[start..i]);
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    if depth != 0 {
+        return Err(format!("unbalanced '(' in selector {:?}", s));
+    }
+    terms.push(&s[start..]);
+    Ok(terms)
+}
+
+fn parse_requirement(term: &str) -> Result<Requirement, String> {
+    if let Some(key) = term.strip_prefix('!') {
+        return Ok(Requirement::DoesNotExist(validate_key(key.trim())?));
+    }
+    if let Some((k, v)) = term.split_once("!=") {
+        return Ok(Requirement::NotEquals(validate_key(k.trim())?, v.trim().to_string()));
+    }
+    if let Some((k, v)) = term.split_once("==") {
+        return Ok(Requirement::Equals(validate_key(k.trim())?, v.trim().to_string()));
+    }
+    if let Some((k, v)) = term.split_once('=') {
+        return Ok(Requirement::Equals(validate_key(k.trim())?, v.trim().to_string()));
+    }
+    if let Some(open) = term.find('(') {
+        let head = term[..open].trim();
+        let values = term[open..]
+            .strip_prefix('(')
+            .and_then(|r| r.strip_suffix(')'))
+            .ok_or_else(|| format!("invalid set in selector term {:?}", term))?
+            .split(',')
+            .map(|v| v.trim().to_string())
+            .filter(|v| !v.is_empty())
+            .collect();
+        if let Some(k) = head.strip_suffix(" notin") {
+            return Ok(Requirement::NotIn(validate_key(k.trim())?, values));
+        }
+        if let Some(k) = head.strip_suffix(" in") {
+            return Ok(Requirement::In(validate_key(k.trim())?, values));
+        }
+        return Err(format!("unknown operator in selector term {:?}", term));
+    }
+    Ok(Requirement::Exists(validate_key(term)?))
+}
+
+fn validate_key(k: &str) -> Result<String, String> {
+    if k.is_empty() || k.contains(char::is_whitespace) {
+        return Err(format!("invalid label key {:?}", k));
+    }
+    Ok(k.to_string())
+}
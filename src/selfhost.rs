@@ -0,0 +1,121 @@
+use serde::Serialize;
+
+use crate::clients::aggregator::Aggregator;
+use crate::config::SelfPodConfig;
+use crate::leader::LeaderElector;
+use crate::stuck;
+
+/// Where the console runs, when it is a pod in the cluster it manages.
+/// Deleting, restarting or migrating that pod takes the console down with
+/// it, so those paths check here first.
+pub struct SelfHost {
+    pod: Option<SelfPodConfig>,
+}
+
+/// The console's own pod as the API shows it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct SelfStatus {
+    pub self_hosted: bool,
+    pub namespace: String,
+    pub name: String,
+    /// Empty when the pod couldn't be found.
+    pub node: String,
+    /// Whether a controller recreates the pod when it is deleted.
+    pub owned: bool,
+    pub identity: String,
+    pub leader: bool,
+}
+
+impl SelfHost {
+    /// From config, else from the POD_NAMESPACE and POD_NAME variables the
+    /// pod spec can set through the downward API.
+    pub fn new(cfg: Option<SelfPodConfig>) -> Self {
+        let pod = cfg.or_else(|| {
+            let namespace = std::env::var("POD_NAMESPACE").ok().filter(|v| !v.is_empty())?;
+            let name = std::env::var("POD_NAME").ok().filter(|v| !v.is_empty())?;
+            Some(SelfPodConfig { namespace, name })
+        });
+        Self { pod }
+    }
+
+    pub fn pod(&self) -> Option<&SelfPodConfig> {
+        self.pod.as_ref()
+    }
+
+    pub fn is_self(&self, namespace: &str, name: &str) -> bool {
+        self.pod
+            .as_ref()
+            .is_some_and(|p| p.namespace == namespace && p.name == name)
+    }
+
+    /// Why `namespace/name` can't be deleted without confirmation, if it
+    /// is the console's own pod.
+    pub fn guard(&self, namespace: &str, name: &str) -> Option<String> {
+        if !self.is_self(namespace, name) {
+            return None;
+        }
+        Some(format!(
+            "{}/{} is the console's own pod; deleting it takes the console down. \
+             Restart it with POST /api/console/v1alpha1/self/restart to hand off to a standby first, \
+             or pass confirmSelf=true",
+            namespace, name
+        ))
+    }
+
+    pub async fn status(&self, aggregator: &Aggregator, leader: &LeaderElector) -> SelfStatus {
+        let mut status = SelfStatus {
+            self_hosted: self.pod.is_some(),
+            namespace: String::new(),
+            name: String::new(),
+            node: String::new(),
+            owned: false,
+            identity: leader.identity(),
+            leader: leader.is_leader(),
+        };
+        let Some(ref p) = self.pod else {
+            return status;
+        };
+        status.namespace = p.namespace.clone();
+        status.name = p.name.clone();
+        if let Ok((pod, node)) = aggregator.get_pod(&p.namespace, &p.name).await {
+            status.owned = stuck::owner(&pod).is_some();
+            status.node = node;
+        }
+        status
+    }
+
+    /// Restarts the console's own pod: hands leadership to the standby if
+    /// this instance leads and has one, then deletes the pod for its
+    /// controller to recreate.
+    /// A pod nothing would recreate is refused, as is going ahead when a
+    /// configured standby doesn't take over.
+    pub async fn restart(&self, aggregator: &Aggregator, leader: &LeaderElector) -> Result<String, String> {
+        let Some(ref p) = self.pod else {
+            return Err("the console is not running as a pod in this cluster".to_string());
+        };
+        let (pod, node) = aggregator
+            .get_pod(&p.namespace, &p.name)
+            .await
+            .map_err(|e| e.to_string())?;
+        if stuck::owner(&pod).is_none() {
+            return Err(format!(
+                "{}/{} has no controller to recreate it; deleting it would leave the console down",
+                p.namespace, p.name
+            ));
+        }
+        let handed = if leader.has_standby() && leader.is_leader() {
+            format!("handed off to {}; ", leader.hand_off().await?)
+        } else {
+            String::new()
+        };
+        aggregator
+            .delete_pod(&p.namespace, &p.name)
+            .await
+            .map_err(|e| e.to_string())?;
+        Ok(format!(
+            "{}deleted from {} for its controller to recreate",
+            handed, node
+        ))
+    }
+}
@@ -0,0 +1,64 @@
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::config::{EventHookConfig, Role};
+use crate::crypto::{self, Sealer};
+
+/// User roles and event hook routes applied through the console (see
+/// desired.rs) on top of the config file's access.users and event_hooks.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct Settings {
+    #[serde(default)]
+    pub users: BTreeMap<String, Role>,
+    #[serde(default)]
+    pub event_hooks: Vec<EventHookConfig>,
+}
+
+/// Holds the applied Settings; the config file wins where both name the
+/// same user. Persisted under the data dir when there is one.
+pub struct SettingsStore {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    settings: Mutex<Settings>,
+}
+
+impl SettingsStore {
+    /// Fails when the store can't be opened rather than start empty and
+    /// overwrite it.
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let settings = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            settings: Mutex::new(settings),
+        })
+    }
+
+    pub fn get(&self) -> Settings {
+        self.settings.lock().unwrap().clone()
+    }
+
+    pub fn role(&self, user: &str) -> Option<Role> {
+        self.settings.lock().unwrap().users.get(user).copied()
+    }
+
+    pub fn event_hooks(&self) -> Vec<EventHookConfig> {
+        self.settings.lock().unwrap().event_hooks.clone()
+    }
+
+    pub fn set(&self, settings: Settings) {
+        let mut current = self.settings.lock().unwrap();
+        *current = settings;
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(&*current)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing console settings {}: {}", p.display(), e);
+            }
+        }
+    }
+}
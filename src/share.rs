@@ -0,0 +1,177 @@
+use base64::Engine;
+use base64::engine::general_purpose::URL_SAFE_NO_PAD;
+use chrono::{DateTime, Duration, Utc};
+use ring::hmac;
+use ring::rand::{SecureRandom, SystemRandom};
+use std::path::PathBuf;
+use tracing::{info, warn};
+
+use crate::crypto::Sealer;
+use crate::helpers::url_encode;
+
+/// A view a share link opens.
+#[derive(Debug, Clone, PartialEq)]
+pub enum ShareTarget {
+    /// The cluster summary and firing alerts.
+    Status,
+    Node(String),
+    /// The tail of a pod's log.
+    PodLogs {
+        namespace: String,
+        name: String,
+    },
+    /// Embeddable cluster health badge, for an iframe.
+    HealthWidget,
+    /// Embeddable strip of node status squares, for an iframe.
+    NodeStrip,
+    /// Cluster health as shields.io endpoint JSON.
+    Badge,
+}
+
+impl ShareTarget {
+    /// Parses the share form: a view ("status", "node", "logs", or the
+    /// widgets "health", "nodes" and "badge") and what it shows, a node
+    /// name or "namespace/pod".
+    pub fn parse(view: &str, target: &str) -> Result<Self, String> {
+        let target = target.trim();
+        match view {
+            "status" => Ok(Self::Status),
+            "node" if !target.is_empty() => Ok(Self::Node(target.to_string())),
+            "node" => Err("a node name is required".to_string()),
+            "logs" => match target.split_once('/') {
+                Some((ns, name)) if !ns.is_empty() && !name.is_empty() => Ok(Self::PodLogs {
+                    namespace: ns.to_string(),
+                    name: name.to_string(),
+                }),
+                _ => Err("a pod as namespace/name is required".to_string()),
+            },
+            "health" => Ok(Self::HealthWidget),
+            "nodes" => Ok(Self::NodeStrip),
+            "badge" => Ok(Self::Badge),
+            other => Err(format!("unknown view {:?}", other)),
+        }
+    }
+
+    /// Path the link opens, which is also what gets signed.
+    pub fn path(&self) -> String {
+        match self {
+            Self::Status => "/share/status".to_string(),
+            Self::Node(name) => format!("/share/nodes/{}", url_encode(name)),
+            Self::PodLogs { namespace, name } => {
+                format!("/share/logs/{}/{}", url_encode(namespace), url_encode(name))
+            }
+            Self::HealthWidget => "/share/widgets/health".to_string(),
+            Self::NodeStrip => "/share/widgets/nodes".to_string(),
+            Self::Badge => "/share/badge.json".to_string(),
+        }
+    }
+
+    pub fn describe(&self) -> String {
+        match self {
+            Self::Status => "the status dashboard".to_string(),
+            Self::Node(name) => format!("node {}", name),
+            Self::PodLogs { namespace, name } => format!("logs of pod {}/{}", namespace, name),
+            Self::HealthWidget => "the cluster health widget".to_string(),
+            Self::NodeStrip => "the node status strip".to_string(),
+            Self::Badge => "the cluster health badge".to_string(),
+        }
+    }
+
+    /// Widgets end up in READMEs and intranet pages, so their links may
+    /// be signed without an expiry.
+    pub fn is_widget(&self) -> bool {
+        matches!(self, Self::HealthWidget | Self::NodeStrip | Self::Badge)
+    }
+}
+
+/// Signs and checks share links: URLs that open one read-only view
+/// without an account until they expire. A link is the view's path plus
+/// ?expires=<unix seconds>&sig=<HMAC-SHA256 of both>, so nothing is stored
+/// per link; replacing the key revokes them all. Widget links may carry
+/// expires=0, which never expires.
+pub struct ShareLinks {
+    key: hmac::Key,
+    max_secs: i64,
+}
+
+impl ShareLinks {
+    /// Loads the signing key from `key_path`, generating it on first use.
+    /// Without a path the key lives in memory and links stop working when
+    /// the console restarts.
+    pub fn new(key_path: Option<PathBuf>, sealer: &Sealer, max_hours: i64) -> Result<Self, String> {
+        let material = match key_path {
+            Some(ref path) => match std::fs::read(path) {
+                Ok(b) => sealer
+                    .open_bytes(&b)
+                    .map_err(|e| format!("loading share link key {}: {}", path.display(), e))?,
+                Err(e) if e.kind() != std::io::ErrorKind::NotFound => {
+                    return Err(format!("reading share link key {}: {}", path.display(), e));
+                }
+                Err(_) => {
+                    let key = generate_key();
+                    if let Some(dir) = path.parent() {
+                        std::fs::create_dir_all(dir).map_err(|e| format!("creating {}: {}", dir.display(), e))?;
+                    }
+                    std::fs::write(path, sealer.seal_bytes(&key))
+                        .map_err(|e| format!("writing share link key {}: {}", path.display(), e))?;
+                    info!("generated share link key at {}", path.display());
+                    key
+                }
+            },
+            None => {
+                warn!("no data_dir; share links stop working when the console restarts");
+                generate_key()
+            }
+        };
+        Ok(Self {
+            key: hmac::Key::new(hmac::HMAC_SHA256, &material),
+            max_secs: max_hours.max(1) * 3600,
+        })
+    }
+
+    pub fn max_secs(&self) -> i64 {
+        self.max_secs
+    }
+
+    /// A link to `target` valid for `ttl_secs`, capped at the configured
+    /// maximum, as a path and query, and when it expires. Widgets may pass
+    /// None for a link that never does.
+    pub fn sign(&self, target: &ShareTarget, ttl_secs: Option<i64>) -> (String, Option<DateTime<Utc>>) {
+        let expires = match ttl_secs {
+            None if target.is_widget() => None,
+            ttl => Some(Utc::now() + Duration::seconds(ttl.unwrap_or(self.max_secs).clamp(60, self.max_secs))),
+        };
+        let stamp = expires.map(|t| t.timestamp()).unwrap_or(0);
+        let path = target.path();
+        let sig = hmac::sign(&self.key, message(&path, stamp).as_bytes());
+        let link = format!(
+            "{}?expires={}&sig={}",
+            path,
+            stamp,
+            URL_SAFE_NO_PAD.encode(sig.as_ref())
+        );
+        (link, expires)
+    }
+
+    /// Checks a link's expiry and signature.
+    pub fn verify(&self, target: &ShareTarget, expires: i64, sig: &str) -> Result<(), &'static str> {
+        let sig = URL_SAFE_NO_PAD.decode(sig).map_err(|_| "This link is malformed.")?;
+        hmac::verify(&self.key, message(&target.path(), expires).as_bytes(), &sig)
+            .map_err(|_| "This link is not valid.")?;
+        let never = expires == 0 && target.is_widget();
+        if !never && expires <= Utc::now().timestamp() {
+            return Err("This link has expired; ask for a new one.");
+        }
+        Ok(())
+    }
+}
+
+fn message(path: &str, expires: i64) -> String {
+    format!("{}\n{}", path, expires)
+}
+
+fn generate_key() -> Vec<u8> {
+    let mut key = vec![0u8; 32];
+    SystemRandom::new().fill(&mut key).expect("system RNG failed");
+    key
+}
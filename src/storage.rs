@@ -0,0 +1,253 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tracing::warn;
+
+use crate::clients::aggregator::Aggregator;
+use crate::crypto::{self, Sealer};
+use crate::diagnostics::valid_host;
+use crate::models::k8s::{CSIVolumeSource, NFSVolumeSource, Pod, Volume, VolumeMount};
+
+/// CSI driver SMB shares are mounted with.
+const SMB_DRIVER: &str = "smb.csi.k8s.io";
+
+#[derive(Debug, Clone, Copy, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ShareKind {
+    Nfs,
+    Smb,
+}
+
+impl ShareKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            ShareKind::Nfs => "NFS",
+            ShareKind::Smb => "SMB",
+        }
+    }
+}
+
+/// An NFS export or SMB share operators have registered for pods to mount.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct SharedExport {
+    pub name: String,
+    pub kind: ShareKind,
+    pub server: String,
+    /// The export path for NFS, the share name for SMB.
+    pub path: String,
+    #[serde(default)]
+    pub read_only: bool,
+    #[serde(default)]
+    pub description: String,
+    pub added_by: String,
+    pub added_at: DateTime<Utc>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ShareRequest {
+    pub name: String,
+    pub kind: ShareKind,
+    pub server: String,
+    pub path: String,
+    #[serde(default)]
+    pub read_only: bool,
+    #[serde(default)]
+    pub description: String,
+}
+
+/// The volume and mount a pod needs to use an export.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Stanza {
+    pub volume: Volume,
+    pub volume_mount: VolumeMount,
+}
+
+impl SharedExport {
+    /// server:/path for NFS, //server/share for SMB.
+    pub fn source(&self) -> String {
+        match self.kind {
+            ShareKind::Nfs => format!("{}:{}", self.server, self.path),
+            ShareKind::Smb => format!("//{}/{}", self.server, self.path.trim_start_matches('/')),
+        }
+    }
+
+    pub fn stanza(&self, mount_path: &str) -> Stanza {
+        let mut volume = Volume {
+            name: self.name.clone(),
+            ..Default::default()
+        };
+        match self.kind {
+            ShareKind::Nfs => {
+                volume.nfs = Some(NFSVolumeSource {
+                    server: self.server.clone(),
+                    path: self.path.clone(),
+                    read_only: self.read_only,
+                })
+            }
+            ShareKind::Smb => {
+                volume.csi = Some(CSIVolumeSource {
+                    driver: SMB_DRIVER.to_string(),
+                    read_only: self.read_only,
+                    volume_attributes: HashMap::from([("source".to_string(), self.source())]),
+                })
+            }
+        }
+        Stanza {
+            volume,
+            volume_mount: VolumeMount {
+                name: self.name.clone(),
+                mount_path: mount_path.to_string(),
+                read_only: self.read_only,
+            },
+        }
+    }
+
+    // Whether a pod volume mounts this export.
+    fn matches(&self, v: &Volume) -> bool {
+        match self.kind {
+            ShareKind::Nfs => v
+                .nfs
+                .as_ref()
+                .is_some_and(|n| n.server == self.server && n.path == self.path),
+            ShareKind::Smb => v.csi.as_ref().is_some_and(|c| {
+                c.driver == SMB_DRIVER && c.volume_attributes.get("source") == Some(&self.source())
+            }),
+        }
+    }
+}
+
+/// Shared NFS and SMB storage registered with the console, offered when
+/// composing pods. Persisted under the data dir when there is one.
+pub struct StorageCatalog {
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<CatalogState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct CatalogState {
+    exports: BTreeMap<String, SharedExport>,
+}
+
+impl StorageCatalog {
+    pub fn new(path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let state = crypto::load_sealed(path.as_deref(), &sealer)?;
+        Ok(Self {
+            path,
+            sealer,
+            state: Mutex::new(state),
+        })
+    }
+
+    pub fn list(&self) -> Vec<SharedExport> {
+        self.state.lock().unwrap().exports.values().cloned().collect()
+    }
+
+    pub fn get(&self, name: &str) -> Option<SharedExport> {
+        self.state.lock().unwrap().exports.get(name).cloned()
+    }
+
+    pub fn add(&self, req: ShareRequest, by: &str) -> Result<SharedExport, String> {
+        let valid_name = !req.name.is_empty()
+            && req
+                .name
+                .chars()
+                .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '-');
+        if !valid_name {
+            return Err("name must be lowercase letters, digits and dashes".to_string());
+        }
+        if !valid_host(&req.server) {
+            return Err(format!("server {:?} is not a hostname or address", req.server));
+        }
+        if req.path.is_empty() {
+            return Err("path is required".to_string());
+        }
+        if req.kind == ShareKind::Nfs && !req.path.starts_with('/') {
+            return Err("an NFS export path must be absolute".to_string());
+        }
+
+        let mut state = self.state.lock().unwrap();
+        if state.exports.contains_key(&req.name) {
+            return Err(format!("{} is already in the catalog", req.name));
+        }
+        let export = SharedExport {
+            name: req.name,
+            kind: req.kind,
+            server: req.server,
+            path: req.path,
+            read_only: req.read_only,
+            description: req.description,
+            added_by: by.to_string(),
+            added_at: Utc::now(),
+        };
+        state.exports.insert(export.name.clone(), export.clone());
+        self.save(&state);
+        Ok(export)
+    }
+
+    /// Takes an export out of the catalog; pods already mounting it keep it.
+    pub fn remove(&self, name: &str) -> bool {
+        let mut state = self.state.lock().unwrap();
+        if state.exports.remove(name).is_none() {
+            return false;
+        }
+        self.save(&state);
+        true
+    }
+
+    fn save(&self, state: &CatalogState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing storage catalog {}: {}", p.display(), e);
+            }
+        }
+    }
+}
+
+/// Checks that the node `pod` will land on can reach the server of every
+/// catalog export it mounts, so a pod isn't created only to hang mounting.
+/// The node pings the server from its side of the network; that is as
+/// close to a trial mount as nodes can check.
+pub async fn verify_mounts(aggregator: &Aggregator, catalog: &StorageCatalog, pod: &Pod) -> Result<(), String> {
+    let exports: Vec<SharedExport> = catalog
+        .list()
+        .into_iter()
+        .filter(|e| pod.spec.volumes.iter().any(|v| e.matches(v)))
+        .collect();
+    if exports.is_empty() {
+        return Ok(());
+    }
+
+    let node = if pod.spec.node_name.is_empty() {
+        aggregator.placement(pod, &[]).await?
+    } else {
+        pod.spec.node_name.clone()
+    };
+    let client = aggregator
+        .snapshot_clients()
+        .await
+        .into_iter()
+        .find(|c| c.name == node)
+        .ok_or_else(|| format!("node {:?} not found", node))?;
+    for e in &exports {
+        let reached = client.probe(&e.server, 2).await.is_ok_and(|r| r.received > 0);
+        if !reached {
+            return Err(format!(
+                "{} cannot reach {} server {} for {}",
+                node,
+                e.kind.as_str(),
+                e.server,
+                e.name
+            ));
+        }
+    }
+    Ok(())
+}
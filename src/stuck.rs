@@ -0,0 +1,279 @@
+use chrono::{DateTime, Utc};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+use crate::clients::aggregator::Aggregator;
+use crate::controllers::{daemonsets, devicesets, jobs};
+use crate::models::k8s::Pod;
+
+/// How long a pod may stay Pending before it counts as stuck.
+const PENDING_AFTER_SECS: i64 = 300;
+
+/// How long after deletion was requested a pod may still be terminating.
+const TERMINATING_AFTER_SECS: i64 = 120;
+
+/// A pod that isn't making progress, what probably holds it up and what
+/// the console can do about it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct StuckPod {
+    pub namespace: String,
+    pub name: String,
+    pub node: String,
+    /// Pending, Terminating or ImagePullBackOff.
+    pub problem: String,
+    /// When the pod got into this state, as far as the console can tell.
+    pub since: Option<String>,
+    /// Likely causes, most likely first.
+    pub causes: Vec<String>,
+    /// Fixes the console can apply; empty when it takes a person.
+    pub remediations: Vec<Remediation>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum Remediation {
+    /// Delete the pod; its deployment or job replaces it.
+    Delete,
+    /// Delete a standalone pod and create it again on another node.
+    Reschedule,
+}
+
+impl Remediation {
+    pub fn label(&self) -> &'static str {
+        match self {
+            Remediation::Delete => "Delete pod",
+            Remediation::Reschedule => "Move to another node",
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Remediation::Delete => "delete",
+            Remediation::Reschedule => "reschedule",
+        }
+    }
+}
+
+/// Finds stuck pods across the cluster: Pending too long, terminating long
+/// after deletion, or failing to pull an image.
+pub async fn analyze(aggregator: &Aggregator) -> Vec<StuckPod> {
+    let pods = aggregator.list_all_pods().await.unwrap_or_default();
+    let now = Utc::now();
+    let mut stuck = Vec::new();
+    for pod in &pods {
+        if let Some(s) = diagnose(aggregator, pod, now).await {
+            stuck.push(s);
+        }
+    }
+    stuck.sort_by(|a, b| (&a.namespace, &a.name).cmp(&(&b.namespace, &b.name)));
+    stuck
+}
+
+/// Applies a remediation, after checking it is still one the analysis
+/// offers for the pod. Returns what was done.
+pub async fn remediate(
+    aggregator: &Aggregator,
+    namespace: &str,
+    name: &str,
+    action: Remediation,
+) -> Result<String, String> {
+    let (pod, node) = aggregator
+        .get_pod(namespace, name)
+        .await
+        .map_err(|e| e.to_string())?;
+    let offered = diagnose(aggregator, &pod, Utc::now())
+        .await
+        .is_some_and(|s| s.remediations.contains(&action));
+    if !offered {
+        return Err(format!("{} is not a remediation for pod {}/{} now", action.as_str(), namespace, name));
+    }
+
+    match action {
+        Remediation::Delete => {
+            aggregator.delete_pod(namespace, name).await.map_err(|e| e.to_string())?;
+            Ok(format!("deleted from {}", node))
+        }
+        Remediation::Reschedule => {
+            let avoid = [node.clone()];
+            let replacement = replacement(&pod);
+            // Only give up the pod once another node will take it.
+            let target = aggregator.placement(&replacement, &avoid).await?;
+            if target == node {
+                return Err(format!("no node other than {} can run this pod", node));
+            }
+            aggregator.delete_pod(namespace, name).await.map_err(|e| e.to_string())?;
+            aggregator
+                .create_pod_avoiding(&replacement, &avoid)
+                .await
+                .map_err(|e| format!("deleted from {} but recreating failed: {}", node, e))?;
+            Ok(format!("moved from {} to {}", node, target))
+        }
+    }
+}
+
+/// Whether `pod` is stuck, and why.
+pub async fn diagnose(aggregator: &Aggregator, pod: &Pod, now: DateTime<Utc>) -> Option<StuckPod> {
+    let node = pod
+        .metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get("mkube.io/node"))
+        .cloned()
+        .unwrap_or_else(|| pod.spec.node_name.clone());
+    let clients = aggregator.snapshot_clients().await;
+    let client = clients.iter().find(|c| c.name == node);
+    let node_online = client.is_some_and(|c| c.is_healthy());
+    let owned = owner(pod).is_some();
+
+    let mut stuck = StuckPod {
+        namespace: pod.metadata.namespace.clone(),
+        name: pod.metadata.name.clone(),
+        node: node.clone(),
+        problem: String::new(),
+        since: None,
+        causes: Vec::new(),
+        remediations: Vec::new(),
+    };
+    let move_or_delete = if owned { Remediation::Delete } else { Remediation::Reschedule };
+
+    if let Some(deleted) = &pod.metadata.deletion_timestamp {
+        if older_than(deleted, now, TERMINATING_AFTER_SECS) != Some(true) {
+            return None;
+        }
+        stuck.problem = "Terminating".to_string();
+        stuck.since = Some(deleted.clone());
+        if node_online {
+            stuck
+                .causes
+                .push(format!("{} hasn't finished stopping the pod's containers", node));
+            stuck.remediations.push(Remediation::Delete);
+        } else {
+            stuck
+                .causes
+                .push(format!("node {} is offline; the pod goes once it is back", node));
+        }
+        return Some(stuck);
+    }
+
+    let pull_failures: Vec<&str> = pod
+        .status
+        .container_statuses
+        .iter()
+        .filter(|s| {
+            s.state
+                .waiting
+                .as_ref()
+                .is_some_and(|w| w.reason == "ImagePullBackOff" || w.reason == "ErrImagePull")
+        })
+        .map(|s| s.image.as_str())
+        .collect();
+    if !pull_failures.is_empty() {
+        stuck.problem = "ImagePullBackOff".to_string();
+        stuck.since = pod.status.start_time.clone();
+        let mut missing = false;
+        for image in &pull_failures {
+            match aggregator.image_in_registry(image).await {
+                Some(false) => {
+                    missing = true;
+                    stuck
+                        .causes
+                        .push(format!("image {} is not in the registry; push it or fix the tag", image));
+                }
+                Some(true) => stuck.causes.push(format!(
+                    "image {} is in the registry, so {} may not be able to reach it",
+                    image, node
+                )),
+                None => stuck.causes.push(format!(
+                    "{} couldn't pull {}; check the image name and that its registry is up",
+                    node, image
+                )),
+            }
+        }
+        if !missing && node_online {
+            stuck.remediations.push(move_or_delete);
+        }
+        return Some(stuck);
+    }
+
+    if pod.status.phase != "Pending" {
+        return None;
+    }
+    let since = pod
+        .status
+        .start_time
+        .clone()
+        .or_else(|| pod.metadata.creation_timestamp.clone());
+    if since
+        .as_deref()
+        .and_then(|t| older_than(t, now, PENDING_AFTER_SECS))
+        != Some(true)
+    {
+        return None;
+    }
+    stuck.problem = "Pending".to_string();
+    stuck.since = since;
+
+    match client {
+        Some(c) if !c.is_healthy() => stuck.causes.push(format!("node {} is offline", node)),
+        Some(c) if c.in_maintenance() => stuck.causes.push(format!("node {} is in maintenance", node)),
+        None => stuck.causes.push(format!("node {} is no longer part of the cluster", node)),
+        _ => {}
+    }
+    for c in &pod.spec.containers {
+        if aggregator.image_in_registry(&c.image).await == Some(false) {
+            stuck.causes.push(format!("image {} is not in the registry", c.image));
+        }
+    }
+    match aggregator.placement(&replacement(pod), &[node.clone()]).await {
+        Ok(other) if other != node => {
+            if stuck.causes.is_empty() {
+                stuck.causes.push(format!("{} hasn't started it; {} could run it instead", node, other));
+            }
+            if node_online {
+                stuck.remediations.push(move_or_delete);
+            }
+        }
+        Ok(_) => {
+            if stuck.causes.is_empty() {
+                stuck.causes.push(format!("{} hasn't started it, and no other node can run it", node));
+            }
+        }
+        Err(e) => stuck.causes.push(format!("no schedulable node: {}", e)),
+    }
+    Some(stuck)
+}
+
+/// Controller owning a pod, which recreates it when deleted.
+pub fn owner(pod: &Pod) -> Option<&str> {
+    let annotations = pod.metadata.annotations.as_ref()?;
+    annotations
+        .get("vkube.io/owner-deployment")
+        .map(String::as_str)
+        .or_else(|| jobs::owner(pod))
+        .or_else(|| devicesets::owner(pod))
+        .or_else(|| daemonsets::owner(pod))
+}
+
+/// The pod to create in place of `pod` elsewhere: same spec and labels,
+/// without a node or any state.
+pub fn replacement(pod: &Pod) -> Pod {
+    let mut annotations: HashMap<String, String> = pod.metadata.annotations.clone().unwrap_or_default();
+    annotations.remove("mkube.io/node");
+    let mut new = Pod {
+        type_meta: pod.type_meta.clone(),
+        spec: pod.spec.clone(),
+        ..Default::default()
+    };
+    new.metadata.name = pod.metadata.name.clone();
+    new.metadata.namespace = pod.metadata.namespace.clone();
+    new.metadata.labels = pod.metadata.labels.clone();
+    new.metadata.annotations = (!annotations.is_empty()).then_some(annotations);
+    new.spec.node_name.clear();
+    new
+}
+
+fn older_than(timestamp: &str, now: DateTime<Utc>, secs: i64) -> Option<bool> {
+    let t = DateTime::parse_from_rfc3339(timestamp).ok()?;
+    Some((now - t.to_utc()).num_seconds() >= secs)
+}
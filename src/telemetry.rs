@@ -0,0 +1,219 @@
+use chrono::{DateTime, Utc};
+use reqwest::Client;
+use ring::rand::{SecureRandom, SystemRandom};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+use std::path::PathBuf;
+use std::sync::{Arc, Mutex};
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::AppState;
+use crate::config::TelemetryConfig;
+use crate::crypto::{self, Sealer};
+
+/// One anonymized usage report. Counts and on/off flags only: no node,
+/// pod, namespace or user names, no addresses, and request routes as
+/// their patterns.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct TelemetryReport {
+    /// Random, generated once per data dir; tells reports of one cluster
+    /// apart from another's and nothing else.
+    pub installation_id: String,
+    pub version: String,
+    pub time: DateTime<Utc>,
+    pub nodes: usize,
+    pub healthy_nodes: usize,
+    /// Nodes per CPU architecture.
+    pub architectures: BTreeMap<String, usize>,
+    pub pods: usize,
+    pub namespaces: usize,
+    /// Optional features and whether they are configured.
+    pub features: BTreeMap<String, bool>,
+    /// Objects the console manages itself, per kind.
+    pub objects: BTreeMap<String, usize>,
+    /// Requests per route pattern since the console started.
+    pub requests: BTreeMap<String, u64>,
+    /// Error responses per status code since the console started.
+    pub errors: BTreeMap<String, u64>,
+    pub panics: u64,
+}
+
+/// The last report and what became of it, for the transparency page.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct SentReport {
+    pub sent_at: DateTime<Utc>,
+    /// HTTP status, or why the report didn't arrive.
+    pub outcome: String,
+    pub report: TelemetryReport,
+}
+
+/// Sends an anonymized usage report to the configured endpoint every
+/// interval_hours while this console leads. Only configured consoles
+/// report at all, and the last report is kept verbatim under the data
+/// dir so the telemetry page can show exactly what left.
+pub struct Telemetry {
+    cfg: TelemetryConfig,
+    http: Client,
+    path: Option<PathBuf>,
+    sealer: Arc<Sealer>,
+    state: Mutex<TelemetryState>,
+}
+
+#[derive(Default, Serialize, Deserialize)]
+struct TelemetryState {
+    installation_id: String,
+    last: Option<SentReport>,
+}
+
+impl Telemetry {
+    pub fn new(cfg: TelemetryConfig, path: Option<PathBuf>, sealer: Arc<Sealer>) -> Result<Self, String> {
+        let mut state: TelemetryState = crypto::load_sealed(path.as_deref(), &sealer)?;
+        if state.installation_id.is_empty() {
+            let mut id = [0u8; 16];
+            let _ = SystemRandom::new().fill(&mut id);
+            state.installation_id = id.iter().map(|b| format!("{:02x}", b)).collect();
+        }
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+        let telemetry = Self {
+            cfg,
+            http,
+            path,
+            sealer,
+            state: Mutex::new(state),
+        };
+        telemetry.save(&telemetry.state.lock().unwrap());
+        Ok(telemetry)
+    }
+
+    pub fn endpoint(&self) -> &str {
+        &self.cfg.endpoint
+    }
+
+    pub fn interval_hours(&self) -> u64 {
+        self.cfg.interval_hours
+    }
+
+    pub fn last(&self) -> Option<SentReport> {
+        self.state.lock().unwrap().last.clone()
+    }
+
+    pub async fn run(self: Arc<Self>, state: AppState, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(Duration::from_secs(self.cfg.interval_hours.max(1) * 3600));
+        // The first tick is immediate; wait a little so the report sees
+        // the nodes' first answers.
+        tokio::select! {
+            _ = time::sleep(Duration::from_secs(60)) => {}
+            _ = shutdown.changed() => return,
+        }
+
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    if state.leader.is_leader() {
+                        self.send(&state).await;
+                    }
+                }
+                _ = shutdown.changed() => {
+                    info!("telemetry shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    /// What the next report would contain, as of now.
+    pub async fn collect(&self, state: &AppState) -> TelemetryReport {
+        let installation_id = self.state.lock().unwrap().installation_id.clone();
+        let clients = state.aggregator.snapshot_clients().await;
+        let nodes = state.aggregator.list_all_nodes().await.unwrap_or_default();
+        let mut architectures: BTreeMap<String, usize> = BTreeMap::new();
+        for n in &nodes {
+            let arch = match n.status.node_info.architecture.as_str() {
+                "" => "unknown",
+                arch => arch,
+            };
+            *architectures.entry(arch.to_string()).or_default() += 1;
+        }
+        let pods = state.aggregator.list_all_pods().await.unwrap_or_default();
+        let namespaces: BTreeSet<&str> = pods.iter().map(|p| p.metadata.namespace.as_str()).collect();
+
+        let cfg = &state.config;
+        let features = BTreeMap::from([
+            ("ha".to_string(), cfg.ha.is_some()),
+            ("follow".to_string(), cfg.follow.is_some()),
+            ("tunnel".to_string(), cfg.tunnel.is_some()),
+            ("push".to_string(), cfg.push.is_some()),
+            ("access".to_string(), cfg.access.is_some()),
+            ("encryption".to_string(), state.sealer.is_enabled()),
+            ("errorReporting".to_string(), cfg.error_reporting.is_some()),
+            ("eventHooks".to_string(), !cfg.event_hooks.is_empty()),
+            ("customResources".to_string(), !cfg.custom_resources.is_empty()),
+            ("selfUpdate".to_string(), state.updater.is_enabled()),
+            ("selfHosted".to_string(), state.self_host.pod().is_some()),
+        ]);
+        let objects = BTreeMap::from([
+            ("jobs".to_string(), state.jobs.list(None).0.len()),
+            ("deviceSets".to_string(), state.device_sets.list(None).len()),
+            ("daemonSets".to_string(), state.daemon_sets.list(None).len()),
+            ("localVolumes".to_string(), state.local_volumes.list(None).len()),
+            ("sharedStorage".to_string(), state.storage.list().len()),
+            ("namespaces".to_string(), state.namespaces.list().len()),
+        ]);
+        let (requests, errors, panics) = state.http_metrics.usage();
+
+        TelemetryReport {
+            installation_id,
+            version: env!("CARGO_PKG_VERSION").to_string(),
+            time: Utc::now(),
+            nodes: clients.len(),
+            healthy_nodes: clients.iter().filter(|c| c.is_healthy()).count(),
+            architectures,
+            pods: pods.len(),
+            namespaces: namespaces.len(),
+            features,
+            objects,
+            requests,
+            errors,
+            panics,
+        }
+    }
+
+    async fn send(&self, state: &AppState) {
+        let report = self.collect(state).await;
+        let outcome = match self.http.post(&self.cfg.endpoint).json(&report).send().await {
+            Ok(r) if r.status().is_success() => r.status().to_string(),
+            Ok(r) => {
+                warn!("telemetry: {} answered {}", self.cfg.endpoint, r.status());
+                r.status().to_string()
+            }
+            Err(e) => {
+                warn!("telemetry: {}", e);
+                e.to_string()
+            }
+        };
+        let mut st = self.state.lock().unwrap();
+        st.last = Some(SentReport {
+            sent_at: Utc::now(),
+            outcome,
+            report,
+        });
+        self.save(&st);
+    }
+
+    fn save(&self, state: &TelemetryState) {
+        if let Some(ref p) = self.path {
+            let result = serde_json::to_vec_pretty(state)
+                .map_err(|e| e.to_string())
+                .and_then(|data| std::fs::write(p, self.sealer.seal_bytes(&data)).map_err(|e| e.to_string()));
+            if let Err(e) = result {
+                warn!("writing telemetry {}: {}", p.display(), e);
+            }
+        }
+    }
+}
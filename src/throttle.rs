@@ -0,0 +1,209 @@
+use chrono::{DateTime, Duration, Utc};
+use serde::Serialize;
+use std::collections::{HashMap, VecDeque};
+use std::sync::{Arc, Mutex};
+use tokio::time;
+use tracing::{info, warn};
+
+use crate::alerts::AlertManager;
+
+/// Failed attempts a client address gets before it is locked out.
+const FREE_ATTEMPTS: u32 = 5;
+
+/// The first lockout; each further failure doubles it, up to
+/// MAX_LOCKOUT_SECS.
+const BASE_LOCKOUT_SECS: i64 = 30;
+const MAX_LOCKOUT_SECS: i64 = 3600;
+
+/// Failures are forgotten after this long without another.
+const FORGET_SECS: i64 = 3600;
+
+/// Failed attempts kept for the admin view.
+const RECENT_LEN: usize = 200;
+
+/// A rejected sign-in: a node agent presenting a token the console doesn't
+/// accept.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct FailedAttempt {
+    pub at: DateTime<Utc>,
+    pub remote: String,
+    /// The node name the attempt claimed, if any; recorded, never trusted.
+    pub identity: String,
+    pub endpoint: String,
+    pub reason: String,
+}
+
+/// A client address currently locked out.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Lockout {
+    /// "ip <address>".
+    pub key: String,
+    pub failures: u32,
+    pub until: DateTime<Utc>,
+}
+
+/// Brute-force protection for the endpoints where the console checks a
+/// credential itself, node registration and push connections; people sign
+/// in at the authenticating proxy instead. Failures are counted per client
+/// address, and past FREE_ATTEMPTS lock the address out for exponentially
+/// longer; a successful sign-in takes one failure off rather than all, so
+/// a client holding one good token can't reset its count between guesses.
+/// A lockout raises an alert that resolves when it expires. Addresses are
+/// the connecting peer's, or behind config.trusted_proxies the client's
+/// (helpers::client_addr), never a header a client could rotate at will;
+/// node names an attempt claims are unauthenticated, so they are only
+/// recorded, or anyone could lock a real node out.
+pub struct LoginThrottle {
+    alerts: Arc<AlertManager>,
+    state: Mutex<ThrottleState>,
+}
+
+#[derive(Default)]
+struct ThrottleState {
+    counters: HashMap<String, Counter>,
+    recent: VecDeque<FailedAttempt>,
+}
+
+struct Counter {
+    failures: u32,
+    last: DateTime<Utc>,
+    locked_until: Option<DateTime<Utc>>,
+}
+
+impl LoginThrottle {
+    pub fn new(alerts: Arc<AlertManager>) -> Self {
+        Self {
+            alerts,
+            state: Mutex::new(ThrottleState::default()),
+        }
+    }
+
+    /// Seconds until the address may try again, if it is locked out.
+    pub fn locked(&self, remote: &str) -> Option<i64> {
+        let now = Utc::now();
+        let state = self.state.lock().unwrap();
+        let until = state.counters.get(&key(remote))?.locked_until?;
+        (until > now).then(|| (until - now).num_seconds().max(1))
+    }
+
+    pub fn failed(&self, remote: &str, identity: &str, endpoint: &str, reason: &str) {
+        let now = Utc::now();
+        let mut state = self.state.lock().unwrap();
+        state.recent.push_front(FailedAttempt {
+            at: now,
+            remote: remote.to_string(),
+            identity: identity.to_string(),
+            endpoint: endpoint.to_string(),
+            reason: reason.to_string(),
+        });
+        state.recent.truncate(RECENT_LEN);
+
+        let key = key(remote);
+        let counter = state.counters.entry(key.clone()).or_insert(Counter {
+            failures: 0,
+            last: now,
+            locked_until: None,
+        });
+        if (now - counter.last).num_seconds() >= FORGET_SECS {
+            counter.failures = 0;
+        }
+        counter.failures += 1;
+        counter.last = now;
+        if counter.failures <= FREE_ATTEMPTS {
+            return;
+        }
+        let doublings = (counter.failures - FREE_ATTEMPTS - 1).min(16);
+        let secs = (BASE_LOCKOUT_SECS << doublings).min(MAX_LOCKOUT_SECS);
+        counter.locked_until = Some(now + Duration::seconds(secs));
+        warn!(
+            "{} locked out for {}s after {} failed sign-ins",
+            key, secs, counter.failures
+        );
+        self.alerts.raise(
+            &alert_key(&key),
+            "warning",
+            &format!("Repeated failed sign-ins from {}", key),
+            &format!(
+                "{} failed attempts; the latest at {} ({}). Locked out for {}s.",
+                counter.failures, endpoint, reason, secs
+            ),
+        );
+    }
+
+    /// Takes one failure off the count of an address that just signed in.
+    /// A lockout still runs out on its own.
+    pub fn succeeded(&self, remote: &str) {
+        let mut state = self.state.lock().unwrap();
+        let key = key(remote);
+        let Some(counter) = state.counters.get_mut(&key) else {
+            return;
+        };
+        counter.failures = counter.failures.saturating_sub(1);
+        if counter.failures == 0 && counter.locked_until.is_none() {
+            state.counters.remove(&key);
+        }
+    }
+
+    /// Failed attempts, newest first.
+    pub fn recent(&self) -> Vec<FailedAttempt> {
+        self.state.lock().unwrap().recent.iter().cloned().collect()
+    }
+
+    pub fn lockouts(&self) -> Vec<Lockout> {
+        let now = Utc::now();
+        let state = self.state.lock().unwrap();
+        let mut lockouts: Vec<Lockout> = state
+            .counters
+            .iter()
+            .filter_map(|(key, c)| {
+                let until = c.locked_until.filter(|u| *u > now)?;
+                Some(Lockout {
+                    key: key.clone(),
+                    failures: c.failures,
+                    until,
+                })
+            })
+            .collect();
+        lockouts.sort_by(|a, b| b.until.cmp(&a.until));
+        lockouts
+    }
+
+    /// Resolves the alerts of expired lockouts and forgets old failures
+    /// until shutdown.
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut interval = time::interval(time::Duration::from_secs(30));
+        loop {
+            tokio::select! {
+                _ = interval.tick() => self.sweep(),
+                _ = shutdown.changed() => {
+                    info!("login throttle shutting down");
+                    return;
+                }
+            }
+        }
+    }
+
+    fn sweep(&self) {
+        let now = Utc::now();
+        let mut state = self.state.lock().unwrap();
+        for (key, c) in state.counters.iter_mut() {
+            if c.locked_until.is_some_and(|u| u <= now) {
+                c.locked_until = None;
+                self.alerts.resolve(&alert_key(key));
+            }
+        }
+        state
+            .counters
+            .retain(|_, c| c.locked_until.is_some() || (now - c.last).num_seconds() < FORGET_SECS);
+    }
+}
+
+fn key(remote: &str) -> String {
+    format!("ip {}", remote)
+}
+
+fn alert_key(key: &str) -> String {
+    format!("signin/{}", key.replace(' ', "/"))
+}
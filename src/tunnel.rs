@@ -0,0 +1,136 @@
+use tokio::process::{Child, Command};
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::config::{SshTunnelConfig, TailscaleConfig, TunnelConfig};
+
+/// Keeps the console published through a remote access tunnel, to the
+/// tailnet or a jump host's loopback but never the internet. The tunnel
+/// itself is the system `tailscale` or `ssh` binary; this supervises it and
+/// restarts it with backoff if it exits.
+pub struct TunnelSupervisor {
+    cfg: TunnelConfig,
+    listen_port: u16,
+}
+
+impl TunnelSupervisor {
+    pub fn new(cfg: TunnelConfig, listen_port: u16) -> Self {
+        Self { cfg, listen_port }
+    }
+
+    pub async fn run(&self, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let mut backoff = Duration::from_secs(2);
+
+        loop {
+            let mut child = match self.spawn() {
+                Ok(c) => c,
+                Err(e) => {
+                    warn!("starting {} tunnel: {}", self.kind(), e);
+                    tokio::select! {
+                        _ = time::sleep(backoff) => {}
+                        _ = shutdown.changed() => return,
+                    }
+                    backoff = (backoff * 2).min(Duration::from_secs(60));
+                    continue;
+                }
+            };
+            info!("{} tunnel started", self.kind());
+            let started = time::Instant::now();
+
+            tokio::select! {
+                status = child.wait() => {
+                    match status {
+                        Ok(s) => warn!("{} tunnel exited: {}", self.kind(), s),
+                        Err(e) => warn!("{} tunnel wait: {}", self.kind(), e),
+                    }
+                }
+                _ = shutdown.changed() => {
+                    let _ = child.kill().await;
+                    self.teardown().await;
+                    info!("{} tunnel shutting down", self.kind());
+                    return;
+                }
+            }
+
+            // A tunnel that stayed up for a while gets a fresh backoff
+            if started.elapsed() > Duration::from_secs(60) {
+                backoff = Duration::from_secs(2);
+            }
+            tokio::select! {
+                _ = time::sleep(backoff) => {}
+                _ = shutdown.changed() => return,
+            }
+            backoff = (backoff * 2).min(Duration::from_secs(60));
+        }
+    }
+
+    fn kind(&self) -> &'static str {
+        match self.cfg {
+            TunnelConfig::Tailscale(_) => "tailscale",
+            TunnelConfig::Ssh(_) => "ssh",
+        }
+    }
+
+    fn spawn(&self) -> std::io::Result<Child> {
+        match self.cfg {
+            TunnelConfig::Tailscale(ref ts) => self.tailscale_command(ts).spawn(),
+            TunnelConfig::Ssh(ref ssh) => self.ssh_command(ssh).spawn(),
+        }
+    }
+
+    // `tailscale serve` in the foreground, proxying HTTPS on the tailnet
+    // name to the local listener. Funnel is refused by Config::load.
+    fn tailscale_command(&self, ts: &TailscaleConfig) -> Command {
+        let mut cmd = Command::new(&ts.binary);
+        if let Some(ref socket) = ts.socket {
+            cmd.arg("--socket").arg(socket);
+        }
+        cmd.arg("serve")
+            .arg(format!("--https={}", ts.https_port))
+            .arg(format!("http://127.0.0.1:{}", self.listen_port));
+        cmd.kill_on_drop(true);
+        cmd
+    }
+
+    // Reverse tunnel: remote_bind:remote_port on the jump host forwards to
+    // the console. Keepalives make ssh exit (and get restarted) on a dead link.
+    fn ssh_command(&self, ssh: &SshTunnelConfig) -> Command {
+        let mut cmd = Command::new(&ssh.binary);
+        cmd.arg("-N")
+            .arg("-o").arg("ExitOnForwardFailure=yes")
+            .arg("-o").arg("ServerAliveInterval=15")
+            .arg("-o").arg("ServerAliveCountMax=3")
+            .arg("-o").arg("BatchMode=yes")
+            .arg("-p").arg(ssh.port.to_string());
+        if let Some(ref key) = ssh.identity_file {
+            cmd.arg("-i").arg(key);
+        }
+        if let Some(ref known_hosts) = ssh.known_hosts_file {
+            cmd.arg("-o").arg(format!("UserKnownHostsFile={}", known_hosts));
+        }
+        cmd.arg("-R").arg(format!(
+            "{}:{}:127.0.0.1:{}",
+            ssh.remote_bind, ssh.remote_port, self.listen_port
+        ));
+        cmd.arg(format!("{}@{}", ssh.user, ssh.host));
+        cmd.kill_on_drop(true);
+        cmd
+    }
+
+    // `tailscale serve` config persists in tailscaled; turn it off on exit so
+    // a stopped console isn't left published.
+    async fn teardown(&self) {
+        if let TunnelConfig::Tailscale(ref ts) = self.cfg {
+            let mut cmd = Command::new(&ts.binary);
+            if let Some(ref socket) = ts.socket {
+                cmd.arg("--socket").arg(socket);
+            }
+            cmd.arg("serve")
+                .arg(format!("--https={}", ts.https_port))
+                .arg("off");
+            if let Err(e) = cmd.status().await {
+                warn!("tailscale teardown: {}", e);
+            }
+        }
+    }
+}
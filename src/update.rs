@@ -0,0 +1,408 @@
+use base64::Engine;
+use base64::engine::general_purpose::STANDARD;
+use chrono::{DateTime, Utc};
+use reqwest::Client;
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::os::unix::fs::PermissionsExt;
+use std::os::unix::process::CommandExt;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, Mutex};
+use tokio::sync::Notify;
+use tokio::time::{self, Duration};
+use tracing::{info, warn};
+
+use crate::config::UpdateConfig;
+use crate::selfhost::SelfHost;
+
+/// Version of the running binary.
+pub const VERSION: &str = env!("CARGO_PKG_VERSION");
+/// Commit and UTC time it was built from, set by build.rs.
+pub const GIT_COMMIT: &str = env!("MKUBE_GIT_COMMIT");
+pub const BUILD_DATE: &str = env!("MKUBE_BUILD_DATE");
+
+/// How often to look for a new release in the background.
+const CHECK_INTERVAL_SECS: u64 = 6 * 3600;
+
+/// How long a new version must stay up before it is kept; a crash before
+/// then rolls back to the previous binary on the next start.
+const CONFIRM_SECS: u64 = 60;
+
+/// Starts of a new version allowed before it is rolled back.
+const MAX_BOOTS: u32 = 1;
+
+/// What the release endpoint serves.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Release {
+    pub version: String,
+    #[serde(default)]
+    pub notes: String,
+    /// Page describing what changed.
+    #[serde(default)]
+    pub changelog_url: String,
+    /// Container image of the release, for consoles running as a pod.
+    #[serde(default)]
+    pub image: String,
+    /// Keyed by "<os>-<arch>", e.g. linux-aarch64.
+    #[serde(default)]
+    pub binaries: HashMap<String, ReleaseBinary>,
+}
+
+#[derive(Debug, Clone, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ReleaseBinary {
+    pub url: String,
+    /// Hex SHA-256 of the binary.
+    pub sha256: String,
+    /// Base64 Ed25519 signature of the binary, checked against
+    /// update.public_key.
+    #[serde(default)]
+    pub signature: String,
+}
+
+/// The result of the latest check, as the API and admin page show it.
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct UpdateCheck {
+    pub current: String,
+    pub latest: String,
+    pub available: bool,
+    pub notes: String,
+    pub changelog_url: String,
+    pub checked_at: DateTime<Utc>,
+}
+
+/// Left next to the binary across an update, so the next start knows
+/// whether it is a new version on trial.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct UpdateMarker {
+    pub from: String,
+    pub to: String,
+    pub at: DateTime<Utc>,
+    /// Starts of the new version so far.
+    pub boots: u32,
+    /// pending, confirmed or rolled-back.
+    pub state: String,
+}
+
+/// Checks a release endpoint for new console versions and installs them:
+/// downloads the binary for this platform, verifies its checksum and
+/// signature and that it runs, swaps it in keeping the old one beside it,
+/// and restarts into it with the same arguments, so config and state
+/// carry over. A new version that doesn't stay up is rolled back at the
+/// next start; see check_boot.
+pub struct Updater {
+    cfg: Option<UpdateConfig>,
+    http: Client,
+    self_host: Arc<SelfHost>,
+    exe: Option<PathBuf>,
+    last_check: Mutex<Option<UpdateCheck>>,
+    restarting: AtomicBool,
+    restart: Notify,
+}
+
+impl Updater {
+    pub fn new(cfg: Option<UpdateConfig>, self_host: Arc<SelfHost>) -> Self {
+        let http = Client::builder()
+            .timeout(Duration::from_secs(300))
+            .build()
+            .expect("failed to create HTTP client");
+        Self {
+            cfg,
+            http,
+            self_host,
+            exe: std::env::current_exe().ok(),
+            last_check: Mutex::new(None),
+            restarting: AtomicBool::new(false),
+            restart: Notify::new(),
+        }
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        self.cfg.is_some()
+    }
+
+    /// Whether releases can be installed, not just checked for: that
+    /// takes a key to verify their signatures with.
+    pub fn can_install(&self) -> bool {
+        self.cfg.as_ref().is_some_and(|c| c.public_key.is_some())
+    }
+
+    pub fn last_check(&self) -> Option<UpdateCheck> {
+        self.last_check.lock().unwrap().clone()
+    }
+
+    /// The marker of the latest update, if one was installed.
+    pub fn marker(&self) -> Option<UpdateMarker> {
+        self.exe.as_deref().and_then(|exe| read_marker(&marker_path(exe)))
+    }
+
+    pub async fn check(&self) -> Result<UpdateCheck, String> {
+        let release = self.fetch().await?;
+        let check = UpdateCheck {
+            current: VERSION.to_string(),
+            available: newer(&release.version, VERSION),
+            latest: release.version,
+            notes: release.notes,
+            changelog_url: release.changelog_url,
+            checked_at: Utc::now(),
+        };
+        *self.last_check.lock().unwrap() = Some(check.clone());
+        Ok(check)
+    }
+
+    /// Installs the latest release and asks main to restart into it once
+    /// the server has shut down. Returns what was installed.
+    pub async fn apply(&self) -> Result<String, String> {
+        let exe = self.exe.clone().ok_or("can't tell where the console binary is")?;
+        // The checksum comes from the same document as the binary's URL,
+        // so only a signature says who built it
+        if !self.can_install() {
+            return Err("installing updates needs update.public_key; without it, only check for them".to_string());
+        }
+        let release = self.fetch().await?;
+        if !newer(&release.version, VERSION) {
+            return Err(format!("{} is the latest version", VERSION));
+        }
+        if self.self_host.pod().is_some() {
+            let image = match release.image.as_str() {
+                "" => String::new(),
+                image => format!(" ({})", image),
+            };
+            return Err(format!(
+                "the console runs as a pod; roll out {}{} to its controller instead",
+                release.version, image
+            ));
+        }
+        if self.restarting.load(Ordering::SeqCst) {
+            return Err("an update is already waiting for the restart".to_string());
+        }
+        let platform = format!("{}-{}", std::env::consts::OS, std::env::consts::ARCH);
+        let binary = release
+            .binaries
+            .get(&platform)
+            .ok_or_else(|| format!("release {} has no binary for {}", release.version, platform))?;
+
+        let data = self
+            .http
+            .get(&binary.url)
+            .send()
+            .await
+            .and_then(|r| r.error_for_status())
+            .map_err(|e| format!("downloading {}: {}", binary.url, e))?
+            .bytes()
+            .await
+            .map_err(|e| format!("downloading {}: {}", binary.url, e))?;
+        self.verify(&data, binary)?;
+
+        let staged = exe.with_extension("new");
+        std::fs::write(&staged, &data).map_err(|e| format!("writing {}: {}", staged.display(), e))?;
+        std::fs::set_permissions(&staged, std::fs::Permissions::from_mode(0o755))
+            .map_err(|e| format!("making {} executable: {}", staged.display(), e))?;
+        // A binary that can't even report its version isn't swapped in.
+        let reported = tokio::process::Command::new(&staged)
+            .arg("version")
+            .output()
+            .await
+            .map(|o| String::from_utf8_lossy(&o.stdout).trim().to_string())
+            .unwrap_or_default();
+        if reported != release.version {
+            let _ = std::fs::remove_file(&staged);
+            return Err(format!(
+                "the downloaded binary reports version {:?}, not {}",
+                reported, release.version
+            ));
+        }
+
+        std::fs::rename(&exe, exe.with_extension("prev")).map_err(|e| format!("keeping the old binary: {}", e))?;
+        if let Err(e) = std::fs::rename(&staged, &exe) {
+            let _ = std::fs::rename(exe.with_extension("prev"), &exe);
+            return Err(format!("installing the new binary: {}", e));
+        }
+        let marker = UpdateMarker {
+            from: VERSION.to_string(),
+            to: release.version.clone(),
+            at: Utc::now(),
+            boots: 0,
+            state: "pending".to_string(),
+        };
+        write_marker(&marker_path(&exe), &marker);
+
+        info!("installed console {}; restarting into it", release.version);
+        self.restarting.store(true, Ordering::SeqCst);
+        self.restart.notify_one();
+        Ok(format!(
+            "installed {}, replacing {}; restarting",
+            release.version, VERSION
+        ))
+    }
+
+    /// Resolves when an installed update is waiting for a restart.
+    pub async fn restart_requested(&self) {
+        self.restart.notified().await
+    }
+
+    /// Executes the installed update once the server has shut down. The
+    /// path is the one captured at startup, as the running binary's own
+    /// now names the previous version.
+    pub fn restart_if_pending(&self) {
+        if !self.restarting.load(Ordering::SeqCst) {
+            return;
+        }
+        if let Some(ref exe) = self.exe {
+            exec(exe);
+        }
+    }
+
+    /// Checks for a new release every CHECK_INTERVAL_SECS, so the UI can
+    /// say one is out without an admin asking.
+    pub async fn run(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        if !self.is_enabled() {
+            return;
+        }
+        let mut interval = time::interval(Duration::from_secs(CHECK_INTERVAL_SECS));
+        loop {
+            tokio::select! {
+                _ = interval.tick() => {
+                    match self.check().await {
+                        Ok(c) if c.available => info!("console {} is available; running {}", c.latest, VERSION),
+                        Ok(_) => {}
+                        Err(e) => warn!("checking for console updates: {}", e),
+                    }
+                }
+                _ = shutdown.changed() => return,
+            }
+        }
+    }
+
+    /// Keeps a new version once it has stayed up for CONFIRM_SECS.
+    pub async fn confirm(self: Arc<Self>, mut shutdown: tokio::sync::watch::Receiver<()>) {
+        let Some(path) = self.exe.as_deref().map(marker_path) else {
+            return;
+        };
+        if !read_marker(&path).is_some_and(|m| m.state == "pending") {
+            return;
+        }
+        tokio::select! {
+            _ = time::sleep(Duration::from_secs(CONFIRM_SECS)) => {}
+            _ = shutdown.changed() => return,
+        }
+        if let Some(mut marker) = read_marker(&path).filter(|m| m.state == "pending") {
+            marker.state = "confirmed".to_string();
+            write_marker(&path, &marker);
+            info!("console {} confirmed; keeping it", marker.to);
+        }
+    }
+
+    async fn fetch(&self) -> Result<Release, String> {
+        let cfg = self.cfg.as_ref().ok_or("no update section is configured")?;
+        self.http
+            .get(&cfg.release_url)
+            .send()
+            .await
+            .and_then(|r| r.error_for_status())
+            .map_err(|e| format!("fetching {}: {}", cfg.release_url, e))?
+            .json()
+            .await
+            .map_err(|e| format!("reading {}: {}", cfg.release_url, e))
+    }
+
+    fn verify(&self, data: &[u8], binary: &ReleaseBinary) -> Result<(), String> {
+        let digest = ring::digest::digest(&ring::digest::SHA256, data);
+        let sha256: String = digest.as_ref().iter().map(|b| format!("{:02x}", b)).collect();
+        if !sha256.eq_ignore_ascii_case(&binary.sha256) {
+            return Err(format!(
+                "checksum mismatch: got {}, release says {}",
+                sha256, binary.sha256
+            ));
+        }
+        let Some(key) = self.cfg.as_ref().and_then(|c| c.public_key.as_deref()) else {
+            return Err("update.public_key is not set".to_string());
+        };
+        let key = STANDARD
+            .decode(key)
+            .map_err(|e| format!("update.public_key is not valid base64: {}", e))?;
+        let signature = STANDARD
+            .decode(&binary.signature)
+            .map_err(|_| "the release binary is not signed".to_string())?;
+        ring::signature::UnparsedPublicKey::new(&ring::signature::ED25519, key)
+            .verify(data, &signature)
+            .map_err(|_| "the release binary's signature doesn't verify".to_string())
+    }
+}
+
+/// Run first thing at startup. Counts starts of a freshly installed
+/// version and, once it has had MAX_BOOTS without being confirmed, puts
+/// the previous binary back and executes it instead.
+pub fn check_boot() {
+    let Ok(exe) = std::env::current_exe() else {
+        return;
+    };
+    let path = marker_path(&exe);
+    let Some(mut marker) = read_marker(&path).filter(|m| m.state == "pending") else {
+        return;
+    };
+    if marker.boots < MAX_BOOTS {
+        marker.boots += 1;
+        write_marker(&path, &marker);
+        return;
+    }
+
+    let prev = exe.with_extension("prev");
+    if !prev.exists() {
+        eprintln!(
+            "console {} failed to start and there is no previous binary to roll back to",
+            marker.to
+        );
+        return;
+    }
+    eprintln!("console {} failed to start; rolling back to {}", marker.to, marker.from);
+    let _ = std::fs::rename(&exe, exe.with_extension("failed"));
+    if let Err(e) = std::fs::rename(&prev, &exe) {
+        eprintln!("restoring {}: {}", prev.display(), e);
+        return;
+    }
+    marker.state = "rolled-back".to_string();
+    write_marker(&path, &marker);
+    exec(&exe);
+}
+
+// Replaces this process with `exe`, keeping its arguments.
+fn exec(exe: &Path) {
+    let err = std::process::Command::new(exe).args(std::env::args_os().skip(1)).exec();
+    eprintln!("restarting into {}: {}", exe.display(), err);
+    std::process::exit(1);
+}
+
+fn marker_path(exe: &Path) -> PathBuf {
+    exe.with_extension("update.json")
+}
+
+fn read_marker(path: &Path) -> Option<UpdateMarker> {
+    let data = std::fs::read(path).ok()?;
+    serde_json::from_slice(&data).ok()
+}
+
+fn write_marker(path: &Path, marker: &UpdateMarker) {
+    let result = serde_json::to_vec_pretty(marker)
+        .map_err(|e| e.to_string())
+        .and_then(|data| std::fs::write(path, data).map_err(|e| e.to_string()));
+    if let Err(e) = result {
+        warn!("writing update marker {}: {}", path.display(), e);
+    }
+}
+
+// Whether dotted version `a` is newer than `b`; non-numeric parts count
+// as 0.
+fn newer(a: &str, b: &str) -> bool {
+    let parse = |v: &str| -> Vec<u64> {
+        v.trim_start_matches('v')
+            .split(['.', '-', '+'])
+            .map(|p| p.parse().unwrap_or(0))
+            .collect()
+    };
+    parse(a) > parse(b)
+}
@@ -0,0 +1,74 @@
+use std::collections::HashMap;
+
+use crate::models::k8s::{Node, Pod};
+
+/// Node annotation listing, comma-separated, the directories whose data
+/// lives only on that node.
+pub const DATA_PATHS_ANNOTATION: &str = "mkube.io/data-paths";
+
+/// Pod annotation tying the pod to nodes holding its hostPath data, so
+/// failover and rescheduling don't start a database away from its files.
+pub const DATA_LOCAL_ANNOTATION: &str = "mkube.io/data-local";
+
+/// The data paths a node declares in its annotations.
+pub fn node_data_paths(annotations: &HashMap<String, String>) -> Vec<String> {
+    annotations
+        .get(DATA_PATHS_ANNOTATION)
+        .map(|v| {
+            v.split(',')
+                .map(|p| p.trim().trim_end_matches('/'))
+                .filter(|p| !p.is_empty())
+                .map(String::from)
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// The hostPath volumes `pod` must find on its node: all of them when it
+/// is annotated mkube.io/data-local, otherwise none.
+pub fn pod_data_paths(pod: &Pod) -> Vec<&str> {
+    let local = pod
+        .metadata
+        .annotations
+        .as_ref()
+        .and_then(|a| a.get(DATA_LOCAL_ANNOTATION))
+        .is_some_and(|v| v == "true");
+    if !local {
+        return Vec::new();
+    }
+    pod.spec
+        .volumes
+        .iter()
+        .filter_map(|v| v.host_path.as_ref())
+        .map(|h| h.path.as_str())
+        .filter(|p| !p.is_empty())
+        .collect()
+}
+
+/// Why `node` can't run `pod`: a hostPath it needs isn't under any of the
+/// node's data paths. A node that couldn't be fetched can't be checked, so
+/// it is ruled out too.
+pub fn data_rejection(pod: &Pod, node: Option<&Node>) -> Option<String> {
+    let wanted = pod_data_paths(pod);
+    if wanted.is_empty() {
+        return None;
+    }
+    let have = node
+        .and_then(|n| n.metadata.annotations.as_ref())
+        .map(node_data_paths)
+        .unwrap_or_default();
+    let missing: Vec<&str> = wanted
+        .into_iter()
+        .filter(|path| !have.iter().any(|dir| covers(dir, path)))
+        .collect();
+    if missing.is_empty() {
+        return None;
+    }
+    Some(format!("data for {} is not on this node", missing.join(", ")))
+}
+
+// Whether `path` is `dir` or inside it.
+fn covers(dir: &str, path: &str) -> bool {
+    let path = path.trim_end_matches('/');
+    path == dir || path.strip_prefix(dir).is_some_and(|rest| rest.starts_with('/'))
+}
@@ -0,0 +1,101 @@
+use chrono::{DateTime, Utc};
+use reqwest::Client;
+use std::collections::HashMap;
+use std::sync::Mutex;
+use std::time::Duration;
+use tokio::net::UdpSocket;
+use tracing::info;
+
+use crate::config::NodeDef;
+
+const DEFAULT_BROADCAST: &str = "255.255.255.255:9";
+
+/// Powers on offline nodes, by Wake-on-LAN magic packet and/or a smart-plug
+/// HTTP endpoint, and remembers pending wakes until the node rejoins.
+pub struct WakeService {
+    nodes: HashMap<String, NodeDef>,
+    http: Client,
+    pending: Mutex<HashMap<String, DateTime<Utc>>>,
+}
+
+impl WakeService {
+    pub fn new(nodes: &[NodeDef]) -> Self {
+        let http = Client::builder()
+            .timeout(Duration::from_secs(10))
+            .build()
+            .expect("failed to create HTTP client");
+
+        Self {
+            nodes: nodes
+                .iter()
+                .filter(|n| n.mac.is_some() || n.power_on_url.is_some())
+                .map(|n| (n.name.clone(), n.clone()))
+                .collect(),
+            http,
+            pending: Mutex::new(HashMap::new()),
+        }
+    }
+
+    pub fn can_wake(&self, node: &str) -> bool {
+        self.nodes.contains_key(node)
+    }
+
+    /// Sends every configured wake mechanism for the node. Returns a short
+    /// description of what was sent.
+    pub async fn wake(&self, node: &str) -> Result<String, Box<dyn std::error::Error + Send + Sync>> {
+        let def = self
+            .nodes
+            .get(node)
+            .ok_or_else(|| format!("node {:?} has no mac or power_on_url configured", node))?;
+        let mut sent = Vec::new();
+
+        if let Some(ref url) = def.power_on_url {
+            let resp = self.http.post(url).send().await?;
+            if !resp.status().is_success() {
+                return Err(format!("power-on {} returned {}", url, resp.status()).into());
+            }
+            sent.push("power-on request".to_string());
+        }
+
+        if let Some(ref mac) = def.mac {
+            let packet = magic_packet(mac)?;
+            let target = def.wol_broadcast.as_deref().unwrap_or(DEFAULT_BROADCAST);
+            let socket = UdpSocket::bind("0.0.0.0:0").await?;
+            socket.set_broadcast(true)?;
+            socket.send_to(&packet, target).await?;
+            sent.push(format!("magic packet to {} via {}", mac, target));
+        }
+
+        info!("waking node {}: {}", node, sent.join(", "));
+        self.pending.lock().unwrap().insert(node.to_string(), Utc::now());
+        Ok(sent.join(", "))
+    }
+
+    /// When a wake was sent for a node that hasn't rejoined yet.
+    pub fn pending_since(&self, node: &str) -> Option<DateTime<Utc>> {
+        self.pending.lock().unwrap().get(node).copied()
+    }
+
+    /// Forgets a pending wake once the node is reachable again.
+    pub fn clear(&self, node: &str) {
+        self.pending.lock().unwrap().remove(node);
+    }
+}
+
+// Six 0xff bytes followed by the MAC repeated 16 times.
+fn magic_packet(mac: &str) -> Result<Vec<u8>, String> {
+    let bytes: Vec<u8> = mac
+        .split(|c| c == ':' || c == '-')
+        .map(|b| u8::from_str_radix(b, 16))
+        .collect::<Result<_, _>>()
+        .map_err(|_| format!("invalid MAC address {:?}", mac))?;
+    if bytes.len() != 6 {
+        return Err(format!("invalid MAC address {:?}", mac));
+    }
+
+    let mut packet = vec![0xffu8; 6];
+    for _ in 0..16 {
+        packet.extend_from_slice(&bytes);
+    }
+    Ok(packet)
+}
@@ -0,0 +1,159 @@
+// Small UI helpers shared by every page.
+
+// Label each table cell with its column header so narrow screens can render
+// rows as cards (see .data-table rules in the mobile media query).
+function labelTableCells(root) {
+  root.querySelectorAll('table.data-table').forEach((table) => {
+    const headers = Array.from(table.querySelectorAll('thead th')).map((th) => th.textContent.trim());
+    if (!headers.length) return;
+    table.querySelectorAll('tbody tr').forEach((tr) => {
+      Array.from(tr.children).forEach((td, i) => {
+        if (!td.hasAttribute('data-label') && headers[i]) td.setAttribute('data-label', headers[i]);
+      });
+    });
+  });
+}
+
+// Parse a value a template embedded with the data_json filter, e.g.
+// data-pod="{{ pod_key|data_json }}". Templates pass values to scripts this
+// way rather than interpolating them into inline JS.
+function readJSON(el, name) {
+  const raw = el.dataset[name];
+  return raw === undefined ? null : JSON.parse(raw);
+}
+
+// Kiosk mode (/ui/kiosk): show one panel at a time for data-rotate
+// seconds each, and reload for fresh data after each full cycle. A click
+// asks for full screen, which browsers only grant on a user gesture.
+function startKiosk(body) {
+  const panels = Array.from(body.querySelectorAll('.kiosk-panel'));
+  const dots = Array.from(body.querySelectorAll('.kiosk-dot'));
+  const clock = body.querySelector('.kiosk-clock');
+  let current = 0;
+  const show = () => {
+    panels.forEach((p, i) => p.classList.toggle('active', i === current));
+    dots.forEach((d, i) => d.classList.toggle('active', i === current));
+  };
+  const tick = () => { if (clock) clock.textContent = new Date().toLocaleTimeString(); };
+
+  show();
+  tick();
+  setInterval(tick, 1000);
+  setInterval(() => {
+    current += 1;
+    if (current >= panels.length) {
+      location.reload();
+      return;
+    }
+    show();
+  }, (readJSON(body, 'rotate') || 20) * 1000);
+  body.addEventListener('click', () => {
+    if (!document.fullscreenElement) document.documentElement.requestFullscreen().catch(() => {});
+  });
+}
+
+// Claim page: read a node's claim QR code with the camera (browsers with
+// BarcodeDetector only) and submit it as the form's payload.
+async function scanClaimCode(button) {
+  const form = button.closest('form');
+  const video = form.querySelector('.claim-video');
+  const stream = await navigator.mediaDevices.getUserMedia({ video: { facingMode: 'environment' } });
+  video.srcObject = stream;
+  video.hidden = false;
+  await video.play();
+  const detector = new BarcodeDetector({ formats: ['qr_code'] });
+  try {
+    for (;;) {
+      const hit = (await detector.detect(video)).find((c) => c.rawValue.startsWith('mkube-claim://'));
+      if (hit) {
+        form.elements.payload.value = hit.rawValue;
+        form.requestSubmit();
+        return;
+      }
+      await new Promise((resolve) => setTimeout(resolve, 300));
+    }
+  } finally {
+    stream.getTracks().forEach((t) => t.stop());
+    video.hidden = true;
+  }
+}
+
+// Create Pod dialog: add a storage catalog entry's volume to the pod JSON
+// being composed, and mount it in every container.
+async function mountSharedStorage(podJSON, share, mountPath) {
+  const resp = await fetch(
+    `/api/console/v1alpha1/storage/${encodeURIComponent(share)}/stanza?mountPath=${encodeURIComponent(mountPath)}`,
+  );
+  if (!resp.ok) throw new Error(await resp.text());
+  const { volume, volumeMount } = await resp.json();
+  const pod = podJSON.trim() ? JSON.parse(podJSON) : { apiVersion: 'v1', kind: 'Pod', metadata: {}, spec: {} };
+  pod.spec = pod.spec || {};
+  pod.spec.volumes = (pod.spec.volumes || []).filter((v) => v.name !== volume.name).concat(volume);
+  pod.spec.containers = pod.spec.containers || [];
+  pod.spec.containers.forEach((c) => {
+    c.volumeMounts = (c.volumeMounts || []).filter((m) => m.name !== volumeMount.name).concat(volumeMount);
+  });
+  return JSON.stringify(pod, null, 2);
+}
+
+// Create Pod dialog: lint the manifest being composed without creating it.
+async function lintManifest(podJSON) {
+  const resp = await fetch('/api/console/v1alpha1/lint', { method: 'POST', body: podJSON });
+  if (!resp.ok) throw new Error(await resp.text());
+  return (await resp.json()).findings;
+}
+
+document.addEventListener('DOMContentLoaded', () => labelTableCells(document));
+document.addEventListener('DOMContentLoaded', () => {
+  if (document.body.classList.contains('kiosk')) startKiosk(document.body);
+});
+document.addEventListener('htmx:afterSettle', () => labelTableCells(document));
+
+if ('serviceWorker' in navigator) {
+  window.addEventListener('load', () => {
+    navigator.serviceWorker.register('/ui/sw.js', { scope: '/ui/' }).catch(() => {});
+  });
+}
+
+// Web Push opt-in for alert notifications. Used by the button on the alerts page.
+function b64urlToBytes(s) {
+  const pad = '='.repeat((4 - (s.length % 4)) % 4);
+  const raw = atob((s + pad).replace(/-/g, '+').replace(/_/g, '/'));
+  return Uint8Array.from(raw, (c) => c.charCodeAt(0));
+}
+
+async function enablePushNotifications() {
+  if (!('serviceWorker' in navigator) || !('PushManager' in window)) {
+    throw new Error('Push notifications are not supported in this browser');
+  }
+  if ((await Notification.requestPermission()) !== 'granted') {
+    throw new Error('Notification permission was denied');
+  }
+  const keyResp = await fetch('/api/console/v1alpha1/push/vapid-public-key');
+  if (!keyResp.ok) throw new Error(await keyResp.text());
+  const { publicKey } = await keyResp.json();
+
+  const reg = await navigator.serviceWorker.ready;
+  const sub = await reg.pushManager.subscribe({
+    userVisibleOnly: true,
+    applicationServerKey: b64urlToBytes(publicKey),
+  });
+  const resp = await fetch('/api/console/v1alpha1/push/subscriptions', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(sub),
+  });
+  if (!resp.ok) throw new Error(await resp.text());
+}
+
+async function disablePushNotifications() {
+  const reg = await navigator.serviceWorker.ready;
+  const sub = await reg.pushManager.getSubscription();
+  if (!sub) return;
+  await fetch('/api/console/v1alpha1/push/subscriptions', {
+    method: 'DELETE',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ endpoint: sub.endpoint }),
+  });
+  await sub.unsubscribe();
+}
@@ -0,0 +1,108 @@
+// mkube console service worker: caches the app shell so the PWA opens
+// instantly, and falls back to the last copy of a page when offline.
+const SHELL_CACHE = 'mkube-shell-v1';
+const PAGE_CACHE = 'mkube-pages-v2';
+
+// Pages never kept for offline use: they show secret values. Responses
+// marked no-store aren't kept either.
+const UNCACHED_PAGES = ['/ui/secrets/'];
+
+const SHELL = [
+  '/ui/static/css/fonts.css',
+  '/ui/static/css/style.css',
+  '/ui/static/js/htmx.min.js',
+  '/ui/static/js/sse.js',
+  '/ui/static/js/alpine.min.js',
+  '/ui/static/js/app.js',
+  '/ui/static/icons/icon.svg',
+  '/ui/static/manifest.webmanifest',
+];
+
+self.addEventListener('install', (event) => {
+  event.waitUntil(
+    caches.open(SHELL_CACHE).then((cache) => cache.addAll(SHELL)).then(() => self.skipWaiting())
+  );
+});
+
+self.addEventListener('activate', (event) => {
+  event.waitUntil(
+    caches.keys().then((keys) =>
+      Promise.all(
+        keys.filter((k) => k !== SHELL_CACHE && k !== PAGE_CACHE).map((k) => caches.delete(k))
+      )
+    ).then(() => self.clients.claim())
+  );
+});
+
+self.addEventListener('fetch', (event) => {
+  const req = event.request;
+  if (req.method !== 'GET') return;
+
+  const url = new URL(req.url);
+  if (url.origin !== self.location.origin) return;
+
+  // Static assets: cache first
+  if (url.pathname.startsWith('/ui/static/')) {
+    event.respondWith(
+      caches.match(req).then((hit) => hit || fetch(req).then((resp) => {
+        const copy = resp.clone();
+        caches.open(SHELL_CACHE).then((cache) => cache.put(req, copy));
+        return resp;
+      }))
+    );
+    return;
+  }
+
+  // Full page navigations: network first, last good copy when offline.
+  // htmx partial requests and event streams always go to the network.
+  if (req.mode === 'navigate' && !req.headers.get('HX-Request')) {
+    event.respondWith(
+      fetch(req).then((resp) => {
+        const noStore = (resp.headers.get('Cache-Control') || '').includes('no-store');
+        if (resp.ok && !noStore && !UNCACHED_PAGES.some((p) => url.pathname.startsWith(p))) {
+          const copy = resp.clone();
+          caches.open(PAGE_CACHE).then((cache) => cache.put(req, copy));
+        }
+        return resp;
+      }).catch(() =>
+        caches.match(req).then((hit) => hit || new Response(
+          '<!DOCTYPE html><meta name="viewport" content="width=device-width, initial-scale=1.0">' +
+          '<body style="background:#09090b;color:#f0f0f5;font-family:sans-serif;padding:32px">' +
+          '<h2>Offline</h2><p>The console is unreachable and this page has not been cached yet.</p></body>',
+          { status: 503, headers: { 'Content-Type': 'text/html; charset=utf-8' } }
+        ))
+      )
+    );
+  }
+});
+
+// Web Push: show firing alerts as notifications, open the alert on click.
+self.addEventListener('push', (event) => {
+  let data = {};
+  try {
+    data = event.data ? event.data.json() : {};
+  } catch (e) {
+    data = { title: 'mkube alert', body: event.data ? event.data.text() : '' };
+  }
+  event.waitUntil(
+    self.registration.showNotification(data.title || 'mkube alert', {
+      body: data.body || '',
+      tag: data.tag,
+      icon: '/ui/static/icons/icon.svg',
+      data: { url: data.url || '/ui/alerts' },
+    })
+  );
+});
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close();
+  const url = (event.notification.data && event.notification.data.url) || '/ui/alerts';
+  event.waitUntil(
+    self.clients.matchAll({ type: 'window', includeUncontrolled: true }).then((wins) => {
+      for (const w of wins) {
+        if (new URL(w.url).pathname === url && 'focus' in w) return w.focus();
+      }
+      return self.clients.openWindow(url);
+    })
+  );
+});